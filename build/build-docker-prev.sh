@@ -0,0 +1,24 @@
+#!/bin/bash
+# Build a Docker image of the previous Cockroach release, used to run
+# mixed-version clusters with run/local-cluster.sh. The image is built
+# from the pinned revision below rather than from the working tree, so
+# the nodes running it behave exactly as the previous release does on
+# the wire. Requires the cockroachdb/cockroach-devbase image built by
+# build-docker-dev.sh.
+set -e
+cd "$(dirname $0)/.."
+
+# Verify docker installation.
+source "./build/verify-docker.sh"
+
+# The last revision of the previous release. Update this when cutting
+# a release. Override with PREV_REVISION to test against another one.
+PREV_REVISION="${PREV_REVISION:-4a8fef8a733895236c2f3f5bc696b8b23f5da367}"
+
+SRC_DIR=$(mktemp -d "/tmp/cockroach-prev.XXXXXXXX" || exit 1)
+trap "rm -rf $SRC_DIR" EXIT
+
+echo "Building Docker Cockroach image of $PREV_REVISION..."
+git clone -q . "$SRC_DIR"
+(cd "$SRC_DIR" && git checkout -q "$PREV_REVISION")
+docker build -t "cockroachdb/cockroach-prev" "$SRC_DIR"
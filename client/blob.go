@@ -0,0 +1,182 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package client
+
+import (
+	"hash/crc32"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// blobManifestTag is the Value.Tag which marks a value as a
+// BlobManifest.
+const blobManifestTag = "blob-manifest"
+
+// blobChunkSize is the size of the chunks a blob is split into, unless
+// the client's maximum value size is smaller.
+const blobChunkSize = 1 << 20 // 1M
+
+// blobChunkInfix separates a blob's key from the encoded index of each
+// of its chunks. Keys beginning with a blob's key followed by the
+// infix are reserved for the blob's chunks.
+var blobChunkInfix = proto.Key("\x00blob-chunk-")
+
+// maxChunkedBlobKeyLength is the maximum length of the key of a blob
+// split into chunks, such that the keys of its chunks don't exceed
+// proto.KeyMaxLength.
+var maxChunkedBlobKeyLength = proto.KeyMaxLength - len(blobChunkKey(nil, 0))
+
+// blobChunkPrefix returns the prefix of the keys of the chunks of the
+// blob stored at key.
+func blobChunkPrefix(key proto.Key) proto.Key {
+	return proto.MakeKey(key, blobChunkInfix)
+}
+
+// blobChunkKey returns the key of the i-th chunk of the blob stored at
+// key.
+func blobChunkKey(key proto.Key, i int32) proto.Key {
+	return proto.MakeKey(blobChunkPrefix(key), encoding.EncodeUint32(nil, uint32(i)))
+}
+
+// PutBlob sets the given key to data. Values larger than the client's
+// maximum value size are split into chunks stored under keys derived
+// from key, with a manifest describing them stored at key, so that
+// they need not fit in a single raft command. Smaller values are
+// stored at key as is. Values which need chunks may only be stored at
+// keys of up to maxChunkedBlobKeyLength bytes. The chunks and manifest
+// are written in a transaction, unless the client is already
+// transactional.
+func (kv *KV) PutBlob(key proto.Key, data []byte) error {
+	return kv.runBlobTransaction("put blob", func(txn *KV) error {
+		// Remove the chunks of any blob previously stored at key.
+		if err := txn.deleteBlobChunks(key); err != nil {
+			return err
+		}
+		maxSize := kv.Limits.MaxValueSize
+		if maxSize <= 0 || int64(len(data)) <= maxSize {
+			return txn.putInternal(key, proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}})
+		}
+		if len(key) > maxChunkedBlobKeyLength {
+			return util.Errorf("key %q is too long to store a blob of %d bytes in chunks (max key length %d)",
+				key, len(data), maxChunkedBlobKeyLength)
+		}
+		chunkSize := int64(blobChunkSize)
+		if maxSize < chunkSize {
+			chunkSize = maxSize
+		}
+		manifest := &proto.BlobManifest{
+			TotalBytes: int64(len(data)),
+			Checksum:   crc32.ChecksumIEEE(data),
+		}
+		for rest := data; len(rest) > 0; manifest.NumChunks++ {
+			n := chunkSize
+			if int64(len(rest)) < n {
+				n = int64(len(rest))
+			}
+			if err := txn.putInternal(blobChunkKey(key, manifest.NumChunks), proto.Value{ValuePayload: proto.ValuePayload{Bytes: rest[:n]}}); err != nil {
+				return err
+			}
+			rest = rest[n:]
+		}
+		manifestData, err := gogoproto.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		return txn.putInternal(key, proto.Value{ValuePayload: proto.ValuePayload{Bytes: manifestData}, Tag: gogoproto.String(blobManifestTag)})
+	})
+}
+
+// GetBlob fetches the value at the specified key, which may have been
+// written by PutBlob, reassembling it from its chunks if necessary.
+// The first result parameter is "ok": true if a value was found for
+// the requested key; false otherwise.
+func (kv *KV) GetBlob(key proto.Key) (bool, []byte, error) {
+	var ok bool
+	var data []byte
+	err := kv.runBlobTransaction("get blob", func(txn *KV) error {
+		ok, data = false, nil
+		value, err := txn.getInternal(key)
+		if err != nil || value == nil {
+			return err
+		}
+		if t := value.GetValueType(); t == proto.INT || t == proto.FLOAT {
+			return util.Errorf("unexpected non-byte value at key %q: %+v", key, value)
+		}
+		if value.GetTag() != blobManifestTag {
+			ok, data = true, value.Bytes
+			return nil
+		}
+		manifest := &proto.BlobManifest{}
+		if err := gogoproto.Unmarshal(value.Bytes, manifest); err != nil {
+			return err
+		}
+		buf := make([]byte, 0, manifest.TotalBytes)
+		for i := int32(0); i < manifest.NumChunks; i++ {
+			chunkKey := blobChunkKey(key, i)
+			chunk, err := txn.getInternal(chunkKey)
+			if err != nil {
+				return err
+			}
+			if chunk == nil {
+				return util.Errorf("blob at key %q is missing chunk %q", key, chunkKey)
+			}
+			buf = append(buf, chunk.Bytes...)
+		}
+		if int64(len(buf)) != manifest.TotalBytes || crc32.ChecksumIEEE(buf) != manifest.Checksum {
+			return util.Errorf("blob at key %q does not match its manifest %+v", key, manifest)
+		}
+		ok, data = true, buf
+		return nil
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	return ok, data, nil
+}
+
+// DeleteBlob deletes the value at the specified key along with its
+// chunks, if it was split into chunks by PutBlob.
+func (kv *KV) DeleteBlob(key proto.Key) error {
+	return kv.runBlobTransaction("delete blob", func(txn *KV) error {
+		if err := txn.deleteBlobChunks(key); err != nil {
+			return err
+		}
+		return txn.Call(proto.Delete, proto.DeleteArgs(key), &proto.DeleteResponse{})
+	})
+}
+
+// deleteBlobChunks deletes all chunks of the blob stored at key. Keys
+// too long for chunked blobs have none.
+func (kv *KV) deleteBlobChunks(key proto.Key) error {
+	if len(key) > maxChunkedBlobKeyLength {
+		return nil
+	}
+	prefix := blobChunkPrefix(key)
+	return kv.Call(proto.DeleteRange, proto.DeleteRangeArgs(prefix, prefix.PrefixEnd()),
+		&proto.DeleteRangeResponse{})
+}
+
+// runBlobTransaction runs fn in a new transaction, or directly if kv
+// is already transactional.
+func (kv *KV) runBlobTransaction(name string, fn func(txn *KV) error) error {
+	if _, ok := kv.sender.(*txnSender); ok {
+		return fn(kv)
+	}
+	return kv.RunTransaction(&TransactionOptions{Name: name}, fn)
+}
@@ -29,6 +29,10 @@ type Call struct {
 	Method string         // The name of the database command (see api.proto)
 	Args   proto.Request  // The argument to the command
 	Reply  proto.Response // The reply from the command
+
+	// ambiguousRetries counts resends of the call by the sender after
+	// failures which left the outcome unknown.
+	ambiguousRetries int64
 }
 
 // now returns clock.Now() if clock is not nil; otherwise uses the
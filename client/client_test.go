@@ -22,10 +22,14 @@ package client_test
 
 import (
 	"bytes"
+	"encoding/gob"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"net/http"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -295,31 +299,338 @@ func TestKVClientGetAndPutProto(t *testing.T) {
 	}
 }
 
-// TestKVClientGetAndPutGob verifies gets and puts of Go objects using the
-// KV client's convenience methods.
-func TestKVClientGetAndPutGob(t *testing.T) {
+// TestKVClientDeleteRange verifies that DeleteRange deletes the keys
+// in its span, including across a range boundary.
+func TestKVClientDeleteRange(t *testing.T) {
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	kvClient := createTestClient(s.HTTPAddr)
+	kvClient.User = storage.UserRoot
+
+	if err := kvClient.AdminSplit(proto.Key("m")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c", "x", "y", "z"} {
+		if err := kvClient.Call(proto.Put, proto.PutArgs(proto.Key(key), []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	num, err := kvClient.DeleteRange(proto.Key("b"), proto.Key("y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 3 {
+		t.Errorf("expected 3 keys deleted; got %d", num)
+	}
+
+	reply := &proto.ScanResponse{}
+	if err := kvClient.Call(proto.Scan, proto.ScanArgs(proto.Key("a"), proto.Key("zz"), 0), reply); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, kv := range reply.Rows {
+		keys = append(keys, string(kv.Key))
+	}
+	if expKeys := []string{"a", "y", "z"}; !reflect.DeepEqual(keys, expKeys) {
+		t.Errorf("expected keys %q to remain; got %q", expKeys, keys)
+	}
+}
+
+// TestKVClientScanPagination verifies that scans spanning two ranges
+// honor max results across the range boundary and that the returned
+// resume key can be used to page through all keys.
+func TestKVClientScanPagination(t *testing.T) {
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	kvClient := createTestClient(s.HTTPAddr)
+	kvClient.User = storage.UserRoot
+
+	if err := kvClient.AdminSplit(proto.Key("m")); err != nil {
+		t.Fatal(err)
+	}
+	expKeys := []string{"a", "b", "c", "x", "y", "z"}
+	for _, key := range expKeys {
+		if err := kvClient.Call(proto.Put, proto.PutArgs(proto.Key(key), []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, maxResults := range []int64{1, 2, 4, 6} {
+		var keys []string
+		key := proto.Key("a")
+		for pages := 0; len(key) > 0; pages++ {
+			if pages > len(expKeys) {
+				t.Fatalf("max results %d: scan did not terminate", maxResults)
+			}
+			rows, resumeKey, err := kvClient.Scan(key, proto.Key("zz"), maxResults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(rows)) > maxResults {
+				t.Errorf("max results %d: got %d rows", maxResults, len(rows))
+			}
+			for _, kv := range rows {
+				keys = append(keys, string(kv.Key))
+			}
+			key = resumeKey
+		}
+		if !reflect.DeepEqual(keys, expKeys) {
+			t.Errorf("max results %d: expected keys %q; got %q", maxResults, expKeys, keys)
+		}
+	}
+}
+
+// TestKVClientScanAndDelPrefix verifies that ScanPrefix visits exactly
+// the keys under a prefix, in batches spanning a range boundary, and
+// that DelPrefix deletes only those keys.
+func TestKVClientScanAndDelPrefix(t *testing.T) {
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	kvClient := createTestClient(s.HTTPAddr)
+	kvClient.User = storage.UserRoot
+
+	if err := kvClient.AdminSplit(proto.Key("p/m")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"p", "p/a", "p/b", "p/x", "p/y", "p/\xff", "p0", "q"} {
+		if err := kvClient.Call(proto.Put, proto.PutArgs(proto.Key(key), []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expKeys := []string{"p/a", "p/b", "p/x", "p/y", "p/\xff"}
+	var keys []string
+	if err := kvClient.ScanPrefix(proto.Key("p/"), 2, func(kv proto.KeyValue) error {
+		keys = append(keys, string(kv.Key))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, expKeys) {
+		t.Errorf("expected keys %q; got %q", expKeys, keys)
+	}
+
+	if n, err := kvClient.DelPrefix(proto.Key("p/")); err != nil || n != int64(len(expKeys)) {
+		t.Fatalf("expected %d keys deleted; got %d, %v", len(expKeys), n, err)
+	}
+	rows, _, err := kvClient.Scan(proto.Key("p"), proto.Key("r"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = nil
+	for _, kv := range rows {
+		keys = append(keys, string(kv.Key))
+	}
+	if expKeys := []string{"p", "p0", "q"}; !reflect.DeepEqual(keys, expKeys) {
+		t.Errorf("expected keys %q to remain; got %q", expKeys, keys)
+	}
+}
+
+// TestKVClientReverseScan verifies that a reverse scan spanning two
+// ranges returns keys in descending order and honors max results
+// across the range boundary.
+func TestKVClientReverseScan(t *testing.T) {
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	kvClient := createTestClient(s.HTTPAddr)
+	kvClient.User = storage.UserRoot
+
+	if err := kvClient.AdminSplit(proto.Key("m")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c", "x", "y", "z"} {
+		if err := kvClient.Call(proto.Put, proto.PutArgs(proto.Key(key), []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testCases := []struct {
+		key, endKey string
+		maxResults  int64
+		expKeys     []string
+	}{
+		{"a", "zz", 0, []string{"z", "y", "x", "c", "b", "a"}},
+		{"b", "z", 0, []string{"y", "x", "c", "b"}},
+		{"a", "zz", 4, []string{"z", "y", "x", "c"}},
+		{"a", "zz", 2, []string{"z", "y"}},
+		{"a", "m", 0, []string{"c", "b", "a"}},
+	}
+	for i, test := range testCases {
+		rows, err := kvClient.ReverseScan(proto.Key(test.key), proto.Key(test.endKey), test.maxResults)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		var keys []string
+		for _, kv := range rows {
+			keys = append(keys, string(kv.Key))
+		}
+		if !reflect.DeepEqual(keys, test.expKeys) {
+			t.Errorf("%d: expected keys %q; got %q", i, test.expKeys, keys)
+		}
+	}
+}
+
+// TestKVClientIncrement verifies that Increment returns the new value
+// and fails cleanly on keys holding byte values.
+func TestKVClientIncrement(t *testing.T) {
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	kvClient := createTestClient(s.HTTPAddr)
+	kvClient.User = storage.UserRoot
+
+	key := proto.Key("counter")
+	for i, inc := range []int64{5, -2, 0, 10} {
+		expValue := []int64{5, 3, 3, 13}[i]
+		if value, err := kvClient.Increment(key, inc); err != nil || value != expValue {
+			t.Errorf("%d: expected %d after incrementing by %d; got %d, %v", i, expValue, inc, value, err)
+		}
+	}
+
+	bytesKey := proto.Key("bytes")
+	if err := kvClient.Call(proto.Put, proto.PutArgs(bytesKey, []byte("value")), &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvClient.Increment(bytesKey, 1); err == nil {
+		t.Error("expected error incrementing a byte value")
+	}
+}
+
+// TestKVClientGetAndPutFloat verifies gets and puts of float values
+// using the KV client's convenience methods.
+func TestKVClientGetAndPutFloat(t *testing.T) {
 	s := server.StartTestServer(t)
 	defer s.Stop()
 	kvClient := createTestClient(s.HTTPAddr)
 	kvClient.User = storage.UserRoot
 
-	obj := map[string]map[int]int{
-		"foo": map[int]int{
-			1: 100,
-			2: 101,
+	key := proto.Key("float")
+	if ok, _, _, err := kvClient.GetFloat(key); ok || err != nil {
+		t.Fatalf("expected missing key; ok? %t: %s", ok, err)
+	}
+	for _, f := range []float64{0, 3.14159, -1e-300, math.MaxFloat64} {
+		if err := kvClient.PutFloat(key, f); err != nil {
+			t.Fatalf("unable to put float: %s", err)
+		}
+		ok, readF, ts, err := kvClient.GetFloat(key)
+		if !ok || err != nil {
+			t.Fatalf("unable to get float ok? %t: %s", ok, err)
+		}
+		if ts.Equal(proto.ZeroTimestamp) {
+			t.Error("expected non-zero timestamp")
+		}
+		if readF != f {
+			t.Errorf("expected %g; got %g", f, readF)
+		}
+	}
+	// Float values can't be read as protos, nor byte values as floats.
+	if _, _, err := kvClient.GetProto(key, &proto.ZoneConfig{}); err == nil {
+		t.Error("expected error reading float value as proto")
+	}
+	if err := kvClient.PutProto(key, &proto.ZoneConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := kvClient.GetFloat(key); err == nil {
+		t.Error("expected error reading byte value as float")
+	}
+}
+
+// TestKVClientGetAndPutBlob verifies that values larger than the
+// client's maximum value size are stored in chunks and reassembled,
+// and that overwrites and deletes remove the chunks of earlier blobs.
+func TestKVClientGetAndPutBlob(t *testing.T) {
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	kvClient := createTestClient(s.HTTPAddr)
+	kvClient.User = storage.UserRoot
+	kvClient.Limits.MaxValueSize = 1000
+
+	key := proto.Key("blob")
+	blob := func(size int) []byte {
+		return []byte(util.RandString(rand.New(rand.NewSource(int64(size))), size))
+	}
+	// A plain put of a value over the limit fails.
+	if err := kvClient.Call(proto.Put, proto.PutArgs(key, blob(4500)), &proto.PutResponse{}); err == nil {
+		t.Fatal("expected put of oversized value to fail")
+	}
+	for i, size := range []int{4500, 2500, 10, 1000, 1001, 0} {
+		data := blob(size)
+		if err := kvClient.PutBlob(key, data); err != nil {
+			t.Fatalf("%d: unable to put blob: %s", i, err)
+		}
+		ok, read, err := kvClient.GetBlob(key)
+		if !ok || err != nil {
+			t.Fatalf("%d: unable to get blob ok? %t: %s", i, ok, err)
+		}
+		if !bytes.Equal(data, read) {
+			t.Errorf("%d: expected blob of %d bytes; got %d bytes", i, len(data), len(read))
+		}
+	}
+
+	// Values which fit in a single put are stored as is.
+	gr := &proto.GetResponse{}
+	if err := kvClient.PutBlob(key, blob(10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvClient.Call(proto.Get, proto.GetArgs(key), gr); err != nil {
+		t.Fatal(err)
+	}
+	if gr.Value == nil || !bytes.Equal(gr.Value.Bytes, blob(10)) {
+		t.Errorf("expected small blob to be stored inline; got %+v", gr.Value)
+	}
+
+	// Deleting a chunked blob removes its manifest and its chunks.
+	if err := kvClient.PutBlob(key, blob(4500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvClient.DeleteBlob(key); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _, err := kvClient.GetBlob(key); ok || err != nil {
+		t.Errorf("expected blob to be deleted; ok? %t: %s", ok, err)
+	}
+	sr := &proto.ScanResponse{}
+	if err := kvClient.Call(proto.Scan, proto.ScanArgs(key, key.PrefixEnd(), 0), sr); err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Rows) != 0 {
+		t.Errorf("expected no chunks to remain after delete; got %d rows", len(sr.Rows))
+	}
+
+	// A key too long for chunk keys may hold small blobs only.
+	longKey := proto.Key(strings.Repeat("k", proto.KeyMaxLength))
+	if err := kvClient.PutBlob(longKey, blob(10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvClient.PutBlob(longKey, blob(4500)); err == nil {
+		t.Error("expected chunked blob at overlong key to fail")
+	}
+	if err := kvClient.DeleteBlob(longKey); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestKVClientGetAndPutJSON verifies gets and puts of Go objects
+// using the KV client's convenience methods.
+func TestKVClientGetAndPutJSON(t *testing.T) {
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	kvClient := createTestClient(s.HTTPAddr)
+	kvClient.User = storage.UserRoot
+
+	obj := map[string]map[string]int{
+		"foo": map[string]int{
+			"1": 100,
+			"2": 101,
 		},
-		"bar": map[int]int{
-			3: 200,
-			4: 201,
+		"bar": map[string]int{
+			"3": 200,
+			"4": 201,
 		},
 	}
 
-	key := proto.Key("gob-key")
+	key := proto.Key("json-key")
 	if err := kvClient.PutI(key, obj); err != nil {
 		t.Fatalf("unable to put object: %s", err)
 	}
 
-	readObj := map[string]map[int]int{}
+	readObj := map[string]map[string]int{}
 	ok, ts, err := kvClient.GetI(key, &readObj)
 	if !ok || err != nil {
 		t.Fatalf("unable to get object ok? %t: %s", ok, err)
@@ -332,6 +643,82 @@ func TestKVClientGetAndPutGob(t *testing.T) {
 	}
 }
 
+// TestKVClientGetAndPutEvolution verifies that objects written with
+// PutI can be read back with GetI into an evolved version of their
+// type, and vice versa: fields common to both are preserved, fields
+// missing from the value are left zero and unknown fields are
+// ignored.
+func TestKVClientGetAndPutEvolution(t *testing.T) {
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	kvClient := createTestClient(s.HTTPAddr)
+	kvClient.User = storage.UserRoot
+
+	type objV1 struct {
+		Name  string
+		Count int
+	}
+	type objV2 struct {
+		Name  string
+		Count int64
+		Tags  []string
+	}
+
+	key := proto.Key("v1-key")
+	if err := kvClient.PutI(key, objV1{Name: "a", Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	v2 := objV2{}
+	if ok, _, err := kvClient.GetI(key, &v2); !ok || err != nil {
+		t.Fatalf("unable to get object ok? %t: %s", ok, err)
+	}
+	if expV2 := (objV2{Name: "a", Count: 1}); !reflect.DeepEqual(v2, expV2) {
+		t.Errorf("expected %+v; got %+v", expV2, v2)
+	}
+
+	key = proto.Key("v2-key")
+	if err := kvClient.PutI(key, objV2{Name: "b", Count: 2, Tags: []string{"x"}}); err != nil {
+		t.Fatal(err)
+	}
+	v1 := objV1{}
+	if ok, _, err := kvClient.GetI(key, &v1); !ok || err != nil {
+		t.Fatalf("unable to get object ok? %t: %s", ok, err)
+	}
+	if expV1 := (objV1{Name: "b", Count: 2}); v1 != expV1 {
+		t.Errorf("expected %+v; got %+v", expV1, v1)
+	}
+}
+
+// TestKVClientGetGob verifies that GetI reads values gob-encoded by
+// earlier versions of PutI, and that fields gob omitted as zero don't
+// keep the values they had before the call.
+func TestKVClientGetGob(t *testing.T) {
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	kvClient := createTestClient(s.HTTPAddr)
+	kvClient.User = storage.UserRoot
+
+	type obj struct {
+		Name  string
+		Count int
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(obj{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	key := proto.Key("gob-key")
+	if err := kvClient.Call(proto.Put, proto.PutArgs(key, buf.Bytes()), &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	readObj := obj{Name: "stale", Count: 1}
+	if ok, _, err := kvClient.GetI(key, &readObj); !ok || err != nil {
+		t.Fatalf("unable to get object ok? %t: %s", ok, err)
+	}
+	if expObj := (obj{Name: "a"}); readObj != expObj {
+		t.Errorf("expected %+v; got %+v", expObj, readObj)
+	}
+}
+
 // TestKVClientEmptyValues verifies that empty values are preserved
 // for both empty []byte and integer=0. This used to fail when we
 // allowed the protobufs to be gob-encoded using the default go rpc
@@ -349,7 +736,7 @@ func TestKVClientEmptyValues(t *testing.T) {
 			Key: proto.Key("b"),
 		},
 		Value: proto.Value{
-			Integer: gogoproto.Int64(0),
+			ValuePayload: proto.ValuePayload{Integer: gogoproto.Int64(0)},
 		},
 	}, &proto.PutResponse{})
 
@@ -115,5 +115,25 @@ Transactions should endeavor to write using KV.Prepare calls. This
 allows writes to the same range to be batched together. In cases where
 the entire transaction affects only a single range, transactions can
 commit in a single round trip.
+
+Where writes must be issued with KV.Call, setting
+TransactionOptions.PipelineWrites sends Puts and Deletes
+asynchronously. Outstanding writes are awaited by subsequent reads of
+overlapping keys and at commit, so a transaction of sequential writes
+costs roughly one round trip instead of one per write. Errors from
+pipelined writes surface on the command which awaits them.
+
+Applications which use the same settings throughout can configure them
+once with a Session. A session applies its user, priority, isolation,
+read consistency, retry options and call timeout to all operations and
+transactions run through it, and orders them causally: each one
+observes the writes of its predecessors, even if they were made
+through another node.
+
+  s := client.NewSession(client.NewHTTPSender("localhost:8080", tlsConfig), nil,
+    client.SessionOptions{User: "app", Isolation: proto.SNAPSHOT})
+  err := s.RunTransaction("test", func(txn *client.KV) error {
+    return txn.Call(proto.Put, proto.PutArgs(proto.Key("a"), []byte("value")), &proto.PutResponse{})
+  })
 */
 package client
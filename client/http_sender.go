@@ -116,6 +116,7 @@ func (s *HTTPSender) Send(call *Call) {
 				// the errors we'll sweep up in this net shouldn't be retried,
 				// but we can't really know for sure which.
 				log.Warningf("failed to send HTTP request or read its response: %s", t)
+				call.ambiguousRetries++
 				return util.RetryContinue, nil
 			default:
 				// Can't retry in order to recover from this error. Propagate.
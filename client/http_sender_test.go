@@ -209,6 +209,9 @@ func TestHTTPSenderRetryHTTPSendError(t *testing.T) {
 		if count != 2 {
 			t.Errorf("%d: expected retry", i)
 		}
+		if call.ambiguousRetries != 1 {
+			t.Errorf("%d: expected one ambiguous retry; got %d", i, call.ambiguousRetries)
+		}
 		server.Close()
 	}
 }
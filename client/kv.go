@@ -20,6 +20,9 @@ package client
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"sort"
 	"time"
 
 	"github.com/cockroachdb/cockroach/proto"
@@ -40,6 +43,23 @@ var TxnRetryOptions = util.RetryOptions{
 type TransactionOptions struct {
 	Name      string // Concise desc of txn for debugging
 	Isolation proto.IsolationType
+	// PipelineWrites enables asynchronous sending of Puts and Deletes
+	// within the transaction. Pipelined writes are only awaited by
+	// subsequent reads of overlapping keys, by other commands and at
+	// commit, cutting latency for transactions dominated by round
+	// trips. Errors from pipelined writes are returned by the command
+	// which awaits them, and their reply structs are not valid until
+	// then.
+	PipelineWrites bool
+	// MinTimestamp is an optional causality token, usually the commit
+	// timestamp of another transaction as returned by
+	// RunTransactionWithCommitTimestamp. If set, the transaction is
+	// ordered after it and observes all writes committed at or before
+	// it, even if they were made through another node.
+	MinTimestamp proto.Timestamp
+	// RetryOptions, if non-nil, override TxnRetryOptions for the
+	// handling of conflicts within this transaction.
+	RetryOptions *util.RetryOptions
 }
 
 // KVSender is an interface for sending a request to a Key-Value
@@ -68,10 +88,22 @@ type KV struct {
 	// UserPriority is set non-zero in call arguments, this value is
 	// ignored.
 	UserPriority int32
+	// ReadConsistency is the default read consistency to set on
+	// read-only API calls which don't specify one. Clients which
+	// shouldn't disturb the traffic they observe, such as monitoring
+	// tools, may set proto.INCONSISTENT, typically together with a low
+	// UserPriority. Transactional calls are always consistent.
+	ReadConsistency proto.ReadConsistencyType
+	// Limits are the request size limits verified before API calls are
+	// sent, so that oversized requests fail early with a
+	// RequestTooLargeError. NewKV initializes them to
+	// proto.DefaultRequestLimits.
+	Limits proto.RequestLimits
 
 	sender   KVSender
 	clock    Clock
 	prepared []*Call
+	stats    *RetryStats
 }
 
 // NewKV creates a new instance of KV using the specified sender. To
@@ -82,8 +114,10 @@ type KV struct {
 // implementation.
 func NewKV(sender KVSender, clock Clock) *KV {
 	return &KV{
+		Limits: proto.DefaultRequestLimits,
 		sender: sender,
 		clock:  clock,
+		stats:  &RetryStats{},
 	}
 }
 
@@ -99,6 +133,15 @@ func (kv *KV) Sender() KVSender {
 	return nil
 }
 
+// RetryStats returns the number of retries by cause of transactions
+// run by this client and of requests it sent. Called on the
+// transactional client supplied to a RunTransaction retryable
+// function, the stats count only that transaction's retries, which
+// are also added to the stats of the client which ran it.
+func (kv *KV) RetryStats() RetryStats {
+	return kv.stats.snapshot()
+}
+
 // Call invokes the KV command synchronously and returns the response
 // and error, if applicable. If preceeding calls have been made to
 // Prepare() without a call to Flush(), this call is prepared and
@@ -114,6 +157,14 @@ func (kv *KV) Call(method string, args proto.Request, reply proto.Response) erro
 	if args.Header().UserPriority == nil && kv.UserPriority != 0 {
 		args.Header().UserPriority = gogoproto.Int32(kv.UserPriority)
 	}
+	if args.Header().ReadConsistency == proto.CONSISTENT && kv.ReadConsistency != proto.CONSISTENT &&
+		args.Header().Txn == nil && proto.IsReadOnly(method) {
+		args.Header().ReadConsistency = kv.ReadConsistency
+	}
+	if err := kv.Limits.Verify(args); err != nil {
+		reply.Header().SetGoError(err)
+		return err
+	}
 	call := &Call{
 		Method: method,
 		Args:   args,
@@ -121,6 +172,9 @@ func (kv *KV) Call(method string, args proto.Request, reply proto.Response) erro
 	}
 	call.resetClientCmdID(kv.clock)
 	kv.sender.Send(call)
+	// Pipelined writes of a transaction complete after Send returns;
+	// their retries are recorded by the txnSender once awaited.
+	kv.stats.record(RetryAmbiguous, call.ambiguousRetries)
 	err := call.Reply.Header().GoError()
 	if err != nil {
 		log.Infof("failed %s: %s", call.Method, err)
@@ -138,7 +192,9 @@ func (kv *KV) Call(method string, args proto.Request, reply proto.Response) erro
 // commands to be sent over the same connection. For transactional
 // clients, Prepare/Flush can dramatically improve efficiency by
 // compressing multiple writes into a single atomic update in the
-// event that the writes are to keys within a single range. However,
+// event that the writes are to keys within a single range. For
+// non-transactional clients, the calls addressing the same range are
+// executed atomically, but calls to different ranges are not, so
 // using Prepare/Flush alone will not guarantee atomicity. Clients
 // must use a transaction for that purpose.
 //
@@ -199,6 +255,35 @@ func (kv *KV) Flush() (err error) {
 	return
 }
 
+// Savepoint flushes prepared calls and returns a savepoint for the
+// transaction, which can be rolled back to with RollbackToSavepoint.
+// It may only be called on the transactional KV client supplied to a
+// RunTransaction retryable function.
+func (kv *KV) Savepoint() (int32, error) {
+	ts, ok := kv.sender.(*txnSender)
+	if !ok {
+		return 0, util.Errorf("savepoints require a transactional client")
+	}
+	if err := kv.Flush(); err != nil {
+		return 0, err
+	}
+	return ts.savepoint()
+}
+
+// RollbackToSavepoint flushes prepared calls and undoes the writes
+// the transaction made since the savepoint was taken. Subsequent
+// reads don't see them and they aren't committed.
+func (kv *KV) RollbackToSavepoint(savepoint int32) error {
+	ts, ok := kv.sender.(*txnSender)
+	if !ok {
+		return util.Errorf("savepoints require a transactional client")
+	}
+	if err := kv.Flush(); err != nil {
+		return err
+	}
+	return ts.rollbackToSavepoint(savepoint)
+}
+
 // RunTransaction executes retryable in the context of a distributed
 // transaction. The transaction is automatically aborted if retryable
 // returns any error aside from recoverable internal errors, and is
@@ -209,8 +294,30 @@ func (kv *KV) Flush() (err error) {
 // Calling RunTransaction on the transactional KV client which is
 // supplied to the retryable function is an error.
 func (kv *KV) RunTransaction(opts *TransactionOptions, retryable func(txn *KV) error) error {
+	_, err := kv.runTransaction(opts, retryable)
+	return err
+}
+
+// RunTransactionWithCommitTimestamp is like RunTransaction but also
+// returns the timestamp at which the transaction committed. The
+// timestamp is a causality token: passed as MinTimestamp to a
+// subsequent transaction, possibly in another process and through
+// another node, it ensures that transaction observes this one's
+// writes. The timestamp is zero if the transaction sent no commands.
+func (kv *KV) RunTransactionWithCommitTimestamp(opts *TransactionOptions, retryable func(txn *KV) error) (
+	proto.Timestamp, error) {
+	ts, err := kv.runTransaction(opts, retryable)
+	if err != nil {
+		return proto.ZeroTimestamp, err
+	}
+	return ts.timestamp(), nil
+}
+
+// runTransaction implements RunTransaction, returning the txnSender
+// of the finished transaction.
+func (kv *KV) runTransaction(opts *TransactionOptions, retryable func(txn *KV) error) (*txnSender, error) {
 	if _, ok := kv.sender.(*txnSender); ok {
-		return util.Errorf("cannot invoke RunTransaction on an already-transactional client")
+		return nil, util.Errorf("cannot invoke RunTransaction on an already-transactional client")
 	}
 
 	// Create a new KV for the transaction using a transactional KV sender.
@@ -218,14 +325,23 @@ func (kv *KV) RunTransaction(opts *TransactionOptions, retryable func(txn *KV) e
 	txnKV := NewKV(txnSender, kv.clock)
 	txnKV.User = kv.User
 	txnKV.UserPriority = kv.UserPriority
+	txnKV.Limits = kv.Limits
+	txnKV.stats = &RetryStats{parent: kv.stats}
+	txnSender.stats = txnKV.stats
 	defer txnKV.Close()
 
 	// Run retryable in a retry loop until we encounter a success or
 	// error condition this loop isn't capable of handling.
 	retryOpts := TxnRetryOptions
+	if opts.RetryOptions != nil {
+		retryOpts = *opts.RetryOptions
+	}
 	retryOpts.Tag = opts.Name
 	if err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
 		txnSender.txnEnd = false // always reset before [re]starting txn
+		// Drain writes still outstanding from a previous attempt; their
+		// errors, if any, have already been acted upon.
+		txnSender.awaitAll()
 		err := retryable(txnKV)
 		if err == nil && !txnSender.txnEnd {
 			// If there were no errors running retryable, commit the txn. This
@@ -239,6 +355,9 @@ func (kv *KV) RunTransaction(opts *TransactionOptions, retryable func(txn *KV) e
 			txnKV.Prepare(proto.EndTransaction, etArgs, etReply)
 			err = txnKV.Flush()
 		}
+		if cause, ok := retryCauseForError(err); ok {
+			txnKV.stats.record(cause, 1)
+		}
 		switch t := err.(type) {
 		case *proto.ReadWithinUncertaintyIntervalError:
 			// Retry immediately on read within uncertainty interval.
@@ -265,31 +384,53 @@ func (kv *KV) RunTransaction(opts *TransactionOptions, retryable func(txn *KV) e
 		if etReply.Header().GoError() != nil {
 			log.Errorf("failure aborting transaction: %s; abort caused by: %s", etReply.Header().GoError(), err)
 		}
-		return err
+		return nil, err
 	}
-	return nil
+	return txnSender, nil
 }
 
-// GetI fetches the value at the specified key and gob-deserializes it
-// into "value". Returns true on success or false if the key was not
-// found. The timestamp of the write is returned as the second return
-// value. The first result parameter is "ok": true if a value was
-// found for the requested key; false otherwise. An error is returned
-// on error fetching from underlying storage or deserializing value.
+// GetI fetches the value at the specified key and JSON-deserializes
+// it into "value". Values which aren't valid JSON are gob-decoded
+// instead, as PutI wrote gob before it wrote JSON. Returns true on
+// success or false if the key was not found. The timestamp of the
+// write is returned as the second return value. The first result
+// parameter is "ok": true if a value was found for the requested key;
+// false otherwise. An error is returned on error fetching from
+// underlying storage or deserializing value.
 func (kv *KV) GetI(key proto.Key, iface interface{}) (bool, proto.Timestamp, error) {
 	value, err := kv.getInternal(key)
 	if err != nil || value == nil {
 		return false, proto.Timestamp{}, err
 	}
-	if value.Integer != nil {
-		return false, proto.Timestamp{}, util.Errorf("unexpected integer value at key %q: %+v", key, value)
+	if t := value.GetValueType(); t == proto.INT || t == proto.FLOAT {
+		return false, proto.Timestamp{}, util.Errorf("unexpected non-byte value at key %q: %+v", key, value)
 	}
-	if err := gob.NewDecoder(bytes.NewBuffer(value.Bytes)).Decode(iface); err != nil {
-		return true, *value.Timestamp, err
+	if err := json.Unmarshal(value.Bytes, iface); err != nil {
+		if !gobDecodeI(value.Bytes, iface) {
+			return true, *value.Timestamp, err
+		}
 	}
 	return true, *value.Timestamp, nil
 }
 
+// gobDecodeI gob-decodes data into the value iface points to, returning
+// whether it succeeded. Gob leaves fields missing from the data
+// untouched, so the data is decoded into a fresh value, which replaces
+// iface's only on success. Neither fields set by the failed JSON decode
+// nor those set by the caller leak into the result.
+func gobDecodeI(data []byte, iface interface{}) bool {
+	v := reflect.ValueOf(iface)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return false
+	}
+	fresh := reflect.New(v.Elem().Type())
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(fresh.Interface()); err != nil {
+		return false
+	}
+	v.Elem().Set(fresh.Elem())
+	return true
+}
+
 // GetProto fetches the value at the specified key and unmarshals it
 // using a protobuf decoder. See comments for GetI for details on
 // return values.
@@ -298,8 +439,8 @@ func (kv *KV) GetProto(key proto.Key, msg gogoproto.Message) (bool, proto.Timest
 	if err != nil || value == nil {
 		return false, proto.Timestamp{}, err
 	}
-	if value.Integer != nil {
-		return false, proto.Timestamp{}, util.Errorf("unexpected integer value at key %q: %+v", key, value)
+	if t := value.GetValueType(); t == proto.INT || t == proto.FLOAT {
+		return false, proto.Timestamp{}, util.Errorf("unexpected non-byte value at key %q: %+v", key, value)
 	}
 	if err := gogoproto.Unmarshal(value.Bytes, msg); err != nil {
 		return true, *value.Timestamp, err
@@ -307,6 +448,19 @@ func (kv *KV) GetProto(key proto.Key, msg gogoproto.Message) (bool, proto.Timest
 	return true, *value.Timestamp, nil
 }
 
+// GetFloat fetches the float value at the specified key. See comments
+// for GetI for details on return values.
+func (kv *KV) GetFloat(key proto.Key) (bool, float64, proto.Timestamp, error) {
+	value, err := kv.getInternal(key)
+	if err != nil || value == nil {
+		return false, 0, proto.Timestamp{}, err
+	}
+	if value.GetValueType() != proto.FLOAT {
+		return false, 0, proto.Timestamp{}, util.Errorf("unexpected non-float value at key %q: %+v", key, value)
+	}
+	return true, value.GetFloat(), *value.Timestamp, nil
+}
+
 // getInternal fetches the requested key and returns the value.
 func (kv *KV) getInternal(key proto.Key) (*proto.Value, error) {
 	reply := &proto.GetResponse{}
@@ -321,13 +475,35 @@ func (kv *KV) getInternal(key proto.Key) (*proto.Value, error) {
 	return nil, nil
 }
 
-// PutI sets the given key to the gob-serialized byte string of value.
+// GetWithTombstone fetches the value at the specified key. If the key
+// was deleted, returns true and a value holding only the timestamp of
+// the deletion. Returns a nil value if the key was never written.
+func (kv *KV) GetWithTombstone(key proto.Key) (*proto.Value, bool, error) {
+	reply := &proto.GetResponse{}
+	if err := kv.Call(proto.Get, &proto.GetRequest{
+		RequestHeader:     proto.RequestHeader{Key: key},
+		IncludeTombstones: true,
+	}, reply); err != nil {
+		return nil, false, err
+	}
+	if reply.Value != nil && !reply.Deleted {
+		if err := reply.Value.Verify(key); err != nil {
+			return nil, false, err
+		}
+	}
+	return reply.Value, reply.Deleted, nil
+}
+
+// PutI sets the given key to the JSON-serialized byte string of
+// value. JSON is used rather than gob so that values remain readable
+// as the Go types they were written from evolve: fields may be added
+// or removed, and values may be read by clients in other languages.
 func (kv *KV) PutI(key proto.Key, iface interface{}) error {
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(iface); err != nil {
+	data, err := json.Marshal(iface)
+	if err != nil {
 		return err
 	}
-	return kv.putInternal(key, proto.Value{Bytes: buf.Bytes()})
+	return kv.putInternal(key, proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}})
 }
 
 // PutProto sets the given key to the protobuf-serialized byte string
@@ -337,7 +513,14 @@ func (kv *KV) PutProto(key proto.Key, msg gogoproto.Message) error {
 	if err != nil {
 		return err
 	}
-	return kv.putInternal(key, proto.Value{Bytes: data})
+	return kv.putInternal(key, proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}})
+}
+
+// PutFloat sets the given key to the float value f.
+func (kv *KV) PutFloat(key proto.Key, f float64) error {
+	value := proto.Value{}
+	value.SetFloat(f)
+	return kv.putInternal(key, value)
 }
 
 // putInternal writes the specified value to key.
@@ -349,6 +532,178 @@ func (kv *KV) putInternal(key proto.Key, value proto.Value) error {
 	}, &proto.PutResponse{})
 }
 
+// ConditionalPut sets the given key to value if the existing value
+// is expValue; a nil expValue specifies that the key must not exist.
+// This allows optimistic concurrency for clients which don't require
+// full transactions. On a mismatch, the returned error is a
+// *proto.ConditionFailedError whose ActualValue holds the existing
+// value, or is nil if the key doesn't exist.
+func (kv *KV) ConditionalPut(key proto.Key, value, expValue []byte) error {
+	args := &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		Value:         proto.Value{ValuePayload: proto.ValuePayload{Bytes: value}},
+	}
+	args.Value.InitChecksum(key)
+	if expValue != nil {
+		args.ExpValue = &proto.Value{ValuePayload: proto.ValuePayload{Bytes: expValue}}
+	}
+	return kv.Call(proto.ConditionalPut, args, &proto.ConditionalPutResponse{})
+}
+
+// CompareAndSwap atomically applies writes if every condition holds:
+// each condition's key must have the expected value, or no value if
+// ExpValue is nil. A write with a nil Value deletes its key. All keys
+// must be addressed to the same range, which makes this a lightweight
+// alternative to a transaction for small read-modify-write patterns
+// on co-located keys, such as swapping index entries. On a mismatch,
+// the returned error is a *proto.ConditionFailedError and nothing is
+// written. CompareAndSwap may not be called on a transactional client.
+func (kv *KV) CompareAndSwap(conditions []proto.CompareAndSwapRequest_Condition,
+	writes []proto.CompareAndSwapRequest_Write) error {
+	var minKey, maxKey proto.Key
+	expand := func(key proto.Key) {
+		if minKey == nil || key.Less(minKey) {
+			minKey = key
+		}
+		if maxKey == nil || maxKey.Less(key) {
+			maxKey = key
+		}
+	}
+	for _, c := range conditions {
+		expand(c.Key)
+	}
+	for i := range writes {
+		expand(writes[i].Key)
+		if writes[i].Value != nil {
+			writes[i].Value.InitChecksum(writes[i].Key)
+		}
+	}
+	if minKey == nil {
+		return util.Errorf("compare-and-swap requires at least one key")
+	}
+	// The header spans all keys so that the command queue and timestamp
+	// cache cover them and the request is rejected if they straddle a
+	// range boundary.
+	args := &proto.CompareAndSwapRequest{
+		RequestHeader: proto.RequestHeader{Key: minKey},
+		Conditions:    conditions,
+		Writes:        writes,
+	}
+	if !minKey.Equal(maxKey) {
+		args.EndKey = maxKey.Next()
+	}
+	return kv.Call(proto.CompareAndSwap, args, &proto.CompareAndSwapResponse{})
+}
+
+// Increment atomically increments the integer value at key by inc
+// and returns the new value. A missing key is treated as zero. Returns
+// an error if the existing value isn't an integer or if the increment
+// would overflow.
+func (kv *KV) Increment(key proto.Key, inc int64) (int64, error) {
+	reply := &proto.IncrementResponse{}
+	if err := kv.Call(proto.Increment, proto.IncrementArgs(key, inc), reply); err != nil {
+		return 0, err
+	}
+	return reply.NewValue, nil
+}
+
+// DeleteRange deletes the values of all keys from key (inclusive) to
+// endKey (exclusive), leaving a deletion tombstone for each, and
+// returns the number of keys deleted. A delete spanning more than one
+// range is executed in a transaction, so it's atomic.
+func (kv *KV) DeleteRange(key, endKey proto.Key) (int64, error) {
+	reply := &proto.DeleteRangeResponse{}
+	if err := kv.Call(proto.DeleteRange, proto.DeleteRangeArgs(key, endKey), reply); err != nil {
+		return 0, err
+	}
+	return reply.NumDeleted, nil
+}
+
+// Scan returns up to maxResults key/value pairs from key (inclusive)
+// to endKey (exclusive) in ascending key order. Specify maxResults=0
+// for an unbounded scan. If the scan stopped at maxResults, the key at
+// which to resume is returned; pass it as key to a subsequent Scan to
+// fetch the next page. The returned key is empty once endKey is
+// reached.
+func (kv *KV) Scan(key, endKey proto.Key, maxResults int64) ([]proto.KeyValue, proto.Key, error) {
+	reply := &proto.ScanResponse{}
+	if err := kv.Call(proto.Scan, proto.ScanArgs(key, endKey, maxResults), reply); err != nil {
+		return nil, nil, err
+	}
+	return reply.Rows, reply.ResumeKey, nil
+}
+
+// ScanWithTombstones is like Scan, except that keys deleted as of the
+// read timestamp are returned as rows with Deleted set. The value of
+// a deleted row holds only the timestamp of the deletion.
+func (kv *KV) ScanWithTombstones(key, endKey proto.Key, maxResults int64) ([]proto.KeyValue, proto.Key, error) {
+	args := proto.ScanArgs(key, endKey, maxResults)
+	args.IncludeTombstones = true
+	reply := &proto.ScanResponse{}
+	if err := kv.Call(proto.Scan, args, reply); err != nil {
+		return nil, nil, err
+	}
+	return reply.Rows, reply.ResumeKey, nil
+}
+
+// ReverseScan returns up to maxResults key/value pairs from key
+// (inclusive) to endKey (exclusive) in descending key order, starting
+// with the last key before endKey. Specify maxResults=0 for an
+// unbounded scan.
+func (kv *KV) ReverseScan(key, endKey proto.Key, maxResults int64) ([]proto.KeyValue, error) {
+	reply := &proto.ReverseScanResponse{}
+	if err := kv.Call(proto.ReverseScan, proto.ReverseScanArgs(key, endKey, maxResults), reply); err != nil {
+		return nil, err
+	}
+	return reply.Rows, nil
+}
+
+// ScanPrefix invokes fn for every key/value pair whose key has the
+// given prefix, in ascending key order. Rows are fetched in batches
+// of at most batchSize (or all at once if batchSize=0), so arbitrarily
+// large prefixes can be scanned without holding them in memory.
+// Iteration stops at the first error returned by fn.
+func (kv *KV) ScanPrefix(prefix proto.Key, batchSize int64, fn func(proto.KeyValue) error) error {
+	key, endKey := prefix, prefixEnd(prefix)
+	for {
+		rows, resumeKey, err := kv.Scan(key, endKey, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		if len(resumeKey) == 0 {
+			return nil
+		}
+		key = resumeKey
+	}
+}
+
+// DelPrefix deletes the values of all keys with the given prefix and
+// returns the number of keys deleted.
+func (kv *KV) DelPrefix(prefix proto.Key) (int64, error) {
+	return kv.DeleteRange(prefix, prefixEnd(prefix))
+}
+
+// prefixEnd returns the end key of the span of all keys having the
+// given prefix. Unlike proto.Key.PrefixEnd, trailing 0xff bytes are
+// dropped before incrementing so that the span is exact (e.g. the end
+// of "a\xff" is "b", not "b\x00"), and a prefix consisting solely of
+// 0xff bytes extends to KeyMax.
+func prefixEnd(prefix proto.Key) proto.Key {
+	i := len(prefix)
+	for i > 0 && prefix[i-1] == 0xff {
+		i--
+	}
+	if i == 0 {
+		return proto.KeyMax
+	}
+	return prefix[:i].PrefixEnd()
+}
+
 // PreparePutProto sets the given key to the protobuf-serialized byte
 // string of msg. The resulting Put call is buffered and will not be
 // sent until a subsequent call to Flush. Returns marshalling errors
@@ -358,7 +713,7 @@ func (kv *KV) PreparePutProto(key proto.Key, msg gogoproto.Message) error {
 	if err != nil {
 		return err
 	}
-	value := proto.Value{Bytes: data}
+	value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}}
 	value.InitChecksum(key)
 	kv.Prepare(proto.Put, &proto.PutRequest{
 		RequestHeader: proto.RequestHeader{Key: key},
@@ -367,6 +722,104 @@ func (kv *KV) PreparePutProto(key proto.Key, msg gogoproto.Message) error {
 	return nil
 }
 
+// PrepareConditionalPutProto sets the given key to the
+// protobuf-serialized byte string of msg if the key's current value is
+// the protobuf-serialized byte string of expMsg. A nil expMsg
+// specifies that the key must not exist. The resulting ConditionalPut
+// call is buffered and will not be sent until a subsequent call to
+// Flush. Returns marshalling errors if encountered.
+func (kv *KV) PrepareConditionalPutProto(key proto.Key, msg, expMsg gogoproto.Message) error {
+	data, err := gogoproto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var expValue *proto.Value
+	if expMsg != nil {
+		expData, err := gogoproto.Marshal(expMsg)
+		if err != nil {
+			return err
+		}
+		expValue = &proto.Value{ValuePayload: proto.ValuePayload{Bytes: expData}}
+	}
+	value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}}
+	value.InitChecksum(key)
+	kv.Prepare(proto.ConditionalPut, &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		Value:         value,
+		ExpValue:      expValue,
+	}, &proto.ConditionalPutResponse{})
+	return nil
+}
+
+// Now returns a timestamp from the cluster's hybrid logical clock,
+// read by the node serving the first range. Unlike a client's local
+// clock, the timestamp is ordered after every timestamp that node has
+// issued or observed, and its wall time is within the cluster's
+// maximum clock offset (--max_offset) of every node's clock.
+//
+// The timestamp may be used as the read timestamp for a consistent
+// snapshot of the database or passed between processes to order
+// their operations. Writes which completed on other nodes before Now
+// was called may carry timestamps up to the maximum clock offset
+// later than the result; readers which must observe them should read
+// in a transaction, whose uncertainty interval covers the offset.
+// Called on a transactional client, Now returns the transaction's
+// read timestamp.
+func (kv *KV) Now() (proto.Timestamp, error) {
+	reply := &proto.ContainsResponse{}
+	if err := kv.Call(proto.Contains, &proto.ContainsRequest{
+		RequestHeader: proto.RequestHeader{Key: proto.KeyMin},
+	}, reply); err != nil {
+		return proto.ZeroTimestamp, err
+	}
+	return reply.Timestamp, nil
+}
+
+// AdminSplit splits the ranges containing the supplied keys so that
+// each key becomes the start key of a range. Pre-splitting the
+// keyspace at known boundaries before a bulk load spreads the
+// initial writes across ranges instead of funneling them into a
+// single hot range. Keys which already begin a range are left as they
+// are. Keys are split in sorted order; AdminSplit stops at and
+// returns the first error encountered.
+func (kv *KV) AdminSplit(keys ...proto.Key) error {
+	sorted := make(keySlice, len(keys))
+	copy(sorted, keys)
+	sort.Sort(sorted)
+	for i, key := range sorted {
+		if i > 0 && key.Equal(sorted[i-1]) {
+			continue
+		}
+		if _, _, err := kv.AdminSplitAt(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdminSplitAt splits the range containing key so that key becomes
+// the start key of a range. Returns the descriptors of the ranges to
+// the left and right of key after the split. If key already begins a
+// range, the descriptors of the existing ranges are returned.
+func (kv *KV) AdminSplitAt(key proto.Key) (proto.RangeDescriptor, proto.RangeDescriptor, error) {
+	req := &proto.AdminSplitRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		SplitKey:      key,
+	}
+	reply := &proto.AdminSplitResponse{}
+	if err := kv.Call(proto.AdminSplit, req, reply); err != nil {
+		return proto.RangeDescriptor{}, proto.RangeDescriptor{}, util.Errorf("unable to split at key %q: %s", key, err)
+	}
+	return reply.LeftDesc, reply.RightDesc, nil
+}
+
+// keySlice implements sort.Interface for a slice of keys.
+type keySlice []proto.Key
+
+func (s keySlice) Len() int           { return len(s) }
+func (s keySlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s keySlice) Less(i, j int) bool { return s[i].Less(s[j]) }
+
 // Close closes the KV client and its sender.
 func (kv *KV) Close() {
 	kv.sender.Close()
@@ -18,6 +18,7 @@
 package client
 
 import (
+	"bytes"
 	"errors"
 	"reflect"
 	"testing"
@@ -120,6 +121,164 @@ func TestKVPrepareAndCall(t *testing.T) {
 	}
 }
 
+// TestKVRequestLimits verifies that requests exceeding the client's
+// size limits fail without being sent.
+func TestKVRequestLimits(t *testing.T) {
+	count := 0
+	client := NewKV(newTestSender(func(call *Call) {
+		count++
+	}), nil)
+	client.Limits.MaxValueSize = 4
+	args := &proto.PutRequest{
+		RequestHeader: proto.RequestHeader{Key: testKey},
+		Value:         proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("value")}},
+	}
+	reply := &proto.PutResponse{}
+	err := client.Call(proto.Put, args, reply)
+	if _, ok := err.(*proto.RequestTooLargeError); !ok {
+		t.Errorf("expected RequestTooLargeError; got %v", err)
+	}
+	if _, ok := reply.GoError().(*proto.RequestTooLargeError); !ok {
+		t.Errorf("expected RequestTooLargeError in reply; got %v", reply.GoError())
+	}
+	if count != 0 {
+		t.Errorf("expected request not to be sent; sent %d", count)
+	}
+}
+
+// TestKVNow verifies that Now sends a read with an unset timestamp
+// and returns the timestamp assigned by the server.
+func TestKVNow(t *testing.T) {
+	serverTS := makeTS(10, 5)
+	client := NewKV(newTestSender(func(call *Call) {
+		if !call.Args.Header().Timestamp.Equal(proto.ZeroTimestamp) {
+			t.Errorf("expected zero request timestamp; got %s", call.Args.Header().Timestamp)
+		}
+		call.Reply.Header().Timestamp = serverTS
+	}), nil)
+	ts, err := client.Now()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Equal(serverTS) {
+		t.Errorf("expected timestamp %s; got %s", serverTS, ts)
+	}
+}
+
+// TestKVAdminSplit verifies that AdminSplit sends one split per
+// distinct key, in sorted order, and stops at the first error.
+func TestKVAdminSplit(t *testing.T) {
+	var splitKeys []proto.Key
+	client := NewKV(newTestSender(func(call *Call) {
+		if call.Method != proto.AdminSplit {
+			t.Errorf("expected AdminSplit; got %s", call.Method)
+		}
+		args := call.Args.(*proto.AdminSplitRequest)
+		if !args.Key.Equal(args.SplitKey) {
+			t.Errorf("expected header key %q to equal split key %q", args.Key, args.SplitKey)
+		}
+		splitKeys = append(splitKeys, args.SplitKey)
+		if args.SplitKey.Equal(proto.Key("d")) {
+			call.Reply.Header().SetGoError(errors.New("split failed"))
+		}
+	}), nil)
+	if err := client.AdminSplit(proto.Key("c"), proto.Key("a"), proto.Key("b"), proto.Key("a")); err != nil {
+		t.Fatal(err)
+	}
+	if expKeys := []proto.Key{proto.Key("a"), proto.Key("b"), proto.Key("c")}; !reflect.DeepEqual(splitKeys, expKeys) {
+		t.Errorf("expected splits at %q; got %q", expKeys, splitKeys)
+	}
+	splitKeys = nil
+	if err := client.AdminSplit(proto.Key("e"), proto.Key("d"), proto.Key("f")); err == nil {
+		t.Error("expected error on failed split")
+	}
+	if expKeys := []proto.Key{proto.Key("d")}; !reflect.DeepEqual(splitKeys, expKeys) {
+		t.Errorf("expected splits at %q; got %q", expKeys, splitKeys)
+	}
+}
+
+// TestKVConditionalPut verifies that ConditionalPut sends the
+// expected value, if any, and returns the actual value on mismatch.
+func TestKVConditionalPut(t *testing.T) {
+	actual := &proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("actual")}}
+	client := NewKV(newTestSender(func(call *Call) {
+		if call.Method != proto.ConditionalPut {
+			t.Errorf("expected ConditionalPut; got %s", call.Method)
+		}
+		args := call.Args.(*proto.ConditionalPutRequest)
+		if err := args.Value.Verify(args.Key); err != nil {
+			t.Error(err)
+		}
+		if args.ExpValue != nil && !bytes.Equal(args.ExpValue.Bytes, actual.Bytes) {
+			call.Reply.Header().SetGoError(&proto.ConditionFailedError{ActualValue: actual})
+		}
+	}), nil)
+	key := proto.Key("a")
+	if err := client.ConditionalPut(key, []byte("value"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ConditionalPut(key, []byte("value"), actual.Bytes); err != nil {
+		t.Fatal(err)
+	}
+	err := client.ConditionalPut(key, []byte("value"), []byte("expected"))
+	if cErr, ok := err.(*proto.ConditionFailedError); !ok {
+		t.Fatalf("expected ConditionFailedError; got %T: %v", err, err)
+	} else if !bytes.Equal(cErr.ActualValue.Bytes, actual.Bytes) {
+		t.Errorf("expected actual value %q; got %+v", actual.Bytes, cErr.ActualValue)
+	}
+}
+
+// TestKVReadConsistency verifies that the client's default read
+// consistency is applied to read-only calls only, and not within
+// transactions.
+func TestKVReadConsistency(t *testing.T) {
+	consistency := map[string]proto.ReadConsistencyType{}
+	client := NewKV(newTestSender(func(call *Call) {
+		consistency[call.Method] = call.Args.Header().ReadConsistency
+	}), nil)
+	client.ReadConsistency = proto.INCONSISTENT
+	key := proto.Key("a")
+	if err := client.Call(proto.Get, proto.GetArgs(key), &proto.GetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(proto.Put, proto.PutArgs(key, []byte("value")), &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if consistency[proto.Get] != proto.INCONSISTENT || consistency[proto.Put] != proto.CONSISTENT {
+		t.Errorf("expected inconsistent get and consistent put; got %v", consistency)
+	}
+
+	if err := client.RunTransaction(&TransactionOptions{}, func(txn *KV) error {
+		return txn.Call(proto.Get, proto.GetArgs(key), &proto.GetResponse{})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if consistency[proto.Get] != proto.CONSISTENT {
+		t.Error("expected transactional get to be consistent")
+	}
+}
+
+// TestKVPrefixEnd verifies the end key computed for key prefixes,
+// including prefixes with trailing 0xff bytes.
+func TestKVPrefixEnd(t *testing.T) {
+	testCases := []struct {
+		prefix, end proto.Key
+	}{
+		{proto.Key(nil), proto.KeyMax},
+		{proto.Key("a"), proto.Key("b")},
+		{proto.Key("a\x00"), proto.Key("a\x01")},
+		{proto.Key("a\xff"), proto.Key("b")},
+		{proto.Key("a\xfe\xff\xff"), proto.Key("a\xff")},
+		{proto.Key("\xff"), proto.KeyMax},
+		{proto.Key("\xff\xff"), proto.KeyMax},
+	}
+	for i, test := range testCases {
+		if end := prefixEnd(test.prefix); !end.Equal(test.end) {
+			t.Errorf("%d: expected end of %q to be %q; got %q", i, test.prefix, test.end, end)
+		}
+	}
+}
+
 // TestKVTransactionSender verifies the proper unwrapping and
 // re-wrapping of the client's sender when starting a transaction.
 // Also verifies that User and UserPriority are propagated to the
@@ -161,6 +320,9 @@ func TestKVNestedTransactions(t *testing.T) {
 func TestKVCommitTransaction(t *testing.T) {
 	count := 0
 	client := NewKV(newTestSender(func(call *Call) {
+		if call.Method == proto.Put {
+			return
+		}
 		count++
 		if call.Method != proto.EndTransaction {
 			t.Errorf("expected call to EndTransaction; got %s", call.Method)
@@ -170,7 +332,7 @@ func TestKVCommitTransaction(t *testing.T) {
 		}
 	}), nil)
 	if err := client.RunTransaction(&TransactionOptions{}, func(txn *KV) error {
-		return nil
+		return txn.Call(proto.Put, testPutReq, &proto.PutResponse{})
 	}); err != nil {
 		t.Errorf("unexpected error on commit: %s", err)
 	}
@@ -185,9 +347,14 @@ func TestKVCommitTransaction(t *testing.T) {
 func TestKVCommitTransactionOnce(t *testing.T) {
 	count := 0
 	client := NewKV(newTestSender(func(call *Call) {
-		count++
+		if call.Method == proto.EndTransaction {
+			count++
+		}
 	}), nil)
 	if err := client.RunTransaction(&TransactionOptions{}, func(txn *KV) error {
+		if err := txn.Call(proto.Put, testPutReq, &proto.PutResponse{}); err != nil {
+			return err
+		}
 		reply := &proto.EndTransactionResponse{}
 		txn.Call(proto.EndTransaction, &proto.EndTransactionRequest{Commit: true}, reply)
 		if reply.GoError() != nil {
@@ -207,6 +374,9 @@ func TestKVCommitTransactionOnce(t *testing.T) {
 func TestKVAbortTransaction(t *testing.T) {
 	count := 0
 	client := NewKV(newTestSender(func(call *Call) {
+		if call.Method == proto.Put {
+			return
+		}
 		count++
 		if call.Method != proto.EndTransaction {
 			t.Errorf("expected call to EndTransaction; got %s", call.Method)
@@ -216,6 +386,9 @@ func TestKVAbortTransaction(t *testing.T) {
 		}
 	}), nil)
 	err := client.RunTransaction(&TransactionOptions{}, func(txn *KV) error {
+		if err := txn.Call(proto.Put, testPutReq, &proto.PutResponse{}); err != nil {
+			return err
+		}
 		return errors.New("foo")
 	})
 	if err == nil {
@@ -226,6 +399,31 @@ func TestKVAbortTransaction(t *testing.T) {
 	}
 }
 
+// TestKVReadOnlyTransaction verifies that transactions which don't
+// write are committed or aborted without sending EndTransaction.
+func TestKVReadOnlyTransaction(t *testing.T) {
+	for _, commit := range []bool{true, false} {
+		client := NewKV(newTestSender(func(call *Call) {
+			if call.Method != proto.Get {
+				t.Errorf("expected only calls to Get; got %s", call.Method)
+			}
+		}), nil)
+		err := client.RunTransaction(&TransactionOptions{}, func(txn *KV) error {
+			gArgs := &proto.GetRequest{RequestHeader: proto.RequestHeader{Key: testKey}}
+			if err := txn.Call(proto.Get, gArgs, &proto.GetResponse{}); err != nil {
+				return err
+			}
+			if !commit {
+				return errors.New("foo")
+			}
+			return nil
+		})
+		if commit != (err == nil) {
+			t.Errorf("commit=%t: unexpected error %v", commit, err)
+		}
+	}
+}
+
 // TestKVRunTransactionRetryOnErrors verifies that the transaction
 // is retried on the correct errors.
 func TestKVRunTransactionRetryOnErrors(t *testing.T) {
@@ -276,3 +474,50 @@ func TestKVRunTransactionRetryOnErrors(t *testing.T) {
 		}
 	}
 }
+
+// TestKVRetryStats verifies that transaction retries are counted by
+// cause, both for the transaction and for the client which ran it.
+func TestKVRetryStats(t *testing.T) {
+	TxnRetryOptions.Backoff = 1 * time.Millisecond
+
+	// The first transaction succeeds on its third attempt, the second
+	// on its second.
+	errs := map[int]error{
+		0: &proto.TransactionPushError{},
+		1: &proto.ReadWithinUncertaintyIntervalError{},
+		3: &proto.TransactionPushError{},
+	}
+	count := 0
+	client := NewKV(newTestSender(func(call *Call) {
+		if call.Method == proto.Put {
+			if err, ok := errs[count]; ok {
+				call.Reply.Header().SetGoError(err)
+			}
+			count++
+		}
+	}), nil)
+	for i := 0; i < 2; i++ {
+		var txnStats RetryStats
+		if err := client.RunTransaction(&TransactionOptions{}, func(txn *KV) error {
+			txnStats = txn.RetryStats()
+			return txn.Call(proto.Put, testPutReq, &proto.PutResponse{})
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// The final attempt observes the two preceding retries.
+			if txnStats.Count(RetryPush) != 1 || txnStats.Count(RetryUncertainty) != 1 || txnStats.Total() != 2 {
+				t.Errorf("unexpected transaction retry stats: %s", txnStats)
+			}
+		} else if txnStats.Total() != 1 {
+			t.Errorf("unexpected transaction retry stats: %s", txnStats)
+		}
+	}
+	stats := client.RetryStats()
+	if stats.Count(RetryPush) != 2 || stats.Count(RetryUncertainty) != 1 || stats.Total() != 3 {
+		t.Errorf("unexpected client retry stats: %s", stats)
+	}
+	if s := stats.String(); s != "uncertainty=1 push=2" {
+		t.Errorf("unexpected retry stats string %q", s)
+	}
+}
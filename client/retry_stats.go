@@ -0,0 +1,137 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// A RetryCause classifies the reason a transaction or request was
+// retried.
+type RetryCause int
+
+const (
+	// RetryUncertainty counts transactions restarted after reading a
+	// value within their uncertainty interval.
+	RetryUncertainty RetryCause = iota
+	// RetryPush counts transactions retried after failing to push a
+	// conflicting transaction.
+	RetryPush
+	// RetryAbort counts transactions restarted after being aborted,
+	// most often by a conflicting transaction of higher priority.
+	RetryAbort
+	// RetryRestart counts transactions restarted at a new timestamp,
+	// as when a SERIALIZABLE transaction's timestamp was pushed.
+	RetryRestart
+	// RetryAmbiguous counts requests resent after a failure which left
+	// their outcome unknown, such as a connection lost while awaiting
+	// the reply.
+	RetryAmbiguous
+
+	numRetryCauses
+)
+
+var retryCauseNames = [numRetryCauses]string{
+	RetryUncertainty: "uncertainty",
+	RetryPush:        "push",
+	RetryAbort:       "abort",
+	RetryRestart:     "restart",
+	RetryAmbiguous:   "ambiguous",
+}
+
+// String returns the name of the retry cause.
+func (c RetryCause) String() string {
+	if c < 0 || c >= numRetryCauses {
+		return fmt.Sprintf("RetryCause(%d)", int(c))
+	}
+	return retryCauseNames[c]
+}
+
+// retryCauseForError returns the retry cause for an error on which
+// transactions are retried. Returns false for all other errors.
+func retryCauseForError(err error) (RetryCause, bool) {
+	switch err.(type) {
+	case *proto.ReadWithinUncertaintyIntervalError:
+		return RetryUncertainty, true
+	case *proto.TransactionPushError:
+		return RetryPush, true
+	case *proto.TransactionAbortedError:
+		return RetryAbort, true
+	case *proto.TransactionRetryError:
+		return RetryRestart, true
+	}
+	return 0, false
+}
+
+// RetryStats counts retries by cause. Applications may use them to
+// detect contention hotspots. RetryStats are safe for concurrent use.
+type RetryStats struct {
+	counts [numRetryCauses]int64
+	parent *RetryStats // Also incremented on each retry, if not nil
+}
+
+// record adds n retries with the given cause to the stats and to
+// those of its parent, if any. A nil RetryStats ignores the retries.
+func (s *RetryStats) record(cause RetryCause, n int64) {
+	for ; s != nil && n != 0; s = s.parent {
+		atomic.AddInt64(&s.counts[cause], n)
+	}
+}
+
+// snapshot returns a copy of the stats, without a parent.
+func (s *RetryStats) snapshot() RetryStats {
+	var copy RetryStats
+	if s != nil {
+		for i := range s.counts {
+			copy.counts[i] = atomic.LoadInt64(&s.counts[i])
+		}
+	}
+	return copy
+}
+
+// Count returns the number of retries with the given cause.
+func (s RetryStats) Count(cause RetryCause) int64 {
+	return s.counts[cause]
+}
+
+// Total returns the number of retries from all causes.
+func (s RetryStats) Total() int64 {
+	var total int64
+	for _, n := range s.counts {
+		total += n
+	}
+	return total
+}
+
+// String returns the non-zero retry counts by cause; e.g.
+// "push=3 abort=1".
+func (s RetryStats) String() string {
+	var buf bytes.Buffer
+	for i, n := range s.counts {
+		if n == 0 {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "%s=%d", RetryCause(i), n)
+	}
+	return buf.String()
+}
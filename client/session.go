@@ -0,0 +1,183 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package client
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// DefaultSessionTimeout is the timeout of calls sent through a session
+// whose options don't specify one.
+const DefaultSessionTimeout = 30 * time.Second
+
+// SessionOptions are the defaults a Session applies to all operations
+// and transactions run through it.
+type SessionOptions struct {
+	// User and UserPriority are set on all API calls which don't
+	// specify them. See KV.User and KV.UserPriority.
+	User         string
+	UserPriority int32
+	// Isolation and PipelineWrites are used for all transactions. See
+	// TransactionOptions.
+	Isolation      proto.IsolationType
+	PipelineWrites bool
+	// MinTimestamp is the initial lower bound on the timestamps of the
+	// session's transactions. The session forwards it to the commit
+	// timestamp of each transaction it runs, so that every transaction
+	// observes the writes of its predecessors, even if they were made
+	// through another node.
+	MinTimestamp proto.Timestamp
+	// RetryOptions, if non-nil, override TxnRetryOptions for the
+	// session's transactions.
+	RetryOptions *util.RetryOptions
+	// ReadConsistency is used for the session's non-transactional
+	// reads. See KV.ReadConsistency. The zero value is
+	// proto.CONSISTENT.
+	ReadConsistency proto.ReadConsistencyType
+	// Timeout bounds each call sent through the session, including
+	// those of its transactions. A call which doesn't complete in time
+	// fails with an error, though it may still execute. Zero means
+	// DefaultSessionTimeout; a negative value disables the timeout.
+	Timeout time.Duration
+}
+
+// A Session is a KV client configured once with default options,
+// which apply to all operations and transactions created from it.
+// Operations and transactions run through a session are causally
+// ordered. Like KV, a Session is not thread safe.
+type Session struct {
+	kv    *KV
+	opts  SessionOptions
+	minTS proto.Timestamp
+}
+
+// NewSession creates a new session using the specified sender and
+// clock, as supplied to NewKV, and options.
+func NewSession(sender KVSender, clock Clock, opts SessionOptions) *Session {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultSessionTimeout
+	}
+	s := &Session{
+		opts:  opts,
+		minTS: opts.MinTimestamp,
+	}
+	s.kv = NewKV(&sessionSender{session: s, wrapped: sender}, clock)
+	s.kv.User = opts.User
+	s.kv.UserPriority = opts.UserPriority
+	s.kv.ReadConsistency = opts.ReadConsistency
+	return s
+}
+
+// KV returns the session's client for non-transactional operations.
+func (s *Session) KV() *KV {
+	return s.kv
+}
+
+// Timestamp returns the session's current lower bound on the
+// timestamps of its operations and transactions: the latest timestamp
+// at which one of them executed or committed, if any. It may be
+// passed as a causality token to other sessions or transactions.
+func (s *Session) Timestamp() proto.Timestamp {
+	return s.minTS
+}
+
+// TransactionOptions returns options for a transaction with the
+// specified name, initialized from the session's defaults.
+func (s *Session) TransactionOptions(name string) *TransactionOptions {
+	return &TransactionOptions{
+		Name:           name,
+		Isolation:      s.opts.Isolation,
+		PipelineWrites: s.opts.PipelineWrites,
+		MinTimestamp:   s.minTS,
+		RetryOptions:   s.opts.RetryOptions,
+	}
+}
+
+// RunTransaction executes retryable in the context of a transaction
+// with the specified name and the session's default options. See
+// KV.RunTransaction. On commit, the session's timestamp is forwarded
+// to the transaction's commit timestamp.
+func (s *Session) RunTransaction(name string, retryable func(txn *KV) error) error {
+	commitTS, err := s.kv.RunTransactionWithCommitTimestamp(s.TransactionOptions(name), retryable)
+	if err != nil {
+		return err
+	}
+	s.minTS.Forward(commitTS)
+	return nil
+}
+
+// sessionSender is the KVSender of a session's client. It bounds each
+// call by the session's timeout. Non-transactional calls carry the
+// session's timestamp as their MinTimestamp, and the session's
+// timestamp is forwarded to the timestamp at which they executed.
+// Transactions are ordered by Session.RunTransaction instead.
+type sessionSender struct {
+	session *Session
+	wrapped KVSender
+}
+
+// Send implements the KVSender interface.
+func (ss *sessionSender) Send(call *Call) {
+	header := call.Args.Header()
+	causal := header.Txn == nil
+	if causal && header.MinTimestamp.Equal(proto.ZeroTimestamp) {
+		header.MinTimestamp = ss.session.minTS
+	}
+	ss.sendWithTimeout(call)
+	if causal && call.Reply.Header().GoError() == nil {
+		ss.session.minTS.Forward(call.Reply.Header().Timestamp)
+	}
+}
+
+// sendWithTimeout sends call using the wrapped sender, setting an
+// error on the reply if it doesn't complete within the session's
+// timeout. The call is sent as a copy, so that a timed out call which
+// completes later doesn't race with the caller's use of its arguments
+// and reply.
+func (ss *sessionSender) sendWithTimeout(call *Call) {
+	timeout := ss.session.opts.Timeout
+	if timeout < 0 {
+		ss.wrapped.Send(call)
+		return
+	}
+	c := &Call{
+		Method: call.Method,
+		Args:   gogoproto.Clone(call.Args).(proto.Request),
+		Reply:  gogoproto.Clone(call.Reply).(proto.Response),
+	}
+	done := make(chan struct{})
+	go func() {
+		ss.wrapped.Send(c)
+		close(done)
+	}()
+	select {
+	case <-done:
+		call.Reply.Reset()
+		gogoproto.Merge(call.Reply, c.Reply)
+		call.ambiguousRetries += c.ambiguousRetries
+	case <-time.After(timeout):
+		call.Reply.Header().SetGoError(util.Errorf("%s timed out after %s", call.Method, timeout))
+	}
+}
+
+// Close implements the KVSender interface.
+func (ss *sessionSender) Close() {
+	ss.wrapped.Close()
+}
@@ -0,0 +1,177 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestSessionDefaults verifies that a session's defaults are applied
+// to its operations and transactions.
+func TestSessionDefaults(t *testing.T) {
+	s := NewSession(newTestSender(func(call *Call) {
+		header := call.Args.Header()
+		if header.User != "foo" {
+			t.Errorf("%s: expected user foo; got %q", call.Method, header.User)
+		}
+		if header.Txn != nil && header.Txn.Isolation != proto.SNAPSHOT {
+			t.Errorf("%s: expected snapshot isolation; got %s", call.Method, header.Txn.Isolation)
+		}
+	}), nil, SessionOptions{User: "foo", UserPriority: 10, Isolation: proto.SNAPSHOT,
+		ReadConsistency: proto.INCONSISTENT})
+
+	if s.KV().UserPriority != 10 {
+		t.Errorf("expected user priority 10; got %d", s.KV().UserPriority)
+	}
+	if s.KV().ReadConsistency != proto.INCONSISTENT {
+		t.Errorf("expected inconsistent reads; got %s", s.KV().ReadConsistency)
+	}
+	if s.opts.Timeout != DefaultSessionTimeout {
+		t.Errorf("expected default timeout %s; got %s", DefaultSessionTimeout, s.opts.Timeout)
+	}
+	if err := s.KV().Call(proto.Put, &proto.PutRequest{}, &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RunTransaction("test", func(txn *KV) error {
+		return txn.Call(proto.Put, &proto.PutRequest{}, &proto.PutResponse{})
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSessionCausality verifies that each transaction run through a
+// session is begun no earlier than the commit timestamp of the
+// previous one.
+func TestSessionCausality(t *testing.T) {
+	var txnTS []proto.Timestamp
+	commitTS := makeTS(10, 0)
+	s := NewSession(newTestSender(func(call *Call) {
+		switch call.Method {
+		case proto.Put:
+			txnTS = append(txnTS, call.Args.Header().Txn.Timestamp)
+		case proto.EndTransaction:
+			call.Reply.Header().Txn.Timestamp = commitTS
+			commitTS.WallTime += 10
+		}
+	}), nil, SessionOptions{MinTimestamp: makeTS(5, 0)})
+
+	for i := 0; i < 3; i++ {
+		if err := s.RunTransaction("test", func(txn *KV) error {
+			return txn.Call(proto.Put, &proto.PutRequest{}, &proto.PutResponse{})
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expTS := []proto.Timestamp{makeTS(5, 0), makeTS(10, 0), makeTS(20, 0)}
+	for i, ts := range txnTS {
+		if !ts.Equal(expTS[i]) {
+			t.Errorf("%d: expected transaction timestamp %s; got %s", i, expTS[i], ts)
+		}
+	}
+	if ts := s.Timestamp(); !ts.Equal(makeTS(30, 0)) {
+		t.Errorf("expected session timestamp %s; got %s", makeTS(30, 0), ts)
+	}
+}
+
+// TestSessionKVCausality verifies that the session's non-transactional
+// calls carry its timestamp as a causality token and advance it to
+// the timestamps at which they execute, ordering them with the
+// session's transactions.
+func TestSessionKVCausality(t *testing.T) {
+	var minTS []proto.Timestamp
+	var txnTS proto.Timestamp
+	s := NewSession(newTestSender(func(call *Call) {
+		header := call.Args.Header()
+		switch {
+		case header.Txn == nil:
+			minTS = append(minTS, header.MinTimestamp)
+			call.Reply.Header().Timestamp = makeTS(20, 0)
+		case call.Method == proto.Put:
+			txnTS = header.Txn.Timestamp
+		case call.Method == proto.EndTransaction:
+			call.Reply.Header().Txn.Timestamp = makeTS(30, 0)
+		}
+	}), nil, SessionOptions{MinTimestamp: makeTS(10, 0)})
+
+	if err := s.KV().Call(proto.Put, &proto.PutRequest{}, &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RunTransaction("test", func(txn *KV) error {
+		return txn.Call(proto.Put, &proto.PutRequest{}, &proto.PutResponse{})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !txnTS.Equal(makeTS(20, 0)) {
+		t.Errorf("expected transaction timestamp %s; got %s", makeTS(20, 0), txnTS)
+	}
+	if err := s.KV().Call(proto.Get, &proto.GetRequest{}, &proto.GetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	expTS := []proto.Timestamp{makeTS(10, 0), makeTS(30, 0)}
+	if len(minTS) != len(expTS) {
+		t.Fatalf("expected %d non-transactional calls; got %d", len(expTS), len(minTS))
+	}
+	for i, ts := range minTS {
+		if !ts.Equal(expTS[i]) {
+			t.Errorf("%d: expected min timestamp %s; got %s", i, expTS[i], ts)
+		}
+	}
+}
+
+// TestSessionTimeout verifies that a call which doesn't complete
+// within the session's timeout fails.
+func TestSessionTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	s := NewSession(newTestSender(func(call *Call) {
+		<-unblock
+	}), nil, SessionOptions{Timeout: time.Millisecond})
+
+	if err := s.KV().Call(proto.Put, &proto.PutRequest{}, &proto.PutResponse{}); err == nil {
+		t.Error("expected call to time out")
+	}
+}
+
+// TestSessionRetryOptions verifies that a session's retry options are
+// used for its transactions.
+func TestSessionRetryOptions(t *testing.T) {
+	count := 0
+	s := NewSession(newTestSender(func(call *Call) {
+		if call.Method == proto.Put {
+			count++
+			call.Reply.Header().SetGoError(&proto.TransactionPushError{})
+		}
+	}), nil, SessionOptions{RetryOptions: &util.RetryOptions{
+		Backoff:     time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+		Constant:    1,
+		MaxAttempts: 2,
+	}})
+
+	err := s.RunTransaction("test", func(txn *KV) error {
+		return txn.Call(proto.Put, &proto.PutRequest{}, &proto.PutResponse{})
+	})
+	if _, ok := err.(*util.RetryMaxAttemptsError); !ok {
+		t.Errorf("expected max attempts error; got %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 attempts; got %d", count)
+	}
+}
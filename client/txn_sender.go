@@ -17,7 +17,53 @@
 
 package client
 
-import "github.com/cockroachdb/cockroach/proto"
+import (
+	"sync"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// pipelinedMethods are the methods which a txnSender with pipelining
+// enabled sends asynchronously. Their replies carry nothing the
+// caller needs beyond success or failure, so the caller can proceed
+// without awaiting them.
+var pipelinedMethods = map[string]struct{}{
+	proto.Put:    struct{}{},
+	proto.Delete: struct{}{},
+}
+
+// An outstandingWrite is a pipelined write which has been sent but
+// whose reply has not yet been awaited. The write is sent as a copy of
+// the caller's call, which the caller may use as soon as Send returns;
+// the copy's reply is copied into the caller's once awaited.
+type outstandingWrite struct {
+	call        *Call         // The caller's call
+	sent        *Call         // The copy of call which was sent
+	key, endKey proto.Key     // Key span written; endKey is exclusive
+	done        chan struct{} // Closed when the reply has been received
+}
+
+// overlaps returns whether the write's key span overlaps
+// [key, endKey). If endKey is empty, the span is the single key.
+func (w *outstandingWrite) overlaps(key, endKey proto.Key) bool {
+	if len(endKey) == 0 {
+		endKey = key.Next()
+	}
+	return key.Less(w.endKey) && w.key.Less(endKey)
+}
+
+// wait waits for the write's reply and copies it into the caller's
+// call, recording any ambiguous retries in stats. Returns the write's
+// error, if any.
+func (w *outstandingWrite) wait(stats *RetryStats) error {
+	<-w.done
+	w.call.Reply.Reset()
+	gogoproto.Merge(w.call.Reply, w.sent.Reply)
+	stats.record(RetryAmbiguous, w.sent.ambiguousRetries)
+	return w.call.Reply.Header().GoError()
+}
 
 // A txnSender proxies requests to the underlying KVSender,
 // automatically beginning a transaction and then propagating txn
@@ -26,21 +72,43 @@ import "github.com/cockroachdb/cockroach/proto"
 // receipt of TransactionAbortedError, the transaction is re-created
 // and error passed to caller.
 //
+// If write pipelining is enabled via TransactionOptions, Puts and
+// Deletes are sent asynchronously once the transaction has begun and
+// are tracked as outstanding writes. Reads await only outstanding
+// writes which overlap the keys they read; all other commands,
+// including EndTransaction, await every outstanding write first. The
+// first error encountered by an outstanding write is returned as the
+// error of the command which awaited it.
+//
+// A transaction which hasn't written anything has neither intents nor
+// a transaction record, the latter being created by the coordinator
+// only on the transaction's first write. Such a read-only transaction
+// is ended locally, without sending EndTransaction.
+//
 // txnSender is not thread safe.
 type txnSender struct {
-	wrapped KVSender
-	txnEnd  bool // True if EndTransaction was invoked internally
-	txn     *proto.Transaction
+	wrapped     KVSender
+	stats       *RetryStats     // Records retries of pipelined writes; may be nil
+	txnEnd      bool            // True if EndTransaction was invoked internally
+	pipeline    bool            // True to pipeline writes
+	minTS       proto.Timestamp // Causality token from TransactionOptions
+	mu          sync.Mutex
+	txn         *proto.Transaction // Protected by mu
+	wrote       bool               // True if txn has sent a write; protected by mu
+	outstanding []*outstandingWrite
 }
 
 // newTxnSender returns a new instance of txnSender which wraps a
 // KVSender and uses the supplied transaction options.
 func newTxnSender(wrapped KVSender, opts *TransactionOptions) *txnSender {
 	return &txnSender{
-		wrapped: wrapped,
+		wrapped:  wrapped,
+		pipeline: opts.PipelineWrites,
+		minTS:    opts.MinTimestamp,
 		txn: &proto.Transaction{
 			Name:      opts.Name,
 			Isolation: opts.Isolation,
+			Timestamp: opts.MinTimestamp,
 		},
 	}
 }
@@ -50,9 +118,179 @@ func newTxnSender(wrapped KVSender, opts *TransactionOptions) *txnSender {
 // response. In the event of a transaction abort, reset txn with a
 // minimum priority.
 func (ts *txnSender) Send(call *Call) {
-	// Send call through wrapped sender.
-	call.Args.Header().Txn = ts.txn
+	header := call.Args.Header()
+	if et, ok := call.Args.(*proto.EndTransactionRequest); ok && !ts.hasWritten() {
+		ts.endLocally(et, call)
+		return
+	}
+	var err error
+	switch {
+	case ts.shouldPipeline(call.Method):
+		// Writes to overlapping keys must be applied in order.
+		err = ts.await(header.Key, header.EndKey)
+		if err == nil {
+			ts.sendAsync(call)
+			return
+		}
+	case proto.IsReadOnly(call.Method):
+		err = ts.await(header.Key, header.EndKey)
+	default:
+		err = ts.awaitAll()
+		// Always attempt to abort, even if an outstanding write failed.
+		if et, ok := call.Args.(*proto.EndTransactionRequest); ok && !et.Commit {
+			err = nil
+		}
+	}
+	if err != nil {
+		call.Reply.Reset()
+		call.Reply.Header().SetGoError(err)
+		return
+	}
+	ts.send(call)
+}
+
+// shouldPipeline returns whether the method should be sent
+// asynchronously. The transaction must already have begun, as the
+// first write assigns the transaction ID.
+func (ts *txnSender) shouldPipeline(method string) bool {
+	if !ts.pipeline {
+		return false
+	}
+	if _, ok := pipelinedMethods[method]; !ok {
+		return false
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.txn.ID) > 0
+}
+
+// hasWritten returns whether the transaction has sent any writes.
+func (ts *txnSender) hasWritten() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.wrote
+}
+
+// endLocally ends a read-only transaction without an RPC, replying
+// with the transaction committed or aborted as requested.
+func (ts *txnSender) endLocally(args *proto.EndTransactionRequest, call *Call) {
+	ts.mu.Lock()
+	txn := gogoproto.Clone(ts.txn).(*proto.Transaction)
+	ts.mu.Unlock()
+	if args.Commit {
+		txn.Status = proto.COMMITTED
+	} else {
+		txn.Status = proto.ABORTED
+	}
+	call.Reply.Reset()
+	call.Reply.Header().Timestamp = txn.Timestamp
+	call.Reply.Header().Txn = txn
+	ts.update(call)
+}
+
+// isWrite returns whether the call writes on behalf of the
+// transaction. A batch is a write if any of its requests, other than
+// EndTransaction, is.
+func isWrite(call *Call) bool {
+	if call.Method == proto.EndTransaction {
+		return false
+	}
+	bArgs, ok := call.Args.(*proto.BatchRequest)
+	if !ok {
+		return !proto.IsReadOnly(call.Method)
+	}
+	for i := range bArgs.Requests {
+		method, err := proto.MethodForRequest(bArgs.Requests[i].GetValue().(proto.Request))
+		if err != nil || (method != proto.EndTransaction && !proto.IsReadOnly(method)) {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends the call synchronously through the wrapped sender and
+// updates the transaction from the reply. Each write is assigned the
+// next sequence number of the transaction.
+func (ts *txnSender) send(call *Call) {
+	ts.mu.Lock()
+	if !proto.IsReadOnly(call.Method) {
+		ts.txn.Sequence++
+	}
+	if isWrite(call) {
+		ts.wrote = true
+	}
+	call.Args.Header().Txn = gogoproto.Clone(ts.txn).(*proto.Transaction)
+	ts.mu.Unlock()
 	ts.wrapped.Send(call)
+	ts.update(call)
+}
+
+// sendAsync sends a copy of the call asynchronously, tracking it as
+// an outstanding write until awaited. The caller's call is left
+// untouched until then.
+func (ts *txnSender) sendAsync(call *Call) {
+	header := call.Args.Header()
+	w := &outstandingWrite{
+		call: call,
+		sent: &Call{
+			Method: call.Method,
+			Args:   gogoproto.Clone(call.Args).(proto.Request),
+			Reply:  gogoproto.Clone(call.Reply).(proto.Response),
+		},
+		key:    header.Key,
+		endKey: header.EndKey,
+		done:   make(chan struct{}),
+	}
+	if len(w.endKey) == 0 {
+		w.endKey = w.key.Next()
+	}
+	ts.outstanding = append(ts.outstanding, w)
+	go func() {
+		ts.send(w.sent)
+		close(w.done)
+	}()
+}
+
+// await waits for all outstanding writes which overlap
+// [key, endKey). If any of them failed, waits for all outstanding
+// writes and returns the first error.
+func (ts *txnSender) await(key, endKey proto.Key) error {
+	remaining := ts.outstanding[:0]
+	var err error
+	for _, w := range ts.outstanding {
+		if !w.overlaps(key, endKey) {
+			remaining = append(remaining, w)
+			continue
+		}
+		if wErr := w.wait(ts.stats); wErr != nil && err == nil {
+			err = wErr
+		}
+	}
+	ts.outstanding = remaining
+	if err != nil {
+		ts.awaitAll()
+	}
+	return err
+}
+
+// awaitAll waits for all outstanding writes and returns the first
+// error encountered, if any.
+func (ts *txnSender) awaitAll() error {
+	var err error
+	for _, w := range ts.outstanding {
+		if wErr := w.wait(ts.stats); wErr != nil && err == nil {
+			err = wErr
+		}
+	}
+	ts.outstanding = nil
+	return err
+}
+
+// update updates the transaction from the call's reply, taking action
+// on various errors.
+func (ts *txnSender) update(call *Call) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
 	ts.txn.Update(call.Reply.Header().Txn)
 
 	// Take action on various errors.
@@ -63,7 +301,9 @@ func (ts *txnSender) Send(call *Call) {
 			Name:      ts.txn.Name,
 			Isolation: ts.txn.Isolation,
 			Priority:  t.Txn.Priority, // acts as a minimum priority on restart
+			Timestamp: ts.minTS,
 		}
+		ts.wrote = false
 	case nil:
 		if call.Method == proto.EndTransaction {
 			ts.txnEnd = true // set this txn as having been ended
@@ -71,6 +311,40 @@ func (ts *txnSender) Send(call *Call) {
 	}
 }
 
+// timestamp returns the transaction's timestamp, which is its commit
+// timestamp once it has committed.
+func (ts *txnSender) timestamp() proto.Timestamp {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.txn.Timestamp
+}
+
+// savepoint waits for outstanding writes and records a savepoint at
+// the transaction's latest write.
+func (ts *txnSender) savepoint() (int32, error) {
+	if err := ts.awaitAll(); err != nil {
+		return 0, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.txn.Savepoint(), nil
+}
+
+// rollbackToSavepoint waits for outstanding writes and marks the
+// writes made since the savepoint as rolled back.
+func (ts *txnSender) rollbackToSavepoint(savepoint int32) error {
+	if err := ts.awaitAll(); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if savepoint > ts.txn.Sequence {
+		return util.Errorf("savepoint %d is later than the transaction's latest write %d", savepoint, ts.txn.Sequence)
+	}
+	ts.txn.RollbackToSavepoint(savepoint)
+	return nil
+}
+
 // Close is a noop for the txnSender.
 func (ts *txnSender) Close() {
 }
@@ -18,10 +18,13 @@
 package client
 
 import (
+	"reflect"
+	"sync"
 	"testing"
 
 	"code.google.com/p/go-uuid/uuid"
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
 	gogoproto "github.com/gogo/protobuf/proto"
 )
 
@@ -114,3 +117,213 @@ func TestTxnSenderResetTxnOnAbort(t *testing.T) {
 		t.Errorf("expected txn to be cleared")
 	}
 }
+
+// TestTxnSenderPipelineWrites verifies that, with write pipelining
+// enabled, writes after the first are sent asynchronously and only
+// awaited by reads of overlapping keys.
+func TestTxnSenderPipelineWrites(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var sent []string
+	ts := newTxnSender(newTestSender(func(call *Call) {
+		key := string(call.Args.Header().Key)
+		if call.Method == proto.Put && key == "b" {
+			<-release
+		}
+		mu.Lock()
+		sent = append(sent, call.Method+":"+key)
+		mu.Unlock()
+	}), &TransactionOptions{PipelineWrites: true})
+
+	send := func(method string, args proto.Request, reply proto.Response) {
+		ts.Send(&Call{Method: method, Args: args, Reply: reply})
+		if err := reply.Header().GoError(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putArgs := func(key string) *proto.PutRequest {
+		return &proto.PutRequest{RequestHeader: proto.RequestHeader{Key: proto.Key(key)}}
+	}
+	getArgs := func(key string) *proto.GetRequest {
+		return &proto.GetRequest{RequestHeader: proto.RequestHeader{Key: proto.Key(key)}}
+	}
+
+	// The first write begins the transaction and is sent synchronously.
+	send(proto.Put, putArgs("a"), &proto.PutResponse{})
+	if len(ts.outstanding) != 0 {
+		t.Fatalf("expected first write to be synchronous; got %d outstanding", len(ts.outstanding))
+	}
+	// The second write blocks in the sender, but Send returns.
+	send(proto.Put, putArgs("b"), &proto.PutResponse{})
+	if len(ts.outstanding) != 1 {
+		t.Fatalf("expected 1 outstanding write; got %d", len(ts.outstanding))
+	}
+	// A read of a non-overlapping key doesn't await the write.
+	send(proto.Get, getArgs("c"), &proto.GetResponse{})
+	if len(ts.outstanding) != 1 {
+		t.Fatalf("expected 1 outstanding write; got %d", len(ts.outstanding))
+	}
+	// A read of an overlapping key awaits the write.
+	close(release)
+	send(proto.Get, getArgs("b"), &proto.GetResponse{})
+	if len(ts.outstanding) != 0 {
+		t.Fatalf("expected no outstanding writes; got %d", len(ts.outstanding))
+	}
+
+	expSent := []string{"Put:a", "Get:c", "Put:b", "Get:b"}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(expSent, sent) {
+		t.Errorf("expected %v; got %v", expSent, sent)
+	}
+}
+
+// TestTxnSenderPipelineWriteError verifies that an error from a
+// pipelined write is returned on commit without sending the
+// EndTransaction, and that an abort is still sent.
+func TestTxnSenderPipelineWriteError(t *testing.T) {
+	var mu sync.Mutex
+	var endTxns []bool
+	ts := newTxnSender(newTestSender(func(call *Call) {
+		switch call.Method {
+		case proto.Put:
+			if string(call.Args.Header().Key) == "b" {
+				call.Reply.Header().SetGoError(util.Errorf("test error"))
+			}
+		case proto.EndTransaction:
+			mu.Lock()
+			endTxns = append(endTxns, call.Args.(*proto.EndTransactionRequest).Commit)
+			mu.Unlock()
+		}
+	}), &TransactionOptions{PipelineWrites: true})
+
+	for _, key := range []string{"a", "b"} {
+		reply := &proto.PutResponse{}
+		ts.Send(&Call{Method: proto.Put, Args: &proto.PutRequest{RequestHeader: proto.RequestHeader{Key: proto.Key(key)}}, Reply: reply})
+		if err := reply.GoError(); err != nil {
+			t.Fatalf("unexpected error from %q: %s", key, err)
+		}
+	}
+
+	reply := &proto.EndTransactionResponse{}
+	ts.Send(&Call{Method: proto.EndTransaction, Args: &proto.EndTransactionRequest{Commit: true}, Reply: reply})
+	if reply.GoError() == nil {
+		t.Error("expected error from outstanding write on commit")
+	}
+	reply = &proto.EndTransactionResponse{}
+	ts.Send(&Call{Method: proto.EndTransaction, Args: &proto.EndTransactionRequest{Commit: false}, Reply: reply})
+	if err := reply.GoError(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(endTxns, []bool{false}) {
+		t.Errorf("expected only an abort to be sent; got %v", endTxns)
+	}
+}
+
+// TestTxnSenderPipelineReplyCopied verifies that the reply of a
+// pipelined write, along with its ambiguous retries, is copied to the
+// caller only once the write is awaited, so that the caller may use
+// its call as soon as Send returns. Run with -race to catch accesses
+// racing the asynchronous send.
+func TestTxnSenderPipelineReplyCopied(t *testing.T) {
+	ts := newTxnSender(newTestSender(func(call *Call) {
+		if call.Method == proto.Put && string(call.Args.Header().Key) == "b" {
+			call.ambiguousRetries++
+			call.Reply.Header().SetGoError(util.Errorf("test error"))
+		}
+	}), &TransactionOptions{PipelineWrites: true})
+	ts.stats = &RetryStats{}
+
+	var calls []*Call
+	for _, key := range []string{"a", "b", "c"} {
+		call := &Call{
+			Method: proto.Put,
+			Args:   &proto.PutRequest{RequestHeader: proto.RequestHeader{Key: proto.Key(key)}},
+			Reply:  &proto.PutResponse{},
+		}
+		ts.Send(call)
+		if err := call.Reply.Header().GoError(); err != nil {
+			t.Fatalf("unexpected error from %q: %s", key, err)
+		}
+		call.Args.Header().Timestamp = makeTS(1, 0)
+		calls = append(calls, call)
+	}
+
+	if err := ts.awaitAll(); err == nil {
+		t.Fatal("expected error from pipelined write")
+	}
+	if calls[1].Reply.Header().GoError() == nil {
+		t.Error("expected the write's error to be copied to its reply")
+	}
+	if calls[2].Reply.Header().Txn == nil {
+		t.Error("expected the write's reply to be copied")
+	}
+	if n := ts.stats.snapshot().Count(RetryAmbiguous); n != 1 {
+		t.Errorf("expected 1 ambiguous retry; got %d", n)
+	}
+}
+
+// TestTxnSenderIsWrite verifies which calls count as writes of the
+// transaction, including those batched together.
+func TestTxnSenderIsWrite(t *testing.T) {
+	batch := func(args ...proto.Request) *proto.BatchRequest {
+		bArgs := &proto.BatchRequest{}
+		for _, a := range args {
+			bArgs.Add(a)
+		}
+		return bArgs
+	}
+	testCases := []struct {
+		method string
+		args   proto.Request
+		expect bool
+	}{
+		{proto.Get, &proto.GetRequest{}, false},
+		{proto.Scan, &proto.ScanRequest{}, false},
+		{proto.Put, &proto.PutRequest{}, true},
+		{proto.DeleteRange, &proto.DeleteRangeRequest{}, true},
+		{proto.EndTransaction, &proto.EndTransactionRequest{}, false},
+		{proto.Batch, batch(&proto.GetRequest{}, &proto.EndTransactionRequest{}), false},
+		{proto.Batch, batch(&proto.GetRequest{}, &proto.PutRequest{}), true},
+	}
+	for i, test := range testCases {
+		if w := isWrite(&Call{Method: test.method, Args: test.args}); w != test.expect {
+			t.Errorf("%d: expected %s write=%t; got %t", i, test.method, test.expect, w)
+		}
+	}
+}
+
+// TestTxnSenderEndReadOnly verifies that a transaction is ended
+// without sending EndTransaction only as long as it hasn't written.
+func TestTxnSenderEndReadOnly(t *testing.T) {
+	var endTxns int
+	sender := newTestSender(func(call *Call) {
+		if call.Method == proto.EndTransaction {
+			endTxns++
+		}
+	})
+
+	ts := newTxnSender(sender, &TransactionOptions{})
+	ts.Send(&Call{
+		Method: proto.Get,
+		Args:   &proto.GetRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("a")}},
+		Reply:  &proto.GetResponse{},
+	})
+	etReply := &proto.EndTransactionResponse{}
+	ts.Send(&Call{Method: proto.EndTransaction, Args: &proto.EndTransactionRequest{Commit: true}, Reply: etReply})
+	if endTxns != 0 {
+		t.Errorf("expected no EndTransaction to be sent; got %d", endTxns)
+	}
+	if etReply.GoError() != nil || etReply.Txn.Status != proto.COMMITTED || !ts.txnEnd {
+		t.Errorf("expected transaction to be committed; got %s, %s", etReply.Txn, etReply.GoError())
+	}
+
+	ts = newTxnSender(sender, &TransactionOptions{})
+	ts.Send(&Call{Method: proto.Put, Args: testPutReq, Reply: &proto.PutResponse{}})
+	ts.Send(&Call{Method: proto.EndTransaction, Args: &proto.EndTransactionRequest{Commit: true}, Reply: &proto.EndTransactionResponse{}})
+	if endTxns != 1 {
+		t.Errorf("expected EndTransaction to be sent after a write; got %d", endTxns)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package gossip
+
+import (
+	"flag"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+var (
+	// GossipSRV is the name of a DNS SRV record listing gossip bootstrap
+	// hosts. If set, the record is resolved periodically and the
+	// bootstrap hosts are refreshed to match it, which suits deployments
+	// where node addresses are ephemeral, such as containers.
+	GossipSRV = flag.String(
+		"gossip_srv", "",
+		"name of a DNS SRV record (e.g. _cockroach._tcp.example.com) which is "+
+			"resolved periodically to discover gossip bootstrap hosts; may be "+
+			"used in addition to -gossip")
+	// GossipSRVInterval is the interval at which GossipSRV is resolved.
+	GossipSRVInterval = flag.Duration(
+		"gossip_srv_interval", 30*time.Second,
+		"interval (time.Duration) at which the -gossip_srv record is resolved")
+)
+
+// lookupSRV resolves SRV records; it's a variable so that tests may
+// substitute a fake resolver.
+var lookupSRV = net.LookupSRV
+
+// resolveSRV resolves the named SRV record and returns the addresses
+// of its targets.
+func resolveSRV(name string) ([]net.Addr, error) {
+	_, srvs, err := lookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]net.Addr, 0, len(srvs))
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		addrs = append(addrs, util.MakeRawAddr("tcp", net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))))
+	}
+	return addrs, nil
+}
+
+// discover resolves the named SRV record every interval, replacing
+// the bootstrap addresses discovered by the previous resolution with
+// those currently listed. Resolution failures leave the bootstrap
+// addresses unchanged. Loops until the gossip instance is stopped and
+// should be invoked via goroutine.
+func (g *Gossip) discover(name string, interval time.Duration) {
+	var prev []net.Addr
+	for {
+		if addrs, err := resolveSRV(name); err != nil {
+			log.Warningf("unable to resolve gossip SRV record %q: %v", name, err)
+		} else {
+			g.updateDiscovered(prev, addrs)
+			prev = addrs
+		}
+		time.Sleep(interval)
+		g.mu.Lock()
+		closed := g.closed
+		g.mu.Unlock()
+		if closed {
+			return
+		}
+	}
+}
+
+// updateDiscovered removes the previously discovered addresses which
+// are no longer listed from the bootstrap hosts and adds the current
+// ones. The bootstrapper is woken if the bootstrap hosts changed.
+func (g *Gossip) updateDiscovered(prev, cur []net.Addr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	curSet := map[string]struct{}{}
+	for _, addr := range cur {
+		curSet[addr.String()] = struct{}{}
+	}
+	changed := false
+	for _, addr := range prev {
+		if _, ok := curSet[addr.String()]; !ok && g.bootstraps.hasAddr(addr) {
+			g.bootstraps.removeAddr(addr)
+			changed = true
+		}
+	}
+	for _, addr := range cur {
+		// Skip our own address, as in parseBootstrapAddresses.
+		if g.is.NodeAddr != nil && addr.String() == g.is.NodeAddr.String() {
+			continue
+		}
+		if !g.bootstraps.hasAddr(addr) {
+			g.bootstraps.addAddr(addr)
+			changed = true
+		}
+	}
+	if changed {
+		log.Infof("gossip bootstrap hosts updated from SRV record: %v", cur)
+		g.stalled.Signal()
+	}
+}
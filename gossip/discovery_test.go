@@ -0,0 +1,91 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package gossip
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// bootstrapAddrs returns the sorted string addresses of the gossip
+// instance's bootstrap hosts.
+func bootstrapAddrs(g *Gossip) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var addrs []string
+	for _, addr := range g.bootstraps.asSlice() {
+		addrs = append(addrs, addr.String())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// TestResolveSRV verifies SRV targets are converted to addresses.
+func TestResolveSRV(t *testing.T) {
+	defer func() { lookupSRV = net.LookupSRV }()
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_cockroach._tcp.example.com" {
+			t.Errorf("unexpected SRV name %q", name)
+		}
+		return "", []*net.SRV{
+			{Target: "node1.example.com.", Port: 26257},
+			{Target: "node2.example.com", Port: 26258},
+		}, nil
+	}
+	addrs, err := resolveSRV("_cockroach._tcp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expAddrs := []net.Addr{
+		util.MakeRawAddr("tcp", "node1.example.com:26257"),
+		util.MakeRawAddr("tcp", "node2.example.com:26258"),
+	}
+	if !reflect.DeepEqual(addrs, expAddrs) {
+		t.Errorf("expected %v; got %v", expAddrs, addrs)
+	}
+}
+
+// TestUpdateDiscovered verifies that bootstrap hosts track the
+// addresses listed by successive SRV resolutions, while leaving other
+// bootstrap hosts and the node's own address alone.
+func TestUpdateDiscovered(t *testing.T) {
+	g := New(nil)
+	g.is.NodeAddr = util.MakeRawAddr("tcp", "self:1")
+	g.SetBootstrap([]net.Addr{util.MakeRawAddr("tcp", "flag:1")})
+
+	first := []net.Addr{
+		util.MakeRawAddr("tcp", "a:1"),
+		util.MakeRawAddr("tcp", "b:1"),
+		util.MakeRawAddr("tcp", "self:1"),
+	}
+	g.updateDiscovered(nil, first)
+	if addrs, exp := bootstrapAddrs(g), []string{"a:1", "b:1", "flag:1"}; !reflect.DeepEqual(addrs, exp) {
+		t.Errorf("expected %v; got %v", exp, addrs)
+	}
+
+	second := []net.Addr{
+		util.MakeRawAddr("tcp", "b:1"),
+		util.MakeRawAddr("tcp", "c:1"),
+	}
+	g.updateDiscovered(first, second)
+	if addrs, exp := bootstrapAddrs(g), []string{"b:1", "c:1", "flag:1"}; !reflect.DeepEqual(addrs, exp) {
+		t.Errorf("expected %v; got %v", exp, addrs)
+	}
+}
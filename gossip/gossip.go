@@ -24,7 +24,8 @@ the system with minimal total hops. The algorithm is as follows:
 
  1 Node selects random peer from bootstrap list, excluding its own
    address for its first outgoing connection. Node starts client and
-   continues to step #2.
+   continues to step #2. The bootstrap list may be refreshed by
+   periodically resolving a DNS SRV record (-gossip_srv).
 
  2 Node requests gossip from peer. If this is first request, MaxSeq
    will be 0. Otherwise, will be value of MaxSeq from last response to
@@ -68,6 +69,7 @@ import (
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
+	gogoproto "github.com/gogo/protobuf/proto"
 )
 
 var (
@@ -81,6 +83,16 @@ var (
 	GossipInterval = flag.Duration(
 		"gossip_interval", 2*time.Second,
 		"approximate interval (time.Duration) for gossiping new information to peers")
+
+	// GossipMaxInfos is the maximum number of non-group infos held in
+	// the infostore. Once reached, the oldest non-critical infos are
+	// evicted to make room for new ones. Groups are bounded separately
+	// by their own limits.
+	GossipMaxInfos = flag.Int(
+		"gossip_max_infos", 10000,
+		"maximum number of non-group infos stored by gossip; when exceeded, "+
+			"the oldest infos are evicted, excepting critical infos such as the "+
+			"cluster ID, first range descriptor and node addresses")
 )
 
 const (
@@ -132,18 +144,27 @@ func New(rpcContext *rpc.Context) *Gossip {
 		clients:      map[string]*client{},
 		disconnected: make(chan *client, MaxPeers),
 	}
+	if rpcContext != nil {
+		g.clock = rpcContext.LocalClock()
+	}
+	// Use the node's clock for info timestamps and expirations so that
+	// tests may control gossip TTLs with a manual or skewed clock.
+	g.is.clock = g.clock
 	g.stalled = sync.NewCond(&g.mu)
 	return g
 }
 
 // SetBootstrap initializes the set of gossip node addresses used to
-// bootstrap the gossip network.
+// bootstrap the gossip network. Addresses may be added after the
+// gossip instance is started, in which case the bootstrapper is woken
+// to reconsider them.
 func (g *Gossip) SetBootstrap(bootstraps []net.Addr) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	for _, addr := range bootstraps {
 		g.bootstraps.addAddr(addr)
 	}
+	g.stalled.Signal()
 }
 
 // SetInterval sets the interval at which fresh info is gossiped to
@@ -177,6 +198,36 @@ func (g *Gossip) GetInfo(key string) (interface{}, error) {
 	return nil, util.Errorf("key %q does not exist or has expired", key)
 }
 
+// AddInfoProto adds or updates an info object whose value is the
+// marshaled form of the supplied protobuf message. Unlike values
+// supplied to AddInfo, proto values require no gob type registration
+// and remain compatible across versions which add fields to the
+// message. Returns an error if the message could not be marshaled or
+// the info couldn't be added.
+func (g *Gossip) AddInfoProto(key string, msg gogoproto.Message, ttl time.Duration) error {
+	bytes, err := gogoproto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return g.AddInfo(key, bytes, ttl)
+}
+
+// GetInfoProto unmarshals the info value stored under key into the
+// supplied protobuf message. Returns an error if the specified key
+// does not exist or has expired, or if the value was not added via
+// AddInfoProto.
+func (g *Gossip) GetInfoProto(key string, msg gogoproto.Message) error {
+	val, err := g.GetInfo(key)
+	if err != nil {
+		return err
+	}
+	bytes, ok := val.([]byte)
+	if !ok {
+		return util.Errorf("key %q has value of type %T; expected marshaled proto", key, val)
+	}
+	return gogoproto.Unmarshal(bytes, msg)
+}
+
 // GetInfosAsJSON returns the contents of the infostore, marshalled to
 // JSON.
 func (g *Gossip) GetInfosAsJSON() ([]byte, error) {
@@ -254,16 +305,21 @@ func (g *Gossip) Outgoing() []net.Addr {
 
 // Start launches the gossip instance, which commences joining the
 // gossip network using the supplied rpc server and the gossip
-// bootstrap addresses specified via command-line flag: -gossip.
+// bootstrap addresses specified via command-line flags: -gossip and
+// -gossip_srv.
 //
-// This method starts bootstrap loop, gossip server, and client
-// management in separate goroutines and returns.
+// This method starts bootstrap loop, gossip server, client management
+// and, if -gossip_srv is set, SRV discovery in separate goroutines
+// and returns.
 func (g *Gossip) Start(rpcServer *rpc.Server) {
 	// Start up asynchronous processors.
 	g.server.start(rpcServer) // serve gossip protocol
 	go g.bootstrap()          // bootstrap gossip client
 	go g.manage()             // manage gossip clients
 	go g.maybeWarnAboutInit()
+	if *GossipSRV != "" {
+		go g.discover(*GossipSRV, *GossipSRVInterval) // discover bootstrap hosts
+	}
 }
 
 // Stop shuts down the gossip server. Returns a channel which signals
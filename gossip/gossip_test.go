@@ -22,6 +22,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/util/hlc"
 )
@@ -77,6 +78,57 @@ func TestGossipInfoStore(t *testing.T) {
 	}
 }
 
+// TestGossipInfoProto verifies that proto-typed infos may be added
+// and fetched, and that non-proto values are rejected on fetch.
+func TestGossipInfoProto(t *testing.T) {
+	rpcContext := rpc.NewContext(hlc.NewClock(hlc.UnixNano), rpc.LoadInsecureTLSConfig())
+	g := New(rpcContext)
+	addr := &proto.Addr{Network: "tcp", Address: "localhost:1234"}
+	if err := g.AddInfoProto("a", addr, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	fetched := &proto.Addr{}
+	if err := g.GetInfoProto("a", fetched); err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Network != addr.Network || fetched.Address != addr.Address {
+		t.Errorf("expected %+v; got %+v", addr, fetched)
+	}
+	if err := g.GetInfoProto("b", fetched); err == nil {
+		t.Error("expected error fetching nonexistent key \"b\"")
+	}
+	g.AddInfo("i", int64(1), time.Hour)
+	if err := g.GetInfoProto("i", fetched); err == nil {
+		t.Error("expected error fetching non-proto value as proto")
+	}
+}
+
+// TestGossipInfoStoreManualClock verifies that gossip info
+// expirations are governed by the node's clock.
+func TestGossipInfoStoreManualClock(t *testing.T) {
+	manual := hlc.NewManualClock(1)
+	rpcContext := rpc.NewContext(hlc.NewClock(manual.UnixNano), rpc.LoadInsecureTLSConfig())
+	g := New(rpcContext)
+	g.AddInfo("i", int64(1), time.Second)
+	g.RegisterGroup("g", 3, MinGroup)
+	g.AddInfo("g.1", int64(1), time.Second)
+	if _, err := g.GetInfo("i"); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := g.GetGroupInfos("g"); err != nil || len(values) != 1 {
+		t.Fatalf("expected one group info; got %v (%v)", values, err)
+	}
+
+	// Advance the manual clock past the TTL.
+	manual.Increment(int64(time.Second))
+	if _, err := g.GetInfo("i"); err == nil {
+		t.Errorf("expected info to expire after advancing clock")
+	}
+	if values, _ := g.GetGroupInfos("g"); len(values) != 0 {
+		t.Errorf("expected group info to expire after advancing clock; got %v", values)
+	}
+}
+
 // TestGossipGroupsInfoStore verifies gossiping of groups via the
 // gossip instance infostore.
 func TestGossipGroupsInfoStore(t *testing.T) {
@@ -45,12 +45,13 @@ const (
 // MinGroup, MaxGroup: maintain only minimum/maximum values added
 // to group respectively.
 type group struct {
-	Prefix      string    // Key prefix for Info items in group
-	Limit       int       // Maximum number of keys in group
-	TypeOf      GroupType // Minimums or maximums of all values encountered
-	Infos       infoMap   // Map of infos in group
-	minTTLStamp int64     // Minimum of all infos' TTLs (Unix nanos)
-	gatekeeper  *info     // Minimum or maximum value in infos map, depending on type
+	Prefix      string       // Key prefix for Info items in group
+	Limit       int          // Maximum number of keys in group
+	TypeOf      GroupType    // Minimums or maximums of all values encountered
+	Infos       infoMap      // Map of infos in group
+	minTTLStamp int64        // Minimum of all infos' TTLs (Unix nanos)
+	gatekeeper  *info        // Minimum or maximum value in infos map, depending on type
+	now         func() int64 // Returns current time in Unix nanos; set by infoStore
 }
 
 // groupMap is a map of group prefixes => *group.
@@ -70,6 +71,16 @@ func newGroup(prefix string, limit int, typeOf GroupType) *group {
 	}
 }
 
+// timeNow returns the current time in Unix nanoseconds, according to
+// the clock of the infoStore with which the group is registered or
+// the local wall time if the group is not registered.
+func (g *group) timeNow() int64 {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now().UnixNano()
+}
+
 // shouldInclude returns true if the specified info should belong
 // in the group according to the group type and the value.
 func (g *group) shouldInclude(i *info) bool {
@@ -115,7 +126,7 @@ func (g *group) update() {
 // compact compacts the group infos slice by removing expired info objects.
 // Returns true if compaction occurred and space is free.
 func (g *group) compact() bool {
-	now := time.Now().UnixNano()
+	now := g.timeNow()
 	if g.minTTLStamp > now {
 		return false
 	}
@@ -152,7 +163,7 @@ func (g *group) removeInternal(i *info) {
 func (g *group) getInfo(key string) *info {
 	if i, ok := g.Infos[key]; ok {
 		// Check TTL and discard if too old.
-		now := time.Now().UnixNano()
+		now := g.timeNow()
 		if i.TTLStamp <= now {
 			g.removeInternal(i)
 			return nil
@@ -166,7 +177,7 @@ func (g *group) getInfo(key string) *info {
 // sort order is dependent on group type (MinGroup: ascending,
 // MaxGroup: descending).
 func (g *group) infosAsSlice() infoSlice {
-	now := time.Now().UnixNano()
+	now := g.timeNow()
 	infos := make(infoSlice, 0, len(g.Infos))
 	for _, i := range g.Infos {
 		// Check TTL and discard if too old.
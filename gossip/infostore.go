@@ -28,6 +28,8 @@ import (
 	"time"
 
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
+	"github.com/cockroachdb/cockroach/util/log"
 )
 
 // callback holds regexp pattern match and GossipCallback method.
@@ -52,7 +54,10 @@ type infoStore struct {
 	NodeAddr  net.Addr `json:"-"`                // Address of node owning this info store: "host:port"
 	MaxSeq    int64    `json:"-"`                // Maximum sequence number inserted
 	seqGen    int64    // Sequence generator incremented each time info is added
+	maxInfos  int      // Maximum number of non-group infos; 0 for no limit
 	callbacks []callback
+	clock     *hlc.Clock // Optional clock; local wall time is used if nil
+	lastTime  int64      // Last time returned by monotonicNow, if clock is set
 }
 
 // monotonicUnixNano returns a monotonically increasing value for
@@ -73,6 +78,29 @@ func monotonicUnixNano() int64 {
 	return now
 }
 
+// now returns the current time in Unix nanoseconds according to the
+// infoStore's clock, or the local wall time if no clock is set.
+func (is *infoStore) now() int64 {
+	if is.clock != nil {
+		return is.clock.PhysicalNow()
+	}
+	return time.Now().UnixNano()
+}
+
+// monotonicNow is like monotonicUnixNano, but uses the infoStore's
+// clock if one is set.
+func (is *infoStore) monotonicNow() int64 {
+	if is.clock == nil {
+		return monotonicUnixNano()
+	}
+	now := is.clock.PhysicalNow()
+	if now <= is.lastTime {
+		now = is.lastTime + 1
+	}
+	is.lastTime = now
+	return now
+}
+
 // String returns a string representation of an infostore.
 func (is *infoStore) String() string {
 	buf := bytes.Buffer{}
@@ -119,6 +147,7 @@ func newInfoStore(nodeAddr net.Addr) *infoStore {
 		Infos:    infoMap{},
 		Groups:   groupMap{},
 		NodeAddr: nodeAddr,
+		maxInfos: *GossipMaxInfos,
 	}
 }
 
@@ -126,7 +155,7 @@ func newInfoStore(nodeAddr net.Addr) *infoStore {
 // value, and time-to-live.
 func (is *infoStore) newInfo(key string, val interface{}, ttl time.Duration) *info {
 	is.seqGen++
-	now := monotonicUnixNano()
+	now := is.monotonicNow()
 	ttlStamp := now + int64(ttl)
 	if ttl == 0*time.Second {
 		ttlStamp = math.MaxInt64
@@ -149,7 +178,7 @@ func (is *infoStore) getInfo(key string) *info {
 	}
 	if info, ok := is.Infos[key]; ok {
 		// Check TTL and discard if too old.
-		if info.expired(time.Now().UnixNano()) {
+		if info.expired(is.now()) {
 			delete(is.Infos, key)
 			return nil
 		}
@@ -181,6 +210,7 @@ func (is *infoStore) registerGroup(g *group) error {
 		}
 		return nil
 	}
+	g.now = is.now
 	is.Groups[g.Prefix] = g
 	return nil
 }
@@ -214,6 +244,10 @@ func (is *infoStore) addInfo(i *info) error {
 		}
 		contentsChanged = !reflect.DeepEqual(existingInfo.Val, i.Val)
 	} else {
+		// Make room for the new info if the infostore is full.
+		if err := is.maybeEvict(i); err != nil {
+			return err
+		}
 		// No preexisting info means contentsChanged is true.
 		contentsChanged = true
 	}
@@ -226,6 +260,47 @@ func (is *infoStore) addInfo(i *info) error {
 	return nil
 }
 
+// maybeEvict makes room for the new, non-group info i if the number
+// of non-group infos has reached maxInfos. Expired infos are removed
+// first. If the store is still full, the oldest info which is not
+// critical (see isCriticalKey) is evicted, unless i is itself
+// non-critical and older, in which case an error is returned and i
+// should not be added. Critical infos are always admitted, even if
+// the store is full of critical infos.
+func (is *infoStore) maybeEvict(i *info) error {
+	if is.maxInfos <= 0 || len(is.Infos) < is.maxInfos {
+		return nil
+	}
+	now := is.now()
+	for key, existing := range is.Infos {
+		if existing.expired(now) {
+			delete(is.Infos, key)
+		}
+	}
+	if len(is.Infos) < is.maxInfos {
+		return nil
+	}
+	var oldest *info
+	for _, existing := range is.Infos {
+		if isCriticalKey(existing.Key) {
+			continue
+		}
+		if oldest == nil || existing.Timestamp < oldest.Timestamp {
+			oldest = existing
+		}
+	}
+	critical := isCriticalKey(i.Key)
+	if oldest == nil || (!critical && i.Timestamp < oldest.Timestamp) {
+		if critical {
+			return nil
+		}
+		return util.Errorf("infostore is full (%d infos); unable to add info %q", len(is.Infos), i.Key)
+	}
+	log.V(1).Infof("infostore is full (%d infos); evicting info %q", len(is.Infos), oldest.Key)
+	delete(is.Infos, oldest.Key)
+	return nil
+}
+
 // infoCount returns the count of infos stored in groups and the
 // non-group infos map. This is really just an approximation as
 // we don't check whether infos are expired.
@@ -284,7 +359,7 @@ func (is *infoStore) processCallbacks(key string, contentsChanged bool) {
 // visitied, the visitInfo function is run against each non-group info
 // in turn. Be sure to skip over any expired infos.
 func (is *infoStore) visitInfos(visitGroup func(*group) error, visitInfo func(*info) error) error {
-	now := time.Now().UnixNano()
+	now := is.now()
 	for _, g := range is.Groups {
 		if visitGroup != nil {
 			if err := visitGroup(g); err != nil {
@@ -361,6 +436,7 @@ func (is *infoStore) delta(addr net.Addr, seq int64) *infoStore {
 	}
 
 	delta := newInfoStore(is.NodeAddr)
+	delta.clock = is.clock
 
 	// Compute delta of groups and infos.
 	is.visitInfos(func(g *group) error {
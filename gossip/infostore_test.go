@@ -564,3 +564,51 @@ func TestCallbacks(t *testing.T) {
 		t.Errorf("expected %v, got %v", expKeys, cbAll.Keys())
 	}
 }
+
+// TestInfoStoreEviction verifies that a full infostore evicts the
+// oldest non-critical infos and always admits critical infos.
+func TestInfoStoreEviction(t *testing.T) {
+	is := newInfoStore(emptyAddr)
+	is.maxInfos = 3
+
+	old := is.newInfo("old", int64(1), time.Hour)
+	for _, i := range []*info{
+		old,
+		is.newInfo(KeyClusterID, "cluster", time.Hour),
+		is.newInfo("new", int64(2), time.Hour),
+	} {
+		if err := is.addInfo(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Adding a fourth info evicts the oldest non-critical info.
+	if err := is.addInfo(is.newInfo("newer", int64(3), time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if is.getInfo("old") != nil {
+		t.Error("expected oldest info to be evicted")
+	}
+	if is.getInfo(KeyClusterID) == nil || is.getInfo("new") == nil || is.getInfo("newer") == nil {
+		t.Error("expected critical and newer infos to be retained")
+	}
+
+	// A non-critical info older than all existing infos is rejected.
+	if err := is.addInfo(old); err == nil {
+		t.Error("expected error adding info older than all existing infos")
+	}
+
+	// Replace remaining infos with critical infos; critical infos are
+	// admitted even when no info can be evicted.
+	for _, key := range []string{KeyFirstRangeDescriptor, MakeNodeIDGossipKey(1), MakeNodeIDGossipKey(2)} {
+		if err := is.addInfo(is.newInfo(key, "value", time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := len(is.Infos); count != 4 {
+		t.Errorf("expected 4 critical infos; got %d", count)
+	}
+	if err := is.addInfo(is.newInfo("other", int64(4), time.Hour)); err == nil {
+		t.Error("expected error adding non-critical info to store full of critical infos")
+	}
+}
@@ -17,10 +17,19 @@
 
 package gossip
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+)
 
 // Constants for gossip keys.
 const (
+	// KeyBuildPrefix is the key prefix for gossiping the build each
+	// node is running. The actual key is suffixed with the hexadecimal
+	// representation of the node id and the value is the string
+	// returned by build.Info.Short().
+	KeyBuildPrefix = "build-"
+
 	// KeyClusterID is the unique UUID for this Cockroach cluster.
 	// The value is a string UUID for the cluster.
 	KeyClusterID = "cluster-id"
@@ -39,6 +48,11 @@ const (
 	// The value is a storage.StoreDescriptor struct.
 	KeyMaxAvailCapacityPrefix = "max-avail-capacity-"
 
+	// KeyStoreSummaryPrefix is the key prefix for gossiping a summary
+	// of each store's ranges, size and load. The suffix is composed of:
+	// <node ID>-<store ID>. The value is a storage.StoreSummary struct.
+	KeyStoreSummaryPrefix = "store-summary-"
+
 	// KeyNodeCount is the count of gossip nodes in the network. The
 	// value is an int64 containing the count of nodes in the cluster.
 	// TODO(spencer): should remove this and instead just count the
@@ -62,7 +76,33 @@ const (
 	KeyFirstRangeDescriptor = "first-range"
 )
 
+// criticalKeys is the set of gossip keys which are never evicted
+// from a full infostore.
+var criticalKeys = map[string]struct{}{
+	KeyClusterID:            struct{}{},
+	KeyConfigAccounting:     struct{}{},
+	KeyConfigPermission:     struct{}{},
+	KeyConfigZone:           struct{}{},
+	KeyNodeCount:            struct{}{},
+	KeyFirstRangeDescriptor: struct{}{},
+}
+
+// isCriticalKey returns true if the gossip key is required for the
+// correct operation of the cluster. Critical infos, which include
+// node addresses, are preserved when the infostore evicts infos.
+func isCriticalKey(key string) bool {
+	if _, ok := criticalKeys[key]; ok {
+		return true
+	}
+	return strings.HasPrefix(key, KeyNodeIDPrefix)
+}
+
 // MakeNodeIDGossipKey returns the gossip key for node ID info.
 func MakeNodeIDGossipKey(nodeID int32) string {
 	return KeyNodeIDPrefix + strconv.FormatInt(int64(nodeID), 16)
 }
+
+// MakeBuildGossipKey returns the gossip key for a node's build info.
+func MakeBuildGossipKey(nodeID int32) string {
+	return KeyBuildPrefix + strconv.FormatInt(int64(nodeID), 16)
+}
@@ -121,12 +121,13 @@ func (s *server) jitteredGossipInterval() time.Duration {
 	return time.Duration(float64(s.interval) * (0.75 + 0.5*rand.Float64()))
 }
 
-// start initializes the infostore with the rpc server address and
-// then begins processing connecting clients in an infinite select
-// loop via goroutine. Periodically, clients connected and awaiting
-// the next round of gossip are awoken via the conditional variable.
+// start initializes the infostore with the rpc server's advertised
+// address and then begins processing connecting clients in an
+// infinite select loop via goroutine. Periodically, clients connected
+// and awaiting the next round of gossip are awoken via the conditional
+// variable.
 func (s *server) start(rpcServer *rpc.Server) {
-	s.is.NodeAddr = rpcServer.Addr()
+	s.is.NodeAddr = rpcServer.AdvertiseAddr()
 	if err := rpcServer.RegisterName("Gossip", s); err != nil {
 		log.Fatalf("unable to register gossip service with RPC server: %s", err)
 	}
@@ -0,0 +1,123 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package kv
+
+import (
+	"math/rand"
+	"net/http"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+	"github.com/cockroachdb/cockroach/util/hlc"
+	"github.com/cockroachdb/cockroach/util/log"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// An AuditLog records administrative and permission-changing
+// operations to an append-only keyspace. Each event is written with a
+// ConditionalPut which requires that its key not exist, so events are
+// never overwritten. Ranges reject any other writes to the audit log,
+// and the DistSender additionally rejects access by users other than
+// root.
+type AuditLog struct {
+	db    *client.KV
+	clock *hlc.Clock
+}
+
+// NewAuditLog returns an AuditLog which records events through the
+// supplied sender as the root user, timestamped by the supplied clock.
+func NewAuditLog(sender client.KVSender, clock *hlc.Clock) *AuditLog {
+	db := client.NewKV(sender, nil)
+	db.User = storage.UserRoot
+	return &AuditLog{db: db, clock: clock}
+}
+
+// Record writes the event to the audit log. If the event's timestamp
+// is unset, it's set to the wall time of the audit log's clock.
+func (a *AuditLog) Record(event *proto.AuditEvent) error {
+	if event.TimestampNanos == 0 {
+		event.TimestampNanos = a.clock.Now().WallTime
+	}
+	data, err := gogoproto.Marshal(event)
+	if err != nil {
+		return err
+	}
+	key := engine.AuditLogKey(event.TimestampNanos, encoding.EncodeUint64(nil, uint64(rand.Int63())))
+	value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}}
+	value.InitChecksum(key)
+	return a.db.Call(proto.ConditionalPut, &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		Value:         value,
+	}, &proto.ConditionalPutResponse{})
+}
+
+// RecordRequest records the outcome of an administrative HTTP
+// request. The origin is the request's remote address. Failures to
+// record are logged.
+func (a *AuditLog) RecordRequest(r *http.Request, user, action, target string, details []byte, err error) {
+	event := &proto.AuditEvent{
+		User:    user,
+		Origin:  r.RemoteAddr,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if err := a.Record(event); err != nil {
+		log.Errorf("unable to record audit event %+v: %s", event, err)
+	}
+}
+
+// Scan returns up to maxResults events recorded at or after start and
+// before end, both in wall time nanoseconds, in the order they were
+// recorded. A maxResults of zero returns all events.
+func (a *AuditLog) Scan(start, end, maxResults int64) ([]proto.AuditEvent, error) {
+	reply := &proto.ScanResponse{}
+	if err := a.db.Call(proto.Scan, proto.ScanArgs(engine.AuditLogKey(start, nil),
+		engine.AuditLogKey(end, nil), maxResults), reply); err != nil {
+		return nil, err
+	}
+	events := make([]proto.AuditEvent, len(reply.Rows))
+	for i, row := range reply.Rows {
+		if err := gogoproto.Unmarshal(row.Value.Bytes, &events[i]); err != nil {
+			return nil, util.Errorf("unable to unmarshal audit event at %s: %s", row.Key, err)
+		}
+	}
+	return events, nil
+}
+
+// verifyAuditLogAccess verifies that the request may access the audit
+// log: only root may access it, and the only permitted write is a
+// ConditionalPut of a new event. Requests which don't touch the audit
+// log are always permitted. Ranges enforce the latter restriction
+// themselves; checking here fails such requests before they're sent.
+func verifyAuditLogAccess(method string, args proto.Request) error {
+	header := args.Header()
+	if !storage.AuditLogSpan.Overlaps(proto.KeyRange{Start: header.Key, End: header.EndKey}) {
+		return nil
+	}
+	if header.User != storage.UserRoot {
+		return util.Errorf("user %q cannot invoke %s on the audit log", header.User, method)
+	}
+	return storage.VerifyAuditLogWrite(method, args)
+}
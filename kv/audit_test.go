@@ -0,0 +1,105 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package kv
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestAuditLogRecordAndScan verifies that recorded events are
+// returned in time order and bounded by the scan's time range.
+func TestAuditLogRecordAndScan(t *testing.T) {
+	db, _, clock, _, ls, err := createTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer ls.Close()
+
+	audit := NewAuditLog(db.Sender(), clock)
+	for _, nanos := range []int64{3, 1, 2, 2} {
+		if err := audit.Record(&proto.AuditEvent{
+			TimestampNanos: nanos,
+			User:           storage.UserRoot,
+			Action:         proto.AdminSplit,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		start, end, max int64
+		expNanos        []int64
+	}{
+		{0, 10, 0, []int64{1, 2, 2, 3}},
+		{2, 3, 0, []int64{2, 2}},
+		{0, 10, 2, []int64{1, 2}},
+		{4, 10, 0, []int64{}},
+	}
+	for i, test := range testCases {
+		events, err := audit.Scan(test.start, test.end, test.max)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(events) != len(test.expNanos) {
+			t.Fatalf("%d: expected %d events; got %+v", i, len(test.expNanos), events)
+		}
+		for j, e := range events {
+			if e.TimestampNanos != test.expNanos[j] || e.Action != proto.AdminSplit {
+				t.Errorf("%d: expected event at %d; got %+v", i, test.expNanos[j], e)
+			}
+		}
+	}
+}
+
+// TestVerifyAuditLogAccess verifies that only root may access the
+// audit log and that it may only be appended to.
+func TestVerifyAuditLogAccess(t *testing.T) {
+	auditKey := engine.AuditLogKey(1, nil)
+	testCases := []struct {
+		method string
+		args   proto.Request
+		user   string
+		key    proto.Key
+		endKey proto.Key
+		expOK  bool
+	}{
+		{proto.ConditionalPut, &proto.ConditionalPutRequest{}, storage.UserRoot, auditKey, nil, true},
+		{proto.Scan, &proto.ScanRequest{}, storage.UserRoot, engine.KeyMin, engine.KeyMax, true},
+		{proto.ConditionalPut, &proto.ConditionalPutRequest{ExpValue: &proto.Value{}}, storage.UserRoot, auditKey, nil, false},
+		{proto.Put, &proto.PutRequest{}, storage.UserRoot, auditKey, nil, false},
+		{proto.Delete, &proto.DeleteRequest{}, storage.UserRoot, auditKey, nil, false},
+		{proto.DeleteRange, &proto.DeleteRangeRequest{}, storage.UserRoot, engine.KeyMin, engine.KeyMax, false},
+		{proto.ConditionalPut, &proto.ConditionalPutRequest{}, "user", auditKey, nil, false},
+		{proto.Scan, &proto.ScanRequest{}, "user", engine.KeyMin, engine.KeyMax, false},
+		{proto.Get, &proto.GetRequest{}, "user", auditKey, nil, false},
+		// Requests which don't touch the audit log are unaffected.
+		{proto.Put, &proto.PutRequest{}, "user", proto.Key("a"), nil, true},
+		{proto.DeleteRange, &proto.DeleteRangeRequest{}, "user", proto.Key("a"), proto.Key("b"), true},
+		{proto.Scan, &proto.ScanRequest{}, "user", engine.KeyConfigPermissionPrefix, engine.KeySystemMax, true},
+	}
+	for i, test := range testCases {
+		header := test.args.Header()
+		header.User, header.Key, header.EndKey = test.user, test.key, test.endKey
+		if err := verifyAuditLogAccess(test.method, test.args); (err == nil) != test.expOK {
+			t.Errorf("%d: expected ok %t; got %v", i, test.expOK, err)
+		}
+	}
+}
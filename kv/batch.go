@@ -0,0 +1,201 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package kv
+
+import (
+	"sync"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// isNonTxnBatch returns true if the call is a batch which isn't part
+// of a transaction. Such batches are split by range by the senders
+// which address ranges; transactional batches are unrolled by the
+// TxnCoordSender, except for one-phase commits.
+func isNonTxnBatch(call *client.Call) bool {
+	bArgs, ok := call.Args.(*proto.BatchRequest)
+	return ok && bArgs.Txn == nil
+}
+
+// sendBatchByRange sends a non-transactional batch, grouping its
+// requests by the range which contains them. The requests of each
+// group are sent together as a batch addressed to their range, which
+// executes them atomically. Requests which are alone in their range,
+// or for which lookup fails (e.g. because they span ranges), are sent
+// individually. Groups are sent in parallel, and the responses are
+// added to the batch reply in request order. The batch reply carries
+// the first error, in request order, if any.
+//
+// A group's batch may be addressed using a stale lookup, as happens
+// when its range splits between grouping and sending, in which case
+// it fails without executing any of its requests. If the requests of
+// a failed batch no longer share a range, they're resent individually.
+//
+// lookup returns the ID of the range containing the key range from
+// key to endKey. send sends a call addressed to a single range.
+func sendBatchByRange(call *client.Call, lookup func(key, endKey proto.Key) (int64, error),
+	send func(*client.Call)) {
+	bArgs := call.Args.(*proto.BatchRequest)
+	bReply := call.Reply.(*proto.BatchResponse)
+
+	// Unroll the batch, initializing args header values from the
+	// batch header where unset, and group the calls by range.
+	calls := make([]*client.Call, 0, len(bArgs.Requests))
+	groups := map[int64][]int{}
+	var raftIDs []int64
+	var unaddressed []int
+	for i := range bArgs.Requests {
+		args := bArgs.Requests[i].GetValue().(proto.Request)
+		method, err := proto.MethodForRequest(args)
+		if err != nil {
+			bReply.SetGoError(err)
+			return
+		}
+		reply, err := proto.CreateReply(method)
+		if err != nil {
+			bReply.SetGoError(util.Errorf("unsupported method in batch: %s", method))
+			return
+		}
+		header := args.Header()
+		if header.User == "" {
+			header.User = bArgs.User
+		}
+		if header.UserPriority == nil {
+			header.UserPriority = bArgs.UserPriority
+		}
+		if header.Timestamp.Equal(proto.ZeroTimestamp) {
+			header.Timestamp = bArgs.Timestamp
+		}
+		if header.MinTimestamp.Equal(proto.ZeroTimestamp) {
+			header.MinTimestamp = bArgs.MinTimestamp
+		}
+		calls = append(calls, &client.Call{Method: method, Args: args, Reply: reply})
+
+		raftID, err := lookup(header.Key, header.EndKey)
+		if err != nil {
+			unaddressed = append(unaddressed, i)
+			continue
+		}
+		if _, ok := groups[raftID]; !ok {
+			raftIDs = append(raftIDs, raftID)
+		}
+		groups[raftID] = append(groups[raftID], i)
+	}
+
+	// Create a batch for each group with more than one request.
+	var sends []*client.Call
+	subBatches := map[*client.Call][]int{}
+	for _, i := range unaddressed {
+		sends = append(sends, calls[i])
+	}
+	for _, raftID := range raftIDs {
+		group := groups[raftID]
+		if len(group) == 1 {
+			sends = append(sends, calls[group[0]])
+			continue
+		}
+		args := &proto.BatchRequest{RequestHeader: bArgs.RequestHeader}
+		args.Key, args.EndKey = nil, nil
+		for _, i := range group {
+			header := calls[i].Args.Header()
+			endKey := header.EndKey
+			if endKey == nil {
+				endKey = header.Key.Next()
+			}
+			if args.Key == nil || header.Key.Less(args.Key) {
+				args.Key = header.Key
+			}
+			if args.EndKey == nil || args.EndKey.Less(endKey) {
+				args.EndKey = endKey
+			}
+			args.Add(calls[i].Args)
+		}
+		batchCall := &client.Call{Method: proto.Batch, Args: args, Reply: &proto.BatchResponse{}}
+		subBatches[batchCall] = group
+		sends = append(sends, batchCall)
+	}
+
+	// Send calls in parallel and wait for all to complete.
+	sendAll := func(sends []*client.Call) {
+		wg := sync.WaitGroup{}
+		wg.Add(len(sends))
+		for _, c := range sends {
+			go func(c *client.Call) {
+				send(c)
+				wg.Done()
+			}(c)
+		}
+		wg.Wait()
+	}
+	sendAll(sends)
+
+	// Resend the requests of failed batches individually if they no
+	// longer share a range.
+	sharesRange := func(group []int) bool {
+		var raftID int64
+		for j, i := range group {
+			header := calls[i].Args.Header()
+			id, err := lookup(header.Key, header.EndKey)
+			if err != nil || (j > 0 && id != raftID) {
+				return false
+			}
+			raftID = id
+		}
+		return true
+	}
+	var resends []*client.Call
+	for batchCall, group := range subBatches {
+		if batchCall.Reply.Header().GoError() == nil || sharesRange(group) {
+			continue
+		}
+		delete(subBatches, batchCall)
+		for _, i := range group {
+			resends = append(resends, calls[i])
+		}
+	}
+	sendAll(resends)
+
+	// Transfer the responses of each batch to the individual replies.
+	// If a batch failed, none of its requests were executed.
+	for batchCall, group := range subBatches {
+		reply := batchCall.Reply.(*proto.BatchResponse)
+		err := reply.GoError()
+		if err == nil && len(reply.Responses) != len(group) {
+			err = util.Errorf("expected %d responses to batch; got %d", len(group), len(reply.Responses))
+		}
+		for j, i := range group {
+			calls[i].Reply.Reset()
+			if err != nil {
+				calls[i].Reply.Header().SetGoError(err)
+				continue
+			}
+			gogoproto.Merge(calls[i].Reply.(gogoproto.Message), reply.Responses[j].GetValue().(gogoproto.Message))
+		}
+	}
+
+	for _, c := range calls {
+		header := c.Reply.Header()
+		if bReply.Error == nil {
+			bReply.Error = header.Error
+		}
+		bReply.Timestamp.Forward(header.Timestamp)
+		bReply.Add(c.Reply)
+	}
+}
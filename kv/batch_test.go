@@ -0,0 +1,196 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package kv
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// TestSendBatchByRange verifies that a non-transactional batch is
+// split into one batch per range, that requests which are alone in
+// their range or span ranges are sent individually, and that the
+// responses are returned in request order.
+func TestSendBatchByRange(t *testing.T) {
+	lookup := func(key, endKey proto.Key) (int64, error) {
+		raftID := int64(1)
+		if !key.Less(proto.Key("m")) {
+			raftID = 2
+		}
+		if endKey != nil && raftID == 1 && proto.Key("m").Less(endKey) {
+			return 0, util.Errorf("key range %q-%q spans ranges", key, endKey)
+		}
+		return raftID, nil
+	}
+	var mu sync.Mutex
+	methods := map[string]int{}
+	send := func(call *client.Call) {
+		mu.Lock()
+		defer mu.Unlock()
+		methods[call.Method]++
+		if call.Method == proto.Put && call.Args.Header().Key.Equal(proto.Key("x")) {
+			call.Reply.Header().SetGoError(util.Errorf("injected error"))
+			return
+		}
+		bArgs, ok := call.Args.(*proto.BatchRequest)
+		if !ok {
+			return
+		}
+		if n := len(bArgs.Requests); n != 2 {
+			t.Errorf("expected batch of 2 requests; got %d", n)
+		}
+		if !bArgs.Key.Equal(proto.Key("a")) || !bArgs.EndKey.Equal(proto.Key("b").Next()) {
+			t.Errorf("expected batch to span \"a\"-\"b\x00\"; got %q-%q", bArgs.Key, bArgs.EndKey)
+		}
+		bReply := call.Reply.(*proto.BatchResponse)
+		for i := range bArgs.Requests {
+			reply := &proto.GetResponse{Value: &proto.Value{ValuePayload: proto.ValuePayload{Bytes: bArgs.Requests[i].GetGet().Key}}}
+			bReply.Add(reply)
+		}
+	}
+
+	bArgs, bReply := &proto.BatchRequest{}, &proto.BatchResponse{}
+	bArgs.Add(proto.GetArgs(proto.Key("a")))
+	bArgs.Add(proto.PutArgs(proto.Key("x"), []byte("value")))
+	bArgs.Add(proto.ScanArgs(proto.Key("c"), proto.Key("z"), 0))
+	bArgs.Add(proto.GetArgs(proto.Key("b")))
+	sendBatchByRange(&client.Call{Method: proto.Batch, Args: bArgs, Reply: bReply}, lookup, send)
+
+	if methods[proto.Batch] != 1 || methods[proto.Put] != 1 || methods[proto.Scan] != 1 {
+		t.Errorf("expected one batch, put and scan to be sent; got %v", methods)
+	}
+	if len(bReply.Responses) != 4 {
+		t.Fatalf("expected 4 responses; got %d", len(bReply.Responses))
+	}
+	for _, i := range []int{0, 3} {
+		gReply := bReply.Responses[i].GetGet()
+		if expKey := bArgs.Requests[i].GetGet().Key; gReply.Value == nil || !bytes.Equal(gReply.Value.Bytes, expKey) {
+			t.Errorf("%d: expected value %q; got %+v", i, expKey, gReply.Value)
+		}
+	}
+	if bReply.Responses[1].GetPut().GoError() == nil || bReply.GoError() == nil {
+		t.Errorf("expected the put's error to be returned; got %v", bReply.GoError())
+	}
+}
+
+// TestSendBatchByRangeSplit verifies that when a range splits
+// between grouping and sending, the requests of the batch addressed
+// to it are resent individually, while a failed batch whose requests
+// still share a range returns its error.
+func TestSendBatchByRangeSplit(t *testing.T) {
+	var mu sync.Mutex
+	split := false
+	lookup := func(key, endKey proto.Key) (int64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case !key.Less(proto.Key("m")):
+			return 3, nil
+		case split && !key.Less(proto.Key("b")):
+			return 2, nil
+		}
+		return 1, nil
+	}
+	methods := map[string]int{}
+	send := func(call *client.Call) {
+		mu.Lock()
+		defer mu.Unlock()
+		methods[call.Method]++
+		bArgs, ok := call.Args.(*proto.BatchRequest)
+		if !ok {
+			reply := call.Reply.(*proto.GetResponse)
+			reply.Value = &proto.Value{ValuePayload: proto.ValuePayload{Bytes: call.Args.Header().Key}}
+			return
+		}
+		if bArgs.Key.Less(proto.Key("m")) {
+			// Range 1 splits at "b" once the batch has been grouped.
+			split = true
+			call.Reply.Header().SetGoError(proto.NewRangeKeyMismatchError(bArgs.Key, bArgs.EndKey, nil))
+			return
+		}
+		call.Reply.Header().SetGoError(util.Errorf("injected error"))
+	}
+
+	bArgs, bReply := &proto.BatchRequest{}, &proto.BatchResponse{}
+	for _, key := range []string{"a", "b", "x", "y"} {
+		bArgs.Add(proto.GetArgs(proto.Key(key)))
+	}
+	sendBatchByRange(&client.Call{Method: proto.Batch, Args: bArgs, Reply: bReply}, lookup, send)
+
+	if methods[proto.Batch] != 2 || methods[proto.Get] != 2 {
+		t.Errorf("expected two batches and two resent gets; got %v", methods)
+	}
+	if len(bReply.Responses) != 4 {
+		t.Fatalf("expected 4 responses; got %d", len(bReply.Responses))
+	}
+	for i := 0; i < 2; i++ {
+		gReply := bReply.Responses[i].GetGet()
+		if expKey := bArgs.Requests[i].GetGet().Key; gReply.GoError() != nil || gReply.Value == nil ||
+			!bytes.Equal(gReply.Value.Bytes, expKey) {
+			t.Errorf("%d: expected value %q; got %+v", i, expKey, gReply)
+		}
+	}
+	for i := 2; i < 4; i++ {
+		if bReply.Responses[i].GetGet().GoError() == nil {
+			t.Errorf("%d: expected the failed batch's error", i)
+		}
+	}
+	if bReply.GoError() == nil {
+		t.Error("expected the failed batch's error to be returned")
+	}
+}
+
+// TestNonTxnBatchAcrossRanges verifies that a non-transactional
+// batch whose requests address two ranges is executed.
+func TestNonTxnBatchAcrossRanges(t *testing.T) {
+	db, _, _, _, ls, err := createTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer ls.Close()
+
+	splitKey := proto.Key("m")
+	req := &proto.AdminSplitRequest{RequestHeader: proto.RequestHeader{Key: splitKey}, SplitKey: splitKey}
+	if err := db.Call(proto.AdminSplit, req, &proto.AdminSplitResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []proto.Key{proto.Key("a"), proto.Key("b"), proto.Key("x"), proto.Key("y")}
+	for _, key := range keys {
+		db.Prepare(proto.Put, proto.PutArgs(key, []byte("value")), &proto.PutResponse{})
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	replies := make([]proto.GetResponse, len(keys))
+	for i, key := range keys {
+		db.Prepare(proto.Get, proto.GetArgs(key), &replies[i])
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i, reply := range replies {
+		if reply.Value == nil || !bytes.Equal(reply.Value.Bytes, []byte("value")) {
+			t.Errorf("expected value at %q; got %+v", keys[i], reply.Value)
+		}
+	}
+}
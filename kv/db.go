@@ -18,6 +18,7 @@
 package kv
 
 import (
+	"flag"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -25,6 +26,7 @@ import (
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
 )
 
 const (
@@ -35,9 +37,25 @@ const (
 
 var allowedEncodings = []util.EncodingType{util.JSONEncoding, util.ProtoEncoding}
 
+var (
+	maxKeySize = flag.Int64("max_key_size", proto.DefaultRequestLimits.MaxKeySize,
+		"maximum size in bytes of keys in requests to the KV API; 0 for no limit")
+	maxValueSize = flag.Int64("max_value_size", proto.DefaultRequestLimits.MaxValueSize,
+		"maximum size in bytes of values in requests to the KV API; 0 for no limit")
+	maxBatchSize = flag.Int64("max_batch_size", proto.DefaultRequestLimits.MaxBatchSize,
+		"maximum total size in bytes of the keys and values of batches sent to "+
+			"the KV API; 0 for no limit")
+)
+
 // verifyRequest checks for illegal inputs in request proto and
 // returns an error indicating which, if any, were found.
 func verifyRequest(args proto.Request) error {
+	// Routing information is set by the senders which address the
+	// request to a range; clients may not specify it.
+	if args.Header().HasRouting() {
+		return util.Errorf("%T from public KV API contains routing information: raft ID %d, replica %+v",
+			args, args.Header().RaftID, args.Header().Replica)
+	}
 	switch t := args.(type) {
 	case *proto.EndTransactionRequest:
 		if t.SplitTrigger != nil {
@@ -51,11 +69,23 @@ func verifyRequest(args proto.Request) error {
 // It accepts either JSON or serialized protobuf content types.
 type DBServer struct {
 	sender client.KVSender
+	limits proto.RequestLimits
+	audit  *AuditLog
 }
 
-// NewDBServer allocates and returns a new DBServer.
-func NewDBServer(sender client.KVSender) *DBServer {
-	return &DBServer{sender: sender}
+// NewDBServer allocates and returns a new DBServer. Requests are
+// subject to the size limits specified via flags. Admin commands are
+// recorded to the audit log, timestamped by the supplied clock.
+func NewDBServer(sender client.KVSender, clock *hlc.Clock) *DBServer {
+	return &DBServer{
+		sender: sender,
+		audit:  NewAuditLog(sender, clock),
+		limits: proto.RequestLimits{
+			MaxKeySize:   *maxKeySize,
+			MaxValueSize: *maxValueSize,
+			MaxBatchSize: *maxBatchSize,
+		},
+	}
 }
 
 // ServeHTTP serves the key-value API by treating the request URL path
@@ -101,13 +131,23 @@ func (s *DBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create a call and invoke through sender.
-	call := &client.Call{
-		Method: method,
-		Args:   args,
-		Reply:  reply,
+	// Reject oversized requests with a structured error, before they
+	// are proposed to raft.
+	if err := s.limits.Verify(args); err != nil {
+		reply.Header().SetGoError(err)
+	} else {
+		// Create a call and invoke through sender.
+		call := &client.Call{
+			Method: method,
+			Args:   args,
+			Reply:  reply,
+		}
+		s.sender.Send(call)
+	}
+	if proto.NeedAdminPerm(method) {
+		s.audit.RecordRequest(r, args.Header().User, method, string(args.Header().Key),
+			reqBody, reply.Header().GoError())
 	}
-	s.sender.Send(call)
 
 	// Marshal the response.
 	body, contentType, err := util.MarshalResponse(r, reply, allowedEncodings)
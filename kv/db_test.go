@@ -20,6 +20,7 @@ package kv_test
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"net/http"
 	"testing"
 
@@ -50,7 +51,7 @@ func TestKVDBCoverage(t *testing.T) {
 	value3 := []byte("value3")
 
 	// Put first value at key.
-	putReq := &proto.PutRequest{Value: proto.Value{Bytes: value1}}
+	putReq := &proto.PutRequest{Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: value1}}}
 	putReq.Key = key
 	putResp := &proto.PutResponse{}
 	if err := kvClient.Call(proto.Put, putReq, putResp); err != nil || putResp.Error != nil {
@@ -70,8 +71,8 @@ func TestKVDBCoverage(t *testing.T) {
 
 	// Conditional put should succeed, changing value1 to value2.
 	cPutReq := &proto.ConditionalPutRequest{
-		Value:    proto.Value{Bytes: value2},
-		ExpValue: &proto.Value{Bytes: value1},
+		Value:    proto.Value{ValuePayload: proto.ValuePayload{Bytes: value2}},
+		ExpValue: &proto.Value{ValuePayload: proto.ValuePayload{Bytes: value1}},
 	}
 	cPutReq.Key = key
 	cPutResp := &proto.ConditionalPutResponse{}
@@ -117,9 +118,9 @@ func TestKVDBCoverage(t *testing.T) {
 
 	// Put values in anticipation of scan & delete range.
 	keyValues := []proto.KeyValue{
-		{Key: proto.Key("a"), Value: proto.Value{Bytes: value1}},
-		{Key: proto.Key("b"), Value: proto.Value{Bytes: value2}},
-		{Key: proto.Key("c"), Value: proto.Value{Bytes: value3}},
+		{Key: proto.Key("a"), Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: value1}}},
+		{Key: proto.Key("b"), Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: value2}}},
+		{Key: proto.Key("c"), Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: value3}}},
 	}
 	for _, kv := range keyValues {
 		putReq.Key, putReq.Value = kv.Key, kv.Value
@@ -211,6 +212,47 @@ func TestKVDBEndTransactionWithTriggers(t *testing.T) {
 	}
 }
 
+// TestKVDBRoutingInfo verifies that requests which specify routing
+// information via the public KV API are rejected.
+func TestKVDBRoutingInfo(t *testing.T) {
+	addr, server, _ := startServer(t)
+	defer server.Close()
+
+	kvClient := createTestClient(addr)
+	for _, header := range []proto.RequestHeader{
+		{Key: proto.Key("a"), RaftID: 1},
+		{Key: proto.Key("a"), Replica: proto.Replica{NodeID: 1, StoreID: 1}},
+	} {
+		args := &proto.PutRequest{RequestHeader: header, Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("value")}}}
+		if err := kvClient.Call(proto.Put, args, &proto.PutResponse{}); err == nil {
+			t.Errorf("expected 400 bad request error for header %+v", header)
+		}
+	}
+}
+
+// TestKVDBRequestTooLarge verifies that oversized requests are
+// rejected by the server with a RequestTooLargeError.
+func TestKVDBRequestTooLarge(t *testing.T) {
+	defer func(v string) { flag.Set("max_value_size", v) }(flag.Lookup("max_value_size").Value.String())
+	if err := flag.Set("max_value_size", "10"); err != nil {
+		t.Fatal(err)
+	}
+	addr, server, _ := startServer(t)
+	defer server.Close()
+
+	// Disable the client's limits so the request reaches the server.
+	kvClient := createTestClient(addr)
+	kvClient.Limits = proto.RequestLimits{}
+	args := &proto.PutRequest{
+		RequestHeader: proto.RequestHeader{Key: proto.Key("a")},
+		Value:         proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("value larger than 10 bytes")}},
+	}
+	err := kvClient.Call(proto.Put, args, &proto.PutResponse{})
+	if tErr, ok := err.(*proto.RequestTooLargeError); !ok || tErr.Kind != "value" || tErr.MaxSize != 10 {
+		t.Errorf("expected value too large error; got %v", err)
+	}
+}
+
 // TestKVDBContentTypes verifies all combinations of request /
 // response content encodings are supported.
 func TestKVDBContentType(t *testing.T) {
@@ -221,7 +263,7 @@ func TestKVDBContentType(t *testing.T) {
 		RequestHeader: proto.RequestHeader{
 			Key: proto.Key("a"),
 		},
-		Value: proto.Value{Bytes: []byte("value")},
+		Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("value")}},
 	}
 
 	testCases := []struct {
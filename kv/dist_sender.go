@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"reflect"
 	"time"
 
 	"github.com/cockroachdb/cockroach/client"
@@ -31,6 +32,7 @@ import (
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metrics"
 
 	gogoproto "github.com/gogo/protobuf/proto"
 )
@@ -48,6 +50,17 @@ const (
 	rangeLookupMaxRanges = 8
 )
 
+// Names of the counters exported by the DistSender. Retried RPCs are
+// counted per error type, with the error's type name appended to
+// distSenderRetriesMetric.
+const (
+	distSenderRPCsMetric       = "kv.dist_sender.rpcs"
+	distSenderRetriesMetric    = "kv.dist_sender.retries"
+	distSenderNotLeaderMetric  = "kv.dist_sender.not_leader"
+	distSenderCrossRangeMetric = "kv.dist_sender.cross_range"
+	distSenderRangeSendsMetric = "kv.dist_sender.cross_range.sends"
+)
+
 var rpcRetryOpts = util.RetryOptions{
 	Backoff:     retryBackoff,
 	MaxBackoff:  maxRetryBackoff,
@@ -92,13 +105,25 @@ type DistSender struct {
 	gossip *gossip.Gossip
 	// rangeCache caches replica metadata for key ranges.
 	rangeCache *RangeDescriptorCache
+	// metrics records RPC retries, leader redirects and cross-range
+	// requests, which make misrouting and stale descriptors visible.
+	metrics *metrics.MetricSystem
+	// rpcSend sends RPCs to the replicas of a range; rpc.Send unless
+	// replaced by tests.
+	rpcSend rpcSendFn
 }
 
+// rpcSendFn is the function type of rpc.Send.
+type rpcSendFn func(opts rpc.Options, method string, addrs []net.Addr, getArgs func(addr net.Addr) interface{},
+	getReply func() interface{}, context *rpc.Context) ([]interface{}, error)
+
 // NewDistSender returns a client.KVSender instance which connects to the
 // Cockroach cluster via the supplied gossip instance.
 func NewDistSender(gossip *gossip.Gossip) *DistSender {
 	ds := &DistSender{
-		gossip: gossip,
+		gossip:  gossip,
+		metrics: metrics.Metrics,
+		rpcSend: rpc.Send,
 	}
 	ds.rangeCache = NewRangeDescriptorCache(ds)
 	return ds
@@ -152,6 +177,33 @@ func (ds *DistSender) verifyPermissions(method string, header *proto.RequestHead
 		})
 }
 
+// verifyCallPermissions verifies permissions for the call, including
+// restrictions on access to the audit log. For a batch, permissions
+// are verified for each of its requests.
+func (ds *DistSender) verifyCallPermissions(call *client.Call) error {
+	bArgs, ok := call.Args.(*proto.BatchRequest)
+	if !ok {
+		if err := verifyAuditLogAccess(call.Method, call.Args); err != nil {
+			return err
+		}
+		return ds.verifyPermissions(call.Method, call.Args.Header())
+	}
+	for i := range bArgs.Requests {
+		args := bArgs.Requests[i].GetValue().(proto.Request)
+		method, err := proto.MethodForRequest(args)
+		if err != nil {
+			return err
+		}
+		if err := verifyAuditLogAccess(method, args); err != nil {
+			return err
+		}
+		if err := ds.verifyPermissions(method, args.Header()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // nodeIDToAddr uses the gossip network to translate from node ID
 // to a host:port address pair.
 func (ds *DistSender) nodeIDToAddr(nodeID int32) (net.Addr, error) {
@@ -187,12 +239,11 @@ func (ds *DistSender) internalRangeLookup(key proto.Key, info *proto.RangeDescri
 // the cluster, which is retrieved from the gossip protocol instead of the
 // datastore.
 func (ds *DistSender) getFirstRangeDescriptor() (*proto.RangeDescriptor, error) {
-	infoI, err := ds.gossip.GetInfo(gossip.KeyFirstRangeDescriptor)
-	if err != nil {
+	desc := &proto.RangeDescriptor{}
+	if err := ds.gossip.GetInfoProto(gossip.KeyFirstRangeDescriptor, desc); err != nil {
 		return nil, firstRangeMissingError{}
 	}
-	info := infoI.(proto.RangeDescriptor)
-	return &info, nil
+	return desc, nil
 }
 
 // getRangeDescriptor retrieves the descriptor for the range
@@ -242,11 +293,15 @@ func (ds *DistSender) getRangeDescriptor(key proto.Key) ([]proto.RangeDescriptor
 // sendRPC sends one or more RPCs to replicas from the supplied
 // proto.Replica slice. First, replicas which have gossipped
 // addresses are corralled and then sent via rpc.Send, with requirement
-// that one RPC to a server must succeed.
+// that one RPC to a server must succeed. Errors returned from the
+// replica are decoded from the reply header and returned as their
+// original, structured type so that callers can act on them (for
+// example, by evicting a stale range descriptor).
 func (ds *DistSender) sendRPC(desc *proto.RangeDescriptor, method string, args proto.Request, reply proto.Response) error {
 	if len(desc.Replicas) == 0 {
 		return util.Errorf("%s: replicas set is empty", method)
 	}
+	ds.metrics.Counter(distSenderRPCsMetric, 1)
 
 	// Build a slice of replica addresses (if gossipped).
 	var addrs []net.Addr
@@ -283,7 +338,7 @@ func (ds *DistSender) sendRPC(desc *proto.RangeDescriptor, method string, args p
 			// Otherwise, copy the args value and set the replica in the header.
 			a = gogoproto.Clone(args).(proto.Request)
 		}
-		a.Header().Replica = *replicaMap[addr.String()]
+		a.Header().SetRouting(desc.RaftID, *replicaMap[addr.String()])
 		return a
 	}
 	firstReply := true
@@ -294,21 +349,62 @@ func (ds *DistSender) sendRPC(desc *proto.RangeDescriptor, method string, args p
 		}
 		return gogoproto.Clone(reply)
 	}
-	_, err := rpc.Send(rpcOpts, "Node."+method, addrs, getArgs, getReply, ds.gossip.RPCContext)
-	return err
+	replies, err := ds.rpcSend(rpcOpts, "Node."+method, addrs, getArgs, getReply, ds.gossip.RPCContext)
+	if err != nil {
+		return err
+	}
+	// If the successful reply was not the first one handed out, copy
+	// it into the supplied reply.
+	if r := replies[0].(proto.Response); r != reply {
+		reply.Reset()
+		gogoproto.Merge(reply, r)
+	}
+	return reply.Header().GoError()
+}
+
+// Send implements the clent.KVSender interface. Non-transactional
+// batches are split by range; see sendBatchByRange. Other calls are
+// sent by send.
+func (ds *DistSender) Send(call *client.Call) {
+	if isNonTxnBatch(call) {
+		sendBatchByRange(call, ds.lookupRaftID, ds.send)
+		return
+	}
+	ds.send(call)
+}
+
+// lookupRaftID returns the Raft ID of the range containing the key
+// range from key to endKey, or an error if it spans ranges.
+func (ds *DistSender) lookupRaftID(key, endKey proto.Key) (int64, error) {
+	desc, err := ds.rangeCache.LookupRangeDescriptor(key)
+	if err != nil {
+		return 0, err
+	}
+	// Local keys are addressed by the global keys they're suffixed with.
+	if !desc.KeyRange().ContainsRange(proto.KeyRange{Start: engine.KeyAddress(key), End: engine.KeyAddress(endKey)}) {
+		return 0, util.Errorf("key range %q-%q spans ranges", key, endKey)
+	}
+	return desc.RaftID, nil
+}
+
+// lookupRangeDescriptor implements the rangeDescriptorLookup
+// interface using the range descriptor cache.
+func (ds *DistSender) lookupRangeDescriptor(key proto.Key) (*proto.RangeDescriptor, error) {
+	return ds.rangeCache.LookupRangeDescriptor(key)
 }
 
-// Send implements the clent.KVSender interface. It verifies
-// permissions and looks up the appropriate range based on the
-// supplied key and sends the RPC according to the specified
+// send verifies permissions and looks up the appropriate range based
+// on the supplied key and sends the RPC according to the specified
 // options.
 // If the request spans multiple ranges (which is possible for
-// Scan or DeleteRange requests), Send sends requests to the
+// Scan or DeleteRange requests), send sends requests to the
 // individual ranges sequentially and combines the results
-// transparently.
-func (ds *DistSender) Send(call *client.Call) {
+// transparently. ReverseScan requests visit the ranges in descending
+// order, beginning with the range containing the end key, and stop
+// early once the maximum number of results has been collected.
+func (ds *DistSender) send(call *client.Call) {
 	// Verify permissions.
-	if err := ds.verifyPermissions(call.Method, call.Args.Header()); err != nil {
+	if err := ds.verifyCallPermissions(call); err != nil {
 		call.Reply.Header().SetGoError(err)
 		return
 	}
@@ -323,33 +419,72 @@ func (ds *DistSender) Send(call *client.Call) {
 	// args will be changed to point to a copy of call.Args if the request
 	// spans ranges since in that case we need to alter its contents.
 	args := call.Args
+	// If a trace was requested, the spans for each RPC attempt and
+	// those returned by the replicas are set on the final reply.
+	var trace []proto.TraceSpan
+	if call.Args.Header().Trace {
+		defer func() { call.Reply.Header().Trace = trace }()
+	}
+	reverse := call.Method == proto.ReverseScan
+	if reverse {
+		if _, err := call.Args.Header().EndKey.PrevSafe(); err != nil {
+			call.Reply.Header().SetGoError(util.Errorf("invalid end key for %s: %s", call.Method, err))
+			return
+		}
+	}
 	for {
 		reply := call.Reply
+		var attempt int32
 		err := util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
 			descNext = nil
-			desc, err := ds.rangeCache.LookupRangeDescriptor(args.Header().Key)
+			// Reverse requests are addressed to the range holding the
+			// last key before EndKey rather than the one holding Key.
+			lookupKey := args.Header().Key
+			keys := proto.KeyRange{Start: args.Header().Key, End: call.Args.Header().EndKey}
+			if reverse {
+				lookupKey = args.Header().EndKey.Prev()
+				keys = proto.KeyRange{Start: call.Args.Header().Key, End: args.Header().EndKey}
+			}
+			desc, err := ds.rangeCache.LookupRangeDescriptor(lookupKey)
 			if err == nil {
 				// If the request accesses keys beyond the end of this range,
 				// get the descriptor of the adjacent range to address next.
-				if desc.EndKey.Less(call.Args.Header().EndKey) {
+				if !desc.KeyRange().ContainsRange(proto.KeyRange{
+					Start: engine.KeyAddress(keys.Start),
+					End:   engine.KeyAddress(keys.End),
+				}) {
+					if call.Method == proto.Batch {
+						// Transactional batches are one-phase commits,
+						// which must be confined to a single range.
+						return util.RetryBreak, proto.NewOnePhaseCommitError("batch spans ranges")
+					}
 					if _, ok := call.Reply.(proto.Combinable); !ok {
 						return util.RetryBreak, util.Error("illegal cross-range operation", call)
 					}
 					if call.Args.Header().Txn == nil {
 						return util.RetryBreak, &proto.OpRequiresTxnError{}
 					}
-					// This next lookup is likely for free since we've read the
-					// previous descriptor and range lookups use cache
-					// prefetching.
-					descNext, err = ds.rangeCache.LookupRangeDescriptor(desc.EndKey)
+					if len(responses) == 0 {
+						ds.metrics.Counter(distSenderCrossRangeMetric, 1)
+					}
 					// If this is the first step in a multi-range operation,
 					// additionally copy call.Args because we will have to
 					// mutate it as we talk to the involved ranges.
 					if len(responses) == 0 {
 						args = gogoproto.Clone(call.Args).(proto.Request)
 					}
-					// Truncate the request to our current range.
-					args.Header().EndKey = desc.EndKey
+					if reverse {
+						descNext, err = ds.rangeCache.LookupRangeDescriptor(desc.StartKey.Prev())
+						// Truncate the request to our current range.
+						args.Header().Key = desc.StartKey
+					} else {
+						// This next lookup is likely for free since we've read the
+						// previous descriptor and range lookups use cache
+						// prefetching.
+						descNext, err = ds.rangeCache.LookupRangeDescriptor(desc.EndKey)
+						// Truncate the request to our current range.
+						args.Header().EndKey = desc.EndKey
+					}
 				}
 			}
 			// true if we're dealing with a range-spanning request.
@@ -358,8 +493,17 @@ func (ds *DistSender) Send(call *client.Call) {
 				if isMulti {
 					// Make a new reply object for this call.
 					reply = gogoproto.Clone(call.Reply).(proto.Response)
+					ds.metrics.Counter(distSenderRangeSendsMetric, 1)
 				}
+				span := proto.NewTraceSpan("rpc " + call.Method)
 				err = ds.sendRPC(desc, call.Method, args, reply)
+				if args.Header().Trace {
+					attempt++
+					span.RaftID, span.Attempt = desc.RaftID, attempt
+					span.Finish(err)
+					trace = append(append(trace, reply.Header().Trace...), span)
+					reply.Header().Trace = nil
+				}
 			}
 
 			if err != nil {
@@ -370,11 +514,15 @@ func (ds *DistSender) Send(call *client.Call) {
 				switch err.(type) {
 				case *proto.RangeNotFoundError, *proto.RangeKeyMismatchError:
 					// Range descriptor might be out of date - evict it.
-					ds.rangeCache.EvictCachedRangeDescriptor(args.Header().Key)
+					ds.rangeCache.EvictCachedRangeDescriptor(lookupKey)
+					ds.countRetry(err)
 					// On addressing errors, don't backoff and retry immediately.
 					return util.RetryReset, nil
+				case *proto.NotLeaderError:
+					ds.metrics.Counter(distSenderNotLeaderMetric, 1)
 				default:
 					if retryErr, ok := err.(util.Retryable); ok && retryErr.CanRetry() {
+						ds.countRetry(err)
 						return util.RetryContinue, nil
 					}
 				}
@@ -395,6 +543,21 @@ func (ds *DistSender) Send(call *client.Call) {
 		if descNext == nil {
 			break
 		}
+		// For scans, stop once enough rows have been collected; otherwise
+		// only ask the next range for the remainder.
+		if remaining, ok := remainingResults(call.Args, responses); ok {
+			if remaining <= 0 {
+				break
+			}
+			setMaxResults(args, remaining)
+		}
+		if reverse {
+			// In next iteration, query the preceding range.
+			args.Header().EndKey = descNext.EndKey
+			// "Untruncate" Key to original.
+			args.Header().Key = call.Args.Header().Key
+			continue
+		}
 		// In next iteration, query next range.
 		args.Header().Key = descNext.StartKey
 		// "Untruncate" EndKey to original.
@@ -414,6 +577,49 @@ func (ds *DistSender) Send(call *client.Call) {
 	}
 }
 
+// countRetry increments the retry counter for the type of the
+// supplied error.
+func (ds *DistSender) countRetry(err error) {
+	t := reflect.TypeOf(err)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	ds.metrics.Counter(distSenderRetriesMetric+"."+t.Name(), 1)
+}
+
 // Close implements the client.KVSender interface. It's a noop for the
 // distributed sender.
 func (ds *DistSender) Close() {}
+
+// remainingResults returns the number of results a multi-range scan
+// may still return given the responses collected so far. The boolean
+// is false if the request is not a scan or its results are unbounded.
+func remainingResults(args proto.Request, responses []proto.Response) (int64, bool) {
+	var maxResults, rows int64
+	switch t := args.(type) {
+	case *proto.ScanRequest:
+		maxResults = t.MaxResults
+		for _, r := range responses {
+			rows += int64(len(r.(*proto.ScanResponse).Rows))
+		}
+	case *proto.ReverseScanRequest:
+		maxResults = t.MaxResults
+		for _, r := range responses {
+			rows += int64(len(r.(*proto.ReverseScanResponse).Rows))
+		}
+	}
+	if maxResults <= 0 {
+		return 0, false
+	}
+	return maxResults - rows, true
+}
+
+// setMaxResults sets the maximum number of results of a scan request.
+func setMaxResults(args proto.Request, maxResults int64) {
+	switch t := args.(type) {
+	case *proto.ScanRequest:
+		t.MaxResults = maxResults
+	case *proto.ReverseScanRequest:
+		t.MaxResults = maxResults
+	}
+}
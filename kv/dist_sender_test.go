@@ -20,13 +20,18 @@ package kv
 
 import (
 	"bytes"
+	"net"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 )
 
 func TestGetFirstRangeDescriptor(t *testing.T) {
@@ -42,8 +47,10 @@ func TestGetFirstRangeDescriptor(t *testing.T) {
 	// Add first RangeDescriptor to a node different from the node for
 	// this dist sender and ensure that this dist sender has the
 	// information within a given time.
-	n.Nodes[1].Gossip.AddInfo(
-		gossip.KeyFirstRangeDescriptor, *expectedDesc, time.Hour)
+	if err := n.Nodes[1].Gossip.AddInfoProto(
+		gossip.KeyFirstRangeDescriptor, expectedDesc, time.Hour); err != nil {
+		t.Fatal(err)
+	}
 	maxCycles := 10
 	n.SimulateNetwork(func(cycle int, network *gossip.SimulationNetwork) bool {
 		desc, err := ds.getFirstRangeDescriptor()
@@ -158,3 +165,159 @@ func TestVerifyPermissions(t *testing.T) {
 	}
 	n.Stop()
 }
+
+// newTestDistSender returns a DistSender for a single range spanning
+// all keys with one replica on node 1, whose RPCs are handled by
+// the supplied function. Range lookups are answered with the range's
+// descriptor. Returns the sequence of methods sent along with a
+// function which stops the gossip network.
+func newTestDistSender(t *testing.T, handle func(method string, reply proto.Response)) (*DistSender, *[]string, func()) {
+	n := gossip.NewSimulationNetwork(1, "unix", gossip.DefaultTestGossipInterval)
+	g := n.Nodes[0].Gossip
+	desc := &proto.RangeDescriptor{
+		RaftID:   1,
+		StartKey: engine.KeyMin,
+		EndKey:   engine.KeyMax,
+		Replicas: []proto.Replica{{NodeID: 1, StoreID: 1}},
+	}
+	if err := g.AddInfoProto(gossip.KeyFirstRangeDescriptor, desc, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddInfo(gossip.MakeNodeIDGossipKey(1), n.Nodes[0].Addr, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var methods []string
+	ds := NewDistSender(g)
+	ds.rpcSend = func(_ rpc.Options, method string, addrs []net.Addr, getArgs func(addr net.Addr) interface{},
+		getReply func() interface{}, _ *rpc.Context) ([]interface{}, error) {
+		methods = append(methods, method)
+		getArgs(addrs[0])
+		reply := getReply().(proto.Response)
+		// Decoding a reply replaces whatever a previous attempt left.
+		reply.Reset()
+		if lReply, ok := reply.(*proto.InternalRangeLookupResponse); ok {
+			lReply.Ranges = []proto.RangeDescriptor{*desc}
+		} else {
+			handle(method, reply)
+		}
+		return []interface{}{reply}, nil
+	}
+	return ds, &methods, n.Stop
+}
+
+// countMethod returns the number of times method appears in methods.
+func countMethod(methods []string, method string) int {
+	var count int
+	for _, m := range methods {
+		if m == method {
+			count++
+		}
+	}
+	return count
+}
+
+// indexOf returns the index of the first occurrence of method in
+// methods at or after start, or -1 if there is none.
+func indexOf(methods []string, method string, start int) int {
+	for i := start; i < len(methods); i++ {
+		if methods[i] == method {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestSendRPCAddressingErrors verifies that RangeKeyMismatch and
+// RangeNotFound errors returned in a reply evict the cached range
+// descriptor and retry the request.
+func TestSendRPCAddressingErrors(t *testing.T) {
+	for _, replyErr := range []error{
+		proto.NewRangeKeyMismatchError(proto.Key("a"), proto.Key("a"), nil),
+		proto.NewRangeNotFoundError(1),
+	} {
+		var gets int
+		ds, methods, stop := newTestDistSender(t, func(method string, reply proto.Response) {
+			if gets++; gets == 1 {
+				reply.Header().SetGoError(replyErr)
+			}
+		})
+		call := &client.Call{
+			Method: proto.Get,
+			Args:   &proto.GetRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("a"), User: storage.UserRoot}},
+			Reply:  &proto.GetResponse{},
+		}
+		ds.Send(call)
+		if err := call.Reply.Header().GoError(); err != nil {
+			t.Errorf("%T: unexpected error: %s", replyErr, err)
+		}
+		if gets != 2 {
+			t.Errorf("%T: expected 2 gets; got %d", replyErr, gets)
+		}
+		// The evicted descriptor is looked up again before the retry.
+		first := indexOf(*methods, "Node.Get", 0)
+		if lookup := indexOf(*methods, "Node.InternalRangeLookup", first+1); lookup == -1 ||
+			lookup > indexOf(*methods, "Node.Get", first+1) {
+			t.Errorf("%T: expected the range to be looked up again before the retry; got %v", replyErr, *methods)
+		}
+		stop()
+	}
+}
+
+// TestSendRPCReplyError verifies that a plain error returned in a
+// reply is returned to the caller without a retry.
+func TestSendRPCReplyError(t *testing.T) {
+	ds, methods, stop := newTestDistSender(t, func(method string, reply proto.Response) {
+		reply.Header().SetGoError(util.Errorf("boom"))
+	})
+	defer stop()
+	call := &client.Call{
+		Method: proto.Get,
+		Args:   &proto.GetRequest{RequestHeader: proto.RequestHeader{Key: proto.Key("a"), User: storage.UserRoot}},
+		Reply:  &proto.GetResponse{},
+	}
+	ds.Send(call)
+	if err := call.Reply.Header().GoError(); err == nil || !strings.HasSuffix(err.Error(), "boom") {
+		t.Errorf("expected error \"boom\"; got %v", err)
+	}
+	if gets := countMethod(*methods, "Node.Get"); gets != 1 {
+		t.Errorf("expected a single get; got %d", gets)
+	}
+}
+
+// TestLookupRaftIDLocalKeys verifies that local keys are matched
+// against range descriptors by their addresses.
+func TestLookupRaftIDLocalKeys(t *testing.T) {
+	ds, _, stop := newTestDistSender(t, nil)
+	defer stop()
+	desc := &proto.RangeDescriptor{RaftID: 2, StartKey: proto.Key("a"), EndKey: proto.Key("c")}
+	ds.rangeCache.rangeCacheMu.Lock()
+	ds.rangeCache.insertRangeDescriptorLocked(desc)
+	ds.rangeCache.rangeCacheMu.Unlock()
+
+	txnKey := func(key string) proto.Key {
+		return engine.MakeKey(engine.KeyLocalTransactionPrefix, proto.Key(key))
+	}
+	for i, test := range []struct {
+		key, endKey proto.Key
+		expErr      bool
+	}{
+		{engine.RangeDescriptorKey(proto.Key("b")), nil, false},
+		{txnKey("a"), txnKey("b"), false},
+		{proto.Key("b"), txnKey("c"), false},
+		{txnKey("b"), txnKey("d"), true},
+		{proto.Key("b"), proto.Key("d"), true},
+	} {
+		raftID, err := ds.lookupRaftID(test.key, test.endKey)
+		if test.expErr {
+			if err == nil {
+				t.Errorf("%d: expected %q-%q to span ranges", i, test.key, test.endKey)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		} else if raftID != desc.RaftID {
+			t.Errorf("%d: expected range %d; got %d", i, desc.RaftID, raftID)
+		}
+	}
+}
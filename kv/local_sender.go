@@ -94,7 +94,22 @@ func (ls *LocalSender) VisitStores(visitor func(s *storage.Store) error) error {
 // up from the store map if specified by header.Replica; otherwise,
 // the command is being executed locally, and the replica is
 // determined via lookup through each store's LookupRange method.
+// Non-transactional batches which haven't been routed are split by
+// range; see sendBatchByRange.
 func (ls *LocalSender) Send(call *client.Call) {
+	if header := call.Args.Header(); isNonTxnBatch(call) && (header.RaftID == 0 || header.Replica.StoreID == 0) {
+		sendBatchByRange(call, func(key, endKey proto.Key) (int64, error) {
+			raftID, _, err := ls.lookupReplica(key, endKey)
+			return raftID, err
+		}, ls.send)
+		return
+	}
+	ls.send(call)
+}
+
+// send sends the call to the store holding the replica addressed
+// by the call, retrying once on a range key mismatch.
+func (ls *LocalSender) send(call *client.Call) {
 	// Instant retry with max two attempts to handle the case of a
 	// range split, which is exposed here as a RangeKeyMismatchError.
 	// If we fail with two in a row, pass the error up to caller and
@@ -116,8 +131,7 @@ func (ls *LocalSender) Send(call *client.Call) {
 			var raftID int64
 			raftID, repl, err = ls.lookupReplica(header.Key, header.EndKey)
 			if err == nil {
-				header.RaftID = raftID
-				header.Replica = *repl
+				header.SetRouting(raftID, *repl)
 			}
 		}
 		if err == nil {
@@ -153,8 +167,8 @@ func (ls *LocalSender) Send(call *client.Call) {
 				call.Reply.Header().SetGoError(err)
 				switch err.(type) {
 				case *proto.RangeKeyMismatchError:
-					// Clear request replica & response error.
-					header.Replica = proto.Replica{}
+					// Clear request routing & response error.
+					header.ClearRouting()
 					return util.RetryContinue, nil
 				}
 			}
@@ -187,3 +201,16 @@ func (ls *LocalSender) lookupReplica(start, end proto.Key) (int64, *proto.Replic
 	}
 	return 0, nil, proto.NewRangeKeyMismatchError(start, end, nil)
 }
+
+// lookupRangeDescriptor implements the rangeDescriptorLookup interface
+// by consulting each store in turn via Store.LookupRange(key).
+func (ls *LocalSender) lookupRangeDescriptor(key proto.Key) (*proto.RangeDescriptor, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	for _, store := range ls.storeMap {
+		if rng := store.LookupRange(key, nil); rng != nil {
+			return rng.Desc, nil
+		}
+	}
+	return nil, proto.NewRangeKeyMismatchError(key, nil, nil)
+}
@@ -25,12 +25,17 @@ import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/metrics"
 )
 
 const (
 	// rangeCacheSize is the number of entries held in the range cache.
 	// TODO(mrtracy): This value should be a command-line option.
 	rangeCacheSize = 1 << 20
+
+	// Names of the counters exported by the range descriptor cache.
+	rangeCacheHitsMetric   = "kv.range_cache.hits"
+	rangeCacheMissesMetric = "kv.range_cache.misses"
 )
 
 // rangeCacheKey is the key type used to store and sort values in the
@@ -76,6 +81,8 @@ type RangeDescriptorCache struct {
 	rangeCache *util.OrderedCache
 	// rangeCacheMu protects rangeCache for concurrent access
 	rangeCacheMu sync.RWMutex
+	// metrics records cache hits and misses.
+	metrics *metrics.MetricSystem
 }
 
 // NewRangeDescriptorCache returns a new RangeDescriptorCache which
@@ -88,6 +95,7 @@ func NewRangeDescriptorCache(db rangeDescriptorDB) *RangeDescriptorCache {
 			Policy:      util.CacheLRU,
 			ShouldEvict: rangeCacheShouldEvict,
 		}),
+		metrics: metrics.Metrics,
 	}
 }
 
@@ -107,8 +115,10 @@ func NewRangeDescriptorCache(db rangeDescriptorDB) *RangeDescriptorCache {
 func (rmc *RangeDescriptorCache) LookupRangeDescriptor(key proto.Key) (*proto.RangeDescriptor, error) {
 	_, r := rmc.getCachedRangeDescriptor(key)
 	if r != nil {
+		rmc.metrics.Counter(rangeCacheHitsMetric, 1)
 		return r, nil
 	}
+	rmc.metrics.Counter(rangeCacheMissesMetric, 1)
 
 	rs, err := rmc.db.getRangeDescriptor(key)
 	if err != nil {
@@ -116,12 +126,40 @@ func (rmc *RangeDescriptorCache) LookupRangeDescriptor(key proto.Key) (*proto.Ra
 	}
 	rmc.rangeCacheMu.Lock()
 	for i := range rs {
-		rmc.rangeCache.Add(rangeCacheKey(engine.RangeMetaLookupKey(&rs[i])), &rs[i])
+		rmc.insertRangeDescriptorLocked(&rs[i])
 	}
 	rmc.rangeCacheMu.Unlock()
 	return &rs[0], nil
 }
 
+// insertRangeDescriptorLocked adds the descriptor to the cache unless
+// an overlapping cached descriptor is newer, in which case the
+// supplied descriptor is stale and is dropped. Older overlapping
+// descriptors are evicted. The cache lock must be held for writing.
+func (rmc *RangeDescriptorCache) insertRangeDescriptorLocked(rd *proto.RangeDescriptor) {
+	var stale []rangeCacheKey
+	// Cached descriptors are keyed by the meta key of their end key, so
+	// any descriptor overlapping rd sorts after rd's start key.
+	k, v, ok := rmc.rangeCache.Ceil(rangeCacheKey(engine.RangeMetaKey(rd.StartKey).Next()))
+	for ok {
+		cacheKey, cached := k.(rangeCacheKey), v.(*proto.RangeDescriptor)
+		if !proto.Key(cached.StartKey).Less(rd.EndKey) {
+			break
+		}
+		if cached.NewerThan(rd) {
+			return
+		}
+		if rd.NewerThan(cached) {
+			stale = append(stale, cacheKey)
+		}
+		k, v, ok = rmc.rangeCache.Ceil(rangeCacheKey(proto.Key(cacheKey).Next()))
+	}
+	for _, cacheKey := range stale {
+		rmc.rangeCache.Del(cacheKey)
+	}
+	rmc.rangeCache.Add(rangeCacheKey(engine.RangeMetaLookupKey(rd)), rd)
+}
+
 // EvictCachedRangeDescriptor will evict any cached range descriptors
 // for the given key. It is intended that this method be called from a
 // consumer of RangeDescriptorCache if the returned range descriptor is
@@ -20,10 +20,12 @@ package kv
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"code.google.com/p/biogo.store/llrb"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/metrics"
 )
 
 type testDescriptorDB struct {
@@ -186,3 +188,84 @@ func TestRangeCache(t *testing.T) {
 	doLookup(t, rangeCache, "da")
 	db.assertHitCount(t, 2)
 }
+
+// TestRangeCacheMetrics verifies that range cache hits and misses are
+// exported as counters.
+func TestRangeCacheMetrics(t *testing.T) {
+	db := newTestDescriptorDB()
+	db.splitRange(t, proto.Key("b"))
+	rangeCache := NewRangeDescriptorCache(db)
+	db.cache = rangeCache
+
+	ms := metrics.NewMetricSystem(time.Millisecond, false)
+	rawMetrics := make(chan *metrics.RawMetricSet, 100)
+	ms.SubscribeToRawMetrics(rawMetrics)
+	ms.Start()
+	defer ms.Stop()
+	rangeCache.metrics = ms
+
+	// The first lookup misses on both the range and its meta2 range.
+	doLookup(t, rangeCache, "aa")
+	doLookup(t, rangeCache, "ab")
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case raw, ok := <-rawMetrics:
+			if !ok {
+				t.Fatal("metrics subscription closed")
+			}
+			if raw.Counters[rangeCacheHitsMetric] == 1 && raw.Counters[rangeCacheMissesMetric] == 2 {
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected 1 range cache hit and 2 misses to be exported")
+		}
+	}
+}
+
+// TestRangeCacheGeneration verifies that the cache uses descriptor
+// generations to decide between overlapping descriptors: stale
+// descriptors are dropped and newer descriptors evict older ones.
+func TestRangeCacheGeneration(t *testing.T) {
+	rangeCache := NewRangeDescriptorCache(newTestDescriptorDB())
+	insert := func(start, end string, generation int64) {
+		rangeCache.rangeCacheMu.Lock()
+		defer rangeCache.rangeCacheMu.Unlock()
+		rangeCache.insertRangeDescriptorLocked(&proto.RangeDescriptor{
+			StartKey:   proto.Key(start),
+			EndKey:     proto.Key(end),
+			Generation: generation,
+		})
+	}
+	expect := func(key, start, end string, generation int64) {
+		_, rd := rangeCache.getCachedRangeDescriptor(proto.Key(key))
+		if rd == nil {
+			t.Fatalf("expected a cached descriptor for %q", key)
+		}
+		if !proto.Key(rd.StartKey).Equal(proto.Key(start)) || !proto.Key(rd.EndKey).Equal(proto.Key(end)) ||
+			rd.Generation != generation {
+			t.Errorf("expected %q-%q at generation %d for %q; got %q-%q at generation %d",
+				start, end, generation, key, rd.StartKey, rd.EndKey, rd.Generation)
+		}
+	}
+
+	// Cache both halves of a split.
+	insert("a", "c", 1)
+	insert("c", "z", 1)
+	expect("b", "a", "c", 1)
+	expect("d", "c", "z", 1)
+
+	// A stale descriptor of the pre-split range is dropped.
+	insert("a", "z", 0)
+	expect("b", "a", "c", 1)
+	expect("d", "c", "z", 1)
+
+	// A descriptor of the merged range evicts both halves.
+	insert("a", "z", 2)
+	expect("b", "a", "z", 2)
+	expect("d", "a", "z", 2)
+	if l := rangeCache.rangeCache.Len(); l != 1 {
+		t.Errorf("expected 1 cached descriptor; got %d", l)
+	}
+}
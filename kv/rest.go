@@ -20,6 +20,8 @@ package kv
 import (
 	"encoding/json"
 	"errors"
+	"flag"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -29,6 +31,7 @@ import (
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -42,6 +45,37 @@ const (
 	RangePrefix = RESTPrefix + "range"
 	// CounterPrefix is the prefix for the endpoint that increments a key by a given amount.
 	CounterPrefix = RESTPrefix + "counter/"
+
+	// CapabilitiesHeader is the response header in which the REST
+	// server advertises the optional features it supports, as a comma
+	// separated list. A server which lacks a capability ignores the
+	// request parameters which ask for it, so clients should check for
+	// the capability before relying on it.
+	CapabilitiesHeader = "X-Cockroach-KV-Capabilities"
+	// CapabilityStreamScan is advertised by servers which stream range
+	// queries made with stream=true.
+	CapabilityStreamScan = "stream-scan"
+	// StreamedHeader is set to "true" on range query responses which
+	// are streamed as a JSON array of rows rather than returned as a
+	// ScanResponse.
+	StreamedHeader = "X-Cockroach-Streamed"
+)
+
+// restCapabilities lists the capabilities advertised in
+// CapabilitiesHeader.
+var restCapabilities = []string{CapabilityStreamScan}
+
+// restScanInitialChunkSize is the maximum number of rows fetched in
+// the first chunk of a streaming range query, before the size of the
+// rows is known.
+const restScanInitialChunkSize = 16
+
+var (
+	restScanChunkSize = flag.Int64("rest_scan_chunk_size", 1000, "maximum number of "+
+		"rows fetched per chunk when streaming range query results over HTTP")
+	restScanBufferSize = flag.Int64("rest_scan_buffer_size", 1<<20, "approximate "+
+		"maximum number of bytes buffered per chunk when streaming range query "+
+		"results over HTTP")
 )
 
 // Function signture for an HTTP handler that only takes a writer and a request
@@ -98,6 +132,7 @@ func NewRESTServer(db *client.KV) *RESTServer {
 // ServeHTTP satisfies the http.Handler interface and arbitrates requests
 // to the appropriate function based on the request’s HTTP method.
 func (s *RESTServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(CapabilitiesHeader, strings.Join(restCapabilities, ","))
 	for endPoint, epRoutes := range routingTable {
 		if strings.HasPrefix(r.URL.Path, endPoint) {
 			epHandler := epRoutes[r.Method]
@@ -112,14 +147,19 @@ func (s *RESTServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 }
 
-// writeJSON marshals v to JSON and writes the result to w with
-// the given status code.
-func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	if err := json.NewEncoder(w).Encode(v); err != nil {
-		log.Errorf("could not json encode response: %v", err)
+// writeResponse marshals v using the encoding negotiated from the
+// request's Accept and Content-Type headers and writes the result to
+// w with the given status code.
+func writeResponse(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	b, contentType, err := util.MarshalResponse(r, v, util.AllEncodings)
+	if err != nil {
+		log.Errorf("could not encode response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set(util.ContentTypeHeader, contentType)
+	w.WriteHeader(statusCode)
+	w.Write(b)
 }
 
 // keyedAction wraps the given actionKeyHandler func in a closure that
@@ -152,6 +192,12 @@ const (
 	rangeParamStart = "start"
 	rangeParamEnd   = "end"
 	rangeParamLimit = "limit"
+	// rangeParamStream, if "true", streams the rows of a range query
+	// as a JSON array instead of buffering the entire ScanResponse.
+	// Rows are only streamed if the response is negotiated to be JSON;
+	// clients which accept only protobuf or YAML receive a buffered
+	// ScanResponse. See CapabilityStreamScan and StreamedHeader.
+	rangeParamStream = "stream"
 )
 
 func (s *RESTServer) handleRangeAction(w http.ResponseWriter, r *http.Request) {
@@ -182,6 +228,11 @@ func (s *RESTServer) handleRangeAction(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "limit must be non-negative", http.StatusBadRequest)
 		return
 	}
+	if r.Method == methodGet && r.FormValue(rangeParamStream) == "true" &&
+		util.ResponseEncoding(r, util.AllEncodings) == util.JSONEncoding {
+		s.streamScan(w, startKey, endKey, limit)
+		return
+	}
 	reqHeader := proto.RequestHeader{
 		Key:    startKey,
 		EndKey: endKey,
@@ -207,7 +258,90 @@ func (s *RESTServer) handleRangeAction(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, results)
+	writeResponse(w, r, http.StatusOK, results)
+}
+
+// streamScan scans the rows between startKey and endKey in chunks and
+// writes them to w as a JSON array, flushing after each chunk. The
+// first chunk fetches at most restScanInitialChunkSize rows. Later
+// chunks are sized to the observed row size so that each buffers
+// roughly -rest_scan_buffer_size bytes, capped by
+// -rest_scan_chunk_size rows. Every chunk is read at the timestamp of
+// the first, so the result is a consistent snapshot of the range. A
+// limit of zero implies no limit.
+func (s *RESTServer) streamScan(w http.ResponseWriter, startKey, endKey proto.Key, limit int64) {
+	w.Header().Set(util.ContentTypeHeader, util.JSONContentType)
+	w.Header().Set(StreamedHeader, "true")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	chunkSize := *restScanChunkSize
+	if chunkSize > restScanInitialChunkSize {
+		chunkSize = restScanInitialChunkSize
+	}
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	var count int64
+	// The read timestamp is chosen by the first chunk's scan and
+	// pinned for the rest.
+	var timestamp proto.Timestamp
+
+	io.WriteString(w, "[")
+	for {
+		maxResults := chunkSize
+		if limit > 0 && limit-count < maxResults {
+			maxResults = limit - count
+		}
+		sr := &proto.ScanResponse{}
+		if err := s.db.Call(proto.Scan, &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       startKey,
+				EndKey:    endKey,
+				User:      storage.UserRoot,
+				Timestamp: timestamp,
+			},
+			MaxResults: maxResults,
+		}, sr); err != nil {
+			// The status code has already been sent. Abandon the response
+			// without closing the array so that the client can't mistake
+			// the partial result for a complete one.
+			log.Errorf("streaming scan of %q-%q failed after %d rows: %s", startKey, endKey, count, err)
+			return
+		}
+		if timestamp.Equal(proto.ZeroTimestamp) {
+			timestamp = sr.Timestamp
+		}
+		var chunkBytes int64
+		for _, row := range sr.Rows {
+			if count > 0 {
+				io.WriteString(w, ",")
+			}
+			if err := enc.Encode(row); err != nil {
+				log.Errorf("could not json encode row: %v", err)
+				return
+			}
+			chunkBytes += int64(len(row.Key) + len(row.Value.Bytes))
+			count++
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if int64(len(sr.Rows)) < maxResults || (limit > 0 && count >= limit) {
+			break
+		}
+		startKey = sr.Rows[len(sr.Rows)-1].Key.Next()
+		// Size the next chunk to fit within the buffer budget.
+		if avgBytes := chunkBytes / int64(len(sr.Rows)); avgBytes > 0 {
+			chunkSize = *restScanBufferSize / avgBytes
+			if chunkSize < 1 {
+				chunkSize = 1
+			} else if chunkSize > *restScanChunkSize {
+				chunkSize = *restScanChunkSize
+			}
+		}
+	}
+	io.WriteString(w, "]")
 }
 
 func (s *RESTServer) handleCounterAction(w http.ResponseWriter, r *http.Request, key proto.Key) {
@@ -239,7 +373,7 @@ func (s *RESTServer) handleCounterAction(w http.ResponseWriter, r *http.Request,
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, ir)
+	writeResponse(w, r, http.StatusOK, ir)
 }
 
 func (s *RESTServer) handlePutAction(w http.ResponseWriter, r *http.Request, key proto.Key) {
@@ -255,12 +389,12 @@ func (s *RESTServer) handlePutAction(w http.ResponseWriter, r *http.Request, key
 			Key:  key,
 			User: storage.UserRoot,
 		},
-		Value: proto.Value{Bytes: b},
+		Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: b}},
 	}, pr); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, pr)
+	writeResponse(w, r, http.StatusOK, pr)
 }
 
 func (s *RESTServer) handleGetAction(w http.ResponseWriter, r *http.Request, key proto.Key) {
@@ -279,7 +413,7 @@ func (s *RESTServer) handleGetAction(w http.ResponseWriter, r *http.Request, key
 	if gr.Value == nil {
 		status = http.StatusNotFound
 	}
-	writeJSON(w, status, gr)
+	writeResponse(w, r, status, gr)
 }
 
 func (s *RESTServer) handleHeadAction(w http.ResponseWriter, r *http.Request, key proto.Key) {
@@ -297,7 +431,7 @@ func (s *RESTServer) handleHeadAction(w http.ResponseWriter, r *http.Request, ke
 	if !cr.Exists {
 		status = http.StatusNotFound
 	}
-	writeJSON(w, status, cr)
+	writeResponse(w, r, status, cr)
 }
 
 func (s *RESTServer) handleDeleteAction(w http.ResponseWriter, r *http.Request, key proto.Key) {
@@ -311,5 +445,5 @@ func (s *RESTServer) handleDeleteAction(w http.ResponseWriter, r *http.Request,
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, dr)
+	writeResponse(w, r, http.StatusOK, dr)
 }
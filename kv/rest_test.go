@@ -25,6 +25,7 @@ package kv_test
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -41,6 +42,7 @@ import (
 	"github.com/cockroachdb/cockroach/server"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/hlc"
 	gogoproto "github.com/gogo/protobuf/proto"
 )
 
@@ -56,7 +58,7 @@ func startServer(t *testing.T) (string, *httptest.Server, *client.KV) {
 	}
 	mux := http.NewServeMux()
 	mux.Handle(RESTPrefix, NewRESTServer(db))
-	mux.Handle(DBPrefix, NewDBServer(db.Sender()))
+	mux.Handle(DBPrefix, NewDBServer(db.Sender(), hlc.NewClock(hlc.UnixNano)))
 	server := httptest.NewServer(mux)
 	addr := server.Listener.Addr().String()
 	return addr, server, db
@@ -150,6 +152,47 @@ func TestMethods(t *testing.T) {
 	}
 }
 
+// TestEntryContentNegotiation verifies that entry responses are
+// encoded as protobufs or YAML when requested via the Accept header.
+func TestEntryContentNegotiation(t *testing.T) {
+	addr, server, _ := startServer(t)
+	defer server.Close()
+
+	url := "http://" + addr + EntryPrefix + "key"
+	postURL(url, strings.NewReader("value"), t)
+
+	for _, accept := range []string{"application/x-protobuf", "text/yaml"} {
+		req, err := http.NewRequest(methodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		checkStatus(resp, t)
+		if contentType := resp.Header.Get("Content-Type"); contentType != accept {
+			t.Errorf("expected content type %s; got %s", accept, contentType)
+		}
+		if accept != "application/x-protobuf" {
+			continue
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gr := &proto.GetResponse{}
+		if err := gogoproto.Unmarshal(b, gr); err != nil {
+			t.Fatalf("could not decode protobuf response: %s", err)
+		}
+		if gr.Value == nil || string(gr.Value.Bytes) != "value" {
+			t.Errorf("expected value %q; got %+v", "value", gr.Value)
+		}
+	}
+}
+
 func TestRange(t *testing.T) {
 	addr, server, _ := startServer(t)
 	defer server.Close()
@@ -249,6 +292,188 @@ func TestRange(t *testing.T) {
 	}
 }
 
+// TestRangeStream verifies that range queries may be streamed as a
+// JSON array fetched in multiple chunks.
+func TestRangeStream(t *testing.T) {
+	addr, server, _ := startServer(t)
+	defer server.Close()
+
+	// Use a small chunk size so the scan spans several chunks.
+	if err := flag.Set("rest_scan_chunk_size", "7"); err != nil {
+		t.Fatal(err)
+	}
+	defer flag.Set("rest_scan_chunk_size", "1000")
+
+	baseURL := "http://" + addr
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key_%.2d", i)
+		val := fmt.Sprintf("value_%.2d", i)
+		prefix := EntryPrefix
+		if i > 0 && i%10 == 0 {
+			prefix = CounterPrefix
+			val = strconv.Itoa(i)
+		}
+		postURL(baseURL+prefix+key, strings.NewReader(val), t)
+	}
+
+	testCases := []struct {
+		start, end, limit, expRows int
+	}{
+		{5, 45, 0, 40},
+		{0, 50, 21, 21},
+		{10, 17, 0, 7},
+		{48, 99, 0, 2},
+	}
+	for i, test := range testCases {
+		url := fmt.Sprintf("%s%s?start=key_%.2d&end=key_%.2d&limit=%d&stream=true",
+			baseURL, RangePrefix, test.start, test.end, test.limit)
+		var rows []proto.KeyValue
+		if err := json.NewDecoder(strings.NewReader(getURL(url, t))).Decode(&rows); err != nil {
+			t.Fatalf("%d: unable to decode JSON into []proto.KeyValue: %s", i, err)
+		}
+		if len(rows) != test.expRows {
+			t.Errorf("%d: expected %d rows; got %d", i, test.expRows, len(rows))
+		}
+		for j, row := range rows {
+			verifyRangeRowIsGood(j, j+test.start, row, t)
+		}
+	}
+}
+
+// TestRangeStreamNegotiation verifies that the server advertises its
+// support for streamed range queries and only streams rows to clients
+// which accept JSON, marking the streamed response as such.
+func TestRangeStreamNegotiation(t *testing.T) {
+	addr, server, _ := startServer(t)
+	defer server.Close()
+
+	baseURL := "http://" + addr
+	for i := 0; i < 3; i++ {
+		postURL(fmt.Sprintf("%s%skey_%.2d", baseURL, EntryPrefix, i), strings.NewReader("value"), t)
+	}
+
+	url := baseURL + RangePrefix + "?start=key_00&end=key_10&stream=true"
+	testCases := []struct {
+		accept   string
+		streamed bool
+	}{
+		{"", true},
+		{"application/json", true},
+		{"application/x-protobuf", false},
+	}
+	for i, test := range testCases {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		checkStatus(resp, t)
+		if caps := resp.Header.Get(CapabilitiesHeader); !strings.Contains(caps, CapabilityStreamScan) {
+			t.Errorf("%d: expected %s capability; got %q", i, CapabilityStreamScan, caps)
+		}
+		if streamed := resp.Header.Get(StreamedHeader) == "true"; streamed != test.streamed {
+			t.Errorf("%d: expected streamed=%t; got %t", i, test.streamed, streamed)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows []proto.KeyValue
+		if test.streamed {
+			err = json.Unmarshal(b, &rows)
+		} else {
+			sr := &proto.ScanResponse{}
+			err = gogoproto.Unmarshal(b, sr)
+			rows = sr.Rows
+		}
+		if err != nil {
+			t.Fatalf("%d: could not decode response: %s", i, err)
+		}
+		if len(rows) != 3 {
+			t.Errorf("%d: expected 3 rows; got %d", i, len(rows))
+		}
+	}
+}
+
+// scanHookSender is a KVSender which invokes afterScan after each scan
+// it sends.
+type scanHookSender struct {
+	client.KVSender
+	afterScan func()
+}
+
+func (s *scanHookSender) Send(call *client.Call) {
+	s.KVSender.Send(call)
+	if call.Method == proto.Scan {
+		s.afterScan()
+	}
+}
+
+// TestRangeStreamSnapshot verifies that every chunk of a streamed range
+// query reads at the same timestamp, so that a row written between
+// chunks isn't returned.
+func TestRangeStreamSnapshot(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	db, err := server.BootstrapCluster("test-cluster", e)
+	if err != nil {
+		t.Fatalf("could not bootstrap test cluster: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		args := proto.PutArgs(proto.Key(fmt.Sprintf("key_%.2d", i)), []byte(fmt.Sprintf("value_%.2d", i)))
+		args.User = storage.UserRoot
+		if err := db.Call(proto.Put, args, &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Write a row into the second chunk's span once the first chunk
+	// has been read.
+	var wrote bool
+	sender := &scanHookSender{KVSender: db.Sender(), afterScan: func() {
+		if wrote {
+			return
+		}
+		wrote = true
+		args := proto.PutArgs(proto.Key("key_08a"), []byte("late"))
+		args.User = storage.UserRoot
+		if err := db.Call(proto.Put, args, &proto.PutResponse{}); err != nil {
+			t.Error(err)
+		}
+	}}
+	mux := http.NewServeMux()
+	mux.Handle(RESTPrefix, NewRESTServer(client.NewKV(sender, nil)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Use a small chunk size so the scan spans several chunks.
+	if err := flag.Set("rest_scan_chunk_size", "7"); err != nil {
+		t.Fatal(err)
+	}
+	defer flag.Set("rest_scan_chunk_size", "1000")
+
+	url := fmt.Sprintf("%s%s?start=key_00&end=key_10&stream=true", server.URL, RangePrefix)
+	var rows []proto.KeyValue
+	if err := json.NewDecoder(strings.NewReader(getURL(url, t))).Decode(&rows); err != nil {
+		t.Fatalf("unable to decode JSON into []proto.KeyValue: %s", err)
+	}
+	if !wrote {
+		t.Fatal("expected a row to be written between chunks")
+	}
+	if len(rows) != 10 {
+		t.Fatalf("expected 10 rows; got %d", len(rows))
+	}
+	for i, row := range rows {
+		verifyRangeRowIsGood(i, i, row, t)
+	}
+}
+
 // verifyRangeRowIsGood tests whether a row at a given index i holds the
 // appropriate values key_<n> -> value_<n> or key_<n> -> <counter value n>
 // in the case where n is greater than zero and a multiple of ten. This
@@ -61,8 +61,9 @@ type txnMetadata struct {
 	txn proto.Transaction
 
 	// keys stores key ranges affected by this transaction through this
-	// coordinator. By keeping this record, the coordinator will be able
-	// to update the write intent when the transaction is committed.
+	// coordinator, including those of calls which failed. By keeping
+	// this record, the coordinator will be able to update the write
+	// intents when the transaction is committed.
 	keys *util.IntervalCache
 
 	// lastUpdateTS is the latest time when the client sent transaction
@@ -105,44 +106,166 @@ func (tm *txnMetadata) addKeyRange(start, end proto.Key) {
 	tm.keys.Add(key, nil)
 }
 
-// close sends resolve intent commands for all key ranges this
+// close resolves the write intents for all key ranges this
 // transaction has covered, clears the keys cache and closes the
-// metadata heartbeat.
+// metadata heartbeat. Resolution is asynchronous and best effort; see
+// resolveIntents.
 func (tm *txnMetadata) close(txn *proto.Transaction, sender client.KVSender) {
 	if tm.keys.Len() > 0 {
 		log.V(1).Infof("cleaning up %d intent(s) for transaction %s", tm.keys.Len(), txn)
 	}
+	var spans []proto.IntentSpan
 	for _, o := range tm.keys.GetOverlaps(engine.KeyMin, engine.KeyMax) {
-		call := &client.Call{
+		span := proto.IntentSpan{Key: o.Key.Start().(proto.Key)}
+		// Set the end key only if it's not equal to Key.Next(). This
+		// saves us from unnecessarily clearing intents as a range.
+		if endKey := o.Key.End().(proto.Key); !span.Key.Next().Equal(endKey) {
+			span.EndKey = endKey
+		}
+		spans = append(spans, span)
+	}
+	tm.keys.Clear()
+	close(tm.closer)
+	if len(spans) > 0 {
+		go resolveIntents(txn, spans, sender)
+	}
+}
+
+// A rangeDescriptorLookup is implemented by senders which can look up
+// the descriptor of the range holding a key, such as DistSender. The
+// descriptor returned may be stale.
+type rangeDescriptorLookup interface {
+	lookupRangeDescriptor(key proto.Key) (*proto.RangeDescriptor, error)
+}
+
+// An intentGroup is a set of intent spans held by the same range,
+// along with the addressed key range covering them.
+type intentGroup struct {
+	keys  proto.KeyRange
+	spans []proto.IntentSpan
+}
+
+// groupIntentsByRange groups the intent spans by the range holding
+// them according to lookup. Spans are assigned to ranges by their
+// addressed keys, as requests are routed. Spans which cross ranges or
+// can't be looked up are placed in groups of their own, as are all
+// spans if lookup is nil. Groups are returned in the order of their
+// first spans.
+func groupIntentsByRange(spans []proto.IntentSpan,
+	lookup func(key proto.Key) (*proto.RangeDescriptor, error)) []intentGroup {
+	end := func(kr proto.KeyRange) proto.Key {
+		if len(kr.End) == 0 {
+			return kr.Start.Next()
+		}
+		return kr.End
+	}
+	var groups []intentGroup
+	byRange := map[int64]int{} // Raft ID -> index into groups
+	for _, span := range spans {
+		keys := proto.KeyRange{Start: engine.KeyAddress(span.Key), End: engine.KeyAddress(span.EndKey)}
+		if lookup != nil {
+			if desc, err := lookup(keys.Start); err == nil && desc.KeyRange().ContainsRange(keys) {
+				if i, ok := byRange[desc.RaftID]; ok {
+					g := &groups[i]
+					g.spans = append(g.spans, span)
+					if keys.Start.Less(g.keys.Start) {
+						g.keys.Start = keys.Start
+					}
+					if gEnd, spanEnd := end(g.keys), end(keys); gEnd.Less(spanEnd) {
+						g.keys.End = spanEnd
+					} else {
+						g.keys.End = gEnd
+					}
+					continue
+				}
+				byRange[desc.RaftID] = len(groups)
+			}
+		}
+		groups = append(groups, intentGroup{keys: keys, spans: []proto.IntentSpan{span}})
+	}
+	return groups
+}
+
+// resolveIntents resolves the supplied intent spans of a finished
+// transaction. If the sender can look up range descriptors, the spans
+// are grouped by the range holding them and each range is sent one
+// InternalResolveIntent request listing only its own spans. Spans
+// which couldn't be grouped, and the spans of any group whose request
+// fails, for example because the range was split since the lookup,
+// are resolved with a request of their own. Only once every span has
+// been resolved is the transaction record removed, so that records
+// don't accumulate under high transaction rates. A record must never
+// be removed while intents referencing it may remain: a pusher which
+// finds no record takes the transaction for pending and could abort
+// an intent of a committed transaction.
+func resolveIntents(txn *proto.Transaction, spans []proto.IntentSpan, sender client.KVSender) {
+	newCall := func(key, endKey proto.Key) *client.Call {
+		return &client.Call{
 			Method: proto.InternalResolveIntent,
 			Args: &proto.InternalResolveIntentRequest{
 				RequestHeader: proto.RequestHeader{
 					Timestamp: txn.Timestamp,
-					Key:       o.Key.Start().(proto.Key),
+					Key:       key,
+					EndKey:    endKey,
 					User:      storage.UserRoot,
 					Txn:       txn,
 				},
 			},
 			Reply: &proto.InternalResolveIntentResponse{},
 		}
-		// Set the end key only if it's not equal to Key.Next(). This
-		// saves us from unnecessarily clearing intents as a range.
-		endKey := o.Key.End().(proto.Key)
-		if !call.Args.Header().Key.Next().Equal(endKey) {
-			call.Args.Header().EndKey = endKey
-		}
-		// We don't care about the reply channel; these are best
-		// effort. We simply fire and forget, each in its own goroutine.
-		go func() {
-			log.V(1).Infof("cleaning up intent %q for txn %s", call.Args.Header().Key, txn)
+	}
+
+	var lookup func(key proto.Key) (*proto.RangeDescriptor, error)
+	if l, ok := sender.(rangeDescriptorLookup); ok {
+		lookup = l.lookupRangeDescriptor
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	resolved := true
+	resolveSpans := func(spans []proto.IntentSpan) {
+		for _, span := range spans {
+			call := newCall(span.Key, span.EndKey)
 			sender.Send(call)
-			if call.Reply.Header().Error != nil {
-				log.Warningf("failed to cleanup %q intent: %s", call.Args.Header().Key, call.Reply.Header().GoError())
+			if err := call.Reply.Header().GoError(); err != nil {
+				log.Warningf("failed to cleanup %q intent: %s", span.Key, err)
+				mu.Lock()
+				resolved = false
+				mu.Unlock()
 			}
-		}()
+		}
+	}
+	for _, g := range groupIntentsByRange(spans, lookup) {
+		wg.Add(1)
+		go func(g intentGroup) {
+			defer wg.Done()
+			if len(g.spans) > 1 {
+				call := newCall(g.keys.Start, g.keys.End)
+				call.Args.(*proto.InternalResolveIntentRequest).Intents = g.spans
+				sender.Send(call)
+				err := call.Reply.Header().GoError()
+				if err == nil {
+					return
+				}
+				log.V(1).Infof("failed to cleanup intents in [%q, %q) as a batch; resolving individually: %s",
+					g.keys.Start, g.keys.End, err)
+			}
+			resolveSpans(g.spans)
+		}(g)
+	}
+	wg.Wait()
+
+	// Intents which failed to resolve still reference the transaction
+	// record, so it must be left in place for whoever encounters them.
+	if !resolved || txn.Status == proto.PENDING {
+		return
+	}
+	call := newCall(txn.Key, nil)
+	call.Args.(*proto.InternalResolveIntentRequest).GCTxn = true
+	sender.Send(call)
+	if err := call.Reply.Header().GoError(); err != nil {
+		log.Warningf("failed to remove record of txn %s: %s", txn, err)
 	}
-	tm.keys.Clear()
-	close(tm.closer)
 }
 
 // A TxnCoordSender is an implementation of client.KVSender which
@@ -158,11 +281,33 @@ type TxnCoordSender struct {
 	wrapped           client.KVSender
 	clock             *hlc.Clock
 	heartbeatInterval time.Duration
+	newTicker         func(time.Duration) ticker // Creates heartbeat tickers
 	clientTimeout     time.Duration
 	sync.Mutex                                // Protects the txns map.
 	txns              map[string]*txnMetadata // txn key to metadata
 }
 
+// A ticker delivers ticks on its channel until stopped. Transaction
+// heartbeats are driven by a ticker so that tests can deliver ticks
+// manually.
+type ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// realTicker adapts a time.Ticker to the ticker interface.
+type realTicker struct {
+	*time.Ticker
+}
+
+func newRealTicker(interval time.Duration) ticker {
+	return realTicker{time.NewTicker(interval)}
+}
+
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
 // NewTxnCoordSender creates a new TxnCoordSender for use from a KV
 // distributed DB instance. TxnCoordSenders should be closed when no
 // longer in use via Close().
@@ -171,6 +316,7 @@ func NewTxnCoordSender(wrapped client.KVSender, clock *hlc.Clock) *TxnCoordSende
 		wrapped:           wrapped,
 		clock:             clock,
 		heartbeatInterval: storage.DefaultHeartbeatInterval,
+		newTicker:         newRealTicker,
 		clientTimeout:     defaultClientTimeout,
 		txns:              map[string]*txnMetadata{},
 	}
@@ -182,7 +328,10 @@ func NewTxnCoordSender(wrapped client.KVSender, clock *hlc.Clock) *TxnCoordSende
 // if it's not nil but has an empty ID.
 func (tc *TxnCoordSender) Send(call *client.Call) {
 	header := call.Args.Header()
-	tc.maybeBeginTxn(header)
+	if err := tc.maybeBeginTxn(header); err != nil {
+		call.Reply.Header().SetGoError(err)
+		return
+	}
 
 	// Process batch specially; otherwise, send via wrapped sender.
 	if call.Method == proto.Batch {
@@ -208,12 +357,22 @@ func (tc *TxnCoordSender) Close() {
 // maybeBeginTxn begins a new transaction if a txn has been specified
 // in the request but has a nil ID. The new transaction is initialized
 // using the name and isolation in the otherwise uninitialized txn.
-// The Priority, if non-zero is used as a minimum.
-func (tc *TxnCoordSender) maybeBeginTxn(header *proto.RequestHeader) {
+// The Priority, if non-zero is used as a minimum. The Timestamp, if
+// non-zero, is a causality token: the clock is updated with it so
+// that the new transaction is ordered after it. An error is returned
+// if the token is too far ahead of the local clock.
+func (tc *TxnCoordSender) maybeBeginTxn(header *proto.RequestHeader) error {
 	if header.Txn != nil {
 		if len(header.Txn.ID) == 0 {
+			now := tc.clock.Now()
+			if !header.Txn.Timestamp.Equal(proto.ZeroTimestamp) {
+				var err error
+				if now, err = tc.clock.Update(header.Txn.Timestamp); err != nil {
+					return util.Errorf("unable to begin transaction after timestamp %s: %s", header.Txn.Timestamp, err)
+				}
+			}
 			newTxn := proto.NewTransaction(header.Txn.Name, engine.KeyAddress(header.Key), header.GetUserPriority(),
-				header.Txn.Isolation, tc.clock.Now(), tc.clock.MaxOffset().Nanoseconds())
+				header.Txn.Isolation, now, tc.clock.MaxOffset().Nanoseconds())
 			// Use existing priority as a minimum. This is used on transaction
 			// aborts to ratchet priority when creating successor transaction.
 			if newTxn.Priority < header.Txn.Priority {
@@ -222,6 +381,7 @@ func (tc *TxnCoordSender) maybeBeginTxn(header *proto.RequestHeader) {
 			header.Txn = newTxn
 		}
 	}
+	return nil
 }
 
 // sendOne sends a single call via the wrapped sender. If the call is
@@ -233,10 +393,11 @@ func (tc *TxnCoordSender) maybeBeginTxn(header *proto.RequestHeader) {
 // key range added to the transaction's interval tree of key ranges
 // for eventual cleanup via resolved write intents.
 //
-// On success, and if the call is part of a transaction, the affected
-// key range is recorded as live intents for eventual cleanup upon
-// transaction commit. Upon successful txn commit, initiates cleanup
-// of intents.
+// If the call is part of a transaction, the affected key range is
+// recorded as possibly holding intents for eventual cleanup upon
+// transaction commit, whether or not the call succeeded: a call which
+// failed or timed out may still have written intents. Upon successful
+// txn commit, initiates cleanup of intents.
 func (tc *TxnCoordSender) sendOne(call *client.Call) {
 	var startNS int64
 	header := call.Args.Header()
@@ -270,10 +431,13 @@ func (tc *TxnCoordSender) sendOne(call *client.Call) {
 		tc.updateResponseTxn(header, call.Reply.Header())
 	}
 
-	// If successful, we're in a transaction, and the command leaves
-	// transactional intents, add the key or key range to the intents map.
-	// If the transaction metadata doesn't yet exist, create it.
-	if call.Reply.Header().GoError() == nil && header.Txn != nil && proto.IsTransactional(call.Method) {
+	// If we're in a transaction and the command may leave transactional
+	// intents, add the key or key range to the intents map, even if the
+	// command failed: an error doesn't prove that no intent was written,
+	// and the transaction record may only be removed once every intent
+	// referencing it has been resolved (see resolveIntents). If the
+	// transaction metadata doesn't yet exist, create it.
+	if header.Txn != nil && proto.IsTransactional(call.Method) {
 		tc.Lock()
 		var ok bool
 		var txnMeta *txnMetadata
@@ -330,12 +494,7 @@ func (tc *TxnCoordSender) sendOne(call *client.Call) {
 			// when returning to the client. Below we choose the option
 			// that involves less waiting, which is likely the first one
 			// unless a transaction commits with an odd timestamp.
-			if tsNS := txn.Timestamp.WallTime; startNS > tsNS {
-				startNS = tsNS
-			}
-			sleepNS := tc.clock.MaxOffset() -
-				time.Duration(tc.clock.PhysicalNow()-startNS)
-			if *linearizable && sleepNS > 0 {
+			if sleepNS := tc.commitWait(startNS, txn); sleepNS > 0 {
 				defer func() {
 					log.V(1).Infof("%v: waiting %dms on EndTransaction for linearizability", txn.ID, sleepNS/1000000)
 					time.Sleep(sleepNS)
@@ -349,8 +508,14 @@ func (tc *TxnCoordSender) sendOne(call *client.Call) {
 }
 
 // sendBatch unrolls a batched command and sends each constituent
-// command in parallel.
+// command in parallel. Non-transactional batches are instead sent
+// whole through the wrapped sender, which splits them by range.
 func (tc *TxnCoordSender) sendBatch(batchArgs *proto.BatchRequest, batchReply *proto.BatchResponse) {
+	if batchArgs.Txn == nil {
+		tc.wrapped.Send(&client.Call{Method: proto.Batch, Args: batchArgs, Reply: batchReply})
+		return
+	}
+
 	// Prepare the calls by unrolling the batch. If the batchReply is
 	// pre-initialized with replies, use those; otherwise create replies
 	// as needed.
@@ -381,6 +546,13 @@ func (tc *TxnCoordSender) sendBatch(batchArgs *proto.BatchRequest, batchReply *p
 		calls = append(calls, &client.Call{Method: method, Args: args, Reply: reply})
 	}
 
+	// If the batch writes and commits a new transaction, first attempt
+	// a one-phase commit. If that isn't possible, nothing has been
+	// written and the calls are sent individually below.
+	if tc.isOnePhaseCommit(batchArgs, calls) && tc.sendOnePhaseCommit(batchArgs, batchReply, calls) {
+		return
+	}
+
 	// Send calls in parallel and wait for all to complete.
 	wg := sync.WaitGroup{}
 	wg.Add(len(calls))
@@ -403,15 +575,119 @@ func (tc *TxnCoordSender) sendBatch(batchArgs *proto.BatchRequest, batchReply *p
 	}
 }
 
+// isOnePhaseCommit returns true if the batch consists solely of
+// one-phase commit eligible writes followed by a committing
+// EndTransaction, and the transaction has not yet written any
+// intents through this coordinator.
+func (tc *TxnCoordSender) isOnePhaseCommit(batchArgs *proto.BatchRequest, calls []*client.Call) bool {
+	if batchArgs.Txn == nil || len(calls) < 2 {
+		return false
+	}
+	last := calls[len(calls)-1]
+	if last.Method != proto.EndTransaction {
+		return false
+	}
+	if etArgs := last.Args.(*proto.EndTransactionRequest); !etArgs.Commit || etArgs.SplitTrigger != nil {
+		return false
+	}
+	for _, call := range calls[:len(calls)-1] {
+		if !proto.IsOnePhaseCommit(call.Method) {
+			return false
+		}
+	}
+	tc.Lock()
+	defer tc.Unlock()
+	_, ok := tc.txns[string(batchArgs.Txn.ID)]
+	return !ok
+}
+
+// sendOnePhaseCommit sends the calls as a single batch addressed to
+// the key span of the writes. If the writes all fall within a single
+// range, they're executed together with the EndTransaction without
+// writing a transaction record or intents. Returns false, leaving
+// batchReply untouched, only if the batch failed with a
+// OnePhaseCommitError, which guarantees that nothing was written.
+// Other errors are returned to the client in batchReply: the outcome
+// of the commit may be unknown, e.g. if the RPC failed after the batch
+// was applied, and falling back could then apply the writes twice.
+func (tc *TxnCoordSender) sendOnePhaseCommit(batchArgs *proto.BatchRequest, batchReply *proto.BatchResponse, calls []*client.Call) bool {
+	txn := batchArgs.Txn
+	args := &proto.BatchRequest{
+		RequestHeader: batchArgs.RequestHeader,
+		Requests:      batchArgs.Requests,
+	}
+	args.Timestamp = txn.Timestamp
+	args.Key, args.EndKey = nil, nil
+	for _, call := range calls[:len(calls)-1] {
+		header := call.Args.Header()
+		header.Timestamp = txn.Timestamp
+		endKey := header.EndKey
+		if endKey == nil {
+			endKey = header.Key.Next()
+		}
+		if args.Key == nil || header.Key.Less(args.Key) {
+			args.Key = header.Key
+		}
+		if args.EndKey == nil || args.EndKey.Less(endKey) {
+			args.EndKey = endKey
+		}
+	}
+	etHeader := calls[len(calls)-1].Args.Header()
+	etHeader.Key = txn.Key
+	etHeader.Timestamp = txn.Timestamp
+
+	reply := &proto.BatchResponse{}
+	startNS := tc.clock.PhysicalNow()
+	tc.wrapped.Send(&client.Call{Method: proto.Batch, Args: args, Reply: reply})
+	err := reply.GoError()
+	if _, ok := err.(*proto.OnePhaseCommitError); ok {
+		log.V(1).Infof("%s: falling back to two-phase commit: %s", txn.ID, err)
+		return false
+	}
+	if err == nil && len(reply.Responses) != len(calls) {
+		err = util.Errorf("one-phase commit returned %d responses to %d requests", len(reply.Responses), len(calls))
+	}
+	if err != nil {
+		batchReply.SetGoError(err)
+		batchReply.Txn = gogoproto.Clone(txn).(*proto.Transaction)
+		tc.updateResponseTxn(&args.RequestHeader, &batchReply.ResponseHeader)
+		return true
+	}
+
+	// Transfer the individual responses to the batch reply.
+	for i, call := range calls {
+		call.Reply.Reset()
+		gogoproto.Merge(call.Reply.(gogoproto.Message), reply.Responses[i].GetValue().(gogoproto.Message))
+	}
+	batchReply.Timestamp = reply.Timestamp
+	batchReply.Txn = reply.Txn
+	if sleepNS := tc.commitWait(startNS, reply.Txn); sleepNS > 0 {
+		log.V(1).Infof("%v: waiting %dms on one-phase commit for linearizability", txn.ID, sleepNS/1000000)
+		time.Sleep(sleepNS)
+	}
+	return true
+}
+
+// commitWait returns the duration to wait after committing txn for
+// the commit to be linearizable, or zero if the -linearizable flag
+// isn't set. startNS is the physical time at which the commit began.
+func (tc *TxnCoordSender) commitWait(startNS int64, txn *proto.Transaction) time.Duration {
+	if !*linearizable {
+		return 0
+	}
+	if tsNS := txn.Timestamp.WallTime; startNS > tsNS {
+		startNS = tsNS
+	}
+	return tc.clock.MaxOffset() - time.Duration(tc.clock.PhysicalNow()-startNS)
+}
+
 // updateResponseTxn updates the response txn based on the response
 // timestamp and error. The timestamp may have changed upon
 // encountering a newer write or read. Both the timestamp and the
 // priority may change depending on error conditions.
 func (tc *TxnCoordSender) updateResponseTxn(argsHeader *proto.RequestHeader, replyHeader *proto.ResponseHeader) {
 	// Move txn timestamp forward to response timestamp if applicable.
-	if replyHeader.Txn.Timestamp.Less(replyHeader.Timestamp) {
-		replyHeader.Txn.Timestamp = replyHeader.Timestamp
-	}
+	replyHeader.Txn.Timestamp.Forward(replyHeader.Timestamp)
 
 	// Take action on various errors.
 	switch t := replyHeader.GoError().(type) {
@@ -423,36 +699,26 @@ func (tc *TxnCoordSender) updateResponseTxn(argsHeader *proto.RequestHeader, rep
 		// If the reader encountered a newer write within the uncertainty
 		// interval, move the timestamp forward, just past that write or
 		// up to MaxTimestamp, whichever comes first.
-		var candidateTS proto.Timestamp
-		if t.ExistingTimestamp.Less(replyHeader.Txn.MaxTimestamp) {
-			candidateTS = t.ExistingTimestamp
-			candidateTS.Logical++
-		} else {
-			candidateTS = replyHeader.Txn.MaxTimestamp
+		candidateTS := replyHeader.Txn.MaxTimestamp
+		if t.ExistingTimestamp.Less(candidateTS) {
+			candidateTS = t.ExistingTimestamp.Next()
 		}
 		// Only change the timestamp if we're moving it forward.
-		if replyHeader.Txn.Timestamp.Less(candidateTS) {
-			replyHeader.Txn.Timestamp = candidateTS
-		}
+		replyHeader.Txn.Timestamp.Forward(candidateTS)
 		replyHeader.Txn.Restart(argsHeader.GetUserPriority(), replyHeader.Txn.Priority, replyHeader.Txn.Timestamp)
 	case *proto.TransactionAbortedError:
 		// Increase timestamp if applicable.
-		if replyHeader.Txn.Timestamp.Less(t.Txn.Timestamp) {
-			replyHeader.Txn.Timestamp = t.Txn.Timestamp
-		}
+		replyHeader.Txn.Timestamp.Forward(t.Txn.Timestamp)
 		replyHeader.Txn.Priority = t.Txn.Priority
 	case *proto.TransactionPushError:
 		// Increase timestamp if applicable.
 		if replyHeader.Txn.Timestamp.Less(t.PusheeTxn.Timestamp) {
-			replyHeader.Txn.Timestamp = t.PusheeTxn.Timestamp
-			replyHeader.Txn.Timestamp.Logical++ // ensure this txn's timestamp > other txn
+			replyHeader.Txn.Timestamp = t.PusheeTxn.Timestamp.Next() // ensure this txn's timestamp > other txn
 		}
 		replyHeader.Txn.Restart(argsHeader.GetUserPriority(), t.PusheeTxn.Priority-1, replyHeader.Txn.Timestamp)
 	case *proto.TransactionRetryError:
 		// Increase timestamp if applicable.
-		if replyHeader.Txn.Timestamp.Less(t.Txn.Timestamp) {
-			replyHeader.Txn.Timestamp = t.Txn.Timestamp
-		}
+		replyHeader.Txn.Timestamp.Forward(t.Txn.Timestamp)
 		replyHeader.Txn.Restart(argsHeader.GetUserPriority(), t.Txn.Priority, replyHeader.Txn.Timestamp)
 	}
 }
@@ -495,7 +761,8 @@ func (tc *TxnCoordSender) hasClientAbandonedCoord(txnID []byte) bool {
 // extant transaction, stopping in the event the transaction is
 // aborted or committed or if the TxnCoordSender is closed.
 func (tc *TxnCoordSender) heartbeat(txn *proto.Transaction, closer chan struct{}) {
-	ticker := time.NewTicker(tc.heartbeatInterval)
+	ticker := tc.newTicker(tc.heartbeatInterval)
+	defer ticker.Stop()
 	request := &proto.InternalHeartbeatTxnRequest{
 		RequestHeader: proto.RequestHeader{
 			Key:  txn.Key,
@@ -507,7 +774,7 @@ func (tc *TxnCoordSender) heartbeat(txn *proto.Transaction, closer chan struct{}
 	// Loop with ticker for periodic heartbeats.
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.Chan():
 			// Before we send a heartbeat, determine whether this transaction
 			// should be considered abandoned. If so, exit heartbeat.
 			if tc.hasClientAbandonedCoord(txn.ID) {
@@ -20,6 +20,8 @@ package kv
 import (
 	"bytes"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -93,7 +95,7 @@ func createPutRequest(key proto.Key, value []byte, txn *proto.Transaction) *prot
 			Timestamp: txn.Timestamp,
 			Txn:       txn,
 		},
-		Value: proto.Value{Bytes: value},
+		Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: value}},
 	}
 }
 
@@ -302,27 +304,29 @@ func TestTxnCoordSenderHeartbeat(t *testing.T) {
 	defer db.Close()
 	defer ls.Close()
 
-	// Set heartbeat interval to 1ms for testing.
-	coord.heartbeatInterval = 1 * time.Millisecond
+	// Deliver heartbeat ticks manually.
+	tick := &manualTicker{ch: make(chan time.Time)}
+	coord.newTicker = func(time.Duration) ticker { return tick }
 
 	txn := newTxn(db, clock, proto.Key("a"))
 	if err := db.Call(proto.Put, createPutRequest(proto.Key("a"), []byte("value"), txn), &proto.PutResponse{}); err != nil {
 		t.Fatal(err)
 	}
 
-	// Verify 3 heartbeats.
+	// Verify 3 heartbeats, each at a later time.
 	var heartbeatTS proto.Timestamp
 	for i := 0; i < 3; i++ {
+		// Advance clock by 1ns.
+		// Locking the TxnCoordSender to prevent a data race.
+		coord.Lock()
+		manual.Increment(1)
+		coord.Unlock()
+		tick.ch <- time.Time{}
 		if err := util.IsTrueWithin(func() bool {
 			ok, txn, err := getTxn(db, txn)
-			if !ok || err != nil {
+			if !ok || err != nil || txn.LastHeartbeat == nil {
 				return false
 			}
-			// Advance clock by 1ns.
-			// Locking the TxnCoordSender to prevent a data race.
-			coord.Lock()
-			manual.Increment(1)
-			coord.Unlock()
 			if heartbeatTS.Less(*txn.LastHeartbeat) {
 				heartbeatTS = *txn.LastHeartbeat
 				return true
@@ -334,6 +338,17 @@ func TestTxnCoordSenderHeartbeat(t *testing.T) {
 	}
 }
 
+// manualTicker is a ticker whose ticks are delivered by the test.
+type manualTicker struct {
+	ch chan time.Time
+}
+
+func (m *manualTicker) Chan() <-chan time.Time {
+	return m.ch
+}
+
+func (m *manualTicker) Stop() {}
+
 // getTxn fetches the requested key and returns the transaction info.
 func getTxn(db *client.KV, txn *proto.Transaction) (bool, *proto.Transaction, error) {
 	hr := &proto.InternalHeartbeatTxnResponse{}
@@ -405,6 +420,393 @@ func TestTxnCoordSenderEndTxn(t *testing.T) {
 	verifyCleanup(key, db, eng, t)
 }
 
+// TestTxnCoordSenderEndTxnGC verifies that ending a transaction
+// which wrote several keys resolves all of its intents and then
+// removes the transaction record.
+func TestTxnCoordSenderEndTxnGC(t *testing.T) {
+	db, eng, clock, _, ls, err := createTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer ls.Close()
+
+	keys := []proto.Key{proto.Key("a"), proto.Key("b"), proto.Key("c")}
+	txn := newTxn(db, clock, keys[0])
+	for _, key := range keys {
+		pReply := &proto.PutResponse{}
+		if err := db.Call(proto.Put, createPutRequest(key, []byte("value"), txn), pReply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	etReply := &proto.EndTransactionResponse{}
+	if err := db.Call(proto.EndTransaction, &proto.EndTransactionRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       txn.Key,
+			Timestamp: txn.Timestamp,
+			Txn:       txn,
+		},
+		Commit: true,
+	}, etReply); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		verifyCleanup(key, db, eng, t)
+	}
+
+	txnKey := engine.MakeKey(engine.KeyLocalTransactionPrefix, txn.Key, txn.ID)
+	if err := util.IsTrueWithin(func() bool {
+		ok, err := engine.MVCCGetProto(eng, txnKey, proto.ZeroTimestamp, nil, &proto.Transaction{})
+		if err != nil {
+			t.Errorf("error getting transaction record: %s", err)
+		}
+		return !ok
+	}, 500*time.Millisecond); err != nil {
+		t.Errorf("expected transaction record to be removed within 500ms")
+	}
+}
+
+// TestGroupIntentsByRange verifies that intent spans are grouped by
+// the range holding their addressed keys.
+func TestGroupIntentsByRange(t *testing.T) {
+	descs := []*proto.RangeDescriptor{
+		{RaftID: 1, StartKey: engine.KeyMin, EndKey: proto.Key("m")},
+		{RaftID: 2, StartKey: proto.Key("m"), EndKey: engine.KeyMax},
+	}
+	lookup := func(key proto.Key) (*proto.RangeDescriptor, error) {
+		for _, desc := range descs {
+			if desc.ContainsKey(key) {
+				return desc, nil
+			}
+		}
+		return nil, util.Errorf("no range for %q", key)
+	}
+	localB := engine.RangeDescriptorKey(proto.Key("b"))
+	localY := engine.RangeDescriptorKey(proto.Key("y"))
+	spans := []proto.IntentSpan{
+		{Key: localB},
+		{Key: localY},
+		{Key: proto.Key("a")},
+		{Key: proto.Key("c"), EndKey: proto.Key("e")},
+		{Key: proto.Key("k"), EndKey: proto.Key("p")},
+		{Key: proto.Key("x")},
+	}
+	expGroups := []intentGroup{
+		{proto.KeyRange{Start: proto.Key("a"), End: proto.Key("e")}, []proto.IntentSpan{spans[0], spans[2], spans[3]}},
+		{proto.KeyRange{Start: proto.Key("x"), End: proto.Key("y").Next()}, []proto.IntentSpan{spans[1], spans[5]}},
+		{proto.KeyRange{Start: proto.Key("k"), End: proto.Key("p")}, []proto.IntentSpan{spans[4]}},
+	}
+	if groups := groupIntentsByRange(spans, lookup); !reflect.DeepEqual(groups, expGroups) {
+		t.Errorf("expected groups %+v; got %+v", expGroups, groups)
+	}
+	if groups := groupIntentsByRange(spans, nil); len(groups) != len(spans) {
+		t.Errorf("expected a group per span without lookup; got %+v", groups)
+	}
+}
+
+// recordingSender wraps a LocalSender, recording the calls it sends.
+type recordingSender struct {
+	*LocalSender
+	mu    sync.Mutex
+	calls []*client.Call
+}
+
+func (rs *recordingSender) Send(call *client.Call) {
+	rs.mu.Lock()
+	rs.calls = append(rs.calls, call)
+	rs.mu.Unlock()
+	rs.LocalSender.Send(call)
+}
+
+// TestResolveIntentsAcrossRanges verifies that the intents of a
+// transaction which wrote to two ranges, including an intent on a
+// range-local key, are resolved with one request per range listing
+// only that range's spans, and that the transaction record is removed
+// afterwards.
+func TestResolveIntentsAcrossRanges(t *testing.T) {
+	db, eng, clock, _, ls, err := createTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer ls.Close()
+
+	splitKey := proto.Key("m")
+	req := &proto.AdminSplitRequest{RequestHeader: proto.RequestHeader{Key: splitKey}, SplitKey: splitKey}
+	if err := db.Call(proto.AdminSplit, req, &proto.AdminSplitResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write and commit bypassing the coordinator, which would otherwise
+	// resolve the intents itself. The local key addresses "y".
+	keys := []proto.Key{engine.RangeDescriptorKey(proto.Key("y")), proto.Key("a"), proto.Key("b"), proto.Key("x")}
+	txn := newTxn(db, clock, proto.Key("a"))
+	for _, key := range keys {
+		call := &client.Call{
+			Method: proto.Put,
+			Args:   createPutRequest(key, []byte("value"), txn),
+			Reply:  &proto.PutResponse{},
+		}
+		ls.Send(call)
+		if err := call.Reply.Header().GoError(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	etCall := &client.Call{
+		Method: proto.EndTransaction,
+		Args: &proto.EndTransactionRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       txn.Key,
+				Timestamp: txn.Timestamp,
+				Txn:       txn,
+			},
+			Commit: true,
+		},
+		Reply: &proto.EndTransactionResponse{},
+	}
+	ls.Send(etCall)
+	if err := etCall.Reply.Header().GoError(); err != nil {
+		t.Fatal(err)
+	}
+	txn = etCall.Reply.Header().Txn
+
+	var spans []proto.IntentSpan
+	for _, key := range keys {
+		spans = append(spans, proto.IntentSpan{Key: key})
+	}
+	rs := &recordingSender{LocalSender: ls}
+	resolveIntents(txn, spans, rs)
+
+	for _, key := range keys {
+		meta := &proto.MVCCMetadata{}
+		if _, _, _, err := engine.GetProto(eng, engine.MVCCEncodeKey(key), meta); err != nil {
+			t.Fatal(err)
+		}
+		if meta.Txn != nil {
+			t.Errorf("%q: expected intent to be resolved", key)
+		}
+	}
+	var batches, gcs int
+	for _, call := range rs.calls {
+		args := call.Args.(*proto.InternalResolveIntentRequest)
+		if len(args.Intents) == 2 {
+			batches++
+		} else if args.GCTxn {
+			gcs++
+		} else {
+			t.Errorf("unexpected resolve request %+v", args)
+		}
+	}
+	if batches != 2 || gcs != 1 {
+		t.Errorf("expected 2 batched resolves and 1 GC; got %d and %d", batches, gcs)
+	}
+	txnKey := engine.MakeKey(engine.KeyLocalTransactionPrefix, txn.Key, txn.ID)
+	if ok, err := engine.MVCCGetProto(eng, txnKey, proto.ZeroTimestamp, nil, &proto.Transaction{}); err != nil || ok {
+		t.Errorf("expected transaction record to be removed; got %t, %v", ok, err)
+	}
+}
+
+// TestResolveIntentsFailureKeepsRecord verifies that the record of a
+// transaction is not removed if any of its intents fail to resolve.
+func TestResolveIntentsFailureKeepsRecord(t *testing.T) {
+	var mu sync.Mutex
+	var gcTxn bool
+	sender := newTestSender(func(call *client.Call) {
+		args := call.Args.(*proto.InternalResolveIntentRequest)
+		mu.Lock()
+		defer mu.Unlock()
+		if args.GCTxn {
+			gcTxn = true
+		} else if args.Key.Equal(proto.Key("b")) {
+			call.Reply.Header().SetGoError(util.Errorf("injected failure"))
+		}
+	})
+	txn := &proto.Transaction{Key: proto.Key("a"), ID: []byte("txn"), Status: proto.COMMITTED}
+	resolveIntents(txn, []proto.IntentSpan{{Key: proto.Key("a")}, {Key: proto.Key("b")}}, sender)
+	if gcTxn {
+		t.Error("expected transaction record to be kept")
+	}
+}
+
+// TestTxnCoordSenderTracksFailedWrites verifies that the key of a
+// transactional write which failed is still resolved when the
+// transaction commits, before its record is removed: the write may
+// have left an intent behind.
+func TestTxnCoordSenderTracksFailedWrites(t *testing.T) {
+	var mu sync.Mutex
+	var resolved []string
+	gcTxn := make(chan struct{})
+	sender := newTestSender(func(call *client.Call) {
+		switch args := call.Args.(type) {
+		case *proto.PutRequest:
+			if args.Key.Equal(proto.Key("b")) {
+				call.Reply.Header().SetGoError(util.Errorf("injected timeout"))
+			}
+		case *proto.InternalHeartbeatTxnRequest:
+			call.Reply.Header().Txn = gogoproto.Clone(args.Txn).(*proto.Transaction)
+		case *proto.EndTransactionRequest:
+			txn := gogoproto.Clone(args.Txn).(*proto.Transaction)
+			txn.Status = proto.COMMITTED
+			call.Reply.Header().Txn = txn
+		case *proto.InternalResolveIntentRequest:
+			mu.Lock()
+			defer mu.Unlock()
+			if args.GCTxn {
+				close(gcTxn)
+				return
+			}
+			resolved = append(resolved, string(args.Key))
+		}
+	})
+	manual := hlc.NewManualClock(0)
+	clock := hlc.NewClock(manual.UnixNano)
+	tc := NewTxnCoordSender(sender, clock)
+	defer tc.Close()
+
+	txn := proto.NewTransaction("test", proto.Key("a"), 1, proto.SERIALIZABLE, clock.Now(), 0)
+	for _, key := range []string{"a", "b"} {
+		tc.Send(&client.Call{Method: proto.Put, Args: createPutRequest(proto.Key(key), []byte("value"), txn), Reply: &proto.PutResponse{}})
+	}
+	etReply := &proto.EndTransactionResponse{}
+	tc.Send(&client.Call{
+		Method: proto.EndTransaction,
+		Args: &proto.EndTransactionRequest{
+			RequestHeader: proto.RequestHeader{Key: txn.Key, Timestamp: txn.Timestamp, Txn: txn},
+			Commit:        true,
+		},
+		Reply: etReply,
+	})
+	if err := etReply.GoError(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-gcTxn:
+	case <-time.After(time.Second):
+		t.Fatal("expected transaction record to be removed")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(resolved)
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("expected intents on %q to be resolved; got %q", expected, resolved)
+	}
+}
+
+// TestTxnCoordSenderOnePhaseCommit verifies that a transaction whose
+// writes are all sent in the same batch as its commit is executed as
+// a one-phase commit, leaving neither intents nor a transaction
+// record behind.
+func TestTxnCoordSenderOnePhaseCommit(t *testing.T) {
+	db, eng, _, _, ls, err := createTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer ls.Close()
+
+	keys := []proto.Key{proto.Key("a"), proto.Key("b")}
+	txnOpts := &client.TransactionOptions{Name: "test"}
+	if err := db.RunTransaction(txnOpts, func(txn *client.KV) error {
+		for _, key := range keys {
+			txn.Prepare(proto.Put, proto.PutArgs(key, []byte("value")), &proto.PutResponse{})
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(getCoord(db).txns) != 0 {
+		t.Errorf("expected empty transactions map; got %d", len(getCoord(db).txns))
+	}
+	for _, key := range keys {
+		meta := &proto.MVCCMetadata{}
+		ok, _, _, err := engine.GetProto(eng, engine.MVCCEncodeKey(key), meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || meta.Txn != nil {
+			t.Errorf("expected committed value without intent at %q; got %+v", key, meta)
+		}
+		gr := &proto.GetResponse{}
+		if err := db.Call(proto.Get, proto.GetArgs(key), gr); err != nil {
+			t.Fatal(err)
+		}
+		if gr.Value == nil || !bytes.Equal(gr.Value.Bytes, []byte("value")) {
+			t.Errorf("expected value %q at %q; got %+v", "value", key, gr.Value)
+		}
+	}
+	kvs, err := engine.Scan(eng, engine.MVCCEncodeKey(engine.KeyLocalTransactionPrefix),
+		engine.MVCCEncodeKey(engine.KeyLocalTransactionPrefix.PrefixEnd()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 0 {
+		t.Errorf("expected no transaction records; got %d", len(kvs))
+	}
+}
+
+// TestTxnCoordSenderOnePhaseCommitErrors verifies that a failed
+// one-phase commit falls back to a two-phase commit only on a
+// OnePhaseCommitError, and that other errors are returned to the
+// client without sending the writes again.
+func TestTxnCoordSenderOnePhaseCommitErrors(t *testing.T) {
+	testCases := []struct {
+		err        error
+		expMethods []string
+	}{
+		{proto.NewOnePhaseCommitError("batch spans ranges"), []string{proto.Batch, proto.EndTransaction, proto.Put}},
+		{util.Errorf("injected timeout"), []string{proto.Batch}},
+	}
+	for i, test := range testCases {
+		var mu sync.Mutex
+		var methods []string
+		sender := newTestSender(func(call *client.Call) {
+			switch args := call.Args.(type) {
+			case *proto.BatchRequest:
+				call.Reply.Header().SetGoError(test.err)
+			case *proto.InternalHeartbeatTxnRequest:
+				call.Reply.Header().Txn = gogoproto.Clone(args.Txn).(*proto.Transaction)
+				return
+			case *proto.EndTransactionRequest:
+				txn := gogoproto.Clone(args.Txn).(*proto.Transaction)
+				txn.Status = proto.COMMITTED
+				call.Reply.Header().Txn = txn
+			case *proto.InternalResolveIntentRequest:
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			methods = append(methods, call.Method)
+		})
+		manual := hlc.NewManualClock(0)
+		clock := hlc.NewClock(manual.UnixNano)
+		tc := NewTxnCoordSender(sender, clock)
+
+		txn := proto.NewTransaction("test", proto.Key("a"), 1, proto.SERIALIZABLE, clock.Now(), 0)
+		bArgs := &proto.BatchRequest{RequestHeader: proto.RequestHeader{Txn: txn}}
+		bArgs.Add(proto.PutArgs(proto.Key("a"), []byte("value")))
+		bArgs.Add(&proto.EndTransactionRequest{Commit: true})
+		bReply := &proto.BatchResponse{}
+		tc.Send(&client.Call{Method: proto.Batch, Args: bArgs, Reply: bReply})
+		tc.Close()
+
+		if _, ok := test.err.(*proto.OnePhaseCommitError); ok {
+			if err := bReply.GoError(); err != nil {
+				t.Errorf("%d: unexpected error after falling back: %s", i, err)
+			}
+		} else if err := bReply.GoError(); err == nil || err.Error() != test.err.Error() {
+			t.Errorf("%d: expected error %q; got %v", i, test.err, err)
+		}
+		mu.Lock()
+		sort.Strings(methods)
+		if !reflect.DeepEqual(methods, test.expMethods) {
+			t.Errorf("%d: expected methods %s; got %s", i, test.expMethods, methods)
+		}
+		mu.Unlock()
+	}
+}
+
 // TestTxnCoordSenderCleanupOnAborted verifies that if a txn receives a
 // TransactionAbortedError, the coordinator cleans up the transaction.
 func TestTxnCoordSenderCleanupOnAborted(t *testing.T) {
@@ -196,7 +196,7 @@ func sumCmd(c *cmd, db *client.KV, t *testing.T) error {
 	r := &proto.PutResponse{}
 	err := db.Call(proto.Put, &proto.PutRequest{
 		RequestHeader: proto.RequestHeader{Key: c.getKey()},
-		Value:         proto.Value{Integer: gogoproto.Int64(sum)},
+		Value:         proto.Value{ValuePayload: proto.ValuePayload{Integer: gogoproto.Int64(sum)}},
 	}, r)
 	c.debug = fmt.Sprintf("[%d ts=%d]", sum, r.Timestamp.Logical)
 	return err
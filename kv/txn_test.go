@@ -105,6 +105,144 @@ func TestTxnDBBasics(t *testing.T) {
 	}
 }
 
+// TestTxnDBSavepoint verifies that writes made after a savepoint are
+// invisible to the transaction once rolled back, and aren't committed.
+func TestTxnDBSavepoint(t *testing.T) {
+	db, _, _, _, _, err := createTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyA, keyB := proto.Key("a"), proto.Key("b")
+	get := func(kv *client.KV, key proto.Key) ([]byte, error) {
+		gr := &proto.GetResponse{}
+		if err := kv.Call(proto.Get, proto.GetArgs(key), gr); err != nil {
+			return nil, err
+		}
+		if gr.Value == nil {
+			return nil, nil
+		}
+		return gr.Value.Bytes, nil
+	}
+
+	txnOpts := &client.TransactionOptions{Name: "test"}
+	if err := db.RunTransaction(txnOpts, func(txn *client.KV) error {
+		if err := txn.Call(proto.Put, proto.PutArgs(keyA, []byte("1")), &proto.PutResponse{}); err != nil {
+			return err
+		}
+		savepoint, err := txn.Savepoint()
+		if err != nil {
+			return err
+		}
+		if err := txn.Call(proto.Put, proto.PutArgs(keyA, []byte("2")), &proto.PutResponse{}); err != nil {
+			return err
+		}
+		if err := txn.Call(proto.Put, proto.PutArgs(keyB, []byte("1")), &proto.PutResponse{}); err != nil {
+			return err
+		}
+		if err := txn.RollbackToSavepoint(savepoint); err != nil {
+			return err
+		}
+		if v, err := get(txn, keyA); err != nil || !bytes.Equal(v, []byte("1")) {
+			return util.Errorf("expected %q after rollback; got %q, %v", "1", v, err)
+		}
+		if v, err := get(txn, keyB); err != nil || v != nil {
+			return util.Errorf("expected nil after rollback; got %q, %v", v, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := get(db, keyA); err != nil || !bytes.Equal(v, []byte("1")) {
+		t.Errorf("expected %q committed; got %q, %v", "1", v, err)
+	}
+	if v, err := get(db, keyB); err != nil || v != nil {
+		t.Errorf("expected nil committed; got %q, %v", v, err)
+	}
+
+	// Savepoints require a transactional client.
+	if _, err := db.Savepoint(); err == nil {
+		t.Error("expected error taking savepoint outside of a transaction")
+	}
+}
+
+// TestTxnDBNow verifies that KV.Now returns cluster timestamps which
+// are ordered after previous writes and don't go backwards, even when
+// the node's physical clock does.
+func TestTxnDBNow(t *testing.T) {
+	db, _, _, manual, _, err := createTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manual.Set(100)
+	putReply := &proto.PutResponse{}
+	if err := db.Call(proto.Put, proto.PutArgs(proto.Key("a"), []byte("value")), putReply); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := db.Now()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !putReply.Timestamp.Less(ts) {
+		t.Errorf("expected timestamp of write %s to be less than %s", putReply.Timestamp, ts)
+	}
+
+	manual.Set(50)
+	laterTS, err := db.Now()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Less(laterTS) {
+		t.Errorf("expected timestamp %s to be less than %s", ts, laterTS)
+	}
+}
+
+// TestTxnDBCausalityToken verifies that a transaction begun through
+// a coordinator with a lagging clock observes the writes of another
+// transaction if given its commit timestamp as a causality token.
+func TestTxnDBCausalityToken(t *testing.T) {
+	db, _, _, manual, lSender, err := createTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manual.Set(1000)
+	key := proto.Key("a")
+	commitTS, err := db.RunTransactionWithCommitTimestamp(&client.TransactionOptions{Name: "writer"}, func(txn *client.KV) error {
+		return txn.Call(proto.Put, proto.PutArgs(key, []byte("value")), &proto.PutResponse{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commitTS.WallTime != 1000 {
+		t.Errorf("expected commit timestamp at wall time 1000; got %s", commitTS)
+	}
+
+	// A client of another coordinator, whose clock lags.
+	otherSender := NewTxnCoordSender(lSender, hlc.NewClock(hlc.NewManualClock(0).UnixNano))
+	defer otherSender.Close()
+	otherDB := client.NewKV(otherSender, nil)
+	otherDB.User = storage.UserRoot
+
+	for _, withToken := range []bool{false, true} {
+		opts := &client.TransactionOptions{Name: "reader"}
+		if withToken {
+			opts.MinTimestamp = commitTS
+		}
+		if err := otherDB.RunTransaction(opts, func(txn *client.KV) error {
+			reply := &proto.GetResponse{}
+			if err := txn.Call(proto.Get, proto.GetArgs(key), reply); err != nil {
+				return err
+			}
+			if found := reply.Value != nil; found != withToken {
+				t.Errorf("with token %t: expected found=%t; got %t", withToken, withToken, found)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 // BenchmarkTxnWrites benchmarks a number of transactions writing to the
 // same key back to back, without using Prepare/Flush.
 func BenchmarkTxnWrites(b *testing.B) {
@@ -171,7 +309,7 @@ func verifyUncertainty(concurrency int, maxOffset time.Duration, t *testing.T) {
 			RequestHeader: proto.RequestHeader{
 				Key: key,
 			},
-			Value: proto.Value{Bytes: value},
+			Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: value}},
 		}, &pr)
 		if err := pr.GoError(); err != nil {
 			t.Errorf("%d: got write error: %v", i, err)
@@ -297,7 +435,7 @@ func TestUncertaintyRestarts(t *testing.T) {
 		clock.SetMaxOffset(offset)
 		key := proto.Key("key")
 		value := proto.Value{
-			Bytes: nil, // Set for each Put
+			ValuePayload: proto.ValuePayload{Bytes: nil}, // Set for each Put
 		}
 		// With the correct restart behaviour, we see only one restart
 		// and the value read is the very first one (as nothing else
@@ -367,13 +505,13 @@ func TestUncertaintyMaxTimestampForwarding(t *testing.T) {
 	futureTS := clock.Now()
 	futureTS.WallTime += offsetNS
 	err = engine.MVCCPut(eng, nil, keySlow, futureTS,
-		proto.Value{Bytes: valSlow}, nil)
+		proto.Value{ValuePayload: proto.ValuePayload{Bytes: valSlow}}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	futureTS.WallTime += offsetNS
 	err = engine.MVCCPut(eng, nil, keyFast, futureTS,
-		proto.Value{Bytes: valFast}, nil)
+		proto.Value{ValuePayload: proto.ValuePayload{Bytes: valFast}}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
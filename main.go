@@ -58,12 +58,17 @@ func main() {
 	c := commander.Commander{
 		Name: "cockroach",
 		Commands: []*commander.Command{
+			server.CmdBackup,
+			server.CmdExplainTrace,
 			server.CmdInit,
 			server.CmdGetZone,
 			server.CmdLsZones,
 			server.CmdRmZone,
+			server.CmdRange,
 			server.CmdSetZone,
+			server.CmdSplit,
 			server.CmdStart,
+			server.CmdUnsafeRecoverRange,
 			&commander.Command{
 				UsageLine: "listparams",
 				Short:     "list all available parameters and their default values",
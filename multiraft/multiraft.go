@@ -55,14 +55,20 @@ func (c *Config) Validate() error {
 	if c.Transport == nil {
 		return util.Error("Transport is required")
 	}
-	if c.ElectionTimeoutTicks == 0 {
-		return util.Error("ElectionTimeoutTicks must be non-zero")
+	if c.ElectionTimeoutTicks <= 0 {
+		return util.Error("ElectionTimeoutTicks must be positive")
 	}
-	if c.HeartbeatIntervalTicks == 0 {
-		return util.Error("HeartbeatIntervalTicks must be non-zero")
+	if c.HeartbeatIntervalTicks <= 0 {
+		return util.Error("HeartbeatIntervalTicks must be positive")
 	}
-	if c.TickInterval == 0 {
-		return util.Error("TickInterval must be non-zero")
+	if c.TickInterval <= 0 {
+		return util.Error("TickInterval must be positive")
+	}
+	// A leader must send heartbeats at least as often as followers time
+	// out, or every election is followed by another.
+	if c.ElectionTimeoutTicks < c.HeartbeatIntervalTicks {
+		return util.Errorf("ElectionTimeoutTicks (%d) must not be less than HeartbeatIntervalTicks (%d)",
+			c.ElectionTimeoutTicks, c.HeartbeatIntervalTicks)
 	}
 	return nil
 }
@@ -41,7 +41,12 @@ type testCluster struct {
 }
 
 func newTestCluster(size int, t *testing.T) *testCluster {
-	transport := NewLocalRPCTransport()
+	return newTestClusterWithTransport(NewLocalRPCTransport(), size, t)
+}
+
+// newTestClusterWithTransport creates a cluster of the given size whose
+// nodes communicate over the supplied transport.
+func newTestClusterWithTransport(transport Transport, size int, t *testing.T) *testCluster {
 	cluster := &testCluster{t: t}
 	for i := 0; i < size; i++ {
 		ticker := newManualTicker()
@@ -216,3 +221,32 @@ func TestMembershipChange(t *testing.T) {
 		<-ch
 	}
 }
+
+// TestConfigValidate verifies that invalid tick settings are rejected.
+func TestConfigValidate(t *testing.T) {
+	testCases := []struct {
+		electionTicks, heartbeatTicks int
+		tickInterval                  time.Duration
+		valid                         bool
+	}{
+		{5, 1, time.Millisecond, true},
+		{1, 1, time.Millisecond, true},
+		{0, 1, time.Millisecond, false},
+		{5, 0, time.Millisecond, false},
+		{5, -1, time.Millisecond, false},
+		{5, 1, 0, false},
+		{5, 1, -time.Millisecond, false},
+		{2, 3, time.Millisecond, false},
+	}
+	for i, test := range testCases {
+		config := &Config{
+			Transport:              NewLocalRPCTransport(),
+			ElectionTimeoutTicks:   test.electionTicks,
+			HeartbeatIntervalTicks: test.heartbeatTicks,
+			TickInterval:           test.tickInterval,
+		}
+		if err := config.Validate(); (err == nil) != test.valid {
+			t.Errorf("%d: expected valid=%t; got %v", i, test.valid, err)
+		}
+	}
+}
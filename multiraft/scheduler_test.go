@@ -0,0 +1,316 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package multiraft
+
+import (
+	"bytes"
+	"flag"
+	mathrand "math/rand"
+	"net/rpc"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+var schedulerSeed = flag.Int64("scheduler-seed", 0,
+	"seed for the deterministic multiraft scheduler; if zero, a random seed is chosen")
+
+const (
+	// schedulerSettle is how long the scheduler waits after the most
+	// recently queued message before choosing the next message to
+	// deliver, so that the set of candidates does not depend on how
+	// quickly each node's goroutines happened to run. The raft
+	// multiNode reports ready state only when there is some, so there
+	// is no signal that a node has finished reacting to a message;
+	// waiting for a quiet period is the only way to tell.
+	schedulerSettle = 5 * time.Millisecond
+	// schedulerTimeout bounds the time runUntil waits for its condition.
+	schedulerTimeout = 10 * time.Second
+)
+
+// scheduler is a Transport for tests which intercepts all raft messages
+// instead of delivering them. Messages are held until the test asks for
+// them to be delivered, at which point one pending message is chosen
+// using a PRNG seeded at construction. Together with manualTicker this
+// places tick delivery and message interleaving under the control of
+// the test, so that a failing run can be replayed by rerunning the test
+// with -scheduler-seed set to the seed it logged.
+type scheduler struct {
+	seed int64
+	rand *mathrand.Rand
+
+	mu         sync.Mutex
+	servers    map[uint64]ServerInterface
+	pending    []*RaftMessageRequest
+	lastQueued time.Time
+}
+
+// Assert implementation of the Transport interface.
+var _ Transport = &scheduler{}
+
+func newScheduler(seed int64) *scheduler {
+	return &scheduler{
+		seed:    seed,
+		rand:    mathrand.New(mathrand.NewSource(seed)),
+		servers: make(map[uint64]ServerInterface),
+	}
+}
+
+// Listen implements Transport.
+func (s *scheduler) Listen(id uint64, server ServerInterface) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.servers[id] = server
+	return nil
+}
+
+// Stop implements Transport. Messages which are pending for the stopped
+// node will be dropped when they are chosen for delivery.
+func (s *scheduler) Stop(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.servers, id)
+}
+
+// Connect implements Transport.
+func (s *scheduler) Connect(id uint64) (ClientInterface, error) {
+	return schedulerClient{s}, nil
+}
+
+func (s *scheduler) enqueue(req *RaftMessageRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, req)
+	s.lastQueued = time.Now()
+}
+
+// deliver waits for the set of pending messages to settle and then
+// delivers one of them, chosen at random. It returns false if there
+// were no messages to deliver.
+func (s *scheduler) deliver() bool {
+	s.mu.Lock()
+	for wait := schedulerSettle - time.Since(s.lastQueued); wait > 0; wait = schedulerSettle - time.Since(s.lastQueued) {
+		s.mu.Unlock()
+		time.Sleep(wait)
+		s.mu.Lock()
+	}
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	// Sort the candidates so that the choice depends only on the seed
+	// and the set of pending messages, not on their arrival order.
+	sort.Stable(requestsByContent(s.pending))
+	i := s.rand.Intn(len(s.pending))
+	req := s.pending[i]
+	s.pending = append(s.pending[:i], s.pending[i+1:]...)
+	server := s.servers[req.Message.To]
+	s.mu.Unlock()
+
+	if server == nil {
+		log.V(6).Infof("scheduler dropping message to stopped node %v", req.Message.To)
+		return true
+	}
+	if err := server.RaftMessage(req, &RaftMessageResponse{}); err != nil {
+		log.Warningf("scheduler failed to deliver message: %s", err)
+	}
+	return true
+}
+
+// runUntil delivers pending messages until done returns true, failing
+// the test if that does not happen within schedulerTimeout.
+func (s *scheduler) runUntil(t *testing.T, done func() bool) {
+	deadline := time.Now().Add(schedulerTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("scheduler with seed %d timed out", s.seed)
+		}
+		if !s.deliver() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// schedulerClient implements ClientInterface by handing each request to
+// the scheduler.
+type schedulerClient struct {
+	s *scheduler
+}
+
+func (c schedulerClient) Go(serviceMethod string, args interface{}, reply interface{},
+	done chan *rpc.Call) *rpc.Call {
+	if serviceMethod != raftMessageName {
+		log.Fatalf("scheduler cannot deliver %s", serviceMethod)
+	}
+	c.s.enqueue(args.(*RaftMessageRequest))
+	call := &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	if done != nil {
+		select {
+		case done <- call:
+		default:
+		}
+	}
+	return call
+}
+
+func (c schedulerClient) Close() error {
+	return nil
+}
+
+// requestsByContent sorts raft message requests by group and then by
+// the encoding of the entire message, so that only identical requests
+// compare as equal.
+type requestsByContent []*RaftMessageRequest
+
+func (r requestsByContent) Len() int      { return len(r) }
+func (r requestsByContent) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r requestsByContent) Less(i, j int) bool {
+	if r[i].GroupID != r[j].GroupID {
+		return r[i].GroupID < r[j].GroupID
+	}
+	return bytes.Compare(marshalMessage(r[i].Message), marshalMessage(r[j].Message)) < 0
+}
+
+// marshalMessage returns the encoding of a raft message.
+func marshalMessage(m raftpb.Message) []byte {
+	data, err := m.Marshal()
+	if err != nil {
+		log.Fatalf("unable to marshal raft message: %s", err)
+	}
+	return data
+}
+
+// newScheduledTestCluster creates a test cluster whose messages are
+// delivered by a scheduler. The seed is taken from -scheduler-seed if
+// set, and is logged so that failures can be reproduced.
+func newScheduledTestCluster(size int, t *testing.T) (*testCluster, *scheduler) {
+	seed := *schedulerSeed
+	if seed == 0 {
+		seed = util.NewPseudoSeed()
+	}
+	t.Logf("scheduler seed: %d", seed)
+	s := newScheduler(seed)
+	return newTestClusterWithTransport(s, size, t), s
+}
+
+// recordingServer is a ServerInterface which records the messages it
+// receives.
+type recordingServer struct {
+	mu       sync.Mutex
+	messages []raftpb.Message
+}
+
+func (r *recordingServer) RaftMessage(req *RaftMessageRequest, resp *RaftMessageResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, req.Message)
+	return nil
+}
+
+// TestSchedulerReplay verifies that schedulers with the same seed
+// deliver the same set of messages in the same order, regardless of the
+// order in which the messages were sent. This includes messages which
+// differ only in their entries.
+func TestSchedulerReplay(t *testing.T) {
+	var msgs []raftpb.Message
+	for from := uint64(1); from <= 3; from++ {
+		for index := uint64(1); index <= 5; index++ {
+			msgs = append(msgs, raftpb.Message{Type: raftpb.MsgApp, From: from, To: 4, Index: index})
+		}
+		for _, data := range []string{"a", "b"} {
+			msgs = append(msgs, raftpb.Message{Type: raftpb.MsgApp, From: from, To: 4, Index: 6,
+				Entries: []raftpb.Entry{{Data: []byte(data)}}})
+		}
+	}
+	replay := func(seed int64, perm []int) []raftpb.Message {
+		s := newScheduler(seed)
+		server := &recordingServer{}
+		if err := s.Listen(4, server); err != nil {
+			t.Fatal(err)
+		}
+		client, err := s.Connect(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, i := range perm {
+			client.Go(raftMessageName, &RaftMessageRequest{1, msgs[i]}, &RaftMessageResponse{}, nil)
+		}
+		for s.deliver() {
+		}
+		return server.messages
+	}
+
+	seed := util.NewPseudoSeed()
+	permRand := mathrand.New(mathrand.NewSource(seed))
+	first := replay(seed, permRand.Perm(len(msgs)))
+	if len(first) != len(msgs) {
+		t.Fatalf("expected %d messages to be delivered; got %d", len(msgs), len(first))
+	}
+	for i := 0; i < 3; i++ {
+		if next := replay(seed, permRand.Perm(len(msgs))); !reflect.DeepEqual(first, next) {
+			t.Fatalf("seed %d: delivery order differed between runs:\n%v\n%v", seed, first, next)
+		}
+	}
+}
+
+// TestScheduledCommand runs an election and commits a command with all
+// messages delivered by the scheduler.
+func TestScheduledCommand(t *testing.T) {
+	cluster, sched := newScheduledTestCluster(3, t)
+	defer cluster.stop()
+	groupID := uint64(1)
+	cluster.createGroup(groupID, 3)
+
+	// Elections are currently triggered after ElectionTimeoutTicks+1 ticks.
+	cluster.tickers[0].Tick()
+	cluster.tickers[0].Tick()
+	var leader uint64
+	sched.runUntil(t, func() bool {
+		select {
+		case e := <-cluster.events[0].LeaderElection:
+			leader = e.NodeID
+		default:
+		}
+		return leader != 0
+	})
+	if leader != cluster.nodes[0].nodeID {
+		t.Fatalf("expected %v to win election, but was %v", cluster.nodes[0].nodeID, leader)
+	}
+
+	cluster.nodes[0].SubmitCommand(groupID, makeCommandID(), []byte("command"))
+	committed := make([]bool, len(cluster.nodes))
+	sched.runUntil(t, func() bool {
+		done := true
+		for i, events := range cluster.events {
+			select {
+			case commit := <-events.CommandCommitted:
+				if string(commit.Command) != "command" {
+					t.Errorf("unexpected value in committed command: %v", commit.Command)
+				}
+				committed[i] = true
+			default:
+			}
+			done = done && committed[i]
+		}
+		return done
+	})
+}
@@ -37,6 +37,10 @@ const (
 	// matches the value specified in the request. Specifying a null value
 	// for existing means the value must not yet exist.
 	ConditionalPut = "ConditionalPut"
+	// CompareAndSwap verifies the values of several keys within a single
+	// range and, only if all match, atomically applies several writes.
+	// It may not be used within a transaction.
+	CompareAndSwap = "CompareAndSwap"
 	// Increment increments the value at the specified key. Once called
 	// for a key, Put & Get will return errors; only Increment will
 	// continue to be a valid command. The value must be deleted before
@@ -52,6 +56,10 @@ const (
 	// args.RequestHeader.Key and args.RequestHeader.EndKey, with
 	// the latter endpoint excluded.
 	Scan = "Scan"
+	// ReverseScan fetches the values for all keys which fall between
+	// args.RequestHeader.Key and args.RequestHeader.EndKey, with the
+	// latter endpoint excluded, in descending key order.
+	ReverseScan = "ReverseScan"
 	// EndTransaction either commits or aborts an ongoing transaction.
 	EndTransaction = "EndTransaction"
 	// ReapQueue scans and deletes messages from a recipient message
@@ -85,25 +93,33 @@ func (s stringSet) keys() []string {
 
 // AllMethods specifies the complete set of methods.
 var AllMethods = stringSet{
-	Contains:              struct{}{},
-	Get:                   struct{}{},
-	Put:                   struct{}{},
-	ConditionalPut:        struct{}{},
-	Increment:             struct{}{},
-	Delete:                struct{}{},
-	DeleteRange:           struct{}{},
-	Scan:                  struct{}{},
-	EndTransaction:        struct{}{},
-	ReapQueue:             struct{}{},
-	EnqueueUpdate:         struct{}{},
-	EnqueueMessage:        struct{}{},
-	AdminSplit:            struct{}{},
-	Batch:                 struct{}{},
-	InternalHeartbeatTxn:  struct{}{},
-	InternalPushTxn:       struct{}{},
-	InternalResolveIntent: struct{}{},
-	InternalSnapshotCopy:  struct{}{},
-	InternalMerge:         struct{}{},
+	Contains:                     struct{}{},
+	Get:                          struct{}{},
+	Put:                          struct{}{},
+	ConditionalPut:               struct{}{},
+	CompareAndSwap:               struct{}{},
+	Increment:                    struct{}{},
+	Delete:                       struct{}{},
+	DeleteRange:                  struct{}{},
+	Scan:                         struct{}{},
+	ReverseScan:                  struct{}{},
+	EndTransaction:               struct{}{},
+	ReapQueue:                    struct{}{},
+	EnqueueUpdate:                struct{}{},
+	EnqueueMessage:               struct{}{},
+	AdminSplit:                   struct{}{},
+	Batch:                        struct{}{},
+	InternalHeartbeatTxn:         struct{}{},
+	InternalPushTxn:              struct{}{},
+	InternalResolveIntent:        struct{}{},
+	InternalSnapshotCopy:         struct{}{},
+	InternalMerge:                struct{}{},
+	InternalComputeChecksum:      struct{}{},
+	InternalVerifyChecksum:       struct{}{},
+	InternalRecomputeStats:       struct{}{},
+	InternalGC:                   struct{}{},
+	InternalConditionalPutInline: struct{}{},
+	InternalDeleteInline:         struct{}{},
 }
 
 // PublicMethods specifies the set of methods accessible via the
@@ -113,10 +129,12 @@ var PublicMethods = stringSet{
 	Get:            struct{}{},
 	Put:            struct{}{},
 	ConditionalPut: struct{}{},
+	CompareAndSwap: struct{}{},
 	Increment:      struct{}{},
 	Delete:         struct{}{},
 	DeleteRange:    struct{}{},
 	Scan:           struct{}{},
+	ReverseScan:    struct{}{},
 	EndTransaction: struct{}{},
 	ReapQueue:      struct{}{},
 	EnqueueUpdate:  struct{}{},
@@ -128,41 +146,57 @@ var PublicMethods = stringSet{
 // InternalMethods specifies the set of methods accessible only
 // via the internal node RPC API.
 var InternalMethods = stringSet{
-	InternalHeartbeatTxn:  struct{}{},
-	InternalPushTxn:       struct{}{},
-	InternalResolveIntent: struct{}{},
-	InternalSnapshotCopy:  struct{}{},
-	InternalMerge:         struct{}{},
+	InternalHeartbeatTxn:         struct{}{},
+	InternalPushTxn:              struct{}{},
+	InternalResolveIntent:        struct{}{},
+	InternalSnapshotCopy:         struct{}{},
+	InternalMerge:                struct{}{},
+	InternalComputeChecksum:      struct{}{},
+	InternalVerifyChecksum:       struct{}{},
+	InternalRecomputeStats:       struct{}{},
+	InternalGC:                   struct{}{},
+	InternalConditionalPutInline: struct{}{},
+	InternalDeleteInline:         struct{}{},
 }
 
 // ReadMethods specifies the set of methods which read and return data.
 var ReadMethods = stringSet{
-	Contains:             struct{}{},
-	Get:                  struct{}{},
-	ConditionalPut:       struct{}{},
-	Increment:            struct{}{},
-	Scan:                 struct{}{},
-	ReapQueue:            struct{}{},
-	InternalRangeLookup:  struct{}{},
-	InternalSnapshotCopy: struct{}{},
+	Contains:                     struct{}{},
+	Get:                          struct{}{},
+	ConditionalPut:               struct{}{},
+	CompareAndSwap:               struct{}{},
+	Increment:                    struct{}{},
+	Scan:                         struct{}{},
+	ReverseScan:                  struct{}{},
+	ReapQueue:                    struct{}{},
+	InternalRangeLookup:          struct{}{},
+	InternalSnapshotCopy:         struct{}{},
+	InternalConditionalPutInline: struct{}{},
 }
 
 // WriteMethods specifies the set of methods which write data.
 var WriteMethods = stringSet{
-	Put:                   struct{}{},
-	ConditionalPut:        struct{}{},
-	Increment:             struct{}{},
-	Delete:                struct{}{},
-	DeleteRange:           struct{}{},
-	EndTransaction:        struct{}{},
-	ReapQueue:             struct{}{},
-	EnqueueUpdate:         struct{}{},
-	EnqueueMessage:        struct{}{},
-	Batch:                 struct{}{},
-	InternalHeartbeatTxn:  struct{}{},
-	InternalPushTxn:       struct{}{},
-	InternalResolveIntent: struct{}{},
-	InternalMerge:         struct{}{},
+	Put:                          struct{}{},
+	ConditionalPut:               struct{}{},
+	CompareAndSwap:               struct{}{},
+	Increment:                    struct{}{},
+	Delete:                       struct{}{},
+	DeleteRange:                  struct{}{},
+	EndTransaction:               struct{}{},
+	ReapQueue:                    struct{}{},
+	EnqueueUpdate:                struct{}{},
+	EnqueueMessage:               struct{}{},
+	Batch:                        struct{}{},
+	InternalHeartbeatTxn:         struct{}{},
+	InternalPushTxn:              struct{}{},
+	InternalResolveIntent:        struct{}{},
+	InternalMerge:                struct{}{},
+	InternalComputeChecksum:      struct{}{},
+	InternalVerifyChecksum:       struct{}{},
+	InternalRecomputeStats:       struct{}{},
+	InternalGC:                   struct{}{},
+	InternalConditionalPutInline: struct{}{},
+	InternalDeleteInline:         struct{}{},
 }
 
 // TxnMethods specifies the set of methods which leave key intents
@@ -178,6 +212,30 @@ var TxnMethods = stringSet{
 	EnqueueMessage: struct{}{},
 }
 
+// OnePhaseCommitMethods specifies the set of methods which may be
+// batched together with a committing EndTransaction and executed
+// as a one-phase commit, without writing a transaction record.
+var OnePhaseCommitMethods = stringSet{
+	Put:            struct{}{},
+	ConditionalPut: struct{}{},
+	Delete:         struct{}{},
+	DeleteRange:    struct{}{},
+}
+
+// BatchMethods specifies the set of methods which may be executed
+// together in a non-transactional batch. Requests in such a batch
+// which address the same range are executed atomically.
+var BatchMethods = stringSet{
+	Contains:       struct{}{},
+	Get:            struct{}{},
+	Put:            struct{}{},
+	ConditionalPut: struct{}{},
+	Increment:      struct{}{},
+	Delete:         struct{}{},
+	DeleteRange:    struct{}{},
+	Scan:           struct{}{},
+}
+
 // adminMethods specifies the set of methods which are neither
 // read-only nor read-write commands but instead execute directly on
 // the Raft leader.
@@ -242,6 +300,20 @@ func IsTransactional(method string) bool {
 	return ok
 }
 
+// IsOnePhaseCommit returns true if the specified method may be
+// executed as part of a one-phase commit batch.
+func IsOnePhaseCommit(method string) bool {
+	_, ok := OnePhaseCommitMethods[method]
+	return ok
+}
+
+// IsBatchable returns true if the specified method may be executed
+// as part of a non-transactional batch.
+func IsBatchable(method string) bool {
+	_, ok := BatchMethods[method]
+	return ok
+}
+
 // GetArgs returns a GetRequest object initialized to get the
 // value at key.
 func GetArgs(key Key) *GetRequest {
@@ -266,7 +338,7 @@ func IncrementArgs(key Key, increment int64) *IncrementRequest {
 // PutArgs returns a PutRequest object initialized to put value
 // as a byte slice at key.
 func PutArgs(key Key, valueBytes []byte) *PutRequest {
-	value := Value{Bytes: valueBytes}
+	value := Value{ValuePayload: ValuePayload{Bytes: valueBytes}}
 	value.InitChecksum(key)
 	return &PutRequest{
 		RequestHeader: RequestHeader{
@@ -309,6 +381,18 @@ func ScanArgs(key, endKey Key, maxResults int64) *ScanRequest {
 	}
 }
 
+// ReverseScanArgs returns a ReverseScanRequest object initialized to
+// scan from end to start keys with max results.
+func ReverseScanArgs(key, endKey Key, maxResults int64) *ReverseScanRequest {
+	return &ReverseScanRequest{
+		RequestHeader: RequestHeader{
+			Key:    key,
+			EndKey: endKey,
+		},
+		MaxResults: maxResults,
+	}
+}
+
 // MethodForRequest returns the method name corresponding to the type
 // of the request.
 func MethodForRequest(req Request) (string, error) {
@@ -321,6 +405,8 @@ func MethodForRequest(req Request) (string, error) {
 		return Put, nil
 	case *ConditionalPutRequest:
 		return ConditionalPut, nil
+	case *CompareAndSwapRequest:
+		return CompareAndSwap, nil
 	case *IncrementRequest:
 		return Increment, nil
 	case *DeleteRequest:
@@ -329,6 +415,8 @@ func MethodForRequest(req Request) (string, error) {
 		return DeleteRange, nil
 	case *ScanRequest:
 		return Scan, nil
+	case *ReverseScanRequest:
+		return ReverseScan, nil
 	case *EndTransactionRequest:
 		return EndTransaction, nil
 	case *ReapQueueRequest:
@@ -351,6 +439,18 @@ func MethodForRequest(req Request) (string, error) {
 		return InternalSnapshotCopy, nil
 	case *InternalMergeRequest:
 		return InternalMerge, nil
+	case *InternalConditionalPutInlineRequest:
+		return InternalConditionalPutInline, nil
+	case *InternalDeleteInlineRequest:
+		return InternalDeleteInline, nil
+	case *InternalComputeChecksumRequest:
+		return InternalComputeChecksum, nil
+	case *InternalVerifyChecksumRequest:
+		return InternalVerifyChecksum, nil
+	case *InternalRecomputeStatsRequest:
+		return InternalRecomputeStats, nil
+	case *InternalGCRequest:
+		return InternalGC, nil
 	}
 	return "", util.Errorf("unhandled request %T", req)
 }
@@ -376,6 +476,8 @@ func CreateArgs(method string) (Request, error) {
 		return &PutRequest{}, nil
 	case ConditionalPut:
 		return &ConditionalPutRequest{}, nil
+	case CompareAndSwap:
+		return &CompareAndSwapRequest{}, nil
 	case Increment:
 		return &IncrementRequest{}, nil
 	case Delete:
@@ -384,6 +486,8 @@ func CreateArgs(method string) (Request, error) {
 		return &DeleteRangeRequest{}, nil
 	case Scan:
 		return &ScanRequest{}, nil
+	case ReverseScan:
+		return &ReverseScanRequest{}, nil
 	case EndTransaction:
 		return &EndTransactionRequest{}, nil
 	case ReapQueue:
@@ -406,6 +510,18 @@ func CreateArgs(method string) (Request, error) {
 		return &InternalSnapshotCopyRequest{}, nil
 	case InternalMerge:
 		return &InternalMergeRequest{}, nil
+	case InternalConditionalPutInline:
+		return &InternalConditionalPutInlineRequest{}, nil
+	case InternalDeleteInline:
+		return &InternalDeleteInlineRequest{}, nil
+	case InternalComputeChecksum:
+		return &InternalComputeChecksumRequest{}, nil
+	case InternalVerifyChecksum:
+		return &InternalVerifyChecksumRequest{}, nil
+	case InternalRecomputeStats:
+		return &InternalRecomputeStatsRequest{}, nil
+	case InternalGC:
+		return &InternalGCRequest{}, nil
 	}
 	return nil, util.Errorf("unhandled method %s", method)
 }
@@ -421,6 +537,8 @@ func CreateReply(method string) (Response, error) {
 		return &PutResponse{}, nil
 	case ConditionalPut:
 		return &ConditionalPutResponse{}, nil
+	case CompareAndSwap:
+		return &CompareAndSwapResponse{}, nil
 	case Increment:
 		return &IncrementResponse{}, nil
 	case Delete:
@@ -429,6 +547,8 @@ func CreateReply(method string) (Response, error) {
 		return &DeleteRangeResponse{}, nil
 	case Scan:
 		return &ScanResponse{}, nil
+	case ReverseScan:
+		return &ReverseScanResponse{}, nil
 	case EndTransaction:
 		return &EndTransactionResponse{}, nil
 	case ReapQueue:
@@ -451,6 +571,18 @@ func CreateReply(method string) (Response, error) {
 		return &InternalSnapshotCopyResponse{}, nil
 	case InternalMerge:
 		return &InternalMergeResponse{}, nil
+	case InternalConditionalPutInline:
+		return &InternalConditionalPutInlineResponse{}, nil
+	case InternalDeleteInline:
+		return &InternalDeleteInlineResponse{}, nil
+	case InternalComputeChecksum:
+		return &InternalComputeChecksumResponse{}, nil
+	case InternalVerifyChecksum:
+		return &InternalVerifyChecksumResponse{}, nil
+	case InternalRecomputeStats:
+		return &InternalRecomputeStatsResponse{}, nil
+	case InternalGC:
+		return &InternalGCResponse{}, nil
 	}
 	return nil, util.Errorf("unhandled method %s", method)
 }
@@ -496,12 +628,26 @@ func (rh *ResponseHeader) Combine(otherRH *ResponseHeader) {
 		if rh.Txn != nil && otherRH.GetTxn() == nil {
 			rh.Txn = nil
 		}
+		rh.Trace = append(rh.Trace, otherRH.GetTrace()...)
 	}
 }
 
 // Combine implements the Combinable interface for ScanResponse.
+// Responses must be combined in ascending order of their ranges; the
+// resume key is taken from the last response.
 func (sr *ScanResponse) Combine(c Response) {
 	otherSR := c.(*ScanResponse)
+	if sr != nil {
+		sr.Rows = append(sr.Rows, otherSR.GetRows()...)
+		sr.ResumeKey = otherSR.ResumeKey
+		sr.Header().Combine(otherSR.Header())
+	}
+}
+
+// Combine implements the Combinable interface for ReverseScanResponse.
+// Responses must be combined in descending order of their ranges.
+func (sr *ReverseScanResponse) Combine(c Response) {
+	otherSR := c.(*ReverseScanResponse)
 	if sr != nil {
 		sr.Rows = append(sr.Rows, otherSR.GetRows()...)
 		sr.Header().Combine(otherSR.Header())
@@ -531,6 +677,26 @@ func (rh *RequestHeader) Header() *RequestHeader {
 	return rh
 }
 
+// HasRouting returns true if any routing information (the replica or
+// Raft ID) is set in the header.
+func (rh *RequestHeader) HasRouting() bool {
+	return rh.RaftID != 0 || rh.Replica.NodeID != 0 || rh.Replica.StoreID != 0
+}
+
+// SetRouting sets the routing information in the header, addressing
+// the request to the specified replica of the specified range.
+func (rh *RequestHeader) SetRouting(raftID int64, replica Replica) {
+	rh.RaftID = raftID
+	rh.Replica = replica
+}
+
+// ClearRouting clears the routing information in the header so that
+// the request will be re-addressed when next sent.
+func (rh *RequestHeader) ClearRouting() {
+	rh.RaftID = 0
+	rh.Replica = Replica{}
+}
+
 // Header implements the Response interface for ResponseHeader.
 func (rh *ResponseHeader) Header() *ResponseHeader {
 	return rh
@@ -591,6 +757,17 @@ func (sr *ScanResponse) Verify(req Request) error {
 	return nil
 }
 
+// Verify verifies the integrity of every value returned in the
+// reverse scan.
+func (sr *ReverseScanResponse) Verify(req Request) error {
+	for _, kv := range sr.Rows {
+		if err := kv.Value.Verify(kv.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Add adds a request to the batch request. The batch inherits
 // the key range of the first request added to it.
 //
@@ -612,3 +789,105 @@ func (br *BatchResponse) Add(reply Response) {
 	union.SetValue(reply)
 	br.Responses = append(br.Responses, union)
 }
+
+// RequestLimits bounds the sizes of the keys and values of requests,
+// and the total size of batches. A zero limit is not enforced.
+// Enforcing the limits where requests enter the system turns requests
+// which would otherwise fail as oversized raft commands into clear
+// errors.
+type RequestLimits struct {
+	MaxKeySize   int64
+	MaxValueSize int64
+	MaxBatchSize int64
+}
+
+// DefaultRequestLimits are the limits enforced by clients and servers
+// unless configured otherwise. Values and batches are kept well below
+// the default maximum range size, as each is proposed to raft as a
+// single command.
+var DefaultRequestLimits = RequestLimits{
+	MaxKeySize:   KeyMaxLength,
+	MaxValueSize: 8 << 20,  // 8M
+	MaxBatchSize: 32 << 20, // 32M
+}
+
+// Verify returns a RequestTooLargeError if the supplied request, or
+// any request in a batch, exceeds the limits.
+func (l RequestLimits) Verify(args Request) error {
+	if br, ok := args.(*BatchRequest); ok {
+		var size int64
+		for i := range br.Requests {
+			req := br.Requests[i].GetValue().(Request)
+			if err := l.Verify(req); err != nil {
+				return err
+			}
+			size += requestSize(req)
+		}
+		if l.MaxBatchSize > 0 && size > l.MaxBatchSize {
+			return NewRequestTooLargeError("batch", size, l.MaxBatchSize)
+		}
+		return nil
+	}
+	header := args.Header()
+	for _, key := range []Key{header.Key, header.EndKey} {
+		// Keys in the system keyspace, which begin with a zero byte,
+		// carry prefixes of their own and are left to the store's key
+		// length verification.
+		if len(key) > 0 && key[0] == 0 {
+			continue
+		}
+		if size := int64(len(key)); l.MaxKeySize > 0 && size > l.MaxKeySize {
+			return NewRequestTooLargeError("key", size, l.MaxKeySize)
+		}
+	}
+	if size := valueSize(args); l.MaxValueSize > 0 && size > l.MaxValueSize {
+		return NewRequestTooLargeError("value", size, l.MaxValueSize)
+	}
+	return nil
+}
+
+// valueSize returns the size of the value carried by a request, if
+// any. The expected value of a ConditionalPut is included.
+func valueSize(args Request) int64 {
+	switch t := args.(type) {
+	case *PutRequest:
+		return int64(len(t.Value.Bytes))
+	case *ConditionalPutRequest:
+		size := int64(len(t.Value.Bytes))
+		if t.ExpValue != nil {
+			size += int64(len(t.ExpValue.Bytes))
+		}
+		return size
+	case *CompareAndSwapRequest:
+		var size int64
+		for _, c := range t.Conditions {
+			if c.ExpValue != nil {
+				size += int64(len(c.ExpValue.Bytes))
+			}
+		}
+		for _, w := range t.Writes {
+			if w.Value != nil {
+				size += int64(len(w.Value.Bytes))
+			}
+		}
+		return size
+	case *EnqueueMessageRequest:
+		return int64(len(t.Msg.Bytes))
+	case *InternalMergeRequest:
+		return int64(len(t.Value.Bytes))
+	case *InternalConditionalPutInlineRequest:
+		size := int64(len(t.Value.Bytes))
+		if t.ExpValue != nil {
+			size += int64(len(t.ExpValue.Bytes))
+		}
+		return size
+	}
+	return 0
+}
+
+// requestSize returns the approximate size of a request, counting its
+// keys and value.
+func requestSize(args Request) int64 {
+	header := args.Header()
+	return int64(len(header.Key)+len(header.EndKey)) + valueSize(args)
+}
@@ -85,7 +85,7 @@ func TestCombinable(t *testing.T) {
 	sr1 := &ScanResponse{
 		ResponseHeader: ResponseHeader{Timestamp: MinTimestamp},
 		Rows: []KeyValue{
-			{Key: Key("A"), Value: Value{Bytes: []byte("V")}},
+			{Key: Key("A"), Value: Value{ValuePayload: ValuePayload{Bytes: []byte("V")}}},
 		},
 	}
 
@@ -96,7 +96,7 @@ func TestCombinable(t *testing.T) {
 	sr2 := &ScanResponse{
 		ResponseHeader: ResponseHeader{Timestamp: MinTimestamp},
 		Rows: []KeyValue{
-			{Key: Key("B"), Value: Value{Bytes: []byte("W")}},
+			{Key: Key("B"), Value: Value{ValuePayload: ValuePayload{Bytes: []byte("W")}}},
 		},
 	}
 	sr2.Timestamp = MaxTimestamp
@@ -139,3 +139,57 @@ func TestCombinable(t *testing.T) {
 		t.Errorf("wanted %v, got %v", wantedDR, dr1)
 	}
 }
+
+// TestRequestLimitsVerify verifies that keys, values and batches which
+// exceed the limits are rejected with a RequestTooLargeError.
+func TestRequestLimitsVerify(t *testing.T) {
+	limits := RequestLimits{MaxKeySize: 4, MaxValueSize: 4, MaxBatchSize: 10}
+	put := func(key, value string) *PutRequest {
+		return &PutRequest{
+			RequestHeader: RequestHeader{Key: Key(key)},
+			Value:         Value{ValuePayload: ValuePayload{Bytes: []byte(value)}},
+		}
+	}
+	batch := func(args ...Request) *BatchRequest {
+		br := &BatchRequest{}
+		for _, a := range args {
+			br.Add(a)
+		}
+		return br
+	}
+	testCases := []struct {
+		args    Request
+		expKind string // empty for no error
+	}{
+		{put("a", "b"), ""},
+		{put("aaaa", "bbbb"), ""},
+		{put("aaaaa", "b"), "key"},
+		{put("\x00aaaaa", "b"), ""}, // system keys are exempt
+		{put("a", "bbbbb"), "value"},
+		{&ScanRequest{RequestHeader: RequestHeader{Key: Key("a"), EndKey: Key("bbbbb")}}, "key"},
+		{&ConditionalPutRequest{
+			RequestHeader: RequestHeader{Key: Key("a")},
+			Value:         Value{ValuePayload: ValuePayload{Bytes: []byte("bb")}},
+			ExpValue:      &Value{ValuePayload: ValuePayload{Bytes: []byte("ccc")}},
+		}, "value"},
+		{batch(put("a", "bbbb"), put("c", "dddd")), ""},
+		{batch(put("a", "bbbb"), put("c", "dddd"), put("e", "f")), "batch"},
+		{batch(put("a", "b"), put("c", "ddddd")), "value"},
+	}
+	for i, test := range testCases {
+		err := limits.Verify(test.args)
+		if test.expKind == "" {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if tErr, ok := err.(*RequestTooLargeError); !ok || tErr.Kind != test.expKind {
+			t.Errorf("%d: expected %s too large error; got %v", i, test.expKind, err)
+		}
+	}
+	// A zero limit isn't enforced.
+	if err := (RequestLimits{}).Verify(put("aaaaaaaa", "bbbbbbbb")); err != nil {
+		t.Errorf("unexpected error with zero limits: %s", err)
+	}
+}
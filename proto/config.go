@@ -23,6 +23,8 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+
+	"github.com/cockroachdb/cockroach/util"
 )
 
 // IsSubset returns whether attributes list a is a subset of
@@ -55,21 +57,129 @@ func (a Attributes) SortedString() string {
 	return strings.Join(attrs, ",")
 }
 
+// Constraints are the attributes a replica's store must have and must
+// not have.
+type Constraints struct {
+	Required   []string
+	Prohibited []string
+}
+
+// ParseConstraints parses a list of constraints. Each is an attribute
+// prefixed by "+" if required or "-" if prohibited. Attributes without
+// a prefix are required, so a plain attribute list (as used by zone
+// config replica attributes) parses as the required attributes.
+func ParseConstraints(strs []string) (Constraints, error) {
+	var c Constraints
+	required := map[string]struct{}{}
+	prohibited := map[string]struct{}{}
+	for _, s := range strs {
+		attr, prohibit := s, false
+		if strings.HasPrefix(s, "+") {
+			attr = s[1:]
+		} else if strings.HasPrefix(s, "-") {
+			attr, prohibit = s[1:], true
+		}
+		if len(attr) == 0 {
+			return Constraints{}, util.Errorf("empty attribute in constraint %q", s)
+		}
+		if prohibit {
+			if _, ok := required[attr]; ok {
+				return Constraints{}, util.Errorf("attribute %q is both required and prohibited", attr)
+			}
+			if _, ok := prohibited[attr]; !ok {
+				prohibited[attr] = struct{}{}
+				c.Prohibited = append(c.Prohibited, attr)
+			}
+		} else {
+			if _, ok := prohibited[attr]; ok {
+				return Constraints{}, util.Errorf("attribute %q is both required and prohibited", attr)
+			}
+			if _, ok := required[attr]; !ok {
+				required[attr] = struct{}{}
+				c.Required = append(c.Required, attr)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Satisfied returns whether attrs contains every required attribute
+// and no prohibited attribute.
+func (c Constraints) Satisfied(attrs Attributes) bool {
+	if !(Attributes{Attrs: c.Required}).IsSubset(attrs) {
+		return false
+	}
+	for _, p := range c.Prohibited {
+		for _, a := range attrs.Attrs {
+			if a == p {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String formats the constraints using the syntax accepted by
+// ParseConstraints.
+func (c Constraints) String() string {
+	var strs []string
+	for _, r := range c.Required {
+		strs = append(strs, "+"+r)
+	}
+	for _, p := range c.Prohibited {
+		strs = append(strs, "-"+p)
+	}
+	return "[" + strings.Join(strs, ",") + "]"
+}
+
+// ReplicaConstraints returns the constraints for each replica in the
+// zone, combining the zone-wide constraints with those for the
+// replica.
+func (z *ZoneConfig) ReplicaConstraints() ([]Constraints, error) {
+	cs := make([]Constraints, len(z.ReplicaAttrs))
+	for i, attrs := range z.ReplicaAttrs {
+		var err error
+		strs := append(append([]string(nil), z.Constraints...), attrs.Attrs...)
+		if cs[i], err = ParseConstraints(strs); err != nil {
+			return nil, util.Errorf("replica %d: %s", i, err)
+		}
+	}
+	return cs, nil
+}
+
+// Validate returns an error if the zone config's constraints are
+// malformed.
+func (z *ZoneConfig) Validate() error {
+	_, err := z.ReplicaConstraints()
+	return err
+}
+
+// KeyRange returns the span of keys addressed by this RangeDescriptor.
+func (r *RangeDescriptor) KeyRange() KeyRange {
+	return KeyRange{Start: r.StartKey, End: r.EndKey}
+}
+
 // ContainsKey returns whether this RangeDescriptor contains the specified key.
 func (r *RangeDescriptor) ContainsKey(key []byte) bool {
-	return bytes.Compare(key, r.StartKey) >= 0 && bytes.Compare(key, r.EndKey) < 0
+	return r.KeyRange().Contains(key)
 }
 
 // ContainsKeyRange returns whether this RangeDescriptor contains the specified
 // key range from start to end.
 func (r *RangeDescriptor) ContainsKeyRange(start, end []byte) bool {
-	if len(end) == 0 {
-		end = append(append([]byte(nil), start...), byte(0))
-	}
-	if bytes.Compare(end, start) < 0 {
+	if len(end) != 0 && bytes.Compare(end, start) < 0 {
 		panic(fmt.Sprintf("start key is larger than end key %q > %q", string(start), string(end)))
 	}
-	return bytes.Compare(start, r.StartKey) >= 0 && bytes.Compare(r.EndKey, end) >= 0
+	return r.KeyRange().ContainsRange(KeyRange{Start: start, End: end})
+}
+
+// NewerThan returns whether this RangeDescriptor supersedes the
+// specified descriptor. Descriptors are ordered by generation, which
+// is incremented when a range splits and when unsafe recovery removes
+// lost replicas. Merges and other replica changes don't yet bump it,
+// so it orders only descriptors separated by those events.
+func (r *RangeDescriptor) NewerThan(o *RangeDescriptor) bool {
+	return r.Generation > o.Generation
 }
 
 // FindReplica returns the replica which matches the specified store
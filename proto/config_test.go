@@ -19,6 +19,7 @@ package proto
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 )
 
@@ -62,6 +63,76 @@ func TestAttributesSortedString(t *testing.T) {
 	}
 }
 
+func TestParseConstraints(t *testing.T) {
+	testCases := []struct {
+		strs   []string
+		exp    Constraints
+		expErr bool
+	}{
+		{nil, Constraints{}, false},
+		{[]string{"ssd", "+us-east"}, Constraints{Required: []string{"ssd", "us-east"}}, false},
+		{[]string{"+ssd", "-region=us-east", "ssd"}, Constraints{Required: []string{"ssd"}, Prohibited: []string{"region=us-east"}}, false},
+		{[]string{"+ssd", "-ssd"}, Constraints{}, true},
+		{[]string{"-"}, Constraints{}, true},
+		{[]string{""}, Constraints{}, true},
+	}
+	for i, test := range testCases {
+		c, err := ParseConstraints(test.strs)
+		if test.expErr {
+			if err == nil {
+				t.Errorf("%d: expected error parsing %q", i, test.strs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		} else if !reflect.DeepEqual(c, test.exp) {
+			t.Errorf("%d: expected %+v; got %+v", i, test.exp, c)
+		}
+	}
+}
+
+func TestConstraintsSatisfied(t *testing.T) {
+	c := Constraints{Required: []string{"ssd"}, Prohibited: []string{"region=us-east"}}
+	testCases := []struct {
+		attrs []string
+		exp   bool
+	}{
+		{nil, false},
+		{[]string{"ssd"}, true},
+		{[]string{"ssd", "region=us-west"}, true},
+		{[]string{"ssd", "region=us-east"}, false},
+		{[]string{"hdd", "region=us-west"}, false},
+	}
+	for i, test := range testCases {
+		if sat := c.Satisfied(Attributes{Attrs: test.attrs}); sat != test.exp {
+			t.Errorf("%d: expected %s satisfied by %q to be %t", i, c, test.attrs, test.exp)
+		}
+	}
+}
+
+func TestZoneConfigReplicaConstraints(t *testing.T) {
+	z := &ZoneConfig{
+		ReplicaAttrs: []Attributes{{Attrs: []string{"ssd"}}, {Attrs: []string{"-ssd"}}},
+		Constraints:  []string{"-region=us-east"},
+	}
+	cs, err := z.ReplicaConstraints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []Constraints{
+		{Required: []string{"ssd"}, Prohibited: []string{"region=us-east"}},
+		{Prohibited: []string{"region=us-east", "ssd"}},
+	}
+	if !reflect.DeepEqual(cs, exp) {
+		t.Errorf("expected %+v; got %+v", exp, cs)
+	}
+	z.Constraints = []string{"ssd"}
+	if err := z.Validate(); err == nil {
+		t.Error("expected error validating conflicting constraints")
+	}
+}
+
 func TestRangeDescriptorFindReplica(t *testing.T) {
 	desc := RangeDescriptor{
 		Replicas: []Replica{
@@ -20,10 +20,14 @@ package proto
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"math"
 	"math/rand"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,7 +35,6 @@ import (
 	"code.google.com/p/biogo.store/llrb"
 	"code.google.com/p/go-uuid/uuid"
 	"github.com/cockroachdb/cockroach/util"
-	"github.com/cockroachdb/cockroach/util/encoding"
 	gogoproto "github.com/gogo/protobuf/proto"
 )
 
@@ -83,25 +86,28 @@ func bytesNext(b []byte) []byte {
 //   "\xff...\x01" -> "\xff...\x00"
 //   "\xff...\x00" -> "\xff..." (same except the \x00 is removed)
 //   "\xff..." -> "\xff...\xfe"
-func bytesPrev(b []byte) []byte {
+func bytesPrev(b []byte) ([]byte, error) {
 	length := len(b)
 
 	// When the byte array is empty.
 	if length == 0 {
-		panic(fmt.Sprint("cannot get the prev bytes of an empty byte array"))
+		return nil, util.Errorf("cannot get the prev bytes of an empty byte array")
 	}
 
 	// If the last byte is a 0, then drop it.
 	if b[length-1] == 0 {
-		return append([]byte(nil), b[0:length-1]...)
+		return append([]byte(nil), b[0:length-1]...), nil
 	}
 
 	// If the last byte isn't 0, subtract one from it and
 	// append "\xff"s until the end of the key space.
 	prefix := b[0 : length-1]
 	reducedValue := []byte{b[length-1] - 1}
-	postfix := KeyMax[length:KeyMaxLength]
-	return bytes.Join([][]byte{prefix, reducedValue, postfix}, nil)
+	var postfix []byte
+	if length < KeyMaxLength {
+		postfix = KeyMax[length:KeyMaxLength]
+	}
+	return bytes.Join([][]byte{prefix, reducedValue, postfix}, nil), nil
 }
 
 func bytesPrefixEnd(b []byte) []byte {
@@ -122,9 +128,25 @@ func (k Key) Next() Key {
 	return Key(bytesNext(k))
 }
 
-// Prev returns the prev key in lexicographic sort order.
+// Prev returns the prev key in lexicographic sort order. Prev panics
+// on KeyMin, which has no predecessor; use PrevSafe for keys which may
+// be KeyMin.
 func (k Key) Prev() Key {
-	return Key(bytesPrev(k))
+	prev, err := k.PrevSafe()
+	if err != nil {
+		panic(err)
+	}
+	return prev
+}
+
+// PrevSafe returns the prev key in lexicographic sort order, or an
+// error if the key is KeyMin.
+func (k Key) PrevSafe() (Key, error) {
+	prev, err := bytesPrev(k)
+	if err != nil {
+		return nil, err
+	}
+	return Key(prev), nil
 }
 
 // Next returns the next key in lexicographic sort order.
@@ -178,14 +200,73 @@ func (k Key) Compare(b interval.Comparable) int {
 }
 
 // String returns a string-formatted version, with a maximum
-// key formatted for brevity as "\xff...".
+// key formatted for brevity as "\xff...". Keys with a registered
+// formatter are rendered as human-readable paths; see
+// RegisterKeyFormatter. If key redaction is enabled, the key is
+// redacted; see Key.Redact.
 func (k Key) String() string {
+	if KeyRedactionEnabled() {
+		return k.Redact()
+	}
+	if s, ok := k.PrettyPrint(); ok {
+		return s
+	}
+	return k.format()
+}
+
+// format returns the unredacted string-formatted version of the key.
+func (k Key) format() string {
 	if idx := bytes.Index(k, KeyMax); idx != -1 {
 		return string(MakeKey(k[:idx], Key("\xff..."), k[idx+KeyMaxLength:]))
 	}
 	return string(k)
 }
 
+// A KeyRange is the span of keys from Start (inclusive) to End
+// (exclusive). An empty End denotes the single key Start.
+type KeyRange struct {
+	Start, End Key
+}
+
+// end returns the exclusive end of the key range.
+func (kr KeyRange) end() Key {
+	if len(kr.End) == 0 {
+		return kr.Start.Next()
+	}
+	return kr.End
+}
+
+// Contains returns whether the key range contains key.
+func (kr KeyRange) Contains(key Key) bool {
+	return !key.Less(kr.Start) && key.Less(kr.end())
+}
+
+// ContainsRange returns whether the key range contains every key in o.
+func (kr KeyRange) ContainsRange(o KeyRange) bool {
+	return !o.Start.Less(kr.Start) && !kr.end().Less(o.end())
+}
+
+// Overlaps returns whether the key range and o have any key in common.
+func (kr KeyRange) Overlaps(o KeyRange) bool {
+	return kr.Start.Less(o.end()) && o.Start.Less(kr.end())
+}
+
+// Intersect returns the keys common to the key range and o. Returns
+// false if the key ranges don't overlap.
+func (kr KeyRange) Intersect(o KeyRange) (KeyRange, bool) {
+	if !kr.Overlaps(o) {
+		return KeyRange{}, false
+	}
+	res := KeyRange{Start: kr.Start, End: kr.end()}
+	if res.Start.Less(o.Start) {
+		res.Start = o.Start
+	}
+	if oEnd := o.end(); oEnd.Less(res.End) {
+		res.End = oEnd
+	}
+	return res, true
+}
+
 // The following methods implement the custom marshalling and
 // unmarshalling necessary to define gogoproto custom types.
 
@@ -211,15 +292,56 @@ func (k *EncodedKey) Unmarshal(bytes []byte) error {
 	return nil
 }
 
-// The following methods implement custom unmarshalling necessary
-// for key objects to be converted from JSON.
+// The following methods implement custom marshalling necessary for
+// key objects to be converted to and from JSON and text. Keys are
+// represented as Go-escaped strings without the surrounding quotes
+// (e.g. \x00\x00meta2foo), which are readable and round-trip exactly.
+// gob ignores encoding.TextMarshaler (golang.org/issue/6760), so the
+// gob encoding of gossiped keys is unaffected.
 
-// UnmarshalJSON implements the json Unmarshaler interface.
-func (k *Key) UnmarshalJSON(bytes []byte) error {
-	*k = Key(append([]byte(nil), bytes...))
+// MarshalText implements the encoding.TextMarshaler interface.
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(escapeBytes(k)), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (k *Key) UnmarshalText(text []byte) error {
+	b, err := unescapeBytes(string(text))
+	if err != nil {
+		return util.Errorf("invalid key %q: %s", text, err)
+	}
+	*k = Key(b)
 	return nil
 }
 
+// UnmarshalJSON implements the json Unmarshaler interface.
+func (k *Key) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	return k.UnmarshalText([]byte(text))
+}
+
+// escapeBytes returns b as a Go-escaped string, without surrounding
+// quotes.
+func escapeBytes(b []byte) string {
+	q := strconv.Quote(string(b))
+	return q[1 : len(q)-1]
+}
+
+// unescapeBytes reverses escapeBytes. An empty string yields nil.
+func unescapeBytes(s string) ([]byte, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	u, err := strconv.Unquote(`"` + s + `"`)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(u), nil
+}
+
 // UnmarshalJSON implements the json Unmarshaler interface.
 func (k *EncodedKey) UnmarshalJSON(bytes []byte) error {
 	*k = EncodedKey(append([]byte(nil), bytes...))
@@ -253,12 +375,93 @@ func (t Timestamp) String() string {
 	return fmt.Sprintf("%d.%09d,%d", t.WallTime/1E9, t.WallTime%1E9, t.Logical)
 }
 
-// Add returns a timestamp with the WallTime and Logical components increased.
+// MarshalText implements the encoding.TextMarshaler interface. The
+// text is the same as String(): seconds and nanoseconds of the wall
+// time followed by the logical component, e.g. 1425600000.000000123,3.
+// As with keys, gob ignores this and keeps encoding the fields.
+func (t Timestamp) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (t *Timestamp) UnmarshalText(text []byte) error {
+	ts, err := ParseTimestamp(string(text))
+	if err != nil {
+		return err
+	}
+	*t = ts
+	return nil
+}
+
+// ParseTimestamp parses a timestamp formatted by Timestamp.String().
+func ParseTimestamp(s string) (Timestamp, error) {
+	dot, comma := strings.Index(s, "."), strings.LastIndex(s, ",")
+	if dot == -1 || comma < dot || len(s[dot+1:comma]) < 9 {
+		return Timestamp{}, util.Errorf("timestamp %q is not of the form <seconds>.<nanos>,<logical>", s)
+	}
+	secs, err := strconv.ParseInt(s[:dot], 10, 64)
+	if err != nil {
+		return Timestamp{}, util.Errorf("invalid seconds in timestamp %q: %s", s, err)
+	}
+	nanos, err := strconv.ParseInt(s[dot+1:comma], 10, 64)
+	if err != nil {
+		return Timestamp{}, util.Errorf("invalid nanoseconds in timestamp %q: %s", s, err)
+	}
+	logical, err := strconv.ParseInt(s[comma+1:], 10, 32)
+	if err != nil {
+		return Timestamp{}, util.Errorf("invalid logical component in timestamp %q: %s", s, err)
+	}
+	return Timestamp{WallTime: secs*1E9 + nanos, Logical: int32(logical)}, nil
+}
+
+// Add returns a timestamp with the WallTime and Logical components
+// increased. The logical component ranges over [0, math.MaxInt32];
+// if the sum overflows or underflows that range, it carries into or
+// borrows from the wall time.
 func (t Timestamp) Add(wallTime int64, logical int32) Timestamp {
+	l := int64(t.Logical) + int64(logical)
+	switch {
+	case l > math.MaxInt32:
+		wallTime++
+		l -= math.MaxInt32 + 1
+	case l < 0:
+		wallTime--
+		l += math.MaxInt32 + 1
+	}
 	return Timestamp{
 		WallTime: t.WallTime + wallTime,
-		Logical:  t.Logical + logical,
+		Logical:  int32(l),
+	}
+}
+
+// Next returns the timestamp immediately following this one. Next
+// panics on MaxTimestamp, which has no successor.
+func (t Timestamp) Next() Timestamp {
+	if t.Equal(MaxTimestamp) {
+		panic("cannot get the next timestamp of MaxTimestamp")
+	}
+	return t.Add(0, 1)
+}
+
+// Prev returns the timestamp immediately preceding this one. Prev
+// panics on ZeroTimestamp, which has no predecessor.
+func (t Timestamp) Prev() Timestamp {
+	if t.Equal(ZeroTimestamp) {
+		panic("cannot get the previous timestamp of ZeroTimestamp")
 	}
+	return t.Add(0, -1)
+}
+
+// GoTime converts the timestamp's wall time to a time.Time. The
+// logical component is dropped.
+func (t Timestamp) GoTime() time.Time {
+	return time.Unix(0, t.WallTime)
+}
+
+// TimestampFromGoTime returns a timestamp with the wall time of the
+// given time.Time and a zero logical component.
+func TimestampFromGoTime(t time.Time) Timestamp {
+	return Timestamp{WallTime: t.UnixNano()}
 }
 
 // Forward updates the timestamp from the one given, if that moves it
@@ -277,44 +480,233 @@ func (t *Timestamp) Backward(s Timestamp) {
 	}
 }
 
-// InitChecksum initializes a checksum based on the provided key and
-// the contents of the value. If the value contains a byte slice, the
-// checksum includes it directly; if the value contains an integer,
-// the checksum includes the integer as 8 bytes in big-endian order.
+// CastagnoliTable is the CRC-32C table used to checksum values,
+// snapshot chunks and replica contents. It's computed once; hash/crc32
+// recognizes it and uses the SSE4.2 CRC32 instruction where the CPU
+// supports it.
+var CastagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// InitChecksum initializes a CRC-32C checksum based on the provided
+// key and the contents of the value. If the value contains a byte
+// slice, the checksum includes it directly; if the value contains an
+// integer, the checksum includes the integer as 8 bytes in big-endian
+// order; if the value contains a float, the checksum includes its
+// IEEE 754 bits as 8 bytes in big-endian order. Values which already
+// carry a checksum of either version are left unchanged.
 func (v *Value) InitChecksum(key []byte) {
-	if v.Checksum == nil {
-		v.Checksum = gogoproto.Uint32(v.computeChecksum(key))
+	if v.Checksum == nil && v.ChecksumCRC32C == nil {
+		v.ChecksumCRC32C = gogoproto.Uint32(v.computeChecksum(key, CastagnoliTable))
 	}
 }
 
-// Verify verifies the value's Checksum matches a newly-computed
-// checksum of the value's contents. If the value's Checksum is not
-// set the verification is a noop. It also ensures that both Bytes
-// and Integer are not both set.
+// GetValueType returns the type of the value's payload. Values which
+// predate the Type field are migrated on the fly by inferring their
+// type from which of the payload fields is set. Values which predate
+// the payload itself must be upgraded first.
+func (v *Value) GetValueType() ValueType {
+	if v.Type != nil {
+		return v.GetType()
+	}
+	switch {
+	case v.Bytes != nil:
+		if v.GetTag() == _CR_TS.String() {
+			return TIMESERIES
+		}
+		return BYTES
+	case v.Integer != nil:
+		return INT
+	case v.Float != nil:
+		return FLOAT
+	}
+	return UNKNOWN_TYPE
+}
+
+// SetBytes sets the value's payload to the given byte slice, clearing
+// any other payload.
+func (v *Value) SetBytes(b []byte) {
+	v.ValuePayload = ValuePayload{Bytes: b}
+	v.Type = BYTES.Enum()
+}
+
+// SetInteger sets the value's payload to the given integer, clearing
+// any other payload.
+func (v *Value) SetInteger(i int64) {
+	v.ValuePayload = ValuePayload{Integer: gogoproto.Int64(i)}
+	v.Type = INT.Enum()
+}
+
+// SetFloat sets the value's payload to the given float, clearing any
+// other payload.
+func (v *Value) SetFloat(f float64) {
+	v.ValuePayload = ValuePayload{Float: gogoproto.Float64(f)}
+	v.Type = FLOAT.Enum()
+}
+
+// isLegacy returns true if the value was encoded before ValuePayload
+// existed and carries its payload in the legacy fields.
+func (v *Value) isLegacy() bool {
+	return v.LegacyBytes != nil || v.LegacyInteger != nil || v.LegacyFloat != nil
+}
+
+// Upgrade moves the payload of a value encoded before ValuePayload
+// existed from the legacy fields into the payload. Checksums cover
+// the payload's contents rather than its encoding, so they remain
+// valid. Upgrade is a noop for current values.
+func (v *Value) Upgrade() {
+	if !v.isLegacy() {
+		return
+	}
+	v.ValuePayload = ValuePayload{
+		Bytes:   v.LegacyBytes,
+		Integer: v.LegacyInteger,
+		Float:   v.LegacyFloat,
+	}
+	v.LegacyBytes, v.LegacyInteger, v.LegacyFloat = nil, nil, nil
+}
+
+// Verify verifies the value's checksums match newly-computed
+// checksums of the value's contents: ChecksumCRC32C for current
+// values and Checksum (CRC-32-IEEE) for values written before it. If
+// neither is set the verification is a noop. It also ensures that at
+// most one of Bytes, Integer and Float is set. Legacy values are
+// verified as if upgraded.
 func (v *Value) Verify(key []byte) error {
+	if v.isLegacy() {
+		u := *v
+		u.Upgrade()
+		v = &u
+	}
+	if v.ChecksumCRC32C != nil {
+		if v.GetChecksumCRC32C() != v.computeChecksum(key, CastagnoliTable) {
+			return util.Errorf("invalid checksum for key %q, value %+v", key, v)
+		}
+	}
 	if v.Checksum != nil {
-		if v.GetChecksum() != v.computeChecksum(key) {
+		if v.GetChecksum() != v.computeChecksum(key, crc32.IEEETable) {
 			return util.Errorf("invalid checksum for key %q, value %+v", key, v)
 		}
 	}
-	if v.Bytes != nil && v.Integer != nil {
-		return util.Errorf("both the value byte slice and integer fields are set for key %q: %+v", key, v)
+	return v.VerifyType(key)
+}
+
+// valueJSON is the JSON representation of a Value. Byte slices are
+// Go-escaped strings as for keys, and the type is named.
+type valueJSON struct {
+	Type           string     `json:"type,omitempty"`
+	Bytes          *string    `json:"bytes,omitempty"`
+	Integer        *int64     `json:"integer,omitempty"`
+	Float          *float64   `json:"float,omitempty"`
+	Checksum       *uint32    `json:"checksum,omitempty"`
+	ChecksumCRC32C *uint32    `json:"checksum_crc32c,omitempty"`
+	Timestamp      *Timestamp `json:"timestamp,omitempty"`
+	Tag            *string    `json:"tag,omitempty"`
+}
+
+// MarshalJSON implements the json Marshaler interface.
+func (v Value) MarshalJSON() ([]byte, error) {
+	v.Upgrade()
+	vj := valueJSON{
+		Integer:        v.Integer,
+		Float:          v.Float,
+		Checksum:       v.Checksum,
+		ChecksumCRC32C: v.ChecksumCRC32C,
+		Timestamp:      v.Timestamp,
+		Tag:            v.Tag,
+	}
+	if v.Type != nil {
+		vj.Type = v.GetType().String()
+	}
+	if v.Bytes != nil {
+		b := escapeBytes(v.Bytes)
+		vj.Bytes = &b
+	}
+	return json.Marshal(vj)
+}
+
+// UnmarshalJSON implements the json Unmarshaler interface.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var vj valueJSON
+	if err := json.Unmarshal(data, &vj); err != nil {
+		return err
+	}
+	*v = Value{
+		ValuePayload:   ValuePayload{Integer: vj.Integer, Float: vj.Float},
+		Checksum:       vj.Checksum,
+		ChecksumCRC32C: vj.ChecksumCRC32C,
+		Timestamp:      vj.Timestamp,
+		Tag:            vj.Tag,
+	}
+	if vj.Type != "" {
+		typ, ok := ValueType_value[vj.Type]
+		if !ok {
+			return util.Errorf("unknown value type %q", vj.Type)
+		}
+		v.Type = ValueType(typ).Enum()
+	}
+	if vj.Bytes != nil {
+		b, err := unescapeBytes(*vj.Bytes)
+		if err != nil {
+			return util.Errorf("invalid value bytes %q: %s", *vj.Bytes, err)
+		}
+		if b == nil {
+			b = []byte{}
+		}
+		v.Bytes = b
 	}
 	return nil
 }
 
-// computeChecksum computes a checksum based on the provided key and
-// the contents of the value. If the value contains a byte slice, the
-// checksum includes it directly; if the value contains an integer,
-// the checksum includes the integer as 8 bytes in big-endian order.
-func (v *Value) computeChecksum(key []byte) uint32 {
-	c := encoding.NewCRC32Checksum(key)
-	if v.Bytes != nil {
-		c.Write(v.Bytes)
-	} else if v.Integer != nil {
-		c.Write(encoding.EncodeUint64(nil, uint64(v.GetInteger())))
+// VerifyType returns an error if more than one of the value's Bytes,
+// Integer and Float fields is set, or if a set payload field does not
+// match the value's Type.
+func (v *Value) VerifyType(key []byte) error {
+	var n int
+	for _, set := range []bool{v.Bytes != nil, v.Integer != nil, v.Float != nil} {
+		if set {
+			n++
+		}
 	}
-	return c.Sum32()
+	if n > 1 {
+		return util.Errorf("more than one of the value byte slice, integer and float fields are set for key %q: %+v", key, v)
+	}
+	var ok bool
+	switch v.GetType() {
+	case UNKNOWN_TYPE:
+		ok = true
+	case BYTES, TIMESERIES:
+		ok = v.Integer == nil && v.Float == nil
+	case INT:
+		ok = v.Bytes == nil && v.Float == nil
+	case FLOAT:
+		ok = v.Bytes == nil && v.Integer == nil
+	}
+	if !ok {
+		return util.Errorf("value of type %s for key %q has a mismatched payload: %+v", v.GetType(), key, v)
+	}
+	return nil
+}
+
+// computeChecksum computes a CRC-32 checksum using the supplied table
+// based on the provided key and the contents of the value. If the
+// value contains a byte slice, the checksum includes it directly; if
+// the value contains an integer or a float, the checksum includes its
+// 8 byte big-endian encoding. The Type field itself is not
+// checksummed, so checksums of values written before it existed
+// remain valid.
+func (v *Value) computeChecksum(key []byte, tab *crc32.Table) uint32 {
+	crc := crc32.Update(0, tab, key)
+	var buf [8]byte
+	switch v.GetValueType() {
+	case BYTES, TIMESERIES:
+		crc = crc32.Update(crc, tab, v.Bytes)
+	case INT:
+		binary.BigEndian.PutUint64(buf[:], uint64(v.GetInteger()))
+		crc = crc32.Update(crc, tab, buf[:])
+	case FLOAT:
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(v.GetFloat()))
+		crc = crc32.Update(crc, tab, buf[:])
+	}
+	return crc
 }
 
 // KeyGetter is a hack to allow Compare() to work for the batch
@@ -446,6 +838,65 @@ func (t *Transaction) Update(o *Transaction) {
 	t.CertainNodes = NodeList{Nodes: append(Int32Slice(nil),
 		o.CertainNodes.Nodes...)}
 	t.UpgradePriority(o.Priority)
+	if t.Sequence < o.Sequence {
+		t.Sequence = o.Sequence
+	}
+	if len(t.IgnoredSequences) < len(o.IgnoredSequences) {
+		t.IgnoredSequences = append([]SequenceRange(nil), o.IgnoredSequences...)
+	}
+	if len(t.Savepoints) < len(o.Savepoints) {
+		t.Savepoints = append([]int32(nil), o.Savepoints...)
+	}
+}
+
+// IsSequenceIgnored returns whether the transaction's write at
+// sequence seq was rolled back to a savepoint.
+func (t *Transaction) IsSequenceIgnored(seq int32) bool {
+	for _, r := range t.IgnoredSequences {
+		if r.Start <= seq && seq <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSequenceVisible returns whether the transaction's write at
+// sequence seq is visible to it: written no later than its current
+// sequence and not rolled back. A zero current sequence sees all
+// writes which weren't rolled back.
+func (t *Transaction) IsSequenceVisible(seq int32) bool {
+	return (t.Sequence == 0 || seq <= t.Sequence) && !t.IsSequenceIgnored(seq)
+}
+
+// Savepoint records and returns a savepoint at the transaction's
+// latest write.
+func (t *Transaction) Savepoint() int32 {
+	if n := len(t.Savepoints); n == 0 || t.Savepoints[n-1] < t.Sequence {
+		t.Savepoints = append(t.Savepoints, t.Sequence)
+	}
+	return t.Sequence
+}
+
+// HasSavepointIn returns whether the transaction took a savepoint at
+// or after sequence start and before sequence end. Rolling back to
+// such a savepoint restores a value written at start and replaced at
+// end.
+func (t *Transaction) HasSavepointIn(start, end int32) bool {
+	for _, s := range t.Savepoints {
+		if start <= s && s < end {
+			return true
+		}
+	}
+	return false
+}
+
+// RollbackToSavepoint rolls back the transaction's writes made after
+// the savepoint, which is the sequence of the latest write at the
+// time it was taken.
+func (t *Transaction) RollbackToSavepoint(savepoint int32) {
+	if savepoint < t.Sequence {
+		t.IgnoredSequences = append(t.IgnoredSequences, SequenceRange{Start: savepoint + 1, End: t.Sequence})
+	}
 }
 
 // UpgradePriority sets transaction priority to the maximum of current
@@ -524,20 +975,32 @@ func (gc *GCMetadata) EstimatedBytes(now time.Time, currentNonLiveBytes int64) i
 	return expGCBytes
 }
 
-// Add adds the given NodeID to the interface (unless already present)
-// and restores ordering.
+// Add adds the given NodeID to the list (unless already present),
+// inserting it at its sorted position rather than resorting.
 func (s *NodeList) Add(nodeID int32) {
-	if !s.Contains(nodeID) {
-		(*s).Nodes = append(s.Nodes, nodeID)
-		sort.Sort(Int32Slice(s.Nodes))
+	i, ok := s.search(nodeID)
+	if ok {
+		return
 	}
+	s.Nodes = append(s.Nodes, 0)
+	copy(s.Nodes[i+1:], s.Nodes[i:])
+	s.Nodes[i] = nodeID
 }
 
-// Contains returns true if the underlying slice contains the given NodeID.
+// Contains returns true if the underlying slice contains the given
+// NodeID. It's consulted when reading intents with uncertainty, so it
+// uses a binary search of the sorted slice.
 func (s NodeList) Contains(nodeID int32) bool {
+	_, ok := s.search(nodeID)
+	return ok
+}
+
+// search returns the index at which nodeID is or would be inserted in
+// the sorted list, and whether it's present.
+func (s NodeList) search(nodeID int32) (int, bool) {
 	ns := s.GetNodes()
 	i := sort.Search(len(ns), func(i int) bool { return ns[i] >= nodeID })
-	return i < len(ns) && ns[i] == nodeID
+	return i, i < len(ns) && ns[i] == nodeID
 }
 
 // Int32Slice implements sort.Interface.
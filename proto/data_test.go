@@ -19,8 +19,12 @@ package proto
 
 import (
 	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"hash/crc32"
 	"math"
 	"math/rand"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -217,6 +221,108 @@ func TestPrevKey(t *testing.T) {
 	KeyMin.Prev()
 }
 
+// TestPrevKeySafe verifies that PrevSafe returns the predecessor of a
+// key and returns an error instead of panicking on KeyMin.
+func TestPrevKeySafe(t *testing.T) {
+	testCases := []struct {
+		key  Key
+		prev Key
+	}{
+		{Key("\x00"), Key("")},
+		{Key("test key\x00"), Key("test key")},
+		{
+			Key("\x01"),
+			Key(strings.Join([]string{
+				"\x00",
+				strings.Repeat("\xff", KeyMaxLength-1)}, "")),
+		},
+		// Keys longer than KeyMaxLength get no "\xff" postfix.
+		{
+			Key(strings.Repeat("a", KeyMaxLength+1)),
+			Key(strings.Join([]string{
+				strings.Repeat("a", KeyMaxLength),
+				"`"}, "")),
+		},
+	}
+	for i, c := range testCases {
+		prev, err := c.key.PrevSafe()
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if !prev.Equal(c.prev) {
+			t.Errorf("%d: unexpected prev key for %q: %q", i, c.key, prev)
+		}
+	}
+	if prev, err := KeyMin.PrevSafe(); err == nil {
+		t.Errorf("expected error getting prev of KeyMin; got %q", prev)
+	}
+}
+
+// TestKeyRange verifies the containment, overlap and intersection
+// helpers of KeyRange, including key ranges denoting a single key.
+func TestKeyRange(t *testing.T) {
+	kr := KeyRange{Start: Key("b"), End: Key("d")}
+	for _, test := range []struct {
+		key Key
+		exp bool
+	}{
+		{Key("a"), false},
+		{Key("b"), true},
+		{Key("c\xff"), true},
+		{Key("d"), false},
+	} {
+		if kr.Contains(test.key) != test.exp {
+			t.Errorf("expected Contains(%q) = %t", test.key, test.exp)
+		}
+	}
+
+	testCases := []struct {
+		o          KeyRange
+		contains   bool
+		overlaps   bool
+		intersects KeyRange
+	}{
+		{KeyRange{Start: Key("a"), End: Key("b")}, false, false, KeyRange{}},
+		{KeyRange{Start: Key("a"), End: Key("c")}, false, true, KeyRange{Key("b"), Key("c")}},
+		{KeyRange{Start: Key("b"), End: Key("d")}, true, true, KeyRange{Key("b"), Key("d")}},
+		{KeyRange{Start: Key("c"), End: Key("e")}, false, true, KeyRange{Key("c"), Key("d")}},
+		{KeyRange{Start: Key("a"), End: Key("e")}, false, true, KeyRange{Key("b"), Key("d")}},
+		{KeyRange{Start: Key("d"), End: Key("e")}, false, false, KeyRange{}},
+		// Single keys.
+		{KeyRange{Start: Key("a")}, false, false, KeyRange{}},
+		{KeyRange{Start: Key("c")}, true, true, KeyRange{Key("c"), Key("c\x00")}},
+		{KeyRange{Start: Key("d")}, false, false, KeyRange{}},
+	}
+	for i, test := range testCases {
+		if c := kr.ContainsRange(test.o); c != test.contains {
+			t.Errorf("%d: expected ContainsRange %t; got %t", i, test.contains, c)
+		}
+		if o := kr.Overlaps(test.o); o != test.overlaps {
+			t.Errorf("%d: expected Overlaps %t; got %t", i, test.overlaps, o)
+		}
+		if o := test.o.Overlaps(kr); o != test.overlaps {
+			t.Errorf("%d: expected reverse Overlaps %t; got %t", i, test.overlaps, o)
+		}
+		is, ok := kr.Intersect(test.o)
+		if ok != test.overlaps {
+			t.Errorf("%d: expected Intersect ok %t; got %t", i, test.overlaps, ok)
+		}
+		if ok && (!is.Start.Equal(test.intersects.Start) || !is.End.Equal(test.intersects.End)) {
+			t.Errorf("%d: expected intersection %q-%q; got %q-%q", i, test.intersects.Start,
+				test.intersects.End, is.Start, is.End)
+		}
+	}
+
+	// A single key contains only itself.
+	single := KeyRange{Start: Key("a")}
+	if !single.Contains(Key("a")) || single.Contains(Key("a\x00")) {
+		t.Error("expected single key range to contain only its key")
+	}
+	if !single.ContainsRange(single) {
+		t.Error("expected single key range to contain itself")
+	}
+}
+
 func TestKeyString(t *testing.T) {
 	if KeyMax.String() != "\xff..." {
 		t.Errorf("expected key max to display a compact version: %s", KeyMax.String())
@@ -226,6 +332,110 @@ func TestKeyString(t *testing.T) {
 	}
 }
 
+// TestKeyJSONRoundTrip verifies that keys marshal to readable JSON
+// strings and unmarshal back to the original bytes.
+func TestKeyJSONRoundTrip(t *testing.T) {
+	testCases := []struct {
+		key  Key
+		json string
+	}{
+		{nil, `""`},
+		{Key("a"), `"a"`},
+		{Key("\x00\x00meta2foo"), `"\\x00\\x00meta2foo"`},
+		{Key("quote\"tab\t"), `"quote\\\"tab\\t"`},
+		{Key("\xff\xff"), `"\\xff\\xff"`},
+	}
+	for i, test := range testCases {
+		b, err := json.Marshal(test.key)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if string(b) != test.json {
+			t.Errorf("%d: expected %s; got %s", i, test.json, b)
+		}
+		var k Key
+		if err := json.Unmarshal(b, &k); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !k.Equal(test.key) {
+			t.Errorf("%d: expected %q; got %q", i, test.key, k)
+		}
+	}
+	var k Key
+	if err := json.Unmarshal([]byte(`"\\xzz"`), &k); err == nil {
+		t.Error("expected error unmarshalling invalid escape")
+	}
+}
+
+// TestTimestampTextRoundTrip verifies that timestamps marshal to
+// their String() form and parse back, including negative values.
+func TestTimestampTextRoundTrip(t *testing.T) {
+	testCases := []struct {
+		ts   Timestamp
+		text string
+	}{
+		{ZeroTimestamp, "0.000000000,0"},
+		{makeTS(1425600000000000123, 3), "1425600000.000000123,3"},
+		{makeTS(-1, 0), "0.-00000001,0"},
+		{makeTS(-1500000000, -2), "-1.-500000000,-2"},
+		{MaxTimestamp, MaxTimestamp.String()},
+	}
+	for i, test := range testCases {
+		b, err := test.ts.MarshalText()
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if string(b) != test.text {
+			t.Errorf("%d: expected %s; got %s", i, test.text, b)
+		}
+		var ts Timestamp
+		if err := ts.UnmarshalText(b); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !ts.Equal(test.ts) {
+			t.Errorf("%d: expected %s; got %s", i, test.ts, ts)
+		}
+	}
+	for _, bad := range []string{"", "1", "1.5,0", "1.000000000", "a.000000000,0", "1.000000000,x"} {
+		if _, err := ParseTimestamp(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+// TestKeyTimestampGob verifies that text marshaling leaves the gob
+// encoding of keys and timestamps, which are gossiped, unchanged: both
+// encode to and decode from the bytes gob produced before they
+// implemented encoding.TextMarshaler.
+func TestKeyTimestampGob(t *testing.T) {
+	key := Key("\x00\x00meta2foo")
+	ts := makeTS(1425600000000000123, 3)
+	testCases := []struct {
+		value, decoded interface{}
+		gob            string
+	}{
+		{&key, new(Key), "\r\n\x00\n\x00\x00meta2foo"},
+		{&ts, new(Timestamp), "D\x7f\x03\x01\x01\tTimestamp\x01\xff\x80\x00\x01\x03\x01\bWallTime\x01" +
+			"\x04\x00\x01\aLogical\x01\x04\x00\x01\x10XXX_unrecognized\x01\n\x00\x00\x00\x0f\xff\x80" +
+			"\x01\xf8'\x91\x7fK/\x98\x00\xf6\x01\x06\x00"},
+	}
+	for i, test := range testCases {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(test.value); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if buf.String() != test.gob {
+			t.Errorf("%d: expected gob %q; got %q", i, test.gob, buf.String())
+		}
+		if err := gob.NewDecoder(strings.NewReader(test.gob)).Decode(test.decoded); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !reflect.DeepEqual(test.decoded, test.value) {
+			t.Errorf("%d: expected %v; got %v", i, test.value, test.decoded)
+		}
+	}
+}
+
 func makeTS(walltime int64, logical int32) Timestamp {
 	return Timestamp{
 		WallTime: walltime,
@@ -265,25 +475,149 @@ func TestEqual(t *testing.T) {
 	}
 }
 
+func TestTimestampForwardBackward(t *testing.T) {
+	testCases := []struct {
+		ts, s, expFwd, expBwd Timestamp
+	}{
+		{makeTS(1, 1), makeTS(1, 1), makeTS(1, 1), makeTS(1, 1)},
+		{makeTS(1, 1), makeTS(1, 2), makeTS(1, 2), makeTS(1, 1)},
+		{makeTS(1, 1), makeTS(2, 0), makeTS(2, 0), makeTS(1, 1)},
+		{makeTS(2, 0), makeTS(1, math.MaxInt32), makeTS(2, 0), makeTS(1, math.MaxInt32)},
+		{ZeroTimestamp, MaxTimestamp, MaxTimestamp, ZeroTimestamp},
+	}
+	for i, test := range testCases {
+		fwd, bwd := test.ts, test.ts
+		fwd.Forward(test.s)
+		bwd.Backward(test.s)
+		if !fwd.Equal(test.expFwd) {
+			t.Errorf("%d: expected forward %s; got %s", i, test.expFwd, fwd)
+		}
+		if !bwd.Equal(test.expBwd) {
+			t.Errorf("%d: expected backward %s; got %s", i, test.expBwd, bwd)
+		}
+	}
+}
+
+// TestTimestampAdd verifies that Add carries logical overflow into
+// the wall time and borrows from it on logical underflow.
+func TestTimestampAdd(t *testing.T) {
+	testCases := []struct {
+		ts       Timestamp
+		wallTime int64
+		logical  int32
+		expTS    Timestamp
+	}{
+		{makeTS(0, 0), 0, 0, makeTS(0, 0)},
+		{makeTS(1, 2), 3, 4, makeTS(4, 6)},
+		{makeTS(5, 5), -2, -3, makeTS(3, 2)},
+		{makeTS(1, math.MaxInt32-1), 0, 1, makeTS(1, math.MaxInt32)},
+		{makeTS(1, math.MaxInt32), 0, 1, makeTS(2, 0)},
+		{makeTS(1, math.MaxInt32), 0, math.MaxInt32, makeTS(2, math.MaxInt32-1)},
+		{makeTS(1, math.MaxInt32), 1, 2, makeTS(3, 1)},
+		{makeTS(1, 0), 0, -1, makeTS(0, math.MaxInt32)},
+		{makeTS(1, 1), 0, -1, makeTS(1, 0)},
+		{makeTS(1, 0), 0, math.MinInt32, makeTS(0, 0)},
+		{makeTS(1, math.MaxInt32), 0, math.MinInt32, makeTS(0, math.MaxInt32)},
+		{makeTS(2, 0), -1, -1, makeTS(0, math.MaxInt32)},
+	}
+	for i, test := range testCases {
+		if ts := test.ts.Add(test.wallTime, test.logical); !ts.Equal(test.expTS) {
+			t.Errorf("%d: expected %s + (%d, %d) = %s; got %s", i, test.ts, test.wallTime, test.logical, test.expTS, ts)
+		}
+	}
+}
+
+func TestTimestampNextPrev(t *testing.T) {
+	testCases := []struct {
+		ts, next Timestamp
+	}{
+		{ZeroTimestamp, MinTimestamp},
+		{makeTS(1, 0), makeTS(1, 1)},
+		{makeTS(1, math.MaxInt32-1), makeTS(1, math.MaxInt32)},
+		{makeTS(1, math.MaxInt32), makeTS(2, 0)},
+		{makeTS(math.MaxInt64-1, math.MaxInt32), makeTS(math.MaxInt64, 0)},
+		{MaxTimestamp.Prev(), MaxTimestamp},
+	}
+	for i, test := range testCases {
+		if next := test.ts.Next(); !next.Equal(test.next) {
+			t.Errorf("%d: expected next of %s to be %s; got %s", i, test.ts, test.next, next)
+		}
+		if prev := test.next.Prev(); !prev.Equal(test.ts) {
+			t.Errorf("%d: expected prev of %s to be %s; got %s", i, test.next, test.ts, prev)
+		}
+		if !test.ts.Less(test.next) {
+			t.Errorf("%d: expected %s < %s", i, test.ts, test.next)
+		}
+	}
+}
+
+func TestTimestampNextPrevPanic(t *testing.T) {
+	for i, fn := range []func(){
+		func() { MaxTimestamp.Next() },
+		func() { ZeroTimestamp.Prev() },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%d: expected panic", i)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestTimestampGoTime(t *testing.T) {
+	now := time.Unix(1425000000, 123456789)
+	ts := TimestampFromGoTime(now)
+	if e := makeTS(now.UnixNano(), 0); !ts.Equal(e) {
+		t.Errorf("expected %s; got %s", e, ts)
+	}
+	// The logical component is dropped when converting back.
+	if goTime := ts.Add(0, 5).GoTime(); !goTime.Equal(now) {
+		t.Errorf("expected %s; got %s", now, goTime)
+	}
+	if goTime := ZeroTimestamp.GoTime(); goTime.UnixNano() != 0 {
+		t.Errorf("expected the unix epoch; got %s", goTime)
+	}
+}
+
 func TestValueBothBytesAndIntegerSet(t *testing.T) {
 	k := []byte("key")
-	v := Value{Bytes: []byte("a"), Integer: gogoproto.Int64(0)}
+	v := Value{ValuePayload: ValuePayload{Bytes: []byte("a"), Integer: gogoproto.Int64(0)}}
 	if err := v.Verify(k); err == nil {
 		t.Error("expected error with both byte slice and integer fields set")
 	}
 }
 
+// TestValueMultipleTypesSet verifies that values with more than one of
+// the byte slice, integer and float fields set fail verification.
+func TestValueMultipleTypesSet(t *testing.T) {
+	k := []byte("key")
+	testCases := []Value{
+		{ValuePayload: ValuePayload{Bytes: []byte("a"), Float: gogoproto.Float64(0)}},
+		{ValuePayload: ValuePayload{Integer: gogoproto.Int64(0), Float: gogoproto.Float64(0)}},
+		{ValuePayload: ValuePayload{Bytes: []byte("a"), Integer: gogoproto.Int64(0), Float: gogoproto.Float64(0)}},
+	}
+	for i, v := range testCases {
+		if err := v.Verify(k); err == nil {
+			t.Errorf("%d: expected error with multiple value types set: %+v", i, v)
+		}
+	}
+	if err := (&Value{ValuePayload: ValuePayload{Float: gogoproto.Float64(1)}}).Verify(k); err != nil {
+		t.Errorf("unexpected error verifying float value: %s", err)
+	}
+}
+
 // TestValueZeroIntegerSerialization verifies that a value with
 // integer=0 set can be marshalled and unmarshalled successfully.
 // This tests exists because gob serialization treats integers
 // and pointers to integers as the same and so loses a proto.Value
-// which encodes integer=0.
-//
-// TODO(spencer): change Value type to switch between integer and
-//   []byte value types using a mechanism other than nil pointers.
+// which encodes integer=0. See TestValueZeroIntegerGob for how the
+// Type field addresses this.
 func TestValueZeroIntegerSerialization(t *testing.T) {
 	k := Key("key 00")
-	v := Value{Integer: gogoproto.Int64(0)}
+	v := Value{ValuePayload: ValuePayload{Integer: gogoproto.Int64(0)}}
 	v.InitChecksum(k)
 
 	data, err := gogoproto.Marshal(&v)
@@ -303,6 +637,177 @@ func TestValueZeroIntegerSerialization(t *testing.T) {
 	}
 }
 
+// TestValueZeroIntegerGob verifies that a typed value with integer=0
+// survives a gob round trip, even though gob drops the zero integer.
+func TestValueZeroIntegerGob(t *testing.T) {
+	k := Key("key 00")
+	v := Value{}
+	v.SetInteger(0)
+	v.InitChecksum(k)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		t.Fatal(err)
+	}
+	v2 := &Value{}
+	if err := gob.NewDecoder(&buf).Decode(v2); err != nil {
+		t.Fatal(err)
+	}
+	if typ := v2.GetValueType(); typ != INT {
+		t.Errorf("expected integer value type; got %s", typ)
+	} else if v2.GetInteger() != 0 {
+		t.Errorf("expected zero integer value; got %d", v2.GetInteger())
+	} else if err := v2.Verify(k); err != nil {
+		t.Errorf("failed value verification: %s", err)
+	}
+}
+
+// TestValueJSONRoundTrip verifies that values survive a JSON round
+// trip with their type, payload, checksum and timestamp intact.
+func TestValueJSONRoundTrip(t *testing.T) {
+	k := Key("key")
+	ts := makeTS(1, 2)
+	var values []Value
+	for _, v := range []Value{
+		{ValuePayload: ValuePayload{Bytes: []byte("a\x00\xff")}},
+		{ValuePayload: ValuePayload{Bytes: []byte{}}},
+		{ValuePayload: ValuePayload{Integer: gogoproto.Int64(0)}},
+		{ValuePayload: ValuePayload{Integer: gogoproto.Int64(-5)}},
+		{ValuePayload: ValuePayload{Float: gogoproto.Float64(1.5)}},
+	} {
+		v.InitChecksum(k)
+		values = append(values, v)
+	}
+	values = append(values, Value{}, Value{ValuePayload: ValuePayload{Bytes: []byte("b")}, Timestamp: &ts})
+
+	for i, v := range values {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		var v2 Value
+		if err := json.Unmarshal(b, &v2); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !reflect.DeepEqual(v, v2) {
+			t.Errorf("%d: expected %+v; got %+v (json %s)", i, v, v2, b)
+		}
+		if v.Checksum != nil || v.ChecksumCRC32C != nil {
+			if err := v2.Verify(k); err != nil {
+				t.Errorf("%d: failed value verification: %s", i, err)
+			}
+		}
+	}
+
+	if b, err := json.Marshal(Value{ValuePayload: ValuePayload{Integer: gogoproto.Int64(3)}}); err != nil {
+		t.Fatal(err)
+	} else if string(b) != `{"integer":3}` {
+		t.Errorf("expected untyped value to omit type; got %s", b)
+	}
+	var v Value
+	if err := json.Unmarshal([]byte(`{"type":"NOPE"}`), &v); err == nil {
+		t.Error("expected error unmarshalling unknown value type")
+	}
+}
+
+// TestValueTypeInference verifies that values written without the
+// Type field have their type inferred from the payload.
+func TestValueTypeInference(t *testing.T) {
+	testCases := []struct {
+		v   Value
+		typ ValueType
+	}{
+		{Value{}, UNKNOWN_TYPE},
+		{Value{ValuePayload: ValuePayload{Bytes: []byte("a")}}, BYTES},
+		{Value{ValuePayload: ValuePayload{Bytes: []byte{}}}, BYTES},
+		{Value{ValuePayload: ValuePayload{Bytes: []byte("a")}, Tag: gogoproto.String(_CR_TS.String())}, TIMESERIES},
+		{Value{ValuePayload: ValuePayload{Integer: gogoproto.Int64(0)}}, INT},
+		{Value{ValuePayload: ValuePayload{Float: gogoproto.Float64(0)}}, FLOAT},
+		// An explicit type takes precedence over inference.
+		{Value{Type: INT.Enum()}, INT},
+	}
+	for i, test := range testCases {
+		if typ := test.v.GetValueType(); typ != test.typ {
+			t.Errorf("%d: expected type %s; got %s", i, test.typ, typ)
+		}
+	}
+}
+
+// TestValueSetters verifies that setting a payload clears any other
+// payload and sets the value's type.
+func TestValueSetters(t *testing.T) {
+	k := []byte("key")
+	v := Value{ValuePayload: ValuePayload{Bytes: []byte("a")}}
+	v.SetInteger(1)
+	if v.Bytes != nil || v.GetInteger() != 1 || v.GetValueType() != INT {
+		t.Errorf("unexpected value after SetInteger: %+v", v)
+	}
+	v.SetFloat(1.5)
+	if v.Integer != nil || v.GetFloat() != 1.5 || v.GetValueType() != FLOAT {
+		t.Errorf("unexpected value after SetFloat: %+v", v)
+	}
+	v.SetBytes([]byte("b"))
+	if v.Float != nil || !bytes.Equal(v.Bytes, []byte("b")) || v.GetValueType() != BYTES {
+		t.Errorf("unexpected value after SetBytes: %+v", v)
+	}
+	if err := v.Verify(k); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestValueUpgrade verifies that values encoded before ValuePayload
+// existed decode into the legacy fields, verify with their original
+// checksums and are moved into the payload by Upgrade.
+func TestValueUpgrade(t *testing.T) {
+	k := []byte("key")
+	testCases := []struct {
+		legacy, current Value
+	}{
+		{Value{LegacyBytes: []byte("a")}, Value{ValuePayload: ValuePayload{Bytes: []byte("a")}}},
+		{Value{LegacyInteger: gogoproto.Int64(0)}, Value{ValuePayload: ValuePayload{Integer: gogoproto.Int64(0)}}},
+		{Value{LegacyFloat: gogoproto.Float64(1.5)}, Value{ValuePayload: ValuePayload{Float: gogoproto.Float64(1.5)}}},
+	}
+	for i, test := range testCases {
+		test.current.InitChecksum(k)
+		test.legacy.ChecksumCRC32C = test.current.ChecksumCRC32C
+		data, err := gogoproto.Marshal(&test.legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := Value{}
+		if err := gogoproto.Unmarshal(data, &v); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Verify(k); err != nil {
+			t.Errorf("%d: failed legacy value verification: %s", i, err)
+		}
+		v.Upgrade()
+		if !reflect.DeepEqual(v, test.current) {
+			t.Errorf("%d: expected upgraded value %+v; got %+v", i, test.current, v)
+		}
+		if err := v.Verify(k); err != nil {
+			t.Errorf("%d: failed upgraded value verification: %s", i, err)
+		}
+	}
+}
+
+// TestValueTypeMismatch verifies that a payload which does not match
+// the value's type fails verification.
+func TestValueTypeMismatch(t *testing.T) {
+	k := []byte("key")
+	testCases := []Value{
+		{Type: INT.Enum(), ValuePayload: ValuePayload{Bytes: []byte("a")}},
+		{Type: FLOAT.Enum(), ValuePayload: ValuePayload{Integer: gogoproto.Int64(1)}},
+		{Type: BYTES.Enum(), ValuePayload: ValuePayload{Float: gogoproto.Float64(1)}},
+		{Type: TIMESERIES.Enum(), ValuePayload: ValuePayload{Integer: gogoproto.Int64(1)}},
+	}
+	for i, v := range testCases {
+		if err := v.Verify(k); err == nil {
+			t.Errorf("%d: expected error with mismatched payload: %+v", i, v)
+		}
+	}
+}
+
 func TestValueChecksumEmpty(t *testing.T) {
 	k := []byte("key")
 	v := Value{}
@@ -321,7 +826,7 @@ func TestValueChecksumEmpty(t *testing.T) {
 
 func TestValueChecksumWithBytes(t *testing.T) {
 	k := []byte("key")
-	v := Value{Bytes: []byte("abc")}
+	v := Value{ValuePayload: ValuePayload{Bytes: []byte("abc")}}
 	v.InitChecksum(k)
 	if err := v.Verify(k); err != nil {
 		t.Error(err)
@@ -341,7 +846,7 @@ func TestValueChecksumWithInteger(t *testing.T) {
 	k := []byte("key")
 	testValues := []int64{0, 1, -1, math.MinInt64, math.MaxInt64}
 	for _, i := range testValues {
-		v := Value{Integer: gogoproto.Int64(i)}
+		v := Value{ValuePayload: ValuePayload{Integer: gogoproto.Int64(i)}}
 		v.InitChecksum(k)
 		if err := v.Verify(k); err != nil {
 			t.Error(err)
@@ -409,4 +914,115 @@ func TestNodeList(t *testing.T) {
 				i, n, sn.GetNodes())
 		}
 	}
+	nodes := sn.GetNodes()
+	for i := 1; i < len(nodes); i++ {
+		if nodes[i-1] >= nodes[i] {
+			t.Fatalf("expected sorted nodes; got %v", nodes)
+		}
+	}
+}
+
+func BenchmarkNodeListContains(b *testing.B) {
+	sn := NodeList{}
+	for _, n := range rand.Perm(500) {
+		sn.Add(int32(n * 2))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sn.Contains(int32(i % 1000))
+	}
+}
+
+// TestTransactionSavepoints verifies which sequences are visible to a
+// transaction after rolling back to savepoints.
+func TestTransactionSavepoints(t *testing.T) {
+	txn := &Transaction{Sequence: 5}
+	txn.RollbackToSavepoint(2)
+	txn.Sequence = 7
+	// Rolling back to the latest write is a noop.
+	txn.RollbackToSavepoint(7)
+	if len(txn.IgnoredSequences) != 1 {
+		t.Fatalf("expected a single ignored range; got %+v", txn.IgnoredSequences)
+	}
+	for seq, visible := range []bool{true, true, true, false, false, false, true, true, false} {
+		if v := txn.IsSequenceVisible(int32(seq)); v != visible {
+			t.Errorf("expected sequence %d visible=%t; got %t", seq, visible, v)
+		}
+	}
+
+	// Savepoints are recorded once per sequence.
+	if sp := txn.Savepoint(); sp != 7 || txn.Savepoint() != 7 || len(txn.Savepoints) != 1 {
+		t.Errorf("expected a single savepoint at 7; got %d, %v", sp, txn.Savepoints)
+	}
+	if !txn.HasSavepointIn(7, 8) || txn.HasSavepointIn(5, 7) || txn.HasSavepointIn(8, 9) {
+		t.Errorf("unexpected savepoint ranges for savepoints %v", txn.Savepoints)
+	}
+
+	// Updates take the max sequence and the longer ignored and
+	// savepoint lists.
+	other := &Transaction{ID: []byte("txn"), Sequence: 3}
+	other.Update(txn)
+	if other.Sequence != 7 || len(other.IgnoredSequences) != 1 || len(other.Savepoints) != 1 {
+		t.Errorf("expected sequence, ignored sequences and savepoints to be updated; got %+v", other)
+	}
+}
+
+func TestValueChecksumWithFloat(t *testing.T) {
+	k := []byte("key")
+	testValues := []float64{0, 1, -1.5, math.SmallestNonzeroFloat64, math.MaxFloat64, math.Inf(-1)}
+	for _, f := range testValues {
+		v := Value{ValuePayload: ValuePayload{Float: gogoproto.Float64(f)}}
+		v.InitChecksum(k)
+		if err := v.Verify(k); err != nil {
+			t.Error(err)
+		}
+		// Try a different key; should fail.
+		if err := v.Verify([]byte("key2")); err == nil {
+			t.Error("expected checksum verification failure on different key")
+		}
+		// Mess with value.
+		v.Float = gogoproto.Float64(f + 1)
+		if err := v.Verify(k); err == nil && f+1 != f {
+			t.Error("expected checksum verification failure on different value")
+		}
+	}
+}
+
+// TestValueChecksumVersions verifies that new checksums are CRC-32C
+// and that values carrying the original CRC-32-IEEE checksum still
+// verify.
+func TestValueChecksumVersions(t *testing.T) {
+	k := []byte("key")
+	v := Value{ValuePayload: ValuePayload{Bytes: []byte("abc")}}
+	v.InitChecksum(k)
+	if v.Checksum != nil {
+		t.Errorf("expected no CRC-32-IEEE checksum; got %08x", v.GetChecksum())
+	}
+	if exp := crc32.Checksum([]byte("keyabc"), crc32.MakeTable(crc32.Castagnoli)); v.GetChecksumCRC32C() != exp {
+		t.Errorf("expected CRC-32C checksum %08x; got %08x", exp, v.GetChecksumCRC32C())
+	}
+
+	legacy := Value{ValuePayload: ValuePayload{Bytes: []byte("abc")}, Checksum: gogoproto.Uint32(crc32.ChecksumIEEE([]byte("keyabc")))}
+	if err := legacy.Verify(k); err != nil {
+		t.Errorf("unexpected error verifying legacy checksum: %s", err)
+	}
+	// InitChecksum leaves an existing checksum of either version alone.
+	legacy.InitChecksum(k)
+	if legacy.ChecksumCRC32C != nil {
+		t.Error("expected legacy value not to be rechecksummed")
+	}
+	legacy.Bytes = []byte("abcd")
+	if err := legacy.Verify(k); err == nil {
+		t.Error("expected legacy checksum verification failure on different value")
+	}
+}
+
+func BenchmarkValueChecksum(b *testing.B) {
+	k := []byte("key")
+	v := Value{ValuePayload: ValuePayload{Bytes: bytes.Repeat([]byte("v"), 1024)}}
+	b.SetBytes(int64(len(k) + len(v.Bytes)))
+	for i := 0; i < b.N; i++ {
+		v.ChecksumCRC32C = nil
+		v.InitChecksum(k)
+	}
 }
@@ -147,3 +147,58 @@ func (e *OpRequiresTxnError) Error() string {
 func (e *ConditionFailedError) Error() string {
 	return fmt.Sprintf("unexpected value: %s", e.ActualValue)
 }
+
+// NewRangeTooLargeError initializes a new RangeTooLargeError.
+func NewRangeTooLargeError(raftID, rangeSize, maxBytes int64) *RangeTooLargeError {
+	return &RangeTooLargeError{
+		RaftID:    raftID,
+		RangeSize: rangeSize,
+		MaxBytes:  maxBytes,
+	}
+}
+
+// Error formats error.
+func (e *RangeTooLargeError) Error() string {
+	return fmt.Sprintf("range %d size %d exceeds backpressure limit based on max size %d; waiting for split",
+		e.RaftID, e.RangeSize, e.MaxBytes)
+}
+
+// CanRetry indicates whether or not this RangeTooLargeError can be retried.
+func (e *RangeTooLargeError) CanRetry() bool {
+	return true
+}
+
+// NewRequestTooLargeError initializes a new RequestTooLargeError.
+func NewRequestTooLargeError(kind string, size, maxSize int64) *RequestTooLargeError {
+	return &RequestTooLargeError{
+		Kind:    kind,
+		Size:    size,
+		MaxSize: maxSize,
+	}
+}
+
+// Error formats error.
+func (e *RequestTooLargeError) Error() string {
+	return fmt.Sprintf("%s size %d exceeds maximum %d", e.Kind, e.Size, e.MaxSize)
+}
+
+// NewReplicaCorruptionError initializes a new ReplicaCorruptionError
+// from the error which revealed the corruption.
+func NewReplicaCorruptionError(cause error) *ReplicaCorruptionError {
+	return &ReplicaCorruptionError{ErrorMsg: cause.Error()}
+}
+
+// Error formats error.
+func (e *ReplicaCorruptionError) Error() string {
+	return fmt.Sprintf("replica corruption: %s", e.ErrorMsg)
+}
+
+// NewOnePhaseCommitError initializes a new OnePhaseCommitError.
+func NewOnePhaseCommitError(reason string) *OnePhaseCommitError {
+	return &OnePhaseCommitError{Reason: reason}
+}
+
+// Error formats error.
+func (e *OnePhaseCommitError) Error() string {
+	return fmt.Sprintf("one-phase commit not possible: %s", e.Reason)
+}
@@ -18,6 +18,10 @@
 package proto
 
 import (
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+
 	"github.com/cockroachdb/cockroach/util"
 	gogoproto "github.com/gogo/protobuf/proto"
 )
@@ -44,8 +48,10 @@ const (
 	// key range.
 	InternalResolveIntent = "InternalResolveIntent"
 	// InternalSnapshotCopy scans the key range specified by start key through
-	// end key up to some maximum number of results from the given snapshot_id.
-	// It will create a snapshot if snapshot_id is empty.
+	// end key up to some maximum number of results (and optionally bytes)
+	// from the given snapshot_id. It will create a snapshot if snapshot_id
+	// is empty. Each chunk of results is checksummed, and a resume key is
+	// returned if the scan stopped short of the end key.
 	InternalSnapshotCopy = "InternalSnapshotCopy"
 	// InternalMerge merges a given value into the specified key. Merge is a
 	// high-performance operation provided by underlying data storage for values
@@ -55,6 +61,31 @@ const (
 	// The logic used to merge values of different types is described in more
 	// detail by the "Merge" method of engine.Engine.
 	InternalMerge = "InternalMerge"
+	// InternalConditionalPutInline sets the inline (non-MVCC) value of a
+	// key if its existing inline value matches the expected value.
+	// Inline values carry no version history, so these operations suit
+	// system metadata which is only ever read at its latest value. They
+	// can't be part of a transaction and fail on keys with versioned
+	// values.
+	InternalConditionalPutInline = "InternalConditionalPutInline"
+	// InternalDeleteInline clears the inline (non-MVCC) value of a key,
+	// optionally only if it matches an expected value.
+	InternalDeleteInline = "InternalDeleteInline"
+	// InternalComputeChecksum has every replica of a range compute a
+	// checksum of a snapshot of its data, taken at the same point in
+	// the range's raft log.
+	InternalComputeChecksum = "InternalComputeChecksum"
+	// InternalVerifyChecksum reports one replica's checksum for a
+	// preceding InternalComputeChecksum to every replica of the range,
+	// each of which compares its own checksum with the one reported by
+	// a majority of the replicas.
+	InternalVerifyChecksum = "InternalVerifyChecksum"
+	// InternalRecomputeStats recomputes a range's MVCC stats from its
+	// data and corrects the persisted stats if they have drifted.
+	InternalRecomputeStats = "InternalRecomputeStats"
+	// InternalGC removes MVCC versions which have expired under the
+	// range's zone GC policy and records the range's GC metadata.
+	InternalGC = "InternalGC"
 )
 
 // ToValue generates a Value message which contains an encoded copy of this
@@ -66,15 +97,16 @@ func (ts *InternalTimeSeriesData) ToValue() (*Value, error) {
 		return nil, err
 	}
 	return &Value{
-		Bytes: b,
-		Tag:   gogoproto.String(_CR_TS.String()),
+		ValuePayload: ValuePayload{Bytes: b},
+		Tag:          gogoproto.String(_CR_TS.String()),
+		Type:         TIMESERIES.Enum(),
 	}, nil
 }
 
 // InternalTimeSeriesDataFromValue attempts to extract an InternalTimeSeriesData
 // message from the "bytes" field of the given value.
 func InternalTimeSeriesDataFromValue(value *Value) (*InternalTimeSeriesData, error) {
-	if value.GetTag() != _CR_TS.String() {
+	if value.GetValueType() != TIMESERIES {
 		return nil, util.Errorf("value is not tagged as containing TimeSeriesData: %v", value)
 	}
 	var ts InternalTimeSeriesData
@@ -84,3 +116,144 @@ func InternalTimeSeriesDataFromValue(value *Value) (*InternalTimeSeriesData, err
 	}
 	return &ts, nil
 }
+
+// Downsample returns a copy of this time series data rolled up into
+// coarser samples of the given duration, starting at the given wall
+// time. Samples falling into the same coarser interval are accumulated
+// the same way the RocksDB merge operator accumulates samples with
+// matching offsets: counts and sums are added and explicit maximums and
+// minimums are kept. The new duration must be a multiple of the current
+// one and no sample may begin before the new start time.
+func (ts *InternalTimeSeriesData) Downsample(startNanos, durationNanos int64) (*InternalTimeSeriesData, error) {
+	if ts.SampleDurationNanos <= 0 || durationNanos < ts.SampleDurationNanos ||
+		durationNanos%ts.SampleDurationNanos != 0 {
+		return nil, util.Errorf("cannot downsample samples of duration %d to duration %d",
+			ts.SampleDurationNanos, durationNanos)
+	}
+	result := &InternalTimeSeriesData{
+		StartTimestampNanos: startNanos,
+		SampleDurationNanos: durationNanos,
+	}
+	byOffset := map[int32]*InternalTimeSeriesSample{}
+	for _, sample := range ts.Samples {
+		wallNanos := ts.StartTimestampNanos + int64(sample.Offset)*ts.SampleDurationNanos
+		if wallNanos < startNanos {
+			return nil, util.Errorf("sample at %d precedes downsampled start time %d", wallNanos, startNanos)
+		}
+		offset := int32((wallNanos - startNanos) / durationNanos)
+		dest, ok := byOffset[offset]
+		if !ok {
+			dest = &InternalTimeSeriesSample{Offset: offset}
+			byOffset[offset] = dest
+			result.Samples = append(result.Samples, dest)
+		}
+		dest.accumulate(sample)
+	}
+	sort.Sort(sampleSlice(result.Samples))
+	return result, nil
+}
+
+// accumulate adds the measurements of src to s.
+func (s *InternalTimeSeriesSample) accumulate(src *InternalTimeSeriesSample) {
+	if s.IntCount+src.IntCount > 1 {
+		max, min := src.intMax(), src.intMin()
+		if src.IntCount == 0 {
+			max, min = s.intMax(), s.intMin()
+		} else if s.IntCount > 0 {
+			if m := s.intMax(); m > max {
+				max = m
+			}
+			if m := s.intMin(); m < min {
+				min = m
+			}
+		}
+		s.IntMax, s.IntMin = gogoproto.Int64(max), gogoproto.Int64(min)
+	}
+	if src.IntCount > 0 {
+		s.IntSum = gogoproto.Int64(s.GetIntSum() + src.GetIntSum())
+	}
+	s.IntCount += src.IntCount
+
+	if s.FloatCount+src.FloatCount > 1 {
+		max, min := src.floatMax(), src.floatMin()
+		if src.FloatCount == 0 {
+			max, min = s.floatMax(), s.floatMin()
+		} else if s.FloatCount > 0 {
+			if m := s.floatMax(); m > max {
+				max = m
+			}
+			if m := s.floatMin(); m < min {
+				min = m
+			}
+		}
+		s.FloatMax, s.FloatMin = gogoproto.Float32(max), gogoproto.Float32(min)
+	}
+	if src.FloatCount > 0 {
+		s.FloatSum = gogoproto.Float32(s.GetFloatSum() + src.GetFloatSum())
+	}
+	s.FloatCount += src.FloatCount
+}
+
+// intMax returns the maximum integer measurement, which is the sum if
+// the sample holds a single measurement. The same goes for intMin,
+// floatMax and floatMin.
+func (s *InternalTimeSeriesSample) intMax() int64 {
+	if s.IntMax != nil {
+		return *s.IntMax
+	}
+	return s.GetIntSum()
+}
+
+func (s *InternalTimeSeriesSample) intMin() int64 {
+	if s.IntMin != nil {
+		return *s.IntMin
+	}
+	return s.GetIntSum()
+}
+
+func (s *InternalTimeSeriesSample) floatMax() float32 {
+	if s.FloatMax != nil {
+		return *s.FloatMax
+	}
+	return s.GetFloatSum()
+}
+
+func (s *InternalTimeSeriesSample) floatMin() float32 {
+	if s.FloatMin != nil {
+		return *s.FloatMin
+	}
+	return s.GetFloatSum()
+}
+
+// sampleSlice implements sort.Interface, ordering samples by offset.
+type sampleSlice []*InternalTimeSeriesSample
+
+func (s sampleSlice) Len() int           { return len(s) }
+func (s sampleSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sampleSlice) Less(i, j int) bool { return s[i].Offset < s[j].Offset }
+
+// SnapshotChecksum returns a CRC-32 (Castagnoli) checksum of the
+// supplied rows. Keys and values are length-prefixed so that moving
+// bytes between adjacent keys and values changes the checksum.
+func SnapshotChecksum(rows []RawKeyValue) uint32 {
+	var crc uint32
+	var lenBuf [binary.MaxVarintLen64]byte
+	for _, kv := range rows {
+		for _, b := range [][]byte{kv.Key, kv.Value} {
+			n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+			crc = crc32.Update(crc, CastagnoliTable, lenBuf[:n])
+			crc = crc32.Update(crc, CastagnoliTable, b)
+		}
+	}
+	return crc
+}
+
+// Verify returns an error if the response's rows don't match its
+// checksum, indicating the chunk was corrupted and should be
+// requested again.
+func (r *InternalSnapshotCopyResponse) Verify() error {
+	if crc := SnapshotChecksum(r.Rows); crc != r.Checksum {
+		return util.Errorf("snapshot %s chunk checksum mismatch: expected %08x; got %08x", r.SnapshotID, r.Checksum, crc)
+	}
+	return nil
+}
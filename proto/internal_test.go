@@ -76,9 +76,85 @@ func TestTimeSeriesToValue(t *testing.T) {
 
 	// Make sure ExtractTimeSeries doesn't work on non-TimeSeries values
 	valueNotTs := &Value{
-		Bytes: []byte("testvalue"),
+		ValuePayload: ValuePayload{Bytes: []byte("testvalue")},
 	}
 	if _, err := InternalTimeSeriesDataFromValue(valueNotTs); err == nil {
 		t.Errorf("did not receive expected error when extracting TimeSeries from regular Byte value.")
 	}
 }
+
+// TestTimeSeriesDownsample verifies that 10s samples are rolled up
+// into 30m samples, accumulating counts, sums, maximums and minimums.
+func TestTimeSeriesDownsample(t *testing.T) {
+	const (
+		start   = 1425600000000000000
+		tenSecs = 10 * 1000000000
+		halfHr  = 180 * tenSecs
+	)
+	ts := &InternalTimeSeriesData{
+		StartTimestampNanos: start + tenSecs,
+		SampleDurationNanos: tenSecs,
+		Samples: []*InternalTimeSeriesSample{
+			{Offset: 0, IntCount: 1, IntSum: gogoproto.Int64(5)},
+			{Offset: 1, IntCount: 2, IntSum: gogoproto.Int64(4), IntMax: gogoproto.Int64(7), IntMin: gogoproto.Int64(-3)},
+			{Offset: 2, FloatCount: 1, FloatSum: gogoproto.Float32(1.5)},
+			{Offset: 179, IntCount: 1, IntSum: gogoproto.Int64(3)},
+			{Offset: 3, FloatCount: 1, FloatSum: gogoproto.Float32(0.5)},
+		},
+	}
+	expected := &InternalTimeSeriesData{
+		StartTimestampNanos: start,
+		SampleDurationNanos: halfHr,
+		Samples: []*InternalTimeSeriesSample{
+			{
+				Offset:     0,
+				IntCount:   3,
+				IntSum:     gogoproto.Int64(9),
+				IntMax:     gogoproto.Int64(7),
+				IntMin:     gogoproto.Int64(-3),
+				FloatCount: 2,
+				FloatSum:   gogoproto.Float32(2),
+				FloatMax:   gogoproto.Float32(1.5),
+				FloatMin:   gogoproto.Float32(0.5),
+			},
+			{Offset: 1, IntCount: 1, IntSum: gogoproto.Int64(3)},
+		},
+	}
+	rolledUp, err := ts.Downsample(start, halfHr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gogoproto.Equal(rolledUp, expected) {
+		t.Errorf("expected %v; got %v", expected, rolledUp)
+	}
+
+	// Downsampling to a duration which isn't a multiple of the current
+	// one, or to a start time after the first sample, fails.
+	if _, err := ts.Downsample(start, tenSecs+1); err == nil {
+		t.Error("expected error downsampling to an uneven duration")
+	}
+	if _, err := ts.Downsample(start+2*tenSecs, halfHr); err == nil {
+		t.Error("expected error downsampling to a later start time")
+	}
+}
+
+// TestSnapshotChecksum verifies that the snapshot checksum is
+// sensitive to the boundaries between keys and values.
+func TestSnapshotChecksum(t *testing.T) {
+	rows := []RawKeyValue{{Key: []byte("ab"), Value: []byte("c")}}
+	shifted := []RawKeyValue{{Key: []byte("a"), Value: []byte("bc")}}
+	if SnapshotChecksum(rows) == SnapshotChecksum(shifted) {
+		t.Error("expected checksums to differ when bytes move between key and value")
+	}
+	if SnapshotChecksum(nil) != 0 {
+		t.Errorf("expected zero checksum for no rows; got %08x", SnapshotChecksum(nil))
+	}
+	reply := &InternalSnapshotCopyResponse{Rows: rows, Checksum: SnapshotChecksum(rows)}
+	if err := reply.Verify(); err != nil {
+		t.Error(err)
+	}
+	reply.Rows = shifted
+	if err := reply.Verify(); err == nil {
+		t.Error("expected verification failure")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package proto
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// A KeyFormatter renders the remainder of a key following a registered
+// prefix as a human-readable path suffix, beginning with "/" unless
+// empty.
+type KeyFormatter func(rest Key) string
+
+// A keyFormatterEntry is a registered key prefix with its name and
+// formatter.
+type keyFormatterEntry struct {
+	prefix Key
+	name   string
+	format KeyFormatter
+}
+
+// keyFormatters holds the registered key formatters, sorted by
+// descending prefix length so that the longest matching prefix is
+// found first.
+var keyFormatters struct {
+	sync.RWMutex
+	entries []keyFormatterEntry
+}
+
+// RegisterKeyFormatter registers a human-readable name for keys with
+// the specified prefix (e.g. "/Meta2" for second-level range addressing
+// records). Such keys are formatted by Key.String() as the name
+// followed by the remainder of the key rendered by format, or by
+// QuoteKeyFormatter if format is nil. Registering a prefix again
+// replaces its name and formatter.
+func RegisterKeyFormatter(prefix Key, name string, format KeyFormatter) {
+	if format == nil {
+		format = QuoteKeyFormatter
+	}
+	keyFormatters.Lock()
+	defer keyFormatters.Unlock()
+	entry := keyFormatterEntry{prefix: prefix, name: name, format: format}
+	for i, e := range keyFormatters.entries {
+		if e.prefix.Equal(prefix) {
+			keyFormatters.entries[i] = entry
+			return
+		}
+	}
+	keyFormatters.entries = append(keyFormatters.entries, entry)
+	sort.Sort(byDescendingPrefixLength(keyFormatters.entries))
+}
+
+// byDescendingPrefixLength sorts key formatters by prefix length,
+// longest first.
+type byDescendingPrefixLength []keyFormatterEntry
+
+func (b byDescendingPrefixLength) Len() int      { return len(b) }
+func (b byDescendingPrefixLength) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byDescendingPrefixLength) Less(i, j int) bool {
+	return len(b[i].prefix) > len(b[j].prefix)
+}
+
+// QuoteKeyFormatter formats the remainder of a key as a quoted string.
+func QuoteKeyFormatter(rest Key) string {
+	if len(rest) == 0 {
+		return ""
+	}
+	return "/" + strconv.Quote(rest.format())
+}
+
+// NestedKeyFormatter formats the remainder of a key as a key in its
+// own right, for prefixes whose suffix is another key (e.g. range
+// addressing records and transaction records). The remainder is
+// pretty-printed if it has a registered prefix and quoted otherwise.
+func NestedKeyFormatter(rest Key) string {
+	if s, ok := rest.PrettyPrint(); ok {
+		return s
+	}
+	return QuoteKeyFormatter(rest)
+}
+
+// PrettyPrint returns a human-readable rendering of the key, such as
+// /Meta2/"foo", if the key has a prefix with a registered formatter.
+// Returns false otherwise. A formatter which panics on a malformed key
+// is ignored and the remainder of the key is quoted instead.
+func (k Key) PrettyPrint() (s string, ok bool) {
+	var entry keyFormatterEntry
+	keyFormatters.RLock()
+	for _, e := range keyFormatters.entries {
+		if bytes.HasPrefix(k, e.prefix) {
+			entry, ok = e, true
+			break
+		}
+	}
+	keyFormatters.RUnlock()
+	if !ok {
+		return "", false
+	}
+	rest := k[len(entry.prefix):]
+	defer func() {
+		if r := recover(); r != nil {
+			s = entry.name + QuoteKeyFormatter(rest)
+		}
+	}()
+	return entry.name + entry.format(rest), true
+}
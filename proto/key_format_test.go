@@ -0,0 +1,52 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package proto
+
+import "testing"
+
+// TestRegisterKeyFormatter verifies that keys are formatted using the
+// formatter of their longest registered prefix, that registering a
+// prefix again replaces it and that panicking formatters fall back to
+// quoting.
+func TestRegisterKeyFormatter(t *testing.T) {
+	RegisterKeyFormatter(Key("\xfetest"), "/Test", nil)
+	RegisterKeyFormatter(Key("\xfetest-nested"), "/Nested", NestedKeyFormatter)
+	RegisterKeyFormatter(Key("\xfetest-panic"), "/Panic", func(rest Key) string {
+		panic("malformed key")
+	})
+
+	testCases := []struct {
+		key Key
+		exp string
+	}{
+		{Key("\xfetest"), "/Test"},
+		{Key("\xfetest-a"), `/Test/"-a"`},
+		{Key("\xfetest-nested\xfetest-b"), `/Nested/Test/"-b"`},
+		{Key("\xfetest-nestedc"), `/Nested/"c"`},
+		{Key("\xfetest-panic\x00"), `/Panic/"\x00"`},
+		{Key("\xfetes"), "\xfetes"},
+	}
+	for i, test := range testCases {
+		if s := test.key.String(); s != test.exp {
+			t.Errorf("%d: expected %s; got %s", i, test.exp, s)
+		}
+	}
+
+	RegisterKeyFormatter(Key("\xfetest"), "/Replaced", nil)
+	if s := Key("\xfetest-a").String(); s != `/Replaced/"-a"` {
+		t.Errorf("expected replaced formatter; got %s", s)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package proto
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// keyRedaction is non-zero if keys should be redacted when formatted
+// via Key.String(). Accessed atomically.
+var keyRedaction int32
+
+// structuralPrefixes holds the registered key prefixes which are
+// preserved when keys are redacted, sorted by descending length so
+// that the longest matching prefix is found first.
+var structuralPrefixes struct {
+	sync.RWMutex
+	prefixes []Key
+}
+
+// SetKeyRedaction enables or disables redaction of keys formatted via
+// Key.String(), which is used for keys appearing in errors, logs and
+// status pages. This is intended to be controlled by a server setting
+// so that logs may be shared without leaking user data.
+func SetKeyRedaction(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&keyRedaction, v)
+}
+
+// KeyRedactionEnabled returns whether keys are redacted when formatted.
+func KeyRedactionEnabled() bool {
+	return atomic.LoadInt32(&keyRedaction) != 0
+}
+
+// RegisterKeyPrefix registers a structural key prefix (e.g. the prefix
+// for range descriptors or zone configs) which is preserved verbatim
+// when a key is redacted. Registering the same prefix twice is a noop.
+func RegisterKeyPrefix(prefix Key) {
+	structuralPrefixes.Lock()
+	defer structuralPrefixes.Unlock()
+	for _, p := range structuralPrefixes.prefixes {
+		if p.Equal(prefix) {
+			return
+		}
+	}
+	structuralPrefixes.prefixes = append(structuralPrefixes.prefixes, prefix)
+	sort.Sort(byDescendingLength(structuralPrefixes.prefixes))
+}
+
+// byDescendingLength sorts keys by length, longest first.
+type byDescendingLength []Key
+
+func (b byDescendingLength) Len() int           { return len(b) }
+func (b byDescendingLength) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDescendingLength) Less(i, j int) bool { return len(b[i]) > len(b[j]) }
+
+// Redact returns a redacted representation of the key. The longest
+// registered structural prefix of the key is preserved; the remainder,
+// which may contain user data, is replaced by its length and a
+// checksum. Equal keys redact identically, so redacted keys may still
+// be correlated across log lines. KeyMin and KeyMax are not redacted.
+func (k Key) Redact() string {
+	if len(k) == 0 || k.Equal(KeyMax) {
+		return k.format()
+	}
+	var prefix Key
+	structuralPrefixes.RLock()
+	for _, p := range structuralPrefixes.prefixes {
+		if bytes.HasPrefix(k, p) {
+			prefix = p
+			break
+		}
+	}
+	structuralPrefixes.RUnlock()
+
+	rest := k[len(prefix):]
+	if len(rest) == 0 {
+		return string(prefix)
+	}
+	return fmt.Sprintf("%s<redacted:%d:%08x>", string(prefix), len(rest), crc32.ChecksumIEEE(rest))
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package proto
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestKeyRedact verifies that redacted keys preserve registered
+// structural prefixes and hide the remainder.
+func TestKeyRedact(t *testing.T) {
+	RegisterKeyPrefix(Key("\x00sys"))
+	RegisterKeyPrefix(Key("\x00sys-long"))
+	RegisterKeyPrefix(Key("\x00sys")) // duplicate registration is a noop
+
+	testCases := []struct {
+		key        Key
+		expPrefix  string
+		expSuffix  bool
+		expNoMatch string
+	}{
+		{KeyMin, "", false, ""},
+		{KeyMax, "\xff...", false, ""},
+		{Key("\x00sys"), "\x00sys", false, ""},
+		{Key("\x00sys-user"), "\x00sys", true, "user"},
+		{Key("\x00sys-longuser"), "\x00sys-long", true, "user"},
+		{Key("secret"), "", true, "secret"},
+	}
+	for i, test := range testCases {
+		r := test.key.Redact()
+		if !strings.HasPrefix(r, test.expPrefix) {
+			t.Errorf("%d: expected redacted key %q to have prefix %q", i, r, test.expPrefix)
+		}
+		if hasSuffix := strings.Contains(r, "<redacted:"); hasSuffix != test.expSuffix {
+			t.Errorf("%d: expected redaction marker %t in %q", i, test.expSuffix, r)
+		}
+		if test.expNoMatch != "" && strings.Contains(r, test.expNoMatch) {
+			t.Errorf("%d: expected %q to be redacted from %q", i, test.expNoMatch, r)
+		}
+	}
+
+	// Equal keys redact identically; different keys differ.
+	if Key("a").Redact() != Key("a").Redact() || Key("a").Redact() == Key("b").Redact() {
+		t.Error("expected redaction to be deterministic and distinguish keys")
+	}
+}
+
+// TestKeyStringRedaction verifies that Key.String() redacts keys only
+// when key redaction is enabled.
+func TestKeyStringRedaction(t *testing.T) {
+	defer SetKeyRedaction(false)
+	key := Key("secret")
+	if s := fmt.Sprintf("%s", key); s != "secret" {
+		t.Errorf("expected unredacted key; got %q", s)
+	}
+	SetKeyRedaction(true)
+	if s := fmt.Sprintf("%q", key); strings.Contains(s, "secret") {
+		t.Errorf("expected redacted key; got %s", s)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package proto
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// NewTraceSpan returns a span for the named stage of a request's
+// execution, starting now.
+func NewTraceSpan(name string) TraceSpan {
+	return TraceSpan{Name: name, Start: time.Now().UnixNano()}
+}
+
+// Finish sets the duration of the span and records err, if not nil,
+// as the error with which the stage ended.
+func (s *TraceSpan) Finish(err error) {
+	s.Duration = time.Now().UnixNano() - s.Start
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// FormatTrace pretty-prints the spans of a trace, one per line, with
+// each span's start offset relative to the earliest span.
+func FormatTrace(spans []TraceSpan) string {
+	if len(spans) == 0 {
+		return "no trace spans recorded\n"
+	}
+	start := spans[0].Start
+	for _, s := range spans[1:] {
+		if s.Start < start {
+			start = s.Start
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%12s %12s  %s\n", "offset", "duration", "stage")
+	for _, s := range spans {
+		fmt.Fprintf(&buf, "%12s %12s  %s", time.Duration(s.Start-start), time.Duration(s.Duration), s.Name)
+		if s.RaftID != 0 {
+			fmt.Fprintf(&buf, " raft=%d", s.RaftID)
+		}
+		if s.StoreID != 0 {
+			fmt.Fprintf(&buf, " store=%d", s.StoreID)
+		}
+		if s.Attempt > 1 {
+			fmt.Fprintf(&buf, " attempt=%d", s.Attempt)
+		}
+		if s.Error != "" {
+			fmt.Fprintf(&buf, " error=%q", s.Error)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,53 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package proto
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestTraceSpanFinish verifies the duration and error of a finished span.
+func TestTraceSpanFinish(t *testing.T) {
+	s := NewTraceSpan("stage")
+	s.Finish(nil)
+	if s.Duration < 0 || s.Error != "" {
+		t.Errorf("unexpected span: %+v", s)
+	}
+	s = NewTraceSpan("stage")
+	s.Finish(errors.New("boom"))
+	if s.Error != "boom" {
+		t.Errorf("expected error to be recorded; got %+v", s)
+	}
+}
+
+// TestFormatTrace verifies that spans are printed in order with
+// offsets relative to the earliest span.
+func TestFormatTrace(t *testing.T) {
+	spans := []TraceSpan{
+		{Name: "command queue", Start: 2000, Duration: 500, RaftID: 1, StoreID: 2},
+		{Name: "rpc Get", Start: 1000, Duration: 3000000, RaftID: 1, Attempt: 2, Error: "not leader"},
+	}
+	expected := "      offset     duration  stage\n" +
+		"         1µs        500ns  command queue raft=1 store=2\n" +
+		"          0s          3ms  rpc Get raft=1 attempt=2 error=\"not leader\"\n"
+	if s := FormatTrace(spans); s != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+	if s := FormatTrace(nil); s != "no trace spans recorded\n" {
+		t.Errorf("unexpected empty trace output: %q", s)
+	}
+}
@@ -21,14 +21,18 @@
 #include <google/protobuf/repeated_field.h>
 #include "rocksdb/cache.h"
 #include "rocksdb/compaction_filter.h"
+#include "rocksdb/comparator.h"
 #include "rocksdb/db.h"
 #include "rocksdb/env.h"
+#include "rocksdb/filter_policy.h"
 #include "rocksdb/merge_operator.h"
 #include "rocksdb/options.h"
+#include "rocksdb/table.h"
 #include "api.pb.h"
 #include "data.pb.h"
 #include "internal.pb.h"
 #include "db.h"
+#include "encoding.h"
 
 extern "C" {
 
@@ -122,10 +126,42 @@ const proto::ResponseHeader* GetResponseHeader(const proto::ReadWriteCmdResponse
     return &rwResp.internal_resolve_intent().header();
   } else if (rwResp.has_internal_merge()) {
     return &rwResp.internal_merge().header();
+  } else if (rwResp.has_batch()) {
+    return &rwResp.batch().header();
+  } else if (rwResp.has_internal_compute_checksum()) {
+    return &rwResp.internal_compute_checksum().header();
+  } else if (rwResp.has_internal_verify_checksum()) {
+    return &rwResp.internal_verify_checksum().header();
+  } else if (rwResp.has_internal_conditional_put_inline()) {
+    return &rwResp.internal_conditional_put_inline().header();
+  } else if (rwResp.has_internal_delete_inline()) {
+    return &rwResp.internal_delete_inline().header();
+  } else if (rwResp.has_internal_recompute_stats()) {
+    return &rwResp.internal_recompute_stats().header();
+  } else if (rwResp.has_internal_gc()) {
+    return &rwResp.internal_gc().header();
+  } else if (rwResp.has_compare_and_swap()) {
+    return &rwResp.compare_and_swap().header();
   }
   return NULL;
 }
 
+// UpgradeValue moves the payload of a Value encoded before ValuePayload
+// existed from its legacy fields into the payload. See Value.Upgrade
+// in proto/data.go.
+void UpgradeValue(proto::Value* val) {
+  if (val->has_legacy_bytes()) {
+    val->mutable_payload()->set_bytes(val->legacy_bytes());
+  } else if (val->has_legacy_integer()) {
+    val->mutable_payload()->set_integer(val->legacy_integer());
+  } else if (val->has_legacy_float()) {
+    val->mutable_payload()->set_float_(val->legacy_float());
+  }
+  val->clear_legacy_bytes();
+  val->clear_legacy_integer();
+  val->clear_legacy_float();
+}
+
 // DBCompactionFilter implements our garbage collection policy for
 // key/value pairs which can be considered in isolation. This
 // includes:
@@ -172,11 +208,12 @@ class DBCompactionFilter : public rocksdb::CompactionFilter {
       // *error_msg = (char*)"not an inlined mvcc value";
       return false;
     }
+    UpgradeValue(meta.mutable_value());
     // Response cache rows are GC'd if their timestamp is older than the
     // response cache GC timeout.
     if (key.starts_with(rcache_prefix_)) {
       proto::ReadWriteCmdResponse rwResp;
-      if (!rwResp.ParseFromArray(meta.value().bytes().data(), meta.value().bytes().size())) {
+      if (!rwResp.ParseFromArray(meta.value().payload().bytes().data(), meta.value().payload().bytes().size())) {
         // *error_msg = (char*)"failed to parse response cache entry";
         return false;
       }
@@ -194,7 +231,7 @@ class DBCompactionFilter : public rocksdb::CompactionFilter {
       // system-wide minimum write intent is periodically computed via
       // map-reduce over all ranges and gossipped.
       proto::Transaction txn;
-      if (!txn.ParseFromArray(meta.value().bytes().data(), meta.value().bytes().size())) {
+      if (!txn.ParseFromArray(meta.value().payload().bytes().data(), meta.value().payload().bytes().size())) {
         // *error_msg = (char*)"failed to parse transaction entry";
         return false;
       }
@@ -293,7 +330,9 @@ long GetIntMin(const proto::InternalTimeSeriesSample *sample) {
 float GetFloatMax(const proto::InternalTimeSeriesSample *sample) {
     if (sample->has_float_max()) return sample->float_max();
     if (sample->has_float_sum()) return sample->float_sum();
-    return std::numeric_limits<float>::min();
+    // Note that numeric_limits<float>::min() is the smallest positive
+    // value, not the most negative one.
+    return -std::numeric_limits<float>::max();
 }
 
 float GetFloatMin(const proto::InternalTimeSeriesSample *sample) {
@@ -340,12 +379,12 @@ bool MergeTimeSeriesValues(proto::Value *left, const proto::Value &right,
     // Attempt to parse TimeSeriesData from both Values.
     proto::InternalTimeSeriesData left_ts;
     proto::InternalTimeSeriesData right_ts;
-    if (!left_ts.ParseFromString(left->bytes())) {
+    if (!left_ts.ParseFromString(left->payload().bytes())) {
         rocksdb::Warn(logger,
                 "left InternalTimeSeriesData could not be parsed from bytes.");
         return false;
     }
-    if (!right_ts.ParseFromString(right.bytes())) {
+    if (!right_ts.ParseFromString(right.payload().bytes())) {
         rocksdb::Warn(logger,
                 "right InternalTimeSeriesData could not be parsed from bytes.");
         return false;
@@ -370,7 +409,7 @@ bool MergeTimeSeriesValues(proto::Value *left, const proto::Value &right,
     // full merge.
     if (!full_merge) {
         left_ts.MergeFrom(right_ts);
-        left_ts.SerializeToString(left->mutable_bytes());
+        left_ts.SerializeToString(left->mutable_payload()->mutable_bytes());
         return true;
     }
     
@@ -422,14 +461,16 @@ bool MergeTimeSeriesValues(proto::Value *left, const proto::Value &right,
     }
 
     // Serialize the new TimeSeriesData into the left value's byte field.
-    new_ts.SerializeToString(left->mutable_bytes());
+    new_ts.SerializeToString(left->mutable_payload()->mutable_bytes());
     return true;
 }
 
 bool MergeValues(proto::Value *left, const proto::Value &right, 
         bool full_merge, rocksdb::Logger* logger) {
-    if (left->has_bytes()) {
-        if (!right.has_bytes()) {
+    proto::ValuePayload* lp = left->mutable_payload();
+    const proto::ValuePayload& rp = right.payload();
+    if (lp->has_bytes()) {
+        if (!rp.has_bytes()) {
             rocksdb::Warn(logger,
                     "inconsistent value types for merge (left = bytes, right = ?)");
             return false;
@@ -448,20 +489,28 @@ bool MergeValues(proto::Value *left, const proto::Value &right,
                     "inconsistent value types for merge (left = bytes, right = TimeSeriesData");
             return false;
         } else {
-            *left->mutable_bytes() += right.bytes();
+            *lp->mutable_bytes() += rp.bytes();
         }
         return true;
-    } else if (left->has_integer()) {
-        if (!right.has_integer()) {
+    } else if (lp->has_integer()) {
+        if (!rp.has_integer()) {
             rocksdb::Warn(logger,
                     "inconsistent value types for merge (left = integer, right = ?)");
             return false;
         }
-        if (WillOverflow(left->integer(), right.integer())) {
+        if (WillOverflow(lp->integer(), rp.integer())) {
             rocksdb::Warn(logger, "merge would result in integer overflow.");
             return false;
         }
-        left->set_integer(left->integer() + right.integer());
+        lp->set_integer(lp->integer() + rp.integer());
+        return true;
+    } else if (lp->has_float_()) {
+        if (!rp.has_float_()) {
+            rocksdb::Warn(logger,
+                    "inconsistent value types for merge (left = float, right = ?)");
+            return false;
+        }
+        lp->set_float_(lp->float_() + rp.float_());
         return true;
     } else {
         *left = right;
@@ -476,6 +525,7 @@ DBStatus MergeResult(proto::MVCCMetadata* meta, DBString* result) {
   // implementation and need to verify the checksumming is identical
   // to what is being done in Go. Zlib's crc32 should be sufficient.
   meta->mutable_value()->clear_checksum();
+  meta->mutable_value()->clear_checksum_crc32c();
   result->len = meta->ByteSize();
   result->data = static_cast<char*>(malloc(result->len));
   if (!meta->SerializeToArray(result->data, result->len)) {
@@ -564,11 +614,38 @@ class DBMergeOperator : public rocksdb::MergeOperator {
       rocksdb::Warn(logger, "corrupted operand value");
       return false;
     }
+    UpgradeValue(meta->mutable_value());
+    UpgradeValue(operand_meta.mutable_value());
     return MergeValues(meta->mutable_value(), operand_meta.value(), 
             full_merge, logger);
   }
 };
 
+class DBComparator : public rocksdb::Comparator {
+ public:
+  virtual int Compare(const rocksdb::Slice& a, const rocksdb::Slice& b) const {
+    return CompareKeys(a, b);
+  }
+
+  // The ordering coincides with bytewise ordering for all keys
+  // written to date, so keep the name of RocksDB's default comparator:
+  // RocksDB refuses to open a database whose recorded comparator name
+  // differs, and existing stores must remain readable.
+  virtual const char* Name() const {
+    return "leveldb.BytewiseComparator";
+  }
+
+  // The following are noops, which is always correct, though it
+  // forgoes shortening of index block keys.
+  virtual void FindShortestSeparator(
+      std::string* start, const rocksdb::Slice& limit) const {
+  }
+  virtual void FindShortSuccessor(std::string* key) const {
+  }
+};
+
+DBComparator kComparator;
+
 class DBLogger : public rocksdb::Logger {
  public:
   DBLogger(DBLoggerFunc f)
@@ -589,9 +666,23 @@ class DBLogger : public rocksdb::Logger {
 }  // namespace
 
 DBStatus DBOpen(DBEngine **db, DBSlice dir, DBOptions db_opts) {
+  rocksdb::BlockBasedTableOptions table_options;
+  table_options.block_cache = rocksdb::NewLRUCache(db_opts.cache_size);
+  if (db_opts.bloom_bits > 0) {
+    table_options.filter_policy.reset(
+        rocksdb::NewBloomFilterPolicy(db_opts.bloom_bits));
+  }
+
   rocksdb::Options options;
-  options.block_cache = rocksdb::NewLRUCache(db_opts.cache_size);
+  options.table_factory.reset(
+      rocksdb::NewBlockBasedTableFactory(table_options));
   options.allow_os_buffer = db_opts.allow_os_buffer;
+  options.compression =
+      static_cast<rocksdb::CompressionType>(db_opts.compression);
+  if (db_opts.max_open_files != 0) {
+    options.max_open_files = db_opts.max_open_files;
+  }
+  options.comparator = &kComparator;
   options.compaction_filter_factory.reset(new DBCompactionFilterFactory(
       ToString(db_opts.txn_prefix),
       ToString(db_opts.rcache_prefix)));
@@ -654,6 +745,27 @@ uint64_t DBApproximateSize(DBEngine* db, DBSlice start, DBSlice end) {
   return result;
 }
 
+DBStatus DBEstimateNumKeys(DBEngine* db, uint64_t* count) {
+  if (!db->rep->GetIntProperty("rocksdb.estimate-num-keys", count)) {
+    return ToDBString("unable to read rocksdb.estimate-num-keys");
+  }
+  return kSuccess;
+}
+
+DBStatus DBGetCompactionStats(DBEngine* db, DBCompactionStats* stats) {
+  uint64_t l0_files;
+  if (!db->rep->GetIntProperty("rocksdb.num-files-at-level0", &l0_files)) {
+    return ToDBString("unable to read rocksdb.num-files-at-level0");
+  }
+  uint64_t pending;
+  if (!db->rep->GetIntProperty("rocksdb.compaction-pending", &pending)) {
+    return ToDBString("unable to read rocksdb.compaction-pending");
+  }
+  stats->l0_file_count = l0_files;
+  stats->pending_compaction = pending != 0;
+  return kSuccess;
+}
+
 DBStatus DBPut(DBEngine* db, DBSlice key, DBSlice value) {
   rocksdb::WriteOptions options;
   return ToDBStatus(db->rep->Put(options, ToSlice(key), ToSlice(value)));
@@ -734,6 +846,10 @@ void DBIterNext(DBIterator* iter) {
   iter->rep->Next();
 }
 
+void DBIterPrev(DBIterator* iter) {
+  iter->rep->Prev();
+}
+
 DBSlice DBIterKey(DBIterator* iter) {
   return ToDBSlice(iter->rep->key());
 }
@@ -779,8 +895,33 @@ DBStatus DBMergeOne(DBSlice existing, DBSlice update, DBString* new_value) {
     return ToDBString("corrupted update value");
   }
 
+  UpgradeValue(meta.mutable_value());
+  UpgradeValue(update_meta.mutable_value());
   if (!MergeValues(meta.mutable_value(), update_meta.value(), true, NULL)) {
     return ToDBString("incompatible merge values");
   }
   return MergeResult(&meta, new_value);
 }
+
+int DBCompareKeys(DBSlice a, DBSlice b) {
+  return CompareKeys(ToSlice(a), ToSlice(b));
+}
+
+DBString DBEncodeVersionKey(DBSlice key, int64_t wall_time, int32_t logical) {
+  std::string encoded;
+  EncodeVersionKey(ToSlice(key), wall_time, logical, &encoded);
+  return ToDBString(encoded);
+}
+
+DBStatus DBDecodeKey(DBSlice encoded, DBString* key,
+                     int64_t* wall_time, int32_t* logical) {
+  key->data = NULL;
+  key->len = 0;
+  std::string k;
+  bool is_version;
+  if (!DecodeKey(ToSlice(encoded), &k, wall_time, logical, &is_version)) {
+    return ToDBString("malformed MVCC key");
+  }
+  *key = ToDBString(k);
+  return kSuccess;
+}
@@ -53,6 +53,13 @@ typedef void (*DBLoggerFunc)(void* state, const char* str, int len);
 typedef struct {
   int64_t cache_size;
   int allow_os_buffer;
+  // Bits per key of the bloom filter; no filter is used if <= 0.
+  int bloom_bits;
+  // A rocksdb::CompressionType value.
+  int compression;
+  // The maximum number of open files; the RocksDB default is used
+  // if 0.
+  int max_open_files;
   // The key prefix for transaction keys.
   DBSlice txn_prefix;
   // The key prefix for response cache keys.
@@ -61,6 +68,13 @@ typedef struct {
   DBLoggerFunc logger;
 } DBOptions;
 
+// DBCompactionStats contains compaction details used to throttle
+// writes before RocksDB stalls them.
+typedef struct {
+  int64_t l0_file_count;
+  int pending_compaction;
+} DBCompactionStats;
+
 // Opens the database located in "dir", creating it if it doesn't
 // exist.
 DBStatus DBOpen(DBEngine **db, DBSlice dir, DBOptions options);
@@ -90,6 +104,13 @@ DBStatus DBCompactRange(DBEngine* db, DBSlice* start, DBSlice* end);
 // range [start,end].
 uint64_t DBApproximateSize(DBEngine* db, DBSlice start, DBSlice end);
 
+// Retrieves RocksDB's estimate of the number of keys in the database.
+DBStatus DBEstimateNumKeys(DBEngine* db, uint64_t* count);
+
+// Retrieves the number of files at level 0 and whether a compaction
+// is pending.
+DBStatus DBGetCompactionStats(DBEngine* db, DBCompactionStats* stats);
+
 // Sets the database entry for "key" to "value".
 DBStatus DBPut(DBEngine* db, DBSlice key, DBSlice value);
 
@@ -143,6 +164,11 @@ int  DBIterValid(DBIterator* iter);
 // last key.
 void DBIterNext(DBIterator* iter);
 
+// Moves the iterator back to the previous key. After this call,
+// DBIterValid() returns 1 iff the iterator was not positioned at the
+// first key.
+void DBIterPrev(DBIterator* iter);
+
 // Returns the key at the current iterator position. Note that a slice
 // is returned and the memory does not have to be freed.
 DBSlice DBIterKey(DBIterator* iter);
@@ -175,6 +201,23 @@ void DBBatchDelete(DBBatch* batch, DBSlice key);
 // Go code.
 DBStatus DBMergeOne(DBSlice existing, DBSlice update, DBString* new_value);
 
+// Compares two keys using the comparator installed on the database,
+// returning a negative, zero or positive value if a is less than,
+// equal to or greater than b. This method is provided for invocation
+// from Go code.
+int DBCompareKeys(DBSlice a, DBSlice b);
+
+// Returns the MVCC version key for "key" at the specified
+// timestamp. This method is provided for invocation from Go code.
+DBString DBEncodeVersionKey(DBSlice key, int64_t wall_time, int32_t logical);
+
+// Decodes an encoded MVCC key into the user key and timestamp, which
+// is zero for MVCC metadata keys. Returns an error if "encoded" is not
+// a well-formed MVCC key. This method is provided for invocation from
+// Go code.
+DBStatus DBDecodeKey(DBSlice encoded, DBString* key,
+                     int64_t* wall_time, int32_t* logical);
+
 #ifdef __cplusplus
 }  // extern "C"
 #endif
@@ -0,0 +1,187 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+#include <string.h>
+#include "encoding.h"
+
+namespace {
+
+// The leading and trailing bytes of a key encoded via
+// encoding.EncodeBinary. Bytes in between always have the high bit
+// set, so the terminator unambiguously ends the user key.
+const char kBinaryEncodingMarker = 0x25;
+const char kBinaryEncodingTerminator = 0x00;
+
+// Appends the binary encoding of "s" to "buf". The bits of "s" are
+// packed 7 to a byte, most significant first, with the high bit of
+// each byte set; any bits left over in the final byte are zero.
+void EncodeBinary(const rocksdb::Slice& s, std::string* buf) {
+  buf->push_back(kBinaryEncodingMarker);
+  uint32_t acc = 0;
+  int bits = 0;
+  for (size_t i = 0; i < s.size(); i++) {
+    acc = (acc << 8) | static_cast<unsigned char>(s[i]);
+    bits += 8;
+    while (bits >= 7) {
+      bits -= 7;
+      buf->push_back(static_cast<char>(0x80 | ((acc >> bits) & 0x7f)));
+    }
+    acc &= (1 << bits) - 1;
+  }
+  if (bits > 0) {
+    buf->push_back(static_cast<char>(0x80 | ((acc << (7 - bits)) & 0x7f)));
+  }
+  buf->push_back(kBinaryEncodingTerminator);
+}
+
+// Decodes a binary encoded value from the front of "buf" into "s",
+// advancing "buf" past the terminator. Returns false if "buf" does
+// not begin with a well-formed binary encoded value.
+bool DecodeBinary(rocksdb::Slice* buf, std::string* s) {
+  if (buf->empty() || (*buf)[0] != kBinaryEncodingMarker) {
+    return false;
+  }
+  uint32_t acc = 0;
+  int bits = 0;
+  for (size_t i = 1; i < buf->size(); i++) {
+    const unsigned char c = (*buf)[i];
+    if (c == kBinaryEncodingTerminator) {
+      // At most 6 bits of zero padding may remain.
+      if (bits >= 7 || acc != 0) {
+        return false;
+      }
+      buf->remove_prefix(i + 1);
+      return true;
+    }
+    if ((c & 0x80) == 0) {
+      return false;
+    }
+    acc = (acc << 7) | (c & 0x7f);
+    bits += 7;
+    if (bits >= 8) {
+      bits -= 8;
+      s->push_back(static_cast<char>(acc >> bits));
+      acc &= (1 << bits) - 1;
+    }
+  }
+  return false;
+}
+
+// Appends the big-endian encoding of the low "size" bytes of "v" to
+// "buf".
+void EncodeUint(uint64_t v, int size, std::string* buf) {
+  for (int i = size - 1; i >= 0; i--) {
+    buf->push_back(static_cast<char>((v >> (8 * i)) & 0xff));
+  }
+}
+
+// Decodes a big-endian unsigned integer of the specified size from
+// the front of the slice, which is then advanced.
+uint64_t DecodeUint(rocksdb::Slice* s, int size) {
+  uint64_t v = 0;
+  for (int i = 0; i < size; i++) {
+    v = (v << 8) | static_cast<unsigned char>((*s)[i]);
+  }
+  s->remove_prefix(size);
+  return v;
+}
+
+}  // namespace
+
+void EncodeKey(const rocksdb::Slice& key, std::string* encoded) {
+  EncodeBinary(key, encoded);
+}
+
+void EncodeVersionKey(const rocksdb::Slice& key, int64_t wall_time,
+                      int32_t logical, std::string* encoded) {
+  EncodeBinary(key, encoded);
+  // Timestamps are encoded in decreasing order via the bitwise
+  // complement so that more recent versions sort first.
+  EncodeUint(~static_cast<uint64_t>(wall_time), 8, encoded);
+  EncodeUint(~static_cast<uint32_t>(logical), 4, encoded);
+}
+
+bool DecodeKey(const rocksdb::Slice& encoded, std::string* key,
+               int64_t* wall_time, int32_t* logical, bool* is_version) {
+  rocksdb::Slice buf(encoded);
+  key->clear();
+  *wall_time = 0;
+  *logical = 0;
+  *is_version = false;
+  if (!DecodeBinary(&buf, key)) {
+    return false;
+  }
+  if (buf.empty()) {
+    return true;
+  }
+  if (buf.size() != kMVCCVersionTimestampSize) {
+    return false;
+  }
+  *wall_time = static_cast<int64_t>(~DecodeUint(&buf, 8));
+  *logical = static_cast<int32_t>(~static_cast<uint32_t>(DecodeUint(&buf, 4)));
+  *is_version = true;
+  return true;
+}
+
+bool SplitKey(const rocksdb::Slice& encoded, rocksdb::Slice* prefix,
+              rocksdb::Slice* timestamp) {
+  if (encoded.empty() || encoded[0] != kBinaryEncodingMarker) {
+    return false;
+  }
+  const char* term = static_cast<const char*>(
+      memchr(encoded.data(), kBinaryEncodingTerminator, encoded.size()));
+  if (term == NULL) {
+    return false;
+  }
+  const size_t prefix_len = term - encoded.data() + 1;
+  const size_t ts_len = encoded.size() - prefix_len;
+  if (ts_len != 0 && ts_len != kMVCCVersionTimestampSize) {
+    return false;
+  }
+  *prefix = rocksdb::Slice(encoded.data(), prefix_len);
+  *timestamp = rocksdb::Slice(encoded.data() + prefix_len, ts_len);
+  return true;
+}
+
+int CompareKeys(const rocksdb::Slice& a, const rocksdb::Slice& b) {
+  rocksdb::Slice a_prefix, a_ts, b_prefix, b_ts;
+  if (!SplitKey(a, &a_prefix, &a_ts) || !SplitKey(b, &b_prefix, &b_ts)) {
+    const int c = a.compare(b);
+    return c < 0 ? -1 : (c > 0 ? 1 : 0);
+  }
+  const int c = a_prefix.compare(b_prefix);
+  if (c != 0) {
+    return c < 0 ? -1 : 1;
+  }
+  if (a_ts.empty() || b_ts.empty()) {
+    if (a_ts.size() == b_ts.size()) {
+      return 0;
+    }
+    return a_ts.empty() ? -1 : 1;
+  }
+  // Timestamps are encoded in decreasing order (bitwise complement),
+  // so larger decoded values correspond to older timestamps.
+  const uint64_t a_wall = DecodeUint(&a_ts, 8);
+  const uint64_t b_wall = DecodeUint(&b_ts, 8);
+  if (a_wall != b_wall) {
+    return a_wall < b_wall ? -1 : 1;
+  }
+  const uint64_t a_logical = DecodeUint(&a_ts, 4);
+  const uint64_t b_logical = DecodeUint(&b_ts, 4);
+  if (a_logical != b_logical) {
+    return a_logical < b_logical ? -1 : 1;
+  }
+  return 0;
+}
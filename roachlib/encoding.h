@@ -0,0 +1,66 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+#ifndef ROACHLIB_ENCODING_H
+#define ROACHLIB_ENCODING_H
+
+#include <stdint.h>
+#include <string>
+#include "rocksdb/slice.h"
+
+// The MVCC key encoding, mirroring storage/engine/mvcc_key.go. An
+// MVCC metadata key is the binary encoding of the user key (see
+// encoding.EncodeBinary); an MVCC version key additionally has a
+// decreasing, big-endian encoding of the timestamp appended (8 bytes
+// for the wall time, followed by 4 bytes for the logical time). Any
+// change here must be reflected in the Go implementation; the two are
+// verified against each other in storage/engine/mvcc_key_test.go.
+
+// The size of the timestamp suffix of an MVCC version key.
+const int kMVCCVersionTimestampSize = 12;
+
+// Appends the MVCC metadata key for "key" to "encoded".
+void EncodeKey(const rocksdb::Slice& key, std::string* encoded);
+
+// Appends the MVCC version key for "key" at the specified timestamp
+// to "encoded".
+void EncodeVersionKey(const rocksdb::Slice& key, int64_t wall_time,
+                      int32_t logical, std::string* encoded);
+
+// Decodes an encoded MVCC key into the user key and, for version
+// keys, the timestamp. "is_version" is set to false for metadata keys,
+// in which case the timestamp is zero. Returns false if "encoded" is
+// not a well-formed MVCC key.
+bool DecodeKey(const rocksdb::Slice& encoded, std::string* key,
+               int64_t* wall_time, int32_t* logical, bool* is_version);
+
+// Splits an encoded MVCC key into the encoded user key (including its
+// terminator) and the encoded timestamp suffix, which is empty for
+// MVCC metadata and raw values. Returns false if the key is not a
+// well-formed MVCC key.
+bool SplitKey(const rocksdb::Slice& encoded, rocksdb::Slice* prefix,
+              rocksdb::Slice* timestamp);
+
+// Orders keys by user key ascending; for the same user key, the
+// metadata key sorts first, followed by versions in order of
+// descending timestamp. Keys which are not well-formed MVCC keys are
+// compared bytewise. Returns -1, 0 or +1.
+int CompareKeys(const rocksdb::Slice& a, const rocksdb::Slice& b);
+
+#endif // ROACHLIB_ENCODING_H
+
+// local variables:
+// mode: c++
+// end:
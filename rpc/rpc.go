@@ -17,3 +17,11 @@ func NewContext(clock *hlc.Clock, config *TLSConfig) *Context {
 		RemoteClocks: newRemoteClockMonitor(clock),
 	}
 }
+
+// LocalClock returns the local hybrid logical clock. Subsystems
+// which are handed the rpc Context should use this clock for
+// time-dependent decisions so that tests can supply a manual or
+// skewed clock.
+func (c *Context) LocalClock() *hlc.Clock {
+	return c.localClock
+}
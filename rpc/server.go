@@ -41,6 +41,8 @@ type Server struct {
 
 	mu             sync.RWMutex          // Mutex protects the fields below
 	addr           net.Addr              // Server address; may change if picking unused port
+	advertiseHost  string                // If set, advertised in place of addr's host
+	advertisePort  string                // If set, advertised in place of addr's port
 	closed         bool                  // Set upon invocation of Close()
 	closeCallbacks []func(conn net.Conn) // Slice of callbacks to invoke on conn close
 }
@@ -156,6 +158,46 @@ func (s *Server) Addr() net.Addr {
 	return s.addr
 }
 
+// SetAdvertise sets the host and/or port advertised to other nodes in
+// place of those the server is bound to. This allows nodes to run
+// behind NAT or port mapping, where the bound address isn't reachable
+// by peers. Empty values leave the bound host or port in place. Only
+// TCP addresses may be advertised.
+func (s *Server) SetAdvertise(host, port string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advertiseHost, s.advertisePort = host, port
+}
+
+// AdvertiseAddr returns the address at which other nodes should
+// connect to the server. This is the server's bound address, with
+// host and port replaced as specified via SetAdvertise.
+func (s *Server) AdvertiseAddr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.advertiseHost == "" && s.advertisePort == "" {
+		return s.addr
+	}
+	switch s.addr.Network() {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		log.Warningf("cannot advertise %s address %s with a different host or port", s.addr.Network(), s.addr)
+		return s.addr
+	}
+	host, port, err := net.SplitHostPort(s.addr.String())
+	if err != nil {
+		log.Warningf("unable to parse server addr %s: %v", s.addr, err)
+		return s.addr
+	}
+	if s.advertiseHost != "" {
+		host = s.advertiseHost
+	}
+	if s.advertisePort != "" {
+		port = s.advertisePort
+	}
+	return util.MakeRawAddr("tcp", net.JoinHostPort(host, port))
+}
+
 // Close closes the listener.
 func (s *Server) Close() {
 	s.mu.Lock()
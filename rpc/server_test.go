@@ -59,3 +59,28 @@ func TestUpdatedAddr(t *testing.T) {
 	checkUpdateMatches(t, "unix", "address", "address", "address")
 	checkUpdateFails(t, "unix", "address", "anotheraddress")
 }
+
+func TestAdvertiseAddr(t *testing.T) {
+	s := &Server{addr: util.MakeRawAddr("tcp", "10.0.0.1:1234")}
+	testCases := []struct {
+		host, port, expAddr string
+	}{
+		{"", "", "10.0.0.1:1234"},
+		{"public.example.com", "", "public.example.com:1234"},
+		{"", "4321", "10.0.0.1:4321"},
+		{"2001:db8::1", "4321", "[2001:db8::1]:4321"},
+	}
+	for i, test := range testCases {
+		s.SetAdvertise(test.host, test.port)
+		if addr := s.AdvertiseAddr(); addr.String() != test.expAddr {
+			t.Errorf("%d: expected advertised addr %s; got %s", i, test.expAddr, addr)
+		}
+	}
+
+	// Unix addresses can't be advertised differently.
+	s = &Server{addr: util.MakeRawAddr("unix", "/tmp/cockroach.sock")}
+	s.SetAdvertise("public.example.com", "4321")
+	if addr := s.AdvertiseAddr(); addr.String() != "/tmp/cockroach.sock" {
+		t.Errorf("expected unix addr to be advertised unchanged; got %s", addr)
+	}
+}
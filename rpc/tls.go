@@ -27,6 +27,7 @@ import (
 	"io/ioutil"
 	"net"
 	"path"
+	"strings"
 	"sync"
 
 	"github.com/cockroachdb/cockroach/util/log"
@@ -91,6 +92,26 @@ func LoadTLSConfig(certDir string) (*TLSConfig, error) {
 	}, nil
 }
 
+// VerifyHost returns an error if the node certificate isn't valid for
+// the specified host name or IP address, which may be an IPv6 literal
+// with or without a zone. Always returns nil if TLS is disabled.
+func (c *TLSConfig) VerifyHost(host string) error {
+	cfg := c.Config()
+	if cfg == nil || len(cfg.Certificates) == 0 || len(cfg.Certificates[0].Certificate) == 0 {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		return err
+	}
+	// Certificates can't name IPv6 zones; strip any zone so that the
+	// address is matched against the certificate's IP SANs.
+	if i := strings.LastIndex(host, "%"); i > 0 && strings.Contains(host, ":") {
+		host = host[:i]
+	}
+	return cert.VerifyHostname(host)
+}
+
 // LoadInsecureTLSConfig creates a TLSConfig that disables TLS.
 func LoadInsecureTLSConfig() *TLSConfig {
 	return &TLSConfig{
@@ -69,3 +69,23 @@ func verifyX509Cert(cert *x509.Certificate, dnsName string, roots *x509.CertPool
 	_, err := cert.Verify(verifyOptions)
 	return err
 }
+
+// TestVerifyHost verifies that host names and IP addresses are checked
+// against the node certificate's subject alternative names.
+func TestVerifyHost(t *testing.T) {
+	config, err := LoadTestTLSConfig("..")
+	if err != nil {
+		t.Fatalf("Failed to load TLS config: %v", err)
+	}
+	if err := config.VerifyHost("127.0.0.1"); err != nil {
+		t.Errorf("expected test cert to be valid for 127.0.0.1: %v", err)
+	}
+	for _, host := range []string{"::1", "fe80::1%eth0", "10.0.0.1", "google.com"} {
+		if err := config.VerifyHost(host); err == nil {
+			t.Errorf("expected test cert to be invalid for %s", host)
+		}
+	}
+	if err := LoadInsecureTLSConfig().VerifyHost("google.com"); err != nil {
+		t.Errorf("expected insecure config to accept any host: %v", err)
+	}
+}
@@ -4,6 +4,12 @@
 # The default choice is cockroachdb/cockroach-dev; to run deployment
 # image acceptance tests, use cockroachdb/cockroachdb instead.
 #
+# To run a mixed-version cluster, supply PREV_COCKROACH_IMAGE, e.g. the
+# cockroachdb/cockroach-prev image built by build/build-docker-prev.sh.
+# Every even-numbered node then runs that image, and once the gossip
+# network connects, a key is written through each node and read back
+# through every node.
+#
 # Author: Spencer Kimball (spencerkimball@gmail.com)
 
 cd "$(dirname $0)"
@@ -99,9 +105,15 @@ for i in $(seq 1 $NODES); do
   # Node-specific arguments for node container.
   NODE_ARGS="--hostname=${HOSTS[$i]} --name=${HOSTS[$i]} --dns=$DNS_IP"
 
+  # Even-numbered nodes run the previous release, if one is given.
+  IMAGE=$COCKROACH_IMAGE
+  if [[ $PREV_COCKROACH_IMAGE != "" && $((i % 2)) == 0 ]]; then
+    IMAGE=$PREV_COCKROACH_IMAGE
+  fi
+
   # Start Cockroach docker container and corral HTTP port and docker
   # IP address for container-local DNS.
-  CIDS[$i]=$(docker run $STD_ARGS $NODE_ARGS $COCKROACH_IMAGE $CMD $CMD_ARGS)
+  CIDS[$i]=$(docker run $STD_ARGS $NODE_ARGS $IMAGE $CMD $CMD_ARGS)
   HTTP_PORTS[$i]=$(echo $(docker port ${CIDS[$i]} 8080) | sed 's/.*://')
   IP=$(docker inspect --format '{{ .NetworkSettings.IPAddress }}' ${CIDS[$i]})
   IP_HOST[$i]="$IP ${HOSTS[$i]}"
@@ -123,8 +135,31 @@ if [[ $DOCKERHOST != "127.0.0.1" ]]; then
   cat $DNS_FILE | boot2docker ssh "sudo -u root /bin/sh -c 'cat - > $DNS_FILE'"
 fi
 
+# Write a key through each node and read it back through every node.
+# In a mixed-version cluster, this exercises the RPCs and raft
+# commands exchanged between releases.
+function verify_kv {
+  for i in $(seq 1 $NODES); do
+    KEY="local-cluster-$i"
+    STATUS=$(curl -s -o /dev/null -w '%{http_code}' -X PUT -d "$i" $DOCKERHOST:${HTTP_PORTS[$i]}/kv/rest/entry/$KEY)
+    if [[ $STATUS != 200 ]]; then
+      echo "Failed to write $KEY through ${HOSTS[$i]}: HTTP status $STATUS"
+      return 1
+    fi
+    for j in $(seq 1 $NODES); do
+      STATUS=$(curl -s -o /dev/null -w '%{http_code}' $DOCKERHOST:${HTTP_PORTS[$j]}/kv/rest/entry/$KEY)
+      if [[ $STATUS != 200 ]]; then
+        echo "Failed to read $KEY through ${HOSTS[$j]}: HTTP status $STATUS"
+        return 1
+      fi
+    done
+  done
+  echo "All nodes read the keys written through every node"
+}
+
 # Get gossip network contents from each node in turn.
 echo -n "Waiting for complete gossip network"
+GOSSIP_OK=0
 MAX_WAIT=20 # seconds
 for ATTEMPT in $(seq 1 $MAX_WAIT); do
   echo -n .
@@ -146,13 +181,21 @@ for ATTEMPT in $(seq 1 $MAX_WAIT); do
   if [[ $ALL_FOUND == 1 ]]; then
     echo
     echo "All nodes verified in the cluster"
-    exit 0
+    GOSSIP_OK=1
+    break
   fi
 done
 
+if [[ $GOSSIP_OK == 1 ]]; then
+  if [[ $PREV_COCKROACH_IMAGE == "" ]] || verify_kv; then
+    exit 0
+  fi
+else
+  echo
+  echo "Failed to verify nodes in cluster after $MAX_WAIT seconds"
+fi
+
 # Print all node logs for debugging.
-echo
-echo "Failed to verify nodes in cluster after $MAX_WAIT seconds"
 for i in $(seq 1 $NODES); do
   echo ""
   echo "Output for ${HOSTS[$i]}..."
@@ -37,7 +37,7 @@ type acctHandler struct {
 
 // Put writes an accounting config for the specified key prefix (which is
 // treated as a key). The accounting config is parsed from the input "body".
-// The accounting config is stored gob-encoded. The specified body must must
+// The accounting config is stored protobuf-encoded. The specified body must
 // validly parse into an acctConfig struct.
 func (ah *acctHandler) Put(path string, body []byte, r *http.Request) error {
 	if len(path) == 0 {
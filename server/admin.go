@@ -19,19 +19,26 @@
 package server
 
 import (
+	"encoding/json"
 	// This is imported for its side-effect of registering expvar
 	// endpoints with the http.DefaultServeMux.
 	_ "expvar"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	// This is imported for its side-effect of registering pprof
 	// endpoints with the http.DefaultServeMux.
 	_ "net/http/pprof"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/kv"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/hlc"
 )
 
 const (
@@ -49,6 +56,8 @@ const (
 	debugEndpoint = "/debug/"
 	// healthzPath is the healthz endpoint.
 	healthzPath = adminEndpoint + "healthz"
+	// auditPath is the endpoint for querying the audit log.
+	auditPath = adminEndpoint + "audit"
 	// acctPathPrefix is the prefix for accounting configuration changes.
 	acctPathPrefix = adminEndpoint + "acct"
 	// permPathPrefix is the prefix for permission configuration changes.
@@ -68,20 +77,23 @@ type actionHandler interface {
 // A adminServer provides a RESTful HTTP API to administration of
 // the cockroach cluster.
 type adminServer struct {
-	db   *client.KV // Key-value database client
-	acct *acctHandler
-	perm *permHandler
-	zone *zoneHandler
+	db    *client.KV // Key-value database client
+	audit *kv.AuditLog
+	acct  *acctHandler
+	perm  *permHandler
+	zone  *zoneHandler
 }
 
 // newAdminServer allocates and returns a new REST server for
-// administrative APIs.
-func newAdminServer(db *client.KV) *adminServer {
+// administrative APIs. Audit events are timestamped by the supplied
+// clock.
+func newAdminServer(db *client.KV, clock *hlc.Clock) *adminServer {
 	return &adminServer{
-		db:   db,
-		acct: &acctHandler{db: db},
-		perm: &permHandler{db: db},
-		zone: &zoneHandler{db: db},
+		db:    db,
+		audit: kv.NewAuditLog(db.Sender(), clock),
+		acct:  &acctHandler{db: db},
+		perm:  &permHandler{db: db},
+		zone:  &zoneHandler{db: db},
 	}
 }
 
@@ -92,6 +104,7 @@ func (s *adminServer) RegisterHandlers(mux *http.ServeMux) {
 	// get exported variables and pprof tools.
 	mux.HandleFunc(acctPathPrefix, s.handleAcctAction)
 	mux.HandleFunc(acctPathPrefix+"/", s.handleAcctAction)
+	mux.HandleFunc(auditPath, s.handleAudit)
 	mux.HandleFunc(debugEndpoint, s.handleDebug)
 	mux.HandleFunc(healthzPath, s.handleHealthz)
 	mux.HandleFunc(permPathPrefix, s.handlePermAction)
@@ -114,6 +127,73 @@ func (s *adminServer) handleDebug(w http.ResponseWriter, r *http.Request) {
 	handler.ServeHTTP(w, r)
 }
 
+// handleAudit responds with the audit log as a JSON array of events,
+// oldest first. The optional "start" and "end" query parameters bound
+// the events by wall time in nanoseconds and "max" limits the number
+// returned. The audit log is append-only, so only GET is supported.
+// Only root, as authenticated by its client certificate, may read the
+// audit log.
+func (s *adminServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "audit log is append-only", http.StatusMethodNotAllowed)
+		return
+	}
+	user, err := authenticatedUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if user != storage.UserRoot {
+		http.Error(w, fmt.Sprintf("user %q cannot read the audit log", user), http.StatusForbidden)
+		return
+	}
+	var bounds [3]int64
+	for i, name := range []string{"start", "end", "max"} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		var err error
+		if bounds[i], err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %s", name, err), http.StatusBadRequest)
+			return
+		}
+	}
+	if bounds[1] == 0 {
+		bounds[1] = math.MaxInt64
+	}
+	events, err := s.audit.Scan(bounds[0], bounds[1], bounds[2])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b, err := json.MarshalIndent(events, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// recordAudit records the outcome of a configuration change to the
+// audit log. The user is recorded only if authenticated; the origin
+// address is recorded in any case.
+func (s *adminServer) recordAudit(r *http.Request, prefix, path string, body []byte, err error) {
+	user, _ := authenticatedUser(r)
+	s.audit.RecordRequest(r, user, r.Method+" "+prefix, path, body, err)
+}
+
+// authenticatedUser returns the user named by the common name of the
+// request's verified TLS client certificate. Returns an error if the
+// request wasn't made with a verified client certificate.
+func authenticatedUser(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", util.Errorf("request is not authenticated by a verified client certificate")
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, nil
+}
+
 // TODO(bram): using a single handler instead of one each for zone/perm/acct
 // handleAcctAction handles actions for accounting configuration by method.
 func (s *adminServer) handleAcctAction(w http.ResponseWriter, r *http.Request) {
@@ -177,7 +257,9 @@ func (s *adminServer) handlePutAction(handler actionHandler, w http.ResponseWrit
 		return
 	}
 	defer r.Body.Close()
-	if err = handler.Put(path, b, r); err != nil {
+	err = handler.Put(path, b, r)
+	s.recordAudit(r, prefix, path, b, err)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -205,7 +287,9 @@ func (s *adminServer) handleDeleteAction(handler actionHandler, w http.ResponseW
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err = handler.Delete(path, r); err != nil {
+	err = handler.Delete(path, r)
+	s.recordAudit(r, prefix, path, nil, err)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -18,7 +18,11 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -27,7 +31,9 @@ import (
 	"testing"
 
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -40,7 +46,7 @@ func startAdminServer() *httptest.Server {
 	if err != nil {
 		log.Fatal(err)
 	}
-	admin := newAdminServer(db)
+	admin := newAdminServer(db, hlc.NewClock(hlc.UnixNano))
 	mux := http.NewServeMux()
 	admin.RegisterHandlers(mux)
 	httpServer := httptest.NewServer(mux)
@@ -107,3 +113,85 @@ func TestAdminDebugPprof(t *testing.T) {
 		t.Errorf("expected match: %t; err nil: %v", matches, err)
 	}
 }
+
+// TestAdminAuditLog verifies that configuration changes are recorded
+// to the audit log with their origin, and that the audit log can be
+// queried but not modified via the admin API, and only by root.
+func TestAdminAuditLog(t *testing.T) {
+	db, err := BootstrapCluster("cluster-1", engine.NewInMem(proto.Attributes{}, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := newAdminServer(db, hlc.NewClock(hlc.UnixNano))
+	mux := http.NewServeMux()
+	admin.RegisterHandlers(mux)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		var body io.Reader
+		if method == "PUT" {
+			body = strings.NewReader(testZoneConfig)
+		}
+		req, err := http.NewRequest(method, s.URL+zonePathPrefix+"/audit", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Content-Type", "text/yaml")
+		if _, err := sendAdminRequest(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest("PUT", s.URL+auditPath, strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sendAdminRequest(req); err == nil {
+		t.Error("expected audit log modification to fail")
+	}
+
+	// Reading the audit log requires root's client certificate.
+	resp, err := http.Get(s.URL + auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unauthenticated read to fail with %d; got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	readAudit := func(user string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", auditPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: user}}
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		w := httptest.NewRecorder()
+		admin.handleAudit(w, req)
+		return w
+	}
+	if w := readAudit("admin"); w.Code != http.StatusForbidden {
+		t.Errorf("expected read by non-root user to fail with %d; got %d", http.StatusForbidden, w.Code)
+	}
+	w := readAudit(storage.UserRoot)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected read by root to succeed; got %d: %s", w.Code, w.Body)
+	}
+	var events []proto.AuditEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+		t.Fatalf("failed to unmarshal %q: %s", w.Body, err)
+	}
+	expActions := []string{"PUT " + zonePathPrefix, "DELETE " + zonePathPrefix}
+	if len(events) != len(expActions) {
+		t.Fatalf("expected %d audit events; got %+v", len(expActions), events)
+	}
+	for i, e := range events {
+		if e.Action != expActions[i] || e.Target != "/audit" || e.User != "" || e.Origin == "" || e.Error != "" {
+			t.Errorf("%d: unexpected audit event %+v", i, e)
+		}
+	}
+	if string(events[0].Details) != testZoneConfig {
+		t.Errorf("expected zone config in audit event details; got %q", events[0].Details)
+	}
+}
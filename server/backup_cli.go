@@ -0,0 +1,407 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/storage/sstable"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// backupManifestName is the name of the manifest written by a backup
+// export.
+const backupManifestName = "MANIFEST"
+
+var (
+	// backupScanChunkSize is the number of rows read by each scan of a
+	// backup export.
+	backupScanChunkSize int64 = 1000
+	// backupTableEntries is the maximum number of entries written to
+	// each table of a backup export.
+	backupTableEntries = 10000
+)
+
+// backupChecksumTable is the CRC-32 table used to checksum backup
+// tables.
+var backupChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// backupTableOptions are used to write and read backup tables, whose
+// keys are MVCC version keys.
+var backupTableOptions = &sstable.Options{
+	Compare: func(a, b []byte) int { return engine.MVCCComparator(a, b) },
+}
+
+// A CmdBackup command exports and verifies backups described by a
+// manifest.
+var CmdBackup = &commander.Command{
+	UsageLine: "backup export <start> <end> <dir> | verify [-sample=<n>] <manifest>",
+	Short:     "export a backup, or verify one without restoring it",
+	Long: `
+Export writes a backup of the keys in [<start>, <end>) on the cluster
+at -addr to <dir>. All keys are read at a single timestamp, which
+becomes the backup's timestamp. The backup consists of SSTables which
+map the MVCC version key of each row to its MVCC-encoded value, and a
+manifest named MANIFEST listing the tables with their key spans and
+CRC-32C checksums.
+
+Verify checks the backup described by <manifest> without restoring
+it. The checksum of each table listed in the manifest is checked
+against the manifest, and the tables' key spans are checked to cover
+the backup's span without gaps or overlaps. Every entry of every table
+is read: its key is decoded and checked to lie within its table's span
+and, for versioned values, not to be newer than the backup's
+timestamp, and MVCC metadata is checked not to hold an intent. With
+-sample=<n>, the value of every n-th entry also has its checksum
+verified against its key. -sample=1 verifies all values.
+
+Exits with a non-zero status on a usage error, if the backup can't be
+written or read, or if any problem is found.
+`,
+	Run:  runBackup,
+	Flag: *flag.CommandLine,
+}
+
+// runBackup dispatches to the backup subcommand named by args[0].
+func runBackup(cmd *commander.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "export":
+		runBackupExport(cmd, args[1:])
+	case "verify":
+		runBackupVerify(cmd, args[1:])
+	default:
+		cmd.Usage()
+		os.Exit(1)
+	}
+}
+
+// runBackupExport exports a backup of the key span given by args to
+// the directory given by args.
+func runBackupExport(cmd *commander.Command, args []string) {
+	if len(args) != 3 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(args[2], 0755); err != nil {
+		log.Errorf("unable to create backup directory: %s", err)
+		os.Exit(1)
+	}
+	kv := client.NewKV(client.NewHTTPSender(adminHost(), adminTransport()), nil)
+	kv.User = storage.UserRoot
+	defer kv.Close()
+	manifest, err := exportBackup(kv, proto.Key(args[0]), proto.Key(args[1]), args[2])
+	if err != nil {
+		log.Errorf("unable to export backup: %s", err)
+		os.Exit(1)
+	}
+	fmt.Printf("exported %d table(s) as of %s\n", len(manifest.Files), manifest.Timestamp)
+}
+
+// runBackupVerify verifies the backup described by the manifest given
+// by args.
+func runBackupVerify(cmd *commander.Command, args []string) {
+	verifyFlags := flag.NewFlagSet("backup verify", flag.ContinueOnError)
+	sample := verifyFlags.Int("sample", 0, "verify the checksum of every n-th value; 0 disables sampling")
+	if err := verifyFlags.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if verifyFlags.NArg() != 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	report, err := verifyBackup(verifyFlags.Arg(0), *sample)
+	if err != nil {
+		log.Errorf("unable to verify backup: %s", err)
+		os.Exit(1)
+	}
+	for _, err := range report.errs {
+		fmt.Printf("FAIL: %s\n", err)
+	}
+	fmt.Printf("checked %d table(s) and %d sampled value(s): %d problem(s) found\n",
+		report.files, report.sampled, len(report.errs))
+	if len(report.errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// exportBackup writes a backup of the keys in [start, end) to dir,
+// reading them through kv, and returns its manifest. All rows are read
+// at the timestamp of the first scan, which becomes the backup's
+// timestamp. Each table holds up to backupTableEntries rows, mapped
+// from their MVCC version keys to their MVCCValues, and the tables'
+// spans tile [start, end).
+func exportBackup(kv *client.KV, start, end proto.Key, dir string) (*proto.BackupManifest, error) {
+	manifest := &proto.BackupManifest{StartKey: start, EndKey: end}
+	var buf bytes.Buffer
+	var w *sstable.Writer
+	var file proto.BackupFile
+	var entries int
+	newTable := func(startKey proto.Key) {
+		buf.Reset()
+		w = sstable.NewWriter(&buf, backupTableOptions)
+		file = proto.BackupFile{Path: fmt.Sprintf("%06d.sst", len(manifest.Files)+1), StartKey: startKey}
+		entries = 0
+	}
+	finishTable := func(endKey proto.Key) error {
+		if err := w.Close(); err != nil {
+			return err
+		}
+		file.EndKey = endKey
+		file.Checksum = crc32.Checksum(buf.Bytes(), backupChecksumTable)
+		if err := ioutil.WriteFile(filepath.Join(dir, file.Path), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+		return nil
+	}
+
+	newTable(start)
+	for key := start; ; {
+		sr := &proto.ScanResponse{}
+		if err := kv.Call(proto.Scan, &proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       key,
+				EndKey:    end,
+				User:      storage.UserRoot,
+				Timestamp: manifest.Timestamp,
+			},
+			MaxResults: backupScanChunkSize,
+		}, sr); err != nil {
+			return nil, err
+		}
+		if manifest.Timestamp.Equal(proto.ZeroTimestamp) {
+			manifest.Timestamp = sr.Timestamp
+		}
+		for i := range sr.Rows {
+			row := &sr.Rows[i]
+			if entries == backupTableEntries {
+				if err := finishTable(row.Key); err != nil {
+					return nil, err
+				}
+				newTable(row.Key)
+			}
+			if row.Value.Timestamp == nil {
+				return nil, util.Errorf("value of key %q has no timestamp", row.Key)
+			}
+			data, err := gogoproto.Marshal(&proto.MVCCValue{Value: &row.Value})
+			if err != nil {
+				return nil, err
+			}
+			if err := w.Add(engine.MVCCEncodeVersionKey(row.Key, *row.Value.Timestamp), data); err != nil {
+				return nil, err
+			}
+			entries++
+		}
+		if int64(len(sr.Rows)) < backupScanChunkSize {
+			break
+		}
+		key = sr.Rows[len(sr.Rows)-1].Key.Next()
+	}
+	if err := finishTable(end); err != nil {
+		return nil, err
+	}
+
+	data, err := gogoproto.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, backupManifestName), data, 0644); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// backupReport summarizes the verification of a backup.
+type backupReport struct {
+	files   int     // Number of tables whose checksums were checked
+	sampled int     // Number of entries whose values were verified
+	errs    []error // Problems found with the backup
+}
+
+// verifyBackup reads the manifest at manifestPath and verifies the
+// backup it describes, sampling every sampleEvery-th entry if
+// sampleEvery is positive. An error is returned only if the manifest
+// can't be read; problems with the backup itself are recorded in the
+// returned report.
+func verifyBackup(manifestPath string, sampleEvery int) (*backupReport, error) {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &proto.BackupManifest{}
+	if err := gogoproto.Unmarshal(data, manifest); err != nil {
+		return nil, util.Errorf("unable to unmarshal backup manifest %s: %s", manifestPath, err)
+	}
+	report := &backupReport{errs: verifyBackupSpans(manifest)}
+	dir := filepath.Dir(manifestPath)
+	for _, file := range manifest.Files {
+		sampled, errs := verifyBackupFile(dir, file, manifest.Timestamp, sampleEvery)
+		report.files++
+		report.sampled += sampled
+		report.errs = append(report.errs, errs...)
+	}
+	return report, nil
+}
+
+// backupFilesByStartKey sorts backup files by the start of their spans.
+type backupFilesByStartKey []proto.BackupFile
+
+func (f backupFilesByStartKey) Len() int           { return len(f) }
+func (f backupFilesByStartKey) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f backupFilesByStartKey) Less(i, j int) bool { return f[i].StartKey.Less(f[j].StartKey) }
+
+// verifyBackupSpans checks that the spans of the manifest's tables
+// tile the backup's span, returning an error for each gap, overlap or
+// invalid span.
+func verifyBackupSpans(manifest *proto.BackupManifest) []error {
+	if !manifest.StartKey.Less(manifest.EndKey) {
+		return []error{util.Errorf("invalid backup span [%q, %q)", manifest.StartKey, manifest.EndKey)}
+	}
+	files := append([]proto.BackupFile(nil), manifest.Files...)
+	sort.Sort(backupFilesByStartKey(files))
+	var errs []error
+	next := manifest.StartKey
+	for _, f := range files {
+		if !f.StartKey.Less(f.EndKey) {
+			errs = append(errs, util.Errorf("%s: invalid span [%q, %q)", f.Path, f.StartKey, f.EndKey))
+			continue
+		}
+		if next.Less(f.StartKey) {
+			errs = append(errs, util.Errorf("keys [%q, %q) are not covered by any table", next, f.StartKey))
+		} else if f.StartKey.Less(next) {
+			errs = append(errs, util.Errorf("%s: span [%q, %q) overlaps keys before %q", f.Path, f.StartKey, f.EndKey, next))
+		}
+		if next.Less(f.EndKey) {
+			next = f.EndKey
+		}
+	}
+	if next.Less(manifest.EndKey) {
+		errs = append(errs, util.Errorf("keys [%q, %q) are not covered by any table", next, manifest.EndKey))
+	} else if manifest.EndKey.Less(next) {
+		errs = append(errs, util.Errorf("tables extend past the end of the backup span %q to %q", manifest.EndKey, next))
+	}
+	return errs
+}
+
+// verifyBackupFile checks the checksum of the table described by file,
+// which is located relative to dir. The table's keys are decoded and
+// checked to lie within its span and not to hold versions newer than
+// timestamp, and its MVCC metadata is checked not to hold intents. If
+// sampleEvery is positive, every sampleEvery-th entry also has its
+// value verified. Returns the number of entries sampled and any
+// problems found.
+func verifyBackupFile(dir string, file proto.BackupFile, timestamp proto.Timestamp, sampleEvery int) (int, []error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, file.Path))
+	if err != nil {
+		return 0, []error{err}
+	}
+	if c := crc32.Checksum(data, backupChecksumTable); c != file.Checksum {
+		return 0, []error{util.Errorf("%s: checksum %08x does not match manifest checksum %08x", file.Path, c, file.Checksum)}
+	}
+	r, err := sstable.NewReader(bytes.NewReader(data), int64(len(data)), backupTableOptions)
+	if err != nil {
+		return 0, []error{util.Errorf("%s: %s", file.Path, err)}
+	}
+	var errs []error
+	var count, sampled int
+	iter := r.NewIterator()
+	defer iter.Close()
+	for iter.Seek(nil); iter.Valid(); iter.Next() {
+		key, ts, isValue, err := decodeBackupKey(iter.Key())
+		if err != nil {
+			errs = append(errs, util.Errorf("%s: %s", file.Path, err))
+			continue
+		}
+		if key.Less(file.StartKey) || !key.Less(file.EndKey) {
+			errs = append(errs, util.Errorf("%s: key %q lies outside of the table's span [%q, %q)", file.Path, key, file.StartKey, file.EndKey))
+		}
+		if isValue && timestamp.Less(ts) {
+			errs = append(errs, util.Errorf("%s: version of key %q at %s is newer than the backup timestamp %s", file.Path, key, ts, timestamp))
+		}
+		count++
+		value, err := decodeBackupValue(key, isValue, iter.Value())
+		if err != nil {
+			errs = append(errs, util.Errorf("%s: %s", file.Path, err))
+			continue
+		}
+		if sampleEvery <= 0 || (count-1)%sampleEvery != 0 {
+			continue
+		}
+		sampled++
+		if value != nil {
+			if err := value.Verify(key); err != nil {
+				errs = append(errs, util.Errorf("%s: %s", file.Path, err))
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		errs = append(errs, util.Errorf("%s: %s", file.Path, err))
+	}
+	return sampled, errs
+}
+
+// decodeBackupKey decodes an MVCC key read from a backup table,
+// returning an error instead of panicking if the key is malformed.
+func decodeBackupKey(encKey []byte) (key proto.Key, ts proto.Timestamp, isValue bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = util.Errorf("malformed key %q: %v", encKey, r)
+		}
+	}()
+	key, ts, isValue = engine.MVCCDecodeKey(encKey)
+	return
+}
+
+// decodeBackupValue unmarshals the value stored under key, an
+// MVCCValue for a version or an MVCCMetadata otherwise, and returns
+// the value it holds, if any. Metadata holding an intent is reported
+// as a problem: a backup as of a timestamp must not contain unresolved
+// writes.
+func decodeBackupValue(key proto.Key, isValue bool, data []byte) (*proto.Value, error) {
+	if isValue {
+		mvccVal := &proto.MVCCValue{}
+		if err := gogoproto.Unmarshal(data, mvccVal); err != nil {
+			return nil, util.Errorf("unable to unmarshal value for key %q: %s", key, err)
+		}
+		return mvccVal.Value, nil
+	}
+	meta := &proto.MVCCMetadata{}
+	if err := gogoproto.Unmarshal(data, meta); err != nil {
+		return nil, util.Errorf("unable to unmarshal metadata for key %q: %s", key, err)
+	}
+	if meta.Txn != nil {
+		return nil, util.Errorf("key %q holds an unresolved intent of txn %s", key, meta.Txn)
+	}
+	return meta.Value, nil
+}
@@ -0,0 +1,301 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/storage/sstable"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// backupTimestamp is the timestamp of the backups written by tests.
+var backupTimestamp = proto.Timestamp{WallTime: 10}
+
+// mvccBackupEntry returns an MVCC version key for key at ts mapped to
+// a marshaled MVCCValue whose value is checksummed with checksumKey.
+func mvccBackupEntry(t *testing.T, key string, ts proto.Timestamp, checksumKey string) ([]byte, []byte) {
+	value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("value-" + key)}}
+	value.InitChecksum([]byte(checksumKey))
+	data, err := gogoproto.Marshal(&proto.MVCCValue{Value: &value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine.MVCCEncodeVersionKey(proto.Key(key), ts), data
+}
+
+// writeBackupEntries writes a table holding the given encoded keys and
+// values to dir and returns its manifest entry, spanning [start, end).
+func writeBackupEntries(t *testing.T, dir, name string, start, end proto.Key, entries ...[2][]byte) proto.BackupFile {
+	var buf bytes.Buffer
+	w := sstable.NewWriter(&buf, backupTableOptions)
+	for _, e := range entries {
+		if err := w.Add(e[0], e[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return proto.BackupFile{
+		Path:     name,
+		StartKey: start,
+		EndKey:   end,
+		Checksum: crc32.Checksum(buf.Bytes(), backupChecksumTable),
+	}
+}
+
+// writeBackupTable writes a table holding a checksummed version of
+// each of the given keys at a timestamp before backupTimestamp to dir
+// and returns its manifest entry, spanning [start, end).
+func writeBackupTable(t *testing.T, dir, name string, start, end proto.Key, keys ...string) proto.BackupFile {
+	var entries [][2][]byte
+	for _, k := range keys {
+		key, value := mvccBackupEntry(t, k, proto.Timestamp{WallTime: 1}, k)
+		entries = append(entries, [2][]byte{key, value})
+	}
+	return writeBackupEntries(t, dir, name, start, end, entries...)
+}
+
+// writeBackupManifest writes a manifest for the backup of [start, end)
+// consisting of files to dir and returns its path.
+func writeBackupManifest(t *testing.T, dir string, start, end proto.Key, files ...proto.BackupFile) string {
+	manifest := &proto.BackupManifest{StartKey: start, EndKey: end, Timestamp: backupTimestamp, Files: files}
+	data, err := gogoproto.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "MANIFEST")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// expectBackupErrors verifies that the report contains exactly one
+// error per expected substring, in order.
+func expectBackupErrors(t *testing.T, report *backupReport, expected ...string) {
+	if len(report.errs) != len(expected) {
+		t.Fatalf("expected %d error(s); got %v", len(expected), report.errs)
+	}
+	for i, err := range report.errs {
+		if !strings.Contains(err.Error(), expected[i]) {
+			t.Errorf("%d: expected error matching %q; got %s", i, expected[i], err)
+		}
+	}
+}
+
+// TestVerifyBackup verifies that a complete, intact backup passes
+// verification with and without sampling.
+func TestVerifyBackup(t *testing.T) {
+	dir := createTempDirs(1, t)
+	defer resetTestData(dir)
+	f1 := writeBackupTable(t, dir[0], "1.sst", proto.Key("a"), proto.Key("m"), "a", "b", "c", "d")
+	f2 := writeBackupTable(t, dir[0], "2.sst", proto.Key("m"), proto.Key("z"), "m", "x", "y")
+	// List the tables out of order; verification shouldn't depend on it.
+	path := writeBackupManifest(t, dir[0], proto.Key("a"), proto.Key("z"), f2, f1)
+
+	for _, test := range []struct {
+		sampleEvery, expSampled int
+	}{
+		{0, 0},
+		{1, 7},
+		{2, 4}, // a, c from 1.sst; m, y from 2.sst
+	} {
+		report, err := verifyBackup(path, test.sampleEvery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectBackupErrors(t, report)
+		if report.files != 2 || report.sampled != test.expSampled {
+			t.Errorf("sample=%d: expected 2 files and %d sampled values; got %+v",
+				test.sampleEvery, test.expSampled, report)
+		}
+	}
+}
+
+// TestVerifyBackupSpans verifies that gaps, overlaps and invalid spans
+// in the manifest are reported.
+func TestVerifyBackupSpans(t *testing.T) {
+	file := func(start, end string) proto.BackupFile {
+		return proto.BackupFile{Path: fmt.Sprintf("%s-%s", start, end), StartKey: proto.Key(start), EndKey: proto.Key(end)}
+	}
+	testCases := []struct {
+		start, end string
+		files      []proto.BackupFile
+		expected   []string
+	}{
+		{"a", "z", []proto.BackupFile{file("a", "m"), file("m", "z")}, nil},
+		{"a", "z", nil, []string{`keys ["a", "z") are not covered`}},
+		{"a", "z", []proto.BackupFile{file("a", "k"), file("m", "z")}, []string{`keys ["k", "m") are not covered`}},
+		{"a", "z", []proto.BackupFile{file("b", "z")}, []string{`keys ["a", "b") are not covered`}},
+		{"a", "z", []proto.BackupFile{file("a", "y")}, []string{`keys ["y", "z") are not covered`}},
+		{"a", "z", []proto.BackupFile{file("a", "n"), file("m", "z")}, []string{`m-z: span ["m", "z") overlaps`}},
+		{"a", "z", []proto.BackupFile{file("a", "zz")}, []string{"extend past the end"}},
+		{"a", "z", []proto.BackupFile{file("a", "z"), file("q", "q")}, []string{`q-q: invalid span`}},
+		{"z", "a", nil, []string{"invalid backup span"}},
+	}
+	for i, test := range testCases {
+		manifest := &proto.BackupManifest{StartKey: proto.Key(test.start), EndKey: proto.Key(test.end), Files: test.files}
+		errs := verifyBackupSpans(manifest)
+		if len(errs) != len(test.expected) {
+			t.Errorf("%d: expected errors %q; got %v", i, test.expected, errs)
+			continue
+		}
+		for j, err := range errs {
+			if !strings.Contains(err.Error(), test.expected[j]) {
+				t.Errorf("%d: expected error matching %q; got %s", i, test.expected[j], err)
+			}
+		}
+	}
+}
+
+// TestVerifyBackupCorruption verifies that tables which don't match
+// their checksums, hold malformed keys, keys outside of their spans,
+// versions newer than the backup or values which fail verification
+// are reported.
+func TestVerifyBackupCorruption(t *testing.T) {
+	dir := createTempDirs(1, t)
+	defer resetTestData(dir)
+
+	// A table whose contents no longer match the manifest.
+	f := writeBackupTable(t, dir[0], "1.sst", proto.Key("a"), proto.Key("z"), "a", "b")
+	f.Checksum++
+	path := writeBackupManifest(t, dir[0], proto.Key("a"), proto.Key("z"), f)
+	report, err := verifyBackup(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBackupErrors(t, report, "does not match manifest checksum")
+
+	// A table holding keys outside of its span.
+	f = writeBackupTable(t, dir[0], "1.sst", proto.Key("b"), proto.Key("z"), "a", "b")
+	path = writeBackupManifest(t, dir[0], proto.Key("b"), proto.Key("z"), f)
+	if report, err = verifyBackup(path, 1); err != nil {
+		t.Fatal(err)
+	}
+	expectBackupErrors(t, report, `key "a" lies outside`)
+
+	// A table holding a plain, unencoded key.
+	f = writeBackupEntries(t, dir[0], "1.sst", proto.Key("a"), proto.Key("z"), [2][]byte{[]byte("a"), []byte("value")})
+	path = writeBackupManifest(t, dir[0], proto.Key("a"), proto.Key("z"), f)
+	if report, err = verifyBackup(path, 1); err != nil {
+		t.Fatal(err)
+	}
+	expectBackupErrors(t, report, "malformed key")
+
+	// A table holding a version newer than the backup.
+	key, value := mvccBackupEntry(t, "a", backupTimestamp.Add(1, 0), "a")
+	f = writeBackupEntries(t, dir[0], "1.sst", proto.Key("a"), proto.Key("z"), [2][]byte{key, value})
+	path = writeBackupManifest(t, dir[0], proto.Key("a"), proto.Key("z"), f)
+	if report, err = verifyBackup(path, 1); err != nil {
+		t.Fatal(err)
+	}
+	expectBackupErrors(t, report, "is newer than the backup timestamp")
+
+	// A table holding an unresolved intent.
+	meta, err := gogoproto.Marshal(&proto.MVCCMetadata{Txn: &proto.Transaction{Name: "txn"}, Timestamp: proto.Timestamp{WallTime: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = writeBackupEntries(t, dir[0], "1.sst", proto.Key("a"), proto.Key("z"), [2][]byte{engine.MVCCEncodeKey(proto.Key("a")), meta})
+	path = writeBackupManifest(t, dir[0], proto.Key("a"), proto.Key("z"), f)
+	// Every entry is checked for intents, whether it's sampled or not.
+	if report, err = verifyBackup(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	expectBackupErrors(t, report, "unresolved intent")
+
+	// A table holding a value checksummed with a different key.
+	key, value = mvccBackupEntry(t, "a", proto.Timestamp{WallTime: 1}, "other")
+	f = writeBackupEntries(t, dir[0], "1.sst", proto.Key("a"), proto.Key("z"), [2][]byte{key, value})
+	path = writeBackupManifest(t, dir[0], proto.Key("a"), proto.Key("z"), f)
+	// Without sampling the values' checksums aren't verified.
+	if report, err = verifyBackup(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	expectBackupErrors(t, report)
+	if report, err = verifyBackup(path, 1); err != nil {
+		t.Fatal(err)
+	}
+	expectBackupErrors(t, report, "invalid checksum")
+
+	// A missing table.
+	f.Path = "missing.sst"
+	path = writeBackupManifest(t, dir[0], proto.Key("a"), proto.Key("z"), f)
+	if report, err = verifyBackup(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	expectBackupErrors(t, report, "missing.sst")
+}
+
+// TestExportBackup verifies that an exported backup holds the rows in
+// its span, split across tables which tile the span, and passes
+// verification.
+func TestExportBackup(t *testing.T) {
+	defer func(c int64, n int) {
+		backupScanChunkSize, backupTableEntries = c, n
+	}(backupScanChunkSize, backupTableEntries)
+	// Use small scans and tables so the export spans several of each.
+	backupScanChunkSize, backupTableEntries = 3, 4
+
+	ts := StartTestServer(t)
+	defer ts.Stop()
+	dir := createTempDirs(1, t)
+	defer resetTestData(dir)
+
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "zz"} {
+		args := proto.PutArgs(proto.Key(k), []byte("value-"+k))
+		args.User = storage.UserRoot
+		if err := ts.node.db.Call(proto.Put, args, &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest, err := exportBackup(ts.node.db, proto.Key("a"), proto.Key("z"), dir[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expSpans := [][2]string{{"a", "e"}, {"e", "i"}, {"i", "z"}}
+	if len(manifest.Files) != len(expSpans) {
+		t.Fatalf("expected %d tables; got %+v", len(expSpans), manifest.Files)
+	}
+	for i, f := range manifest.Files {
+		if !f.StartKey.Equal(proto.Key(expSpans[i][0])) || !f.EndKey.Equal(proto.Key(expSpans[i][1])) {
+			t.Errorf("%d: expected span [%q, %q); got [%q, %q)", i, expSpans[i][0], expSpans[i][1], f.StartKey, f.EndKey)
+		}
+	}
+
+	report, err := verifyBackup(filepath.Join(dir[0], backupManifestName), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBackupErrors(t, report)
+	if report.files != 3 || report.sampled != 10 {
+		t.Errorf("expected 3 files and 10 sampled values; got %+v", report)
+	}
+}
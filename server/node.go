@@ -20,7 +20,9 @@ package server
 import (
 	"container/list"
 	"net"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/cockroachdb/cockroach/client"
@@ -30,7 +32,9 @@ import (
 	"github.com/cockroachdb/cockroach/rpc"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/structured"
 	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/build"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
 )
@@ -45,6 +49,10 @@ const (
 	ttlCapacityGossip = 2 * time.Minute
 	// ttlNodeIDGossip is time-to-live for node ID -> address.
 	ttlNodeIDGossip = 0 * time.Second
+	// schemaRefreshInterval is the interval at which stored structured
+	// schemas are registered, picking up schemas put through other
+	// nodes.
+	schemaRefreshInterval = 1 * time.Minute
 )
 
 // A Node manages a map of stores (by store ID) for which it serves
@@ -63,8 +71,13 @@ type Node struct {
 	db         *client.KV             // KV DB client; used to access global id generators
 	lSender    *kv.LocalSender        // Local KV sender for access to node-local stores
 	closer     chan struct{}
+	standby    bool // Start in read-only standby mode
 
 	maxAvailPrefix string // Prefix for max avail capacity gossip topic
+
+	mu         sync.Mutex          // Protects peers and peersDirty
+	peers      map[string]net.Addr // Addresses of other nodes, learned via gossip
+	peersDirty bool                // True if peers have changed since last persisted
 }
 
 // allocateNodeID increments the node id generator key to allocate
@@ -159,6 +172,7 @@ func NewNode(db *client.KV, gossip *gossip.Gossip) *Node {
 		db:      db,
 		lSender: kv.NewLocalSender(),
 		closer:  make(chan struct{}),
+		peers:   map[string]net.Addr{},
 	}
 	return n
 }
@@ -171,6 +185,7 @@ func (n *Node) initDescriptor(addr net.Addr, attrs proto.Attributes) {
 		// NodeID is after invocation of start()
 		Address: addr,
 		Attrs:   attrs,
+		Standby: n.standby,
 	}
 }
 
@@ -180,17 +195,22 @@ func (n *Node) initDescriptor(addr net.Addr, attrs proto.Attributes) {
 // in a goroutine.
 func (n *Node) start(rpcServer *rpc.Server, clock *hlc.Clock,
 	engines []engine.Engine, attrs proto.Attributes) error {
-	n.initDescriptor(rpcServer.Addr(), attrs)
+	n.initDescriptor(rpcServer.AdvertiseAddr(), attrs)
 	if err := rpcServer.RegisterName("Node", n); err != nil {
 		log.Fatalf("unable to register node service with RPC server: %s", err)
 	}
+	n.gossip.RegisterCallback("^"+gossip.KeyNodeIDPrefix+"[0-9a-f]+$", n.updatePeer)
 
 	// Initialize stores, including bootstrapping new ones.
 	if err := n.initStores(clock, engines); err != nil {
 		return err
 	}
 	go n.startGossip()
+	go n.startSchemaRefresh()
 	log.Infof("Started node with %v engine(s) and attributes %v", engines, attrs)
+	if n.standby {
+		log.Infof("Node is in standby mode; no new replicas will be allocated to its stores")
+	}
 	return nil
 }
 
@@ -238,7 +258,10 @@ func (n *Node) initStores(clock *hlc.Clock, engines []engine.Engine) error {
 	}
 
 	// Connect gossip before starting bootstrap. For new nodes, connecting
-	// to the gossip network is necessary to get the cluster ID.
+	// to the gossip network is necessary to get the cluster ID. Nodes
+	// with initialized stores may also use the node addresses persisted
+	// by those stores to rejoin.
+	n.loadBootstrapInfo()
 	n.connectGossip()
 
 	// Bootstrap any uninitialized stores asynchronously.
@@ -284,11 +307,7 @@ func (n *Node) bootstrapStores(bootstraps *list.List) {
 		if err != nil {
 			log.Fatal(err)
 		}
-		// Gossip node address keyed by node ID.
-		nodeIDKey := gossip.MakeNodeIDGossipKey(n.Descriptor.NodeID)
-		if err := n.gossip.AddInfo(nodeIDKey, n.Descriptor.Address, ttlNodeIDGossip); err != nil {
-			log.Errorf("couldn't gossip address for node %d: %v", n.Descriptor.NodeID, err)
-		}
+		n.gossipNodeInfo()
 	}
 
 	// Bootstrap all waiting stores by allocating a new store id for
@@ -315,7 +334,7 @@ func (n *Node) bootstrapStores(bootstraps *list.List) {
 // connectGossip connects to gossip network and reads cluster ID. If
 // this node is already part of a cluster, the cluster ID is verified
 // for a match. If not part of a cluster, the cluster ID is set. The
-// node's address is gossipped with node ID as the gossip key.
+// node's address and build are gossipped, keyed by node ID.
 func (n *Node) connectGossip() {
 	log.Infof("connecting to gossip network to verify cluster ID...")
 	<-n.gossip.Connected
@@ -334,15 +353,112 @@ func (n *Node) connectGossip() {
 	}
 	log.Infof("node connected via gossip and verified as part of cluster %q", gossipClusterID)
 
-	// Gossip node address keyed by node ID.
 	if n.Descriptor.NodeID != 0 {
-		nodeIDKey := gossip.MakeNodeIDGossipKey(n.Descriptor.NodeID)
-		if err := n.gossip.AddInfo(nodeIDKey, n.Descriptor.Address, ttlNodeIDGossip); err != nil {
-			log.Errorf("couldn't gossip address for node %d: %v", n.Descriptor.NodeID, err)
+		n.gossipNodeInfo()
+	}
+}
+
+// gossipNodeInfo gossips the node's address and build, keyed by
+// node ID.
+func (n *Node) gossipNodeInfo() {
+	nodeIDKey := gossip.MakeNodeIDGossipKey(n.Descriptor.NodeID)
+	if err := n.gossip.AddInfo(nodeIDKey, n.Descriptor.Address, ttlNodeIDGossip); err != nil {
+		log.Errorf("couldn't gossip address for node %d: %v", n.Descriptor.NodeID, err)
+	}
+	buildKey := gossip.MakeBuildGossipKey(n.Descriptor.NodeID)
+	if err := n.gossip.AddInfo(buildKey, build.GetInfo().Short(), ttlNodeIDGossip); err != nil {
+		log.Errorf("couldn't gossip build for node %d: %v", n.Descriptor.NodeID, err)
+	}
+}
+
+// updatePeer is a gossip callback which records the address of another
+// node in the cluster.
+func (n *Node) updatePeer(key string, contentsChanged bool) {
+	val, err := n.gossip.GetInfo(key)
+	if err != nil {
+		log.Errorf("unable to fetch node address for gossip key %q: %v", key, err)
+		return
+	}
+	addr, ok := val.(net.Addr)
+	if !ok {
+		log.Errorf("gossip key %q has unexpected value %+v", key, val)
+		return
+	}
+	if n.Descriptor.Address != nil && addr.String() == n.Descriptor.Address.String() {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if prev, ok := n.peers[key]; !ok || prev.String() != addr.String() {
+		n.peers[key] = addr
+		n.peersDirty = true
+	}
+}
+
+// loadBootstrapInfo adds the node addresses persisted by the node's
+// stores to the gossip bootstrap hosts, so that a restarted node can
+// rejoin the gossip network even if the hosts specified via -gossip
+// are gone. Should the persisted addresses now belong to a different
+// cluster, connectGossip refuses to join it.
+func (n *Node) loadBootstrapInfo() {
+	var addrs []net.Addr
+	n.lSender.VisitStores(func(s *storage.Store) error {
+		info := &proto.GossipBootstrapInfo{}
+		if ok, err := s.ReadBootstrapInfo(info); err != nil {
+			log.Warningf("unable to read gossip bootstrap info from store %s: %v", s, err)
+			return nil
+		} else if !ok {
+			return nil
+		}
+		for _, addr := range info.Addresses {
+			if addr.Address != n.Descriptor.Address.String() {
+				addrs = append(addrs, util.MakeRawAddr(addr.Network, addr.Address))
+			}
 		}
+		return nil
+	})
+	if len(addrs) > 0 {
+		log.Infof("adding %d persisted gossip bootstrap address(es)", len(addrs))
+		n.gossip.SetBootstrap(addrs)
+	}
+}
+
+// persistBootstrapInfo writes the addresses of other nodes learned via
+// gossip to each of the node's stores if they've changed since they
+// were last written.
+func (n *Node) persistBootstrapInfo() {
+	n.mu.Lock()
+	if !n.peersDirty {
+		n.mu.Unlock()
+		return
+	}
+	info := &proto.GossipBootstrapInfo{}
+	for _, addr := range n.peers {
+		info.Addresses = append(info.Addresses, *proto.FromNetAddr(addr))
 	}
+	n.peersDirty = false
+	n.mu.Unlock()
+
+	sort.Sort(addrsByString(info.Addresses))
+	n.lSender.VisitStores(func(s *storage.Store) error {
+		if err := s.WriteBootstrapInfo(info); err != nil {
+			log.Warningf("unable to persist gossip bootstrap info to store %s: %v", s, err)
+			// Try again on the next gossip interval.
+			n.mu.Lock()
+			n.peersDirty = true
+			n.mu.Unlock()
+		}
+		return nil
+	})
 }
 
+// addrsByString implements sort.Interface for a slice of addresses.
+type addrsByString []proto.Addr
+
+func (a addrsByString) Len() int           { return len(a) }
+func (a addrsByString) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a addrsByString) Less(i, j int) bool { return a[i].Address < a[j].Address }
+
 // startGossip loops on a periodic ticker to gossip node-related
 // information. Loops until the node is closed and should be
 // invoked via goroutine.
@@ -352,6 +468,27 @@ func (n *Node) startGossip() {
 		select {
 		case <-ticker.C:
 			n.gossipCapacities()
+			n.gossipStoreSummaries()
+			n.persistBootstrapInfo()
+		case <-n.closer:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// startSchemaRefresh registers the stored structured schemas now and
+// on a periodic ticker thereafter, so that ranges holding their data
+// are split only between rows. Loops until the node is closed and
+// should be invoked via goroutine.
+func (n *Node) startSchemaRefresh() {
+	ticker := time.NewTicker(schemaRefreshInterval)
+	for {
+		if err := structured.RegisterSchemas(n.db); err != nil {
+			log.Warningf("unable to register structured schemas: %s", err)
+		}
+		select {
+		case <-ticker.C:
 		case <-n.closer:
 			ticker.Stop()
 			return
@@ -378,6 +515,24 @@ func (n *Node) gossipCapacities() {
 	})
 }
 
+// gossipStoreSummaries computes a summary of each store and adds it
+// to the gossip network.
+func (n *Node) gossipStoreSummaries() {
+	n.lSender.VisitStores(func(s *storage.Store) error {
+		summary, err := s.Summary()
+		if err != nil {
+			log.Warningf("problem getting store summary for store %+v: %v", s.Ident, err)
+			return nil
+		}
+		// Unique gossip key per store.
+		key := gossip.KeyStoreSummaryPrefix +
+			strconv.FormatInt(int64(summary.NodeID), 10) + "-" +
+			strconv.FormatInt(int64(summary.StoreID), 10)
+		n.gossip.AddInfo(key, *summary, ttlCapacityGossip)
+		return nil
+	})
+}
+
 // executeCmd creates a client.Call struct and sends if via our local sender.
 func (n *Node) executeCmd(method string, args proto.Request, reply proto.Response) error {
 	call := &client.Call{
@@ -431,6 +586,11 @@ func (n *Node) Scan(args *proto.ScanRequest, reply *proto.ScanResponse) error {
 	return n.executeCmd(proto.Scan, args, reply)
 }
 
+// ReverseScan .
+func (n *Node) ReverseScan(args *proto.ReverseScanRequest, reply *proto.ReverseScanResponse) error {
+	return n.executeCmd(proto.ReverseScan, args, reply)
+}
+
 // EndTransaction .
 func (n *Node) EndTransaction(args *proto.EndTransactionRequest, reply *proto.EndTransactionResponse) error {
 	return n.executeCmd(proto.EndTransaction, args, reply)
@@ -451,6 +611,16 @@ func (n *Node) EnqueueMessage(args *proto.EnqueueMessageRequest, reply *proto.En
 	return n.executeCmd(proto.EnqueueMessage, args, reply)
 }
 
+// Batch .
+func (n *Node) Batch(args *proto.BatchRequest, reply *proto.BatchResponse) error {
+	return n.executeCmd(proto.Batch, args, reply)
+}
+
+// CompareAndSwap .
+func (n *Node) CompareAndSwap(args *proto.CompareAndSwapRequest, reply *proto.CompareAndSwapResponse) error {
+	return n.executeCmd(proto.CompareAndSwap, args, reply)
+}
+
 // AdminSplit .
 func (n *Node) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSplitResponse) error {
 	return n.executeCmd(proto.AdminSplit, args, reply)
@@ -485,3 +655,33 @@ func (n *Node) InternalSnapshotCopy(args *proto.InternalSnapshotCopyRequest, rep
 func (n *Node) InternalMerge(args *proto.InternalMergeRequest, reply *proto.InternalMergeResponse) error {
 	return n.executeCmd(proto.InternalMerge, args, reply)
 }
+
+// InternalConditionalPutInline .
+func (n *Node) InternalConditionalPutInline(args *proto.InternalConditionalPutInlineRequest, reply *proto.InternalConditionalPutInlineResponse) error {
+	return n.executeCmd(proto.InternalConditionalPutInline, args, reply)
+}
+
+// InternalDeleteInline .
+func (n *Node) InternalDeleteInline(args *proto.InternalDeleteInlineRequest, reply *proto.InternalDeleteInlineResponse) error {
+	return n.executeCmd(proto.InternalDeleteInline, args, reply)
+}
+
+// InternalComputeChecksum .
+func (n *Node) InternalComputeChecksum(args *proto.InternalComputeChecksumRequest, reply *proto.InternalComputeChecksumResponse) error {
+	return n.executeCmd(proto.InternalComputeChecksum, args, reply)
+}
+
+// InternalVerifyChecksum .
+func (n *Node) InternalVerifyChecksum(args *proto.InternalVerifyChecksumRequest, reply *proto.InternalVerifyChecksumResponse) error {
+	return n.executeCmd(proto.InternalVerifyChecksum, args, reply)
+}
+
+// InternalRecomputeStats .
+func (n *Node) InternalRecomputeStats(args *proto.InternalRecomputeStatsRequest, reply *proto.InternalRecomputeStatsResponse) error {
+	return n.executeCmd(proto.InternalRecomputeStats, args, reply)
+}
+
+// InternalGC .
+func (n *Node) InternalGC(args *proto.InternalGCRequest, reply *proto.InternalGCResponse) error {
+	return n.executeCmd(proto.InternalGC, args, reply)
+}
@@ -197,3 +197,50 @@ func TestNodeJoin(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// TestNodePersistBootstrapInfo verifies that a node persists the
+// addresses of other nodes learned via gossip to its stores.
+func TestNodePersistBootstrapInfo(t *testing.T) {
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	db, err := BootstrapCluster("cluster-1", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	*gossip.GossipInterval = 10 * time.Millisecond
+	addr1 := util.CreateTestAddr("tcp")
+	server1, _ := createTestNode(addr1, []engine.Engine{e}, addr1, t)
+	defer server1.Close()
+
+	engines2 := []engine.Engine{engine.NewInMem(proto.Attributes{}, 1<<20)}
+	server2, node2 := createTestNode(util.CreateTestAddr("tcp"), engines2, server1.Addr(), t)
+	defer server2.Close()
+	if err := util.IsTrueWithin(func() bool { return node2.lSender.GetStoreCount() == 1 }, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for node2 to learn node1's address via gossip.
+	if err := util.IsTrueWithin(func() bool {
+		node2.mu.Lock()
+		defer node2.mu.Unlock()
+		return len(node2.peers) == 1
+	}, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	node2.persistBootstrapInfo()
+
+	var store *storage.Store
+	node2.lSender.VisitStores(func(s *storage.Store) error {
+		store = s
+		return nil
+	})
+	info := &proto.GossipBootstrapInfo{}
+	if ok, err := store.ReadBootstrapInfo(info); !ok || err != nil {
+		t.Fatalf("expected bootstrap info to be persisted: %t, %v", ok, err)
+	}
+	expAddrs := []proto.Addr{*proto.FromNetAddr(server1.Addr())}
+	if !reflect.DeepEqual(info.Addresses, expAddrs) {
+		t.Errorf("expected persisted addresses %+v; got %+v", expAddrs, info.Addresses)
+	}
+}
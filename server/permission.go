@@ -37,7 +37,7 @@ type permHandler struct {
 
 // Put writes a perm config for the specified key prefix (which is treated as
 // a key). The perm config is parsed from the input "body". The perm config is
-// stored gob-encoded. The specified body must validly parse into a
+// stored protobuf-encoded. The specified body must validly parse into a
 // perm config struct.
 func (ph *permHandler) Put(path string, body []byte, r *http.Request) error {
 	if len(path) == 0 {
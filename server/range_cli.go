@@ -0,0 +1,62 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// A CmdRange command operates on the ranges of a cluster.
+var CmdRange = &commander.Command{
+	UsageLine: "range split [options] <key>",
+	Short:     "split the range containing a key",
+	Long: `
+Splits the range containing <key> on the cluster at -addr so that
+<key> begins a new range, and prints the descriptors of the ranges to
+the left and right of the split. Use this to pre-split the keyspace
+before bulk loading data; see also the split command, which splits at
+several keys at once. Exits with a non-zero status if the split
+fails.
+`,
+	Run:  runRange,
+	Flag: *flag.CommandLine,
+}
+
+// runRange dispatches to the range subcommand named by args[0].
+func runRange(cmd *commander.Command, args []string) {
+	if len(args) != 2 || args[0] != "split" {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	kv := client.NewKV(client.NewHTTPSender(adminHost(), adminTransport()), nil)
+	kv.User = storage.UserRoot
+	defer kv.Close()
+	left, right, err := kv.AdminSplitAt(proto.Key(args[1]))
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	fmt.Printf("left:  %s\n", formatRangeDescriptor(&left))
+	fmt.Printf("right: %s\n", formatRangeDescriptor(&right))
+}
@@ -0,0 +1,118 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/util/hlc"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+var confirmDataLoss = flag.Bool("confirm_data_loss", false, "specify "+
+	"--confirm_data_loss to acknowledge that unsafe-recover-range may lose "+
+	"committed writes")
+
+// A CmdUnsafeRecoverRange command rewrites a range's replica set to the
+// replicas which survived the loss of a majority.
+var CmdUnsafeRecoverRange = &commander.Command{
+	UsageLine: "unsafe-recover-range -stores=<stores> -confirm_data_loss <raft-id> <store-id>[,<store-id>...]",
+	Short:     "recreate quorum for a range which lost a majority of replicas",
+	Long: `
+Rewrites the descriptor of the range identified by <raft-id> so that
+its replicas are only those on the listed surviving stores. Run this
+with the node stopped, against the -stores of every node holding a
+surviving replica or the range's meta1/meta2 addressing records, using
+the same list of survivors each time.
+
+THIS MAY LOSE DATA. Writes committed by the lost replicas which were
+not yet applied by the survivors are gone, and if there are several
+survivors their contents may differ. Use only as a last resort when
+the lost replicas cannot be brought back. The command refuses to run
+without -confirm_data_loss.
+`,
+	Run:  runUnsafeRecoverRange,
+	Flag: *flag.CommandLine,
+}
+
+// runUnsafeRecoverRange parses the Raft ID and surviving store IDs
+// and rewrites the range's descriptor in each of the -stores engines.
+func runUnsafeRecoverRange(cmd *commander.Command, args []string) {
+	if len(args) != 2 {
+		cmd.Usage()
+		return
+	}
+	if !*confirmDataLoss {
+		log.Errorf("unsafe-recover-range may lose data; specify -confirm_data_loss to proceed")
+		return
+	}
+	raftID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		log.Errorf("invalid raft ID %q: %s", args[0], err)
+		return
+	}
+	var survivors []int32
+	for _, s := range strings.Split(args[1], ",") {
+		storeID, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			log.Errorf("invalid store ID %q: %s", s, err)
+			return
+		}
+		survivors = append(survivors, int32(storeID))
+	}
+
+	engines, err := initEngines(*stores)
+	if err != nil {
+		log.Errorf("Failed to initialize engines from -stores=%s: %v", *stores, err)
+		return
+	}
+	now := hlc.NewClock(hlc.UnixNano).Now()
+	var total int
+	for _, e := range engines {
+		if err := e.Start(); err != nil {
+			log.Errorf("unable to start engine %s: %s", e, err)
+			return
+		}
+		desc, count, err := storage.UnsafeRecoverRange(e, raftID, survivors, now)
+		e.Stop()
+		if err != nil {
+			log.Errorf("unable to recover range %d on engine %s: %s", raftID, e, err)
+			return
+		}
+		if desc != nil {
+			fmt.Printf("%s: rewrote %d descriptor(s) for range %d to replicas %s\n", e, count, raftID, formatReplicas(desc.Replicas))
+		}
+		total += count
+	}
+	if total == 0 {
+		fmt.Printf("no copies of the descriptor for range %d found in -stores=%s\n", raftID, *stores)
+	}
+}
+
+// formatReplicas formats replicas as a list of node/store pairs.
+func formatReplicas(replicas []proto.Replica) string {
+	var strs []string
+	for _, r := range replicas {
+		strs = append(strs, fmt.Sprintf("n%d/s%d", r.NodeID, r.StoreID))
+	}
+	return "[" + strings.Join(strs, " ") + "]"
+}
@@ -25,6 +25,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
@@ -47,13 +48,40 @@ import (
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metrics"
 )
 
 const staticDir = "./ui/"
 
+const (
+	// apiV1Prefix is the path prefix of version 1 of the HTTP API. Each
+	// endpoint is served both at its path under the prefix and, for
+	// compatibility with existing clients, at its unversioned path.
+	apiV1Prefix = "/api/v1"
+	// apiVersionHeader is the response header which reports the API
+	// version of responses to requests under a versioned prefix.
+	apiVersionHeader = "X-Cockroach-API-Version"
+)
+
+// advertiseDialTimeout bounds the attempt to connect to the node's own
+// advertised RPC address at startup.
+const advertiseDialTimeout = 5 * time.Second
+
 var (
-	rpcAddr  = flag.String("rpc", ":0", "host:port to bind for RPC traffic; 0 to pick unused port")
-	httpAddr = flag.String("http", ":8080", "host:port to bind for HTTP traffic; 0 to pick unused port")
+	rpcAddr = flag.String("rpc", ":0", "host:port to bind for RPC traffic; 0 to pick unused port. "+
+		"IPv6 hosts must be enclosed in brackets (e.g. [::1]:0); specify unix:<path> to bind "+
+		"to a unix domain socket")
+	httpAddr = flag.String("http", ":8080", "host:port to bind for HTTP traffic; 0 to pick unused port. "+
+		"IPv6 hosts must be enclosed in brackets (e.g. [::1]:8080); specify unix:<path> to bind "+
+		"to a unix domain socket for local clients")
+
+	// advertiseHost and advertisePort override the host and port of the
+	// RPC address which is advertised to other nodes via gossip.
+	advertiseHost = flag.String("advertise_host", "", "host advertised to other "+
+		"nodes for RPC traffic, if different from the -rpc host; use when the node "+
+		"is behind NAT or the bound address is otherwise unreachable by peers")
+	advertisePort = flag.String("advertise_port", "", "port advertised to other "+
+		"nodes for RPC traffic, if different from the -rpc port; use with port mapping")
 
 	certDir = flag.String("certs", "", "directory containing RSA key and x509 certs")
 
@@ -66,7 +94,11 @@ var (
 		"in-memory store. Device attributes typically include whether the store is "+
 		"flash (ssd), spinny disk (hdd), fusion-io (fio), in-memory (mem); device "+
 		"attributes might also include speeds and other specs (7200rpm, 200kiops, etc.). "+
-		"For example, -store=hdd:7200rpm=/mnt/hda1,ssd=/mnt/ssd01,ssd=/mnt/ssd02,mem=1073741824")
+		"For example, -store=hdd:7200rpm=/mnt/hda1,ssd=/mnt/ssd01,ssd=/mnt/ssd02,mem=1073741824. "+
+		"The filepath of a persistent store may be followed by semicolon-separated "+
+		"RocksDB options overriding the --cache_size and --rocksdb_* flags: cache "+
+		"(block cache size in bytes), bloom_bits, compression and max_open_files. "+
+		"For example, -stores=ssd=/mnt/ssd01;cache=2147483648;bloom_bits=10")
 
 	// attrs specifies node topography or machine capabilities, used to
 	// match capabilities or location preferences specified in zone configs.
@@ -83,9 +115,33 @@ var (
 		"of -max_drift, it will commit suicide. Setting this value too high may "+
 		"decrease transaction performance in the presence of contention.")
 
+	redactKeys = flag.Bool("redact_keys", false, "specify --redact_keys to "+
+		"redact user data from keys which appear in errors, logs and status "+
+		"pages. Structural key prefixes are preserved and the remainder of "+
+		"each key is replaced by its length and a checksum.")
+
 	bootstrapOnly = flag.Bool("bootstrap_only", false, "specify --bootstrap_only "+
 		"to avoid starting the server after bootstrapping with the init command.")
 
+	// TODO(spencer): allow toggling standby mode on a running node.
+	standby = flag.Bool("standby", false, "specify --standby to start the node in "+
+		"read-only standby mode, for maintenance windows and controlled failovers. "+
+		"The node keeps serving and replicating its existing ranges, but its stores "+
+		"are advertised as unavailable for new replicas.")
+
+	// metricsPushAddr, metricsPushProtocol and metricsPushPrefix
+	// configure pushing metrics to a Graphite or StatsD endpoint.
+	metricsPushAddr = flag.String("metrics_push_addr", "", "specify "+
+		"--metrics_push_addr=host:port to periodically push this node's "+
+		"metrics to a Graphite or StatsD endpoint, for monitoring systems "+
+		"which can't pull metrics from nodes directly.")
+	metricsPushProtocol = flag.String("metrics_push_protocol", metrics.Graphite, "protocol "+
+		"used to push metrics to --metrics_push_addr: either \"graphite\" "+
+		"(plaintext, over TCP) or \"statsd\" (gauges, over UDP).")
+	metricsPushPrefix = flag.String("metrics_push_prefix", "cockroach", "prefix "+
+		"of the names of metrics pushed to --metrics_push_addr. Use a prefix "+
+		"unique to each node (e.g. cockroach.node1) to keep nodes' metrics apart.")
+
 	// Regular expression for capturing data directory specifications.
 	storesRE = regexp.MustCompile(`([^=]+)=([^,]+)(,|$)`)
 
@@ -200,6 +256,7 @@ type server struct {
 	structuredDB   structured.DB
 	structuredREST *structured.RESTServer
 	httpListener   *net.Listener // holds http endpoint information
+	metricsPusher  *metrics.Pusher
 }
 
 // runStart starts the cockroach node using -stores as the list of
@@ -208,7 +265,8 @@ type server struct {
 // cluster via the gossip network.
 func runStart(cmd *commander.Command, args []string) {
 	log.Info("Starting cockroach cluster")
-	s, err := newServer(*rpcAddr, *certDir, *maxOffset)
+	proto.SetKeyRedaction(*redactKeys)
+	s, err := newServer(*rpcAddr, *advertiseHost, *advertisePort, *certDir, *maxOffset)
 	if err != nil {
 		log.Errorf("Failed to start Cockroach server: %v", err)
 		return
@@ -283,9 +341,26 @@ func initEngines(stores string) ([]engine.Engine, error) {
 // initEngine parses the store attributes as a colon-separated list
 // and instantiates an engine based on the dir parameter. If dir parses
 // to an integer, it's taken to mean an in-memory engine; otherwise,
-// dir is treated as a path and a RocksDB engine is created.
+// dir is treated as a path and a RocksDB engine is created. The path
+// may be followed by semicolon-separated name=value RocksDB options.
 func initEngine(attrsStr, path string) (engine.Engine, error) {
 	attrs := parseAttributes(attrsStr)
+	opts := engine.DefaultRocksDBOptions()
+	if parts := strings.Split(path, ";"); len(parts) > 1 {
+		path = parts[0]
+		if _, err := strconv.ParseUint(path, 10, 64); err == nil {
+			return nil, util.Errorf("RocksDB options may not be specified for an in-memory store")
+		}
+		for _, opt := range parts[1:] {
+			kv := strings.SplitN(opt, "=", 2)
+			if len(kv) != 2 {
+				return nil, util.Errorf("invalid RocksDB option %q; expected name=value", opt)
+			}
+			if err := opts.Set(kv[0], kv[1]); err != nil {
+				return nil, err
+			}
+		}
+	}
 	if size, err := strconv.ParseUint(path, 10, 64); err == nil {
 		if size == 0 {
 			return nil, util.Errorf("unable to initialize an in-memory store with capacity 0")
@@ -294,10 +369,16 @@ func initEngine(attrsStr, path string) (engine.Engine, error) {
 		// TODO(spencer): should be using rocksdb for in-memory stores and
 		// relegate the InMem engine to usage only from unittests.
 	}
-	return engine.NewRocksDB(attrs, path), nil
+	if len(path) == 0 {
+		return nil, util.Errorf("no path specified for store")
+	}
+	return engine.NewRocksDB(attrs, path, opts), nil
 }
 
-func newServer(rpcAddr, certDir string, maxOffset time.Duration) (*server, error) {
+// newServer allocates a server which binds RPC traffic to rpcAddr.
+// If set, advertiseHost and advertisePort replace the host and port
+// of the bound RPC address which is advertised to other nodes.
+func newServer(rpcAddr, advertiseHost, advertisePort, certDir string, maxOffset time.Duration) (*server, error) {
 	// Determine hostname in case it hasn't been specified in -rpc or -http.
 	host, err := os.Hostname()
 	if err != nil {
@@ -305,12 +386,21 @@ func newServer(rpcAddr, certDir string, maxOffset time.Duration) (*server, error
 	}
 
 	// If the specified rpc address includes no host component, use the hostname.
-	if strings.HasPrefix(rpcAddr, ":") {
-		rpcAddr = host + rpcAddr
-	}
-	_, err = net.ResolveTCPAddr("tcp", rpcAddr)
+	addr, err := util.ParseAddr(rpcAddr, host)
 	if err != nil {
-		return nil, util.Errorf("unable to resolve RPC address %q: %v", rpcAddr, err)
+		return nil, util.Errorf("unable to parse RPC address %q: %v", rpcAddr, err)
+	}
+	if addr.Network() == "tcp" {
+		if _, err := net.ResolveTCPAddr("tcp", addr.String()); err != nil {
+			return nil, util.Errorf("unable to resolve RPC address %q: %v", addr, err)
+		}
+	} else if advertiseHost != "" || advertisePort != "" {
+		return nil, util.Errorf("cannot advertise a host or port for %s RPC address %q", addr.Network(), addr)
+	}
+	if advertisePort != "" {
+		if _, err := strconv.ParseUint(advertisePort, 10, 16); err != nil {
+			return nil, util.Errorf("invalid advertised port %q", advertisePort)
+		}
 	}
 
 	var tlsConfig *rpc.TLSConfig
@@ -321,6 +411,17 @@ func newServer(rpcAddr, certDir string, maxOffset time.Duration) (*server, error
 		if tlsConfig, err = rpc.LoadTLSConfig(certDir); err != nil {
 			return nil, util.Errorf("unable to load TLS config: %v", err)
 		}
+		// Peers verify the node certificate against the RPC host; warn
+		// early if they will be unable to.
+		if addr.Network() == "tcp" {
+			rpcHost, _, _ := net.SplitHostPort(addr.String())
+			if advertiseHost != "" {
+				rpcHost = advertiseHost
+			}
+			if err := tlsConfig.VerifyHost(rpcHost); err != nil {
+				log.Warningf("node certificate is not valid for RPC host %q: %v", rpcHost, err)
+			}
+		}
 	}
 
 	s := &server{
@@ -333,7 +434,8 @@ func newServer(rpcAddr, certDir string, maxOffset time.Duration) (*server, error
 	rpcContext := rpc.NewContext(s.clock, tlsConfig)
 	go rpcContext.RemoteClocks.MonitorRemoteOffsets()
 
-	s.rpc = rpc.NewServer(util.MakeRawAddr("tcp", rpcAddr), rpcContext)
+	s.rpc = rpc.NewServer(addr, rpcContext)
+	s.rpc.SetAdvertise(advertiseHost, advertisePort)
 	s.gossip = gossip.New(rpcContext)
 
 	// Create a client.KVSender instance for use with this node's
@@ -342,11 +444,12 @@ func newServer(rpcAddr, certDir string, maxOffset time.Duration) (*server, error
 	s.kv = client.NewKV(sender, nil)
 	s.kv.User = storage.UserRoot
 
-	s.kvDB = kv.NewDBServer(sender)
+	s.kvDB = kv.NewDBServer(sender, s.clock)
 	s.kvREST = kv.NewRESTServer(s.kv)
 	s.node = NewNode(s.kv, s.gossip)
-	s.admin = newAdminServer(s.kv)
-	s.status = newStatusServer(s.kv, s.gossip)
+	s.node.standby = *standby
+	s.admin = newAdminServer(s.kv, s.clock)
+	s.status = newStatusServer(s.kv, s.gossip, s.node.lSender)
 	s.structuredDB = structured.NewDB(s.kv)
 	s.structuredREST = structured.NewRESTServer(s.structuredDB)
 
@@ -362,10 +465,14 @@ func (s *server) start(engines []engine.Engine, attrs, httpAddr string, selfBoot
 		return err
 	}
 	log.Infof("Started RPC server at %s", s.rpc.Addr())
+	if advertised := s.rpc.AdvertiseAddr(); advertised.String() != s.rpc.Addr().String() {
+		log.Infof("Advertising RPC address %s", advertised)
+		go verifyReachable(advertised)
+	}
 
 	// Handle self-bootstrapping case for a single node.
 	if selfBootstrap {
-		s.gossip.SetBootstrap([]net.Addr{s.rpc.Addr()})
+		s.gossip.SetBootstrap([]net.Addr{s.rpc.AdvertiseAddr()})
 	}
 	s.gossip.Start(s.rpc)
 	log.Infoln("Started gossip instance")
@@ -376,14 +483,26 @@ func (s *server) start(engines []engine.Engine, attrs, httpAddr string, selfBoot
 		return err
 	}
 
+	if *metricsPushAddr != "" {
+		pusher, err := metrics.NewPusher(*metricsPushProtocol, *metricsPushAddr, *metricsPushPrefix)
+		if err != nil {
+			return err
+		}
+		s.metricsPusher = pusher
+		s.metricsPusher.Start(metrics.Metrics)
+		metrics.Metrics.Start()
+		log.Infof("Pushing metrics to %s endpoint at %s", *metricsPushProtocol, *metricsPushAddr)
+	}
+
 	// TODO(spencer): add tls to the HTTP server.
 	s.initHTTP()
-	if strings.HasPrefix(httpAddr, ":") {
-		httpAddr = s.host + httpAddr
+	addr, err := util.ParseAddr(httpAddr, s.host)
+	if err != nil {
+		return util.Errorf("unable to parse HTTP address %q: %v", httpAddr, err)
 	}
-	ln, err := net.Listen("tcp", httpAddr)
+	ln, err := util.Listen(addr)
 	if err != nil {
-		return util.Errorf("could not listen on %s: %s", httpAddr, err)
+		return util.Errorf("could not listen on %s: %s", addr, err)
 	}
 	// Obtaining the http end point listener is difficult using
 	// http.ListenAndServe(), so we are storing it with the server.
@@ -393,6 +512,20 @@ func (s *server) start(engines []engine.Engine, attrs, httpAddr string, selfBoot
 	return nil
 }
 
+// verifyReachable attempts to connect to the node's advertised RPC
+// address, warning if it fails. Peers connect to the advertised
+// address, so an unreachable one usually indicates a misconfigured
+// -advertise_host or -advertise_port. This is only a warning because
+// some NAT setups don't allow a node to reach its own public address.
+func verifyReachable(addr net.Addr) {
+	conn, err := net.DialTimeout(addr.Network(), addr.String(), advertiseDialTimeout)
+	if err != nil {
+		log.Warningf("advertised RPC address %s is not reachable from this node: %v", addr, err)
+		return
+	}
+	conn.Close()
+}
+
 func (s *server) initHTTP() {
 	s.mux.Handle("/", http.FileServer(http.Dir(staticDir)))
 
@@ -405,9 +538,33 @@ func (s *server) initHTTP() {
 	s.mux.Handle(kv.RESTPrefix, s.kvREST)
 	s.mux.Handle(kv.DBPrefix, s.kvDB)
 	s.mux.Handle(structured.StructuredKeyPrefix, s.structuredREST)
+
+	// Versioned API endpoints.
+	s.mux.HandleFunc(apiV1Prefix+"/", s.handleAPIV1)
+}
+
+// handleAPIV1 serves requests under apiV1Prefix using the handler
+// registered for the remainder of the path. Static content is not part
+// of the API and is not served under the prefix.
+func (s *server) handleAPIV1(w http.ResponseWriter, r *http.Request) {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = strings.TrimPrefix(r.URL.Path, apiV1Prefix)
+	h, pattern := s.mux.Handler(r2)
+	if pattern == "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set(apiVersionHeader, "1")
+	h.ServeHTTP(w, r2)
 }
 
 func (s *server) stop() {
+	if s.metricsPusher != nil {
+		s.metricsPusher.Stop()
+	}
 	s.node.stop()
 	s.gossip.Stop()
 	s.rpc.Close()
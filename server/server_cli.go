@@ -23,22 +23,58 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
+	"strings"
 
 	commander "code.google.com/p/go-commander"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
-var addr = flag.String("addr", "127.0.0.1:8080", "address for connection to cockroach cluster")
+var addr = flag.String("addr", "127.0.0.1:8080", "address for connection to cockroach cluster; "+
+	"IPv6 hosts must be enclosed in brackets (e.g. [::1]:8080); specify unix:<path> to connect "+
+	"via a unix domain socket")
+
+// adminHost returns the host to use in admin request URLs. Requests
+// to a unix domain socket are addressed to localhost.
+func adminHost() string {
+	if strings.HasPrefix(*addr, util.UnixAddrPrefix) {
+		return "localhost"
+	}
+	return *addr
+}
+
+// adminClient returns the HTTP client used to send admin requests,
+// which dials the unix domain socket specified by -addr, if any.
+func adminClient() *http.Client {
+	if !strings.HasPrefix(*addr, util.UnixAddrPrefix) {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: adminTransport()}
+}
+
+// adminTransport returns the HTTP transport used to connect to the
+// cluster at -addr, dialing its unix domain socket, if any.
+func adminTransport() *http.Transport {
+	if !strings.HasPrefix(*addr, util.UnixAddrPrefix) {
+		return &http.Transport{}
+	}
+	path := strings.TrimPrefix(*addr, util.UnixAddrPrefix)
+	return &http.Transport{
+		Dial: func(_, _ string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		},
+	}
+}
 
 // sendAdminRequest send an HTTP request and processes the response for
 // its body or error message if a non-200 response code.
 func sendAdminRequest(req *http.Request) ([]byte, error) {
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := adminClient().Do(req)
 	if err != nil {
 		return nil, util.Errorf("admin REST request failed: %s", err)
 	}
@@ -74,7 +110,7 @@ func runGetConfig(prefix string, cmd *commander.Command, args []string) {
 		return
 	}
 	friendlyName := getFriendlyNameFromPrefix(prefix)
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s/%s", adminScheme, *addr, prefix, args[0]), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s/%s", adminScheme, adminHost(), prefix, args[0]), nil)
 	if err != nil {
 		log.Errorf("unable to create request to admin REST endpoint: %s", err)
 		return
@@ -100,7 +136,7 @@ func runLsConfigs(prefix string, cmd *commander.Command, args []string) {
 		return
 	}
 	friendlyName := getFriendlyNameFromPrefix(prefix)
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s", adminScheme, *addr, prefix), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s", adminScheme, adminHost(), prefix), nil)
 	if err != nil {
 		log.Errorf("unable to create request to admin REST endpoint: %s", err)
 		return
@@ -144,7 +180,7 @@ func runRmConfig(prefix string, cmd *commander.Command, args []string) {
 		return
 	}
 	friendlyName := getFriendlyNameFromPrefix(prefix)
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s://%s%s/%s", adminScheme, *addr, prefix, args[0]), nil)
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s://%s%s/%s", adminScheme, adminHost(), prefix, args[0]), nil)
 	if err != nil {
 		log.Errorf("unable to create request to admin REST endpoint: %s", err)
 		return
@@ -175,7 +211,7 @@ func runSetConfig(prefix string, cmd *commander.Command, args []string) {
 		return
 	}
 	// Send to admin REST API.
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s/%s", adminScheme, *addr, prefix, args[0]), bytes.NewReader(body))
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s/%s", adminScheme, adminHost(), prefix, args[0]), bytes.NewReader(body))
 	if err != nil {
 		log.Errorf("unable to create request to admin REST endpoint: %s", err)
 		return
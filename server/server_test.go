@@ -18,6 +18,7 @@
 package server
 
 import (
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io/ioutil"
@@ -32,6 +33,7 @@ import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -88,7 +90,7 @@ func resetTestData(dirs []string) {
 
 // TestInitEngine tests whether the data directory string is parsed correctly.
 func TestInitEngine(t *testing.T) {
-	tmp := createTempDirs(5, t)
+	tmp := createTempDirs(7, t)
 	defer resetTestData(tmp)
 
 	testCases := []struct {
@@ -104,6 +106,12 @@ func TestInitEngine(t *testing.T) {
 		{fmt.Sprintf("mem=%s", tmp[2]), proto.Attributes{Attrs: []string{"mem"}}, false, false},
 		{fmt.Sprintf("abc=%s", tmp[3]), proto.Attributes{Attrs: []string{"abc"}}, false, false},
 		{fmt.Sprintf("hdd:7200rpm=%s", tmp[4]), proto.Attributes{Attrs: []string{"hdd", "7200rpm"}}, false, false},
+		{fmt.Sprintf("ssd=%s;cache=1000;bloom_bits=10;compression=lz4;max_open_files=100", tmp[5]),
+			proto.Attributes{Attrs: []string{"ssd"}}, false, false},
+		{fmt.Sprintf("ssd=%s;compression=bogus", tmp[6]), proto.Attributes{}, true, false},
+		{fmt.Sprintf("ssd=%s;cache", tmp[6]), proto.Attributes{}, true, false},
+		{"mem=1000;cache=1000", proto.Attributes{}, true, false},
+		{"ssd=;cache=1000", proto.Attributes{}, true, false},
 		{"", proto.Attributes{}, true, false},
 		{"  ", proto.Attributes{}, true, false},
 		{"arbitrarystring", proto.Attributes{}, true, false},
@@ -237,6 +245,50 @@ func TestGzip(t *testing.T) {
 	}
 }
 
+// TestAPIV1Prefix verifies that endpoints are served under the
+// versioned API prefix, with responses encoded as requested by the
+// Accept header, and that static content is not.
+func TestAPIV1Prefix(t *testing.T) {
+	startServer(t)
+	testCases := []struct {
+		path, accept   string
+		expStatus      int
+		expContentType string
+	}{
+		{healthzPath, "", http.StatusOK, ""},
+		{statusDetailsKey, "", http.StatusOK, util.JSONContentType},
+		{statusDetailsKey, util.YAMLContentType, http.StatusOK, util.YAMLContentType},
+		{"/index.html", "", http.StatusNotFound, ""},
+	}
+	for i, test := range testCases {
+		req, err := http.NewRequest("GET", "http://"+s.HTTPAddr+apiV1Prefix+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.accept != "" {
+			req.Header.Set(util.AcceptHeader, test.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%d: could not make request to %s: %s", i, req.URL, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.expStatus {
+			t.Errorf("%d: expected status %d for %s; got %d", i, test.expStatus, req.URL, resp.StatusCode)
+			continue
+		}
+		if test.expStatus != http.StatusOK {
+			continue
+		}
+		if version := resp.Header.Get(apiVersionHeader); version != "1" {
+			t.Errorf("%d: expected API version 1; got %q", i, version)
+		}
+		if contentType := resp.Header.Get(util.ContentTypeHeader); test.expContentType != "" && contentType != test.expContentType {
+			t.Errorf("%d: expected content type %s; got %s", i, test.expContentType, contentType)
+		}
+	}
+}
+
 // TestMultiRangeScanDeleteRange tests that commands that commands which access
 // multiple ranges are carried out properly.
 func TestMultiRangeScanDeleteRange(t *testing.T) {
@@ -340,3 +392,81 @@ func TestMultiRangeScanDeleteRange(t *testing.T) {
 		t.Fatalf("scan after delete returned rows: %v", rows)
 	}
 }
+
+// TestServerRestartWithStickyEngine verifies that a TestServer
+// started with a sticky engine retains its data across a restart.
+func TestServerRestartWithStickyEngine(t *testing.T) {
+	const engineID = "restart"
+	defer engine.RemoveStickyInMem(engineID)
+
+	ts := &TestServer{StickyEngineID: engineID}
+	if err := ts.Start(); err != nil {
+		t.Fatal(err)
+	}
+	key, value := proto.Key("a"), []byte("value")
+	if err := ts.node.db.Call(proto.Put, proto.PutArgs(key, value), &proto.PutResponse{}); err != nil {
+		ts.Stop()
+		t.Fatal(err)
+	}
+	ts.Stop()
+
+	// Restart a new server on the same engine and verify the value.
+	ts = &TestServer{StickyEngineID: engineID}
+	if err := ts.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	reply := &proto.GetResponse{}
+	if err := ts.node.db.Call(proto.Get, proto.GetArgs(key), reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Value == nil || !bytes.Equal(reply.Value.Bytes, value) {
+		t.Errorf("expected value %q after restart; got %+v", value, reply.Value)
+	}
+}
+
+// TestServeHTTPUnixSocket verifies that the HTTP server may be bound
+// to a unix domain socket and that admin clients can reach it there.
+func TestServeHTTPUnixSocket(t *testing.T) {
+	path := util.CreateTestAddr("unix").String()
+	ts := &TestServer{HTTPAddr: util.UnixAddrPrefix + path}
+	if err := ts.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	if ts.HTTPAddr != path {
+		t.Errorf("expected HTTP server bound to %s; got %s", path, ts.HTTPAddr)
+	}
+
+	defer func(prev string) { *addr = prev }(*addr)
+	*addr = util.UnixAddrPrefix + path
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s", adminScheme, adminHost(), healthzPath), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sendAdminRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "ok") {
+		t.Errorf("expected healthz body to contain \"ok\"; got %q", b)
+	}
+}
+
+// TestNewServerAdvertise verifies validation of the advertised RPC
+// host and port and that they replace those of the bound address.
+func TestNewServerAdvertise(t *testing.T) {
+	srv, err := newServer("127.0.0.1:0", "public.example.com", "4321", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := srv.rpc.AdvertiseAddr().String(); addr != "public.example.com:4321" {
+		t.Errorf("expected advertised addr public.example.com:4321; got %s", addr)
+	}
+	if _, err := newServer("127.0.0.1:0", "", "http", "", 0); err == nil {
+		t.Error("expected error for invalid advertised port")
+	}
+	if _, err := newServer("unix:/tmp/cockroach.sock", "public.example.com", "", "", 0); err == nil {
+		t.Error("expected error advertising a host for a unix address")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// A CmdSplit command splits ranges at a list of keys.
+var CmdSplit = &commander.Command{
+	UsageLine: "split [options] <key> [<key>...]",
+	Short:     "split ranges at the specified keys",
+	Long: `
+Splits the ranges of the cluster at -addr so that each of the
+specified keys begins a new range, and prints the descriptors of the
+ranges to the left and right of each split. Use this to pre-split the
+keyspace at known boundaries before bulk loading data, so that the
+initial writes are spread across ranges instead of all landing in a
+single hot range. Exits with a non-zero status at the first split
+which fails.
+`,
+	Run:  runSplit,
+	Flag: *flag.CommandLine,
+}
+
+// runSplit splits at each of the distinct keys in args in turn,
+// printing the resulting range descriptors.
+func runSplit(cmd *commander.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	kv := client.NewKV(client.NewHTTPSender(adminHost(), adminTransport()), nil)
+	kv.User = storage.UserRoot
+	defer kv.Close()
+	seen := map[string]struct{}{}
+	for _, arg := range args {
+		if _, ok := seen[arg]; ok {
+			continue
+		}
+		seen[arg] = struct{}{}
+		left, right, err := kv.AdminSplitAt(proto.Key(arg))
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		fmt.Printf("split at %q\n", arg)
+		fmt.Printf("  left:  %s\n", formatRangeDescriptor(&left))
+		fmt.Printf("  right: %s\n", formatRangeDescriptor(&right))
+	}
+}
+
+// formatRangeDescriptor returns a one-line description of the range's
+// ID, key span, generation and replicas.
+func formatRangeDescriptor(desc *proto.RangeDescriptor) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "range %d [%q, %q) generation %d, replicas:",
+		desc.RaftID, desc.StartKey, desc.EndKey, desc.Generation)
+	for _, r := range desc.Replicas {
+		fmt.Fprintf(&buf, " n%d/s%d", r.NodeID, r.StoreID)
+	}
+	return buf.String()
+}
@@ -0,0 +1,41 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestFormatRangeDescriptor verifies the one-line description of a
+// range printed by the split command.
+func TestFormatRangeDescriptor(t *testing.T) {
+	desc := &proto.RangeDescriptor{
+		RaftID:   2,
+		StartKey: proto.Key("a"),
+		EndKey:   proto.Key("m"),
+		Replicas: []proto.Replica{
+			{NodeID: 1, StoreID: 1},
+			{NodeID: 2, StoreID: 3},
+		},
+		Generation: 4,
+	}
+	exp := `range 2 ["a", "m") generation 4, replicas: n1/s1 n2/s3`
+	if s := formatRangeDescriptor(desc); s != exp {
+		t.Errorf("expected %s; got %s", exp, s)
+	}
+}
@@ -18,23 +18,57 @@
 package server
 
 import (
-	"encoding/json"
+	"flag"
+	"fmt"
 	"net/http"
+	"os"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/gossip"
+	"github.com/cockroachdb/cockroach/kv"
+	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/server/status"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/build"
 	"github.com/cockroachdb/cockroach/util/log"
+	gogoproto "github.com/gogo/protobuf/proto"
 )
 
 const (
 	// stackTraceApproxSize is the approximate size of a goroutine stack trace.
 	stackTraceApproxSize = 1024
 
+	// rangeSizeMinBucket is the upper bound of the smallest bucket in
+	// the range size histogram. Each subsequent bucket doubles it.
+	rangeSizeMinBucket = 1 << 10
+
 	// statusKeyPrefix is the root of the RESTful cluster statistics and metrics API.
 	statusKeyPrefix = "/_status/"
 
+	// statusDetailsKey exposes the build and allow-listed flags and
+	// environment of the node serving the request.
+	statusDetailsKey = statusKeyPrefix + "details"
+
+	// statusClusterSummaryKey exposes cluster-wide totals composed from
+	// the store summaries gossiped by each node.
+	statusClusterSummaryKey = statusKeyPrefix + "cluster"
+
+	// staleSummaryAge is the age after which a gossiped store summary
+	// is reported as stale: it has missed a refresh.
+	staleSummaryAge = 2 * gossipInterval
+
+	// summaryTTL is the age after which a gossiped store summary is
+	// dropped, e.g. because its store or node has gone away.
+	summaryTTL = 5 * gossipInterval
+
 	// statusGossipKeyPrefix exposes a view of the gossip network.
 	statusGossipKeyPrefix = statusKeyPrefix + "gossip"
 
@@ -46,6 +80,27 @@ const (
 	// statusLocalStacksKey exposes stack traces of running goroutines.
 	statusLocalStacksKey = statusLocalKeyPrefix + "stacks"
 
+	// statusLocalRangeSizesKey exposes a histogram of the sizes of the
+	// ranges on the node's stores.
+	statusLocalRangeSizesKey = statusLocalKeyPrefix + "ranges/sizes"
+
+	// statusLocalRangeHeatMapKey exposes the size and QPS of each range
+	// on the node's stores, ordered by key.
+	statusLocalRangeHeatMapKey = statusLocalKeyPrefix + "ranges/heatmap"
+
+	// statusLocalRangeHotKeysKey exposes the most frequently addressed
+	// keys of the busiest ranges on the node's stores.
+	statusLocalRangeHotKeysKey = statusLocalKeyPrefix + "ranges/hotkeys"
+
+	// statusLocalRangeConstraintsKey exposes the ranges on the node's
+	// stores whose replicas violate their zone's constraints.
+	statusLocalRangeConstraintsKey = statusLocalKeyPrefix + "ranges/constraints"
+
+	// statusLocalRangeCorruptKey exposes the replicas on the node's
+	// stores which were marked corrupted. DELETE with a raft_id puts the
+	// range's replicas back into service.
+	statusLocalRangeCorruptKey = statusLocalKeyPrefix + "ranges/corrupt"
+
 	// statusNodesKeyPrefix exposes status for each of the nodes the cluster.
 	// GETing statusNodesKeyPrefix will list all nodes.
 	// Individual node status can be queried at statusNodesKeyPrefix/NodeID.
@@ -58,27 +113,214 @@ const (
 	statusTransactionsKeyPrefix = statusKeyPrefix + "txns/"
 )
 
+// statusWithheldFlags lists the command line flags withheld from the
+// details endpoint, which reports all other flags except those of the
+// testing package. Any flag naming paths, external endpoints or
+// anything which may hold credentials must be added here.
+var statusWithheldFlags = []string{
+	"certs",             // certificate directory
+	"gossip",            // addresses of gossip bootstrap hosts
+	"gossip_srv",        // DNS record naming gossip bootstrap hosts
+	"log_dir",           // log file directory
+	"metrics_push_addr", // address of the external metrics collector
+	"stores",            // store directories
+}
+
+// statusEnvVars lists the environment variables reported by the
+// details endpoint.
+var statusEnvVars = []string{"GOGC", "GODEBUG", "GOMAXPROCS", "GOTRACEBACK"}
+
 // A statusServer provides a RESTful status API.
 type statusServer struct {
 	db     *client.KV
 	gossip *gossip.Gossip
+	stores *kv.LocalSender // Stores local to the node
+
+	mu        sync.Mutex                      // Protects builds and summaries
+	builds    map[int32]string                // Node ID -> build, learned via gossip
+	summaries map[string]receivedStoreSummary // Gossip key -> store summary
+}
+
+// A receivedStoreSummary is a gossiped store summary together with the
+// local wall time in nanoseconds at which it was last received. Ages
+// are measured from the time of receipt, as the summary's own
+// timestamp was taken by the remote node's clock.
+type receivedStoreSummary struct {
+	storage.StoreSummary
+	received int64
 }
 
 // newStatusServer allocates and returns a statusServer.
-func newStatusServer(db *client.KV, gossip *gossip.Gossip) *statusServer {
-	return &statusServer{
-		db:     db,
-		gossip: gossip,
+func newStatusServer(db *client.KV, g *gossip.Gossip, stores *kv.LocalSender) *statusServer {
+	s := &statusServer{
+		db:        db,
+		gossip:    g,
+		stores:    stores,
+		builds:    map[int32]string{},
+		summaries: map[string]receivedStoreSummary{},
+	}
+	if g != nil {
+		g.RegisterCallback("^"+gossip.KeyBuildPrefix, s.updateBuild)
+		g.RegisterCallback("^"+gossip.KeyStoreSummaryPrefix, s.updateStoreSummary)
+	}
+	return s
+}
+
+// updateBuild is a gossip callback which records the build a node is
+// running.
+func (s *statusServer) updateBuild(key string, contentsChanged bool) {
+	nodeID, err := strconv.ParseInt(strings.TrimPrefix(key, gossip.KeyBuildPrefix), 16, 32)
+	if err != nil {
+		log.Errorf("unable to parse node ID from gossip key %q: %v", key, err)
+		return
+	}
+	val, err := s.gossip.GetInfo(key)
+	if err != nil {
+		log.Errorf("unable to fetch build for node %d: %v", nodeID, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.builds[int32(nodeID)] = val.(string)
+}
+
+// updateStoreSummary is a gossip callback which records the latest
+// summary of a store and the time it was received.
+func (s *statusServer) updateStoreSummary(key string, contentsChanged bool) {
+	val, err := s.gossip.GetInfo(key)
+	if err != nil {
+		log.Errorf("unable to fetch store summary %q: %v", key, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summaries[key] = receivedStoreSummary{
+		StoreSummary: val.(storage.StoreSummary),
+		received:     time.Now().UnixNano(),
+	}
+}
+
+// clusterSummary composes cluster-wide totals from the latest store
+// summaries. now is the current wall time in nanoseconds, against
+// which the time since each summary was received is measured.
+// Summaries older than summaryTTL are dropped.
+func (s *statusServer) clusterSummary(now int64) *status.ClusterSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cluster := &status.ClusterSummary{Stores: []status.StoreSummary{}}
+	nodes := map[int32]struct{}{}
+	for key, summary := range s.summaries {
+		age := time.Duration(now - summary.received)
+		if age > summaryTTL {
+			delete(s.summaries, key)
+			continue
+		}
+		store := status.StoreSummary{
+			NodeID:     summary.NodeID,
+			StoreID:    summary.StoreID,
+			RangeCount: summary.RangeCount,
+			Bytes:      summary.Bytes,
+			QPS:        summary.QPS,
+			AgeSeconds: age.Seconds(),
+			Stale:      age > staleSummaryAge,
+		}
+		nodes[store.NodeID] = struct{}{}
+		cluster.RangeCount += store.RangeCount
+		cluster.Bytes += store.Bytes
+		cluster.QPS += store.QPS
+		if store.Stale {
+			cluster.StaleStores++
+		}
+		cluster.Stores = append(cluster.Stores, store)
+	}
+	cluster.NodeCount = len(nodes)
+	cluster.StoreCount = len(cluster.Stores)
+	sort.Sort(storeSummariesByID(cluster.Stores))
+	return cluster
+}
+
+// storeSummariesByID implements sort.Interface, ordering by node ID
+// and then by store ID.
+type storeSummariesByID []status.StoreSummary
+
+func (s storeSummariesByID) Len() int      { return len(s) }
+func (s storeSummariesByID) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s storeSummariesByID) Less(i, j int) bool {
+	if s[i].NodeID != s[j].NodeID {
+		return s[i].NodeID < s[j].NodeID
+	}
+	return s[i].StoreID < s[j].StoreID
+}
+
+// clusterStatus returns the cluster status roll-up, including a
+// warning if nodes are running different builds.
+func (s *statusServer) clusterStatus() *status.Cluster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cluster := &status.Cluster{}
+	if len(s.builds) == 0 {
+		return cluster
+	}
+	cluster.Builds = map[string]string{}
+	nodesByBuild := map[string][]int{}
+	for nodeID, b := range s.builds {
+		cluster.Builds[strconv.Itoa(int(nodeID))] = b
+		nodesByBuild[b] = append(nodesByBuild[b], int(nodeID))
+	}
+	if len(nodesByBuild) > 1 {
+		var descs []string
+		for b, nodeIDs := range nodesByBuild {
+			sort.Ints(nodeIDs)
+			descs = append(descs, fmt.Sprintf("%s on nodes %v", b, nodeIDs))
+		}
+		sort.Strings(descs)
+		cluster.Warnings = append(cluster.Warnings,
+			"nodes are running mismatched builds: "+strings.Join(descs, "; "))
 	}
+	return cluster
+}
+
+// nodeDetails returns the build of this node along with its flags,
+// less those named by statusWithheldFlags, and the environment
+// variables named by statusEnvVars.
+func nodeDetails() *status.Details {
+	details := &status.Details{
+		Build:       build.GetInfo(),
+		Flags:       map[string]string{},
+		Environment: map[string]string{},
+	}
+	withheld := map[string]struct{}{}
+	for _, name := range statusWithheldFlags {
+		withheld[name] = struct{}{}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := withheld[f.Name]; ok || strings.HasPrefix(f.Name, "test.") {
+			return
+		}
+		details.Flags[f.Name] = f.Value.String()
+	})
+	for _, name := range statusEnvVars {
+		if val := os.Getenv(name); val != "" {
+			details.Environment[name] = val
+		}
+	}
+	return details
 }
 
 // RegisterHandlers registers admin handlers with the supplied
 // serve mux.
 func (s *statusServer) RegisterHandlers(mux *http.ServeMux) {
 	mux.HandleFunc(statusKeyPrefix, s.handleStatus)
+	mux.HandleFunc(statusDetailsKey, s.handleDetails)
+	mux.HandleFunc(statusClusterSummaryKey, s.handleClusterSummary)
 	mux.HandleFunc(statusGossipKeyPrefix, s.handleGossipStatus)
 	mux.HandleFunc(statusLocalKeyPrefix, s.handleLocalStatus)
 	mux.HandleFunc(statusLocalStacksKey, s.handleLocalStacks)
+	mux.HandleFunc(statusLocalRangeSizesKey, s.handleLocalRangeSizes)
+	mux.HandleFunc(statusLocalRangeHeatMapKey, s.handleLocalRangeHeatMap)
+	mux.HandleFunc(statusLocalRangeHotKeysKey, s.handleLocalRangeHotKeys)
+	mux.HandleFunc(statusLocalRangeConstraintsKey, s.handleLocalRangeConstraints)
+	mux.HandleFunc(statusLocalRangeCorruptKey, s.handleLocalRangeCorrupt)
 	mux.HandleFunc(statusNodesKeyPrefix, s.handleNodeStatus)
 	mux.HandleFunc(statusStoresKeyPrefix, s.handleStoresStatus)
 	mux.HandleFunc(statusTransactionsKeyPrefix, s.handleTransactionStatus)
@@ -86,21 +328,36 @@ func (s *statusServer) RegisterHandlers(mux *http.ServeMux) {
 
 // TODO(shawn) lots of implementing - setting up a skeleton for hack week.
 
-// handleStatus handles GET requests for cluster status.
-func (s *statusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	cluster := &status.Cluster{}
-
-	b, err := json.Marshal(cluster)
+// writeResponse marshals value using the encoding negotiated from the
+// request's Accept and Content-Type headers and writes it to w.
+func writeResponse(w http.ResponseWriter, r *http.Request, value interface{}) {
+	b, contentType, err := util.MarshalResponse(r, value, util.AllEncodings)
 	if err != nil {
 		log.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set(util.ContentTypeHeader, contentType)
 	w.Write(b)
 }
 
+// handleStatus handles GET requests for cluster status.
+func (s *statusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, s.clusterStatus())
+}
+
+// handleDetails handles GET requests for the build, flags and
+// environment of this node.
+func (s *statusServer) handleDetails(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, nodeDetails())
+}
+
+// handleClusterSummary handles GET requests for the cluster-wide
+// totals composed from gossiped store summaries.
+func (s *statusServer) handleClusterSummary(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, s.clusterSummary(time.Now().UnixNano()))
+}
+
 // handleGossipStatus handles GET requests for gossip network status.
 func (s *statusServer) handleGossipStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -114,9 +371,7 @@ func (s *statusServer) handleGossipStatus(w http.ResponseWriter, r *http.Request
 
 // handleLocalStatus handles GET requests for local-node status.
 func (s *statusServer) handleLocalStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	w.Write([]byte(`{}`))
+	writeResponse(w, r, struct{}{})
 }
 
 // handleLocalStacks handles GET requests for goroutines stack traces.
@@ -137,33 +392,356 @@ func (s *statusServer) handleLocalStacks(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-// handleNodeStatus handles GET requests for node status.
-func (s *statusServer) handleNodeStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// localRangeHeat returns the size and QPS of each range on the node's
+// stores, ordered by start key.
+func (s *statusServer) localRangeHeat() ([]status.RangeHeat, error) {
+	var ranges rangeHeatByKey
+	err := s.stores.VisitStores(func(store *storage.Store) error {
+		return store.VisitRanges(func(rng *storage.Range) error {
+			desc := rng.Descriptor()
+			size, err := engine.GetRangeSize(store.Engine(), desc.RaftID)
+			if err != nil {
+				return err
+			}
+			ranges = append(ranges, rangeHeat{
+				startKey: desc.StartKey,
+				RangeHeat: status.RangeHeat{
+					RaftID:   desc.RaftID,
+					StoreID:  store.StoreID(),
+					StartKey: desc.StartKey.String(),
+					EndKey:   desc.EndKey.String(),
+					Bytes:    size,
+					QPS:      rng.QPS(),
+				},
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Ranges from multiple stores are interleaved; order by key and
+	// then by store so that replicas of a range appear together.
+	sort.Sort(ranges)
+	heat := make([]status.RangeHeat, len(ranges))
+	for i := range ranges {
+		heat[i] = ranges[i].RangeHeat
+	}
+	return heat, nil
+}
 
-	// TODO(shawn) parse node-id in path
+// rangeHeat pairs a status.RangeHeat with the range's unformatted
+// start key for sorting.
+type rangeHeat struct {
+	startKey proto.Key
+	status.RangeHeat
+}
+
+// rangeHeatByKey implements sort.Interface, ordering by start key
+// and then by store ID.
+type rangeHeatByKey []rangeHeat
+
+func (r rangeHeatByKey) Len() int      { return len(r) }
+func (r rangeHeatByKey) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r rangeHeatByKey) Less(i, j int) bool {
+	if !r[i].startKey.Equal(r[j].startKey) {
+		return r[i].startKey.Less(r[j].startKey)
+	}
+	return r[i].StoreID < r[j].StoreID
+}
+
+// newRangeSizeHistogram buckets the supplied range sizes into
+// power-of-two buckets, starting at rangeSizeMinBucket and ending with
+// the smallest bucket which holds the largest range. Empty buckets in
+// between are included so the histogram can be plotted directly.
+func newRangeSizeHistogram(sizes []int64) *status.RangeSizeHistogram {
+	h := &status.RangeSizeHistogram{Count: len(sizes)}
+	for _, size := range sizes {
+		h.TotalBytes += size
+		i := 0
+		for max := int64(rangeSizeMinBucket); size > max; max *= 2 {
+			i++
+		}
+		for len(h.Buckets) <= i {
+			h.Buckets = append(h.Buckets, status.RangeSizeBucket{
+				MaxBytes: rangeSizeMinBucket << uint(len(h.Buckets)),
+			})
+		}
+		h.Buckets[i].Count++
+	}
+	return h
+}
 
-	nodes := &status.NodeList{}
+// handleLocalRangeSizes handles GET requests for a histogram of the
+// sizes of ranges on this node.
+func (s *statusServer) handleLocalRangeSizes(w http.ResponseWriter, r *http.Request) {
+	heat, err := s.localRangeHeat()
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sizes := make([]int64, len(heat))
+	for i := range heat {
+		sizes[i] = heat[i].Bytes
+	}
+	writeResponse(w, r, newRangeSizeHistogram(sizes))
+}
 
-	b, err := json.Marshal(nodes)
+// handleLocalRangeHeatMap handles GET requests for the size and QPS of
+// each range on this node, ordered by key.
+func (s *statusServer) handleLocalRangeHeatMap(w http.ResponseWriter, r *http.Request) {
+	heat, err := s.localRangeHeat()
 	if err != nil {
 		log.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	w.Write(b)
+	writeResponse(w, r, &status.RangeHeatMap{Ranges: heat})
+}
+
+// defaultHotKeys is the number of hot keys reported per range unless
+// otherwise specified.
+const defaultHotKeys = 10
+
+// localRangeHotKeys returns up to k hot keys for each range on the
+// node's stores which has received commands, ordered by descending
+// QPS. If raftID is non-zero, only that range is included.
+func (s *statusServer) localRangeHotKeys(raftID int64, k int) ([]status.RangeHotKeys, error) {
+	ranges := []status.RangeHotKeys{}
+	err := s.stores.VisitStores(func(store *storage.Store) error {
+		return store.VisitRanges(func(rng *storage.Range) error {
+			desc := rng.Descriptor()
+			if raftID != 0 && desc.RaftID != raftID {
+				return nil
+			}
+			samples := rng.HotKeys(k)
+			if len(samples) == 0 {
+				return nil
+			}
+			hot := status.RangeHotKeys{
+				RaftID:   desc.RaftID,
+				StoreID:  store.StoreID(),
+				StartKey: desc.StartKey.String(),
+				EndKey:   desc.EndKey.String(),
+				QPS:      rng.QPS(),
+			}
+			for _, sample := range samples {
+				hot.Keys = append(hot.Keys, status.HotKey{
+					Key:      sample.Key.String(),
+					Fraction: sample.Fraction,
+					QPS:      sample.Fraction * hot.QPS,
+				})
+			}
+			ranges = append(ranges, hot)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(rangeHotKeysByQPS(ranges))
+	return ranges, nil
+}
+
+// rangeHotKeysByQPS implements sort.Interface, ordering by descending
+// QPS.
+type rangeHotKeysByQPS []status.RangeHotKeys
+
+func (r rangeHotKeysByQPS) Len() int           { return len(r) }
+func (r rangeHotKeysByQPS) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r rangeHotKeysByQPS) Less(i, j int) bool { return r[i].QPS > r[j].QPS }
+
+// handleLocalRangeHotKeys handles GET requests for the hot keys of
+// ranges on this node. The optional "raft_id" query parameter selects
+// a single range and "k" sets the number of keys reported per range.
+func (s *statusServer) handleLocalRangeHotKeys(w http.ResponseWriter, r *http.Request) {
+	var raftID int64
+	k := defaultHotKeys
+	if v := r.URL.Query().Get("raft_id"); v != "" {
+		var err error
+		if raftID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid raft_id: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("k"); v != "" {
+		var err error
+		if k, err = strconv.Atoi(v); err != nil || k <= 0 {
+			http.Error(w, fmt.Sprintf("invalid k: %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	ranges, err := s.localRangeHotKeys(raftID, k)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, &status.RangeHotKeysList{Ranges: ranges})
+}
+
+// loadZoneConfigs scans the zone configs and returns them as a
+// PrefixConfigMap.
+func (s *statusServer) loadZoneConfigs() (storage.PrefixConfigMap, error) {
+	sr := &proto.ScanResponse{}
+	if err := s.db.Call(proto.Scan, &proto.ScanRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:    engine.KeyConfigZonePrefix,
+			EndKey: engine.KeyConfigZonePrefix.PrefixEnd(),
+			User:   storage.UserRoot,
+		},
+	}, sr); err != nil {
+		return nil, err
+	}
+	var configs []*storage.PrefixConfig
+	for _, kv := range sr.Rows {
+		config := &proto.ZoneConfig{}
+		if err := gogoproto.Unmarshal(kv.Value.Bytes, config); err != nil {
+			return nil, err
+		}
+		configs = append(configs, &storage.PrefixConfig{
+			Prefix: kv.Key[len(engine.KeyConfigZonePrefix):],
+			Config: config,
+		})
+	}
+	return storage.NewPrefixConfigMap(configs)
+}
+
+// localRangeConstraintViolations checks the replicas of each range on
+// the node's stores against the constraints of the range's zone.
+func (s *statusServer) localRangeConstraintViolations() ([]status.ConstraintViolation, error) {
+	zones, err := s.loadZoneConfigs()
+	if err != nil {
+		return nil, err
+	}
+	violations := []status.ConstraintViolation{}
+	err = s.stores.VisitStores(func(store *storage.Store) error {
+		return store.VisitRanges(func(rng *storage.Range) error {
+			desc := rng.Descriptor()
+			violation := status.ConstraintViolation{
+				RaftID:   desc.RaftID,
+				StoreID:  store.StoreID(),
+				StartKey: desc.StartKey.String(),
+				EndKey:   desc.EndKey.String(),
+			}
+			zone := zones.MatchByPrefix(engine.RangeConfigKey(desc.StartKey)).Config.(*proto.ZoneConfig)
+			constraints, err := zone.ReplicaConstraints()
+			if err != nil {
+				violation.Error = err.Error()
+				violations = append(violations, violation)
+				return nil
+			}
+			for _, c := range storage.UnsatisfiedConstraints(desc.Replicas, constraints) {
+				violation.Constraints = c.String()
+				violations = append(violations, violation)
+			}
+			return nil
+		})
+	})
+	return violations, err
+}
+
+// handleLocalRangeConstraints handles GET requests for the ranges on
+// this node whose replicas can't satisfy their zone's constraints.
+func (s *statusServer) handleLocalRangeConstraints(w http.ResponseWriter, r *http.Request) {
+	violations, err := s.localRangeConstraintViolations()
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, &status.ConstraintViolations{Violations: violations})
+}
+
+// localCorruptReplicas returns the replicas on the node's stores which
+// were marked corrupted.
+func (s *statusServer) localCorruptReplicas() ([]status.CorruptReplica, error) {
+	replicas := []status.CorruptReplica{}
+	err := s.stores.VisitStores(func(store *storage.Store) error {
+		return store.VisitRanges(func(rng *storage.Range) error {
+			if cErr := rng.Corruption(); cErr != nil {
+				desc := rng.Descriptor()
+				replicas = append(replicas, status.CorruptReplica{
+					RaftID:   desc.RaftID,
+					StoreID:  store.StoreID(),
+					StartKey: desc.StartKey.String(),
+					EndKey:   desc.EndKey.String(),
+					Error:    cErr.ErrorMsg,
+				})
+			}
+			return nil
+		})
+	})
+	return replicas, err
+}
+
+// clearLocalCorruption puts the replicas of the range with the
+// specified Raft ID on the node's stores back into service and returns
+// how many were marked corrupted.
+func (s *statusServer) clearLocalCorruption(raftID int64) (int, error) {
+	cleared := 0
+	err := s.stores.VisitStores(func(store *storage.Store) error {
+		if _, err := store.GetRange(raftID); err != nil {
+			return nil
+		}
+		if err := store.ClearReplicaCorruption(raftID); err == nil {
+			cleared++
+		}
+		return nil
+	})
+	return cleared, err
+}
+
+// handleLocalRangeCorrupt handles GET requests for the replicas on this
+// node which were marked corrupted, and DELETE requests putting the
+// replicas of the range selected by the "raft_id" query parameter back
+// into service.
+func (s *statusServer) handleLocalRangeCorrupt(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		replicas, err := s.localCorruptReplicas()
+		if err != nil {
+			log.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeResponse(w, r, &status.CorruptReplicas{Replicas: replicas})
+	case "DELETE":
+		raftID, err := strconv.ParseInt(r.URL.Query().Get("raft_id"), 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid raft_id: %s", err), http.StatusBadRequest)
+			return
+		}
+		cleared, err := s.clearLocalCorruption(raftID)
+		if err != nil {
+			log.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if cleared == 0 {
+			http.Error(w, fmt.Sprintf("no corrupted replica of range %d on this node", raftID), http.StatusNotFound)
+			return
+		}
+		log.Infof("put %d corrupted replica(s) of range %d back into service", cleared, raftID)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleNodeStatus handles GET requests for node status.
+func (s *statusServer) handleNodeStatus(w http.ResponseWriter, r *http.Request) {
+	// TODO(shawn) parse node-id in path
+
+	writeResponse(w, r, &status.NodeList{})
 }
 
 // handleStoresStatus handles GET requests for store status.
 func (s *statusServer) handleStoresStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	w.Write([]byte(`{"stores": []}`))
+	writeResponse(w, r, map[string][]interface{}{"stores": {}})
 }
 
 // handleTransactionStatus handles GET requests for transaction status.
 func (s *statusServer) handleTransactionStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	w.Write([]byte(`{"transactions": []}`))
+	writeResponse(w, r, map[string][]interface{}{"transactions": {}})
 }
@@ -18,8 +18,17 @@
 // Package status defines the data types of cluster-wide and per-node status responses.
 package status
 
+import "github.com/cockroachdb/cockroach/util/build"
+
 // A Cluster that contains nodes.
-type Cluster struct{}
+type Cluster struct {
+	// Builds maps node IDs to the build each node is running, as
+	// learned via gossip.
+	Builds map[string]string `json:"builds,omitempty"`
+	// Warnings lists problems detected across the cluster, such as
+	// nodes running mismatched builds.
+	Warnings []string `json:"warnings,omitempty"`
+}
 
 // NodeList contains a slice of summaries for each Node.
 type NodeList struct {
@@ -34,3 +43,130 @@ type NodeSummary struct {
 
 // Node represents an individual node within the cluster.
 type Node struct{}
+
+// Details describes the binary and configuration of a node: its
+// build and the subset of its command line flags and environment
+// variables which are safe to expose.
+type Details struct {
+	Build       build.Info        `json:"build"`
+	Flags       map[string]string `json:"flags"`
+	Environment map[string]string `json:"environment"`
+}
+
+// A RangeSizeHistogram describes the distribution of range sizes on a
+// node. Buckets are sorted by increasing size; a range falls into the
+// first bucket whose MaxBytes is at least the range's size.
+type RangeSizeHistogram struct {
+	Count      int               `json:"count"`
+	TotalBytes int64             `json:"total_bytes"`
+	Buckets    []RangeSizeBucket `json:"buckets"`
+}
+
+// A RangeSizeBucket counts the ranges no larger than MaxBytes which
+// didn't fit into a smaller bucket.
+type RangeSizeBucket struct {
+	MaxBytes int64 `json:"max_bytes"`
+	Count    int   `json:"count"`
+}
+
+// A RangeHeatMap lists the size and request rate of each range on a
+// node, ordered by start key, so that hot spots and skew in the
+// keyspace are easy to pick out.
+type RangeHeatMap struct {
+	Ranges []RangeHeat `json:"ranges"`
+}
+
+// RangeHeat holds the size and request rate of a single range.
+type RangeHeat struct {
+	RaftID   int64   `json:"raft_id"`
+	StoreID  int32   `json:"store_id"`
+	StartKey string  `json:"start_key"`
+	EndKey   string  `json:"end_key"`
+	Bytes    int64   `json:"bytes"`
+	QPS      float64 `json:"qps"`
+}
+
+// A RangeHotKeysList lists the most frequently addressed keys of the
+// ranges on a node, busiest range first, to pin down the keys behind
+// a hot range.
+type RangeHotKeysList struct {
+	Ranges []RangeHotKeys `json:"ranges"`
+}
+
+// RangeHotKeys holds the request rate of a single range together with
+// its hottest keys, as estimated by sampling the keys of requests.
+type RangeHotKeys struct {
+	RaftID   int64    `json:"raft_id"`
+	StoreID  int32    `json:"store_id"`
+	StartKey string   `json:"start_key"`
+	EndKey   string   `json:"end_key"`
+	QPS      float64  `json:"qps"`
+	Keys     []HotKey `json:"keys"`
+}
+
+// HotKey holds a key's estimated share of the requests to its range
+// and the corresponding request rate.
+type HotKey struct {
+	Key      string  `json:"key"`
+	Fraction float64 `json:"fraction"`
+	QPS      float64 `json:"qps"`
+}
+
+// ConstraintViolations lists the ranges whose replicas don't satisfy
+// the constraints of their zone configs.
+type ConstraintViolations struct {
+	Violations []ConstraintViolation `json:"violations"`
+}
+
+// ConstraintViolation describes a single set of replica constraints
+// which no replica of a range satisfies.
+type ConstraintViolation struct {
+	RaftID      int64  `json:"raft_id"`
+	StoreID     int32  `json:"store_id"`
+	StartKey    string `json:"start_key"`
+	EndKey      string `json:"end_key"`
+	Constraints string `json:"constraints"`
+	Error       string `json:"error,omitempty"`
+}
+
+// CorruptReplicas lists the replicas which were marked corrupted and
+// have stopped serving.
+type CorruptReplicas struct {
+	Replicas []CorruptReplica `json:"replicas"`
+}
+
+// CorruptReplica describes a replica which was marked corrupted.
+type CorruptReplica struct {
+	RaftID   int64  `json:"raft_id"`
+	StoreID  int32  `json:"store_id"`
+	StartKey string `json:"start_key"`
+	EndKey   string `json:"end_key"`
+	Error    string `json:"error"`
+}
+
+// A ClusterSummary holds cluster-wide totals composed from the
+// summaries each store gossips, so that they are available without
+// querying every node. The summaries are gossiped periodically; those
+// which haven't been refreshed recently are marked stale, but are
+// still included in the totals until they expire.
+type ClusterSummary struct {
+	NodeCount   int            `json:"node_count"`
+	StoreCount  int            `json:"store_count"`
+	RangeCount  int            `json:"range_count"`
+	Bytes       int64          `json:"bytes"`
+	QPS         float64        `json:"qps"`
+	StaleStores int            `json:"stale_stores"`
+	Stores      []StoreSummary `json:"stores"`
+}
+
+// A StoreSummary holds the totals gossiped by a single store and the
+// time since the summary was last received.
+type StoreSummary struct {
+	NodeID     int32   `json:"node_id"`
+	StoreID    int32   `json:"store_id"`
+	RangeCount int     `json:"range_count"`
+	Bytes      int64   `json:"bytes"`
+	QPS        float64 `json:"qps"`
+	AgeSeconds float64 `json:"age_seconds"`
+	Stale      bool    `json:"stale"`
+}
@@ -18,13 +18,24 @@
 package server
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
 	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/kv"
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/server/status"
+	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -37,7 +48,7 @@ func startStatusServer() *httptest.Server {
 	if err != nil {
 		log.Fatal(err)
 	}
-	status := newStatusServer(db, nil)
+	status := newStatusServer(db, nil, nil)
 	mux := http.NewServeMux()
 	status.RegisterHandlers(mux)
 	httpServer := httptest.NewServer(mux)
@@ -57,3 +68,369 @@ func TestStatusStacks(t *testing.T) {
 		t.Errorf("expected match: %t; err nil: %v", matches, err)
 	}
 }
+
+// startRangeStatusServer launches a status server backed by a single
+// bootstrapped in-memory store. Returns the http test server and the
+// store; the caller should close and stop them respectively.
+func startRangeStatusServer(t *testing.T) (*httptest.Server, *storage.Store) {
+	clock := hlc.NewClock(hlc.UnixNano)
+	lSender := kv.NewLocalSender()
+	db := client.NewKV(kv.NewTxnCoordSender(lSender, clock), nil)
+	store := storage.NewStore(clock, engine.NewInMem(proto.Attributes{}, 1<<20), db, nil)
+	if err := store.Bootstrap(proto.StoreIdent{NodeID: 1, StoreID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.BootstrapRange(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	lSender.AddStore(store)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Call(proto.Put, proto.PutArgs(proto.Key(key), []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	newStatusServer(db, nil, lSender).RegisterHandlers(mux)
+	return httptest.NewServer(mux), store
+}
+
+// TestStatusRangeHeatMap verifies that the size and QPS of each range
+// are available via the heat map endpoint.
+func TestStatusRangeHeatMap(t *testing.T) {
+	s, store := startRangeStatusServer(t)
+	defer s.Close()
+	defer store.Stop()
+
+	body, err := getText(s.URL + statusLocalRangeHeatMapKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heatMap := status.RangeHeatMap{}
+	if err := json.Unmarshal(body, &heatMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(heatMap.Ranges) != 1 {
+		t.Fatalf("expected one range; got %+v", heatMap)
+	}
+	heat := heatMap.Ranges[0]
+	if heat.RaftID != 1 || heat.StoreID != 1 {
+		t.Errorf("unexpected range/store IDs: %+v", heat)
+	}
+	if heat.StartKey != engine.KeyMin.String() || heat.EndKey != engine.KeyMax.String() {
+		t.Errorf("expected range to span keyspace; got %+v", heat)
+	}
+	if heat.Bytes <= 0 {
+		t.Errorf("expected positive range size; got %+v", heat)
+	}
+	if heat.QPS <= 0 {
+		t.Errorf("expected positive QPS; got %+v", heat)
+	}
+}
+
+// TestStatusRangeHotKeys verifies that the most frequently addressed
+// keys of a range are available via the hot keys endpoint.
+func TestStatusRangeHotKeys(t *testing.T) {
+	s, store := startRangeStatusServer(t)
+	defer s.Close()
+	defer store.Stop()
+
+	hotKey := proto.Key("hot")
+	for i := 0; i < 200; i++ {
+		if err := store.DB().Call(proto.Put, proto.PutArgs(hotKey, []byte("value")), &proto.PutResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body, err := getText(s.URL + statusLocalRangeHotKeysKey + "?raft_id=1&k=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hotKeys := status.RangeHotKeysList{}
+	if err := json.Unmarshal(body, &hotKeys); err != nil {
+		t.Fatal(err)
+	}
+	if len(hotKeys.Ranges) != 1 {
+		t.Fatalf("expected one range; got %+v", hotKeys)
+	}
+	rng := hotKeys.Ranges[0]
+	if rng.RaftID != 1 || rng.StoreID != 1 || rng.QPS <= 0 {
+		t.Errorf("unexpected range: %+v", rng)
+	}
+	if len(rng.Keys) != 2 {
+		t.Fatalf("expected two hot keys; got %+v", rng.Keys)
+	}
+	if top := rng.Keys[0]; top.Key != hotKey.String() || top.Fraction < 0.5 || top.QPS <= 0 {
+		t.Errorf("expected %q to be the hottest key; got %+v", hotKey, rng.Keys)
+	}
+
+	// A range which doesn't exist yields no results.
+	body, err = getText(s.URL + statusLocalRangeHotKeysKey + "?raft_id=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hotKeys = status.RangeHotKeysList{}
+	if err := json.Unmarshal(body, &hotKeys); err != nil {
+		t.Fatal(err)
+	}
+	if len(hotKeys.Ranges) != 0 {
+		t.Errorf("expected no ranges; got %+v", hotKeys)
+	}
+}
+
+// TestStatusRangeConstraints verifies that ranges whose replicas
+// don't satisfy their zone's constraints are reported.
+func TestStatusRangeConstraints(t *testing.T) {
+	s, store := startRangeStatusServer(t)
+	defer s.Close()
+	defer store.Stop()
+
+	getViolations := func() []status.ConstraintViolation {
+		body, err := getText(s.URL + statusLocalRangeConstraintsKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		violations := status.ConstraintViolations{}
+		if err := json.Unmarshal(body, &violations); err != nil {
+			t.Fatal(err)
+		}
+		return violations.Violations
+	}
+
+	// The default zone asks for three replicas; the range has one.
+	if v := getViolations(); len(v) != 2 {
+		t.Fatalf("expected 2 violations; got %+v", v)
+	}
+
+	// Require a single replica on an SSD store, which the sole
+	// replica's store isn't.
+	zoneConfig := &proto.ZoneConfig{
+		ReplicaAttrs:  []proto.Attributes{{Attrs: []string{"+ssd"}}},
+		RangeMinBytes: 1048576,
+		RangeMaxBytes: 67108864,
+	}
+	key := engine.MakeKey(engine.KeyConfigZonePrefix, engine.KeyMin)
+	if err := store.DB().PutProto(key, zoneConfig); err != nil {
+		t.Fatal(err)
+	}
+	v := getViolations()
+	if len(v) != 1 {
+		t.Fatalf("expected 1 violation; got %+v", v)
+	}
+	if v[0].RaftID != 1 || v[0].StoreID != 1 || v[0].Constraints != "[+ssd]" {
+		t.Errorf("unexpected violation: %+v", v[0])
+	}
+}
+
+// TestStatusRangeCorrupt verifies that the replicas marked corrupted
+// are listed and that clearing the corruption of a range requires a
+// valid Raft ID of a corrupted range.
+func TestStatusRangeCorrupt(t *testing.T) {
+	s, store := startRangeStatusServer(t)
+	defer s.Close()
+	defer store.Stop()
+
+	body, err := getText(s.URL + statusLocalRangeCorruptKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas := status.CorruptReplicas{}
+	if err := json.Unmarshal(body, &replicas); err != nil {
+		t.Fatal(err)
+	}
+	if len(replicas.Replicas) != 0 {
+		t.Errorf("expected no corrupt replicas; got %+v", replicas)
+	}
+
+	for query, expCode := range map[string]int{
+		"?raft_id=1": http.StatusNotFound,
+		"?raft_id=x": http.StatusBadRequest,
+	} {
+		req, err := http.NewRequest("DELETE", s.URL+statusLocalRangeCorruptKey+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expCode {
+			t.Errorf("%s: expected status %d; got %d", query, expCode, resp.StatusCode)
+		}
+	}
+}
+
+// TestStatusRangeSizes verifies that the range size histogram is
+// available and accounts for every range.
+func TestStatusRangeSizes(t *testing.T) {
+	s, store := startRangeStatusServer(t)
+	defer s.Close()
+	defer store.Stop()
+
+	body, err := getText(s.URL + statusLocalRangeSizesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist := status.RangeSizeHistogram{}
+	if err := json.Unmarshal(body, &hist); err != nil {
+		t.Fatal(err)
+	}
+	if hist.Count != 1 || hist.TotalBytes <= 0 || len(hist.Buckets) == 0 {
+		t.Fatalf("unexpected histogram: %+v", hist)
+	}
+	if last := hist.Buckets[len(hist.Buckets)-1]; last.Count != 1 || last.MaxBytes < hist.TotalBytes {
+		t.Errorf("expected range in last bucket; got %+v", hist)
+	}
+}
+
+// TestRangeSizeHistogram verifies bucketing of range sizes.
+func TestRangeSizeHistogram(t *testing.T) {
+	testCases := []struct {
+		sizes    []int64
+		expected []status.RangeSizeBucket
+	}{
+		{nil, nil},
+		{[]int64{0, 1, rangeSizeMinBucket}, []status.RangeSizeBucket{{rangeSizeMinBucket, 3}}},
+		{[]int64{rangeSizeMinBucket + 1}, []status.RangeSizeBucket{{rangeSizeMinBucket, 0}, {rangeSizeMinBucket << 1, 1}}},
+		{[]int64{5 * rangeSizeMinBucket, 10, 3 * rangeSizeMinBucket}, []status.RangeSizeBucket{
+			{rangeSizeMinBucket, 1},
+			{rangeSizeMinBucket << 1, 0},
+			{rangeSizeMinBucket << 2, 1},
+			{rangeSizeMinBucket << 3, 1},
+		}},
+	}
+	for i, test := range testCases {
+		h := newRangeSizeHistogram(test.sizes)
+		if !reflect.DeepEqual(h.Buckets, test.expected) {
+			t.Errorf("%d: expected buckets %+v; got %+v", i, test.expected, h.Buckets)
+		}
+		var total int64
+		for _, size := range test.sizes {
+			total += size
+		}
+		if h.Count != len(test.sizes) || h.TotalBytes != total {
+			t.Errorf("%d: expected count %d, total %d; got %+v", i, len(test.sizes), total, h)
+		}
+	}
+}
+
+// TestStatusDetails verifies that the build and allowed flags and
+// environment variables of the node are available via the details
+// endpoint, and that nothing else is exposed.
+func TestStatusDetails(t *testing.T) {
+	for name, val := range map[string]string{"GOTRACEBACK": "all", "COCKROACH_STATUS_TEST": "foo"} {
+		defer os.Setenv(name, os.Getenv(name))
+		if err := os.Setenv(name, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := startStatusServer()
+	defer s.Close()
+
+	body, err := getText(s.URL + statusDetailsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	details := status.Details{}
+	if err := json.Unmarshal(body, &details); err != nil {
+		t.Fatal(err)
+	}
+	if details.Build.GoVersion != runtime.Version() {
+		t.Errorf("expected Go version %q; got %+v", runtime.Version(), details.Build)
+	}
+	if val, ok := details.Flags["rpc"]; !ok || val != *rpcAddr {
+		t.Errorf("expected rpc flag %q; got %q", *rpcAddr, val)
+	}
+	if _, ok := details.Flags["redact_keys"]; !ok {
+		t.Errorf("expected redact_keys flag to be reported; got %+v", details.Flags)
+	}
+	for _, name := range statusWithheldFlags {
+		if _, ok := details.Flags[name]; ok {
+			t.Errorf("expected %s flag to be withheld; got %+v", name, details.Flags)
+		}
+	}
+	for name := range details.Flags {
+		if strings.HasPrefix(name, "test.") {
+			t.Errorf("expected test flags to be withheld; got %+v", details.Flags)
+			break
+		}
+	}
+	if val := details.Environment["GOTRACEBACK"]; val != "all" {
+		t.Errorf("expected GOTRACEBACK to be reported; got %+v", details.Environment)
+	}
+	if _, ok := details.Environment["COCKROACH_STATUS_TEST"]; ok {
+		t.Errorf("expected unlisted environment variable to be withheld; got %+v", details.Environment)
+	}
+}
+
+// TestStatusClusterBuildMismatch verifies that the cluster status
+// warns when nodes are running different builds.
+func TestStatusClusterBuildMismatch(t *testing.T) {
+	s := newStatusServer(nil, nil, nil)
+	if cluster := s.clusterStatus(); cluster.Builds != nil || cluster.Warnings != nil {
+		t.Errorf("expected empty cluster status; got %+v", cluster)
+	}
+
+	s.builds[1] = "v0.1 (go1.4)"
+	s.builds[2] = "v0.1 (go1.4)"
+	cluster := s.clusterStatus()
+	if len(cluster.Builds) != 2 || cluster.Warnings != nil {
+		t.Errorf("expected two builds and no warnings; got %+v", cluster)
+	}
+
+	s.builds[3] = "v0.2 (go1.4)"
+	cluster = s.clusterStatus()
+	expWarning := "nodes are running mismatched builds: v0.1 (go1.4) on nodes [1 2]; v0.2 (go1.4) on nodes [3]"
+	if len(cluster.Warnings) != 1 || cluster.Warnings[0] != expWarning {
+		t.Errorf("expected warning %q; got %+v", expWarning, cluster.Warnings)
+	}
+}
+
+// TestStatusClusterSummary verifies that gossiped store summaries are
+// composed into cluster-wide totals, with summaries which haven't been
+// received recently marked stale and expired ones dropped. Ages are
+// measured from receipt, regardless of the remote clock.
+func TestStatusClusterSummary(t *testing.T) {
+	s := newStatusServer(nil, nil, nil)
+	now := int64(10 * summaryTTL)
+	s.summaries["store-1-1"] = receivedStoreSummary{storage.StoreSummary{
+		NodeID: 1, StoreID: 1, RangeCount: 3, Bytes: 100, QPS: 1.5, Timestamp: now,
+	}, now}
+	// The remote clock is far behind, but the summary was just received.
+	s.summaries["store-1-2"] = receivedStoreSummary{storage.StoreSummary{
+		NodeID: 1, StoreID: 2, RangeCount: 2, Bytes: 50, QPS: 0.5, Timestamp: now - int64(summaryTTL),
+	}, now - int64(time.Second)}
+	// The remote clock is ahead, but the summary hasn't been refreshed.
+	s.summaries["store-2-3"] = receivedStoreSummary{storage.StoreSummary{
+		NodeID: 2, StoreID: 3, RangeCount: 1, Bytes: 10, Timestamp: now,
+	}, now - int64(2*staleSummaryAge)}
+	// The summary has expired.
+	s.summaries["store-3-4"] = receivedStoreSummary{storage.StoreSummary{
+		NodeID: 3, StoreID: 4, RangeCount: 1, Bytes: 10, Timestamp: now,
+	}, now - int64(2*summaryTTL)}
+
+	cluster := s.clusterSummary(now)
+	if cluster.NodeCount != 2 || cluster.StoreCount != 3 || cluster.RangeCount != 6 ||
+		cluster.Bytes != 160 || cluster.QPS != 2 || cluster.StaleStores != 1 {
+		t.Errorf("unexpected cluster summary: %+v", cluster)
+	}
+	for i, store := range cluster.Stores {
+		if store.StoreID != int32(i+1) {
+			t.Errorf("%d: expected store %d; got %d", i, i+1, store.StoreID)
+		}
+		if expStale := store.StoreID == 3; store.Stale != expStale {
+			t.Errorf("store %d: expected stale=%t; got %t", store.StoreID, expStale, store.Stale)
+		}
+	}
+	if age := cluster.Stores[1].AgeSeconds; age != 1 {
+		t.Errorf("expected age of 1s; got %f", age)
+	}
+	if _, ok := s.summaries["store-3-4"]; ok || len(s.summaries) != 3 {
+		t.Errorf("expected expired summary to be dropped; got %+v", s.summaries)
+	}
+}
@@ -54,6 +54,13 @@ type TestServer struct {
 	// HTTPAddr and RPCAddr default to localhost with port set
 	// at time of call to Start() to an available port.
 	HTTPAddr, RPCAddr string
+	// StickyEngineID, if set, names a sticky in-memory engine which is
+	// retained across calls to Stop() and used again by any TestServer
+	// started with the same ID. This allows tests to simulate node
+	// restarts. The engine is bootstrapped only when first created.
+	// Tests should call engine.RemoveStickyInMem(StickyEngineID) when
+	// done to free the engine's memory.
+	StickyEngineID string
 	// server is the embedded Cockroach server struct.
 	*server
 }
@@ -85,9 +92,11 @@ func (ts *TestServer) Clock() *hlc.Clock {
 }
 
 // Start starts the TestServer by bootstrapping an in-memory store
-// (defaults to maximum of 100M). The server is started, launching the
-// node RPC server and all HTTP endpoints. Use the value of
-// TestServer.HTTPAddr after Start() for client connections.
+// (defaults to maximum of 100M). If StickyEngineID is set and names
+// an existing sticky engine, that engine is reused as is. The server
+// is started, launching the node RPC server and all HTTP endpoints.
+// Use the value of TestServer.HTTPAddr after Start() for client
+// connections.
 func (ts *TestServer) Start() error {
 	// We update these with the actual port once the servers
 	// have been launched for the purpose of this test.
@@ -98,13 +107,22 @@ func (ts *TestServer) Start() error {
 		ts.HTTPAddr = defaultHTTPAddr
 	}
 	var err error
-	ts.server, err = newServer(ts.RPCAddr, ts.CertDir, ts.MaxOffset)
+	ts.server, err = newServer(ts.RPCAddr, "", "", ts.CertDir, ts.MaxOffset)
 	if err != nil {
 		return util.Errorf("could not init server: %s", err)
 	}
-	engines := []engine.Engine{engine.NewInMem(proto.Attributes{}, 100<<20)}
-	if _, err := BootstrapCluster("cluster-1", engines[0]); err != nil {
-		return util.Errorf("could not bootstrap cluster: %s", err)
+	var e engine.Engine
+	bootstrap := true
+	if ts.StickyEngineID != "" {
+		e, bootstrap = engine.GetOrCreateStickyInMem(ts.StickyEngineID, proto.Attributes{}, 100<<20)
+	} else {
+		e = engine.NewInMem(proto.Attributes{}, 100<<20)
+	}
+	engines := []engine.Engine{e}
+	if bootstrap {
+		if _, err := BootstrapCluster("cluster-1", e); err != nil {
+			return util.Errorf("could not bootstrap cluster: %s", err)
+		}
 	}
 	err = ts.start(engines, "", ts.HTTPAddr, true) // TODO(spencer): should shutdown server.
 	if err != nil {
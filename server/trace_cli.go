@@ -0,0 +1,73 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package server
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	commander "code.google.com/p/go-commander"
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// A CmdExplainTrace command runs a single key-value operation with
+// tracing enabled and pretty-prints the spans of its execution.
+var CmdExplainTrace = &commander.Command{
+	UsageLine: "explain-trace [options] (get <key> | scan <start-key> <end-key>)",
+	Short:     "run a get or scan and display its execution trace",
+	Long: `
+Runs a get of <key> or a scan of [<start-key>, <end-key>) against the
+cluster at -addr and displays the trace of its execution: the time
+spent in each stage (RPCs, command queue, Raft, execution), the ranges
+and stores which handled it and any retries. Scans may not span ranges.
+`,
+	Run:  runExplainTrace,
+	Flag: *flag.CommandLine,
+}
+
+// runExplainTrace sends the requested operation with
+// RequestHeader.Trace set and prints the trace from the reply.
+func runExplainTrace(cmd *commander.Command, args []string) {
+	var method string
+	var req proto.Request
+	switch {
+	case len(args) == 2 && args[0] == "get":
+		method, req = proto.Get, proto.GetArgs(proto.Key(args[1]))
+	case len(args) == 3 && args[0] == "scan":
+		method, req = proto.Scan, proto.ScanArgs(proto.Key(args[1]), proto.Key(args[2]), 0)
+	default:
+		cmd.Usage()
+		return
+	}
+	_, reply, err := proto.CreateArgsAndReply(method)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	req.Header().Trace = true
+
+	kv := client.NewKV(client.NewHTTPSender(adminHost(), adminTransport()), nil)
+	kv.User = storage.UserRoot
+	defer kv.Close()
+	if err := kv.Call(method, req, reply); err != nil {
+		log.Errorf("%s failed: %s", method, err)
+	}
+	fmt.Fprint(os.Stdout, proto.FormatTrace(reply.Header().Trace))
+}
@@ -30,13 +30,45 @@ import (
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
+// zoneNames maps the names of internal keyspaces to their key
+// prefixes. A name may be given in place of a key prefix in zone paths
+// (e.g. "/.meta") so that operators can place system ranges on
+// particular stores.
+var zoneNames = map[string]proto.Key{
+	".meta":   engine.KeyMetaPrefix,
+	".system": engine.KeySystemPrefix,
+}
+
+// zoneKey returns the key of the zone config for the specified path,
+// with the leading "/" path delimiter stripped and any zone name
+// resolved to its key prefix.
+func zoneKey(path string) proto.Key {
+	prefix := proto.Key(path[1:])
+	if namedPrefix, ok := zoneNames[path[1:]]; ok {
+		prefix = namedPrefix
+	}
+	return engine.MakeKey(engine.KeyConfigZonePrefix, prefix)
+}
+
+// zoneName returns the URL query escaped key prefix for display,
+// substituting the name of an internal keyspace where one applies.
+func zoneName(prefix proto.Key) string {
+	for name, namedPrefix := range zoneNames {
+		if prefix.Equal(namedPrefix) {
+			return name
+		}
+	}
+	return url.QueryEscape(string(prefix))
+}
+
 // A zoneHandler implements the adminHandler interface.
 type zoneHandler struct {
 	db *client.KV // Key-value database client
 }
 
 // Put writes a zone config for the specified key prefix (which is
-// treated as a key). The zone config is parsed from the input
+// treated as a key) or internal keyspace name (see zoneNames). The
+// zone config is parsed from the input
 // "body". The specified body must validly parse into a zone config
 // struct.
 func (zh *zoneHandler) Put(path string, body []byte, r *http.Request) error {
@@ -47,8 +79,10 @@ func (zh *zoneHandler) Put(path string, body []byte, r *http.Request) error {
 	if err := util.UnmarshalRequest(r, body, config, util.AllEncodings); err != nil {
 		return util.Errorf("zone config has invalid format: %q: %s", body, err)
 	}
-	zoneKey := engine.MakeKey(engine.KeyConfigZonePrefix, proto.Key(path[1:]))
-	if err := zh.db.PutProto(zoneKey, config); err != nil {
+	if err := config.Validate(); err != nil {
+		return util.Errorf("zone config has invalid constraints: %q: %s", body, err)
+	}
+	if err := zh.db.PutProto(zoneKey(path), config); err != nil {
 		return err
 	}
 	return nil
@@ -82,15 +116,14 @@ func (zh *zoneHandler) Get(path string, r *http.Request) (body []byte, contentTy
 		var prefixes []string
 		for _, kv := range sr.Rows {
 			trimmed := bytes.TrimPrefix(kv.Key, engine.KeyConfigZonePrefix)
-			prefixes = append(prefixes, url.QueryEscape(string(trimmed)))
+			prefixes = append(prefixes, zoneName(trimmed))
 		}
 		// Encode the response.
 		body, contentType, err = util.MarshalResponse(r, prefixes, util.AllEncodings)
 	} else {
-		zoneKey := engine.MakeKey(engine.KeyConfigZonePrefix, proto.Key(path[1:]))
 		var ok bool
 		config := &proto.ZoneConfig{}
-		if ok, _, err = zh.db.GetProto(zoneKey, config); err != nil {
+		if ok, _, err = zh.db.GetProto(zoneKey(path), config); err != nil {
 			return
 		}
 		// On get, if there's no zone config for the requested prefix,
@@ -113,10 +146,9 @@ func (zh *zoneHandler) Delete(path string, r *http.Request) error {
 	if path == "/" {
 		return util.Errorf("the default zone configuration cannot be deleted")
 	}
-	zoneKey := engine.MakeKey(engine.KeyConfigZonePrefix, proto.Key(path[1:]))
 	return zh.db.Call(proto.Delete, &proto.DeleteRequest{
 		RequestHeader: proto.RequestHeader{
-			Key:  zoneKey,
+			Key:  zoneKey(path),
 			User: storage.UserRoot,
 		},
 	}, &proto.DeleteResponse{})
@@ -98,20 +98,36 @@ argument: <key-prefix>) to the contents of the specified file
 escaped via URL query escaping if it contains non-ascii bytes or
 spaces.
 
+Internal keyspaces may be named in place of a key prefix: ".meta" for
+the range addressing records and ".system" for all system data. For
+example, to keep the range addressing records on ssds:
+
+  set-zone .meta <zone-config-file with "constraints: [ssd]">
+
 The zone config format has the following YAML schema:
 
   replicas:
     - [comma-separated attribute list]
     - ...
+  constraints: [comma-separated constraint list]
   range_min_bytes: <size-in-bytes>
   range_max_bytes: <size-in-bytes>
 
+Each attribute may be prefixed with "+" (the replica's store must
+have the attribute; the default) or "-" (the store must not have
+it). Constraints apply to every replica in addition to the
+per-replica attributes. The prefix is not part of the attribute
+name: a replica attribute written as "-ssd" prohibits "ssd", where
+zone configs set before constraints were supported required a store
+attribute named "-ssd".
+
 For example:
 
   replicas:
     - [us-east-1a, ssd]
     - [us-east-1b, ssd]
     - [us-west-1b, ssd]
+  constraints: [-region=eu]
   range_min_bytes: 8388608
   range_min_bytes: 67108864
 
@@ -157,6 +157,34 @@ func ExampleLsZones() {
 	// db2
 }
 
+// ExampleNamedZones verifies that zone configs may be set for
+// internal keyspaces by name and that listings display the names.
+func ExampleNamedZones() {
+	httpServer := startAdminServer()
+	defer httpServer.Close()
+	testConfigFn := createTestConfigFile(testZoneConfig)
+	defer os.Remove(testConfigFn)
+
+	for _, prefix := range []string{"db1", ".system", ".meta"} {
+		runSetZone(CmdSetZone, []string{prefix, testConfigFn})
+	}
+	runLsZones(CmdLsZones, []string{})
+	runRmZone(CmdRmZone, []string{".system"})
+	runLsZones(CmdLsZones, []string{})
+	// Output:
+	// set zone config for key prefix "db1"
+	// set zone config for key prefix ".system"
+	// set zone config for key prefix ".meta"
+	// [default]
+	// .system
+	// .meta
+	// db1
+	// removed zone config for key prefix ".system"
+	// [default]
+	// .meta
+	// db1
+}
+
 // ExampleRmZones creates a series of zone configs and verifies
 // zone-rm works by deleting some and then all and verifying entries
 // have been removed via zone-ls. Also verify the default zone cannot
@@ -35,12 +35,36 @@ type allocator struct {
 	rand        rand.Rand
 }
 
-// allocate returns a suitable store based on the supplied
-// attributes list. If none are available / suitable, returns an
-// error. It uses the allocator's StoreFinder to select the set of
-// available stores matching attributes for missing replicas and picks
-// using randomly weighted selection based on available capacities.
-func (a *allocator) allocate(required proto.Attributes, existingReplicas []proto.Replica) (
+// allocateForZone returns a suitable store for a new replica of a
+// range in the supplied zone, given the range's existing replicas. The
+// store must satisfy the first of the zone's replica constraints left
+// unsatisfied by the existing replicas, or only the zone-wide
+// constraints if the existing replicas satisfy them all.
+func (a *allocator) allocateForZone(zone *proto.ZoneConfig, existingReplicas []proto.Replica) (
+	*StoreDescriptor, error) {
+	replicaConstraints, err := zone.ReplicaConstraints()
+	if err != nil {
+		return nil, err
+	}
+	if unsatisfied := UnsatisfiedConstraints(existingReplicas, replicaConstraints); len(unsatisfied) > 0 {
+		return a.allocate(unsatisfied[0], existingReplicas)
+	}
+	constraints, err := proto.ParseConstraints(zone.Constraints)
+	if err != nil {
+		return nil, err
+	}
+	return a.allocate(constraints, existingReplicas)
+}
+
+// allocate returns a suitable store for a replica with the supplied
+// constraints, as returned for one of a zone's replicas by
+// ZoneConfig.ReplicaConstraints. If none are available / suitable,
+// returns an error. It uses the allocator's StoreFinder to select the
+// set of available stores with the required attributes, excludes
+// those with any prohibited attribute or on a node in standby mode,
+// and picks using randomly weighted selection based on available
+// capacities.
+func (a *allocator) allocate(constraints proto.Constraints, existingReplicas []proto.Replica) (
 	*StoreDescriptor, error) {
 	// Get a set of current nodes -- we never want to allocate on an existing node.
 	usedNodes := make(map[int32]struct{})
@@ -48,7 +72,7 @@ func (a *allocator) allocate(required proto.Attributes, existingReplicas []proto
 		usedNodes[replica.NodeID] = struct{}{}
 	}
 
-	stores, err := a.storeFinder(required)
+	stores, err := a.storeFinder(proto.Attributes{Attrs: constraints.Required})
 	if err != nil {
 		return nil, err
 	}
@@ -57,6 +81,9 @@ func (a *allocator) allocate(required proto.Attributes, existingReplicas []proto
 	var candidates []*StoreDescriptor
 	var capacityTotal float64
 	for _, s := range stores {
+		if s.Node.Standby || !constraints.Satisfied(*s.CombinedAttrs()) {
+			continue
+		}
 		if _, ok := usedNodes[s.Node.NodeID]; !ok {
 			candidates = append(candidates, s)
 			capacityTotal += s.Capacity.PercentAvail()
@@ -74,5 +101,40 @@ func (a *allocator) allocate(required proto.Attributes, existingReplicas []proto
 			return c, nil
 		}
 	}
-	return nil, util.Errorf("unable to find an appropriate store for requested replica attributes")
+	return nil, util.Errorf("unable to find an appropriate store for replica constraints %s", constraints)
+}
+
+// UnsatisfiedConstraints matches replicas to the per-replica
+// constraints of a zone, each replica satisfying at most one set of
+// constraints, and returns the constraints left unmatched. Missing
+// replicas leave constraints unmatched, as do replicas whose
+// attributes don't satisfy them.
+func UnsatisfiedConstraints(replicas []proto.Replica, constraints []proto.Constraints) []proto.Constraints {
+	// Find a maximum matching by augmenting paths; zones have few
+	// replicas, so the quadratic cost is of no concern.
+	owner := make([]int, len(replicas)) // replica index -> constraint index
+	for i := range owner {
+		owner[i] = -1
+	}
+	var augment func(c int, seen []bool) bool
+	augment = func(c int, seen []bool) bool {
+		for r, replica := range replicas {
+			if seen[r] || !constraints[c].Satisfied(replica.Attrs) {
+				continue
+			}
+			seen[r] = true
+			if owner[r] == -1 || augment(owner[r], seen) {
+				owner[r] = c
+				return true
+			}
+		}
+		return false
+	}
+	var unsatisfied []proto.Constraints
+	for c := range constraints {
+		if !augment(c, make([]bool, len(replicas))) {
+			unsatisfied = append(unsatisfied, constraints[c])
+		}
+	}
+	return unsatisfied
 }
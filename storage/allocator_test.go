@@ -170,6 +170,16 @@ var multiDCStores = func(a proto.Attributes) ([]*StoreDescriptor, error) {
 	})
 }
 
+// replicaConstraints returns the constraints for the i-th replica of
+// the supplied zone.
+func replicaConstraints(t *testing.T, zone proto.ZoneConfig, i int) proto.Constraints {
+	cs, err := zone.ReplicaConstraints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs[i]
+}
+
 var noStores = func(a proto.Attributes) ([]*StoreDescriptor, error) {
 	return filterStores(a, []*StoreDescriptor{})
 }
@@ -179,7 +189,7 @@ func TestSimpleRetrieval(t *testing.T) {
 		storeFinder: singleStore,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result, err := a.allocate(simpleZoneConfig.ReplicaAttrs[0], []proto.Replica{})
+	result, err := a.allocate(replicaConstraints(t, simpleZoneConfig, 0), []proto.Replica{})
 	if err != nil {
 		t.Errorf("Unable to perform allocation: %v", err)
 	}
@@ -193,7 +203,7 @@ func TestNoAvailableDisks(t *testing.T) {
 		storeFinder: noStores,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result, err := a.allocate(simpleZoneConfig.ReplicaAttrs[0], []proto.Replica{})
+	result, err := a.allocate(replicaConstraints(t, simpleZoneConfig, 0), []proto.Replica{})
 	if result != nil {
 		t.Errorf("expected nil result: %+v", result)
 	}
@@ -207,7 +217,7 @@ func TestThreeDisksSameDC(t *testing.T) {
 		storeFinder: sameDCStores,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result1, err := a.allocate(multiDisksConfig.ReplicaAttrs[0], []proto.Replica{})
+	result1, err := a.allocate(replicaConstraints(t, multiDisksConfig, 0), []proto.Replica{})
 	if err != nil {
 		t.Fatalf("Unable to perform allocation: %v", err)
 	}
@@ -221,7 +231,7 @@ func TestThreeDisksSameDC(t *testing.T) {
 			Attrs:   multiDisksConfig.ReplicaAttrs[0],
 		},
 	}
-	result2, err := a.allocate(multiDisksConfig.ReplicaAttrs[1], exReplicas)
+	result2, err := a.allocate(replicaConstraints(t, multiDisksConfig, 1), exReplicas)
 	if err != nil {
 		t.Errorf("Unable to perform allocation: %v", err)
 	}
@@ -231,7 +241,7 @@ func TestThreeDisksSameDC(t *testing.T) {
 	if result1.Node.NodeID == result2.Node.NodeID {
 		t.Errorf("Expected node ids to be different %+v vs %+v", result1, result2)
 	}
-	result3, err := a.allocate(multiDisksConfig.ReplicaAttrs[2], []proto.Replica{})
+	result3, err := a.allocate(replicaConstraints(t, multiDisksConfig, 2), []proto.Replica{})
 	if err != nil {
 		t.Errorf("Unable to perform allocation: %v", err)
 	}
@@ -245,11 +255,11 @@ func TestTwoDatacenters(t *testing.T) {
 		storeFinder: multiDCStores,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result1, err := a.allocate(multiDCConfig.ReplicaAttrs[0], []proto.Replica{})
+	result1, err := a.allocate(replicaConstraints(t, multiDCConfig, 0), []proto.Replica{})
 	if err != nil {
 		t.Fatalf("Unable to perform allocation: %v", err)
 	}
-	result2, err := a.allocate(multiDCConfig.ReplicaAttrs[1], []proto.Replica{})
+	result2, err := a.allocate(replicaConstraints(t, multiDCConfig, 1), []proto.Replica{})
 	if err != nil {
 		t.Fatalf("Unable to perform allocation: %v", err)
 	}
@@ -257,7 +267,7 @@ func TestTwoDatacenters(t *testing.T) {
 		t.Errorf("Expected nodes 1 & 2: %+v vs %+v", result1.Node, result2.Node)
 	}
 	// Verify that no result is forthcoming if we already have a replica.
-	_, err = a.allocate(multiDCConfig.ReplicaAttrs[1], []proto.Replica{
+	_, err = a.allocate(replicaConstraints(t, multiDCConfig, 1), []proto.Replica{
 		proto.Replica{
 			NodeID:  result2.Node.NodeID,
 			StoreID: result2.StoreID,
@@ -274,7 +284,7 @@ func TestExistingReplica(t *testing.T) {
 		storeFinder: sameDCStores,
 		rand:        *rand.New(rand.NewSource(0)),
 	}
-	result, err := a.allocate(multiDisksConfig.ReplicaAttrs[1], []proto.Replica{
+	result, err := a.allocate(replicaConstraints(t, multiDisksConfig, 1), []proto.Replica{
 		proto.Replica{
 			NodeID:  1,
 			StoreID: 1,
@@ -288,3 +298,158 @@ func TestExistingReplica(t *testing.T) {
 		t.Errorf("expected result to have node 3 and store 4: %+v", result)
 	}
 }
+
+func TestAllocateConstrained(t *testing.T) {
+	var a = allocator{
+		storeFinder: sameDCStores,
+		rand:        *rand.New(rand.NewSource(0)),
+	}
+	// Prohibiting ssd and mem leaves only the hdd stores 3 & 4.
+	for i := 0; i < 10; i++ {
+		result, err := a.allocate(proto.Constraints{Required: []string{"a"}, Prohibited: []string{"ssd", "mem"}}, []proto.Replica{})
+		if err != nil {
+			t.Fatalf("Unable to perform allocation: %v", err)
+		}
+		if result.StoreID != 3 && result.StoreID != 4 {
+			t.Errorf("expected an hdd store; got %+v", result)
+		}
+	}
+	if _, err := a.allocate(proto.Constraints{Prohibited: []string{"a"}}, []proto.Replica{}); err == nil {
+		t.Errorf("expected error when every store is prohibited")
+	}
+}
+
+// TestAllocateZoneConstraints verifies that allocation honors a
+// zone's constraints in addition to its replica attributes.
+func TestAllocateZoneConstraints(t *testing.T) {
+	var a = allocator{
+		storeFinder: sameDCStores,
+		rand:        *rand.New(rand.NewSource(0)),
+	}
+	zone := proto.ZoneConfig{
+		ReplicaAttrs: []proto.Attributes{
+			proto.Attributes{Attrs: []string{"a"}},
+		},
+		Constraints: []string{"-ssd"},
+	}
+	// Of the stores with attribute "a", stores 1 & 2 are prohibited.
+	for i := 0; i < 10; i++ {
+		result, err := a.allocate(replicaConstraints(t, zone, 0), []proto.Replica{})
+		if err != nil {
+			t.Fatalf("Unable to perform allocation: %v", err)
+		}
+		if result.StoreID == 1 || result.StoreID == 2 {
+			t.Errorf("expected a store without ssd; got %+v", result)
+		}
+	}
+
+	// Prohibiting every remaining store leaves nothing to allocate.
+	zone.Constraints = []string{"-ssd", "-hdd", "-mem"}
+	if result, err := a.allocate(replicaConstraints(t, zone, 0), []proto.Replica{}); err == nil {
+		t.Errorf("expected error with every store prohibited; got %+v", result)
+	}
+}
+
+// TestAllocateForZone verifies that a zone's replicas are allocated
+// for the first replica constraints its existing replicas leave
+// unsatisfied, and for the zone-wide constraints once all are.
+func TestAllocateForZone(t *testing.T) {
+	var a = allocator{
+		storeFinder: sameDCStores,
+		rand:        *rand.New(rand.NewSource(0)),
+	}
+	// The ssd replica is in place, so the next must be on hdd.
+	existing := []proto.Replica{
+		proto.Replica{
+			NodeID:  1,
+			StoreID: 1,
+			Attrs:   proto.Attributes{Attrs: []string{"a", "ssd"}},
+		},
+	}
+	for i := 0; i < 10; i++ {
+		result, err := a.allocateForZone(&multiDisksConfig, existing)
+		if err != nil {
+			t.Fatalf("Unable to perform allocation: %v", err)
+		}
+		if result.StoreID != 3 && result.StoreID != 4 {
+			t.Errorf("expected an hdd store; got %+v", result)
+		}
+	}
+
+	// With every replica constraint satisfied, only the zone-wide
+	// constraints apply.
+	zone := proto.ZoneConfig{
+		ReplicaAttrs: []proto.Attributes{
+			proto.Attributes{Attrs: []string{"a", "ssd"}},
+		},
+		Constraints: []string{"-hdd", "-mem"},
+	}
+	result, err := a.allocateForZone(&zone, existing)
+	if err != nil {
+		t.Fatalf("Unable to perform allocation: %v", err)
+	}
+	if result.StoreID != 2 {
+		t.Errorf("expected store 2, the only allowed store off node 1; got %+v", result)
+	}
+}
+
+// TestAllocateStandby verifies that stores on nodes in standby mode
+// aren't allocated.
+func TestAllocateStandby(t *testing.T) {
+	var a = allocator{
+		storeFinder: func(attrs proto.Attributes) ([]*StoreDescriptor, error) {
+			stores, err := sameDCStores(attrs)
+			for _, s := range stores {
+				if s.Node.NodeID == 2 {
+					s.Node.Standby = true
+				}
+			}
+			return stores, err
+		},
+		rand: *rand.New(rand.NewSource(0)),
+	}
+	// Of the ssd stores, only store 1 isn't on standby node 2.
+	for i := 0; i < 10; i++ {
+		result, err := a.allocate(replicaConstraints(t, simpleZoneConfig, 0), []proto.Replica{})
+		if err != nil {
+			t.Fatalf("Unable to perform allocation: %v", err)
+		}
+		if result.StoreID != 1 {
+			t.Errorf("expected store 1; got %+v", result)
+		}
+	}
+	// With store 1's node already holding a replica, nothing is left.
+	if result, err := a.allocate(replicaConstraints(t, simpleZoneConfig, 0), []proto.Replica{
+		proto.Replica{NodeID: 1, StoreID: 1},
+	}); err == nil {
+		t.Errorf("expected error allocating with only standby stores left; got %+v", result)
+	}
+}
+
+func TestUnsatisfiedConstraints(t *testing.T) {
+	ssd := proto.Constraints{Required: []string{"ssd"}}
+	anyStore := proto.Constraints{}
+	notEast := proto.Constraints{Prohibited: []string{"us-east"}}
+	replicas := []proto.Replica{
+		{StoreID: 1, Attrs: proto.Attributes{Attrs: []string{"ssd", "us-east"}}},
+		{StoreID: 2, Attrs: proto.Attributes{Attrs: []string{"hdd", "us-west"}}},
+	}
+	testCases := []struct {
+		constraints []proto.Constraints
+		expCount    int
+	}{
+		{nil, 0},
+		{[]proto.Constraints{ssd, anyStore}, 0},
+		// A greedy match would assign the ssd replica to anyStore first.
+		{[]proto.Constraints{anyStore, ssd}, 0},
+		{[]proto.Constraints{ssd, notEast}, 0},
+		{[]proto.Constraints{ssd, ssd}, 1},
+		{[]proto.Constraints{anyStore, anyStore, anyStore}, 1},
+		{[]proto.Constraints{notEast, notEast}, 1},
+	}
+	for i, test := range testCases {
+		if unsatisfied := UnsatisfiedConstraints(replicas, test.constraints); len(unsatisfied) != test.expCount {
+			t.Errorf("%d: expected %d unsatisfied constraints; got %+v", i, test.expCount, unsatisfied)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// AuditLogSpan is the key range holding the audit log.
+var AuditLogSpan = proto.KeyRange{
+	Start: engine.KeyAuditLogPrefix,
+	End:   engine.KeyAuditLogPrefix.PrefixEnd(),
+}
+
+// auditLogInternalMethods are the internal read-write methods which
+// may touch the audit log. They maintain data already written (e.g.
+// by resolving intents or garbage collecting old versions) rather
+// than writing values of their own.
+var auditLogInternalMethods = map[string]struct{}{
+	proto.InternalHeartbeatTxn:    struct{}{},
+	proto.InternalPushTxn:         struct{}{},
+	proto.InternalResolveIntent:   struct{}{},
+	proto.InternalComputeChecksum: struct{}{},
+	proto.InternalVerifyChecksum:  struct{}{},
+	proto.InternalRecomputeStats:  struct{}{},
+	proto.InternalGC:              struct{}{},
+}
+
+// VerifyAuditLogWrite verifies that the request doesn't modify the
+// audit log, which is append-only: the only permitted write is a
+// ConditionalPut of a new event. Ranges verify every command they
+// receive, so the audit log is append-only regardless of the path by
+// which a request arrives. Requests which don't touch the audit log
+// are always permitted. Each request in a batch is verified.
+func VerifyAuditLogWrite(method string, args proto.Request) error {
+	if proto.IsReadOnly(method) || proto.IsAdmin(method) {
+		return nil
+	}
+	if bArgs, ok := args.(*proto.BatchRequest); ok {
+		for i := range bArgs.Requests {
+			req := bArgs.Requests[i].GetValue().(proto.Request)
+			m, err := proto.MethodForRequest(req)
+			if err != nil {
+				return err
+			}
+			if err := VerifyAuditLogWrite(m, req); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, ok := auditLogInternalMethods[method]; ok {
+		return nil
+	}
+	header := args.Header()
+	if !AuditLogSpan.Overlaps(proto.KeyRange{Start: header.Key, End: header.EndKey}) {
+		return nil
+	}
+	if cPut, ok := args.(*proto.ConditionalPutRequest); !ok || cPut.ExpValue != nil {
+		return util.Errorf("audit log is append-only; cannot invoke %s at %s", method, header.Key)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/encoding"
+	"github.com/cockroachdb/cockroach/util/hlc"
+	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metrics"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+const (
+	// consistencyQueueMaxSize is the max size of the consistency queue.
+	consistencyQueueMaxSize = 100
+
+	// consistencyCheckMinSleep is the shortest pause taken to keep a
+	// checksum computation within --consistency_check_rate; shorter
+	// pauses are deferred until enough bytes have accumulated.
+	consistencyCheckMinSleep = 10 * time.Millisecond
+)
+
+var (
+	consistencyCheckInterval = flag.Duration("consistency_check_interval", 24*time.Hour, "specify "+
+		"--consistency_check_interval to set the interval at which each range's "+
+		"replicas are compared against each other. Specify 0 to disable "+
+		"consistency checks.")
+
+	consistencyCheckRate = flag.Int64("consistency_check_rate", 8<<20, "specify "+
+		"--consistency_check_rate to set the maximum number of bytes per second "+
+		"read by each replica while computing a consistency checksum. Specify "+
+		"0 for no limit.")
+)
+
+// A replicaChecksum tracks a consistency check on one replica, from
+// the InternalComputeChecksum command which starts it, through the
+// InternalVerifyChecksum commands reporting each replica's checksum,
+// to the comparison of this replica's checksum against the one
+// reported by a majority of the range's replicas. The local checksum
+// and the majority may be known in either order. No single replica,
+// including the proposer, decides which checksum is the right one.
+//
+// Until ranges have more than one replica, the only checksum reported
+// is the leader's own and checks can't find divergence; they still
+// exercise the command path and pace the snapshot scan.
+type replicaChecksum struct {
+	id     string        // ChecksumID of the check
+	done   chan struct{} // Closed once the checksum has been computed
+	report bool          // Report the checksum once computed?
+
+	// The following fields are protected by the range's lock.
+	computed bool             // Has the checksum been computed?
+	checksum uint32           // The computed checksum
+	err      error            // Error computing the checksum, if any
+	reports  map[int32]uint32 // Checksums reported so far, by store ID
+	agreed   *uint32          // The majority's checksum, once known
+	verified bool             // Has the checksum been verified?
+}
+
+// computeChecksum computes a checksum of the range's replicated data
+// as of the specified snapshot, releasing the snapshot when done. The
+// checksum is reported to the range's replicas if requested and
+// verified if a majority of them have already agreed.
+func (r *Range) computeChecksum(c *replicaChecksum, snap engine.Engine) {
+	checksum, err := r.snapshotChecksum(snap)
+	snap.Stop()
+	r.Lock()
+	c.computed, c.checksum, c.err = true, checksum, err
+	r.Unlock()
+	close(c.done)
+	if err != nil {
+		log.Warningf("range %d: unable to compute checksum %s: %s", r.Desc.RaftID, c.id, err)
+	} else if c.report {
+		if err := r.reportChecksum(c.id, checksum); err != nil {
+			log.Warningf("range %d: unable to report checksum %s: %s", r.Desc.RaftID, c.id, err)
+		}
+	}
+	r.maybeVerifyChecksum(c)
+}
+
+// reportChecksum proposes an InternalVerifyChecksum command reporting
+// this replica's checksum for the consistency check with the specified
+// ID to all replicas of the range.
+func (r *Range) reportChecksum(id string, checksum uint32) error {
+	desc := r.Descriptor()
+	replica := desc.FindReplica(r.rm.StoreID())
+	if replica == nil {
+		return util.Errorf("store %d holds no replica of range %d", r.rm.StoreID(), desc.RaftID)
+	}
+	args := &proto.InternalVerifyChecksumRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       desc.StartKey,
+			Timestamp: r.rm.Clock().Now(),
+			User:      UserRoot,
+			RaftID:    desc.RaftID,
+			Replica:   *replica,
+		},
+		ChecksumID: id,
+		Checksum:   checksum,
+	}
+	return r.AddCmd(proto.InternalVerifyChecksum, args, &proto.InternalVerifyChecksumResponse{}, true)
+}
+
+// snapshotChecksum returns a CRC-32 (Castagnoli) checksum of the
+// range's replicated data as read from the supplied snapshot: the
+// range-local keys addressed by key (range descriptors, range
+// tombstones and transaction records) and by Raft ID (stats and GC
+// metadata), followed by the key/value data. Keys and values are
+// length-prefixed, as with proto.SnapshotChecksum. The response cache
+// is garbage collected independently by each replica and the last
+// verified timestamp is only recorded by replicas which took part in
+// the check, so both are excluded. Reads are paced to
+// --consistency_check_rate.
+func (r *Range) snapshotChecksum(snap engine.Engine) (uint32, error) {
+	r.RLock()
+	start, end, raftID := r.Desc.StartKey, r.Desc.EndKey, r.Desc.RaftID
+	r.RUnlock()
+
+	dataStart := start
+	if dataStart.Less(engine.KeyLocalMax) {
+		dataStart = engine.KeyLocalMax
+	}
+	var spans [][2]proto.Key
+	for _, prefix := range []proto.Key{engine.KeyLocalRangeDescriptorPrefix, engine.KeyLocalRangeTombstonePrefix} {
+		spans = append(spans, [2]proto.Key{engine.MakeLocalKey(prefix, start), engine.MakeLocalKey(prefix, end)})
+	}
+	for _, prefix := range []proto.Key{engine.KeyLocalRangeStatPrefix, engine.KeyLocalRangeGCMetadataPrefix} {
+		idKey := engine.MakeKey(prefix, encoding.EncodeInt(nil, raftID))
+		spans = append(spans, [2]proto.Key{idKey, idKey.PrefixEnd()})
+	}
+	spans = append(spans,
+		[2]proto.Key{engine.MakeKey(engine.KeyLocalTransactionPrefix, start), engine.MakeKey(engine.KeyLocalTransactionPrefix, end)},
+		[2]proto.Key{dataStart, end})
+
+	limiter := newByteRateLimiter(*consistencyCheckRate, r.rm.Clock(), r.closer)
+	var crc uint32
+	var lenBuf [binary.MaxVarintLen64]byte
+	visit := func(kv proto.RawKeyValue) (bool, error) {
+		for _, b := range [][]byte{kv.Key, kv.Value} {
+			n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+			crc = crc32.Update(crc, proto.CastagnoliTable, lenBuf[:n])
+			crc = crc32.Update(crc, proto.CastagnoliTable, b)
+		}
+		return false, limiter.wait(len(kv.Key) + len(kv.Value))
+	}
+	for _, span := range spans {
+		if err := snap.Iterate(engine.MVCCEncodeKey(span[0]),
+			engine.MVCCEncodeKey(span[1]), visit); err != nil {
+			return 0, err
+		}
+	}
+	return crc, nil
+}
+
+// tallyChecksum records a replica's reported checksum for the
+// consistency check and returns whether the report gave a majority of
+// the range's replicas the same checksum, in which case it's recorded
+// as the agreed checksum. A check which ends without a majority is
+// abandoned. The range's lock must be held.
+func (r *Range) tallyChecksum(c *replicaChecksum, storeID int32, checksum uint32) bool {
+	c.reports[storeID] = checksum
+	agreeing := 0
+	for _, reported := range c.reports {
+		if reported == checksum {
+			agreeing++
+		}
+	}
+	replicas := len(r.Desc.Replicas)
+	if agreeing > replicas/2 {
+		c.agreed = gogoproto.Uint32(checksum)
+		return true
+	}
+	if len(c.reports) == replicas {
+		log.Warningf("range %d: replicas disagree on checksum %s without a majority", r.Desc.RaftID, c.id)
+		if r.checksum == c {
+			r.checksum = nil
+		}
+	}
+	return false
+}
+
+// maybeVerifyChecksum compares the replica's checksum against the one
+// a majority of the range's replicas agreed on, once both are known.
+// A replica in the minority has diverged: this is counted and marks
+// the replica corrupted.
+func (r *Range) maybeVerifyChecksum(c *replicaChecksum) {
+	r.Lock()
+	if !c.computed || c.agreed == nil || c.verified {
+		r.Unlock()
+		return
+	}
+	c.verified = true
+	if r.checksum == c {
+		r.checksum = nil
+	}
+	r.Unlock()
+
+	if c.err == nil && c.checksum != *c.agreed {
+		metrics.Metrics.Counter(fmt.Sprintf("storage.store.%d.consistency_divergences", r.rm.StoreID()), 1)
+		r.setCorrupt(util.Errorf("checksum %s is %08x; a majority of replicas have %08x",
+			c.id, c.checksum, *c.agreed))
+	}
+}
+
+// waitForChecksum blocks until the replica's checksum with the
+// specified ID has been computed and returns it.
+func (r *Range) waitForChecksum(id string) (uint32, error) {
+	r.RLock()
+	c := r.checksum
+	r.RUnlock()
+	if c == nil || c.id != id {
+		return 0, util.Errorf("range %d: checksum %s is not being computed", r.Desc.RaftID, id)
+	}
+	select {
+	case <-c.done:
+	case <-r.closer:
+		return 0, util.Errorf("range %d: stopped while computing checksum %s", r.Desc.RaftID, id)
+	}
+	return c.checksum, c.err
+}
+
+// LastVerified returns the timestamp at which the range's replicas
+// were last found to be consistent, or proto.ZeroTimestamp if they
+// have never been verified.
+func (r *Range) LastVerified() (proto.Timestamp, error) {
+	var ts proto.Timestamp
+	if _, err := engine.MVCCGetProto(r.rm.Engine(), engine.RangeLastVerifiedKey(r.Desc.RaftID),
+		proto.ZeroTimestamp, nil, &ts); err != nil {
+		return proto.ZeroTimestamp, err
+	}
+	return ts, nil
+}
+
+// A byteRateLimiter paces a reader to a maximum number of bytes per
+// second, measured by the clock from the limiter's creation.
+type byteRateLimiter struct {
+	rate   int64 // Bytes per second; no limit if <= 0
+	clock  *hlc.Clock
+	start  int64 // Physical time at creation, in nanoseconds
+	bytes  int64
+	closer chan struct{}
+}
+
+// newByteRateLimiter returns a byteRateLimiter which paces reads to
+// rate bytes per second as measured by clock, and which stops waiting
+// once closer is closed.
+func newByteRateLimiter(rate int64, clock *hlc.Clock, closer chan struct{}) *byteRateLimiter {
+	return &byteRateLimiter{rate: rate, clock: clock, start: clock.PhysicalNow(), closer: closer}
+}
+
+// wait accounts for n bytes read and sleeps for as long as the reader
+// is ahead of the rate. Returns an error if closer is closed while
+// sleeping.
+func (l *byteRateLimiter) wait(n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	l.bytes += int64(n)
+	target := time.Duration(float64(l.bytes) / float64(l.rate) * float64(time.Second))
+	elapsed := time.Duration(l.clock.PhysicalNow() - l.start)
+	if d := target - elapsed; d >= consistencyCheckMinSleep {
+		select {
+		case <-time.After(d):
+		case <-l.closer:
+			return util.Errorf("stopped")
+		}
+	}
+	return nil
+}
+
+// consistencyQueue is a low priority background scrubber. It walks
+// the store's ranges, asking the leader of each range which hasn't
+// been verified within --consistency_check_interval to have all
+// replicas checksum the range at the same point in the Raft log and
+// compare the results.
+type consistencyQueue struct {
+	*baseQueue
+	clock    *hlc.Clock
+	interval time.Duration
+}
+
+// newConsistencyQueue returns a new instance of consistencyQueue.
+func newConsistencyQueue(clock *hlc.Clock) *consistencyQueue {
+	cq := &consistencyQueue{
+		clock:    clock,
+		interval: *consistencyCheckInterval,
+	}
+	cq.baseQueue = newBaseQueue("consistency", cq.shouldQueue, cq.process, consistencyQueueMaxSize)
+	return cq
+}
+
+// shouldQueue determines whether the range's replicas are due to be
+// compared. Only the leader proposes consistency checks. Priority is
+// the time since the range was last verified, in units of the check
+// interval.
+func (cq *consistencyQueue) shouldQueue(rng *Range) (bool, float64) {
+	if cq.interval <= 0 || !rng.IsLeader() {
+		return false, 0
+	}
+	lastVerified, err := rng.LastVerified()
+	if err != nil {
+		log.Warningf("range %d: unable to read last verified timestamp: %s", rng.Desc.RaftID, err)
+		return false, 0
+	}
+	elapsed := cq.clock.PhysicalNow() - lastVerified.WallTime
+	priority := float64(elapsed) / float64(cq.interval.Nanoseconds())
+	return priority >= 1, priority
+}
+
+// process proposes a consistency check on the range: every replica
+// computes a checksum when it applies the InternalComputeChecksum
+// command and reports it with an InternalVerifyChecksum command. The
+// leader reports its own checksum here, once it's been computed.
+func (cq *consistencyQueue) process(rng *Range) error {
+	now := cq.clock.Now()
+	id := fmt.Sprintf("%d-%d-%x", rng.Desc.RaftID, now.WallTime, rand.Int63())
+	header := proto.RequestHeader{
+		Key:       rng.Desc.StartKey,
+		Timestamp: now,
+		User:      UserRoot,
+		RaftID:    rng.Desc.RaftID,
+		Replica:   *rng.GetReplica(),
+	}
+
+	computeArgs := &proto.InternalComputeChecksumRequest{RequestHeader: header, ChecksumID: id}
+	if err := rng.AddCmd(proto.InternalComputeChecksum, computeArgs, &proto.InternalComputeChecksumResponse{}, true); err != nil {
+		return err
+	}
+	checksum, err := rng.waitForChecksum(id)
+	if err != nil {
+		return err
+	}
+	return rng.reportChecksum(id, checksum)
+}
@@ -0,0 +1,295 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/hlc"
+)
+
+// TestConsistencyQueue verifies that a range which hasn't been
+// verified within the check interval is queued, that processing it
+// records the verification timestamp and that it's queued again once
+// the interval has elapsed.
+func TestConsistencyQueue(t *testing.T) {
+	s, rng, manual, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+	manual.Set(int64(2 * time.Hour))
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1, s.StoreID())
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	cq := newConsistencyQueue(clock)
+	cq.interval = time.Hour
+	if should, priority := cq.shouldQueue(rng); !should || priority < 1 {
+		t.Fatalf("expected unverified range to be queued; got %t, %f", should, priority)
+	}
+	if err := cq.process(rng); err != nil {
+		t.Fatal(err)
+	}
+	// The range's second replica has no store in this test; report the
+	// same checksum on its behalf to give the check a majority.
+	rng.RLock()
+	c := rng.checksum
+	rng.RUnlock()
+	if c == nil {
+		t.Fatal("expected check to await the second replica's checksum")
+	}
+	reportTestChecksum(t, rng, clock, c.id, 2, c.checksum)
+	lastVerified, err := rng.LastVerified()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastVerified.WallTime != int64(2*time.Hour) {
+		t.Errorf("expected last verified at %d; got %s", int64(2*time.Hour), lastVerified)
+	}
+	if should, _ := cq.shouldQueue(rng); should {
+		t.Error("expected verified range not to be queued")
+	}
+
+	manual.Increment(int64(3 * time.Hour))
+	if should, priority := cq.shouldQueue(rng); !should || priority != 3 {
+		t.Errorf("expected range to be queued at priority 3; got %t, %f", should, priority)
+	}
+
+	// A zero interval disables consistency checks.
+	cq.interval = 0
+	if should, _ := cq.shouldQueue(rng); should {
+		t.Error("expected range not to be queued with checks disabled")
+	}
+}
+
+// reportTestChecksum has the range apply a report of the checksum
+// computed for the consistency check with the specified ID by the
+// range's replica on the specified store.
+func reportTestChecksum(t *testing.T, rng *Range, clock *hlc.Clock, id string, storeID int32, checksum uint32) {
+	desc := rng.Descriptor()
+	args := &proto.InternalVerifyChecksumRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       desc.StartKey,
+			Timestamp: clock.Now(),
+			RaftID:    desc.RaftID,
+			Replica:   *desc.FindReplica(storeID),
+		},
+		ChecksumID: id,
+		Checksum:   checksum,
+	}
+	if err := rng.AddCmd(proto.InternalVerifyChecksum, args, &proto.InternalVerifyChecksumResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTestConsistencyCheck gives the range three replicas, the first
+// of them on the test store, and has that replica propose a
+// consistency check with the specified ID. Returns the local replica's
+// checksum once computed.
+func startTestConsistencyCheck(t *testing.T, s *Store, rng *Range, clock *hlc.Clock, id string) uint32 {
+	rng.Lock()
+	desc := *rng.Desc
+	desc.Replicas = []proto.Replica{
+		{NodeID: 1, StoreID: s.StoreID()},
+		{NodeID: 2, StoreID: 2},
+		{NodeID: 3, StoreID: 3},
+	}
+	rng.Desc = &desc
+	rng.Unlock()
+
+	computeArgs := &proto.InternalComputeChecksumRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       desc.StartKey,
+			Timestamp: clock.Now(),
+			RaftID:    desc.RaftID,
+			Replica:   desc.Replicas[0],
+		},
+		ChecksumID: id,
+	}
+	if err := rng.AddCmd(proto.InternalComputeChecksum, computeArgs, &proto.InternalComputeChecksumResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := rng.waitForChecksum(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checksum
+}
+
+// TestConsistencyCheckDivergence verifies that a replica whose checksum
+// differs from the one reported by a majority of the range's replicas
+// is marked corrupted, and not before the majority is known.
+func TestConsistencyCheckDivergence(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	checksum := startTestConsistencyCheck(t, s, rng, clock, "test")
+	if _, err := rng.waitForChecksum("other"); err == nil {
+		t.Error("expected error waiting for unknown checksum")
+	}
+
+	reportTestChecksum(t, rng, clock, "test", 2, checksum+1)
+	if rng.IsCorrupt() {
+		t.Fatal("expected range not to be marked corrupt without a majority")
+	}
+	reportTestChecksum(t, rng, clock, "test", 3, checksum+1)
+	if !rng.IsCorrupt() {
+		t.Error("expected diverged range to be marked corrupt")
+	}
+	gArgs, gReply := getArgs(rng.Desc.StartKey, rng.Desc.RaftID, s.StoreID())
+	if _, ok := rng.AddCmd(proto.Get, gArgs, gReply, true).(*proto.ReplicaCorruptionError); !ok {
+		t.Errorf("expected diverged range to reject commands; got %v", gReply.GoError())
+	}
+	rng.RLock()
+	defer rng.RUnlock()
+	if rng.checksum != nil {
+		t.Error("expected checksum to be cleared after verification")
+	}
+}
+
+// TestConsistencyCheckMajority verifies that a replica agreeing with
+// the majority isn't marked corrupted when another replica diverges,
+// that the majority's agreement is recorded as the range's last
+// verified timestamp, and that a check without a majority is
+// abandoned.
+func TestConsistencyCheckMajority(t *testing.T) {
+	s, rng, manual, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+	manual.Set(int64(time.Hour))
+
+	checksum := startTestConsistencyCheck(t, s, rng, clock, "agree")
+	reportTestChecksum(t, rng, clock, "agree", 2, checksum+1)
+	reportTestChecksum(t, rng, clock, "agree", 3, checksum)
+	if lastVerified, err := rng.LastVerified(); err != nil || !lastVerified.Equal(proto.ZeroTimestamp) {
+		t.Fatalf("expected range not to be verified without a majority; got %s, %v", lastVerified, err)
+	}
+	reportTestChecksum(t, rng, clock, "agree", s.StoreID(), checksum)
+	if rng.IsCorrupt() {
+		t.Error("expected range agreeing with the majority not to be marked corrupt")
+	}
+	if lastVerified, err := rng.LastVerified(); err != nil || lastVerified.WallTime != int64(time.Hour) {
+		t.Errorf("expected range to be verified at %d; got %s, %v", int64(time.Hour), lastVerified, err)
+	}
+
+	checksum = startTestConsistencyCheck(t, s, rng, clock, "disagree")
+	reportTestChecksum(t, rng, clock, "disagree", s.StoreID(), checksum)
+	reportTestChecksum(t, rng, clock, "disagree", 2, checksum+1)
+	reportTestChecksum(t, rng, clock, "disagree", 3, checksum+2)
+	if rng.IsCorrupt() {
+		t.Error("expected range not to be marked corrupt without a majority")
+	}
+	rng.RLock()
+	defer rng.RUnlock()
+	if rng.checksum != nil {
+		t.Error("expected check without a majority to be abandoned")
+	}
+}
+
+// TestRangeSnapshotChecksum verifies that the checksum of a range's
+// replicated data is stable, changes with the data and with
+// range-local keys such as range tombstones, and ignores the last
+// verified timestamp.
+func TestRangeSnapshotChecksum(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	checksum := func() uint32 {
+		snap, err := s.Engine().NewSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snap.Stop()
+		c, err := rng.snapshotChecksum(snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	c1 := checksum()
+	if c2 := checksum(); c1 != c2 {
+		t.Errorf("expected stable checksum; got %08x and %08x", c1, c2)
+	}
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1, s.StoreID())
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	c3 := checksum()
+	if c1 == c3 {
+		t.Errorf("expected checksum to change after put; got %08x", c3)
+	}
+
+	if err := engine.MVCCDeleteRangeTombstone(s.Engine(), nil, proto.Key("b"), proto.Key("c"), clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	c4 := checksum()
+	if c3 == c4 {
+		t.Errorf("expected checksum to change after range tombstone; got %08x", c4)
+	}
+
+	ts := clock.Now()
+	if err := engine.MVCCPutProto(s.Engine(), nil, engine.RangeLastVerifiedKey(rng.Desc.RaftID),
+		proto.ZeroTimestamp, nil, &ts); err != nil {
+		t.Fatal(err)
+	}
+	if c5 := checksum(); c4 != c5 {
+		t.Errorf("expected last verified timestamp not to change checksum; got %08x and %08x", c4, c5)
+	}
+}
+
+// TestByteRateLimiter verifies that reads are paced to the limiter's
+// rate as measured by its clock, and that a sleeping limiter returns
+// once closed.
+func TestByteRateLimiter(t *testing.T) {
+	manual := hlc.NewManualClock(0)
+	clock := hlc.NewClock(manual.UnixNano)
+	l := newByteRateLimiter(10000, clock, make(chan struct{}))
+
+	// A reader which isn't ahead of the rate by the clock isn't paced.
+	manual.Increment((100 * time.Millisecond).Nanoseconds())
+	start := time.Now()
+	if err := l.wait(1000); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= 90*time.Millisecond {
+		t.Errorf("expected no wait; got %s", elapsed)
+	}
+
+	// Once ahead, the reader sleeps for the difference.
+	start = time.Now()
+	if err := l.wait(1000); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected wait of ~100ms; got %s", elapsed)
+	}
+
+	close(l.closer)
+	if err := l.wait(1000000); err == nil {
+		t.Error("expected error from closed limiter")
+	}
+
+	// A zero rate disables the limit.
+	l = newByteRateLimiter(0, clock, nil)
+	if err := l.wait(1 << 30); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -24,43 +24,112 @@ import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
+	gogoproto "github.com/gogo/protobuf/proto"
 )
 
 type metaAction func(*client.KV, proto.Key, *proto.RangeDescriptor) error
 
-func putMeta(db *client.KV, key proto.Key, desc *proto.RangeDescriptor) error {
-	return db.PreparePutProto(key, desc)
+// SplitRangeAddressing updates the meta1 and meta2 range addressing
+// records for the left and right ranges created by splitting orig.
+// Each record is written with a conditional put which expects the
+// record orig had at the same key, or no record at all, so that
+// concurrent updates of the addressing records fail the split.
+func SplitRangeAddressing(db *client.KV, orig, left, right *proto.RangeDescriptor) error {
+	return replaceRangeAddressing(db, []*proto.RangeDescriptor{orig}, []*proto.RangeDescriptor{left, right})
 }
 
-func delMeta(db *client.KV, key proto.Key, desc *proto.RangeDescriptor) error {
-	db.Prepare(proto.Delete, proto.DeleteArgs(key), &proto.DeleteResponse{})
-	return nil
+// MergeRangeAddressing removes subsumed meta1 and meta2 range
+// addressing records caused by merging the left and right ranges and
+// updates the records for the new merged range. As with splits, the
+// records for the merged range are written with conditional puts
+// which expect the records of the left or right range.
+func MergeRangeAddressing(db *client.KV, left, right, merged *proto.RangeDescriptor) error {
+	return replaceRangeAddressing(db, []*proto.RangeDescriptor{left, right}, []*proto.RangeDescriptor{merged})
 }
 
-// SplitRangeAddressing creates (or overwrites if necessary) the meta1
-// and meta2 range addressing records for the left and right ranges
-// caused by a split.
-func SplitRangeAddressing(db *client.KV, left, right *proto.RangeDescriptor) error {
-	if err := updateRangeAddressing(db, left, putMeta); err != nil {
-		return err
+// replaceRangeAddressing replaces the addressing records of the
+// descriptors in prev with those of the descriptors in next. Records
+// of next are written with conditional puts expecting the prev record
+// at the same key, if any. Records of prev which are not overwritten
+// are deleted.
+func replaceRangeAddressing(db *client.KV, prev, next []*proto.RangeDescriptor) error {
+	var prevKeys []proto.Key
+	expected := map[string]*proto.RangeDescriptor{}
+	for _, desc := range prev {
+		if err := updateRangeAddressing(db, desc, func(_ *client.KV, key proto.Key, desc *proto.RangeDescriptor) error {
+			if _, ok := expected[string(key)]; !ok {
+				prevKeys = append(prevKeys, key)
+			}
+			expected[string(key)] = desc
+			return nil
+		}); err != nil {
+			return err
+		}
 	}
-	if err := updateRangeAddressing(db, right, putMeta); err != nil {
-		return err
+	written := map[string]struct{}{}
+	for _, desc := range next {
+		if err := updateRangeAddressing(db, desc, func(db *client.KV, key proto.Key, desc *proto.RangeDescriptor) error {
+			written[string(key)] = struct{}{}
+			if expDesc, ok := expected[string(key)]; ok {
+				return condPutProto(db, key, desc, expDesc)
+			}
+			return condPutProto(db, key, desc, nil)
+		}); err != nil {
+			return err
+		}
+	}
+	for _, key := range prevKeys {
+		if _, ok := written[string(key)]; !ok {
+			db.Prepare(proto.Delete, proto.DeleteArgs(key), &proto.DeleteResponse{})
+		}
 	}
 	return nil
 }
 
-// MergeRangeAddressing removes subsumed meta1 and meta2 range
-// addressing records caused by merging and updates the records for
-// the new merged range. Left is the range descriptor for the "left"
-// range before merging and merged describes the left to right merge.
-func MergeRangeAddressing(db *client.KV, left, merged *proto.RangeDescriptor) error {
-	if err := updateRangeAddressing(db, left, delMeta); err != nil {
+// condPutProto prepares a conditional put of msg to key, provided the
+// key's current value decodes to a message equal to expMsg. The
+// messages are compared decoded, as the stored bytes needn't match
+// expMsg's encoding (e.g. if written by a version which encoded
+// fields since added or dropped). The bytes read then serve as the
+// conditional put's expected value, so the put still fails if the key
+// changes after the read.
+//
+// A nil expMsg specifies that the key must not exist. It must be
+// passed as an untyped nil, not a nil pointer, and is sent as a
+// conditional put without expected value, which fails if the key has
+// a value.
+func condPutProto(db *client.KV, key proto.Key, msg, expMsg gogoproto.Message) error {
+	if expMsg == nil {
+		return db.PrepareConditionalPutProto(key, msg, nil)
+	}
+	reply := &proto.GetResponse{}
+	if err := db.Call(proto.Get, &proto.GetRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+	}, reply); err != nil {
 		return err
 	}
-	if err := updateRangeAddressing(db, merged, putMeta); err != nil {
+	if reply.Value == nil {
+		return &proto.ConditionFailedError{}
+	}
+	actual := gogoproto.Clone(expMsg)
+	actual.Reset()
+	if err := gogoproto.Unmarshal(reply.Value.Bytes, actual); err != nil {
+		return util.Errorf("unable to decode value at key %q: %s", key, err)
+	}
+	if !gogoproto.Equal(actual, expMsg) {
+		return &proto.ConditionFailedError{ActualValue: reply.Value}
+	}
+	data, err := gogoproto.Marshal(msg)
+	if err != nil {
 		return err
 	}
+	value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}}
+	value.InitChecksum(key)
+	db.Prepare(proto.ConditionalPut, &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		Value:         value,
+		ExpValue:      &proto.Value{ValuePayload: proto.ValuePayload{Bytes: reply.Value.Bytes}},
+	}, &proto.ConditionalPutResponse{})
 	return nil
 }
 
@@ -23,6 +23,7 @@ dependencies.
 package storage_test
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 	"sort"
@@ -91,7 +92,7 @@ func putArgs(key, value []byte, raftID int64, storeID int32) (*proto.PutRequest,
 			Replica: proto.Replica{StoreID: storeID},
 		},
 		Value: proto.Value{
-			Bytes: value,
+			ValuePayload: proto.ValuePayload{Bytes: value},
 		},
 	}
 	reply := &proto.PutResponse{}
@@ -188,34 +189,55 @@ func TestUpdateRangeAddressing(t *testing.T) {
 		{false, engine.KeyMin, proto.Key("a"), engine.KeyMin, engine.KeyMax,
 			[]proto.Key{meta2Key(proto.Key("a"))}, []proto.Key{meta1Key(engine.KeyMax), meta2Key(engine.KeyMax)}},
 	}
-	expMetas := metaSlice{}
+	// scanMetas scans meta keys directly from engine.
+	scanMetas := func() metaSlice {
+		kvs, err := engine.MVCCScan(store.Engine(), engine.KeyMetaPrefix, engine.KeyMetaMax, 0, proto.MaxTimestamp, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metas := metaSlice{}
+		for _, kv := range kvs {
+			scannedDesc := &proto.RangeDescriptor{}
+			if err := gogoproto.Unmarshal(kv.Value.Bytes, scannedDesc); err != nil {
+				t.Fatal(err)
+			}
+			metas = append(metas, metaRecord{key: kv.Key, desc: scannedDesc})
+		}
+		return metas
+	}
+	// The bootstrap addressing records are expected to start.
+	expMetas := scanMetas()
+	// lookupMeta returns the expected addressing record for the range
+	// ending at end. Addressing records are updated conditionally on the
+	// current records of the ranges being split or merged.
+	lookupMeta := func(end proto.Key) *proto.RangeDescriptor {
+		key := engine.RangeMetaLookupKey(&proto.RangeDescriptor{EndKey: end})
+		for _, meta := range expMetas {
+			if meta.key.Equal(key) {
+				return meta.desc
+			}
+		}
+		t.Fatalf("no addressing record at %q", key)
+		return nil
+	}
 
 	for i, test := range testCases {
 		left := &proto.RangeDescriptor{RaftID: int64(i * 2), StartKey: test.leftStart, EndKey: test.leftEnd}
 		right := &proto.RangeDescriptor{RaftID: int64(i*2 + 1), StartKey: test.rightStart, EndKey: test.rightEnd}
 		if test.split {
-			if err := storage.SplitRangeAddressing(store.DB(), left, right); err != nil {
+			orig := lookupMeta(test.rightEnd)
+			if err := storage.SplitRangeAddressing(store.DB(), orig, left, right); err != nil {
 				t.Fatal(err)
 			}
 		} else {
-			if err := storage.MergeRangeAddressing(store.DB(), left, right); err != nil {
+			if err := storage.MergeRangeAddressing(store.DB(), lookupMeta(test.leftEnd), lookupMeta(test.rightEnd), right); err != nil {
 				t.Fatal(err)
 			}
 		}
-		store.DB().Flush()
-		// Scan meta keys directly from engine.
-		kvs, err := engine.MVCCScan(store.Engine(), engine.KeyMetaPrefix, engine.KeyMetaMax, 0, proto.MaxTimestamp, nil)
-		if err != nil {
+		if err := store.DB().Flush(); err != nil {
 			t.Fatal(err)
 		}
-		metas := metaSlice{}
-		for _, kv := range kvs {
-			scannedDesc := &proto.RangeDescriptor{}
-			if err := gogoproto.Unmarshal(kv.Value.Bytes, scannedDesc); err != nil {
-				t.Fatal(err)
-			}
-			metas = append(metas, metaRecord{key: kv.Key, desc: scannedDesc})
-		}
+		metas := scanMetas()
 
 		// Continue to build up the expected metas slice, replacing any earlier
 		// version of same key.
@@ -275,7 +297,63 @@ func TestUpdateRangeAddressingSplitMeta1(t *testing.T) {
 	store := createTestStore(t)
 	left := &proto.RangeDescriptor{StartKey: engine.KeyMin, EndKey: meta1Key(proto.Key("a"))}
 	right := &proto.RangeDescriptor{StartKey: meta1Key(proto.Key("a")), EndKey: engine.KeyMax}
-	if err := storage.SplitRangeAddressing(store.DB(), left, right); err == nil {
+	orig := &proto.RangeDescriptor{StartKey: engine.KeyMin, EndKey: engine.KeyMax}
+	if err := storage.SplitRangeAddressing(store.DB(), orig, left, right); err == nil {
 		t.Error("expected failure trying to update addressing records for meta1 split")
 	}
 }
+
+// TestSplitRangeAddressingEncoding verifies that an addressing record
+// is replaced if it decodes to the expected descriptor, even though
+// its encoding differs from the expected descriptor's, and isn't
+// replaced if it decodes to a different descriptor.
+func TestSplitRangeAddressingEncoding(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+	orig := &proto.RangeDescriptor{RaftID: 10, StartKey: proto.Key("a"), EndKey: proto.Key("z")}
+	left := &proto.RangeDescriptor{RaftID: 10, StartKey: proto.Key("a"), EndKey: proto.Key("m")}
+	right := &proto.RangeDescriptor{RaftID: 11, StartKey: proto.Key("m"), EndKey: proto.Key("z")}
+
+	// Write the record without its zero generation, as it was encoded
+	// before the field was introduced.
+	data, err := gogoproto.Marshal(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(data, []byte{0x28, 0x00}) {
+		t.Fatalf("expected encoding to end with zero generation; got %q", data)
+	}
+	data = data[:len(data)-2]
+	key := meta2Key(orig.EndKey)
+	value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}}
+	value.InitChecksum(key)
+	if err := store.DB().Call(proto.Put, &proto.PutRequest{
+		RequestHeader: proto.RequestHeader{Key: key},
+		Value:         value,
+	}, &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	split := func(expDesc *proto.RangeDescriptor) error {
+		return store.DB().RunTransaction(&client.TransactionOptions{Name: "split"}, func(txn *client.KV) error {
+			return storage.SplitRangeAddressing(txn, expDesc, left, right)
+		})
+	}
+	stale := *orig
+	stale.Generation = 1
+	if err := split(&stale); err == nil {
+		t.Fatal("expected split expecting a different descriptor to fail")
+	}
+	if err := split(orig); err != nil {
+		t.Fatal(err)
+	}
+	for _, desc := range []*proto.RangeDescriptor{left, right} {
+		scannedDesc := &proto.RangeDescriptor{}
+		if ok, _, err := store.DB().GetProto(meta2Key(desc.EndKey), scannedDesc); err != nil || !ok {
+			t.Fatalf("unable to read addressing record for %s: %t, %v", desc, ok, err)
+		}
+		if !reflect.DeepEqual(scannedDesc, desc) {
+			t.Errorf("expected addressing record %s; got %s", desc, scannedDesc)
+		}
+	}
+}
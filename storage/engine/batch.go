@@ -209,29 +209,19 @@ func (b *Batch) Capacity() (StoreCapacity, error) {
 func (b *Batch) SetGCTimeouts(minTxnTS, minRCacheTS int64) {
 }
 
-// CreateSnapshot returns an error if called on a Batch.
-func (b *Batch) CreateSnapshot(snapshotID string) error {
-	return util.Errorf("cannot create a snapshot from a Batch")
-}
-
-// ReleaseSnapshot returns an error if called on a Batch.
-func (b *Batch) ReleaseSnapshot(snapshotID string) error {
-	return util.Errorf("cannot release a snapshot from a Batch")
-}
-
-// GetSnapshot returns an error if called on a Batch.
-func (b *Batch) GetSnapshot(key proto.EncodedKey, snapshotID string) ([]byte, error) {
-	return nil, util.Errorf("cannot get with a snapshot from a Batch")
+// ApproximateSize returns an error if called on a Batch.
+func (b *Batch) ApproximateSize(start, end proto.EncodedKey) (uint64, error) {
+	return 0, util.Errorf("cannot get approximate size from a Batch")
 }
 
-// IterateSnapshot returns an error if called on a Batch.
-func (b *Batch) IterateSnapshot(start, end proto.EncodedKey, snapshotID string, f func(proto.RawKeyValue) (bool, error)) error {
-	return util.Errorf("cannot iterate with a snapshot from a Batch")
+// ApproximateKeyCount returns an error if called on a Batch.
+func (b *Batch) ApproximateKeyCount(start, end proto.EncodedKey) (uint64, error) {
+	return 0, util.Errorf("cannot get approximate key count from a Batch")
 }
 
-// ApproximateSize returns an error if called on a Batch.
-func (b *Batch) ApproximateSize(start, end proto.EncodedKey) (uint64, error) {
-	return 0, util.Errorf("cannot get approximate size from a Batch")
+// CompactionStats returns an error if called on a Batch.
+func (b *Batch) CompactionStats() (CompactionStats, error) {
+	return CompactionStats{}, util.Errorf("cannot get compaction stats from a Batch")
 }
 
 // NewIterator returns an iterator over Batch. Batch iterators are
@@ -245,6 +235,12 @@ func (b *Batch) NewBatch() Engine {
 	return &Batch{engine: b.engine}
 }
 
+// NewSnapshot returns a snapshot of the wrapped engine. Updates
+// pending in the batch aren't visible through the snapshot.
+func (b *Batch) NewSnapshot() (Engine, error) {
+	return b.engine.NewSnapshot()
+}
+
 type batchIterator struct {
 	iter    Iterator
 	updates *llrb.Tree
@@ -272,6 +268,50 @@ func (bi *batchIterator) Seek(key []byte) {
 	bi.mergeUpdates(key)
 }
 
+func (bi *batchIterator) SeekReverse(key []byte) {
+	bi.pending = []proto.RawKeyValue{}
+	bi.err = nil
+	bound := proto.EncodedKey(key)
+	// Step backwards through the engine and the batch updates until a
+	// key which hasn't been deleted by the batch is found.
+	for {
+		bi.iter.SeekReverse(bound)
+		var kv *proto.RawKeyValue
+		if bi.iter.Valid() {
+			kv = &proto.RawKeyValue{Key: bi.iter.Key(), Value: bi.iter.Value()}
+		}
+		update := llrbLastBefore(*bi.updates, bound)
+		if update == nil && kv == nil {
+			return
+		}
+		if update == nil || (kv != nil && bytes.Compare(update.(proto.KeyGetter).KeyGet(), kv.Key) < 0) {
+			bi.pending = append(bi.pending, *kv)
+			break
+		}
+		switch t := update.(type) {
+		case BatchDelete:
+			bound = t.Key
+			continue
+		case BatchPut:
+			bi.pending = append(bi.pending, t.RawKeyValue)
+		case BatchMerge:
+			var existing []byte
+			if kv != nil && kv.Key.Equal(t.Key) {
+				existing = kv.Value
+			}
+			mergedKV := proto.RawKeyValue{Key: t.Key}
+			if mergedKV.Value, bi.err = goMerge(existing, t.Value); bi.err != nil {
+				return
+			}
+			bi.pending = append(bi.pending, mergedKV)
+		}
+		break
+	}
+	// Position the engine iterator just past the result so that Next()
+	// continues forward from here.
+	bi.iter.Seek(bi.pending[0].Key.Next())
+}
+
 func (bi *batchIterator) Valid() bool {
 	return bi.err == nil && len(bi.pending) > 0
 }
@@ -357,6 +357,62 @@ func TestBatchScanMaxWithDeleted(t *testing.T) {
 	}
 }
 
+// TestBatchSeekReverse verifies that a batch iterator positioned by
+// SeekReverse takes puts, deletes and merges in the batch into account.
+func TestBatchSeekReverse(t *testing.T) {
+	e := NewInMem(proto.Attributes{}, 1<<20)
+	b := e.NewBatch()
+	for _, key := range []string{"a", "c", "e"} {
+		if err := e.Put(proto.EncodedKey(key), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Delete "c" and "e" and add "b" in the batch.
+	if err := b.Clear(proto.EncodedKey("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Clear(proto.EncodedKey("e")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(proto.EncodedKey("b"), []byte("batch")); err != nil {
+		t.Fatal(err)
+	}
+
+	iter := b.NewIterator()
+	defer iter.Close()
+	testCases := []struct {
+		key, expKey string
+	}{
+		{"", "b"},
+		{"f", "b"},
+		{"c", "b"},
+		{"b", "a"},
+		{"a", ""},
+	}
+	for i, test := range testCases {
+		iter.SeekReverse([]byte(test.key))
+		if test.expKey == "" {
+			if iter.Valid() {
+				t.Errorf("%d: expected invalid iterator; got %q", i, iter.Key())
+			}
+			continue
+		}
+		if !iter.Valid() || string(iter.Key()) != test.expKey {
+			t.Errorf("%d: expected key %q; got valid=%t", i, test.expKey, iter.Valid())
+		}
+	}
+
+	// Forward iteration resumes after the reverse seek position.
+	iter.SeekReverse([]byte("b"))
+	var keys []string
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("expected keys [a b]; got %v", keys)
+	}
+}
+
 // TestBatchConcurrency verifies operation of batch when the
 // underlying engine has concurrent modifications to overlapping
 // keys. This should never happen with the way Cockroach uses
@@ -68,13 +68,20 @@ that all timestamp-suffixed MVCC version keys sort consecutively with
 the metadata key. It should be noted that the 7-bit binary encoding is
 distasteful and we'd like to substitute it with something which
 preserves at least 7-bit ascii visibility, but has the same sort
-properties. We considered using RocksDB's custom key comparator
-functionality, but the attendant risks seemed too great. What risks?
-Mostly that RocksDB is unlikely to have tested custom key comparators
-with their more advanced (and ever-growing) functionality. Further,
-bugs in our code (both C++ and Go) related to the custom comparator
-seemed more likely to be painful than just dealing with the 7-bit
-binary encoding.
+properties. RocksDB engines install a custom key comparator
+(engine.MVCCComparator in Go, mirrored in C++) which decodes MVCC
+keys and orders them by key ascending and timestamp descending, with
+the metadata key first. For keys in the current encoding this
+ordering coincides with bytewise ordering, and keys which aren't
+well-formed MVCC keys are compared bytewise, so the comparator is
+safe to use with existing data. For the same reason it keeps the name
+of RocksDB's default bytewise comparator, which RocksDB records in
+each database and checks on open; it must be renamed if the ordering
+ever diverges from bytewise for keys already on disk. The Go and C++
+implementations are verified against each other in tests, which
+mitigates the risk that bugs in either diverge. Having the ordering enforced by the engine
+frees us to substitute the key encoding without relying on the
+timestamp encoding to produce the right sort order.
 
 We considered inlining the most recent MVCC version in the
 MVCCMetadata. This would reduce the storage overhead of storing the
@@ -32,6 +32,15 @@ type StoreCapacity struct {
 	Available int64
 }
 
+// CompactionStats contains compaction details for an engine. A large
+// number of files at level 0 means reads must consult many overlapping
+// files (read amplification) and that RocksDB is falling behind on
+// compactions; past its own thresholds RocksDB stalls writes entirely.
+type CompactionStats struct {
+	L0FileCount       int64
+	PendingCompaction bool
+}
+
 // PercentAvail computes the percentage of disk space that is available.
 func (sc StoreCapacity) PercentAvail() float64 {
 	return float64(sc.Available) / float64(sc.Capacity)
@@ -46,6 +55,11 @@ type Iterator interface {
 	// Seek advances the iterator to the first key in the engine which
 	// is >= the provided key.
 	Seek(key []byte)
+	// SeekReverse positions the iterator at the last key in the engine
+	// which is < the provided key, or at the last key in the engine if
+	// key is empty. The iterator is invalid if there is no such key.
+	// Iteration continues forward from the new position via Next().
+	SeekReverse(key []byte)
 	// Valid returns true if the iterator is currently valid. An
 	// iterator which hasn't been seeked or has gone past the end of the
 	// key range is invalid.
@@ -64,9 +78,6 @@ type Iterator interface {
 
 // Engine is the interface that wraps the core operations of a
 // key/value store.
-// TODO(Jiang-Ming,Spencer): Remove some of the *Snapshot methods and have
-// their non-snapshot counterparts accept a snapshotID which is used unless
-// empty.
 type Engine interface {
 	// Start initializes and starts the engine.
 	Start() error
@@ -115,20 +126,15 @@ type Engine interface {
 	// Rows with timestamps less than the associated value will be GC'd
 	// during compaction.
 	SetGCTimeouts(minTxnTS, minRCacheTS int64)
-	// CreateSnapshot creates a snapshot handle from engine.
-	CreateSnapshot(snapshotID string) error
-	// ReleaseSnapshot releases the existing snapshot handle for the
-	// given snapshotID.
-	ReleaseSnapshot(snapshotID string) error
-	// GetSnapshot returns the value for the given key from the given
-	// snapshotID, nil otherwise.
-	GetSnapshot(key proto.EncodedKey, snapshotID string) ([]byte, error)
-	// IterateSnapshot scans from start to end keys, visiting at
-	// most max key/value pairs from the specified snapshot ID.
-	IterateSnapshot(start, end proto.EncodedKey, snapshotID string, f func(proto.RawKeyValue) (bool, error)) error
 	// ApproximateSize returns the approximate number of bytes the engine is
 	// using to store data for the given range of keys.
 	ApproximateSize(start, end proto.EncodedKey) (uint64, error)
+	// ApproximateKeyCount returns the approximate number of keys the
+	// engine is storing in the given range of keys.
+	ApproximateKeyCount(start, end proto.EncodedKey) (uint64, error)
+	// CompactionStats returns the engine's current level 0 file count
+	// and whether a compaction is pending.
+	CompactionStats() (CompactionStats, error)
 	// NewIterator returns a new instance of an Iterator over this
 	// engine. The caller must invoke Iterator.Close() when finished with
 	// the iterator to free resources.
@@ -140,11 +146,17 @@ type Engine interface {
 	// Commit atomically applies any batched updates to the underlying
 	// engine. This is a noop unless the engine was created via NewBatch().
 	Commit() error
+	// NewSnapshot returns a new instance of a read-only, point-in-time
+	// view of this engine. Writes to this engine after the call aren't
+	// visible through the snapshot, and writes to the snapshot itself
+	// return an error. The caller must invoke Stop() on the snapshot
+	// when finished with it to free resources; reads from a stopped
+	// snapshot return an error. Snapshots can't be taken of a
+	// snapshot.
+	NewSnapshot() (Engine, error)
 
 	// TODO(petermattis): Remove the WriteBatch functionality from this
-	//   interface. Add a "NewSnapshot() Engine" method which works
-	//   similarly to NewBatch and remove CreateSnapshot, GetSnapshot,
-	//   IterateSnapshot.
+	//   interface.
 }
 
 // A BatchDelete is a delete operation executed as part of an atomic batch.
@@ -258,19 +270,6 @@ func Scan(engine Engine, start, end proto.EncodedKey, max int64) ([]proto.RawKey
 	return kvs, err
 }
 
-// ScanSnapshot scans using the given snapshot ID.
-func ScanSnapshot(engine Engine, start, end proto.EncodedKey, max int64, snapshotID string) ([]proto.RawKeyValue, error) {
-	var kvs []proto.RawKeyValue
-	err := engine.IterateSnapshot(start, end, snapshotID, func(kv proto.RawKeyValue) (bool, error) {
-		if max != 0 && int64(len(kvs)) >= max {
-			return true, nil
-		}
-		kvs = append(kvs, kv)
-		return false, nil
-	})
-	return kvs, err
-}
-
 // ClearRange removes a set of entries, from start (inclusive) to end
 // (exclusive). This function returns the number of entries
 // removed. Either all entries within the range will be deleted, or
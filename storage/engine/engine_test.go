@@ -57,7 +57,7 @@ func runWithAllEngines(test func(e Engine, t *testing.T), t *testing.T) {
 	inMem := NewInMem(proto.Attributes{}, 10<<20)
 
 	loc := fmt.Sprintf("%s/data_%d", os.TempDir(), time.Now().UnixNano())
-	rocksdb := NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc)
+	rocksdb := NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc, DefaultRocksDBOptions())
 	err := rocksdb.Start()
 	if err != nil {
 		t.Fatalf("could not create new rocksdb db instance at %s: %v", loc, err)
@@ -251,7 +251,7 @@ func TestEnginePutGetDelete(t *testing.T) {
 }
 
 // TestEngineMerge tests that the passing through of engine merge operations
-// to the goMerge function works as expected. The semantics are tested more
+// to the merge operator works as expected. The semantics are tested more
 // exhaustively in the merge tests themselves.
 func TestEngineMerge(t *testing.T) {
 	runWithAllEngines(func(engine Engine, t *testing.T) {
@@ -464,6 +464,56 @@ func TestEngineScan2(t *testing.T) {
 	}, t)
 }
 
+// TestEngineSeekReverse verifies that iterators can be positioned at
+// the key preceding a seek key and continue forward from there.
+func TestEngineSeekReverse(t *testing.T) {
+	runWithAllEngines(func(engine Engine, t *testing.T) {
+		insertKeys([]proto.EncodedKey{
+			proto.EncodedKey("a"),
+			proto.EncodedKey("b"),
+			proto.EncodedKey("d"),
+		}, engine, t)
+
+		iter := engine.NewIterator()
+		defer iter.Close()
+		testCases := []struct {
+			key, expKey, expNext string
+		}{
+			{"", "d", ""},
+			{"a", "", ""},
+			{"aa", "a", "b"},
+			{"b", "a", "b"},
+			{"c", "b", "d"},
+			{"d", "b", "d"},
+			{"e", "d", ""},
+		}
+		for i, test := range testCases {
+			iter.SeekReverse([]byte(test.key))
+			if test.expKey == "" {
+				if iter.Valid() {
+					t.Errorf("%d: expected invalid iterator; got %q", i, iter.Key())
+				}
+				continue
+			}
+			if !iter.Valid() || string(iter.Key()) != test.expKey {
+				t.Errorf("%d: expected key %q; got valid=%t", i, test.expKey, iter.Valid())
+				continue
+			}
+			iter.Next()
+			if test.expNext == "" {
+				if iter.Valid() {
+					t.Errorf("%d: expected invalid iterator after next; got %q", i, iter.Key())
+				}
+			} else if !iter.Valid() || string(iter.Key()) != test.expNext {
+				t.Errorf("%d: expected next key %q; got valid=%t", i, test.expNext, iter.Valid())
+			}
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal(err)
+		}
+	}, t)
+}
+
 func TestEngineDeleteRange(t *testing.T) {
 	runWithAllEngines(func(engine Engine, t *testing.T) {
 		keys := []proto.EncodedKey{
@@ -506,16 +556,16 @@ func TestSnapshot(t *testing.T) {
 				val, val1)
 		}
 
-		snapshotID := strconv.FormatInt(1, 10)
-		error := engine.CreateSnapshot(snapshotID)
+		snap, error := engine.NewSnapshot()
 		if error != nil {
 			t.Fatalf("error : %s", error)
 		}
+		defer snap.Stop()
 
 		val2 := []byte("2")
 		engine.Put(key, val2)
 		val, _ = engine.Get(key)
-		valSnapshot, error := engine.GetSnapshot(key, snapshotID)
+		valSnapshot, error := snap.Get(key)
 		if error != nil {
 			t.Fatalf("error : %s", error)
 		}
@@ -529,7 +579,7 @@ func TestSnapshot(t *testing.T) {
 		}
 
 		keyvals, _ := Scan(engine, key, proto.EncodedKey(KeyMax), 0)
-		keyvalsSnapshot, error := ScanSnapshot(engine, key, proto.EncodedKey(KeyMax), 0, snapshotID)
+		keyvalsSnapshot, error := Scan(snap, key, proto.EncodedKey(KeyMax), 0)
 		if error != nil {
 			t.Fatalf("error : %s", error)
 		}
@@ -541,8 +591,96 @@ func TestSnapshot(t *testing.T) {
 			t.Fatalf("the value %s in get result does not match the value %s in request",
 				keyvalsSnapshot[0].Value, val1)
 		}
+	}, t)
+}
+
+// TestNewSnapshot verifies that a snapshot is a read-only view of
+// the engine as of its creation, via gets, iteration and iterators.
+func TestNewSnapshot(t *testing.T) {
+	runWithAllEngines(func(engine Engine, t *testing.T) {
+		key := proto.EncodedKey("a")
+		val1, val2 := []byte("1"), []byte("2")
+		if err := engine.Put(key, val1); err != nil {
+			t.Fatal(err)
+		}
+		snap, err := engine.NewSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snap.Stop()
+		if err := engine.Put(key, val2); err != nil {
+			t.Fatal(err)
+		}
+		if err := engine.Put(proto.EncodedKey("b"), val2); err != nil {
+			t.Fatal(err)
+		}
 
-		engine.ReleaseSnapshot(snapshotID)
+		if val, err := snap.Get(key); err != nil || !bytes.Equal(val, val1) {
+			t.Errorf("expected snapshot value %q; got %q, %v", val1, val, err)
+		}
+		keyvals, err := Scan(snap, key, proto.EncodedKey(KeyMax), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keyvals) != 1 || !bytes.Equal(keyvals[0].Value, val1) {
+			t.Errorf("expected single snapshot value %q; got %v", val1, keyvals)
+		}
+		iter := snap.NewIterator()
+		iter.Seek(key)
+		if !iter.Valid() || !bytes.Equal(iter.Value(), val1) {
+			t.Errorf("expected iterator at snapshot value %q", val1)
+		}
+		iter.Next()
+		if iter.Valid() {
+			t.Errorf("expected snapshot iterator to be exhausted; got key %q", iter.Key())
+		}
+		iter.Close()
+
+		if err := snap.Put(key, val2); err == nil {
+			t.Error("expected error writing to snapshot")
+		}
+		if err := snap.NewBatch().Commit(); err == nil {
+			t.Error("expected error committing batch on snapshot")
+		}
+		if val, _ := engine.Get(key); !bytes.Equal(val, val2) {
+			t.Errorf("expected engine value %q; got %q", val2, val)
+		}
+	}, t)
+}
+
+// TestSnapshotStop verifies that a snapshot can't be taken of a
+// snapshot and that reads from a stopped snapshot return an error
+// rather than the engine's live data.
+func TestSnapshotStop(t *testing.T) {
+	runWithAllEngines(func(engine Engine, t *testing.T) {
+		key := proto.EncodedKey("a")
+		if err := engine.Put(key, []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		snap, err := engine.NewSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := snap.NewSnapshot(); err == nil {
+			t.Error("expected error creating a snapshot of a snapshot")
+		}
+		snap.Stop()
+
+		if val, err := snap.Get(key); err == nil {
+			t.Errorf("expected error reading from stopped snapshot; got %q", val)
+		}
+		if err := snap.Iterate(key, proto.EncodedKey(KeyMax), func(proto.RawKeyValue) (bool, error) {
+			t.Error("unexpected key/value in stopped snapshot")
+			return false, nil
+		}); err == nil {
+			t.Error("expected error iterating stopped snapshot")
+		}
+		iter := snap.NewIterator()
+		iter.Seek(key)
+		if iter.Valid() || iter.Error() == nil {
+			t.Error("expected iterator over stopped snapshot to be invalid with an error")
+		}
+		iter.Close()
 	}, t)
 }
 
@@ -572,6 +710,50 @@ func TestApproximateSize(t *testing.T) {
 		verifyApproximateSize(keys, engine, sizePerRecord, 0.15, t)
 		verifyApproximateSize(keys[:count/2], engine, sizePerRecord, 0.15, t)
 		verifyApproximateSize(keys[:count/4], engine, sizePerRecord, 0.15, t)
+		verifyApproximateKeyCount(keys, engine, 0.15, t)
+		verifyApproximateKeyCount(keys[:count/2], engine, 0.15, t)
+		verifyApproximateKeyCount(keys[count/4:count/2], engine, 0.15, t)
+	}, t)
+}
+
+// TestApproximateSizeAcrossFlushes verifies size and key count
+// estimates for spans crossing the boundaries between the key ranges
+// of separately flushed tables.
+func TestApproximateSizeAcrossFlushes(t *testing.T) {
+	runWithAllEngines(func(engine Engine, t *testing.T) {
+		var (
+			count    = 8000
+			flushes  = 4
+			keys     = make([]proto.EncodedKey, count)
+			values   = make([][]byte, count)
+			rand     = util.NewPseudoRand()
+			valueLen = 10
+		)
+		for i := 0; i < count; i++ {
+			keys[i] = []byte(fmt.Sprintf("key%8d", i))
+			values[i] = []byte(util.RandString(rand, valueLen))
+		}
+		// Write and flush the keys in consecutive chunks, so that each
+		// table holds a disjoint span of keys.
+		chunk := count / flushes
+		for i := 0; i < count; i += chunk {
+			insertKeysAndValues(keys[i:i+chunk], values[i:i+chunk], engine, t)
+			if rocksdb, ok := engine.(*RocksDB); ok {
+				if err := rocksdb.Flush(); err != nil {
+					t.Fatalf("Error flushing RocksDB: %s", err)
+				}
+			}
+		}
+
+		sizePerRecord := (len([]byte(keys[0])) + valueLen)
+		for _, span := range [][2]int{
+			{chunk / 2, chunk + chunk/2},     // crosses one boundary
+			{chunk / 2, 3*chunk + chunk/2},   // crosses three boundaries
+			{chunk - chunk/4, 2*chunk + 100}, // crosses two boundaries
+		} {
+			verifyApproximateSize(keys[span[0]:span[1]], engine, sizePerRecord, 0.15, t)
+			verifyApproximateKeyCount(keys[span[0]:span[1]], engine, 0.15, t)
+		}
 	}, t)
 }
 
@@ -595,6 +777,18 @@ func insertKeysAndValues(keys []proto.EncodedKey, values [][]byte, engine Engine
 	}
 }
 
+func verifyApproximateKeyCount(keys []proto.EncodedKey, engine Engine, ratio float64, t *testing.T) {
+	n, err := engine.ApproximateKeyCount(keys[0], keys[len(keys)-1])
+	if err != nil {
+		t.Errorf("Error from ApproximateKeyCount(): %s", err)
+	}
+	minCount := uint64(float64(len(keys)) * (1 - ratio))
+	maxCount := uint64(float64(len(keys)) * (1 + ratio))
+	if n < minCount || n > maxCount {
+		t.Errorf("ApproximateKeyCount %d outside of acceptable bounds %d - %d", n, minCount, maxCount)
+	}
+}
+
 func verifyApproximateSize(keys []proto.EncodedKey, engine Engine, sizePerRecord int, ratio float64, t *testing.T) {
 	sz, err := engine.ApproximateSize(keys[0], keys[len(keys)-1])
 	if err != nil {
@@ -19,7 +19,6 @@ package engine
 
 import (
 	"github.com/cockroachdb/cockroach/proto"
-	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/log"
 	gogoproto "github.com/gogo/protobuf/proto"
 )
@@ -28,8 +27,9 @@ import (
 // policy allows either the union or intersection of maximum # of
 // versions and maximum age.
 type GarbageCollector struct {
-	now      proto.Timestamp // time at start of GC
-	policyFn func(key proto.Key) *proto.GCPolicy
+	now       proto.Timestamp // time at start of GC
+	protected proto.Timestamp // versions visible at this time are retained
+	policyFn  func(key proto.Key) *proto.GCPolicy
 }
 
 // NewGarbageCollector allocates and returns a new GC.
@@ -40,11 +40,21 @@ func NewGarbageCollector(now proto.Timestamp, policyFn func(key proto.Key) *prot
 	}
 }
 
+// SetProtectedTimestamp instructs the GC to retain, for every key,
+// the version which a read at timestamp ts would see, regardless of
+// its age. A zero timestamp clears the protection.
+func (gc *GarbageCollector) SetProtectedTimestamp(ts proto.Timestamp) {
+	gc.protected = ts
+}
+
 // MVCCPrefix returns the full key as prefix for non-version MVCC
 // keys and otherwise just the encoded key portion of version MVCC keys.
 func (gc *GarbageCollector) MVCCPrefix(key proto.EncodedKey) int {
-	remaining, _ := encoding.DecodeBinary(key)
-	return len(key) - len(remaining)
+	prefix, _, ok := mvccSplitEncodedKey(key)
+	if !ok {
+		return len(key)
+	}
+	return len(prefix)
 }
 
 // Filter makes decisions about garbage collection based on the
@@ -72,6 +82,10 @@ func (gc *GarbageCollector) Filter(keys []proto.EncodedKey, values [][]byte) []b
 	expiration := gc.now
 	expiration.WallTime -= int64(policy.TTLSeconds) * 1E9
 
+	// If a protected timestamp is set, the newest version at or below
+	// it must survive; versions are ordered newest first.
+	protect := !gc.protected.Equal(proto.ZeroTimestamp)
+
 	var survivors bool
 	// Loop over remaining values. All should be MVCC versions.
 	for i, key := range keys[1:] {
@@ -85,13 +99,17 @@ func (gc *GarbageCollector) Filter(keys []proto.EncodedKey, values [][]byte) []b
 			log.Errorf("unable to unmarshal MVCC value %q: %v", key, err)
 			return make([]bool, len(keys))
 		}
+		visible := protect && !gc.protected.Less(ts)
+		if visible {
+			protect = false
+		}
 		if i == 0 {
 			// If the first value isn't a deletion tombstone, set survivors to true.
 			if !mvccVal.Deleted {
 				survivors = true
 			}
 		} else {
-			if ts.Less(expiration) {
+			if ts.Less(expiration) && !visible {
 				// If we encounter a version older than our GC timestamp, mark for deletion.
 				toDelete[i+1] = true
 			} else if !mvccVal.Deleted {
@@ -135,3 +135,36 @@ func TestGarbageCollectorFilter(t *testing.T) {
 		}
 	}
 }
+
+// TestGarbageCollectorFilterProtected verifies that the version
+// visible at the protected timestamp survives GC regardless of age.
+func TestGarbageCollectorFilterProtected(t *testing.T) {
+	gc := NewGarbageCollector(makeTS(5E9, 0), func(key proto.Key) *proto.GCPolicy {
+		return &proto.GCPolicy{TTLSeconds: 1}
+	})
+	e := []byte{}
+	n := serializedMVCCValue(false, t)
+	d := serializedMVCCValue(true, t)
+	testData := []struct {
+		protected proto.Timestamp
+		keys      []proto.EncodedKey
+		values    [][]byte
+		expDelete []bool
+	}{
+		{proto.ZeroTimestamp, aKeys, [][]byte{e, n, n, n}, []bool{false, false, true, true}},
+		{makeTS(1E9, 0), aKeys, [][]byte{e, n, n, n}, []bool{false, false, true, false}},
+		{makeTS(1E9, 1), aKeys, [][]byte{e, n, n, n}, []bool{false, false, false, true}},
+		{makeTS(1E9, 5), aKeys, [][]byte{e, n, n, n}, []bool{false, false, false, true}},
+		{makeTS(2E9, 0), aKeys, [][]byte{e, n, n, n}, []bool{false, false, true, true}},
+		{makeTS(1E9, 1), aKeys, [][]byte{e, d, n, n}, []bool{false, false, false, true}},
+		{makeTS(1E9, 1), aKeys, [][]byte{e, d, d, n}, []bool{true, true, true, true}},
+		{makeTS(5E8, 0), bKeys, [][]byte{e, n, n}, []bool{false, false, true}},
+	}
+	for i, test := range testData {
+		gc.SetProtectedTimestamp(test.protected)
+		toDelete := gc.Filter(test.keys, test.values)
+		if !reflect.DeepEqual(toDelete, test.expDelete) {
+			t.Errorf("expected deletions (test %d): %v; got %v", i, test.expDelete, toDelete)
+		}
+	}
+}
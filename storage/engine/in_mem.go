@@ -56,15 +56,47 @@ type InMem struct {
 	maxBytes  int64
 	usedBytes int64
 	data      llrb.Tree
-	snapshots map[string]llrb.Tree
+}
+
+// stickyInMemEngines is a registry of named in-memory engines which
+// survive being stopped. See GetOrCreateStickyInMem.
+var stickyInMemEngines = struct {
+	sync.Mutex
+	engines map[string]*InMem
+}{engines: map[string]*InMem{}}
+
+// GetOrCreateStickyInMem returns the in-memory engine registered
+// under name, creating and registering a new one with the supplied
+// attributes and max bytes if none exists. The second return value
+// is true if the engine was newly created. Since InMem.Stop() is a
+// noop, a sticky engine retains its data across the stop and restart
+// of the node or store which uses it. This allows tests to simulate
+// node restarts without touching disk.
+func GetOrCreateStickyInMem(name string, attrs proto.Attributes, maxBytes int64) (*InMem, bool) {
+	stickyInMemEngines.Lock()
+	defer stickyInMemEngines.Unlock()
+	if in, ok := stickyInMemEngines.engines[name]; ok {
+		return in, false
+	}
+	in := NewInMem(attrs, maxBytes)
+	stickyInMemEngines.engines[name] = in
+	return in, true
+}
+
+// RemoveStickyInMem removes the in-memory engine registered under
+// name, if any. Subsequent calls to GetOrCreateStickyInMem with the
+// same name will create a new, empty engine.
+func RemoveStickyInMem(name string) {
+	stickyInMemEngines.Lock()
+	defer stickyInMemEngines.Unlock()
+	delete(stickyInMemEngines.engines, name)
 }
 
 // NewInMem allocates and returns a new InMem object.
 func NewInMem(attrs proto.Attributes, maxBytes int64) *InMem {
 	return &InMem{
-		snapshots: map[string]llrb.Tree{},
-		attrs:     attrs,
-		maxBytes:  maxBytes,
+		attrs:    attrs,
+		maxBytes: maxBytes,
 	}
 }
 
@@ -82,19 +114,9 @@ func (in *InMem) Start() error {
 func (in *InMem) Stop() {
 }
 
-// CreateSnapshot creates a snapshot handle from engine.
-func (in *InMem) CreateSnapshot(snapshotID string) error {
-	in.Lock()
-	defer in.Unlock()
-	_, ok := in.snapshots[snapshotID]
-	if ok {
-		return util.Errorf("snapshotID %s already exists", snapshotID)
-	}
-	snapshotHandle := cloneTree(in.data)
-	in.snapshots[snapshotID] = snapshotHandle
-	return nil
-}
-
+// cloneTree returns a copy of the tree. The copy visits every node,
+// so a snapshot costs O(n) time and memory; the in-memory engine is
+// only used for testing, where that's acceptable.
 func cloneTree(a llrb.Tree) llrb.Tree {
 	var newTree = llrb.Tree{Count: a.Count}
 	newTree.Root = cloneNode(a.Root)
@@ -111,19 +133,6 @@ func cloneNode(a *llrb.Node) *llrb.Node {
 	return newNode
 }
 
-// ReleaseSnapshot releases the existing snapshot handle for the
-// given snapshotID.
-func (in *InMem) ReleaseSnapshot(snapshotID string) error {
-	in.Lock()
-	defer in.Unlock()
-	_, ok := in.snapshots[snapshotID]
-	if !ok {
-		return util.Errorf("snapshotID %s does not exist", snapshotID)
-	}
-	delete(in.snapshots, snapshotID)
-	return nil
-}
-
 // Attrs returns the list of attributes describing this engine. This
 // includes the disk type (always "mem") and potentially other labels
 // to identify important attributes of the engine.
@@ -161,7 +170,7 @@ func (in *InMem) putLocked(key proto.EncodedKey, value []byte) error {
 
 // Merge implements a merge operation which updates the existing value stored
 // under key based on the value passed.
-// See the documentation of goMerge and goMergeInit for details.
+// See the documentation of nativeMerge for details.
 func (in *InMem) Merge(key proto.EncodedKey, value []byte) error {
 	in.Lock()
 	defer in.Unlock()
@@ -178,7 +187,7 @@ func (in *InMem) mergeLocked(key proto.EncodedKey, value []byte) error {
 		return err
 	}
 	// Emulate RocksDB errors by... not having errors.
-	newValue, _ := goMerge(existingVal, value)
+	newValue, _ := nativeMerge(existingVal, value)
 	return in.putLocked(key, newValue)
 }
 
@@ -189,18 +198,6 @@ func (in *InMem) Get(key proto.EncodedKey) ([]byte, error) {
 	return in.getLocked(key, in.data)
 }
 
-// GetSnapshot returns the value for the given key from the given
-// snapshotID, nil otherwise.
-func (in *InMem) GetSnapshot(key proto.EncodedKey, snapshotID string) ([]byte, error) {
-	in.RLock()
-	defer in.RUnlock()
-	snapshotHandle, ok := in.snapshots[snapshotID]
-	if !ok {
-		return nil, util.Errorf("snapshotID %s does not exist", snapshotID)
-	}
-	return in.getLocked(key, snapshotHandle)
-}
-
 // getLocked performs a get operation assuming that the caller
 // is already holding the mutex.
 func (in *InMem) getLocked(key proto.EncodedKey, data llrb.Tree) ([]byte, error) {
@@ -223,18 +220,6 @@ func (in *InMem) Iterate(start, end proto.EncodedKey, f func(proto.RawKeyValue)
 	return in.iterateLocked(start, end, f, in.data)
 }
 
-// Iterate iterates from start to end keys using snapshot ID, invoking
-// f on each key/value pair. See engine.IterateSnapshot for details.
-func (in *InMem) IterateSnapshot(start, end proto.EncodedKey, snapshotID string, f func(proto.RawKeyValue) (bool, error)) error {
-	in.RLock()
-	defer in.RUnlock()
-	snapshotHandle, ok := in.snapshots[snapshotID]
-	if !ok {
-		return util.Errorf("snapshotID %s does not exist", snapshotID)
-	}
-	return in.iterateLocked(start, end, f, snapshotHandle)
-}
-
 func (in *InMem) iterateLocked(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error), data llrb.Tree) error {
 	if bytes.Compare(start, end) >= 0 {
 		return nil
@@ -332,6 +317,24 @@ func (in *InMem) ApproximateSize(start, end proto.EncodedKey) (uint64, error) {
 	return size, nil
 }
 
+// ApproximateKeyCount counts the keys in the given key range.
+func (in *InMem) ApproximateKeyCount(start, end proto.EncodedKey) (uint64, error) {
+	var count uint64
+	in.RLock()
+	defer in.RUnlock()
+	in.data.DoRange(func(node llrb.Comparable) bool {
+		count++
+		return false
+	}, proto.RawKeyValue{Key: start}, proto.RawKeyValue{Key: end})
+	return count, nil
+}
+
+// CompactionStats returns empty stats; the InMem engine has no levels
+// and never compacts.
+func (in *InMem) CompactionStats() (CompactionStats, error) {
+	return CompactionStats{}, nil
+}
+
 // NewIterator returns an iterator over this in-memory engine.
 func (in *InMem) NewIterator() Iterator {
 	in.RLock()
@@ -352,6 +355,99 @@ func (in *InMem) Commit() error {
 	return nil
 }
 
+// NewSnapshot returns a new read-only view of this in-memory engine,
+// backed by a copy of its data.
+func (in *InMem) NewSnapshot() (Engine, error) {
+	in.RLock()
+	defer in.RUnlock()
+	snap := NewInMem(in.attrs, in.maxBytes)
+	snap.data = cloneTree(in.data)
+	snap.usedBytes = in.usedBytes
+	return &inMemSnapshot{InMem: snap}, nil
+}
+
+// inMemSnapshot is a read-only, point-in-time view of an in-memory
+// engine. Reads are served by the embedded InMem, which holds a copy
+// of the engine's data; writes return an error, as do reads once the
+// snapshot has been stopped.
+type inMemSnapshot struct {
+	*InMem
+	stopped bool
+}
+
+// Stop releases the snapshot's copy of the data.
+func (s *inMemSnapshot) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	s.stopped = true
+	s.data = llrb.Tree{}
+}
+
+// Get returns the value for the given key as of the snapshot, nil
+// otherwise.
+func (s *inMemSnapshot) Get(key proto.EncodedKey) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.stopped {
+		return nil, snapshotStoppedError()
+	}
+	return s.getLocked(key, s.data)
+}
+
+// Iterate iterates from start to end keys as of the snapshot,
+// invoking f on each key/value pair. See engine.Iterate for details.
+func (s *inMemSnapshot) Iterate(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error)) error {
+	s.RLock()
+	defer s.RUnlock()
+	if s.stopped {
+		return snapshotStoppedError()
+	}
+	return s.iterateLocked(start, end, f, s.data)
+}
+
+// NewIterator returns an iterator over the snapshot. If the snapshot
+// has been stopped, the iterator is never valid and returns an error.
+func (s *inMemSnapshot) NewIterator() Iterator {
+	s.RLock()
+	stopped := s.stopped
+	s.RUnlock()
+	if stopped {
+		return &errorIterator{err: snapshotStoppedError()}
+	}
+	return s.InMem.NewIterator()
+}
+
+// Put returns an error if called on a snapshot.
+func (s *inMemSnapshot) Put(key proto.EncodedKey, value []byte) error {
+	return snapshotWriteError()
+}
+
+// Clear returns an error if called on a snapshot.
+func (s *inMemSnapshot) Clear(key proto.EncodedKey) error {
+	return snapshotWriteError()
+}
+
+// WriteBatch returns an error if called on a snapshot.
+func (s *inMemSnapshot) WriteBatch([]interface{}) error {
+	return snapshotWriteError()
+}
+
+// Merge returns an error if called on a snapshot.
+func (s *inMemSnapshot) Merge(key proto.EncodedKey, value []byte) error {
+	return snapshotWriteError()
+}
+
+// NewBatch returns a new Batch wrapping the snapshot. Reads through
+// the batch see the snapshot; committing it returns an error.
+func (s *inMemSnapshot) NewBatch() Engine {
+	return &Batch{engine: s}
+}
+
+// NewSnapshot returns an error if called on a snapshot.
+func (s *inMemSnapshot) NewSnapshot() (Engine, error) {
+	return nil, snapshotOfSnapshotError()
+}
+
 // This implementation is not very efficient because the biogo LLRB
 // API supports iterations, not iterators. Every call to Next() is
 // O(logN). But since the in-memory engine is really only good for
@@ -382,6 +478,36 @@ func (in *inMemIterator) Seek(key []byte) {
 	}, proto.RawKeyValue{Key: key}, proto.RawKeyValue{Key: proto.EncodedKey(KeyMax)})
 }
 
+func (in *inMemIterator) SeekReverse(key []byte) {
+	in.cur = nil
+	in.err = nil
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	if c := llrbLastBefore(in.data, key); c != nil {
+		kv := c.(proto.RawKeyValue)
+		in.cur = &kv
+	}
+}
+
+// llrbLastBefore returns the last element of the tree with a key <
+// the supplied key, or the last element of the tree if key is empty.
+// Returns nil if no such element exists.
+func llrbLastBefore(data llrb.Tree, key proto.EncodedKey) llrb.Comparable {
+	if len(key) == 0 {
+		return data.Max()
+	}
+	var last llrb.Comparable
+	// DoRangeReverse visits (to, from], so key itself must be skipped.
+	data.DoRangeReverse(func(c llrb.Comparable) (done bool) {
+		if bytes.Compare(c.(proto.KeyGetter).KeyGet(), key) >= 0 {
+			return false
+		}
+		last = c
+		return true
+	}, proto.RawKeyValue{Key: key}, proto.RawKeyValue{Key: proto.EncodedKey(KeyMin)})
+	return last
+}
+
 func (in *inMemIterator) Valid() bool {
 	return in.err == nil && in.cur != nil
 }
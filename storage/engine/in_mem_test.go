@@ -101,3 +101,31 @@ func BenchmarkCapacity(b *testing.B) {
 		}
 	}
 }
+
+// TestStickyInMem verifies that sticky in-memory engines are shared
+// by name and retain their data after being stopped.
+func TestStickyInMem(t *testing.T) {
+	defer RemoveStickyInMem("test")
+	engine, created := GetOrCreateStickyInMem("test", proto.Attributes{}, 1<<20)
+	if !created {
+		t.Fatal("expected sticky engine to be newly created")
+	}
+	if err := engine.Put(proto.EncodedKey("a"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	engine.Stop()
+
+	restarted, created := GetOrCreateStickyInMem("test", proto.Attributes{}, 1<<20)
+	if created || restarted != engine {
+		t.Fatal("expected to get existing sticky engine")
+	}
+	if val, err := restarted.Get(proto.EncodedKey("a")); err != nil || string(val) != "value" {
+		t.Errorf("expected value \"value\" after restart; got %q (%v)", val, err)
+	}
+
+	// Once removed, a new empty engine is created.
+	RemoveStickyInMem("test")
+	if fresh, created := GetOrCreateStickyInMem("test", proto.Attributes{}, 1<<20); !created || fresh == engine {
+		t.Error("expected a new sticky engine after removal")
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"fmt"
 
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/log"
 )
 
@@ -74,6 +75,20 @@ func RangeDescriptorKey(startKey proto.Key) proto.Key {
 	return MakeLocalKey(KeyLocalRangeDescriptorPrefix, startKey)
 }
 
+// RangeLastVerifiedKey returns a store-local key for the timestamp at
+// which the replica of the range with the specified Raft ID was last
+// verified consistent with a majority of its range's replicas. The
+// key isn't replicated, so replicas may disagree on its value.
+func RangeLastVerifiedKey(raftID int64) proto.Key {
+	return MakeKey(KeyLocalRangeLastVerifiedPrefix, encoding.EncodeInt(nil, raftID))
+}
+
+// RangeGCMetadataKey returns a store-local key for the GC metadata
+// of the range with the specified Raft ID.
+func RangeGCMetadataKey(raftID int64) proto.Key {
+	return MakeKey(KeyLocalRangeGCMetadataPrefix, encoding.EncodeInt(nil, raftID))
+}
+
 // RangeMetaKey returns a range metadata (meta1, meta2) indexing key
 // for the given key. For ordinary keys this returns a level 2
 // metadata key - for level 2 keys, it returns a level 1 key. For
@@ -180,14 +195,31 @@ var (
 	//   part of the local key.
 	KeyLocalPrefixLength = len(KeyLocalPrefix) + 4
 
+	// KeyLocalGossipBootstrap stores the addresses of nodes learned via
+	// gossip, used to rejoin the gossip network on restart. The value
+	// is a proto.GossipBootstrapInfo.
+	KeyLocalGossipBootstrap = MakeKey(KeyLocalPrefix, proto.Key("gsbs"))
 	// KeyLocalIdent stores an immutable identifier for this store,
 	// created when the store is first bootstrapped.
 	KeyLocalIdent = MakeKey(KeyLocalPrefix, proto.Key("iden"))
 	// KeyLocalRangeDescriptorPrefix is the prefix for keys storing
 	// range descriptors. The value is a struct of type RangeDescriptor.
 	KeyLocalRangeDescriptorPrefix = MakeKey(KeyLocalPrefix, proto.Key("rng-"))
+	// KeyLocalRangeTombstonePrefix is the prefix for keys storing MVCC
+	// range tombstones, addressed by the tombstone's start key. The
+	// value is a proto.MVCCRangeTombstone.
+	KeyLocalRangeTombstonePrefix = MakeKey(KeyLocalPrefix, proto.Key("rtmb"))
 	// KeyLocalRangeStatPrefix is the prefix for range statistics.
 	KeyLocalRangeStatPrefix = MakeKey(KeyLocalPrefix, proto.Key("rst-"))
+	// KeyLocalRangeGCMetadataPrefix is the prefix for keys storing a
+	// range's GC metadata, addressed by Raft ID. The value is a
+	// proto.GCMetadata.
+	KeyLocalRangeGCMetadataPrefix = MakeKey(KeyLocalPrefix, proto.Key("rgcm"))
+	// KeyLocalRangeLastVerifiedPrefix is the prefix for unreplicated
+	// keys storing the timestamp at which a replica was last verified
+	// consistent with its range's other replicas, addressed by Raft ID.
+	// The value is a proto.Timestamp.
+	KeyLocalRangeLastVerifiedPrefix = MakeKey(KeyLocalPrefix, proto.Key("rlvt"))
 	// KeyLocalResponseCachePrefix is the prefix for keys storing command
 	// responses used to guarantee idempotency (see ResponseCache).
 	KeyLocalResponseCachePrefix = MakeKey(KeyLocalPrefix, proto.Key("res-"))
@@ -225,6 +257,9 @@ var (
 	// KeyConfigAccountingPrefix specifies the key prefix for accounting
 	// configurations. The suffix is the affected key prefix.
 	KeyConfigAccountingPrefix = MakeKey(KeySystemPrefix, proto.Key("acct"))
+	// KeyAuditLogPrefix specifies the key prefix for the audit log of
+	// administrative operations. See AuditLogKey.
+	KeyAuditLogPrefix = MakeKey(KeySystemPrefix, proto.Key("audit-"))
 	// KeyConfigPermissionPrefix specifies the key prefix for accounting
 	// configurations. The suffix is the affected key prefix.
 	KeyConfigPermissionPrefix = MakeKey(KeySystemPrefix, proto.Key("perm"))
@@ -241,3 +276,98 @@ var (
 	// generators, one per node, for store IDs.
 	KeyStoreIDGeneratorPrefix = MakeKey(KeySystemPrefix, proto.Key("store-idgen-"))
 )
+
+// AuditLogKey returns the audit log key for an event recorded at the
+// given wall time in nanoseconds. Keys sort by time; the suffix
+// distinguishes events recorded in the same nanosecond.
+func AuditLogKey(nanos int64, suffix []byte) proto.Key {
+	return MakeKey(KeyAuditLogPrefix, encoding.EncodeUint64(nil, uint64(nanos)), suffix)
+}
+
+// RangeConfigKey returns the key by which the range with the given
+// start key is matched against prefix configs, such as zones. This is
+// the start key itself, except for the first range: the keys below
+// KeyMetaPrefix are range-local, so the first range is governed by the
+// configs for its range addressing records.
+func RangeConfigKey(startKey proto.Key) proto.Key {
+	if startKey.Less(KeyMetaPrefix) {
+		return KeyMetaPrefix
+	}
+	return startKey
+}
+
+// init registers the structural key prefixes defined above, which
+// are preserved when keys are redacted for display.
+func init() {
+	for _, prefix := range []proto.Key{
+		KeyLocalPrefix,
+		KeyLocalGossipBootstrap,
+		KeyLocalIdent,
+		KeyLocalRangeDescriptorPrefix,
+		KeyLocalRangeTombstonePrefix,
+		KeyLocalRangeStatPrefix,
+		KeyLocalRangeGCMetadataPrefix,
+		KeyLocalRangeLastVerifiedPrefix,
+		KeyLocalResponseCachePrefix,
+		KeyLocalStoreStatPrefix,
+		KeyLocalTransactionPrefix,
+		KeyLocalSnapshotIDGenerator,
+		KeySystemPrefix,
+		KeyMetaPrefix,
+		KeyMeta1Prefix,
+		KeyMeta2Prefix,
+		KeyConfigAccountingPrefix,
+		KeyAuditLogPrefix,
+		KeyConfigPermissionPrefix,
+		KeyConfigZonePrefix,
+		KeyNodeIDGenerator,
+		KeyRaftIDGenerator,
+		KeySchemaPrefix,
+		KeyStoreIDGeneratorPrefix,
+	} {
+		proto.RegisterKeyPrefix(prefix)
+	}
+
+	for _, f := range []struct {
+		prefix proto.Key
+		name   string
+		format proto.KeyFormatter
+	}{
+		{KeyLocalPrefix, "/Local", nil},
+		{KeyLocalGossipBootstrap, "/Local/GossipBootstrap", nil},
+		{KeyLocalIdent, "/Local/Ident", nil},
+		{KeyLocalRangeDescriptorPrefix, "/Local/RangeDescriptor", proto.NestedKeyFormatter},
+		{KeyLocalRangeTombstonePrefix, "/Local/RangeTombstone", proto.NestedKeyFormatter},
+		{KeyLocalRangeStatPrefix, "/Local/RangeStat", formatIDKey},
+		{KeyLocalRangeGCMetadataPrefix, "/Local/RangeGCMetadata", formatIDKey},
+		{KeyLocalRangeLastVerifiedPrefix, "/Local/RangeLastVerified", formatIDKey},
+		{KeyLocalResponseCachePrefix, "/Local/ResponseCache", formatIDKey},
+		{KeyLocalStoreStatPrefix, "/Local/StoreStat", formatIDKey},
+		{KeyLocalTransactionPrefix, "/Local/Transaction", proto.NestedKeyFormatter},
+		{KeyLocalSnapshotIDGenerator, "/Local/SnapshotIDGenerator", nil},
+		{KeySystemPrefix, "/System", nil},
+		{KeyMetaPrefix, "/Meta", nil},
+		{KeyMeta1Prefix, "/Meta1", proto.NestedKeyFormatter},
+		{KeyMeta2Prefix, "/Meta2", proto.NestedKeyFormatter},
+		{KeyConfigAccountingPrefix, "/System/Accounting", nil},
+		{KeyAuditLogPrefix, "/System/AuditLog", nil},
+		{KeyConfigPermissionPrefix, "/System/Permission", nil},
+		{KeyConfigZonePrefix, "/System/Zone", nil},
+		{KeyNodeIDGenerator, "/System/NodeIDGenerator", nil},
+		{KeyRaftIDGenerator, "/System/RaftIDGenerator", nil},
+		{KeySchemaPrefix, "/System/Schema", nil},
+		{KeyStoreIDGeneratorPrefix, "/System/StoreIDGenerator", nil},
+	} {
+		proto.RegisterKeyFormatter(f.prefix, f.name, f.format)
+	}
+}
+
+// formatIDKey formats the remainder of a key which begins with an
+// encoded Raft or store ID, such as a range or store statistic key.
+func formatIDKey(rest proto.Key) string {
+	if len(rest) == 0 {
+		return ""
+	}
+	rest, id := encoding.DecodeInt(rest)
+	return fmt.Sprintf("/%d", id) + proto.QuoteKeyFormatter(rest)
+}
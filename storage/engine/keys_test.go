@@ -48,6 +48,57 @@ func TestMakeKey(t *testing.T) {
 	}
 }
 
+// TestKeyPrettyPrint verifies that system and range-local keys are
+// formatted as human-readable paths and user keys are left alone.
+func TestKeyPrettyPrint(t *testing.T) {
+	testCases := []struct {
+		key proto.Key
+		exp string
+	}{
+		{proto.Key("foo"), "foo"},
+		{KeyMax, "\xff..."},
+		{RangeMetaKey(proto.Key("foo")), `/Meta2/"foo"`},
+		{RangeMetaKey(KeyMax), `/Meta2/"\xff..."`},
+		{RangeMetaKey(RangeMetaKey(proto.Key("foo"))), `/Meta1/"foo"`},
+		{RangeDescriptorKey(KeyMin), "/Local/RangeDescriptor"},
+		{RangeDescriptorKey(RangeMetaKey(proto.Key("foo"))), `/Local/RangeDescriptor/Meta2/"foo"`},
+		{MakeRangeStatKey(5, StatLiveBytes), `/Local/RangeStat/5/"live-bytes"`},
+		{RangeGCMetadataKey(12), "/Local/RangeGCMetadata/12"},
+		{RangeLastVerifiedKey(12), "/Local/RangeLastVerified/12"},
+		{MakeKey(KeyLocalTransactionPrefix, proto.Key("foo")), `/Local/Transaction/"foo"`},
+		{KeyLocalIdent, "/Local/Ident"},
+		{MakeKey(KeyConfigZonePrefix, proto.Key("db1")), `/System/Zone/"db1"`},
+		{KeyNodeIDGenerator, "/System/NodeIDGenerator"},
+		{MakeKey(KeySystemPrefix, proto.Key("other")), `/System/"other"`},
+		// A malformed ID is quoted rather than decoded.
+		{MakeKey(KeyLocalRangeStatPrefix, proto.Key("\x14")), `/Local/RangeStat/"\x14"`},
+	}
+	for i, test := range testCases {
+		if s := test.key.String(); s != test.exp {
+			t.Errorf("%d: expected %s; got %s", i, test.exp, s)
+		}
+	}
+}
+
+// TestRangeConfigKey verifies that the first range is matched against
+// configs by its range addressing records and other ranges by their
+// start keys.
+func TestRangeConfigKey(t *testing.T) {
+	testCases := []struct {
+		startKey, expKey proto.Key
+	}{
+		{KeyMin, KeyMetaPrefix},
+		{KeyMeta2Prefix, KeyMeta2Prefix},
+		{KeySchemaPrefix, KeySchemaPrefix},
+		{proto.Key("a"), proto.Key("a")},
+	}
+	for i, test := range testCases {
+		if key := RangeConfigKey(test.startKey); !key.Equal(test.expKey) {
+			t.Errorf("%d: expected config key %q; got %q", i, test.expKey, key)
+		}
+	}
+}
+
 func TestRangeMetaKey(t *testing.T) {
 	testCases := []struct {
 		key, expKey proto.Key
@@ -0,0 +1,243 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"math"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// nativeMerge is a Go implementation of the merge operator which
+// roachlib installs on RocksDB. Like goMerge, it merges the
+// marshalled proto.MVCCMetadata update into existing and returns the
+// marshalled result, but it doesn't cross into C++, allowing the
+// in-memory engine to merge values exactly as RocksDB does.
+func nativeMerge(existing, update []byte) ([]byte, error) {
+	var meta, updateMeta proto.MVCCMetadata
+	if err := gogoproto.Unmarshal(existing, &meta); err != nil {
+		return nil, util.Errorf("corrupted existing value: %s", err)
+	}
+	if err := gogoproto.Unmarshal(update, &updateMeta); err != nil {
+		return nil, util.Errorf("corrupted update value: %s", err)
+	}
+	if meta.Value == nil {
+		meta.Value = &proto.Value{}
+	}
+	right := updateMeta.Value
+	if right == nil {
+		right = &proto.Value{}
+	}
+	meta.Value.Upgrade()
+	right.Upgrade()
+	if err := mergeValues(meta.Value, right); err != nil {
+		return nil, util.Errorf("incompatible merge values: %s", err)
+	}
+	// As in roachlib, checksums of merged values are cleared rather
+	// than recomputed.
+	meta.Value.Checksum = nil
+	meta.Value.ChecksumCRC32C = nil
+	return gogoproto.Marshal(&meta)
+}
+
+// isTimeSeriesData returns true if the value contains an
+// InternalTimeSeriesData message.
+func isTimeSeriesData(v *proto.Value) bool {
+	return v.GetValueType() == proto.TIMESERIES
+}
+
+// mergeValues merges right into left. Byte slices are concatenated,
+// integers and floats are summed and time series are combined by
+// mergeTimeSeriesValues. If left is empty, it's set to right.
+func mergeValues(left, right *proto.Value) error {
+	switch {
+	case left.Bytes != nil:
+		if right.Bytes == nil {
+			return util.Errorf("inconsistent value types for merge (left = bytes, right = ?)")
+		}
+		if isTimeSeriesData(left) {
+			if !isTimeSeriesData(right) {
+				return util.Errorf("inconsistent value types for merge (left = TimeSeriesData, right = bytes)")
+			}
+			return mergeTimeSeriesValues(left, right)
+		} else if isTimeSeriesData(right) {
+			return util.Errorf("inconsistent value types for merge (left = bytes, right = TimeSeriesData)")
+		}
+		left.Bytes = append(left.Bytes, right.Bytes...)
+	case left.Integer != nil:
+		if right.Integer == nil {
+			return util.Errorf("inconsistent value types for merge (left = integer, right = ?)")
+		}
+		l, r := *left.Integer, *right.Integer
+		if (r > 0 && l > math.MaxInt64-r) || (r < 0 && l < math.MinInt64-r) {
+			return util.Errorf("merge would result in integer overflow")
+		}
+		left.Integer = gogoproto.Int64(l + r)
+	case left.Float != nil:
+		if right.Float == nil {
+			return util.Errorf("inconsistent value types for merge (left = float, right = ?)")
+		}
+		left.Float = gogoproto.Float64(*left.Float + *right.Float)
+	default:
+		*left = *gogoproto.Clone(right).(*proto.Value)
+	}
+	return nil
+}
+
+// timeSeriesSamples implements sort.Interface, ordering samples by
+// offset.
+type timeSeriesSamples []*proto.InternalTimeSeriesSample
+
+func (s timeSeriesSamples) Len() int           { return len(s) }
+func (s timeSeriesSamples) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s timeSeriesSamples) Less(i, j int) bool { return s[i].Offset < s[j].Offset }
+
+// mergeTimeSeriesValues merges the InternalTimeSeriesData in right into
+// that in left. The two must share a start timestamp and sample
+// duration. The samples of left are assumed to be sorted; the merged
+// samples are sorted, with samples at the same offset accumulated.
+func mergeTimeSeriesValues(left, right *proto.Value) error {
+	var leftTS, rightTS proto.InternalTimeSeriesData
+	if err := gogoproto.Unmarshal(left.Bytes, &leftTS); err != nil {
+		return util.Errorf("left InternalTimeSeriesData could not be parsed from bytes: %s", err)
+	}
+	if err := gogoproto.Unmarshal(right.Bytes, &rightTS); err != nil {
+		return util.Errorf("right InternalTimeSeriesData could not be parsed from bytes: %s", err)
+	}
+	if leftTS.StartTimestampNanos != rightTS.StartTimestampNanos {
+		return util.Errorf("TimeSeries merge failed due to mismatched start timestamps")
+	}
+	if leftTS.SampleDurationNanos != rightTS.SampleDurationNanos {
+		return util.Errorf("TimeSeries merge failed due to mismatched sample durations")
+	}
+
+	newTS := proto.InternalTimeSeriesData{
+		StartTimestampNanos: leftTS.StartTimestampNanos,
+		SampleDurationNanos: leftTS.SampleDurationNanos,
+	}
+	sort.Stable(timeSeriesSamples(rightTS.Samples))
+	l, r := leftTS.Samples, rightTS.Samples
+	for len(l) > 0 || len(r) > 0 {
+		// Select the lowest offset from either side and accumulate all
+		// samples at that offset, as each side may contain duplicates.
+		var offset int32
+		if len(r) == 0 || (len(l) > 0 && l[0].Offset <= r[0].Offset) {
+			offset = l[0].Offset
+		} else {
+			offset = r[0].Offset
+		}
+		ns := &proto.InternalTimeSeriesSample{Offset: offset}
+		for len(l) > 0 && l[0].Offset == offset {
+			accumulateTimeSeriesSamples(ns, l[0])
+			l = l[1:]
+		}
+		for len(r) > 0 && r[0].Offset == offset {
+			accumulateTimeSeriesSamples(ns, r[0])
+			r = r[1:]
+		}
+		newTS.Samples = append(newTS.Samples, ns)
+	}
+
+	b, err := gogoproto.Marshal(&newTS)
+	if err != nil {
+		return err
+	}
+	left.Bytes = b
+	return nil
+}
+
+// accumulateTimeSeriesSamples accumulates the values of src into dest,
+// which share an offset.
+func accumulateTimeSeriesSamples(dest, src *proto.InternalTimeSeriesSample) {
+	intCount := dest.IntCount + src.IntCount
+	if intCount > 1 {
+		// Keep explicit max and min values.
+		dest.IntMax = gogoproto.Int64(maxInt64(sampleIntMax(dest), sampleIntMax(src)))
+		dest.IntMin = gogoproto.Int64(minInt64(sampleIntMin(dest), sampleIntMin(src)))
+	}
+	if intCount > 0 {
+		dest.IntSum = gogoproto.Int64(dest.GetIntSum() + src.GetIntSum())
+	}
+	dest.IntCount = intCount
+
+	floatCount := dest.FloatCount + src.FloatCount
+	if floatCount > 1 {
+		dest.FloatMax = gogoproto.Float32(float32(math.Max(float64(sampleFloatMax(dest)), float64(sampleFloatMax(src)))))
+		dest.FloatMin = gogoproto.Float32(float32(math.Min(float64(sampleFloatMin(dest)), float64(sampleFloatMin(src)))))
+	}
+	if floatCount > 0 {
+		dest.FloatSum = gogoproto.Float32(dest.GetFloatSum() + src.GetFloatSum())
+	}
+	dest.FloatCount = floatCount
+}
+
+// The sample{Int,Float}{Max,Min} functions return the explicit max or
+// min of a sample, falling back to its sum for a sample holding a
+// single measurement, and to the identity of max or min for an empty
+// sample.
+
+func sampleIntMax(s *proto.InternalTimeSeriesSample) int64 {
+	if s.IntMax != nil {
+		return *s.IntMax
+	} else if s.IntSum != nil {
+		return *s.IntSum
+	}
+	return math.MinInt64
+}
+
+func sampleIntMin(s *proto.InternalTimeSeriesSample) int64 {
+	if s.IntMin != nil {
+		return *s.IntMin
+	} else if s.IntSum != nil {
+		return *s.IntSum
+	}
+	return math.MaxInt64
+}
+
+func sampleFloatMax(s *proto.InternalTimeSeriesSample) float32 {
+	if s.FloatMax != nil {
+		return *s.FloatMax
+	} else if s.FloatSum != nil {
+		return *s.FloatSum
+	}
+	return -math.MaxFloat32
+}
+
+func sampleFloatMin(s *proto.InternalTimeSeriesSample) float32 {
+	if s.FloatMin != nil {
+		return *s.FloatMin
+	} else if s.FloatSum != nil {
+		return *s.FloatSum
+	}
+	return math.MaxFloat32
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -65,7 +65,16 @@ func mustMarshal(m gogoproto.Message) []byte {
 func counter(n int64) []byte {
 	v := &proto.MVCCMetadata{
 		Value: &proto.Value{
-			Integer: gogoproto.Int64(n),
+			ValuePayload: proto.ValuePayload{Integer: gogoproto.Int64(n)},
+		},
+	}
+	return mustMarshal(v)
+}
+
+func floatCounter(f float64) []byte {
+	v := &proto.MVCCMetadata{
+		Value: &proto.Value{
+			ValuePayload: proto.ValuePayload{Float: gogoproto.Float64(f)},
 		},
 	}
 	return mustMarshal(v)
@@ -74,7 +83,7 @@ func counter(n int64) []byte {
 func appender(s string) []byte {
 	v := &proto.MVCCMetadata{
 		Value: &proto.Value{
-			Bytes: []byte(s),
+			ValuePayload: proto.ValuePayload{Bytes: []byte(s)},
 		},
 	}
 	return mustMarshal(v)
@@ -223,6 +232,34 @@ func TestGoMerge(t *testing.T) {
 		}
 	}
 
+	testCasesFloatCounter := []struct {
+		existing, update, expected float64
+	}{
+		{0, 10, 10},
+		{1.5, 2.25, 3.75},
+		{-0.5, 0.5, 0},
+		{math.Inf(1), 1, math.Inf(1)},
+	}
+	for i, c := range testCasesFloatCounter {
+		result, err := goMerge(floatCounter(c.existing), floatCounter(c.update))
+		if err != nil {
+			t.Errorf("goMerge error: %d: %v", i, err)
+			continue
+		}
+		var v proto.MVCCMetadata
+		if err := gogoproto.Unmarshal(result, &v); err != nil {
+			t.Errorf("goMerge error unmarshalling: %s", err)
+			continue
+		}
+		if v.Value.Float == nil || v.Value.GetFloat() != c.expected {
+			t.Errorf("goMerge error: %d: want %v, got %v", i, c.expected, v.Value)
+		}
+	}
+	// Floats may not be merged with integers.
+	if _, err := goMerge(floatCounter(1), counter(1)); err == nil {
+		t.Error("goMerge: expected error merging integer into float")
+	}
+
 	gibber1, gibber2 := gibberishString(100), gibberishString(200)
 
 	testCasesAppender := []struct {
@@ -348,6 +385,18 @@ func TestGoMerge(t *testing.T) {
 				{3, 1, 5, 5, 5},
 			}...),
 		},
+		{
+			timeSeriesFloat(testtime, 1000, []tsFloatSample{
+				{1, 2, -10, -3, -7},
+			}...),
+			timeSeriesFloat(testtime, 1000, []tsFloatSample{
+				{2, 1, -5, -5, -5},
+			}...),
+			timeSeriesFloat(testtime, 1000, []tsFloatSample{
+				{1, 2, -10, -3, -7},
+				{2, 1, -5, -5, -5},
+			}...),
+		},
 	}
 
 	for i, c := range testCasesTimeSeries {
@@ -368,3 +417,66 @@ func TestGoMerge(t *testing.T) {
 		}
 	}
 }
+
+// TestNativeMerge verifies that nativeMerge, used by the in-memory
+// engine, agrees with the roachlib merge operator used by RocksDB.
+func TestNativeMerge(t *testing.T) {
+	values := [][]byte{
+		nil,
+		counter(0),
+		counter(10),
+		counter(math.MaxInt64),
+		counter(math.MinInt64),
+		floatCounter(1.5),
+		floatCounter(-2.25),
+		appender(""),
+		appender("foo"),
+		// Values encoded before proto.ValuePayload existed.
+		mustMarshal(&proto.MVCCMetadata{Value: &proto.Value{LegacyInteger: gogoproto.Int64(7)}}),
+		mustMarshal(&proto.MVCCMetadata{Value: &proto.Value{LegacyBytes: []byte("bar")}}),
+		timeSeriesInt(testtime, 1000, []tsIntSample{
+			{1, 1, 5, 5, 5},
+			{3, 2, 10, 7, 3},
+		}...),
+		timeSeriesInt(testtime, 1000, []tsIntSample{
+			{3, 1, 100, 100, 100},
+			{2, 1, 5, 5, 5},
+			{2, 1, 6, 6, 6},
+		}...),
+		timeSeriesInt(testtime+1, 1000, []tsIntSample{
+			{1, 1, 5, 5, 5},
+		}...),
+		timeSeriesFloat(testtime, 1000, []tsFloatSample{
+			{1, 2, -10, -3, -7},
+		}...),
+		timeSeriesFloat(testtime, 1000, []tsFloatSample{
+			{1, 1, 2.5, 2.5, 2.5},
+			{4, 1, -1, -1, -1},
+		}...),
+		timeSeriesFloat(testtime, 100, []tsFloatSample{
+			{1, 1, 5, 5, 5},
+		}...),
+	}
+	for i, existing := range values {
+		for j, update := range values {
+			expected, expErr := goMerge(existing, update)
+			result, err := nativeMerge(existing, update)
+			if (err == nil) != (expErr == nil) {
+				t.Errorf("%d,%d: expected error %v; got %v", i, j, expErr, err)
+				continue
+			} else if err != nil {
+				continue
+			}
+			var resultMeta, expectedMeta proto.MVCCMetadata
+			if err := gogoproto.Unmarshal(result, &resultMeta); err != nil {
+				t.Fatal(err)
+			}
+			if err := gogoproto.Unmarshal(expected, &expectedMeta); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resultMeta, expectedMeta) {
+				t.Errorf("%d,%d: expected %+v; got %+v", i, j, expectedMeta, resultMeta)
+			}
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"sync"
 
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util"
@@ -44,9 +45,75 @@ const (
 //  - Key count (count of all keys, including keys with deleted tombstones)
 //  - Value count (all versions, including deleted tombstones)
 //  - Intents (provisional values written during txns)
+//  - GC bytes age (see GCBytesAge)
+//  - Range tombstones (see RangeTombstoneCount)
 type MVCCStats struct {
 	LiveBytes, KeyBytes, ValBytes, IntentBytes int64
 	LiveCount, KeyCount, ValCount, IntentCount int64
+	// GCBytesAge is the age in seconds of all non-live bytes (see
+	// GCBytes), measured from the wall time at which each non-live
+	// version was written, as of the Unix epoch. It's therefore
+	// negative; use GCBytesAgeAt for the age as of a given time. Since
+	// the age of non-live bytes grows with time without any writes,
+	// this representation is what allows the stat to be maintained by
+	// merging deltas like the other counters.
+	GCBytesAge int64
+	// RangeTombstoneCount is the number of range tombstone records
+	// starting within the range. It lets ranges which hold none skip
+	// looking for them on every read and write.
+	RangeTombstoneCount int64
+}
+
+// GCBytes returns the number of bytes which are not live, i.e. those
+// of historical versions, deletion tombstones and their metadata.
+func (ms *MVCCStats) GCBytes() int64 {
+	return ms.KeyBytes + ms.ValBytes - ms.LiveBytes
+}
+
+// GCBytesAgeAt returns the age in seconds of all non-live bytes as of
+// the specified wall time in nanoseconds.
+func (ms *MVCCStats) GCBytesAgeAt(nowNanos int64) int64 {
+	return ms.GCBytesAge + ms.GCBytes()*wallSeconds(nowNanos)
+}
+
+// Add adds the counters of oms to ms.
+func (ms *MVCCStats) Add(oms *MVCCStats) {
+	ms.LiveBytes += oms.LiveBytes
+	ms.KeyBytes += oms.KeyBytes
+	ms.ValBytes += oms.ValBytes
+	ms.IntentBytes += oms.IntentBytes
+	ms.LiveCount += oms.LiveCount
+	ms.KeyCount += oms.KeyCount
+	ms.ValCount += oms.ValCount
+	ms.IntentCount += oms.IntentCount
+	ms.GCBytesAge += oms.GCBytesAge
+	ms.RangeTombstoneCount += oms.RangeTombstoneCount
+}
+
+// Subtract subtracts the counters of oms from ms.
+func (ms *MVCCStats) Subtract(oms *MVCCStats) {
+	ms.LiveBytes -= oms.LiveBytes
+	ms.KeyBytes -= oms.KeyBytes
+	ms.ValBytes -= oms.ValBytes
+	ms.IntentBytes -= oms.IntentBytes
+	ms.LiveCount -= oms.LiveCount
+	ms.KeyCount -= oms.KeyCount
+	ms.ValCount -= oms.ValCount
+	ms.IntentCount -= oms.IntentCount
+	ms.GCBytesAge -= oms.GCBytesAge
+	ms.RangeTombstoneCount -= oms.RangeTombstoneCount
+}
+
+// wallSeconds converts a wall time in nanoseconds to whole seconds.
+func wallSeconds(nanos int64) int64 {
+	return nanos / 1E9
+}
+
+// updateGCBytesAge accounts for bytes which have become non-live
+// (positive bytes) or non-live bytes which have been removed or
+// become live again (negative bytes) at their version's timestamp.
+func (ms *MVCCStats) updateGCBytesAge(bytes int64, timestamp proto.Timestamp) {
+	ms.GCBytesAge -= bytes * wallSeconds(timestamp.WallTime)
 }
 
 // MergeStats merges accumulated stats to stat counters for both the
@@ -60,6 +127,10 @@ func (ms *MVCCStats) MergeStats(engine Engine, raftID int64, storeID int32) {
 	MergeStat(engine, raftID, storeID, StatKeyCount, ms.KeyCount)
 	MergeStat(engine, raftID, storeID, StatValCount, ms.ValCount)
 	MergeStat(engine, raftID, storeID, StatIntentCount, ms.IntentCount)
+	// GC bytes age is kept only per range, as its epoch-relative
+	// value could overflow when aggregated over an entire store.
+	MergeStat(engine, raftID, 0, StatGCBytesAge, ms.GCBytesAge)
+	MergeStat(engine, raftID, 0, StatRangeTombstoneCount, ms.RangeTombstoneCount)
 }
 
 // SetStats sets stat counters for both the affected range and store.
@@ -72,6 +143,8 @@ func (ms *MVCCStats) SetStats(engine Engine, raftID int64, storeID int32) {
 	SetStat(engine, raftID, storeID, StatKeyCount, ms.KeyCount)
 	SetStat(engine, raftID, storeID, StatValCount, ms.ValCount)
 	SetStat(engine, raftID, storeID, StatIntentCount, ms.IntentCount)
+	SetStat(engine, raftID, 0, StatGCBytesAge, ms.GCBytesAge)
+	SetStat(engine, raftID, 0, StatRangeTombstoneCount, ms.RangeTombstoneCount)
 }
 
 // updateStatsForKey returns whether or not the bytes and counts for
@@ -132,6 +205,18 @@ func (ms *MVCCStats) updateStatsOnPut(key proto.Key, origMetaKeySize, origMetaVa
 	}
 	// Remove current live counts for this key.
 	if orig != nil {
+		// Account for the age of bytes which become or cease to be
+		// non-live: the original metadata is replaced, an original
+		// intent is removed and an original committed value becomes
+		// historical.
+		if orig.Deleted {
+			ms.updateGCBytesAge(-(origMetaKeySize + origMetaValSize), orig.Timestamp)
+			if orig.Txn != nil {
+				ms.updateGCBytesAge(-(orig.KeyBytes + orig.ValBytes), orig.Timestamp)
+			}
+		} else if orig.Txn == nil {
+			ms.updateGCBytesAge(orig.KeyBytes+orig.ValBytes, orig.Timestamp)
+		}
 		// If original version value for this key wasn't deleted, subtract
 		// its contribution from live bytes in anticipation of adding in
 		// contribution from new version below.
@@ -158,6 +243,8 @@ func (ms *MVCCStats) updateStatsOnPut(key proto.Key, origMetaKeySize, origMetaVa
 	if !meta.Deleted {
 		ms.LiveBytes += meta.KeyBytes + meta.ValBytes + metaKeySize + metaValSize
 		ms.LiveCount++
+	} else {
+		ms.updateGCBytesAge(meta.KeyBytes+meta.ValBytes+metaKeySize+metaValSize, meta.Timestamp)
 	}
 	ms.KeyBytes += meta.KeyBytes + metaKeySize
 	ms.ValBytes += meta.ValBytes + metaValSize
@@ -172,12 +259,19 @@ func (ms *MVCCStats) updateStatsOnPut(key proto.Key, origMetaKeySize, origMetaVa
 // updateStatsOnResolve updates stat counters with the difference
 // between the original and new metadata sizes. The size of the
 // resolved value (key & bytes) are subtracted from the intents
-// counters if commit=true.
+// counters if commit=true. origTimestamp is the intent's timestamp
+// before it was resolved.
 func (ms *MVCCStats) updateStatsOnResolve(key proto.Key, origMetaKeySize, origMetaValSize,
-	metaKeySize, metaValSize int64, meta *proto.MVCCMetadata, commit bool) {
+	metaKeySize, metaValSize int64, origTimestamp proto.Timestamp, meta *proto.MVCCMetadata, commit bool) {
 	if !ms.updateStatsForKey(key) {
 		return
 	}
+	// A resolved deletion tombstone and its metadata remain non-live,
+	// but may have moved to a new timestamp.
+	if meta.Deleted {
+		ms.updateGCBytesAge(-(origMetaKeySize + origMetaValSize + meta.KeyBytes + meta.ValBytes), origTimestamp)
+		ms.updateGCBytesAge(metaKeySize+metaValSize+meta.KeyBytes+meta.ValBytes, meta.Timestamp)
+	}
 	// We're pushing or committing an intent; update counts with
 	// difference in bytes between old metadata and new.
 	keyDiff := metaKeySize - origMetaKeySize
@@ -206,6 +300,8 @@ func (ms *MVCCStats) updateStatsOnAbort(key proto.Key, origMetaKeySize, origMeta
 	if !orig.Deleted {
 		ms.LiveBytes -= (orig.KeyBytes + orig.ValBytes + origMetaKeySize + origMetaValSize)
 		ms.LiveCount--
+	} else {
+		ms.updateGCBytesAge(-(orig.KeyBytes + orig.ValBytes + origMetaKeySize + origMetaValSize), orig.Timestamp)
 	}
 	ms.KeyBytes -= (orig.KeyBytes + origMetaKeySize)
 	ms.ValBytes -= (orig.ValBytes + origMetaValSize)
@@ -219,6 +315,9 @@ func (ms *MVCCStats) updateStatsOnAbort(key proto.Key, origMetaKeySize, origMeta
 		if !restored.Deleted {
 			ms.LiveBytes += restored.KeyBytes + restored.ValBytes + restoredMetaKeySize + restoredMetaValSize
 			ms.LiveCount++
+			ms.updateGCBytesAge(-(restored.KeyBytes + restored.ValBytes), restored.Timestamp)
+		} else {
+			ms.updateGCBytesAge(restoredMetaKeySize+restoredMetaValSize, restored.Timestamp)
 		}
 		ms.KeyBytes += restoredMetaKeySize
 		ms.ValBytes += restoredMetaValSize
@@ -229,6 +328,24 @@ func (ms *MVCCStats) updateStatsOnAbort(key proto.Key, origMetaKeySize, origMeta
 	}
 }
 
+// updateStatsOnGC updates stat counters after garbage collection of
+// a historical version (meta=false) or of the metadata of a deleted
+// key (meta=true). Both are non-live, so only the key and value
+// counters and the GC bytes age change.
+func (ms *MVCCStats) updateStatsOnGC(key proto.Key, keySize, valSize int64, meta bool, timestamp proto.Timestamp) {
+	if !ms.updateStatsForKey(key) {
+		return
+	}
+	ms.KeyBytes -= keySize
+	ms.ValBytes -= valSize
+	if meta {
+		ms.KeyCount--
+	} else {
+		ms.ValCount--
+	}
+	ms.updateGCBytesAge(-(keySize + valSize), timestamp)
+}
+
 // MVCCGetRangeStats reads stat counters for the specified range and
 // returns an MVCCStats object on success.
 func MVCCGetRangeStats(engine Engine, raftID int64) (*MVCCStats, error) {
@@ -258,6 +375,12 @@ func MVCCGetRangeStats(engine Engine, raftID int64) (*MVCCStats, error) {
 	if ms.IntentCount, err = GetRangeStat(engine, raftID, StatIntentCount); err != nil {
 		return nil, err
 	}
+	if ms.GCBytesAge, err = GetRangeStat(engine, raftID, StatGCBytesAge); err != nil {
+		return nil, err
+	}
+	if ms.RangeTombstoneCount, err = GetRangeStat(engine, raftID, StatRangeTombstoneCount); err != nil {
+		return nil, err
+	}
 	return ms, nil
 }
 
@@ -265,7 +388,7 @@ func MVCCGetRangeStats(engine Engine, raftID int64) (*MVCCStats, error) {
 // it using a protobuf decoder. Returns true on success or false if
 // the key was not found.
 func MVCCGetProto(engine Engine, key proto.Key, timestamp proto.Timestamp, txn *proto.Transaction, msg gogoproto.Message) (bool, error) {
-	value, err := MVCCGet(engine, key, timestamp, txn)
+	value, err := MVCCGet(engine, key, timestamp, true, txn)
 	if err != nil {
 		return false, err
 	}
@@ -287,7 +410,7 @@ func MVCCPutProto(engine Engine, ms *MVCCStats, key proto.Key, timestamp proto.T
 	if err != nil {
 		return err
 	}
-	value := proto.Value{Bytes: data}
+	value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}}
 	value.InitChecksum(key)
 	return MVCCPut(engine, ms, key, timestamp, value, txn)
 }
@@ -297,6 +420,11 @@ func MVCCPutProto(engine Engine, ms *MVCCStats, key proto.Key, timestamp proto.T
 // arbitrary bytes. If no value for the key exists, or it has been
 // deleted, returns nil for value.
 //
+// A consistent read returns a WriteIntentError if it encounters
+// another transaction's intent; an inconsistent read, which may not
+// be transactional, returns the latest value written before the
+// intent instead.
+//
 // The values of multiple versions for the given key should
 // be organized as follows:
 // ...
@@ -307,9 +435,35 @@ func MVCCPutProto(engine Engine, ms *MVCCStats, key proto.Key, timestamp proto.T
 // keyA_Timestamp_0 : value of version_0
 // keyB : MVCCMetadata of keyB
 // ...
-func MVCCGet(engine Engine, key proto.Key, timestamp proto.Timestamp, txn *proto.Transaction) (*proto.Value, error) {
+func MVCCGet(engine Engine, key proto.Key, timestamp proto.Timestamp, consistent bool, txn *proto.Transaction) (*proto.Value, error) {
+	value, _, err := mvccGet(engine, key, timestamp, consistent, txn)
+	return value, err
+}
+
+// MVCCGetWithTombstones is like MVCCGet, except that if the key was
+// deleted as of timestamp, either by a deletion tombstone or by a
+// range tombstone, it returns a value holding only the timestamp of
+// the deletion and true.
+func MVCCGetWithTombstones(engine Engine, key proto.Key, timestamp proto.Timestamp, consistent bool, txn *proto.Transaction) (*proto.Value, bool, error) {
+	value, deleted, err := mvccGet(engine, key, timestamp, consistent, txn)
+	if err != nil {
+		return nil, false, err
+	}
+	if deleted != nil {
+		return &proto.Value{Timestamp: deleted}, true, nil
+	}
+	return value, false, nil
+}
+
+// mvccGet returns the value for key as of timestamp or, if the key was
+// deleted as of timestamp, the timestamp of the deletion.
+func mvccGet(engine Engine, key proto.Key, timestamp proto.Timestamp, consistent bool,
+	txn *proto.Transaction) (*proto.Value, *proto.Timestamp, error) {
 	if len(key) == 0 {
-		return nil, emptyKeyError()
+		return nil, nil, emptyKeyError()
+	}
+	if !consistent && txn != nil {
+		return nil, nil, inconsistentTxnError()
 	}
 
 	// Create a function which scans for the first key between next and end keys.
@@ -326,10 +480,14 @@ func MVCCGet(engine Engine, key proto.Key, timestamp proto.Timestamp, txn *proto
 	metaKey := MVCCEncodeKey(key)
 	data, err := engine.Get(metaKey)
 	if err != nil || data == nil {
-		return nil, err
+		return nil, nil, err
+	}
+	tombstones, err := mvccLoadRangeTombstones(engine, key, key.Next())
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return mvccGetInternal(engine, key, proto.RawKeyValue{Key: metaKey, Value: data}, timestamp, txn, earlier)
+	return mvccGetInternal(engine, key, proto.RawKeyValue{Key: metaKey, Value: data}, timestamp, consistent, txn, earlier, tombstones)
 }
 
 // getEarlierFunc fetches an earlier version of a key starting at
@@ -342,34 +500,58 @@ type getEarlierFunc func(engine Engine, start, end proto.EncodedKey) (proto.RawK
 // value, and reads the versioned value indicated by timestamp, taking
 // the transaction txn into account. earlier is a helper function to
 // get an earlier version of the value when doing historical reads.
-func mvccGetInternal(engine Engine, key proto.Key, kv proto.RawKeyValue, timestamp proto.Timestamp,
-	txn *proto.Transaction, earlier getEarlierFunc) (*proto.Value, error) {
+// Versions deleted by any of tombstones as of timestamp are masked.
+// If the version read was deleted, the value is nil and the timestamp
+// of the deletion is returned. Unless consistent is true, another
+// transaction's intent is skipped in favor of the version beneath it
+// rather than returned as a WriteIntentError.
+func mvccGetInternal(engine Engine, key proto.Key, kv proto.RawKeyValue, timestamp proto.Timestamp, consistent bool,
+	txn *proto.Transaction, earlier getEarlierFunc, tombstones rangeTombstones) (*proto.Value, *proto.Timestamp, error) {
 	meta := &proto.MVCCMetadata{}
 	err := gogoproto.Unmarshal(kv.Value, meta)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// If value is inline, return immediately; txn & timestamp are irrelevant.
 	if meta.IsInline() {
-		return meta.Value, nil
+		meta.Value.Upgrade()
+		return meta.Value, nil, nil
 	}
 
 	// First case: Our read timestamp is ahead of the latest write, or the
 	// latest write and current read are within the same transaction.
 	if !timestamp.Less(meta.Timestamp) ||
 		(meta.Txn != nil && txn != nil && bytes.Equal(meta.Txn.ID, txn.ID)) {
-		if meta.Txn != nil && (txn == nil || !bytes.Equal(meta.Txn.ID, txn.ID)) {
-			// Trying to read the last value, but it's another transaction's
-			// intent; the reader will have to act on this.
-			return nil, &proto.WriteIntentError{Key: key, Txn: *meta.Txn}
-		}
 		latestKey := MVCCEncodeVersionKey(key, meta.Timestamp)
-
-		// Check for case where we're reading our own txn's intent
-		// but it's got a different epoch. This can happen if the
-		// txn was restarted and an earlier iteration wrote the value
-		// we're now reading. In this case, we skip the intent.
-		if meta.Txn != nil && txn.Epoch != meta.Txn.Epoch {
+		if meta.Txn != nil && (txn == nil || !bytes.Equal(meta.Txn.ID, txn.ID)) {
+			if consistent {
+				// Trying to read the last value, but it's another transaction's
+				// intent; the reader will have to act on this.
+				return nil, nil, &proto.WriteIntentError{Key: key, Txn: *meta.Txn}
+			}
+			// An inconsistent read ignores the intent and reads the latest
+			// version written before it.
+			kv, err = earlier(engine, latestKey.Next(), MVCCEncodeKey(key.Next()))
+		} else if meta.Txn != nil && txn.Epoch != meta.Txn.Epoch {
+			// We're reading our own txn's intent but it's got a different
+			// epoch. This can happen if the txn was restarted and an earlier
+			// iteration wrote the value we're now reading. In this case, we
+			// skip the intent.
+			kv, err = earlier(engine, latestKey.Next(), MVCCEncodeKey(key.Next()))
+		} else if meta.Txn != nil && !txn.IsSequenceVisible(meta.Txn.Sequence) {
+			// The intent's latest write isn't visible to us, as it was
+			// rolled back or is from a later sequence. Read the latest
+			// earlier write retained in the intent history which is, or
+			// else the committed value.
+			if prev := mvccVisibleIntentHistory(meta, txn); prev != nil {
+				if prev.Value.Value != nil {
+					value := *prev.Value.Value
+					value.Upgrade()
+					value.Timestamp = &meta.Timestamp
+					return &value, nil, nil
+				}
+				return nil, &meta.Timestamp, nil
+			}
 			kv, err = earlier(engine, latestKey.Next(), MVCCEncodeKey(key.Next()))
 		} else {
 			kv.Key = latestKey
@@ -386,7 +568,7 @@ func mvccGetInternal(engine Engine, key proto.Key, kv proto.RawKeyValue, timesta
 			// absolute time if the writer had a fast clock.
 			// The reader should try again with a later timestamp than the
 			// one given below.
-			return nil, &proto.ReadWithinUncertaintyIntervalError{
+			return nil, nil, &proto.ReadWithinUncertaintyIntervalError{
 				Timestamp:         timestamp,
 				ExistingTimestamp: meta.Timestamp,
 			}
@@ -403,7 +585,7 @@ func mvccGetInternal(engine Engine, key proto.Key, kv proto.RawKeyValue, timesta
 				// value, but there is another previous write with the same issues
 				// as in the second case, so the reader will have to come again
 				// with a higher read timestamp.
-				return nil, &proto.ReadWithinUncertaintyIntervalError{
+				return nil, nil, &proto.ReadWithinUncertaintyIntervalError{
 					Timestamp:         timestamp,
 					ExistingTimestamp: ts,
 				}
@@ -420,28 +602,57 @@ func mvccGetInternal(engine Engine, key proto.Key, kv proto.RawKeyValue, timesta
 		kv, err = earlier(engine, nextKey, MVCCEncodeKey(key.Next()))
 	}
 	if kv.Value == nil || err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	_, ts, isValue := MVCCDecodeKey(kv.Key)
 	if !isValue {
-		return nil, util.Errorf("expected scan to versioned value reading key %q; got %q", key, kv.Key)
+		return nil, nil, util.Errorf("expected scan to versioned value reading key %q; got %q", key, kv.Key)
+	}
+
+	// Check whether the version is deleted by a range tombstone. A
+	// tombstone within the uncertainty interval is treated like any
+	// other write in that interval.
+	if txn != nil {
+		if rtTS, ok := tombstones.uncertain(key, ts, timestamp, txn.MaxTimestamp); ok {
+			return nil, nil, &proto.ReadWithinUncertaintyIntervalError{
+				Timestamp:         timestamp,
+				ExistingTimestamp: rtTS,
+			}
+		}
+	}
+	if rtTS, ok := tombstones.deletedAt(key, ts, timestamp); ok {
+		return nil, &rtTS, nil
 	}
 
 	// Unmarshal the mvcc value.
 	value := &proto.MVCCValue{}
 	if err := gogoproto.Unmarshal(kv.Value, value); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// Set the timestamp if the value is not nil (i.e. not a deletion tombstone).
-	if value.Value != nil {
-		value.Value.Timestamp = &ts
-	} else if !value.Deleted {
-		// Sanity check.
-		panic(fmt.Sprintf("encountered MVCC value at key %q with a nil proto.Value but with !Deleted: %+v", key, value))
+	if value.Value == nil {
+		if !value.Deleted {
+			// Sanity check.
+			panic(fmt.Sprintf("encountered MVCC value at key %q with a nil proto.Value but with !Deleted: %+v", key, value))
+		}
+		return nil, &ts, nil
 	}
+	value.Value.Upgrade()
+	value.Value.Timestamp = &ts
+
+	return value.Value, nil, nil
+}
 
-	return value.Value, nil
+// mvccVisibleIntentHistory returns the latest value in the intent's
+// history which is visible to txn, or nil if there is none.
+func mvccVisibleIntentHistory(meta *proto.MVCCMetadata, txn *proto.Transaction) *proto.MVCCIntentValue {
+	for i := len(meta.IntentHistory) - 1; i >= 0; i-- {
+		if txn.IsSequenceVisible(meta.IntentHistory[i].Sequence) {
+			return &meta.IntentHistory[i]
+		}
+	}
+	return nil
 }
 
 // MVCCPut sets the value for a specified key. It will save the value
@@ -456,12 +667,16 @@ func mvccGetInternal(engine Engine, key proto.Key, kv proto.RawKeyValue, timesta
 // single row and never accumulate more than a single value. Successive
 // zero timestamp writes to a key replace the value and deletes clear
 // the value. In addition, zero timestamp values may be merged.
+//
+// Values encoded before proto.ValuePayload existed are upgraded before
+// they're written.
 func MVCCPut(engine Engine, ms *MVCCStats, key proto.Key, timestamp proto.Timestamp, value proto.Value, txn *proto.Transaction) error {
 	if value.Timestamp != nil && !value.Timestamp.Equal(timestamp) {
 		return util.Errorf(
 			"the timestamp %+v provided in value does not match the timestamp %+v in request",
 			value.Timestamp, timestamp)
 	}
+	value.Upgrade()
 	return mvccPutInternal(engine, ms, key, timestamp, proto.MVCCValue{Value: &value}, txn)
 }
 
@@ -478,8 +693,10 @@ func mvccPutInternal(engine Engine, ms *MVCCStats, key proto.Key, timestamp prot
 		return emptyKeyError()
 	}
 	metaKey := MVCCEncodeKey(key)
-	if value.Value != nil && value.Value.Bytes != nil && value.Value.Integer != nil {
-		return util.Errorf("key %q value contains both a byte slice and an integer value: %+v", key, value)
+	if value.Value != nil {
+		if err := value.Value.VerifyType(key); err != nil {
+			return err
+		}
 	}
 
 	meta := &proto.MVCCMetadata{}
@@ -493,6 +710,11 @@ func mvccPutInternal(engine Engine, ms *MVCCStats, key proto.Key, timestamp prot
 	if ok && putIsInline != meta.IsInline() {
 		return util.Errorf("put is inline=%t, but existing value is inline=%t", putIsInline, meta.IsInline())
 	}
+	if !putIsInline {
+		if err := mvccCheckRangeTombstones(engine, key, timestamp); err != nil {
+			return err
+		}
+	}
 	if putIsInline {
 		var metaKeySize, metaValSize int64
 		if value.Deleted {
@@ -516,6 +738,32 @@ func mvccPutInternal(engine Engine, ms *MVCCStats, key proto.Key, timestamp prot
 			return &proto.WriteIntentError{Key: key, Txn: *meta.Txn}
 		}
 
+		// Writes to our own intent in the same epoch carry increasing
+		// sequence numbers. A write with an earlier sequence than the
+		// intent's is a replay; just ignore it. A later write records the
+		// value it replaces in the intent history if a savepoint taken
+		// in between may restore it. Values which have been rolled back
+		// can't be restored and are dropped from the history.
+		var history []proto.MVCCIntentValue
+		if meta.Txn != nil && txn.Epoch == meta.Txn.Epoch && txn.Sequence > 0 {
+			if txn.Sequence < meta.Txn.Sequence {
+				return nil
+			}
+			for _, iv := range meta.IntentHistory {
+				if !txn.IsSequenceIgnored(iv.Sequence) {
+					history = append(history, iv)
+				}
+			}
+			if txn.Sequence > meta.Txn.Sequence && !txn.IsSequenceIgnored(meta.Txn.Sequence) &&
+				txn.HasSavepointIn(meta.Txn.Sequence, txn.Sequence) {
+				prev := proto.MVCCIntentValue{Sequence: meta.Txn.Sequence}
+				if _, _, _, err := GetProto(engine, MVCCEncodeVersionKey(key, meta.Timestamp), &prev.Value); err != nil {
+					return err
+				}
+				history = append(history, prev)
+			}
+		}
+
 		// We can update the current metadata only if both the timestamp
 		// and epoch of the new intent are greater than or equal to
 		// existing. If either of these conditions doesn't hold, it's
@@ -530,7 +778,7 @@ func mvccPutInternal(engine Engine, ms *MVCCStats, key proto.Key, timestamp prot
 			if meta.Txn != nil && !timestamp.Equal(meta.Timestamp) {
 				engine.Clear(MVCCEncodeVersionKey(key, meta.Timestamp))
 			}
-			newMeta = &proto.MVCCMetadata{Txn: txn, Timestamp: timestamp}
+			newMeta = &proto.MVCCMetadata{Txn: txn, Timestamp: timestamp, IntentHistory: history}
 		} else if timestamp.Less(meta.Timestamp) && meta.Txn == nil {
 			// If we receive a Put request to write before an already-
 			// committed version, send write tool old error.
@@ -582,7 +830,7 @@ func MVCCIncrement(engine Engine, ms *MVCCStats, key proto.Key, timestamp proto.
 	// the potential write intent by another concurrent transaction
 	// with a newer timestamp, we need to use the max timestamp
 	// while reading.
-	value, err := MVCCGet(engine, key, proto.MaxTimestamp, txn)
+	value, err := MVCCGet(engine, key, proto.MaxTimestamp, true, txn)
 	if err != nil {
 		return 0, err
 	}
@@ -590,7 +838,7 @@ func MVCCIncrement(engine Engine, ms *MVCCStats, key proto.Key, timestamp proto.
 	var int64Val int64
 	// If the value exists, verify it's an integer type not a byte slice.
 	if value != nil {
-		if value.Bytes != nil || value.Integer == nil {
+		if value.GetValueType() != proto.INT {
 			return 0, util.Errorf("cannot increment key %q which already has a generic byte value: %+v", key, *value)
 		}
 		int64Val = value.GetInteger()
@@ -607,7 +855,8 @@ func MVCCIncrement(engine Engine, ms *MVCCStats, key proto.Key, timestamp proto.
 	}
 
 	r := int64Val + inc
-	value = &proto.Value{Integer: gogoproto.Int64(r)}
+	value = &proto.Value{}
+	value.SetInteger(r)
 	value.InitChecksum(key)
 	return r, MVCCPut(engine, ms, key, timestamp, *value, txn)
 }
@@ -621,11 +870,32 @@ func MVCCConditionalPut(engine Engine, ms *MVCCStats, key proto.Key, timestamp p
 	// the potential write intent by another concurrent transaction
 	// with a newer timestamp, we need to use the max timestamp
 	// while reading.
-	existVal, err := MVCCGet(engine, key, proto.MaxTimestamp, txn)
+	existVal, err := MVCCGet(engine, key, proto.MaxTimestamp, true, txn)
 	if err != nil {
 		return err
 	}
+	if err := mvccCheckExpValue(existVal, expValue); err != nil {
+		return err
+	}
+
+	return MVCCPut(engine, ms, key, timestamp, value, txn)
+}
+
+// MVCCVerifyValue returns a ConditionFailedError unless the most
+// recent value of key matches expValue. A nil expValue expects there
+// to be no value. As with MVCCConditionalPut, the key is read at the
+// max timestamp so that a newer write intent is detected.
+func MVCCVerifyValue(engine Engine, key proto.Key, expValue *proto.Value) error {
+	existVal, err := MVCCGet(engine, key, proto.MaxTimestamp, true, nil)
+	if err != nil {
+		return err
+	}
+	return mvccCheckExpValue(existVal, expValue)
+}
 
+// mvccCheckExpValue returns a ConditionFailedError unless existVal
+// matches expValue. A nil expValue expects there to be no value.
+func mvccCheckExpValue(existVal, expValue *proto.Value) error {
 	if expValue == nil && existVal != nil {
 		return &proto.ConditionFailedError{
 			ActualValue: existVal,
@@ -634,21 +904,85 @@ func MVCCConditionalPut(engine Engine, ms *MVCCStats, key proto.Key, timestamp p
 		// Handle check for existence when there is no key.
 		if existVal == nil {
 			return &proto.ConditionFailedError{}
-		} else if expValue.Bytes != nil && !bytes.Equal(expValue.Bytes, existVal.Bytes) {
-			return &proto.ConditionFailedError{
-				ActualValue: existVal,
-			}
-		} else if expValue.Integer != nil && (existVal.Integer == nil || expValue.GetInteger() != existVal.GetInteger()) {
+		}
+		exp := *expValue
+		exp.Upgrade()
+		expValue = &exp
+		var mismatch bool
+		existType := existVal.GetValueType()
+		switch expValue.GetValueType() {
+		case proto.BYTES, proto.TIMESERIES:
+			mismatch = !bytes.Equal(expValue.Bytes, existVal.Bytes)
+		case proto.INT:
+			mismatch = existType != proto.INT || expValue.GetInteger() != existVal.GetInteger()
+		case proto.FLOAT:
+			mismatch = existType != proto.FLOAT || expValue.GetFloat() != existVal.GetFloat()
+		}
+		if mismatch {
 			return &proto.ConditionFailedError{
 				ActualValue: existVal,
 			}
 		}
 	}
+	return nil
+}
 
-	return MVCCPut(engine, ms, key, timestamp, value, txn)
+// mvccGetInline returns the inline value of key, or nil if the key has
+// no value. Returns an error if the key holds versioned values.
+func mvccGetInline(engine Engine, key proto.Key) (*proto.Value, error) {
+	if len(key) == 0 {
+		return nil, emptyKeyError()
+	}
+	meta := &proto.MVCCMetadata{}
+	ok, _, _, err := GetProto(engine, MVCCEncodeKey(key), meta)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if !meta.IsInline() {
+		return nil, util.Errorf("key %q has versioned values; inline operations are not permitted", key)
+	}
+	meta.Value.Upgrade()
+	return meta.Value, nil
 }
 
-// MVCCMerge implements a merge operation. Merge adds integer values,
+// MVCCConditionalPutInline sets the inline value of key if its
+// existing inline value matches expValue; a nil expValue requires that
+// the key have no value. Inline values are written without a timestamp
+// and keep no history, so a key written this way can never acquire
+// versioned values (or intents), and a key with versioned values is
+// never written inline. On mismatch, a ConditionFailedError containing
+// the actual value is returned.
+func MVCCConditionalPutInline(engine Engine, ms *MVCCStats, key proto.Key, value proto.Value, expValue *proto.Value) error {
+	existVal, err := mvccGetInline(engine, key)
+	if err != nil {
+		return err
+	}
+	if err := mvccCheckExpValue(existVal, expValue); err != nil {
+		return err
+	}
+	return MVCCPut(engine, ms, key, proto.ZeroTimestamp, value, nil)
+}
+
+// MVCCDeleteInline clears the inline value of key. If expValue is not
+// nil, the key must hold a matching inline value or a
+// ConditionFailedError is returned.
+func MVCCDeleteInline(engine Engine, ms *MVCCStats, key proto.Key, expValue *proto.Value) error {
+	existVal, err := mvccGetInline(engine, key)
+	if err != nil {
+		return err
+	}
+	if expValue != nil {
+		if err := mvccCheckExpValue(existVal, expValue); err != nil {
+			return err
+		}
+	}
+	if existVal == nil {
+		return nil
+	}
+	return MVCCDelete(engine, ms, key, proto.ZeroTimestamp, nil)
+}
+
+// MVCCMerge implements a merge operation. Merge adds integer and float values,
 // concatenates undifferentiated byte slice values, and efficiently
 // combines time series observations if the proto.Value tag value
 // indicates the value byte slice is of type _CR_TS (the internal
@@ -660,6 +994,7 @@ func MVCCMerge(engine Engine, ms *MVCCStats, key proto.Key, value proto.Value) e
 	metaKey := MVCCEncodeKey(key)
 
 	// Encode and merge the MVCC metadata with inlined value.
+	value.Upgrade()
 	meta := &proto.MVCCMetadata{Value: &value}
 	data, err := gogoproto.Marshal(meta)
 	if err != nil {
@@ -676,7 +1011,7 @@ func MVCCDeleteRange(engine Engine, ms *MVCCStats, key, endKey proto.Key, max in
 	// In order to detect the potential write intent by another
 	// concurrent transaction with a newer timestamp, we need
 	// to use the max timestamp for scan.
-	kvs, err := MVCCScan(engine, key, endKey, max, proto.MaxTimestamp, txn)
+	kvs, err := MVCCScan(engine, key, endKey, max, proto.MaxTimestamp, true, txn)
 	if err != nil {
 		return 0, err
 	}
@@ -694,11 +1029,28 @@ func MVCCDeleteRange(engine Engine, ms *MVCCStats, key, endKey proto.Key, max in
 
 // MVCCScan scans the key range specified by start key through end key
 // up to some maximum number of results. Specify max=0 for unbounded
-// scans.
-func MVCCScan(engine Engine, key, endKey proto.Key, max int64, timestamp proto.Timestamp, txn *proto.Transaction) ([]proto.KeyValue, error) {
+// scans. Intents are handled as for MVCCGet, according to consistent.
+func MVCCScan(engine Engine, key, endKey proto.Key, max int64, timestamp proto.Timestamp, consistent bool, txn *proto.Transaction) ([]proto.KeyValue, error) {
+	return mvccScanInternal(engine, key, endKey, max, timestamp, consistent, txn, false)
+}
+
+// MVCCScanWithTombstones is like MVCCScan, except that keys deleted as
+// of timestamp are returned as rows with Deleted set, whose values
+// hold only the timestamp of the deletion. Deleted rows count towards
+// max.
+func MVCCScanWithTombstones(engine Engine, key, endKey proto.Key, max int64, timestamp proto.Timestamp, consistent bool, txn *proto.Transaction) ([]proto.KeyValue, error) {
+	return mvccScanInternal(engine, key, endKey, max, timestamp, consistent, txn, true)
+}
+
+// mvccScanInternal implements MVCCScan and MVCCScanWithTombstones.
+func mvccScanInternal(engine Engine, key, endKey proto.Key, max int64, timestamp proto.Timestamp,
+	consistent bool, txn *proto.Transaction, includeTombstones bool) ([]proto.KeyValue, error) {
 	if len(endKey) == 0 {
 		return nil, emptyKeyError()
 	}
+	if !consistent && txn != nil {
+		return nil, inconsistentTxnError()
+	}
 	encKey := MVCCEncodeKey(key)
 	encEndKey := MVCCEncodeKey(endKey)
 
@@ -712,6 +1064,10 @@ func MVCCScan(engine Engine, key, endKey proto.Key, max int64, timestamp proto.T
 		}
 		return proto.RawKeyValue{}, iter.Error()
 	}
+	tombstones, err := mvccLoadRangeTombstones(engine, key, endKey)
+	if err != nil {
+		return nil, err
+	}
 
 	res := []proto.KeyValue{}
 	for {
@@ -723,12 +1079,12 @@ func MVCCScan(engine Engine, key, endKey proto.Key, max int64, timestamp proto.T
 		if isValue {
 			return nil, util.Errorf("expected an MVCC metadata key: %q", kv.Key)
 		}
-		value, err := mvccGetInternal(engine, key, kv, timestamp, txn, earlier)
+		value, deleted, err := mvccGetInternal(engine, key, kv, timestamp, consistent, txn, earlier, tombstones)
 		if err != nil {
 			return nil, err
 		}
-		if value != nil {
-			res = append(res, proto.KeyValue{Key: key, Value: *value})
+		if row, ok := mvccScanRow(key, value, deleted, includeTombstones); ok {
+			res = append(res, row)
 			if max != 0 && max == int64(len(res)) {
 				return res, nil
 			}
@@ -737,16 +1093,108 @@ func MVCCScan(engine Engine, key, endKey proto.Key, max int64, timestamp proto.T
 	}
 }
 
+// MVCCReverseScan scans the key range specified by start key through
+// end key in descending order, beginning with the last key before end
+// key, up to some maximum number of results. Specify max=0 for
+// unbounded scans.
+func MVCCReverseScan(engine Engine, key, endKey proto.Key, max int64, timestamp proto.Timestamp, consistent bool, txn *proto.Transaction) ([]proto.KeyValue, error) {
+	return mvccReverseScanInternal(engine, key, endKey, max, timestamp, consistent, txn, false)
+}
+
+// MVCCReverseScanWithTombstones is like MVCCReverseScan, except that
+// keys deleted as of timestamp are returned as for
+// MVCCScanWithTombstones.
+func MVCCReverseScanWithTombstones(engine Engine, key, endKey proto.Key, max int64, timestamp proto.Timestamp, consistent bool, txn *proto.Transaction) ([]proto.KeyValue, error) {
+	return mvccReverseScanInternal(engine, key, endKey, max, timestamp, consistent, txn, true)
+}
+
+// mvccReverseScanInternal implements MVCCReverseScan and
+// MVCCReverseScanWithTombstones.
+func mvccReverseScanInternal(engine Engine, key, endKey proto.Key, max int64, timestamp proto.Timestamp,
+	consistent bool, txn *proto.Transaction, includeTombstones bool) ([]proto.KeyValue, error) {
+	if len(endKey) == 0 {
+		return nil, emptyKeyError()
+	}
+	if !consistent && txn != nil {
+		return nil, inconsistentTxnError()
+	}
+	encKey := MVCCEncodeKey(key)
+	encEndKey := MVCCEncodeKey(endKey)
+
+	// Versions of each key are located via a forward seek from its
+	// metadata, exactly as for MVCCScan; only the step from key to key
+	// moves backwards.
+	iter := engine.NewIterator()
+	defer iter.Close()
+	earlier := func(engine Engine, start, end proto.EncodedKey) (proto.RawKeyValue, error) {
+		iter.Seek(start)
+		if iter.Valid() && bytes.Compare(iter.Key(), end) < 0 {
+			return proto.RawKeyValue{Key: iter.Key(), Value: iter.Value()}, nil
+		}
+		return proto.RawKeyValue{}, iter.Error()
+	}
+	tombstones, err := mvccLoadRangeTombstones(engine, key, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []proto.KeyValue{}
+	for {
+		// The entry preceding encEndKey is the oldest version (or the
+		// metadata) of the previous key.
+		iter.SeekReverse(encEndKey)
+		if !iter.Valid() || bytes.Compare(iter.Key(), encKey) < 0 {
+			return res, iter.Error()
+		}
+		key, _, _ := MVCCDecodeKey(iter.Key())
+		metaKey := MVCCEncodeKey(key)
+		data, err := engine.Get(metaKey)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			value, deleted, err := mvccGetInternal(engine, key, proto.RawKeyValue{Key: metaKey, Value: data}, timestamp, consistent, txn, earlier, tombstones)
+			if err != nil {
+				return nil, err
+			}
+			if row, ok := mvccScanRow(key, value, deleted, includeTombstones); ok {
+				res = append(res, row)
+				if max != 0 && max == int64(len(res)) {
+					return res, nil
+				}
+			}
+		}
+		encEndKey = metaKey
+	}
+}
+
+// mvccScanRow returns the row a scan yields for key given the result
+// of reading it, and false if the scan yields no row for the key.
+func mvccScanRow(key proto.Key, value *proto.Value, deleted *proto.Timestamp, includeTombstones bool) (proto.KeyValue, bool) {
+	if value != nil {
+		return proto.KeyValue{Key: key, Value: *value}, true
+	}
+	if deleted != nil && includeTombstones {
+		return proto.KeyValue{Key: key, Value: proto.Value{Timestamp: deleted}, Deleted: true}, true
+	}
+	return proto.KeyValue{}, false
+}
+
 // MVCCIterateCommitted iterates over the key range specified by start
 // and end keys, returning only the most recently committed version of
 // each key/value pair. Intents are ignored. If a key has an intent
-// but no earlier, committed version, nothing is returned. At each
+// but no earlier, committed version, nothing is returned. Versions
+// deleted by a range tombstone are skipped. At each
 // step of the iteration, f() is invoked with the current key/value
 // pair. If f returns true (done) or an error, the iteration stops and
 // the error is propagated.
 func MVCCIterateCommitted(engine Engine, key, endKey proto.Key, f func(proto.KeyValue) (bool, error)) error {
 	encKey := MVCCEncodeKey(key)
 	encEndKey := MVCCEncodeKey(endKey)
+	tombstones, err := mvccLoadRangeTombstones(engine, key, endKey)
+	if err != nil {
+		return err
+	}
 
 	var currentKey proto.Key        // The current unencoded key
 	var versionKey proto.EncodedKey // Need to read this version of the key
@@ -765,6 +1213,7 @@ func MVCCIterateCommitted(engine Engine, key, endKey proto.Key, f func(proto.Key
 			nextKey = MVCCEncodeKey(currentKey.Next())
 			if meta.IsInline() {
 				versionKey = nextKey
+				meta.Value.Upgrade()
 				return f(proto.KeyValue{Key: currentKey, Value: *meta.Value})
 			}
 			// If most recent value isn't an intent, the key to read will be next in iteration.
@@ -790,9 +1239,10 @@ func MVCCIterateCommitted(engine Engine, key, endKey proto.Key, f func(proto.Key
 				if err := gogoproto.Unmarshal(rawKV.Value, value); err != nil {
 					return false, err
 				}
-				if value.Deleted {
+				if value.Deleted || tombstones.masks(currentKey, ts, proto.MaxTimestamp) {
 					return false, nil
 				}
+				value.Value.Upgrade()
 				value.Value.Timestamp = &ts
 				return f(proto.KeyValue{Key: currentKey, Value: *value.Value})
 			}
@@ -848,14 +1298,33 @@ func MVCCResolveWriteIntent(engine Engine, ms *MVCCStats, key proto.Key, txn *pr
 	// timestamp-encoded key) if timestamp changed.
 	commit := txn.Status == proto.COMMITTED
 	pushed := txn.Status == proto.PENDING && meta.Txn.Timestamp.Less(txn.Timestamp)
+	// If the intent's latest write was rolled back to a savepoint,
+	// restore the latest write which wasn't before committing, or
+	// abort the intent if there is none.
+	if commit && meta.Txn.Epoch == txn.Epoch && txn.IsSequenceIgnored(meta.Txn.Sequence) {
+		restored, metaKeySize, metaValSize, err := mvccRestoreIntentHistory(engine, ms, key, meta, origMetaKeySize, origMetaValSize, txn)
+		if err != nil {
+			return err
+		}
+		if restored != nil {
+			meta, origMetaKeySize, origMetaValSize = restored, metaKeySize, metaValSize
+		} else {
+			commit = false
+		}
+	}
 	if (commit || pushed) && meta.Txn.Epoch == txn.Epoch {
 		origTimestamp := meta.Timestamp
 		newMeta := *meta
 		newMeta.Timestamp = txn.Timestamp
 		if pushed { // keep intent if we're pushing timestamp
-			newMeta.Txn = txn
+			// The pusher's copy of the txn doesn't know the sequence
+			// of the intent's write.
+			pushedTxn := *txn
+			pushedTxn.Sequence = meta.Txn.Sequence
+			newMeta.Txn = &pushedTxn
 		} else {
 			newMeta.Txn = nil
+			newMeta.IntentHistory = nil
 		}
 		metaKeySize, metaValSize, err := PutProto(engine, metaKey, &newMeta)
 		if err != nil {
@@ -863,7 +1332,7 @@ func MVCCResolveWriteIntent(engine Engine, ms *MVCCStats, key proto.Key, txn *pr
 		}
 
 		// Update stat counters related to resolving the intent.
-		ms.updateStatsOnResolve(key, origMetaKeySize, origMetaValSize, metaKeySize, metaValSize, &newMeta, commit)
+		ms.updateStatsOnResolve(key, origMetaKeySize, origMetaValSize, metaKeySize, metaValSize, origTimestamp, &newMeta, commit)
 
 		// If timestamp of value changed, need to rewrite versioned value.
 		// TODO(spencer,tobias): think about a new merge operator for
@@ -940,6 +1409,44 @@ func MVCCResolveWriteIntent(engine Engine, ms *MVCCStats, key proto.Key, txn *pr
 	return nil
 }
 
+// mvccRestoreIntentHistory replaces the intent's value with the
+// latest value in its history which txn hasn't rolled back, and
+// returns the updated metadata and its encoded key and value sizes.
+// Returns nil metadata if there's no such value.
+func mvccRestoreIntentHistory(engine Engine, ms *MVCCStats, key proto.Key, meta *proto.MVCCMetadata,
+	origMetaKeySize, origMetaValSize int64, txn *proto.Transaction) (*proto.MVCCMetadata, int64, int64, error) {
+	var i int
+	for i = len(meta.IntentHistory) - 1; i >= 0; i-- {
+		if !txn.IsSequenceIgnored(meta.IntentHistory[i].Sequence) {
+			break
+		}
+	}
+	if i < 0 {
+		return nil, 0, 0, nil
+	}
+	prev := meta.IntentHistory[i]
+	valueKeySize, valueSize, err := PutProto(engine, MVCCEncodeVersionKey(key, meta.Timestamp), &prev.Value)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	intentTxn := *meta.Txn
+	intentTxn.Sequence = prev.Sequence
+	newMeta := &proto.MVCCMetadata{
+		Txn:           &intentTxn,
+		Timestamp:     meta.Timestamp,
+		Deleted:       prev.Value.Deleted,
+		KeyBytes:      valueKeySize,
+		ValBytes:      valueSize,
+		IntentHistory: meta.IntentHistory[:i],
+	}
+	metaKeySize, metaValSize, err := PutProto(engine, MVCCEncodeKey(key), newMeta)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	ms.updateStatsOnPut(key, origMetaKeySize, origMetaValSize, metaKeySize, metaValSize, meta, newMeta)
+	return newMeta, metaKeySize, metaValSize, nil
+}
+
 // MVCCResolveWriteIntentRange commits or aborts (rolls back) the
 // range of write intents specified by start and end keys for a given
 // txn. ResolveWriteIntentRange will skip write intents of other
@@ -994,10 +1501,74 @@ func MVCCResolveWriteIntentRange(engine Engine, ms *MVCCStats, key, endKey proto
 //   - \x00acct < SplitKey < \x00accu
 //   - \x00perm < SplitKey < \x00pern
 //   - \x00zone < SplitKey < \x00zonf
+//
+// Split keys within a co-location group are also invalid; see
+// RegisterColocation.
 func IsValidSplitKey(key proto.Key) bool {
 	return isValidEncodedSplitKey(MVCCEncodeKey(key))
 }
 
+// A ColocationFunc returns the prefix shared by key and all other keys
+// which should be kept in the same range as key (for example, the key
+// of a row for the keys stored beneath it), or nil if key may be
+// separated from its neighbors.
+type ColocationFunc func(key proto.Key) proto.Key
+
+// colocations holds the registered ColocationFuncs, sorted by
+// descending prefix length so that the longest matching prefix is
+// found first.
+var colocations struct {
+	sync.RWMutex
+	entries []colocationEntry
+}
+
+type colocationEntry struct {
+	prefix proto.Key
+	fn     ColocationFunc
+}
+
+// RegisterColocation registers fn to determine the co-location group
+// of keys with the given prefix. Ranges are never split within a
+// co-location group; only the first key of a group is a valid split
+// key. This keeps related keys in the same range so that transactions
+// which update them usually address a single range and qualify for a
+// one-phase commit. Registering a prefix again replaces its function.
+func RegisterColocation(prefix proto.Key, fn ColocationFunc) {
+	colocations.Lock()
+	defer colocations.Unlock()
+	for i := range colocations.entries {
+		if colocations.entries[i].prefix.Equal(prefix) {
+			colocations.entries[i].fn = fn
+			return
+		}
+	}
+	colocations.entries = append(colocations.entries, colocationEntry{prefix: prefix, fn: fn})
+	for i := len(colocations.entries) - 1; i > 0; i-- {
+		if len(colocations.entries[i].prefix) <= len(colocations.entries[i-1].prefix) {
+			break
+		}
+		colocations.entries[i], colocations.entries[i-1] = colocations.entries[i-1], colocations.entries[i]
+	}
+}
+
+// isColocationBoundary returns false if the key falls within, but
+// isn't the first key of, a co-location group.
+func isColocationBoundary(key proto.EncodedKey) bool {
+	colocations.RLock()
+	defer colocations.RUnlock()
+	if len(colocations.entries) == 0 {
+		return true
+	}
+	humanKey, _, _ := MVCCDecodeKey(key)
+	for _, e := range colocations.entries {
+		if bytes.HasPrefix(humanKey, e.prefix) {
+			group := e.fn(humanKey)
+			return group == nil || group.Equal(humanKey)
+		}
+	}
+	return true
+}
+
 // illegalSplitKeyRanges detail illegal ranges for split keys,
 // exclusive of start and end.
 var illegalSplitKeyRanges = []struct {
@@ -1029,18 +1600,80 @@ func isValidEncodedSplitKey(key proto.EncodedKey) bool {
 			return false
 		}
 	}
-	return true
+	return isColocationBoundary(key)
+}
+
+// MVCCGarbageCollect removes the versions listed by keys. Each entry
+// names a key and the timestamp of one of its versions. If that is
+// the key's newest version, it must be a committed deletion
+// tombstone, and the key is removed entirely: all of its versions as
+// well as its metadata. Keys with an intent are skipped, as aborting
+// the intent may need to restore an earlier version. Versions which
+// no longer exist are ignored.
+func MVCCGarbageCollect(engine Engine, ms *MVCCStats, keys []proto.InternalGCRequest_GCKey) error {
+	for _, gcKey := range keys {
+		if len(gcKey.Key) == 0 {
+			return emptyKeyError()
+		}
+		metaKey := MVCCEncodeKey(gcKey.Key)
+		meta := &proto.MVCCMetadata{}
+		ok, metaKeySize, metaValSize, err := GetProto(engine, metaKey, meta)
+		if err != nil {
+			return err
+		}
+		if !ok || meta.IsInline() || meta.Txn != nil {
+			continue
+		}
+		// Remove a single historical version.
+		if gcKey.Timestamp.Less(meta.Timestamp) {
+			versionKey := MVCCEncodeVersionKey(gcKey.Key, gcKey.Timestamp)
+			value, err := engine.Get(versionKey)
+			if err != nil {
+				return err
+			}
+			if value != nil {
+				if err := engine.Clear(versionKey); err != nil {
+					return err
+				}
+				ms.updateStatsOnGC(gcKey.Key, int64(len(versionKey)), int64(len(value)), false, gcKey.Timestamp)
+			}
+			continue
+		}
+		if !meta.Deleted || !gcKey.Timestamp.Equal(meta.Timestamp) {
+			return util.Errorf("cannot garbage collect the latest value of %q unless it's deleted", gcKey.Key)
+		}
+		// Remove the key entirely.
+		kvs, err := Scan(engine, MVCCEncodeVersionKey(gcKey.Key, meta.Timestamp), MVCCEncodeKey(gcKey.Key.Next()), 0)
+		if err != nil {
+			return err
+		}
+		for _, kv := range kvs {
+			_, ts, isValue := MVCCDecodeKey(kv.Key)
+			if !isValue {
+				return util.Errorf("expected an MVCC value key: %s", kv.Key)
+			}
+			if err := engine.Clear(kv.Key); err != nil {
+				return err
+			}
+			ms.updateStatsOnGC(gcKey.Key, int64(len(kv.Key)), int64(len(kv.Value)), false, ts)
+		}
+		if err := engine.Clear(metaKey); err != nil {
+			return err
+		}
+		ms.updateStatsOnGC(gcKey.Key, metaKeySize, metaValSize, true, meta.Timestamp)
+	}
+	return nil
 }
 
 // MVCCFindSplitKey suggests a split key from the given user-space key
 // range that aims to roughly cut into half the total number of bytes
-// used (in raw key and value byte strings) in both subranges. It will
-// operate on a snapshot of the underlying engine if a snapshotID is
-// given, and in that case may safely be invoked in a goroutine.
+// used (in raw key and value byte strings) in both subranges. If
+// engine is a snapshot (see Engine.NewSnapshot), it may safely be
+// invoked in a goroutine.
 //
 // The split key will never be chosen from the key ranges listed in
 // illegalSplitKeyRanges.
-func MVCCFindSplitKey(engine Engine, raftID int64, key, endKey proto.Key, snapshotID string) (proto.Key, error) {
+func MVCCFindSplitKey(engine Engine, raftID int64, key, endKey proto.Key) (proto.Key, error) {
 	if key.Less(KeyLocalMax) {
 		key = KeyLocalMax
 	}
@@ -1058,7 +1691,7 @@ func MVCCFindSplitKey(engine Engine, raftID int64, key, endKey proto.Key, snapsh
 	bestSplitKey := encStartKey
 	bestSplitDiff := int64(math.MaxInt64)
 
-	if err := engine.IterateSnapshot(encStartKey, encEndKey, snapshotID, func(kv proto.RawKeyValue) (bool, error) {
+	if err := engine.Iterate(encStartKey, encEndKey, func(kv proto.RawKeyValue) (bool, error) {
 		// Is key within a legal key range?
 		valid := isValidEncodedSplitKey(kv.Key)
 
@@ -1098,7 +1731,8 @@ func MVCCFindSplitKey(engine Engine, raftID int64, key, endKey proto.Key, snapsh
 // used after a range is split to recompute stats for each
 // subrange. The start key is always adjusted to avoid counting local
 // keys in the event stats are being recomputed for the first range
-// (i.e. the one with start key == KeyMin).
+// (i.e. the one with start key == KeyMin). Range tombstones starting
+// between the start and end keys are counted as well.
 func MVCCComputeStats(engine Engine, key, endKey proto.Key) (MVCCStats, error) {
 	if key.Less(KeyLocalMax) {
 		key = KeyLocalMax
@@ -1107,10 +1741,17 @@ func MVCCComputeStats(engine Engine, key, endKey proto.Key) (MVCCStats, error) {
 	encEndKey := MVCCEncodeKey(endKey)
 
 	ms := MVCCStats{}
+	if err := engine.Iterate(MVCCEncodeKey(MakeLocalKey(KeyLocalRangeTombstonePrefix, key)),
+		MVCCEncodeKey(MakeLocalKey(KeyLocalRangeTombstonePrefix, endKey)), func(_ proto.RawKeyValue) (bool, error) {
+			ms.RangeTombstoneCount++
+			return false, nil
+		}); err != nil {
+		return ms, err
+	}
 	first := false
 	meta := &proto.MVCCMetadata{}
 	err := engine.Iterate(encStartKey, encEndKey, func(kv proto.RawKeyValue) (bool, error) {
-		_, _, isValue := MVCCDecodeKey(kv.Key)
+		_, ts, isValue := MVCCDecodeKey(kv.Key)
 		if !isValue {
 			first = true
 			if err := gogoproto.Unmarshal(kv.Value, meta); err != nil {
@@ -1119,6 +1760,8 @@ func MVCCComputeStats(engine Engine, key, endKey proto.Key) (MVCCStats, error) {
 			if !meta.Deleted {
 				ms.LiveBytes += int64(len(kv.Value)) + int64(len(kv.Key))
 				ms.LiveCount++
+			} else {
+				ms.updateGCBytesAge(int64(len(kv.Value))+int64(len(kv.Key)), meta.Timestamp)
 			}
 			ms.KeyBytes += int64(len(kv.Key))
 			ms.ValBytes += int64(len(kv.Value))
@@ -1131,6 +1774,8 @@ func MVCCComputeStats(engine Engine, key, endKey proto.Key) (MVCCStats, error) {
 				first = false
 				if !meta.Deleted {
 					ms.LiveBytes += int64(len(kv.Value)) + int64(len(kv.Key))
+				} else {
+					ms.updateGCBytesAge(int64(len(kv.Value))+int64(len(kv.Key)), ts)
 				}
 				if meta.Txn != nil {
 					ms.IntentBytes += int64(len(kv.Key)) + int64(len(kv.Value))
@@ -1142,6 +1787,9 @@ func MVCCComputeStats(engine Engine, key, endKey proto.Key) (MVCCStats, error) {
 				if meta.ValBytes != int64(len(kv.Value)) {
 					return false, util.Errorf("expected mvcc metadata val bytes to equal %d; got %d", len(kv.Value), meta.ValBytes)
 				}
+			} else {
+				// Historical versions are never live.
+				ms.updateGCBytesAge(int64(len(kv.Key))+int64(len(kv.Value)), ts)
 			}
 			ms.KeyBytes += int64(len(kv.Key))
 			ms.ValBytes += int64(len(kv.Value))
@@ -1151,42 +1799,3 @@ func MVCCComputeStats(engine Engine, key, endKey proto.Key) (MVCCStats, error) {
 	})
 	return ms, err
 }
-
-// MVCCEncodeKey makes an MVCC key for storing MVCC metadata or
-// for storing raw values directly. Use MVCCEncodeVersionValue for
-// storing timestamped version values.
-func MVCCEncodeKey(key proto.Key) proto.EncodedKey {
-	return encoding.EncodeBinary(nil, key)
-}
-
-// MVCCEncodeVersionKey makes an MVCC version key, which consists
-// of a binary-encoding of key, followed by a decreasing encoding
-// of the timestamp, so that more recent versions sort first.
-func MVCCEncodeVersionKey(key proto.Key, timestamp proto.Timestamp) proto.EncodedKey {
-	if timestamp.WallTime < 0 || timestamp.Logical < 0 {
-		panic(fmt.Sprintf("negative values disallowed in timestamps: %+v", timestamp))
-	}
-	k := encoding.EncodeBinary(nil, key)
-	k = encoding.EncodeUint64Decreasing(k, uint64(timestamp.WallTime))
-	k = encoding.EncodeUint32Decreasing(k, uint32(timestamp.Logical))
-	return k
-}
-
-// MVCCDecodeKey decodes encodedKey by binary decoding the leading
-// bytes of encodedKey. If there are no remaining bytes, returns the
-// decoded key, an empty timestamp, and false, to indicate the key is
-// for an MVCC metadata or a raw value. Otherwise, there must be
-// exactly 12 trailing bytes and they're decoded into a timestamp.
-// The decoded key, timestamp and true are returned to indicate the
-// key is for an MVCC versioned value.
-func MVCCDecodeKey(encodedKey proto.EncodedKey) (proto.Key, proto.Timestamp, bool) {
-	tsBytes, key := encoding.DecodeBinary(encodedKey)
-	if len(tsBytes) == 0 {
-		return key, proto.Timestamp{}, false
-	} else if len(tsBytes) != 12 {
-		panic(fmt.Sprintf("there should be 12 bytes for encoded timestamp: %q", tsBytes))
-	}
-	tsBytes, walltime := encoding.DecodeUint64Decreasing(tsBytes)
-	tsBytes, logical := encoding.DecodeUint32Decreasing(tsBytes)
-	return key, proto.Timestamp{WallTime: int64(walltime), Logical: int32(logical)}, true
-}
@@ -0,0 +1,148 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// The MVCC key encoding. This is mirrored in C++ by
+// roachlib/encoding.{h,cc}, which is used by the RocksDB comparator;
+// any change here must be reflected there. The two implementations
+// are verified against each other in mvcc_key_test.go.
+
+// MVCCEncodeKey makes an MVCC key for storing MVCC metadata or
+// for storing raw values directly. Use MVCCEncodeVersionValue for
+// storing timestamped version values.
+func MVCCEncodeKey(key proto.Key) proto.EncodedKey {
+	return encoding.EncodeBinary(nil, key)
+}
+
+// MVCCEncodeVersionKey makes an MVCC version key, which consists
+// of a binary-encoding of key, followed by a decreasing encoding
+// of the timestamp, so that more recent versions sort first.
+func MVCCEncodeVersionKey(key proto.Key, timestamp proto.Timestamp) proto.EncodedKey {
+	if timestamp.WallTime < 0 || timestamp.Logical < 0 {
+		panic(fmt.Sprintf("negative values disallowed in timestamps: %+v", timestamp))
+	}
+	k := encoding.EncodeBinary(nil, key)
+	k = encoding.EncodeUint64Decreasing(k, uint64(timestamp.WallTime))
+	k = encoding.EncodeUint32Decreasing(k, uint32(timestamp.Logical))
+	return k
+}
+
+// MVCCDecodeKey decodes encodedKey by binary decoding the leading
+// bytes of encodedKey. If there are no remaining bytes, returns the
+// decoded key, an empty timestamp, and false, to indicate the key is
+// for an MVCC metadata or a raw value. Otherwise, there must be
+// exactly 12 trailing bytes and they're decoded into a timestamp.
+// The decoded key, timestamp and true are returned to indicate the
+// key is for an MVCC versioned value.
+func MVCCDecodeKey(encodedKey proto.EncodedKey) (proto.Key, proto.Timestamp, bool) {
+	tsBytes, key := encoding.DecodeBinary(encodedKey)
+	if len(tsBytes) == 0 {
+		return key, proto.Timestamp{}, false
+	} else if len(tsBytes) != 12 {
+		panic(fmt.Sprintf("there should be 12 bytes for encoded timestamp: %q", tsBytes))
+	}
+	tsBytes, walltime := encoding.DecodeUint64Decreasing(tsBytes)
+	tsBytes, logical := encoding.DecodeUint32Decreasing(tsBytes)
+	return key, proto.Timestamp{WallTime: int64(walltime), Logical: int32(logical)}, true
+}
+
+const (
+	// mvccBinaryEncodingMarker and mvccBinaryEncodingTerminator are the
+	// leading and trailing bytes of a key encoded via
+	// encoding.EncodeBinary. Bytes in between always have the high bit
+	// set, so the terminator unambiguously ends the user key.
+	mvccBinaryEncodingMarker     = 0x25
+	mvccBinaryEncodingTerminator = 0x00
+	// mvccVersionTimestampSize is the size of the timestamp suffix of
+	// an MVCC version key: a decreasing uint64 wall time followed by a
+	// decreasing uint32 logical clock.
+	mvccVersionTimestampSize = 12
+)
+
+// mvccSplitEncodedKey splits an encoded MVCC key into the encoded
+// user key (including its terminator) and the encoded timestamp
+// suffix, which is empty for MVCC metadata and raw values. Returns
+// false if encodedKey is not a well-formed MVCC key.
+func mvccSplitEncodedKey(encodedKey []byte) ([]byte, []byte, bool) {
+	if len(encodedKey) == 0 || encodedKey[0] != mvccBinaryEncodingMarker {
+		return nil, nil, false
+	}
+	i := bytes.IndexByte(encodedKey, mvccBinaryEncodingTerminator)
+	if i == -1 {
+		return nil, nil, false
+	}
+	prefix, suffix := encodedKey[:i+1], encodedKey[i+1:]
+	if len(suffix) != 0 && len(suffix) != mvccVersionTimestampSize {
+		return nil, nil, false
+	}
+	return prefix, suffix, true
+}
+
+// MVCCComparator compares two encoded MVCC keys, returning -1, 0 or
+// +1. Keys are ordered by user key ascending; for the same user key,
+// the metadata key sorts first, followed by versions in order of
+// descending timestamp. Keys which are not well-formed MVCC keys
+// (e.g. those written by tests directly to the engine) are compared
+// bytewise. For keys produced by MVCCEncodeKey and
+// MVCCEncodeVersionKey the ordering coincides with bytewise order;
+// the RocksDB comparator implements the same ordering in C++ and the
+// two are verified against each other in tests.
+func MVCCComparator(a, b proto.EncodedKey) int {
+	aPrefix, aTS, aOK := mvccSplitEncodedKey(a)
+	bPrefix, bTS, bOK := mvccSplitEncodedKey(b)
+	if !aOK || !bOK {
+		return bytes.Compare(a, b)
+	}
+	if c := bytes.Compare(aPrefix, bPrefix); c != 0 {
+		return c
+	}
+	// The metadata key, without a timestamp, sorts before all versions.
+	if len(aTS) == 0 || len(bTS) == 0 {
+		switch {
+		case len(aTS) == len(bTS):
+			return 0
+		case len(aTS) == 0:
+			return -1
+		default:
+			return 1
+		}
+	}
+	aTS, aWall := encoding.DecodeUint64Decreasing(aTS)
+	bTS, bWall := encoding.DecodeUint64Decreasing(bTS)
+	if aWall != bWall {
+		if aWall > bWall {
+			return -1
+		}
+		return 1
+	}
+	_, aLogical := encoding.DecodeUint32Decreasing(aTS)
+	_, bLogical := encoding.DecodeUint32Decreasing(bTS)
+	if aLogical != bLogical {
+		if aLogical > bLogical {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// mvccKeyFuzzIterations is the number of random keys (or key pairs)
+// generated by each of the fuzz tests below.
+const mvccKeyFuzzIterations = 10000
+
+// randMVCCKey returns a random user key. Keys are drawn from a small
+// alphabet (including nil bytes) so that keys frequently share
+// prefixes.
+func randMVCCKey(r *rand.Rand) proto.Key {
+	alphabet := []byte{0x00, 0x01, 'a', 'b', 0x7f, 0x80, 0xff}
+	key := make(proto.Key, r.Intn(16))
+	for i := range key {
+		key[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return key
+}
+
+// randMVCCTimestamp returns a random, non-negative timestamp. Wall
+// and logical times are drawn from small ranges half of the time so
+// that timestamps frequently collide.
+func randMVCCTimestamp(r *rand.Rand) proto.Timestamp {
+	if r.Intn(2) == 0 {
+		return makeTS(r.Int63n(3), r.Int31n(3))
+	}
+	return makeTS(r.Int63(), r.Int31())
+}
+
+// randMVCCEncodedKey returns a random encoded MVCC metadata or version
+// key.
+func randMVCCEncodedKey(r *rand.Rand) proto.EncodedKey {
+	key := randMVCCKey(r)
+	if r.Intn(4) == 0 {
+		return MVCCEncodeKey(key)
+	}
+	return MVCCEncodeVersionKey(key, randMVCCTimestamp(r))
+}
+
+// TestMVCCKeyEncodingFuzz verifies that the Go and C++
+// implementations of the MVCC key encoding produce identical encodings
+// and that each decodes the other's encodings.
+func TestMVCCKeyEncodingFuzz(t *testing.T) {
+	r := util.NewPseudoRand()
+	keys := []proto.Key{nil, proto.Key("\x00"), proto.Key("\xff\xff")}
+	for i := 0; i < mvccKeyFuzzIterations; i++ {
+		keys = append(keys, randMVCCKey(r))
+	}
+	timestamps := []proto.Timestamp{
+		makeTS(0, 0), makeTS(0, math.MaxInt32), makeTS(math.MaxInt64, 0), makeTS(math.MaxInt64, math.MaxInt32),
+	}
+	for i, key := range keys {
+		var ts proto.Timestamp
+		if i < len(timestamps) {
+			ts = timestamps[i]
+		} else {
+			ts = randMVCCTimestamp(r)
+		}
+
+		encoded := MVCCEncodeVersionKey(key, ts)
+		if cEncoded := goEncodeVersionKey(key, ts); !bytes.Equal(encoded, cEncoded) {
+			t.Fatalf("%q@%s: Go encoding %q != C++ encoding %q", key, ts, encoded, cEncoded)
+		}
+		if dKey, dTS, isValue := MVCCDecodeKey(encoded); !isValue || !bytes.Equal(dKey, key) || !dTS.Equal(ts) {
+			t.Fatalf("%q@%s: Go decoded %q@%s (%t)", key, ts, dKey, dTS, isValue)
+		}
+		if dKey, dTS, err := goDecodeKey(encoded); err != nil || !bytes.Equal(dKey, key) || !dTS.Equal(ts) {
+			t.Fatalf("%q@%s: C++ decoded %q@%s (%v)", key, ts, dKey, dTS, err)
+		}
+
+		metaKey := MVCCEncodeKey(key)
+		if dKey, dTS, err := goDecodeKey(metaKey); err != nil || !bytes.Equal(dKey, key) || !dTS.Equal(proto.ZeroTimestamp) {
+			t.Fatalf("%q: C++ decoded metadata key to %q@%s (%v)", key, dKey, dTS, err)
+		}
+	}
+}
+
+// TestMVCCKeyDecodeMalformed verifies that the C++ implementation
+// rejects keys which aren't well-formed MVCC keys.
+func TestMVCCKeyDecodeMalformed(t *testing.T) {
+	testCases := []proto.EncodedKey{
+		nil,
+		proto.EncodedKey("a"),
+		proto.EncodedKey("\x25"),
+		proto.EncodedKey("\x25a\x00"),
+		proto.EncodedKey("\x25\xff\xff"),
+		// Nonzero padding bits.
+		proto.EncodedKey("\x25\xb0\x81\x00"),
+		// Trailing bytes which aren't a timestamp.
+		append(MVCCEncodeKey(proto.Key("a")), 1, 2, 3),
+	}
+	for i, encoded := range testCases {
+		if _, _, err := goDecodeKey(encoded); err == nil {
+			t.Errorf("%d: expected error decoding %q", i, encoded)
+		}
+	}
+}
+
+// TestMVCCKeyOrderingFuzz verifies that the Go and C++ comparators
+// agree with each other and, for well-formed MVCC keys, with the
+// bytewise ordering of the encoded keys.
+func TestMVCCKeyOrderingFuzz(t *testing.T) {
+	r := util.NewPseudoRand()
+	for i := 0; i < mvccKeyFuzzIterations; i++ {
+		a := randMVCCEncodedKey(r)
+		var b proto.EncodedKey
+		switch r.Intn(3) {
+		case 0:
+			b = randMVCCEncodedKey(r)
+		case 1:
+			// A version of the same user key.
+			key, _, _ := MVCCDecodeKey(a)
+			b = MVCCEncodeVersionKey(key, randMVCCTimestamp(r))
+		default:
+			// The metadata key of the same user key.
+			key, _, _ := MVCCDecodeKey(a)
+			b = MVCCEncodeKey(key)
+		}
+		exp := bytes.Compare(a, b)
+		if c := MVCCComparator(a, b); c != exp {
+			t.Fatalf("Go compare(%q, %q) = %d; expected %d", a, b, c, exp)
+		}
+		if c := goCompareKeys(a, b); c != exp {
+			t.Fatalf("C++ compare(%q, %q) = %d; expected %d", a, b, c, exp)
+		}
+	}
+
+	// Arbitrary byte strings, which are mostly malformed MVCC keys.
+	for i := 0; i < mvccKeyFuzzIterations; i++ {
+		a := append(proto.EncodedKey{0x25}, randMVCCKey(r)...)
+		b := append(proto.EncodedKey{0x25}, randMVCCKey(r)...)
+		if c, exp := goCompareKeys(a, b), MVCCComparator(a, b); c != exp {
+			t.Fatalf("C++ compare(%q, %q) = %d; Go compare = %d", a, b, c, exp)
+		}
+	}
+}
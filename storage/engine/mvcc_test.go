@@ -50,10 +50,10 @@ var (
 	txn1e2Commit = &proto.Transaction{ID: []byte("Txn1"), Epoch: 2, Status: proto.COMMITTED}
 	txn2         = &proto.Transaction{ID: []byte("Txn2")}
 	txn2Commit   = &proto.Transaction{ID: []byte("Txn2"), Status: proto.COMMITTED}
-	value1       = proto.Value{Bytes: []byte("testValue1")}
-	value2       = proto.Value{Bytes: []byte("testValue2")}
-	value3       = proto.Value{Bytes: []byte("testValue3")}
-	value4       = proto.Value{Bytes: []byte("testValue4")}
+	value1       = proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("testValue1")}}
+	value2       = proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("testValue2")}}
+	value3       = proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("testValue3")}}
+	value4       = proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("testValue4")}}
 	valueEmpty   = proto.Value{}
 )
 
@@ -115,16 +115,16 @@ func TestMVCCKeys(t *testing.T) {
 
 func TestMVCCEmptyKey(t *testing.T) {
 	engine := createTestEngine()
-	if _, err := MVCCGet(engine, proto.Key{}, makeTS(0, 1), nil); err == nil {
+	if _, err := MVCCGet(engine, proto.Key{}, makeTS(0, 1), true, nil); err == nil {
 		t.Error("expected empty key error")
 	}
 	if err := MVCCPut(engine, nil, proto.Key{}, makeTS(0, 1), value1, nil); err == nil {
 		t.Error("expected empty key error")
 	}
-	if _, err := MVCCScan(engine, proto.Key{}, testKey1, 0, makeTS(0, 1), nil); err != nil {
+	if _, err := MVCCScan(engine, proto.Key{}, testKey1, 0, makeTS(0, 1), true, nil); err != nil {
 		t.Errorf("empty key allowed for start key in scan; got %s", err)
 	}
-	if _, err := MVCCScan(engine, testKey1, proto.Key{}, 0, makeTS(0, 1), nil); err == nil {
+	if _, err := MVCCScan(engine, testKey1, proto.Key{}, 0, makeTS(0, 1), true, nil); err == nil {
 		t.Error("expected empty key error")
 	}
 	if err := MVCCResolveWriteIntent(engine, nil, proto.Key{}, txn1); err == nil {
@@ -134,7 +134,7 @@ func TestMVCCEmptyKey(t *testing.T) {
 
 func TestMVCCGetNotExist(t *testing.T) {
 	engine := createTestEngine()
-	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -143,13 +143,57 @@ func TestMVCCGetNotExist(t *testing.T) {
 	}
 }
 
+// TestMVCCGetLegacyValue verifies that inline and versioned values
+// encoded before proto.ValuePayload existed are read upgraded, and
+// that an integer written that way can still be incremented.
+func TestMVCCGetLegacyValue(t *testing.T) {
+	engine := createTestEngine()
+	inline := &proto.MVCCMetadata{Value: &proto.Value{LegacyInteger: gogoproto.Int64(5)}}
+	if _, _, err := PutProto(engine, MVCCEncodeKey(testKey1), inline); err != nil {
+		t.Fatal(err)
+	}
+	ts := makeTS(1, 0)
+	meta := &proto.MVCCMetadata{Timestamp: ts}
+	if _, _, err := PutProto(engine, MVCCEncodeKey(testKey2), meta); err != nil {
+		t.Fatal(err)
+	}
+	version := &proto.MVCCValue{Value: &proto.Value{LegacyBytes: []byte("a")}}
+	if _, _, err := PutProto(engine, MVCCEncodeVersionKey(testKey2, ts), version); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := MVCCGet(engine, testKey1, makeTS(2, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.GetInteger() != 5 || value.LegacyInteger != nil {
+		t.Errorf("expected upgraded integer value; got %+v", value)
+	}
+	if r, err := MVCCIncrement(engine, nil, testKey1, proto.ZeroTimestamp, nil, 2); err != nil {
+		t.Fatal(err)
+	} else if r != 7 {
+		t.Errorf("expected incremented value 7; got %d", r)
+	}
+	value, err = MVCCGet(engine, testKey2, makeTS(2, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value.Bytes, []byte("a")) || value.LegacyBytes != nil {
+		t.Errorf("expected upgraded bytes value; got %+v", value)
+	}
+}
+
 func TestMVCCPutWithBadValue(t *testing.T) {
 	engine := createTestEngine()
-	badValue := proto.Value{Bytes: []byte("a"), Integer: gogoproto.Int64(1)}
+	badValue := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("a"), Integer: gogoproto.Int64(1)}}
 	err := MVCCPut(engine, nil, testKey1, makeTS(0, 1), badValue, nil)
 	if err == nil {
 		t.Fatal("expected an error putting a value with both byte slice and integer components")
 	}
+	badValue = proto.Value{ValuePayload: proto.ValuePayload{Integer: gogoproto.Int64(1), Float: gogoproto.Float64(1)}}
+	if err := MVCCPut(engine, nil, testKey1, makeTS(0, 1), badValue, nil); err == nil {
+		t.Fatal("expected an error putting a value with both integer and float components")
+	}
 }
 
 func TestMVCCPutWithTxn(t *testing.T) {
@@ -160,7 +204,7 @@ func TestMVCCPutWithTxn(t *testing.T) {
 	}
 
 	for _, ts := range []proto.Timestamp{makeTS(0, 1), makeTS(0, 2), makeTS(1, 0)} {
-		value, err := MVCCGet(engine, testKey1, ts, txn1)
+		value, err := MVCCGet(engine, testKey1, ts, true, txn1)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -179,7 +223,7 @@ func TestMVCCPutWithoutTxn(t *testing.T) {
 	}
 
 	for _, ts := range []proto.Timestamp{makeTS(0, 1), makeTS(0, 2), makeTS(1, 0)} {
-		value, err := MVCCGet(engine, testKey1, ts, nil)
+		value, err := MVCCGet(engine, testKey1, ts, true, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -201,7 +245,7 @@ func TestMVCCIncrement(t *testing.T) {
 	if newVal != 0 {
 		t.Errorf("expected new value of 0; got %d", newVal)
 	}
-	val, err := MVCCGet(engine, testKey1, makeTS(0, 1), nil)
+	val, err := MVCCGet(engine, testKey1, makeTS(0, 1), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -225,7 +269,7 @@ func TestMVCCUpdateExistingKey(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	value, err := MVCCGet(engine, testKey1, makeTS(1, 0), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -240,7 +284,7 @@ func TestMVCCUpdateExistingKey(t *testing.T) {
 	}
 
 	// Read the latest version.
-	value, err = MVCCGet(engine, testKey1, makeTS(3, 0), nil)
+	value, err = MVCCGet(engine, testKey1, makeTS(3, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -250,7 +294,7 @@ func TestMVCCUpdateExistingKey(t *testing.T) {
 	}
 
 	// Read the old version.
-	value, err = MVCCGet(engine, testKey1, makeTS(1, 0), nil)
+	value, err = MVCCGet(engine, testKey1, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -321,7 +365,7 @@ func TestMVCCGetNoMoreOldVersion(t *testing.T) {
 	err := MVCCPut(engine, nil, testKey1, makeTS(3, 0), value1, nil)
 	err = MVCCPut(engine, nil, testKey2, makeTS(1, 0), value2, nil)
 
-	value, err := MVCCGet(engine, testKey1, makeTS(2, 0), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(2, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -345,7 +389,7 @@ func TestMVCCGetUncertainty(t *testing.T) {
 		t.Fatal(err)
 	}
 	// Read with transaction, should get a value back.
-	val, err := MVCCGet(engine, testKey1, makeTS(7, 0), txn)
+	val, err := MVCCGet(engine, testKey1, makeTS(7, 0), true, txn)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -359,20 +403,20 @@ func TestMVCCGetUncertainty(t *testing.T) {
 		t.Fatal(err)
 	}
 	// Read with transaction, should get error back.
-	if _, err := MVCCGet(engine, testKey2, makeTS(7, 0), txn); err == nil {
+	if _, err := MVCCGet(engine, testKey2, makeTS(7, 0), true, txn); err == nil {
 		t.Fatal("wanted an error")
 	} else if e, ok := err.(*proto.ReadWithinUncertaintyIntervalError); !ok {
 		t.Fatalf("wanted a ReadWithinUncertaintyIntervalError, got %+v", e)
 	}
-	if _, err := MVCCScan(engine, testKey2, testKey2.PrefixEnd(), 10, makeTS(7, 0), txn); err == nil {
+	if _, err := MVCCScan(engine, testKey2, testKey2.PrefixEnd(), 10, makeTS(7, 0), true, txn); err == nil {
 		t.Fatal("wanted an error")
 	}
 	// Adjust MaxTimestamp and retry.
 	txn.MaxTimestamp = makeTS(7, 0)
-	if _, err := MVCCGet(engine, testKey2, makeTS(7, 0), txn); err != nil {
+	if _, err := MVCCGet(engine, testKey2, makeTS(7, 0), true, txn); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := MVCCScan(engine, testKey2, testKey2.PrefixEnd(), 10, makeTS(7, 0), txn); err != nil {
+	if _, err := MVCCScan(engine, testKey2, testKey2.PrefixEnd(), 10, makeTS(7, 0), true, txn); err != nil {
 		t.Fatal(err)
 	}
 
@@ -387,10 +431,10 @@ func TestMVCCGetUncertainty(t *testing.T) {
 	if err := MVCCPut(engine, nil, testKey3, makeTS(99, 0), value2, nil); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := MVCCScan(engine, testKey3, testKey3.PrefixEnd(), 10, makeTS(7, 0), txn); err == nil {
+	if _, err := MVCCScan(engine, testKey3, testKey3.PrefixEnd(), 10, makeTS(7, 0), true, txn); err == nil {
 		t.Fatal("wanted an error")
 	}
-	if _, err := MVCCGet(engine, testKey3, makeTS(7, 0), txn); err == nil {
+	if _, err := MVCCGet(engine, testKey3, makeTS(7, 0), true, txn); err == nil {
 		t.Fatalf("wanted an error")
 	}
 }
@@ -398,7 +442,7 @@ func TestMVCCGetUncertainty(t *testing.T) {
 func TestMVCCGetAndDelete(t *testing.T) {
 	engine := createTestEngine()
 	err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, nil)
-	value, err := MVCCGet(engine, testKey1, makeTS(2, 0), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(2, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -412,7 +456,7 @@ func TestMVCCGetAndDelete(t *testing.T) {
 	}
 
 	// Read the latest version which should be deleted.
-	value, err = MVCCGet(engine, testKey1, makeTS(4, 0), nil)
+	value, err = MVCCGet(engine, testKey1, makeTS(4, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -422,7 +466,7 @@ func TestMVCCGetAndDelete(t *testing.T) {
 
 	// Read the old version which should still exist.
 	for _, logical := range []int32{0, math.MaxInt32} {
-		value, err = MVCCGet(engine, testKey1, makeTS(2, logical), nil)
+		value, err = MVCCGet(engine, testKey1, makeTS(2, logical), true, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -446,7 +490,7 @@ func TestMVCCDeleteMissingKey(t *testing.T) {
 func TestMVCCGetAndDeleteInTxn(t *testing.T) {
 	engine := createTestEngine()
 	err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, txn1)
-	value, err := MVCCGet(engine, testKey1, makeTS(2, 0), txn1)
+	value, err := MVCCGet(engine, testKey1, makeTS(2, 0), true, txn1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -460,7 +504,7 @@ func TestMVCCGetAndDeleteInTxn(t *testing.T) {
 	}
 
 	// Read the latest version which should be deleted.
-	value, err = MVCCGet(engine, testKey1, makeTS(4, 0), txn1)
+	value, err = MVCCGet(engine, testKey1, makeTS(4, 0), true, txn1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -470,7 +514,7 @@ func TestMVCCGetAndDeleteInTxn(t *testing.T) {
 
 	// Read the old version which shouldn't exist, as within a
 	// transaction, we delete previous values.
-	value, err = MVCCGet(engine, testKey1, makeTS(2, 0), nil)
+	value, err = MVCCGet(engine, testKey1, makeTS(2, 0), true, nil)
 	if value != nil || err != nil {
 		t.Fatalf("expected value and err to be nil: %+v, %v", value, err)
 	}
@@ -483,17 +527,62 @@ func TestMVCCGetWriteIntentError(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err = MVCCGet(engine, testKey1, makeTS(1, 0), nil)
+	_, err = MVCCGet(engine, testKey1, makeTS(1, 0), true, nil)
 	if err == nil {
 		t.Fatal("cannot read the value of a write intent without TxnID")
 	}
 
-	_, err = MVCCGet(engine, testKey1, makeTS(1, 0), txn2)
+	_, err = MVCCGet(engine, testKey1, makeTS(1, 0), true, txn2)
 	if err == nil {
 		t.Fatal("cannot read the value of a write intent from a different TxnID")
 	}
 }
 
+// TestMVCCGetInconsistent verifies that inconsistent reads skip
+// intents and read the value beneath them, and may not be used in
+// transactions.
+func TestMVCCGetInconsistent(t *testing.T) {
+	engine := createTestEngine()
+	if err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCPut(engine, nil, testKey1, makeTS(2, 0), value2, txn1); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCPut(engine, nil, testKey2, makeTS(2, 0), value3, txn1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MVCCGet(engine, testKey1, makeTS(3, 0), true, nil); err == nil {
+		t.Error("expected consistent read of an intent to fail")
+	}
+	value, err := MVCCGet(engine, testKey1, makeTS(3, 0), false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value == nil || !bytes.Equal(value.Bytes, value1.Bytes) {
+		t.Errorf("expected value beneath intent %q; got %+v", value1.Bytes, value)
+	}
+	// A key with only an intent has no value to read.
+	if value, err := MVCCGet(engine, testKey2, makeTS(3, 0), false, nil); err != nil || value != nil {
+		t.Errorf("expected no value beneath intent; got %+v, %v", value, err)
+	}
+	kvs, err := MVCCScan(engine, testKey1, testKey3, 0, makeTS(3, 0), false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || !kvs[0].Key.Equal(testKey1) || !bytes.Equal(kvs[0].Value.Bytes, value1.Bytes) {
+		t.Errorf("expected scan to return only %q beneath its intent; got %+v", testKey1, kvs)
+	}
+
+	if _, err := MVCCGet(engine, testKey1, makeTS(3, 0), false, txn2); err == nil {
+		t.Error("expected transactional inconsistent read to fail")
+	}
+	if _, err := MVCCScan(engine, testKey1, testKey3, 0, makeTS(3, 0), false, txn2); err == nil {
+		t.Error("expected transactional inconsistent scan to fail")
+	}
+}
+
 func TestMVCCScan(t *testing.T) {
 	engine := createTestEngine()
 	err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, nil)
@@ -505,7 +594,7 @@ func TestMVCCScan(t *testing.T) {
 	err = MVCCPut(engine, nil, testKey4, makeTS(1, 0), value4, nil)
 	err = MVCCPut(engine, nil, testKey4, makeTS(5, 0), value1, nil)
 
-	kvs, err := MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), nil)
+	kvs, err := MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -517,7 +606,7 @@ func TestMVCCScan(t *testing.T) {
 		t.Fatal("the value should not be empty")
 	}
 
-	kvs, err = MVCCScan(engine, testKey2, testKey4, 0, makeTS(4, 0), nil)
+	kvs, err = MVCCScan(engine, testKey2, testKey4, 0, makeTS(4, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -529,7 +618,7 @@ func TestMVCCScan(t *testing.T) {
 		t.Fatal("the value should not be empty")
 	}
 
-	kvs, err = MVCCScan(engine, testKey4, KeyMax, 0, makeTS(1, 0), nil)
+	kvs, err = MVCCScan(engine, testKey4, KeyMax, 0, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -539,8 +628,8 @@ func TestMVCCScan(t *testing.T) {
 		t.Fatal("the value should not be empty")
 	}
 
-	_, err = MVCCGet(engine, testKey1, makeTS(1, 0), txn2)
-	kvs, err = MVCCScan(engine, KeyMin, testKey2, 0, makeTS(1, 0), nil)
+	_, err = MVCCGet(engine, testKey1, makeTS(1, 0), true, txn2)
+	kvs, err = MVCCScan(engine, KeyMin, testKey2, 0, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -558,7 +647,7 @@ func TestMVCCScanMaxNum(t *testing.T) {
 	err = MVCCPut(engine, nil, testKey3, makeTS(1, 0), value3, nil)
 	err = MVCCPut(engine, nil, testKey4, makeTS(1, 0), value4, nil)
 
-	kvs, err := MVCCScan(engine, testKey2, testKey4, 1, makeTS(1, 0), nil)
+	kvs, err := MVCCScan(engine, testKey2, testKey4, 1, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -588,7 +677,7 @@ func TestMVCCScanWithKeyPrefix(t *testing.T) {
 	err = MVCCPut(engine, nil, proto.Key("/aa"), makeTS(3, 0), value3, nil)
 	err = MVCCPut(engine, nil, proto.Key("/b"), makeTS(1, 0), value3, nil)
 
-	kvs, err := MVCCScan(engine, proto.Key("/a"), proto.Key("/b"), 0, makeTS(2, 0), nil)
+	kvs, err := MVCCScan(engine, proto.Key("/a"), proto.Key("/b"), 0, makeTS(2, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -608,7 +697,7 @@ func TestMVCCScanInTxn(t *testing.T) {
 	err = MVCCPut(engine, nil, testKey3, makeTS(1, 0), value3, txn1)
 	err = MVCCPut(engine, nil, testKey4, makeTS(1, 0), value4, nil)
 
-	kvs, err := MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), txn1)
+	kvs, err := MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, txn1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -620,12 +709,192 @@ func TestMVCCScanInTxn(t *testing.T) {
 		t.Fatal("the value should not be empty")
 	}
 
-	kvs, err = MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), nil)
+	kvs, err = MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, nil)
 	if err == nil {
 		t.Fatal("expected error on uncommitted write intent")
 	}
 }
 
+// TestMVCCReverseScan verifies that a reverse scan returns the same
+// versions as a forward scan, in descending key order.
+func TestMVCCReverseScan(t *testing.T) {
+	engine := createTestEngine()
+	err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, nil)
+	err = MVCCPut(engine, nil, testKey1, makeTS(2, 0), value4, nil)
+	err = MVCCPut(engine, nil, testKey2, makeTS(1, 0), value2, nil)
+	err = MVCCPut(engine, nil, testKey2, makeTS(3, 0), value3, nil)
+	err = MVCCPut(engine, nil, testKey3, makeTS(1, 0), value3, nil)
+	err = MVCCPut(engine, nil, testKey3, makeTS(4, 0), value2, nil)
+	err = MVCCPut(engine, nil, testKey4, makeTS(1, 0), value4, nil)
+	err = MVCCDelete(engine, nil, testKey4, makeTS(2, 0), nil)
+
+	kvs, err := MVCCReverseScan(engine, testKey1, testKey4, 0, makeTS(2, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 3 ||
+		!bytes.Equal(kvs[0].Key, testKey3) ||
+		!bytes.Equal(kvs[1].Key, testKey2) ||
+		!bytes.Equal(kvs[2].Key, testKey1) ||
+		!bytes.Equal(kvs[0].Value.Bytes, value3.Bytes) ||
+		!bytes.Equal(kvs[1].Value.Bytes, value2.Bytes) ||
+		!bytes.Equal(kvs[2].Value.Bytes, value4.Bytes) {
+		t.Fatalf("unexpected reverse scan results: %v", kvs)
+	}
+
+	// The deleted key is skipped and max limits the results from the end.
+	kvs, err = MVCCReverseScan(engine, testKey1, KeyMax, 2, makeTS(4, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 ||
+		!bytes.Equal(kvs[0].Key, testKey3) ||
+		!bytes.Equal(kvs[1].Key, testKey2) ||
+		!bytes.Equal(kvs[0].Value.Bytes, value2.Bytes) ||
+		!bytes.Equal(kvs[1].Value.Bytes, value3.Bytes) {
+		t.Fatalf("unexpected reverse scan results: %v", kvs)
+	}
+
+	// At timestamp 1, the key before testKey4 is still visible.
+	kvs, err = MVCCReverseScan(engine, testKey3, KeyMax, 0, makeTS(1, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 ||
+		!bytes.Equal(kvs[0].Key, testKey4) ||
+		!bytes.Equal(kvs[1].Key, testKey3) {
+		t.Fatalf("unexpected reverse scan results: %v", kvs)
+	}
+}
+
+// TestMVCCReadWithTombstones verifies that reads including tombstones
+// return deleted keys along with the timestamp of their deletion,
+// whether by a deletion tombstone or a range tombstone.
+func TestMVCCReadWithTombstones(t *testing.T) {
+	engine := createTestEngine()
+	putTestKeys(t, engine, makeTS(1, 0), testKey1, testKey2, testKey3, testKey4)
+	if err := MVCCDelete(engine, nil, testKey1, makeTS(2, 0), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCDeleteRangeTombstone(engine, nil, testKey3, testKey4, makeTS(3, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		key        proto.Key
+		ts         proto.Timestamp
+		expDeleted bool
+		expTS      proto.Timestamp
+	}{
+		{testKey1, makeTS(1, 0), false, makeTS(1, 0)},
+		{testKey1, makeTS(2, 0), true, makeTS(2, 0)},
+		{testKey1, makeTS(4, 0), true, makeTS(2, 0)},
+		{testKey2, makeTS(4, 0), false, makeTS(1, 0)},
+		{testKey3, makeTS(2, 0), false, makeTS(1, 0)},
+		{testKey3, makeTS(4, 0), true, makeTS(3, 0)},
+	}
+	for i, test := range testCases {
+		value, deleted, err := MVCCGetWithTombstones(engine, test.key, test.ts, true, nil)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if deleted != test.expDeleted || value == nil || !value.Timestamp.Equal(test.expTS) {
+			t.Errorf("%d: expected deleted=%t at %s; got %t, %+v", i, test.expDeleted, test.expTS, deleted, value)
+		}
+		if deleted && (value.Bytes != nil || value.Integer != nil) {
+			t.Errorf("%d: expected tombstone to hold only a timestamp; got %+v", i, value)
+		}
+		// A plain read never returns deleted keys.
+		if value, err := MVCCGet(engine, test.key, test.ts, true, nil); err != nil || (value == nil) != test.expDeleted {
+			t.Errorf("%d: expected MVCCGet to return nil iff deleted; got %+v, %v", i, value, err)
+		}
+	}
+
+	// A key which was never written has no tombstone.
+	value, deleted, err := MVCCGetWithTombstones(engine, proto.Key("/db0"), makeTS(4, 0), true, nil)
+	if err != nil || value != nil || deleted {
+		t.Errorf("expected no value for missing key; got %+v, %t, %v", value, deleted, err)
+	}
+
+	ts2, ts3 := makeTS(2, 0), makeTS(3, 0)
+	expRows := []proto.KeyValue{
+		{Key: testKey1, Value: proto.Value{Timestamp: &ts2}, Deleted: true},
+		{Key: testKey2, Value: value1},
+		{Key: testKey3, Value: proto.Value{Timestamp: &ts3}, Deleted: true},
+		{Key: testKey4, Value: value1},
+	}
+	checkRows := func(rows, exp []proto.KeyValue) {
+		if len(rows) != len(exp) {
+			t.Fatalf("expected %d rows; got %d: %+v", len(exp), len(rows), rows)
+		}
+		for i := range rows {
+			if !rows[i].Key.Equal(exp[i].Key) || rows[i].Deleted != exp[i].Deleted {
+				t.Errorf("%d: expected %q (deleted=%t); got %q (deleted=%t)",
+					i, exp[i].Key, exp[i].Deleted, rows[i].Key, rows[i].Deleted)
+			}
+			if exp[i].Deleted && !rows[i].Value.Timestamp.Equal(*exp[i].Value.Timestamp) {
+				t.Errorf("%d: expected deletion at %s; got %s", i, exp[i].Value.Timestamp, rows[i].Value.Timestamp)
+			}
+			if !exp[i].Deleted && !bytes.Equal(rows[i].Value.Bytes, exp[i].Value.Bytes) {
+				t.Errorf("%d: expected value %q; got %q", i, exp[i].Value.Bytes, rows[i].Value.Bytes)
+			}
+		}
+	}
+
+	rows, err := MVCCScanWithTombstones(engine, testKey1, testKey4.Next(), 0, makeTS(4, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(rows, expRows)
+
+	// Deleted rows count towards max.
+	rows, err = MVCCScanWithTombstones(engine, testKey1, testKey4.Next(), 2, makeTS(4, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(rows, expRows[:2])
+
+	rows, err = MVCCReverseScanWithTombstones(engine, testKey1, testKey4.Next(), 0, makeTS(4, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed := make([]proto.KeyValue, len(expRows))
+	for i := range expRows {
+		reversed[len(expRows)-1-i] = expRows[i]
+	}
+	checkRows(rows, reversed)
+
+	// Without tombstones, only the live keys are returned.
+	rows, err = MVCCScan(engine, testKey1, testKey4.Next(), 0, makeTS(4, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(rows, []proto.KeyValue{expRows[1], expRows[3]})
+}
+
+// TestMVCCReverseScanInTxn verifies that a reverse scan reads its own
+// transaction's intents and fails on those of other transactions.
+func TestMVCCReverseScanInTxn(t *testing.T) {
+	engine := createTestEngine()
+	err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, nil)
+	err = MVCCPut(engine, nil, testKey2, makeTS(1, 0), value2, txn1)
+	err = MVCCPut(engine, nil, testKey3, makeTS(1, 0), value3, nil)
+
+	kvs, err := MVCCReverseScan(engine, testKey1, testKey4, 0, makeTS(1, 0), true, txn1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 3 ||
+		!bytes.Equal(kvs[1].Key, testKey2) ||
+		!bytes.Equal(kvs[1].Value.Bytes, value2.Bytes) {
+		t.Fatalf("unexpected reverse scan results: %v", kvs)
+	}
+
+	if _, err = MVCCReverseScan(engine, testKey1, testKey4, 0, makeTS(1, 0), true, nil); err == nil {
+		t.Fatal("expected error on uncommitted write intent")
+	}
+}
+
 // TestMVCCIterateCommitted writes several values, some as intents
 // and verifies that IterateCommitted sees only the committed versions.
 func TestMVCCIterateCommitted(t *testing.T) {
@@ -664,9 +933,9 @@ func TestMVCCIterateCommitted(t *testing.T) {
 	}
 
 	expKVs := []proto.KeyValue{
-		proto.KeyValue{Key: testKey1, Value: proto.Value{Bytes: value1.Bytes, Timestamp: &ts1}},
-		proto.KeyValue{Key: testKey2, Value: proto.Value{Bytes: value2.Bytes, Timestamp: &ts4}},
-		proto.KeyValue{Key: testKey4, Value: proto.Value{Bytes: value4.Bytes, Timestamp: &ts6}},
+		proto.KeyValue{Key: testKey1, Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: value1.Bytes}, Timestamp: &ts1}},
+		proto.KeyValue{Key: testKey2, Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: value2.Bytes}, Timestamp: &ts4}},
+		proto.KeyValue{Key: testKey4, Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: value4.Bytes}, Timestamp: &ts6}},
 	}
 	if !reflect.DeepEqual(kvs, expKVs) {
 		t.Errorf("expected key values equal %v != %v", kvs, expKVs)
@@ -687,7 +956,7 @@ func TestMVCCDeleteRange(t *testing.T) {
 	if num != 2 {
 		t.Fatal("the value should not be empty")
 	}
-	kvs, _ := MVCCScan(engine, KeyMin, KeyMax, 0, makeTS(2, 0), nil)
+	kvs, _ := MVCCScan(engine, KeyMin, KeyMax, 0, makeTS(2, 0), true, nil)
 	if len(kvs) != 2 ||
 		!bytes.Equal(kvs[0].Key, testKey1) ||
 		!bytes.Equal(kvs[1].Key, testKey4) ||
@@ -703,7 +972,7 @@ func TestMVCCDeleteRange(t *testing.T) {
 	if num != 1 {
 		t.Fatal("the value should not be empty")
 	}
-	kvs, _ = MVCCScan(engine, KeyMin, KeyMax, 0, makeTS(2, 0), nil)
+	kvs, _ = MVCCScan(engine, KeyMin, KeyMax, 0, makeTS(2, 0), true, nil)
 	if len(kvs) != 1 ||
 		!bytes.Equal(kvs[0].Key, testKey1) ||
 		!bytes.Equal(kvs[0].Value.Bytes, value1.Bytes) {
@@ -717,7 +986,7 @@ func TestMVCCDeleteRange(t *testing.T) {
 	if num != 1 {
 		t.Fatal("the value should not be empty")
 	}
-	kvs, _ = MVCCScan(engine, KeyMin, KeyMax, 0, makeTS(2, 0), nil)
+	kvs, _ = MVCCScan(engine, KeyMin, KeyMax, 0, makeTS(2, 0), true, nil)
 	if len(kvs) != 0 {
 		t.Fatal("the value should be empty")
 	}
@@ -830,17 +1099,114 @@ func TestMVCCConditionalPut(t *testing.T) {
 		t.Fatal(err)
 	}
 	// Verify we get value2 as expected.
-	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), true, nil)
 	if !bytes.Equal(value2.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value1.Bytes, value.Bytes)
 	}
 }
 
+// TestMVCCConditionalPutFloat verifies that conditional puts compare
+// float values.
+// TestMVCCConditionalPutInline verifies conditional puts and deletes
+// of inline values, and that inline and versioned values are never
+// mixed on the same key.
+func TestMVCCConditionalPutInline(t *testing.T) {
+	engine := createTestEngine()
+	// Expecting a value which doesn't exist fails.
+	if err := MVCCConditionalPutInline(engine, nil, testKey1, value1, &value2); err == nil {
+		t.Fatal("expected error on key not exists")
+	} else if e, ok := err.(*proto.ConditionFailedError); !ok || e.ActualValue != nil {
+		t.Fatalf("expected condition failed error without actual value; got %v", err)
+	}
+	if err := MVCCConditionalPutInline(engine, nil, testKey1, value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A second put expecting no value fails with the actual value.
+	err := MVCCConditionalPutInline(engine, nil, testKey1, value2, nil)
+	if e, ok := err.(*proto.ConditionFailedError); !ok || !bytes.Equal(e.ActualValue.Bytes, value1.Bytes) {
+		t.Fatalf("expected condition failed error with actual value %v; got %v", value1, err)
+	}
+	if err := MVCCConditionalPutInline(engine, nil, testKey1, value2, &value1); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := MVCCGet(engine, testKey1, makeTS(1, 0), true, nil); err != nil || !bytes.Equal(value.Bytes, value2.Bytes) {
+		t.Fatalf("expected inline value %v; got %v, %v", value2, value, err)
+	}
+
+	// The key is only ever a single metadata row.
+	kvs, err := Scan(engine, MVCCEncodeKey(testKey1), MVCCEncodeKey(testKey1.Next()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 {
+		t.Fatalf("expected a single row for inline key; got %d", len(kvs))
+	}
+
+	// Versioned writes to the inline key fail, as do inline operations
+	// on a versioned key.
+	if err := MVCCPut(engine, nil, testKey1, makeTS(2, 0), value3, nil); err == nil {
+		t.Error("expected error on versioned put to inline key")
+	}
+	if err := MVCCPut(engine, nil, testKey2, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCConditionalPutInline(engine, nil, testKey2, value2, &value1); err == nil {
+		t.Error("expected error on inline put to versioned key")
+	}
+	if err := MVCCDeleteInline(engine, nil, testKey2, nil); err == nil {
+		t.Error("expected error on inline delete of versioned key")
+	}
+
+	// Deletes with a mismatched expected value fail.
+	err = MVCCDeleteInline(engine, nil, testKey1, &value1)
+	if e, ok := err.(*proto.ConditionFailedError); !ok || !bytes.Equal(e.ActualValue.Bytes, value2.Bytes) {
+		t.Fatalf("expected condition failed error with actual value %v; got %v", value2, err)
+	}
+	if err := MVCCDeleteInline(engine, nil, testKey1, &value2); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err = Scan(engine, MVCCEncodeKey(testKey1), MVCCEncodeKey(testKey1.Next()), 0)
+	if err != nil || len(kvs) != 0 {
+		t.Fatalf("expected no rows after inline delete; got %v, %v", kvs, err)
+	}
+	// Unconditional deletes of missing keys are no-ops.
+	if err := MVCCDeleteInline(engine, nil, testKey3, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMVCCConditionalPutFloat(t *testing.T) {
+	engine := createTestEngine()
+	valueF1 := proto.Value{ValuePayload: proto.ValuePayload{Float: gogoproto.Float64(1.5)}}
+	valueF2 := proto.Value{ValuePayload: proto.ValuePayload{Float: gogoproto.Float64(-2.25)}}
+	if err := MVCCPut(engine, nil, testKey1, makeTS(0, 1), valueF1, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Expecting a different float or a non-float value fails.
+	for _, expValue := range []proto.Value{valueF2, {ValuePayload: proto.ValuePayload{Integer: gogoproto.Int64(1)}}} {
+		err := MVCCConditionalPut(engine, nil, testKey1, makeTS(0, 2), valueF2, &expValue, nil)
+		if e, ok := err.(*proto.ConditionFailedError); !ok || e.ActualValue.GetFloat() != valueF1.GetFloat() {
+			t.Fatalf("expected condition failed error with actual value %v; got %v", valueF1, err)
+		}
+	}
+	// Expecting the current float succeeds.
+	if err := MVCCConditionalPut(engine, nil, testKey1, makeTS(0, 2), valueF2, &valueF1, nil); err != nil {
+		t.Fatal(err)
+	}
+	value, err := MVCCGet(engine, testKey1, makeTS(0, 2), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Float == nil || value.GetFloat() != valueF2.GetFloat() {
+		t.Fatalf("expected %v; got %v", valueF2, value)
+	}
+}
+
 func TestMVCCResolveTxn(t *testing.T) {
 	engine := createTestEngine()
 	err := MVCCPut(engine, nil, testKey1, makeTS(0, 1), value1, txn1)
-	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), txn1)
+	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), true, txn1)
 	if !bytes.Equal(value1.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value1.Bytes, value.Bytes)
@@ -852,7 +1218,7 @@ func TestMVCCResolveTxn(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	value, err = MVCCGet(engine, testKey1, makeTS(0, 1), nil)
+	value, err = MVCCGet(engine, testKey1, makeTS(0, 1), true, nil)
 	if !bytes.Equal(value1.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value1.Bytes, value.Bytes)
@@ -870,7 +1236,7 @@ func TestMVCCAbortTxn(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	value, err := MVCCGet(engine, testKey1, makeTS(1, 0), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(1, 0), true, nil)
 	if err != nil || value != nil {
 		t.Fatalf("the value should be empty: %s", err)
 	}
@@ -901,7 +1267,7 @@ func TestMVCCAbortTxnWithPreviousVersion(t *testing.T) {
 		t.Fatalf("expected the MVCCMetadata")
 	}
 
-	value, err := MVCCGet(engine, testKey1, makeTS(3, 0), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(3, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -945,12 +1311,12 @@ func TestMVCCWriteWithDiffTimestampsAndEpochs(t *testing.T) {
 		t.Fatal("expected write too old error")
 	}
 	// Attempt to read older timestamp; should fail.
-	value, err := MVCCGet(engine, testKey1, makeTS(0, 0), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(0, 0), true, nil)
 	if value != nil || err != nil {
 		t.Errorf("expected value nil, err nil; got %+v, %v", value, err)
 	}
 	// Read at correct timestamp.
-	value, err = MVCCGet(engine, testKey1, makeTS(1, 0), nil)
+	value, err = MVCCGet(engine, testKey1, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -992,7 +1358,7 @@ func TestMVCCReadWithDiffEpochs(t *testing.T) {
 		{txn2, nil, true},
 	}
 	for i, test := range testCases {
-		value, err := MVCCGet(engine, testKey1, makeTS(2, 0), test.txn)
+		value, err := MVCCGet(engine, testKey1, makeTS(2, 0), true, test.txn)
 		if test.expErr {
 			if err == nil {
 				t.Errorf("test %d: unexpected success", i)
@@ -1022,7 +1388,7 @@ func TestMVCCReadWithPushedTimestamp(t *testing.T) {
 		t.Fatal(err)
 	}
 	// Attempt to read using naive txn's previous timestamp.
-	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), txn1)
+	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), true, txn1)
 	if err != nil || value == nil || !bytes.Equal(value.Bytes, value1.Bytes) {
 		t.Errorf("expected value %q, err nil; got %+v, %v", value1.Bytes, value, err)
 	}
@@ -1039,13 +1405,13 @@ func TestMVCCResolveWithDiffEpochs(t *testing.T) {
 
 	// Verify key1 is empty, as resolution with epoch 2 would have
 	// aborted the epoch 1 intent.
-	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), true, nil)
 	if value != nil || err != nil {
 		t.Errorf("expected value nil, err nil; got %+v, %v", value, err)
 	}
 
 	// Key2 should be committed.
-	value, err = MVCCGet(engine, testKey2, makeTS(0, 1), nil)
+	value, err = MVCCGet(engine, testKey2, makeTS(0, 1), true, nil)
 	if !bytes.Equal(value2.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value2.Bytes, value.Bytes)
@@ -1055,7 +1421,7 @@ func TestMVCCResolveWithDiffEpochs(t *testing.T) {
 func TestMVCCResolveWithUpdatedTimestamp(t *testing.T) {
 	engine := createTestEngine()
 	err := MVCCPut(engine, nil, testKey1, makeTS(0, 1), value1, txn1)
-	value, err := MVCCGet(engine, testKey1, makeTS(1, 0), txn1)
+	value, err := MVCCGet(engine, testKey1, makeTS(1, 0), true, txn1)
 	if !bytes.Equal(value1.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value1.Bytes, value.Bytes)
@@ -1068,11 +1434,11 @@ func TestMVCCResolveWithUpdatedTimestamp(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if value, err := MVCCGet(engine, testKey1, makeTS(0, 1), nil); value != nil || err != nil {
+	if value, err := MVCCGet(engine, testKey1, makeTS(0, 1), true, nil); value != nil || err != nil {
 		t.Fatalf("expected both value and err to be nil: %+v, %v", value, err)
 	}
 
-	value, err = MVCCGet(engine, testKey1, makeTS(1, 0), nil)
+	value, err = MVCCGet(engine, testKey1, makeTS(1, 0), true, nil)
 	if !value.Timestamp.Equal(makeTS(1, 0)) {
 		t.Fatalf("expected timestamp %+v == %+v", value.Timestamp, makeTS(1, 0))
 	}
@@ -1085,7 +1451,7 @@ func TestMVCCResolveWithUpdatedTimestamp(t *testing.T) {
 func TestMVCCResolveWithPushedTimestamp(t *testing.T) {
 	engine := createTestEngine()
 	err := MVCCPut(engine, nil, testKey1, makeTS(0, 1), value1, txn1)
-	value, err := MVCCGet(engine, testKey1, makeTS(1, 0), txn1)
+	value, err := MVCCGet(engine, testKey1, makeTS(1, 0), true, txn1)
 	if !bytes.Equal(value1.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value1.Bytes, value.Bytes)
@@ -1098,12 +1464,12 @@ func TestMVCCResolveWithPushedTimestamp(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if value, err := MVCCGet(engine, testKey1, makeTS(1, 0), nil); value != nil || err == nil {
+	if value, err := MVCCGet(engine, testKey1, makeTS(1, 0), true, nil); value != nil || err == nil {
 		t.Fatalf("expected both value nil and err to be a writeIntentError: %+v", value)
 	}
 
 	// Can still fetch the value using txn1.
-	value, err = MVCCGet(engine, testKey1, makeTS(1, 0), txn1)
+	value, err = MVCCGet(engine, testKey1, makeTS(1, 0), true, txn1)
 	if !value.Timestamp.Equal(makeTS(1, 0)) {
 		t.Fatalf("expected timestamp %+v == %+v", value.Timestamp, makeTS(1, 0))
 	}
@@ -1152,31 +1518,215 @@ func TestMVCCResolveTxnRange(t *testing.T) {
 		t.Fatalf("expected all keys to process for resolution, even though 2 are noops; got %d", num)
 	}
 
-	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), nil)
+	value, err := MVCCGet(engine, testKey1, makeTS(0, 1), true, nil)
 	if !bytes.Equal(value1.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value1.Bytes, value.Bytes)
 	}
 
-	value, err = MVCCGet(engine, testKey2, makeTS(0, 1), nil)
+	value, err = MVCCGet(engine, testKey2, makeTS(0, 1), true, nil)
 	if !bytes.Equal(value2.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value2.Bytes, value.Bytes)
 	}
 
-	value, err = MVCCGet(engine, testKey3, makeTS(0, 1), txn2)
+	value, err = MVCCGet(engine, testKey3, makeTS(0, 1), true, txn2)
 	if !bytes.Equal(value3.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value3.Bytes, value.Bytes)
 	}
 
-	value, err = MVCCGet(engine, testKey4, makeTS(0, 1), nil)
+	value, err = MVCCGet(engine, testKey4, makeTS(0, 1), true, nil)
 	if !bytes.Equal(value4.Bytes, value.Bytes) {
 		t.Fatalf("the value %s in get result does not match the value %s in request",
 			value4.Bytes, value.Bytes)
 	}
 }
 
+// TestMVCCWriteSequenceReplay verifies that a write from an earlier
+// sequence of the intent's transaction is ignored as a replay, while a
+// retry of the same sequence is applied.
+func TestMVCCWriteSequenceReplay(t *testing.T) {
+	engine := createTestEngine()
+	txnSeq := func(seq int32) *proto.Transaction {
+		txn := makeTxn(txn1, makeTS(1, 0))
+		txn.Sequence = seq
+		return txn
+	}
+	if err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value2, txnSeq(2)); err != nil {
+		t.Fatal(err)
+	}
+	// Replay of sequence 1 is ignored.
+	if err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, txnSeq(1)); err != nil {
+		t.Fatal(err)
+	}
+	value, err := MVCCGet(engine, testKey1, makeTS(1, 0), true, txnSeq(2))
+	if err != nil || value == nil || !bytes.Equal(value.Bytes, value2.Bytes) {
+		t.Errorf("expected value %q; got %+v, %v", value2.Bytes, value, err)
+	}
+	// A retry of sequence 2 is applied without adding to the history.
+	if err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value3, txnSeq(2)); err != nil {
+		t.Fatal(err)
+	}
+	meta := &proto.MVCCMetadata{}
+	if _, _, _, err := GetProto(engine, MVCCEncodeKey(testKey1), meta); err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.IntentHistory) != 0 || meta.Txn.Sequence != 2 {
+		t.Errorf("unexpected intent metadata: %+v", meta)
+	}
+}
+
+// TestMVCCIntentHistoryRetention verifies that an intent's history
+// keeps only values which a savepoint may restore, and drops values
+// which were rolled back.
+func TestMVCCIntentHistoryRetention(t *testing.T) {
+	engine := createTestEngine()
+	txn := makeTxn(txn1, makeTS(1, 0))
+	history := func() []int32 {
+		meta := &proto.MVCCMetadata{}
+		if _, _, _, err := GetProto(engine, MVCCEncodeKey(testKey1), meta); err != nil {
+			t.Fatal(err)
+		}
+		var seqs []int32
+		for _, iv := range meta.IntentHistory {
+			seqs = append(seqs, iv.Sequence)
+		}
+		return seqs
+	}
+	put := func(seq int32) {
+		txn.Sequence = seq
+		if err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without savepoints, no history is kept.
+	for seq := int32(1); seq <= 3; seq++ {
+		put(seq)
+	}
+	if seqs := history(); len(seqs) != 0 {
+		t.Errorf("expected no intent history without savepoints; got %v", seqs)
+	}
+
+	// Savepoints at 4 and 5 keep only the values written at 4 and 5.
+	for seq := int32(4); seq <= 7; seq++ {
+		put(seq)
+		if seq <= 5 {
+			txn.Savepoint()
+		}
+	}
+	if seqs := history(); len(seqs) != 2 || seqs[0] != 4 || seqs[1] != 5 {
+		t.Errorf("expected intent history [4 5]; got %v", seqs)
+	}
+
+	// After rolling back to the savepoint at 4, the value written at 5
+	// is dropped from the history, and the rolled back value written
+	// at 7 isn't recorded.
+	txn.RollbackToSavepoint(4)
+	put(8)
+	if seqs := history(); len(seqs) != 1 || seqs[0] != 4 {
+		t.Errorf("expected intent history [4] after rollback; got %v", seqs)
+	}
+}
+
+// TestMVCCSavepointRollback verifies that writes rolled back to a
+// savepoint are invisible to the transaction's reads and aren't
+// committed, and that stats remain accurate.
+func TestMVCCSavepointRollback(t *testing.T) {
+	engine := createTestEngine()
+	ms := &MVCCStats{}
+	txnSeq := func(seq int32) *proto.Transaction {
+		txn := makeTxn(txn1, makeTS(1, 0))
+		txn.Sequence = seq
+		// Savepoints after each of the first two writes keep their
+		// values in the intent history.
+		txn.Savepoints = []int32{1, 2}
+		return txn
+	}
+	// testKey3 has a committed value before the transaction.
+	if err := MVCCPut(engine, ms, testKey3, makeTS(0, 1), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	writes := []struct {
+		key   proto.Key
+		value proto.Value
+		seq   int32
+	}{
+		{testKey1, value1, 1},
+		{testKey1, value2, 2},
+		{testKey2, value4, 2},
+		{testKey1, value3, 3},
+		{testKey3, value4, 3},
+	}
+	for _, w := range writes {
+		if err := MVCCPut(engine, ms, w.key, makeTS(1, 0), w.value, txnSeq(w.seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Roll back sequences 2 and 3.
+	txn := txnSeq(3)
+	txn.RollbackToSavepoint(1)
+	expValues := []struct {
+		txn      *proto.Transaction
+		key      proto.Key
+		expValue *proto.Value
+	}{
+		{txnSeq(3), testKey1, &value3},
+		{txnSeq(2), testKey1, &value2},
+		{txnSeq(1), testKey1, &value1},
+		{txn, testKey1, &value1},
+		{txn, testKey2, nil},
+		{txn, testKey3, &value1},
+	}
+	for i, test := range expValues {
+		value, err := MVCCGet(engine, test.key, makeTS(1, 0), true, test.txn)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if test.expValue == nil {
+			if value != nil {
+				t.Errorf("%d: expected nil value; got %+v", i, value)
+			}
+		} else if value == nil || !bytes.Equal(value.Bytes, test.expValue.Bytes) {
+			t.Errorf("%d: expected value %q; got %+v", i, test.expValue.Bytes, value)
+		}
+	}
+
+	// Commit, then verify only the writes which weren't rolled back
+	// were committed.
+	txn.Status = proto.COMMITTED
+	if _, err := MVCCResolveWriteIntentRange(engine, ms, testKey1, testKey4, 0, txn); err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range []struct {
+		key      proto.Key
+		expValue *proto.Value
+	}{
+		{testKey1, &value1},
+		{testKey2, nil},
+		{testKey3, &value1},
+	} {
+		value, err := MVCCGet(engine, test.key, makeTS(2, 0), true, nil)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if test.expValue == nil {
+			if value != nil {
+				t.Errorf("%d: expected nil value; got %+v", i, value)
+			}
+		} else if value == nil || !bytes.Equal(value.Bytes, test.expValue.Bytes) {
+			t.Errorf("%d: expected value %q; got %+v", i, test.expValue.Bytes, value)
+		}
+	}
+	expMS, err := MVCCComputeStats(engine, KeyMin, KeyMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyStats("after commit", ms, &expMS, t)
+}
+
 func TestValidSplitKeys(t *testing.T) {
 	testCases := []struct {
 		key   proto.Key
@@ -1231,17 +1781,19 @@ func TestFindSplitKey(t *testing.T) {
 	for i := 0; i < splitReservoirSize; i++ {
 		k := fmt.Sprintf("%09d", i)
 		v := strings.Repeat("X", 10-len(k))
-		val := proto.Value{Bytes: []byte(v)}
+		val := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte(v)}}
 		// Write the key and value through MVCC
 		if err := MVCCPut(engine, ms, []byte(k), makeTS(0, 1), val, nil); err != nil {
 			t.Fatal(err)
 		}
 	}
 	ms.MergeStats(engine, raftID, 0) // write stats
-	if err := engine.CreateSnapshot("snap1"); err != nil {
+	snap, err := engine.NewSnapshot()
+	if err != nil {
 		t.Fatal(err)
 	}
-	humanSplitKey, err := MVCCFindSplitKey(engine, raftID, KeyMin, KeyMax, "snap1")
+	defer snap.Stop()
+	humanSplitKey, err := MVCCFindSplitKey(snap, raftID, KeyMin, KeyMax)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1249,9 +1801,6 @@ func TestFindSplitKey(t *testing.T) {
 	if diff := splitReservoirSize/2 - ind; diff > 1 || diff < -1 {
 		t.Fatalf("wanted key #%d+-1, but got %d (diff %d)", ind+diff, ind, diff)
 	}
-	if err := engine.ReleaseSnapshot("snap1"); err != nil {
-		t.Fatal(err)
-	}
 }
 
 // TestFindValidSplitKeys verifies split keys are located such that
@@ -1334,19 +1883,16 @@ func TestFindValidSplitKeys(t *testing.T) {
 	for i, test := range testCases {
 		engine := NewInMem(proto.Attributes{}, 1<<20)
 		ms := &MVCCStats{}
-		val := proto.Value{Bytes: []byte(strings.Repeat("X", 10))}
+		val := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte(strings.Repeat("X", 10))}}
 		for _, k := range test.keys {
 			if err := MVCCPut(engine, ms, []byte(k), makeTS(0, 1), val, nil); err != nil {
 				t.Fatal(err)
 			}
 		}
 		ms.MergeStats(engine, raftID, 0) // write stats
-		if err := engine.CreateSnapshot("snap1"); err != nil {
-			t.Fatal(err)
-		}
 		rangeStart := test.keys[0]
 		rangeEnd := test.keys[len(test.keys)-1].Next()
-		splitKey, err := MVCCFindSplitKey(engine, raftID, rangeStart, rangeEnd, "snap1")
+		splitKey, err := MVCCFindSplitKey(engine, raftID, rangeStart, rangeEnd)
 		if test.expError {
 			if err == nil {
 				t.Errorf("%d: expected error", i)
@@ -1360,10 +1906,59 @@ func TestFindValidSplitKeys(t *testing.T) {
 		if !splitKey.Equal(test.expSplit) {
 			t.Errorf("%d: expected split key %q; got %q", i, test.expSplit, splitKey)
 		}
-		if err := engine.ReleaseSnapshot("snap1"); err != nil {
+	}
+}
+
+// TestFindColocatedSplitKeys verifies that split keys are never
+// chosen within a registered co-location group.
+func TestFindColocatedSplitKeys(t *testing.T) {
+	raftID := int64(1)
+	prefix := proto.Key("cust/")
+	// Keys of the form cust/<id>/order/<id> are co-located with the
+	// parent key cust/<id>.
+	RegisterColocation(prefix, func(key proto.Key) proto.Key {
+		rest := key[len(prefix):]
+		if i := bytes.IndexByte(rest, '/'); i >= 0 {
+			return key[:len(prefix)+i]
+		}
+		return key
+	})
+	defer func() {
+		colocations.Lock()
+		colocations.entries = nil
+		colocations.Unlock()
+	}()
+
+	engine := NewInMem(proto.Attributes{}, 1<<20)
+	ms := &MVCCStats{}
+	val := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte(strings.Repeat("X", 10))}}
+	var keys []proto.Key
+	for i := 0; i < 10; i++ {
+		keys = append(keys, proto.Key(fmt.Sprintf("cust/1/order/%d", i)))
+	}
+	keys = append(keys, proto.Key("cust/1"), proto.Key("cust/2"), proto.Key("cust/2/order/0"))
+	for _, k := range keys {
+		if err := MVCCPut(engine, ms, k, makeTS(0, 1), val, nil); err != nil {
 			t.Fatal(err)
 		}
 	}
+	ms.MergeStats(engine, raftID, 0) // write stats
+	// Without co-location, the split would fall among the orders of
+	// customer 1; instead it must fall at the start of customer 2.
+	splitKey, err := MVCCFindSplitKey(engine, raftID, proto.Key("cust/1"), proto.Key("cust/3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expSplit := proto.Key("cust/2"); !splitKey.Equal(expSplit) {
+		t.Errorf("expected split key %q; got %q", expSplit, splitKey)
+	}
+
+	if IsValidSplitKey(proto.Key("cust/1/order/5")) {
+		t.Errorf("expected split within co-location group to be invalid")
+	}
+	if !IsValidSplitKey(proto.Key("cust/1")) {
+		t.Errorf("expected split at start of co-location group to be valid")
+	}
 }
 
 // TestFindBalancedSplitKeys verifies split keys are located such that
@@ -1422,16 +2017,13 @@ func TestFindBalancedSplitKeys(t *testing.T) {
 			if test.expSplit == j {
 				expKey = key
 			}
-			val := proto.Value{Bytes: []byte(strings.Repeat("X", test.valSizes[j]))}
+			val := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte(strings.Repeat("X", test.valSizes[j]))}}
 			if err := MVCCPut(engine, ms, key, makeTS(0, 1), val, nil); err != nil {
 				t.Fatal(err)
 			}
 		}
 		ms.MergeStats(engine, raftID, 0) // write stats
-		if err := engine.CreateSnapshot("snap1"); err != nil {
-			t.Fatal(err)
-		}
-		splitKey, err := MVCCFindSplitKey(engine, raftID, proto.Key("\x01"), proto.KeyMax, "snap1")
+		splitKey, err := MVCCFindSplitKey(engine, raftID, proto.Key("\x01"), proto.KeyMax)
 		if err != nil {
 			t.Errorf("unexpected error: %s", err)
 			continue
@@ -1439,9 +2031,6 @@ func TestFindBalancedSplitKeys(t *testing.T) {
 		if !splitKey.Equal(expKey) {
 			t.Errorf("%d: expected split key %q; got %q", i, expKey, splitKey)
 		}
-		if err := engine.ReleaseSnapshot("snap1"); err != nil {
-			t.Fatal(err)
-		}
 	}
 }
 
@@ -1480,6 +2069,12 @@ func verifyStats(debug string, ms *MVCCStats, expMS *MVCCStats, t *testing.T) {
 	if ms.IntentCount != expMS.IntentCount {
 		t.Errorf("%s: mvcc intentCount %d; measured %d", debug, expMS.IntentCount, ms.IntentCount)
 	}
+	if ms.GCBytesAge != expMS.GCBytesAge {
+		t.Errorf("%s: mvcc gcBytesAge %d; measured %d", debug, expMS.GCBytesAge, ms.GCBytesAge)
+	}
+	if ms.RangeTombstoneCount != expMS.RangeTombstoneCount {
+		t.Errorf("%s: mvcc rangeTombstoneCount %d; measured %d", debug, expMS.RangeTombstoneCount, ms.RangeTombstoneCount)
+	}
 }
 
 // TestMVCCStatsBasic writes a value, then deletes it as an intent via
@@ -1492,7 +2087,7 @@ func TestMVCCStatsBasic(t *testing.T) {
 	// Put a value.
 	ts := makeTS(0, 1)
 	key := proto.Key("a")
-	value := proto.Value{Bytes: []byte("value")}
+	value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("value")}}
 	if err := MVCCPut(engine, ms, key, ts, value, nil); err != nil {
 		t.Fatal(err)
 	}
@@ -1561,6 +2156,160 @@ func TestMVCCStatsBasic(t *testing.T) {
 	verifyStats("after abort", ms, expMS3, t)
 }
 
+// TestMVCCStatsGCBytesAge verifies that the age of non-live bytes is
+// maintained incrementally through overwrites, deletions and intent
+// pushes, commits and aborts, matching a recomputation of the stats.
+func TestMVCCStatsGCBytesAge(t *testing.T) {
+	engine := createTestEngine()
+	ms := &MVCCStats{}
+	secs := func(s int64) proto.Timestamp { return makeTS(s*1E9, 0) }
+	verify := func(debug string) {
+		expMS, err := MVCCComputeStats(engine, KeyMin, KeyMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyStats(debug, ms, &expMS, t)
+	}
+
+	// Overwrite a value; the original version ages from when it was
+	// written.
+	if err := MVCCPut(engine, ms, testKey1, secs(1), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ms.GCBytes() != 0 || ms.GCBytesAgeAt(secs(10).WallTime) != 0 {
+		t.Errorf("expected no GC bytes; got %d with age %d", ms.GCBytes(), ms.GCBytesAgeAt(secs(10).WallTime))
+	}
+	if err := MVCCPut(engine, ms, testKey1, secs(3), value2, nil); err != nil {
+		t.Fatal(err)
+	}
+	verify("after overwrite")
+	gcBytes := ms.GCBytes()
+	if gcBytes == 0 {
+		t.Fatal("expected overwritten version to be counted as GC bytes")
+	}
+	if age := ms.GCBytesAgeAt(secs(10).WallTime); age != 9*gcBytes {
+		t.Errorf("expected GC bytes age %d; got %d", 9*gcBytes, age)
+	}
+
+	// Delete the value.
+	if err := MVCCDelete(engine, ms, testKey1, secs(5), nil); err != nil {
+		t.Fatal(err)
+	}
+	verify("after delete")
+
+	// Delete a value transactionally, push the intent and commit it.
+	if err := MVCCPut(engine, ms, testKey2, secs(1), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	txn := &proto.Transaction{ID: []byte("txn1"), Timestamp: secs(6)}
+	if err := MVCCDelete(engine, ms, testKey2, secs(6), txn); err != nil {
+		t.Fatal(err)
+	}
+	verify("after txn delete")
+	txn.Timestamp = secs(7)
+	if err := MVCCResolveWriteIntent(engine, ms, testKey2, txn); err != nil {
+		t.Fatal(err)
+	}
+	verify("after push")
+	txn.Status = proto.COMMITTED
+	if err := MVCCResolveWriteIntent(engine, ms, testKey2, txn); err != nil {
+		t.Fatal(err)
+	}
+	verify("after commit")
+
+	// Delete a value transactionally and abort, restoring the value;
+	// then do the same for the deleted key.
+	if err := MVCCPut(engine, ms, testKey3, secs(1), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []proto.Key{testKey3, testKey1} {
+		txn := &proto.Transaction{ID: []byte("txn2"), Timestamp: secs(8)}
+		if err := MVCCDelete(engine, ms, key, secs(8), txn); err != nil {
+			t.Fatal(err)
+		}
+		txn.Status = proto.ABORTED
+		if err := MVCCResolveWriteIntent(engine, ms, key, txn); err != nil {
+			t.Fatal(err)
+		}
+		verify(fmt.Sprintf("after abort of %q", key))
+	}
+}
+
+// TestMVCCGarbageCollect verifies that historical versions and
+// deleted keys are removed, that keys with intents and latest live
+// values are not, and that stats are updated accordingly.
+func TestMVCCGarbageCollect(t *testing.T) {
+	engine := createTestEngine()
+	ms := &MVCCStats{}
+	secs := func(s int64) proto.Timestamp { return makeTS(s*1E9, 0) }
+
+	for _, ts := range []proto.Timestamp{secs(1), secs(2), secs(3)} {
+		if err := MVCCPut(engine, ms, testKey1, ts, value1, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := MVCCPut(engine, ms, testKey2, secs(1), value2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCDelete(engine, ms, testKey2, secs(2), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCPut(engine, ms, testKey3, secs(1), value3, nil); err != nil {
+		t.Fatal(err)
+	}
+	txn := &proto.Transaction{ID: []byte("txn"), Timestamp: secs(2)}
+	if err := MVCCPut(engine, ms, testKey3, secs(2), value3, txn); err != nil {
+		t.Fatal(err)
+	}
+
+	// The latest value of a key can't be collected unless it's deleted.
+	if err := MVCCGarbageCollect(engine, ms, []proto.InternalGCRequest_GCKey{
+		{Key: testKey1, Timestamp: secs(3)},
+	}); err == nil {
+		t.Error("expected error collecting latest live value")
+	}
+
+	keys := []proto.InternalGCRequest_GCKey{
+		{Key: testKey1, Timestamp: secs(1)},
+		{Key: testKey1, Timestamp: secs(2)},
+		{Key: testKey2, Timestamp: secs(2)},
+		{Key: testKey3, Timestamp: secs(1)},
+	}
+	if err := MVCCGarbageCollect(engine, ms, keys); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := Scan(engine, MVCCEncodeKey(KeyMin), MVCCEncodeKey(KeyMax), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expKeys := []proto.EncodedKey{
+		MVCCEncodeKey(testKey1),
+		MVCCEncodeVersionKey(testKey1, secs(3)),
+		MVCCEncodeKey(testKey3),
+		MVCCEncodeVersionKey(testKey3, secs(2)),
+		MVCCEncodeVersionKey(testKey3, secs(1)),
+	}
+	if len(kvs) != len(expKeys) {
+		t.Fatalf("expected %d keys to remain; got %d", len(expKeys), len(kvs))
+	}
+	for i, kv := range kvs {
+		if !kv.Key.Equal(expKeys[i]) {
+			t.Errorf("%d: expected key %q; got %q", i, expKeys[i], kv.Key)
+		}
+	}
+	expMS, err := MVCCComputeStats(engine, KeyMin, KeyMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyStats("after GC", ms, &expMS, t)
+
+	// Collecting versions which no longer exist is a noop.
+	if err := MVCCGarbageCollect(engine, ms, keys); err != nil {
+		t.Fatal(err)
+	}
+	verifyStats("after repeated GC", ms, &expMS, t)
+}
+
 // TestMVCCStatsWithRandomRuns creates a random sequence of puts,
 // deletes and delete ranges and at each step verifies that the mvcc
 // stats match a manual computation of range stats via a scan of the
@@ -1613,7 +2362,7 @@ func TestMVCCStatsWithRandomRuns(t *testing.T) {
 				}
 			}
 		} else {
-			rngVal := proto.Value{Bytes: []byte(util.RandString(rng, int(rng.Int31n(128))))}
+			rngVal := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte(util.RandString(rng, int(rng.Int31n(128))))}}
 			log.V(1).Infof("*** PUT index %d; TXN=%t", i, txn != nil)
 			if err := MVCCPut(engine, ms, key, makeTS(0, i+1), rngVal, txn); err != nil {
 				t.Fatal(err)
@@ -1641,3 +2390,72 @@ func TestMVCCStatsWithRandomRuns(t *testing.T) {
 		}
 	}
 }
+
+// mvccComparatorKeys returns a list of encoded MVCC keys in the
+// order expected of MVCCComparator.
+func mvccComparatorKeys() []proto.EncodedKey {
+	return []proto.EncodedKey{
+		MVCCEncodeKey(proto.Key("")),
+		MVCCEncodeVersionKey(proto.Key(""), makeTS(1, 0)),
+		MVCCEncodeKey(proto.Key("\x00")),
+		MVCCEncodeVersionKey(proto.Key("\x00"), makeTS(0, 1)),
+		MVCCEncodeKey(proto.Key("a")),
+		MVCCEncodeVersionKey(proto.Key("a"), makeTS(math.MaxInt64, 0)),
+		MVCCEncodeVersionKey(proto.Key("a"), makeTS(2, 1)),
+		MVCCEncodeVersionKey(proto.Key("a"), makeTS(2, 0)),
+		MVCCEncodeVersionKey(proto.Key("a"), makeTS(1, math.MaxInt32)),
+		MVCCEncodeVersionKey(proto.Key("a"), makeTS(1, 0)),
+		MVCCEncodeVersionKey(proto.Key("a"), makeTS(0, 0)),
+		MVCCEncodeKey(proto.Key("a\x00")),
+		MVCCEncodeVersionKey(proto.Key("a\x00"), makeTS(1, 0)),
+		MVCCEncodeKey(proto.Key("aa")),
+		MVCCEncodeVersionKey(proto.Key("aa"), makeTS(3, 0)),
+		MVCCEncodeKey(proto.Key("b")),
+		MVCCEncodeKey(proto.Key("\xff\xff")),
+		MVCCEncodeVersionKey(proto.Key("\xff\xff"), makeTS(1, 0)),
+	}
+}
+
+// TestMVCCComparator verifies that MVCCComparator orders keys by user
+// key ascending and timestamp descending, with the metadata key first,
+// and that this ordering coincides with the bytewise ordering of the
+// encoded keys.
+func TestMVCCComparator(t *testing.T) {
+	keys := mvccComparatorKeys()
+	for i, a := range keys {
+		for j, b := range keys {
+			exp := 0
+			if i < j {
+				exp = -1
+			} else if i > j {
+				exp = 1
+			}
+			if c := MVCCComparator(a, b); c != exp {
+				t.Errorf("%d, %d: expected compare(%q, %q) = %d; got %d", i, j, a, b, exp, c)
+			}
+			if c := bytes.Compare(a, b); c != exp {
+				t.Errorf("%d, %d: expected bytewise compare(%q, %q) = %d; got %d", i, j, a, b, exp, c)
+			}
+		}
+	}
+}
+
+// TestMVCCComparatorMalformed verifies that keys which aren't
+// well-formed MVCC keys are compared bytewise.
+func TestMVCCComparatorMalformed(t *testing.T) {
+	testCases := []struct {
+		a, b proto.EncodedKey
+	}{
+		{proto.EncodedKey("a"), proto.EncodedKey("b")},
+		{proto.EncodedKey("b"), MVCCEncodeKey(proto.Key("a"))},
+		{proto.EncodedKey("\x25abc"), MVCCEncodeKey(proto.Key("a"))},
+		{append(MVCCEncodeKey(proto.Key("a")), 1, 2, 3), MVCCEncodeVersionKey(proto.Key("a"), makeTS(1, 0))},
+	}
+	for i, test := range testCases {
+		for _, pair := range [][2]proto.EncodedKey{{test.a, test.b}, {test.b, test.a}} {
+			if c, exp := MVCCComparator(pair[0], pair[1]), bytes.Compare(pair[0], pair[1]); c != exp {
+				t.Errorf("%d: expected compare(%q, %q) = %d; got %d", i, pair[0], pair[1], exp, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// Range tombstones delete all versions of every key in a span which
+// are older than the tombstone's timestamp, using a single record in
+// place of a deletion tombstone per key. Tombstones are stored under
+// KeyLocalRangeTombstonePrefix, addressed by start key and versioned
+// by timestamp:
+//
+// \x00\x00\x00rtmb<startKey>_Timestamp : MVCCRangeTombstone
+//
+// Reads mask any version covered by a tombstone with a later timestamp
+// which is visible at the read timestamp. Writes beneath a tombstone
+// fail with a WriteTooOldError, so intents are never masked. Inline
+// (zero timestamp) values are unaffected by range tombstones.
+//
+// Tombstones are counted by MVCCStats.RangeTombstoneCount, but the
+// versions they delete are not reflected in MVCC stats until garbage
+// collected; MVCCGarbageCollectRangeTombstones corrects stats for the
+// span when it removes the covered versions.
+//
+// Reads and writes must look for tombstones covering their keys. As
+// tombstones are split along with ranges, a range's keys are covered
+// only by tombstones starting within the range; ranges bound the
+// search accordingly, or skip it when they hold no tombstones, with
+// WithRangeTombstoneBounds.
+
+// rangeTombstoneKey returns the encoded key at which a range tombstone
+// starting at key with the given timestamp is stored.
+func rangeTombstoneKey(key proto.Key, timestamp proto.Timestamp) proto.EncodedKey {
+	return MVCCEncodeVersionKey(MakeLocalKey(KeyLocalRangeTombstonePrefix, key), timestamp)
+}
+
+// rangeTombstones is a slice of range tombstones.
+type rangeTombstones []proto.MVCCRangeTombstone
+
+// covering returns the tombstones which cover key.
+func (rts rangeTombstones) covering(key proto.Key) rangeTombstones {
+	var res rangeTombstones
+	for _, rt := range rts {
+		if (proto.KeyRange{Start: rt.StartKey, End: rt.EndKey}).Contains(key) {
+			res = append(res, rt)
+		}
+	}
+	return res
+}
+
+// masks returns true if the version of key written at version is
+// deleted by a tombstone visible at the read timestamp.
+func (rts rangeTombstones) masks(key proto.Key, version, timestamp proto.Timestamp) bool {
+	_, ok := rts.deletedAt(key, version, timestamp)
+	return ok
+}
+
+// deletedAt returns the timestamp of the earliest tombstone visible at
+// the read timestamp which deletes the version of key written at
+// version. Returns false if there is none.
+func (rts rangeTombstones) deletedAt(key proto.Key, version, timestamp proto.Timestamp) (proto.Timestamp, bool) {
+	var ts proto.Timestamp
+	var ok bool
+	for _, rt := range rts.covering(key) {
+		if version.Less(rt.Timestamp) && !timestamp.Less(rt.Timestamp) {
+			if !ok || rt.Timestamp.Less(ts) {
+				ts, ok = rt.Timestamp, true
+			}
+		}
+	}
+	return ts, ok
+}
+
+// uncertain returns the timestamp of a tombstone which deletes the
+// version of key written at version and which falls in the interval
+// (timestamp, maxTimestamp]. Returns false if there is none.
+func (rts rangeTombstones) uncertain(key proto.Key, version, timestamp, maxTimestamp proto.Timestamp) (proto.Timestamp, bool) {
+	for _, rt := range rts.covering(key) {
+		if version.Less(rt.Timestamp) && timestamp.Less(rt.Timestamp) && !maxTimestamp.Less(rt.Timestamp) {
+			return rt.Timestamp, true
+		}
+	}
+	return proto.Timestamp{}, false
+}
+
+// rangeTombstoneBounds is an engine on which MVCC operations consider
+// only the range tombstones starting within bounds.
+type rangeTombstoneBounds struct {
+	Engine
+	bounds proto.KeyRange
+}
+
+// WithRangeTombstoneBounds returns an engine which reads and writes
+// through e, but on which MVCC operations consider only the range
+// tombstones starting from start up to end. If end doesn't follow
+// start, no tombstones are considered at all.
+func WithRangeTombstoneBounds(e Engine, start, end proto.Key) Engine {
+	return &rangeTombstoneBounds{Engine: e, bounds: proto.KeyRange{Start: start, End: end}}
+}
+
+// mvccLoadRangeTombstones returns all range tombstones which overlap
+// the span from key to endKey. Tombstones are only written over global
+// keys, so spans which lie entirely within the local key space are
+// never scanned. Unless the engine bounds the tombstones to consider
+// (see WithRangeTombstoneBounds), every tombstone starting before
+// endKey is read.
+func mvccLoadRangeTombstones(engine Engine, key, endKey proto.Key) (rangeTombstones, error) {
+	if !KeyLocalMax.Less(endKey) {
+		return nil, nil
+	}
+	scanKey := KeyMin
+	if rtb, ok := engine.(*rangeTombstoneBounds); ok {
+		if !rtb.bounds.Start.Less(rtb.bounds.End) {
+			return nil, nil
+		}
+		scanKey = rtb.bounds.Start
+		if rtb.bounds.End.Less(endKey) {
+			endKey = rtb.bounds.End
+		}
+	}
+	var rts rangeTombstones
+	start := MVCCEncodeKey(MakeLocalKey(KeyLocalRangeTombstonePrefix, scanKey))
+	end := MVCCEncodeKey(MakeLocalKey(KeyLocalRangeTombstonePrefix, endKey))
+	err := engine.Iterate(start, end, func(kv proto.RawKeyValue) (bool, error) {
+		rt := proto.MVCCRangeTombstone{}
+		if err := gogoproto.Unmarshal(kv.Value, &rt); err != nil {
+			return false, util.Errorf("unable to unmarshal range tombstone %q: %s", kv.Key, err)
+		}
+		if key.Less(rt.EndKey) {
+			rts = append(rts, rt)
+		}
+		return false, nil
+	})
+	return rts, err
+}
+
+// mvccCheckRangeTombstones returns a WriteTooOldError if key is
+// covered by a range tombstone at or after timestamp.
+func mvccCheckRangeTombstones(engine Engine, key proto.Key, timestamp proto.Timestamp) error {
+	rts, err := mvccLoadRangeTombstones(engine, key, key.Next())
+	if err != nil {
+		return err
+	}
+	for _, rt := range rts.covering(key) {
+		if !rt.Timestamp.Less(timestamp) {
+			return &proto.WriteTooOldError{Timestamp: timestamp, ExistingTimestamp: rt.Timestamp}
+		}
+	}
+	return nil
+}
+
+// MVCCDeleteRangeTombstone deletes all keys from key to endKey as of
+// timestamp by writing a single range tombstone, which is counted in
+// ms. Range tombstones may not be written transactionally. The span is
+// checked for intents, and a WriteIntentError returned for the first
+// one found; while this reads the metadata for every key in the span,
+// it writes only one record.
+func MVCCDeleteRangeTombstone(engine Engine, ms *MVCCStats, key, endKey proto.Key, timestamp proto.Timestamp) error {
+	if len(key) == 0 || len(endKey) == 0 {
+		return emptyKeyError()
+	}
+	if !key.Less(endKey) {
+		return util.Errorf("invalid range tombstone span %q-%q", key, endKey)
+	}
+	if key.Less(KeyLocalMax) {
+		return util.Errorf("range tombstones may not cover local keys: %q", key)
+	}
+	if timestamp.Equal(proto.ZeroTimestamp) {
+		return util.Errorf("range tombstones require a non-zero timestamp")
+	}
+
+	meta := &proto.MVCCMetadata{}
+	err := engine.Iterate(MVCCEncodeKey(key), MVCCEncodeKey(endKey), func(kv proto.RawKeyValue) (bool, error) {
+		metaKey, _, isValue := MVCCDecodeKey(kv.Key)
+		if isValue {
+			return false, nil
+		}
+		if err := gogoproto.Unmarshal(kv.Value, meta); err != nil {
+			return false, util.Errorf("unable to unmarshal MVCC metadata %q: %s", kv.Key, err)
+		}
+		if meta.Txn != nil {
+			return true, &proto.WriteIntentError{Key: metaKey, Txn: *meta.Txn}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rt := &proto.MVCCRangeTombstone{StartKey: key, EndKey: endKey, Timestamp: timestamp}
+	if _, _, err = PutProto(engine, rangeTombstoneKey(key, timestamp), rt); err != nil {
+		return err
+	}
+	if ms != nil {
+		ms.RangeTombstoneCount++
+	}
+	return nil
+}
+
+// MVCCSplitRangeTombstones splits any range tombstone spanning
+// splitKey into two tombstones, one ending and one starting at
+// splitKey, so that each half of a split range addresses the
+// tombstones covering its keys. Stats aren't updated; the stats of
+// both halves are expected to be recomputed afterwards.
+func MVCCSplitRangeTombstones(engine Engine, splitKey proto.Key) error {
+	rts, err := mvccLoadRangeTombstones(engine, splitKey, splitKey.Next())
+	if err != nil {
+		return err
+	}
+	for _, rt := range rts.covering(splitKey) {
+		if bytes.Equal(rt.StartKey, splitKey) {
+			continue
+		}
+		left := rt
+		left.EndKey = splitKey
+		if _, _, err := PutProto(engine, rangeTombstoneKey(left.StartKey, left.Timestamp), &left); err != nil {
+			return err
+		}
+		right := rt
+		right.StartKey = splitKey
+		if _, _, err := PutProto(engine, rangeTombstoneKey(right.StartKey, right.Timestamp), &right); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MVCCGarbageCollectRangeTombstones removes range tombstones starting
+// between key and endKey with timestamps earlier than expiration,
+// along with every version they delete. Metadata is cleared for keys
+// left with no versions. Stats for the span are recomputed and the
+// difference applied to ms. Returns the number of tombstones removed.
+func MVCCGarbageCollectRangeTombstones(engine Engine, ms *MVCCStats, key, endKey proto.Key, expiration proto.Timestamp) (int64, error) {
+	rts, err := mvccLoadRangeTombstones(engine, key, endKey)
+	if err != nil {
+		return 0, err
+	}
+	var num int64
+	for _, rt := range rts {
+		if rt.StartKey.Less(key) || !rt.Timestamp.Less(expiration) {
+			continue
+		}
+		before, err := MVCCComputeStats(engine, rt.StartKey, rt.EndKey)
+		if err != nil {
+			return num, err
+		}
+		if err := mvccClearRangeTombstone(engine, rt); err != nil {
+			return num, err
+		}
+		after, err := MVCCComputeStats(engine, rt.StartKey, rt.EndKey)
+		if err != nil {
+			return num, err
+		}
+		if ms != nil {
+			after.Subtract(&before)
+			ms.Add(&after)
+		}
+		num++
+	}
+	return num, nil
+}
+
+// mvccClearRangeTombstone clears all versions deleted by the range
+// tombstone, the metadata of any key left without versions, and
+// finally the tombstone itself.
+func mvccClearRangeTombstone(engine Engine, rt proto.MVCCRangeTombstone) error {
+	var toClear []proto.EncodedKey
+	var metaKey proto.EncodedKey
+	var remaining int
+	flush := func() {
+		if metaKey != nil && remaining == 0 {
+			toClear = append(toClear, metaKey)
+		}
+	}
+	meta := &proto.MVCCMetadata{}
+	err := engine.Iterate(MVCCEncodeKey(rt.StartKey), MVCCEncodeKey(rt.EndKey), func(kv proto.RawKeyValue) (bool, error) {
+		_, ts, isValue := MVCCDecodeKey(kv.Key)
+		if !isValue {
+			flush()
+			if err := gogoproto.Unmarshal(kv.Value, meta); err != nil {
+				return false, util.Errorf("unable to unmarshal MVCC metadata %q: %s", kv.Key, err)
+			}
+			metaKey, remaining = nil, 0
+			if !meta.IsInline() {
+				metaKey = kv.Key
+			}
+			return false, nil
+		}
+		if ts.Less(rt.Timestamp) {
+			toClear = append(toClear, kv.Key)
+		} else {
+			remaining++
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	flush()
+	for _, key := range toClear {
+		if err := engine.Clear(key); err != nil {
+			return err
+		}
+	}
+	return engine.Clear(rangeTombstoneKey(rt.StartKey, rt.Timestamp))
+}
@@ -0,0 +1,296 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// putTestKeys writes value1 at timestamp ts to each of keys.
+func putTestKeys(t *testing.T, engine Engine, ts proto.Timestamp, keys ...proto.Key) {
+	for _, key := range keys {
+		if err := MVCCPut(engine, nil, key, ts, value1, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// scanTestKeys scans all of testKey1 through testKey4 at ts and
+// returns the keys found.
+func scanTestKeys(t *testing.T, engine Engine, ts proto.Timestamp) []proto.Key {
+	kvs, err := MVCCScan(engine, testKey1, testKey4.Next(), 0, ts, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []proto.Key
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func keysEqual(a, b []proto.Key) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// TestMVCCRangeTombstoneMasking verifies that a range tombstone masks
+// older versions of covered keys for reads at or after its timestamp.
+func TestMVCCRangeTombstoneMasking(t *testing.T) {
+	engine := createTestEngine()
+	putTestKeys(t, engine, makeTS(1, 0), testKey1, testKey2, testKey3, testKey4)
+	if err := MVCCDeleteRangeTombstone(engine, nil, testKey2, testKey4, makeTS(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	putTestKeys(t, engine, makeTS(3, 0), testKey3)
+
+	testCases := []struct {
+		ts      proto.Timestamp
+		expKeys []proto.Key
+	}{
+		{makeTS(1, 0), []proto.Key{testKey1, testKey2, testKey3, testKey4}},
+		{makeTS(2, 0), []proto.Key{testKey1, testKey4}},
+		{makeTS(3, 0), []proto.Key{testKey1, testKey3, testKey4}},
+	}
+	for i, test := range testCases {
+		if keys := scanTestKeys(t, engine, test.ts); !keysEqual(keys, test.expKeys) {
+			t.Errorf("%d: expected keys %q; got %q", i, test.expKeys, keys)
+		}
+	}
+
+	value, err := MVCCGet(engine, testKey2, makeTS(2, 0), true, nil)
+	if err != nil || value != nil {
+		t.Errorf("expected masked key to read as nil; got %+v, %v", value, err)
+	}
+	value, err = MVCCGet(engine, testKey2, makeTS(1, 0), true, nil)
+	if err != nil || value == nil {
+		t.Errorf("expected historical read below tombstone to succeed; got %+v, %v", value, err)
+	}
+
+	var committed []proto.Key
+	if err := MVCCIterateCommitted(engine, testKey1, testKey4.Next(), func(kv proto.KeyValue) (bool, error) {
+		committed = append(committed, kv.Key)
+		return false, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []proto.Key{testKey1, testKey3, testKey4}; !keysEqual(committed, exp) {
+		t.Errorf("expected committed keys %q; got %q", exp, committed)
+	}
+}
+
+// TestMVCCRangeTombstoneWrites verifies that writes beneath a range
+// tombstone fail and that tombstones cannot be written over intents.
+func TestMVCCRangeTombstoneWrites(t *testing.T) {
+	engine := createTestEngine()
+	if err := MVCCDeleteRangeTombstone(engine, nil, testKey1, testKey3, makeTS(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	err := MVCCPut(engine, nil, testKey2, makeTS(1, 0), value1, nil)
+	if _, ok := err.(*proto.WriteTooOldError); !ok {
+		t.Errorf("expected write too old error; got %v", err)
+	}
+	if err := MVCCPut(engine, nil, testKey2, makeTS(3, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCPut(engine, nil, testKey3, makeTS(1, 0), value1, nil); err != nil {
+		t.Errorf("expected write past tombstone end key to succeed: %s", err)
+	}
+
+	if err := MVCCPut(engine, nil, testKey4, makeTS(4, 0), value1, txn1); err != nil {
+		t.Fatal(err)
+	}
+	err = MVCCDeleteRangeTombstone(engine, nil, testKey3, testKey4.Next(), makeTS(5, 0))
+	if _, ok := err.(*proto.WriteIntentError); !ok {
+		t.Errorf("expected write intent error; got %v", err)
+	}
+	if err := MVCCDeleteRangeTombstone(engine, nil, KeyLocalPrefix, testKey1, makeTS(5, 0)); err == nil {
+		t.Error("expected error writing range tombstone over local keys")
+	}
+}
+
+// TestMVCCRangeTombstoneUncertainty verifies that a tombstone within
+// a transaction's uncertainty interval causes a read to restart.
+func TestMVCCRangeTombstoneUncertainty(t *testing.T) {
+	engine := createTestEngine()
+	putTestKeys(t, engine, makeTS(1, 0), testKey1)
+	if err := MVCCDeleteRangeTombstone(engine, nil, testKey1, testKey2, makeTS(5, 0)); err != nil {
+		t.Fatal(err)
+	}
+	txn := makeTxn(txn1, makeTS(3, 0))
+	txn.MaxTimestamp = makeTS(6, 0)
+	_, err := MVCCGet(engine, testKey1, makeTS(3, 0), true, txn)
+	if _, ok := err.(*proto.ReadWithinUncertaintyIntervalError); !ok {
+		t.Errorf("expected uncertainty error; got %v", err)
+	}
+	txn.MaxTimestamp = makeTS(4, 0)
+	if value, err := MVCCGet(engine, testKey1, makeTS(3, 0), true, txn); err != nil || value == nil {
+		t.Errorf("expected value below tombstone; got %+v, %v", value, err)
+	}
+}
+
+// TestMVCCSplitRangeTombstones verifies that tombstones spanning a
+// split key are divided at the split key.
+func TestMVCCSplitRangeTombstones(t *testing.T) {
+	engine := createTestEngine()
+	putTestKeys(t, engine, makeTS(1, 0), testKey1, testKey2, testKey3)
+	if err := MVCCDeleteRangeTombstone(engine, nil, testKey1, testKey4, makeTS(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCSplitRangeTombstones(engine, testKey2); err != nil {
+		t.Fatal(err)
+	}
+	rts, err := mvccLoadRangeTombstones(engine, testKey1, testKey4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rts) != 2 {
+		t.Fatalf("expected 2 tombstones; got %+v", rts)
+	}
+	if !bytes.Equal(rts[0].StartKey, testKey1) || !bytes.Equal(rts[0].EndKey, testKey2) ||
+		!bytes.Equal(rts[1].StartKey, testKey2) || !bytes.Equal(rts[1].EndKey, testKey4) {
+		t.Errorf("unexpected tombstones after split: %+v", rts)
+	}
+	if keys := scanTestKeys(t, engine, makeTS(2, 0)); len(keys) != 0 {
+		t.Errorf("expected all keys masked after split; got %q", keys)
+	}
+}
+
+// TestMVCCRangeTombstoneBounds verifies that reads on an engine
+// returned by WithRangeTombstoneBounds consider only the tombstones
+// starting within its bounds, and none if the bounds are empty.
+func TestMVCCRangeTombstoneBounds(t *testing.T) {
+	engine := createTestEngine()
+	putTestKeys(t, engine, makeTS(1, 0), testKey1, testKey2, testKey3)
+	if err := MVCCDeleteRangeTombstone(engine, nil, testKey1, testKey3, makeTS(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		start, end proto.Key
+		expMasked  bool
+	}{
+		{testKey1, testKey4, true},
+		{KeyMin, KeyMax, true},
+		{testKey2, testKey4, false}, // tombstone starts before the bounds
+		{testKey1, testKey1, false}, // empty bounds
+	}
+	for i, test := range testCases {
+		bounded := WithRangeTombstoneBounds(engine, test.start, test.end)
+		value, err := MVCCGet(bounded, testKey2, makeTS(2, 0), true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if masked := value == nil; masked != test.expMasked {
+			t.Errorf("%d: expected masked=%t; got value %+v", i, test.expMasked, value)
+		}
+	}
+}
+
+// TestMVCCComputeStatsRangeTombstones verifies that computed stats
+// count the range tombstones starting within the span, including
+// those created by splitting a tombstone.
+func TestMVCCComputeStatsRangeTombstones(t *testing.T) {
+	engine := createTestEngine()
+	ms := &MVCCStats{}
+	if err := MVCCDeleteRangeTombstone(engine, ms, testKey1, testKey4, makeTS(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCDeleteRangeTombstone(engine, ms, testKey3, testKey4, makeTS(3, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if ms.RangeTombstoneCount != 2 {
+		t.Errorf("expected 2 tombstones counted; got %d", ms.RangeTombstoneCount)
+	}
+	if err := MVCCSplitRangeTombstones(engine, testKey2); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		start, end proto.Key
+		expCount   int64
+	}{
+		{KeyMin, KeyMax, 3},
+		{KeyMin, testKey2, 1},
+		{testKey2, KeyMax, 2},
+		{testKey4, KeyMax, 0},
+	}
+	for i, test := range testCases {
+		computed, err := MVCCComputeStats(engine, test.start, test.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if computed.RangeTombstoneCount != test.expCount {
+			t.Errorf("%d: expected %d tombstones; got %d", i, test.expCount, computed.RangeTombstoneCount)
+		}
+	}
+}
+
+// TestMVCCGarbageCollectRangeTombstones verifies that expired
+// tombstones are removed along with the versions they delete, and that
+// stats are adjusted to match.
+func TestMVCCGarbageCollectRangeTombstones(t *testing.T) {
+	engine := createTestEngine()
+	ms := &MVCCStats{}
+	for _, key := range []proto.Key{testKey1, testKey2, testKey3} {
+		if err := MVCCPut(engine, ms, key, makeTS(1, 0), value1, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := MVCCPut(engine, ms, testKey2, makeTS(3, 0), value2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCDeleteRangeTombstone(engine, ms, testKey1, testKey3, makeTS(2, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tombstone isn't yet expired.
+	if num, err := MVCCGarbageCollectRangeTombstones(engine, ms, testKey1, testKey4, makeTS(2, 0)); err != nil || num != 0 {
+		t.Fatalf("expected no tombstones collected; got %d, %v", num, err)
+	}
+	if num, err := MVCCGarbageCollectRangeTombstones(engine, ms, testKey1, testKey4, makeTS(10, 0)); err != nil || num != 1 {
+		t.Fatalf("expected one tombstone collected; got %d, %v", num, err)
+	}
+
+	// testKey1 is gone entirely; testKey2 retains only its newer version.
+	if data, err := engine.Get(MVCCEncodeKey(testKey1)); err != nil || data != nil {
+		t.Errorf("expected metadata for %q to be cleared; got %q, %v", testKey1, data, err)
+	}
+	if value, err := MVCCGet(engine, testKey2, makeTS(1, 0), true, nil); err != nil || value != nil {
+		t.Errorf("expected old version of %q to be cleared; got %+v, %v", testKey2, value, err)
+	}
+	if keys := scanTestKeys(t, engine, makeTS(10, 0)); !keysEqual(keys, []proto.Key{testKey2, testKey3}) {
+		t.Errorf("unexpected keys after GC: %q", keys)
+	}
+	if rts, err := mvccLoadRangeTombstones(engine, testKey1, testKey4); err != nil || len(rts) != 0 {
+		t.Errorf("expected tombstone to be cleared; got %+v, %v", rts, err)
+	}
+	expMS, err := MVCCComputeStats(engine, testKey1, testKey4.Next())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyStats("after GC", ms, &expMS, t)
+}
@@ -29,7 +29,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"sync"
+	"strconv"
 	"syscall"
 	"unsafe"
 
@@ -46,22 +46,96 @@ const defaultCacheSize = 1 << 30 // GB
 var cacheSize = flag.Int64("cache_size", defaultCacheSize, "total size in bytes for "+
 	"caches, shared evenly if there are multiple storage devices")
 
+var (
+	bloomBits = flag.Int("rocksdb_bloom_bits", 0, "bits per key of the bloom "+
+		"filters built for RocksDB stores, which spare reads of keys that don't "+
+		"exist from touching disk; 0 disables bloom filters")
+	compression = flag.String("rocksdb_compression", "snappy", "compression "+
+		"algorithm for RocksDB stores: one of none, snappy, zlib, bzip2, lz4 or lz4hc")
+	maxOpenFiles = flag.Int("rocksdb_max_open_files", 0, "maximum number of "+
+		"files each RocksDB store keeps open; 0 uses the RocksDB default and -1 "+
+		"leaves it unlimited")
+)
+
+// rocksDBCompressions maps the names of compression algorithms to
+// their rocksdb::CompressionType values.
+var rocksDBCompressions = map[string]int{
+	"none":   0,
+	"snappy": 1,
+	"zlib":   2,
+	"bzip2":  3,
+	"lz4":    4,
+	"lz4hc":  5,
+}
+
+// RocksDBOptions holds per-store tuning options for RocksDB.
+type RocksDBOptions struct {
+	CacheSize       int64  // Size in bytes of the block cache
+	BloomFilterBits int    // Bits per key of bloom filters; 0 to disable
+	Compression     string // Compression algorithm; see rocksDBCompressions
+	MaxOpenFiles    int    // Max open files; 0 for the RocksDB default
+}
+
+// DefaultRocksDBOptions returns options initialized from the command
+// line flags.
+func DefaultRocksDBOptions() RocksDBOptions {
+	return RocksDBOptions{
+		CacheSize:       *cacheSize,
+		BloomFilterBits: *bloomBits,
+		Compression:     *compression,
+		MaxOpenFiles:    *maxOpenFiles,
+	}
+}
+
+// Set parses value and sets the option with the specified name,
+// which is one of "cache", "bloom_bits", "compression" or
+// "max_open_files".
+func (o *RocksDBOptions) Set(name, value string) error {
+	switch name {
+	case "cache":
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 {
+			return util.Errorf("invalid cache size %q", value)
+		}
+		o.CacheSize = size
+	case "bloom_bits":
+		bits, err := strconv.Atoi(value)
+		if err != nil || bits < 0 {
+			return util.Errorf("invalid bloom filter bits %q", value)
+		}
+		o.BloomFilterBits = bits
+	case "compression":
+		if _, ok := rocksDBCompressions[value]; !ok {
+			return util.Errorf("unknown compression algorithm %q", value)
+		}
+		o.Compression = value
+	case "max_open_files":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < -1 {
+			return util.Errorf("invalid max open files %q", value)
+		}
+		o.MaxOpenFiles = n
+	default:
+		return util.Errorf("unknown RocksDB option %q", name)
+	}
+	return nil
+}
+
 // RocksDB is a wrapper around a RocksDB database instance.
 type RocksDB struct {
 	rdb   *C.DBEngine
 	attrs proto.Attributes // Attributes for this engine
 	dir   string           // The data directory
-
-	sync.Mutex                          // Protects the snapshots map.
-	snapshots  map[string]*C.DBSnapshot // Map of snapshot handles by snapshot ID
+	opts  RocksDBOptions   // Options used to open the database
 }
 
-// NewRocksDB allocates and returns a new RocksDB object.
-func NewRocksDB(attrs proto.Attributes, dir string) *RocksDB {
+// NewRocksDB allocates and returns a new RocksDB object which is
+// opened with the specified options.
+func NewRocksDB(attrs proto.Attributes, dir string, opts RocksDBOptions) *RocksDB {
 	return &RocksDB{
-		snapshots: map[string]*C.DBSnapshot{},
-		attrs:     attrs,
-		dir:       dir,
+		attrs: attrs,
+		dir:   dir,
+		opts:  opts,
 	}
 }
 
@@ -86,10 +160,17 @@ func (r *RocksDB) Start() error {
 	rcachePrefix := goToCSlice(MVCCEncodeKey(KeyLocalResponseCachePrefix))
 	rcachePrefix.len-- // Trim nul-byte suffix
 
+	compression, ok := rocksDBCompressions[r.opts.Compression]
+	if !ok {
+		return util.Errorf("unknown compression algorithm %q", r.opts.Compression)
+	}
 	status := C.DBOpen(&r.rdb, goToCSlice([]byte(r.dir)),
 		C.DBOptions{
-			cache_size:      C.int64_t(*cacheSize),
+			cache_size:      C.int64_t(r.opts.CacheSize),
 			allow_os_buffer: C.int(1),
+			bloom_bits:      C.int(r.opts.BloomFilterBits),
+			compression:     C.int(compression),
+			max_open_files:  C.int(r.opts.MaxOpenFiles),
 			txn_prefix:      txnPrefix,
 			rcache_prefix:   rcachePrefix,
 			logger:          C.DBLoggerFunc(nil),
@@ -114,39 +195,6 @@ func (r *RocksDB) Stop() {
 	r.rdb = nil
 }
 
-// CreateSnapshot creates a snapshot handle from engine.
-func (r *RocksDB) CreateSnapshot(snapshotID string) error {
-	if r.rdb == nil {
-		return util.Errorf("RocksDB is not initialized yet")
-	}
-	r.Lock()
-	defer r.Unlock()
-	_, ok := r.snapshots[snapshotID]
-	if ok {
-		return util.Errorf("snapshotID %s already exists", snapshotID)
-	}
-	snapshotHandle := C.DBNewSnapshot(r.rdb)
-	r.snapshots[snapshotID] = snapshotHandle
-	return nil
-}
-
-// ReleaseSnapshot releases the existing snapshot handle for the
-// given snapshotID.
-func (r *RocksDB) ReleaseSnapshot(snapshotID string) error {
-	if r.rdb == nil {
-		return util.Errorf("RocksDB is not initialized yet")
-	}
-	r.Lock()
-	defer r.Unlock()
-	snapshotHandle, ok := r.snapshots[snapshotID]
-	if !ok {
-		return util.Errorf("snapshotID %s does not exist", snapshotID)
-	}
-	C.DBSnapshotRelease(snapshotHandle)
-	delete(r.snapshots, snapshotID)
-	return nil
-}
-
 // Attrs returns the list of attributes describing this engine. This
 // may include a specification of disk type (e.g. hdd, ssd, fio, etc.)
 // and potentially other labels to identify important attributes of
@@ -159,6 +207,22 @@ func emptyKeyError() error {
 	return util.ErrorSkipFrames(1, "attempted access to empty key")
 }
 
+func inconsistentTxnError() error {
+	return util.ErrorSkipFrames(1, "cannot read inconsistently within a transaction")
+}
+
+func snapshotWriteError() error {
+	return util.ErrorSkipFrames(1, "attempted write to a read-only snapshot")
+}
+
+func snapshotStoppedError() error {
+	return util.ErrorSkipFrames(1, "attempted read from a stopped snapshot")
+}
+
+func snapshotOfSnapshotError() error {
+	return util.ErrorSkipFrames(1, "cannot create a snapshot from a snapshot")
+}
+
 // Put sets the given key to the value provided.
 //
 // The key and value byte slices may be reused safely. put takes a copy of
@@ -198,19 +262,6 @@ func (r *RocksDB) Get(key proto.EncodedKey) ([]byte, error) {
 	return r.getInternal(key, nil)
 }
 
-// GetSnapshot returns the value for the given key from the given
-// snapshotID, nil otherwise.
-func (r *RocksDB) GetSnapshot(key proto.EncodedKey, snapshotID string) ([]byte, error) {
-	r.Lock()
-	snapshotHandle, ok := r.snapshots[snapshotID]
-	if !ok {
-		return nil, util.Errorf("snapshotID %s does not exist", snapshotID)
-	}
-	r.Unlock()
-
-	return r.getInternal(key, snapshotHandle)
-}
-
 // Get returns the value for the given key.
 func (r *RocksDB) getInternal(key proto.EncodedKey, snapshotHandle *C.DBSnapshot) ([]byte, error) {
 	if len(key) == 0 {
@@ -238,19 +289,6 @@ func (r *RocksDB) Iterate(start, end proto.EncodedKey, f func(proto.RawKeyValue)
 	return r.iterateInternal(start, end, f, nil)
 }
 
-// IterateSnapshot iterates from start to end keys, invoking f on
-// each key/value pair. See engine.IterateSnapshot for details.
-func (r *RocksDB) IterateSnapshot(start, end proto.EncodedKey, snapshotID string, f func(proto.RawKeyValue) (bool, error)) error {
-	r.Lock()
-	snapshotHandle, ok := r.snapshots[snapshotID]
-	if !ok {
-		return util.Errorf("snapshotID %s does not exist", snapshotID)
-	}
-	r.Unlock()
-
-	return r.iterateInternal(start, end, f, snapshotHandle)
-}
-
 func (r *RocksDB) iterateInternal(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error),
 	snapshotHandle *C.DBSnapshot) error {
 	if bytes.Compare(start, end) >= 0 {
@@ -357,6 +395,44 @@ func (r *RocksDB) ApproximateSize(start, end proto.EncodedKey) (uint64, error) {
 	return uint64(C.DBApproximateSize(r.rdb, goToCSlice(start), goToCSlice(end))), nil
 }
 
+// ApproximateKeyCount returns the approximate number of keys RocksDB
+// is storing in the given range of keys. RocksDB only estimates the
+// number of keys in the whole database, so the estimate is scaled by
+// the fraction of the database's size on disk occupied by the range.
+// As with ApproximateSize, data not yet flushed to disk isn't counted
+// towards the range.
+func (r *RocksDB) ApproximateKeyCount(start, end proto.EncodedKey) (uint64, error) {
+	var numKeys C.uint64_t
+	if err := statusToError(C.DBEstimateNumKeys(r.rdb, &numKeys)); err != nil {
+		return 0, err
+	}
+	total, err := r.ApproximateSize(MVCCEncodeKey(KeyMin), MVCCEncodeKey(KeyMax))
+	if err != nil || total == 0 {
+		return 0, err
+	}
+	size, err := r.ApproximateSize(start, end)
+	if err != nil {
+		return 0, err
+	}
+	if size >= total {
+		return uint64(numKeys), nil
+	}
+	return uint64(float64(numKeys) * float64(size) / float64(total)), nil
+}
+
+// CompactionStats returns the number of files at level 0 and whether
+// RocksDB has a compaction pending.
+func (r *RocksDB) CompactionStats() (CompactionStats, error) {
+	var stats C.DBCompactionStats
+	if err := statusToError(C.DBGetCompactionStats(r.rdb, &stats)); err != nil {
+		return CompactionStats{}, err
+	}
+	return CompactionStats{
+		L0FileCount:       int64(stats.l0_file_count),
+		PendingCompaction: stats.pending_compaction != 0,
+	}, nil
+}
+
 // Flush causes RocksDB to write all in-memory data to disk immediately.
 func (r *RocksDB) Flush() error {
 	return statusToError(C.DBFlush(r.rdb))
@@ -423,6 +499,40 @@ func goMerge(existing, update []byte) ([]byte, error) {
 	return cStringToGoBytes(result), nil
 }
 
+// goCompareKeys compares the two keys using the comparator installed
+// on RocksDB engines, returning -1, 0 or +1.
+func goCompareKeys(a, b []byte) int {
+	c := int(C.DBCompareKeys(goToCSlice(a), goToCSlice(b)))
+	switch {
+	case c < 0:
+		return -1
+	case c > 0:
+		return 1
+	}
+	return 0
+}
+
+// goEncodeVersionKey encodes an MVCC version key using the C++
+// implementation of the MVCC key encoding.
+func goEncodeVersionKey(key proto.Key, timestamp proto.Timestamp) proto.EncodedKey {
+	result := C.DBEncodeVersionKey(goToCSlice(key),
+		C.int64_t(timestamp.WallTime), C.int32_t(timestamp.Logical))
+	return proto.EncodedKey(cStringToGoBytes(result))
+}
+
+// goDecodeKey decodes an MVCC key using the C++ implementation of the
+// MVCC key encoding. The timestamp is zero for MVCC metadata keys.
+func goDecodeKey(encodedKey proto.EncodedKey) (proto.Key, proto.Timestamp, error) {
+	var key C.DBString
+	var wallTime C.int64_t
+	var logical C.int32_t
+	status := C.DBDecodeKey(goToCSlice(encodedKey), &key, &wallTime, &logical)
+	if status.data != nil {
+		return nil, proto.Timestamp{}, util.Errorf("%s: %q", cStringToGoString(status), encodedKey)
+	}
+	return proto.Key(cStringToGoBytes(key)), proto.Timestamp{WallTime: int64(wallTime), Logical: int32(logical)}, nil
+}
+
 // NewIterator returns an iterator over this rocksdb engine.
 func (r *RocksDB) NewIterator() Iterator {
 	return newRocksDBIterator(r.rdb, nil)
@@ -438,6 +548,151 @@ func (r *RocksDB) Commit() error {
 	return nil
 }
 
+// NewSnapshot returns a new read-only view of this rocksdb engine,
+// backed by a RocksDB snapshot.
+func (r *RocksDB) NewSnapshot() (Engine, error) {
+	if r.rdb == nil {
+		return nil, util.Errorf("RocksDB is not initialized yet")
+	}
+	return &rocksDBSnapshot{
+		parent: r,
+		handle: C.DBNewSnapshot(r.rdb),
+	}, nil
+}
+
+// rocksDBSnapshot is a read-only, point-in-time view of a RocksDB
+// engine. Reads are served from a RocksDB snapshot, which is released
+// by Stop(). A nil snapshot handle means "read the live database" to
+// the underlying RocksDB calls, so every read checks that the snapshot
+// hasn't been stopped.
+type rocksDBSnapshot struct {
+	parent *RocksDB
+	handle *C.DBSnapshot
+}
+
+// Start returns an error if called on a snapshot.
+func (r *rocksDBSnapshot) Start() error {
+	return util.Errorf("cannot start a snapshot")
+}
+
+// Stop releases the snapshot.
+func (r *rocksDBSnapshot) Stop() {
+	if r.handle != nil {
+		C.DBSnapshotRelease(r.handle)
+		r.handle = nil
+	}
+}
+
+// Attrs returns the attributes of the underlying engine.
+func (r *rocksDBSnapshot) Attrs() proto.Attributes {
+	return r.parent.Attrs()
+}
+
+// Put returns an error if called on a snapshot.
+func (r *rocksDBSnapshot) Put(key proto.EncodedKey, value []byte) error {
+	return snapshotWriteError()
+}
+
+// Get returns the value for the given key as of the snapshot, nil
+// otherwise.
+func (r *rocksDBSnapshot) Get(key proto.EncodedKey) ([]byte, error) {
+	if r.handle == nil {
+		return nil, snapshotStoppedError()
+	}
+	return r.parent.getInternal(key, r.handle)
+}
+
+// Iterate iterates from start to end keys as of the snapshot,
+// invoking f on each key/value pair. See engine.Iterate for details.
+func (r *rocksDBSnapshot) Iterate(start, end proto.EncodedKey, f func(proto.RawKeyValue) (bool, error)) error {
+	if r.handle == nil {
+		return snapshotStoppedError()
+	}
+	return r.parent.iterateInternal(start, end, f, r.handle)
+}
+
+// Clear returns an error if called on a snapshot.
+func (r *rocksDBSnapshot) Clear(key proto.EncodedKey) error {
+	return snapshotWriteError()
+}
+
+// WriteBatch returns an error if called on a snapshot.
+func (r *rocksDBSnapshot) WriteBatch([]interface{}) error {
+	return snapshotWriteError()
+}
+
+// Merge returns an error if called on a snapshot.
+func (r *rocksDBSnapshot) Merge(key proto.EncodedKey, value []byte) error {
+	return snapshotWriteError()
+}
+
+// Capacity returns the capacity of the underlying engine.
+func (r *rocksDBSnapshot) Capacity() (StoreCapacity, error) {
+	return r.parent.Capacity()
+}
+
+// SetGCTimeouts is a noop for a snapshot.
+func (r *rocksDBSnapshot) SetGCTimeouts(minTxnTS, minRCacheTS int64) {
+}
+
+// ApproximateSize returns the approximate size of the key range in
+// the underlying engine.
+func (r *rocksDBSnapshot) ApproximateSize(start, end proto.EncodedKey) (uint64, error) {
+	return r.parent.ApproximateSize(start, end)
+}
+
+// ApproximateKeyCount returns the approximate number of keys in the
+// key range in the underlying engine.
+func (r *rocksDBSnapshot) ApproximateKeyCount(start, end proto.EncodedKey) (uint64, error) {
+	return r.parent.ApproximateKeyCount(start, end)
+}
+
+// CompactionStats returns the compaction stats of the underlying
+// engine.
+func (r *rocksDBSnapshot) CompactionStats() (CompactionStats, error) {
+	return r.parent.CompactionStats()
+}
+
+// NewIterator returns an iterator over the snapshot. If the snapshot
+// has been stopped, the iterator is never valid and returns an error.
+func (r *rocksDBSnapshot) NewIterator() Iterator {
+	if r.handle == nil {
+		return &errorIterator{err: snapshotStoppedError()}
+	}
+	return newRocksDBIterator(r.parent.rdb, r.handle)
+}
+
+// NewBatch returns a new Batch wrapping the snapshot. Reads through
+// the batch see the snapshot; committing it returns an error.
+func (r *rocksDBSnapshot) NewBatch() Engine {
+	return &Batch{engine: r}
+}
+
+// Commit is a noop for a snapshot.
+func (r *rocksDBSnapshot) Commit() error {
+	return nil
+}
+
+// NewSnapshot returns an error if called on a snapshot.
+func (r *rocksDBSnapshot) NewSnapshot() (Engine, error) {
+	return nil, snapshotOfSnapshotError()
+}
+
+// errorIterator is an iterator which is never valid and reports err.
+type errorIterator struct {
+	err error
+}
+
+// The following methods implement the Iterator interface.
+func (e *errorIterator) Close()                 {}
+func (e *errorIterator) Seek(key []byte)        {}
+func (e *errorIterator) SeekReverse(key []byte) {}
+func (e *errorIterator) Valid() bool            { return false }
+func (e *errorIterator) Next()                  {}
+func (e *errorIterator) Key() []byte            { return nil }
+func (e *errorIterator) Value() []byte          { return nil }
+func (e *errorIterator) Error() error           { return e.err }
+
 type rocksDBIterator struct {
 	iter *C.DBIterator
 }
@@ -471,6 +726,21 @@ func (r *rocksDBIterator) Seek(key []byte) {
 	}
 }
 
+func (r *rocksDBIterator) SeekReverse(key []byte) {
+	if len(key) == 0 {
+		C.DBIterSeekToLast(r.iter)
+		return
+	}
+	// Seek to the first key >= key and step back one; if there is no
+	// such key, the last key in the database precedes key.
+	C.DBIterSeek(r.iter, goToCSlice(key))
+	if C.DBIterValid(r.iter) == 1 {
+		C.DBIterPrev(r.iter)
+	} else {
+		C.DBIterSeekToLast(r.iter)
+	}
+}
+
 func (r *rocksDBIterator) Valid() bool {
 	return C.DBIterValid(r.iter) == 1
 }
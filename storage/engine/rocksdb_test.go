@@ -21,11 +21,14 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/sstable"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/log"
@@ -68,7 +71,7 @@ func encodeTransaction(timestamp proto.Timestamp, t *testing.T) []byte {
 func TestRocksDBCompaction(t *testing.T) {
 	gob.Register(proto.Timestamp{})
 	loc := util.CreateTempDirectory()
-	rocksdb := NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc)
+	rocksdb := NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc, DefaultRocksDBOptions())
 	err := rocksdb.Start()
 	if err != nil {
 		t.Fatalf("could not create new rocksdb db instance at %s: %v", loc, err)
@@ -89,19 +92,19 @@ func TestRocksDBCompaction(t *testing.T) {
 	kvs := []proto.KeyValue{
 		proto.KeyValue{
 			Key:   MakeLocalKey(rcPre, proto.Key("a")),
-			Value: proto.Value{Bytes: encodePutResponse(makeTS(2, 0), t)},
+			Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: encodePutResponse(makeTS(2, 0), t)}},
 		},
 		proto.KeyValue{
 			Key:   MakeLocalKey(rcPre, proto.Key("b")),
-			Value: proto.Value{Bytes: encodePutResponse(makeTS(3, 0), t)},
+			Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: encodePutResponse(makeTS(3, 0), t)}},
 		},
 		proto.KeyValue{
 			Key:   MakeLocalKey(txnPre, proto.Key("a")),
-			Value: proto.Value{Bytes: encodeTransaction(makeTS(1, 0), t)},
+			Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: encodeTransaction(makeTS(1, 0), t)}},
 		},
 		proto.KeyValue{
 			Key:   MakeLocalKey(txnPre, proto.Key("b")),
-			Value: proto.Value{Bytes: encodeTransaction(makeTS(2, 0), t)},
+			Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: encodeTransaction(makeTS(2, 0), t)}},
 		},
 	}
 	for _, kv := range kvs {
@@ -112,7 +115,7 @@ func TestRocksDBCompaction(t *testing.T) {
 
 	// Compact range and scan remaining values to compare.
 	rocksdb.CompactRange(nil, nil)
-	actualKVs, err := MVCCScan(rocksdb, KeyMin, KeyMax, 0, proto.ZeroTimestamp, nil)
+	actualKVs, err := MVCCScan(rocksdb, KeyMin, KeyMax, 0, proto.ZeroTimestamp, true, nil)
 	if err != nil {
 		t.Fatalf("could not run scan: %v", err)
 	}
@@ -159,7 +162,7 @@ func setupMVCCData(rocksdb *RocksDB, numVersions, numKeys int, b *testing.B) map
 			// Only write values if this iteration is less than the random
 			// number of versions chosen for this key.
 			if t <= nvs[i] {
-				value := proto.Value{Bytes: []byte(util.RandString(rng, 1024))}
+				value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte(util.RandString(rng, 1024))}}
 				if err := MVCCPut(batch, nil, keys[i], ts, value, nil); err != nil {
 					b.Fatal(err)
 				}
@@ -184,7 +187,7 @@ func setupMVCCData(rocksdb *RocksDB, numVersions, numKeys int, b *testing.B) map
 // necessary.
 func runMVCCScan(numVersions, numKeys int, b *testing.B) {
 	loc := util.CreateTempDirectory()
-	rocksdb := NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc)
+	rocksdb := NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc, DefaultRocksDBOptions())
 	if err := rocksdb.Start(); err != nil {
 		b.Fatalf("could not create new rocksdb db instance at %s: %v", loc, err)
 	}
@@ -206,7 +209,7 @@ func runMVCCScan(numVersions, numKeys int, b *testing.B) {
 		startKey := proto.Key(encoding.EncodeInt([]byte("key-"), int64(keyIdx)))
 		walltime := int64(5 * (rand.Int31n(int32(numVersions)) + 1))
 		ts := makeTS(walltime, 0)
-		kvs, err := MVCCScan(rocksdb, startKey, KeyMax, maxRows, ts, nil)
+		kvs, err := MVCCScan(rocksdb, startKey, KeyMax, maxRows, ts, true, nil)
 		if err != nil {
 			b.Fatalf("failed scan: %s", err)
 		}
@@ -234,7 +237,7 @@ func BenchmarkMVCCScan1Version(b *testing.B) {
 // runMVCCMerge merges value numMerges times into numKeys separate keys.
 func runMVCCMerge(value *proto.Value, numMerges, numKeys int, b *testing.B) {
 	loc := util.CreateTempDirectory()
-	rocksdb := NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc)
+	rocksdb := NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc, DefaultRocksDBOptions())
 	if err := rocksdb.Start(); err != nil {
 		b.Fatalf("could not create new rocksdb db instance at %s: %v", loc, err)
 	}
@@ -264,7 +267,7 @@ func runMVCCMerge(value *proto.Value, numMerges, numKeys int, b *testing.B) {
 
 	// Read values out to force merge.
 	for _, key := range keys {
-		val, err := MVCCGet(rocksdb, key, proto.ZeroTimestamp, nil)
+		val, err := MVCCGet(rocksdb, key, proto.ZeroTimestamp, true, nil)
 		if err != nil {
 			b.Fatal(err)
 		} else if val == nil {
@@ -280,7 +283,7 @@ func runMVCCMerge(value *proto.Value, numMerges, numKeys int, b *testing.B) {
 
 // BenchmarkMVCCMergeInteger computes performance of merging integers.
 func BenchmarkMVCCMergeInteger(b *testing.B) {
-	runMVCCMerge(&proto.Value{Integer: gogoproto.Int64(1)}, 1024, 1024, b)
+	runMVCCMerge(&proto.Value{ValuePayload: proto.ValuePayload{Integer: gogoproto.Int64(1)}}, 1024, 1024, b)
 }
 
 // BenchmarkMVCCMergeTimeSeries computes performance of merging time series data.
@@ -298,3 +301,192 @@ func BenchmarkMVCCMergeTimeSeries(b *testing.B) {
 	}
 	runMVCCMerge(value, 1024, 1024, b)
 }
+
+// TestRocksDBComparator verifies that the comparator installed on
+// RocksDB agrees with MVCCComparator on both well-formed MVCC keys and
+// raw keys, and that iteration yields keys in the expected order.
+func TestRocksDBComparator(t *testing.T) {
+	keys := mvccComparatorKeys()
+	// Add random MVCC keys and a few raw keys.
+	r := util.NewPseudoRand()
+	for i := 0; i < 100; i++ {
+		key := proto.Key(util.RandString(r, r.Intn(3)))
+		if r.Intn(4) == 0 {
+			keys = append(keys, MVCCEncodeKey(key))
+		} else {
+			ts := makeTS(r.Int63n(10), r.Int31n(3))
+			keys = append(keys, MVCCEncodeVersionKey(key, ts))
+		}
+	}
+	keys = append(keys, proto.EncodedKey("a"), proto.EncodedKey("\x25a"),
+		append(MVCCEncodeKey(proto.Key("a")), 1))
+
+	for _, a := range keys {
+		for _, b := range keys {
+			if c, exp := goCompareKeys(a, b), MVCCComparator(a, b); c != exp {
+				t.Fatalf("expected compare(%q, %q) = %d; got %d", a, b, exp, c)
+			}
+		}
+	}
+
+	loc := util.CreateTempDirectory()
+	rocksdb := NewRocksDB(proto.Attributes{}, loc, DefaultRocksDBOptions())
+	if err := rocksdb.Start(); err != nil {
+		t.Fatalf("could not create new rocksdb db instance at %s: %v", loc, err)
+	}
+	defer func() {
+		rocksdb.Stop()
+		if err := rocksdb.Destroy(); err != nil {
+			t.Errorf("could not delete rocksdb db at %s: %v", loc, err)
+		}
+	}()
+
+	for _, i := range r.Perm(len(keys)) {
+		if err := rocksdb.Put(keys[i], []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kvs, err := Scan(rocksdb, proto.EncodedKey(KeyMin), proto.EncodedKey(KeyMax), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(kvs); i++ {
+		if MVCCComparator(kvs[i-1].Key, kvs[i].Key) >= 0 {
+			t.Errorf("%d: keys out of order: %q >= %q", i, kvs[i-1].Key, kvs[i].Key)
+		}
+	}
+}
+
+// TestRocksDBOptions verifies that options are parsed and validated
+// and that a database opened with them serves reads and writes.
+func TestRocksDBOptions(t *testing.T) {
+	opts := DefaultRocksDBOptions()
+	for _, c := range []struct{ name, value string }{
+		{"cache", "1048576"},
+		{"bloom_bits", "10"},
+		{"compression", "none"},
+		{"max_open_files", "100"},
+	} {
+		if err := opts.Set(c.name, c.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expOpts := RocksDBOptions{CacheSize: 1048576, BloomFilterBits: 10, Compression: "none", MaxOpenFiles: 100}
+	if opts != expOpts {
+		t.Errorf("expected options %+v; got %+v", expOpts, opts)
+	}
+	for _, c := range []struct{ name, value string }{
+		{"cache", "-1"},
+		{"cache", "1GiB"},
+		{"bloom_bits", "x"},
+		{"compression", "gzip"},
+		{"max_open_files", "-2"},
+		{"block_size", "4096"},
+	} {
+		if err := opts.Set(c.name, c.value); err == nil {
+			t.Errorf("expected error setting %s=%s", c.name, c.value)
+		}
+	}
+
+	loc := util.CreateTempDirectory()
+	rocksdb := NewRocksDB(proto.Attributes{}, loc, opts)
+	if err := rocksdb.Start(); err != nil {
+		t.Fatalf("could not create new rocksdb db instance at %s: %v", loc, err)
+	}
+	defer func() {
+		rocksdb.Stop()
+		if err := rocksdb.Destroy(); err != nil {
+			t.Errorf("could not delete rocksdb db at %s: %v", loc, err)
+		}
+	}()
+	key := proto.EncodedKey("a")
+	if err := rocksdb.Put(key, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := rocksdb.Get(key); err != nil || !bytes.Equal(val, []byte("value")) {
+		t.Errorf("expected %q; got %q, %v", "value", val, err)
+	}
+	if val, err := rocksdb.Get(proto.EncodedKey("b")); err != nil || val != nil {
+		t.Errorf("expected missing key; got %q, %v", val, err)
+	}
+}
+
+// TestRocksDBSSTableInterop verifies that the tables RocksDB flushes
+// can be read by the sstable package, with and without compression
+// and with bloom filters enabled.
+func TestRocksDBSSTableInterop(t *testing.T) {
+	for _, compression := range []string{"none", "snappy"} {
+		opts := DefaultRocksDBOptions()
+		opts.BloomFilterBits = 10
+		opts.Compression = compression
+		loc := util.CreateTempDirectory()
+		rocksdb := NewRocksDB(proto.Attributes{}, loc, opts)
+		if err := rocksdb.Start(); err != nil {
+			t.Fatalf("could not create new rocksdb db instance at %s: %v", loc, err)
+		}
+
+		// Keys at a single timestamp sort the same bytewise and under
+		// the MVCC comparator.
+		var keys []proto.EncodedKey
+		for i := 0; i < 1000; i++ {
+			keys = append(keys, MVCCEncodeVersionKey(proto.Key(fmt.Sprintf("key-%04d", i)), makeTS(1, 0)))
+		}
+		for _, i := range rand.Perm(len(keys)) {
+			if err := rocksdb.Put(keys[i], []byte(fmt.Sprintf("value-%04d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rocksdb.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		files, err := filepath.Glob(filepath.Join(loc, "*.sst"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Fatalf("%s: expected a single flushed table; got %v", compression, files)
+		}
+		data, err := ioutil.ReadFile(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rocksdb.Stop()
+		if err := rocksdb.Destroy(); err != nil {
+			t.Errorf("could not delete rocksdb db at %s: %v", loc, err)
+		}
+
+		r, err := sstable.NewReader(bytes.NewReader(data), int64(len(data)), &sstable.Options{
+			Compare: func(a, b []byte) int { return MVCCComparator(a, b) },
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", compression, err)
+		}
+		if n := r.Properties().NumEntries; n != uint64(len(keys)) {
+			t.Errorf("%s: expected %d entries; got %d", compression, len(keys), n)
+		}
+		i := 0
+		iter := r.NewIterator()
+		for iter.Seek(nil); iter.Valid(); iter.Next() {
+			if i >= len(keys) {
+				t.Fatalf("%s: unexpected key %q", compression, iter.Key())
+			}
+			if !bytes.Equal(iter.Key(), keys[i]) || iter.Kind() != sstable.KindValue {
+				t.Errorf("%s: %d: expected value for %q; got %q (kind %d)", compression, i, keys[i], iter.Key(), iter.Kind())
+			}
+			if exp := fmt.Sprintf("value-%04d", i); string(iter.Value()) != exp {
+				t.Errorf("%s: %d: expected %q; got %q", compression, i, exp, iter.Value())
+			}
+			i++
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal(err)
+		}
+		iter.Close()
+		if i != len(keys) {
+			t.Errorf("%s: expected %d keys; got %d", compression, len(keys), i)
+		}
+		if val, err := r.Get(keys[500]); err != nil || string(val) != "value-0500" {
+			t.Errorf("%s: expected %q; got %q, %v", compression, "value-0500", val, err)
+		}
+	}
+}
@@ -20,7 +20,6 @@ package engine
 import (
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/util/encoding"
-	gogoproto "github.com/gogo/protobuf/proto"
 )
 
 // Constants for stat key construction.
@@ -51,6 +50,12 @@ var (
 	StatValCount = proto.Key("val-count")
 	// StatIntentCount counts the number of unresolved intents.
 	StatIntentCount = proto.Key("intent-count")
+	// StatGCBytesAge accumulates the age of non-live bytes relative to
+	// the Unix epoch. Only kept for ranges; see MVCCStats.GCBytesAge.
+	StatGCBytesAge = proto.Key("gc-bytes-age")
+	// StatRangeTombstoneCount counts the range tombstones starting
+	// within a range. Only kept for ranges.
+	StatRangeTombstoneCount = proto.Key("range-tombstone-count")
 )
 
 // MakeRangeStatKey returns the key for accessing the named stat
@@ -71,7 +76,7 @@ func MakeStoreStatKey(storeID int32, stat proto.Key) proto.Key {
 // If the stat could not be found, returns 0. An error is returned
 // on stat decode error.
 func GetRangeStat(engine Engine, raftID int64, stat proto.Key) (int64, error) {
-	val, err := MVCCGet(engine, MakeRangeStatKey(raftID, stat), proto.ZeroTimestamp, nil)
+	val, err := MVCCGet(engine, MakeRangeStatKey(raftID, stat), proto.ZeroTimestamp, true, nil)
 	if err != nil || val == nil {
 		return 0, err
 	}
@@ -85,7 +90,8 @@ func MergeStat(engine Engine, raftID int64, storeID int32, stat proto.Key, statV
 	if statVal == 0 {
 		return nil
 	}
-	value := proto.Value{Integer: gogoproto.Int64(statVal)}
+	value := proto.Value{}
+	value.SetInteger(statVal)
 	if raftID != 0 {
 		if err := MVCCMerge(engine, nil, MakeRangeStatKey(raftID, stat), value); err != nil {
 			return err
@@ -103,7 +109,8 @@ func MergeStat(engine Engine, raftID int64, storeID int32, stat proto.Key, statV
 // instance for both the affected range and store. Only updates range
 // or store stats if the corresponding ID is non-zero.
 func SetStat(engine Engine, raftID int64, storeID int32, stat proto.Key, statVal int64) error {
-	value := proto.Value{Integer: gogoproto.Int64(statVal)}
+	value := proto.Value{}
+	value.SetInteger(statVal)
 	if raftID != 0 {
 		if err := MVCCPut(engine, nil, MakeRangeStatKey(raftID, stat), proto.ZeroTimestamp, value, nil); err != nil {
 			return err
@@ -0,0 +1,110 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+const (
+	// maxGCProtectionDuration caps how far past the present a request
+	// may protect its read timestamp. Scans which take longer renew
+	// the protection with each request.
+	maxGCProtectionDuration = 10 * time.Minute
+
+	// maxGCProtections caps the number of protections a range keeps.
+	// Beyond this, they're coalesced into a single protection at the
+	// earliest protected timestamp.
+	maxGCProtections = 64
+)
+
+// gcProtection records a read timestamp whose visible versions must
+// be retained by garbage collection until the expiration wall time.
+type gcProtection struct {
+	timestamp  proto.Timestamp
+	expiration int64 // Wall time in nanoseconds
+}
+
+// gcProtections is the set of read timestamps a range has been asked
+// to protect from garbage collection. Long-running scans declare the
+// timestamp they read at via RequestHeader.ProtectUntil so that the
+// range doesn't GC versions they still need, even if those versions
+// are older than the zone's GC TTL.
+//
+// Any user may protect its reads: a protection only retains versions
+// visible to reads the user is permitted to make, and is bounded in
+// time by maxGCProtectionDuration and in number by maxGCProtections.
+type gcProtections []gcProtection
+
+// add protects timestamp ts until the expiration wall time, which is
+// capped at maxGCProtectionDuration past now. If ts is already
+// protected, its expiration is extended as necessary. If the number of
+// protections exceeds maxGCProtections, they're coalesced into one.
+func (p *gcProtections) add(ts proto.Timestamp, expiration, now int64) {
+	if maxExpiration := now + maxGCProtectionDuration.Nanoseconds(); expiration > maxExpiration {
+		expiration = maxExpiration
+	}
+	for i := range *p {
+		if (*p)[i].timestamp.Equal(ts) {
+			if (*p)[i].expiration < expiration {
+				(*p)[i].expiration = expiration
+			}
+			return
+		}
+	}
+	*p = append(*p, gcProtection{timestamp: ts, expiration: expiration})
+	if len(*p) > maxGCProtections {
+		p.coalesce()
+	}
+}
+
+// coalesce replaces all protections with a single one protecting the
+// earliest timestamp until the latest expiration. Garbage collection
+// honors only the earliest protected timestamp (see min), so this
+// changes no more than how long it's protected.
+func (p *gcProtections) coalesce() {
+	merged := (*p)[0]
+	for _, prot := range (*p)[1:] {
+		if prot.timestamp.Less(merged.timestamp) {
+			merged.timestamp = prot.timestamp
+		}
+		if prot.expiration > merged.expiration {
+			merged.expiration = prot.expiration
+		}
+	}
+	*p = append((*p)[:0], merged)
+}
+
+// min discards protections which have expired as of wall time now
+// and returns the earliest remaining protected timestamp, or
+// proto.ZeroTimestamp if there is none.
+func (p *gcProtections) min(now int64) proto.Timestamp {
+	minTS := proto.ZeroTimestamp
+	remaining := (*p)[:0]
+	for _, prot := range *p {
+		if prot.expiration <= now {
+			continue
+		}
+		remaining = append(remaining, prot)
+		if minTS.Equal(proto.ZeroTimestamp) || prot.timestamp.Less(minTS) {
+			minTS = prot.timestamp
+		}
+	}
+	*p = remaining
+	return minTS
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestGCProtections verifies that the earliest unexpired protected
+// timestamp is returned and expired protections are discarded.
+func TestGCProtections(t *testing.T) {
+	ts := func(wallTime int64) proto.Timestamp { return proto.Timestamp{WallTime: wallTime} }
+
+	var p gcProtections
+	if minTS := p.min(0); !minTS.Equal(proto.ZeroTimestamp) {
+		t.Errorf("expected no protected timestamp; got %s", minTS)
+	}
+	p.add(ts(5), 10, 0)
+	p.add(ts(3), 20, 0)
+	p.add(ts(7), 30, 0)
+	if minTS := p.min(0); !minTS.Equal(ts(3)) {
+		t.Errorf("expected %s; got %s", ts(3), minTS)
+	}
+	// Re-protecting a timestamp extends, but never shortens, its expiration.
+	p.add(ts(5), 25, 0)
+	p.add(ts(5), 15, 0)
+	if minTS := p.min(20); !minTS.Equal(ts(5)) {
+		t.Errorf("expected %s; got %s", ts(5), minTS)
+	}
+	if len(p) != 2 {
+		t.Errorf("expected expired protection to be discarded; got %+v", p)
+	}
+	if minTS := p.min(30); !minTS.Equal(proto.ZeroTimestamp) {
+		t.Errorf("expected no protected timestamp; got %s", minTS)
+	}
+	if len(p) != 0 {
+		t.Errorf("expected all protections to be discarded; got %+v", p)
+	}
+}
+
+// TestGCProtectionsCapped verifies that expirations are capped at
+// maxGCProtectionDuration past the present and that protections are
+// coalesced at the earliest timestamp once there are too many.
+func TestGCProtectionsCapped(t *testing.T) {
+	ts := func(wallTime int64) proto.Timestamp { return proto.Timestamp{WallTime: wallTime} }
+	maxNanos := maxGCProtectionDuration.Nanoseconds()
+
+	var p gcProtections
+	p.add(ts(5), math.MaxInt64, 100)
+	if p[0].expiration != 100+maxNanos {
+		t.Errorf("expected expiration capped at %d; got %d", 100+maxNanos, p[0].expiration)
+	}
+
+	for i := 0; i < maxGCProtections; i++ {
+		p.add(ts(int64(10+i)), int64(200+i), 100)
+	}
+	if len(p) != 1 {
+		t.Fatalf("expected protections to be coalesced; got %d", len(p))
+	}
+	if !p[0].timestamp.Equal(ts(5)) || p[0].expiration != 100+maxNanos {
+		t.Errorf("expected coalesced protection of %s until %d; got %+v", ts(5), 100+maxNanos, p[0])
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"flag"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/hlc"
+	"github.com/cockroachdb/cockroach/util/log"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+const (
+	// gcQueueMaxSize is the max size of the gc queue.
+	gcQueueMaxSize = 100
+
+	// gcByteCountNormalization is the count of GC'able bytes which
+	// amount to a priority of 1. Ranges expected to free fewer bytes
+	// aren't queued.
+	gcByteCountNormalization = 1 << 20 // 1 MB
+
+	// gcKeyBatchSize is the maximum number of versions removed by a
+	// single InternalGC command, which bounds the size of each Raft
+	// command and how long it holds up other commands on the range.
+	gcKeyBatchSize = 1000
+)
+
+var gcScanRate = flag.Int64("gc_scan_rate", 8<<20, "specify "+
+	"--gc_scan_rate to set the maximum number of bytes per second read "+
+	"by the GC queue while scanning a range for expired versions. Specify "+
+	"0 for no limit.")
+
+// gcQueue removes MVCC versions which have expired under the GC
+// policy of the range's zone. Ranges are prioritized by the number of
+// bytes their GC metadata estimates are reclaimable. The leader scans
+// the range, paced to --gc_scan_rate, and proposes the expired
+// versions for removal via InternalGC commands.
+type gcQueue struct {
+	*baseQueue
+	clock    *hlc.Clock
+	policyFn func(*Range) (*proto.GCPolicy, error) // Returns the range's GC policy
+}
+
+// newGCQueue returns a new instance of gcQueue.
+func newGCQueue(clock *hlc.Clock) *gcQueue {
+	gcq := &gcQueue{
+		clock:    clock,
+		policyFn: zoneGCPolicy,
+	}
+	gcq.baseQueue = newBaseQueue("gc", gcq.shouldQueue, gcq.process, gcQueueMaxSize)
+	return gcq
+}
+
+// zoneGCPolicy returns the GC policy of the zone containing the range,
+// as gossiped.
+func zoneGCPolicy(rng *Range) (*proto.GCPolicy, error) {
+	zone, err := rng.zoneConfig()
+	if err != nil {
+		return nil, err
+	}
+	return zone.GC, nil
+}
+
+// shouldQueue determines whether the range is worth garbage
+// collecting. Only the leader collects garbage. Priority is the
+// number of bytes the range's GC metadata estimates can be freed, in
+// units of gcByteCountNormalization.
+func (gcq *gcQueue) shouldQueue(rng *Range) (bool, float64) {
+	if !rng.IsLeader() {
+		return false, 0
+	}
+	policy, err := gcq.policyFn(rng)
+	if err != nil {
+		log.V(1).Infof("range %d: unable to determine GC policy: %s", rng.Desc.RaftID, err)
+		return false, 0
+	}
+	if policy == nil || policy.TTLSeconds <= 0 {
+		return false, 0
+	}
+	gcMeta, err := rng.GetGCMetadata()
+	if err != nil {
+		log.Warningf("range %d: unable to read GC metadata: %s", rng.Desc.RaftID, err)
+		return false, 0
+	}
+	// A range which has never been collected is treated as though it
+	// were collected at the epoch, with the zone's current TTL.
+	if gcMeta.LastGCNanos == 0 {
+		gcMeta.TTLSeconds = policy.TTLSeconds
+	}
+	ms, err := engine.MVCCGetRangeStats(rng.rm.Engine(), rng.Desc.RaftID)
+	if err != nil {
+		log.Warningf("range %d: unable to read stats: %s", rng.Desc.RaftID, err)
+		return false, 0
+	}
+	now := time.Unix(0, gcq.clock.PhysicalNow())
+	priority := float64(gcMeta.EstimatedBytes(now, ms.GCBytes())) / gcByteCountNormalization
+	// Versions deleted by range tombstones aren't counted as GC bytes
+	// until collected, so a range holding tombstones is queued once a
+	// full TTL has passed since its last collection.
+	ttl := time.Duration(policy.TTLSeconds) * time.Second
+	if priority < 1 && ms.RangeTombstoneCount > 0 && now.Sub(time.Unix(0, gcMeta.LastGCNanos)) >= ttl {
+		priority = 1
+	}
+	return priority >= 1, priority
+}
+
+// process scans the range's data for versions which have expired
+// under its zone's GC policy and proposes their removal in
+// InternalGC commands of at most gcKeyBatchSize versions each. The
+// last command also removes expired range tombstones, along with the
+// versions they delete, and records the range's new GC metadata. Keys
+// with write intents are left alone until the intent is resolved.
+func (gcq *gcQueue) process(rng *Range) error {
+	policy, err := gcq.policyFn(rng)
+	if err != nil {
+		return err
+	}
+	if policy == nil || policy.TTLSeconds <= 0 {
+		return nil
+	}
+	gc := rng.newGarbageCollector(func(proto.Key) *proto.GCPolicy { return policy })
+	now := gcq.clock.Now()
+	ttlNanos := int64(policy.TTLSeconds) * 1E9
+
+	gcMeta := proto.NewGCMetadata()
+	gcMeta.LastGCNanos = now.WallTime
+	gcMeta.TTLSeconds = policy.TTLSeconds
+
+	var gcKeys []proto.InternalGCRequest_GCKey
+	var keys []proto.EncodedKey
+	var vals [][]byte
+
+	// processKey decides the fate of the versions of a single key,
+	// gathered in keys and vals starting with the key's metadata.
+	processKey := func() {
+		if len(keys) < 2 {
+			return
+		}
+		meta := &proto.MVCCMetadata{}
+		if err := gogoproto.Unmarshal(vals[0], meta); err != nil {
+			log.Warningf("range %d: unable to unmarshal MVCC metadata %q: %s", rng.Desc.RaftID, keys[0], err)
+			return
+		}
+		if meta.Txn != nil {
+			return
+		}
+		toDelete := gc.Filter(keys, vals)
+		if toDelete != nil && toDelete[0] {
+			// Removing the newest version removes the key entirely.
+			key, ts, _ := engine.MVCCDecodeKey(keys[1])
+			gcKeys = append(gcKeys, proto.InternalGCRequest_GCKey{Key: key, Timestamp: ts})
+			return
+		}
+		for i, encKey := range keys[1:] {
+			key, ts, _ := engine.MVCCDecodeKey(encKey)
+			if toDelete != nil && toDelete[i+1] {
+				gcKeys = append(gcKeys, proto.InternalGCRequest_GCKey{Key: key, Timestamp: ts})
+				continue
+			}
+			if i == 0 && !meta.Deleted {
+				continue // the live version
+			}
+			// Account for the surviving non-live bytes by age: entry j
+			// of ByteCounts counts bytes aged at least j tenths of the
+			// TTL, which is how GCMetadata.EstimatedBytes reads it.
+			ttlFraction := float64(now.WallTime-ts.WallTime) / float64(ttlNanos)
+			bytes := int64(len(encKey) + len(vals[i+1]))
+			for j := 0; j < len(gcMeta.ByteCounts) && float64(j) <= ttlFraction*10; j++ {
+				gcMeta.ByteCounts[j] += bytes
+			}
+		}
+	}
+
+	// Scan the range's data, excluding range-local keys, with reads
+	// paced to --gc_scan_rate. Removals are proposed only once the
+	// scan is done, as the engine may not accept writes mid-iteration.
+	start := rng.Desc.StartKey
+	if start.Less(engine.KeyLocalMax) {
+		start = engine.KeyLocalMax
+	}
+	limiter := newByteRateLimiter(*gcScanRate, gcq.clock, rng.closer)
+	if err := rng.rm.Engine().Iterate(engine.MVCCEncodeKey(start), engine.MVCCEncodeKey(rng.Desc.EndKey),
+		func(kv proto.RawKeyValue) (bool, error) {
+			if _, _, isValue := engine.MVCCDecodeKey(kv.Key); !isValue {
+				processKey()
+				keys, vals = keys[:0], vals[:0]
+			}
+			keys = append(keys, kv.Key)
+			vals = append(vals, kv.Value)
+			return false, limiter.wait(len(kv.Key) + len(kv.Value))
+		}); err != nil {
+		return err
+	}
+	processKey()
+
+	header := proto.RequestHeader{
+		Key:     rng.Desc.StartKey,
+		User:    UserRoot,
+		RaftID:  rng.Desc.RaftID,
+		Replica: *rng.GetReplica(),
+	}
+	for {
+		args := &proto.InternalGCRequest{RequestHeader: header}
+		args.Timestamp = gcq.clock.Now()
+		if len(gcKeys) > gcKeyBatchSize {
+			args.Keys, gcKeys = gcKeys[:gcKeyBatchSize], gcKeys[gcKeyBatchSize:]
+		} else {
+			args.Keys, args.GCMeta = gcKeys, gcMeta
+			args.RangeTombstoneExpiration = proto.Timestamp{WallTime: now.WallTime - ttlNanos}
+		}
+		if err := rng.AddCmd(proto.InternalGC, args, &proto.InternalGCResponse{}, true); err != nil {
+			return err
+		}
+		if args.GCMeta != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestGCQueue verifies that a range holding expired versions is
+// queued and that processing it removes the expired versions, keeps
+// the live ones, updates the range's stats and records its GC
+// metadata.
+func TestGCQueue(t *testing.T) {
+	s, rng, manual, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	// Write three versions of "a" and a deleted "b", one second apart.
+	for i, key := range []proto.Key{proto.Key("a"), proto.Key("a"), proto.Key("b"), proto.Key("a")} {
+		manual.Set(int64(i+1) * int64(time.Second))
+		pArgs, pReply := putArgs(key, []byte("value"), 1, s.StoreID())
+		pArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manual.Set(int64(5 * time.Second))
+	dArgs, dReply := deleteArgs(proto.Key("b"), 1, s.StoreID())
+	dArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Delete, dArgs, dReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	manual.Set(int64(10 * time.Second))
+	gcq := newGCQueue(clock)
+	gcq.policyFn = func(*Range) (*proto.GCPolicy, error) {
+		return &proto.GCPolicy{TTLSeconds: 1}, nil
+	}
+	if _, priority := gcq.shouldQueue(rng); priority <= 0 {
+		t.Errorf("expected positive priority for range with expired versions; got %f", priority)
+	}
+	if err := gcq.process(rng); err != nil {
+		t.Fatal(err)
+	}
+
+	kvs, err := engine.Scan(s.Engine(), engine.MVCCEncodeKey(proto.Key("a")), engine.MVCCEncodeKey(proto.Key("c")), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expKeys := []proto.EncodedKey{
+		engine.MVCCEncodeKey(proto.Key("a")),
+		engine.MVCCEncodeVersionKey(proto.Key("a"), proto.Timestamp{WallTime: int64(4 * time.Second)}),
+	}
+	if len(kvs) != len(expKeys) {
+		t.Fatalf("expected %d keys after GC; got %d: %v", len(expKeys), len(kvs), kvs)
+	}
+	for i, kv := range kvs {
+		if !kv.Key.Equal(expKeys[i]) {
+			t.Errorf("%d: expected key %q; got %q", i, expKeys[i], kv.Key)
+		}
+	}
+
+	ms, err := engine.MVCCGetRangeStats(s.Engine(), rng.Desc.RaftID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.KeyCount != 1 || ms.ValCount != 1 || ms.LiveCount != 1 {
+		t.Errorf("expected one key and one version after GC; got %+v", ms)
+	}
+
+	gcMeta, err := rng.GetGCMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gcMeta.LastGCNanos != int64(10*time.Second) || gcMeta.TTLSeconds != 1 {
+		t.Errorf("unexpected GC metadata: %+v", gcMeta)
+	}
+	if should, priority := gcq.shouldQueue(rng); should || priority != 0 {
+		t.Errorf("expected collected range not to be queued; got %t, %f", should, priority)
+	}
+}
+
+// TestGCQueueRangeTombstones verifies that a range holding a range
+// tombstone is queued once the tombstone has expired, and that
+// processing the range removes the tombstone and the versions it
+// deletes.
+func TestGCQueueRangeTombstones(t *testing.T) {
+	s, rng, manual, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	manual.Set(int64(time.Second))
+	for _, key := range []proto.Key{proto.Key("a"), proto.Key("b")} {
+		pArgs, pReply := putArgs(key, []byte("value"), 1, s.StoreID())
+		pArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manual.Set(int64(2 * time.Second))
+	drArgs := proto.DeleteRangeArgs(proto.Key("a"), proto.Key("c"))
+	drArgs.RaftID = 1
+	drArgs.Replica = proto.Replica{StoreID: s.StoreID()}
+	drArgs.UseRangeTombstone = true
+	drArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.DeleteRange, drArgs, &proto.DeleteRangeResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if count := atomic.LoadInt64(&rng.rtCount); count != 1 {
+		t.Fatalf("expected range to count 1 tombstone; got %d", count)
+	}
+
+	gcq := newGCQueue(clock)
+	gcq.policyFn = func(*Range) (*proto.GCPolicy, error) {
+		return &proto.GCPolicy{TTLSeconds: 5}, nil
+	}
+	manual.Set(int64(10 * time.Second))
+	if should, _ := gcq.shouldQueue(rng); !should {
+		t.Fatal("expected range holding a range tombstone to be queued")
+	}
+	if err := gcq.process(rng); err != nil {
+		t.Fatal(err)
+	}
+
+	kvs, err := engine.Scan(s.Engine(), engine.MVCCEncodeKey(proto.Key("a")), engine.MVCCEncodeKey(proto.Key("c")), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 0 {
+		t.Errorf("expected deleted keys to be removed; got %v", kvs)
+	}
+	ms, err := engine.MVCCGetRangeStats(s.Engine(), rng.Desc.RaftID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.RangeTombstoneCount != 0 || ms.KeyCount != 0 {
+		t.Errorf("expected no keys or tombstones after GC; got %+v", ms)
+	}
+	if count := atomic.LoadInt64(&rng.rtCount); count != 0 {
+		t.Errorf("expected range to count no tombstones; got %d", count)
+	}
+}
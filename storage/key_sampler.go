@@ -0,0 +1,142 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+const (
+	// keySampleSize is the number of keys held in each reservoir of a
+	// range's key sampler.
+	keySampleSize = 128
+	// keySampleInterval is the minimum duration over which keys are
+	// sampled into a reservoir before a new one is started, so that
+	// hot keys reflect recent traffic.
+	keySampleInterval = time.Minute
+)
+
+// A KeySample is a key together with its estimated share of the
+// requests a range received.
+type KeySample struct {
+	Key      proto.Key
+	Fraction float64 // Estimated fraction of requests addressing Key
+}
+
+// A keyReservoir holds a uniform random sample of the keys recorded
+// since it was started.
+type keyReservoir struct {
+	nanos int64       // Time at which sampling into the reservoir began
+	count int64       // Number of keys recorded into the reservoir
+	keys  []proto.Key // Up to keySampleSize sampled keys
+}
+
+// A keySampler samples the keys addressed by requests to a range
+// using reservoir sampling, which bounds its memory regardless of the
+// request rate. The estimate covers the current and preceding
+// reservoirs; a new reservoir is started whenever a key is recorded or
+// the top keys are requested at least keySampleInterval after the
+// current one began, so under steady traffic the window spans between
+// one and two multiples of keySampleInterval.
+type keySampler struct {
+	sync.Mutex
+	rand    *rand.Rand
+	prev    keyReservoir // Reservoir preceding cur
+	cur     keyReservoir // Reservoir into which keys are recorded
+	maxSize int
+}
+
+// newKeySampler returns a keySampler which starts sampling at the
+// specified time in nanoseconds.
+func newKeySampler(nowNanos int64) *keySampler {
+	return &keySampler{
+		rand:    util.NewPseudoRand(),
+		cur:     keyReservoir{nanos: nowNanos},
+		maxSize: keySampleSize,
+	}
+}
+
+// record samples key, addressed by a request at the specified time.
+func (ks *keySampler) record(key proto.Key, nowNanos int64) {
+	ks.Lock()
+	defer ks.Unlock()
+	ks.maybeRotateLocked(nowNanos)
+	ks.cur.count++
+	if len(ks.cur.keys) < ks.maxSize {
+		ks.cur.keys = append(ks.cur.keys, key)
+	} else if i := ks.rand.Int63n(ks.cur.count); i < int64(ks.maxSize) {
+		ks.cur.keys[i] = key
+	}
+}
+
+// maybeRotateLocked starts a new reservoir if the current one is at
+// least keySampleInterval old.
+func (ks *keySampler) maybeRotateLocked(nowNanos int64) {
+	if nowNanos-ks.cur.nanos >= keySampleInterval.Nanoseconds() {
+		ks.prev = ks.cur
+		ks.cur = keyReservoir{nanos: nowNanos}
+	}
+}
+
+// top returns up to k of the most frequently sampled keys, ordered by
+// descending fraction. Each sampled key stands in for count/len(keys)
+// requests of its reservoir.
+func (ks *keySampler) top(k int, nowNanos int64) []KeySample {
+	ks.Lock()
+	ks.maybeRotateLocked(nowNanos)
+	weights := map[string]float64{}
+	var total float64
+	for _, r := range []*keyReservoir{&ks.prev, &ks.cur} {
+		if len(r.keys) == 0 {
+			continue
+		}
+		weight := float64(r.count) / float64(len(r.keys))
+		for _, key := range r.keys {
+			weights[string(key)] += weight
+		}
+		total += float64(r.count)
+	}
+	ks.Unlock()
+
+	samples := make(keySamples, 0, len(weights))
+	for key, weight := range weights {
+		samples = append(samples, KeySample{Key: proto.Key(key), Fraction: weight / total})
+	}
+	sort.Sort(samples)
+	if len(samples) > k {
+		samples = samples[:k]
+	}
+	return samples
+}
+
+// keySamples implements sort.Interface, ordering samples by descending
+// fraction and then by key.
+type keySamples []KeySample
+
+func (s keySamples) Len() int      { return len(s) }
+func (s keySamples) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s keySamples) Less(i, j int) bool {
+	if s[i].Fraction != s[j].Fraction {
+		return s[i].Fraction > s[j].Fraction
+	}
+	return s[i].Key.Less(s[j].Key)
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// TestKeySampler verifies that the key sampler estimates the share of
+// requests addressing each key and that old samples age out.
+func TestKeySampler(t *testing.T) {
+	ks := newKeySampler(0)
+	// Record 1000 requests: half to "a", a quarter to "b" and the rest
+	// spread over distinct keys.
+	for i := 0; i < 1000; i++ {
+		var key proto.Key
+		switch {
+		case i%2 == 0:
+			key = proto.Key("a")
+		case i%4 == 1:
+			key = proto.Key("b")
+		default:
+			key = proto.Key(fmt.Sprintf("c%d", i))
+		}
+		ks.record(key, 1)
+	}
+	if l := len(ks.cur.keys); l != keySampleSize {
+		t.Fatalf("expected %d sampled keys; got %d", keySampleSize, l)
+	}
+	top := ks.top(2, 2)
+	if len(top) != 2 {
+		t.Fatalf("expected two keys; got %+v", top)
+	}
+	// The sample is random, so allow a generous margin.
+	for i, exp := range []struct {
+		key      string
+		fraction float64
+	}{{"a", 0.5}, {"b", 0.25}} {
+		if !top[i].Key.Equal(proto.Key(exp.key)) || math.Abs(top[i].Fraction-exp.fraction) > 0.15 {
+			t.Errorf("expected key %q with fraction ~%.2f; got %+v", exp.key, exp.fraction, top[i])
+		}
+	}
+
+	// After one interval, the samples are still reported; after two,
+	// they've aged out.
+	interval := keySampleInterval.Nanoseconds()
+	if top := ks.top(1, interval); len(top) != 1 || !top[0].Key.Equal(proto.Key("a")) {
+		t.Errorf("expected key \"a\" to remain hottest after one interval; got %+v", top)
+	}
+	if top := ks.top(1, 2*interval); len(top) != 0 {
+		t.Errorf("expected no keys after two intervals; got %+v", top)
+	}
+}
@@ -17,7 +17,21 @@
 
 package storage
 
-import "container/heap"
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metrics"
+)
+
+// purgatoryInterval is the interval at which a started queue re-adds
+// the ranges in its purgatory, in case whatever kept them from being
+// processed has since changed.
+var purgatoryInterval = 1 * time.Minute
 
 // A rangeItem holds a range and its priority for use with a priority queue.
 type rangeItem struct {
@@ -68,54 +82,198 @@ func (pq *priorityQueue) update(item *rangeItem, priority float64) {
 // queued and if so, at what priority.
 type shouldQueueFn func(*Range) (shouldQueue bool, priority float64)
 
+// processQueueFn accepts a Range and carries out the queue-specific
+// work on it (e.g. garbage collection, splitting or replication).
+type processQueueFn func(*Range) error
+
+// A purgatoryError indicates that a range could not be processed
+// for reasons which are expected to persist until some external
+// condition changes (e.g. there aren't enough stores to which to
+// replicate). Ranges which fail with a purgatoryError are moved to
+// the queue's purgatory instead of being retried at the next scan.
+type purgatoryError interface {
+	error
+	purgatoryErrorMarker() // dummy method for unique interface
+}
+
+// queueStats holds counters describing the activity of a baseQueue.
+type queueStats struct {
+	Processed int64 // Ranges processed successfully
+	Failed    int64 // Ranges which failed processing
+	Pending   int64 // Ranges currently queued
+	Purgatory int64 // Ranges currently in purgatory
+	Dropped   int64 // Ranges dropped because the queue was full
+}
+
 // baseQueue is the base implementation of the rangeQueue interface.
-// Queue implementations should embed a baseQueue and provide it
-// with shouldQueueFn.
+// Queue implementations (e.g. the GC, split and consistency queues)
+// should embed a baseQueue and provide it with a shouldQueueFn and a
+// processQueueFn.
 //
-// baseQueue is not thread safe.
+// baseQueue is thread safe. Once started, ranges are processed one at
+// a time by its own goroutine, concurrently with the range scanner
+// adding them. The queue's lock isn't held while a range is
+// processed, so the scanner isn't blocked for the duration.
 type baseQueue struct {
-	shouldQ   shouldQueueFn        // Should a range be queued?
-	maxSize   int                  // Maximum number of ranges to queue
+	name    string         // Queue name, for logging
+	shouldQ shouldQueueFn  // Should a range be queued?
+	process processQueueFn // Process a range
+	maxSize int            // Maximum number of ranges to queue
+	signal  chan struct{}  // Wakes the processing goroutine
+
+	mu        sync.Mutex           // Protects priorityQ, ranges and purgatory
 	priorityQ priorityQueue        // The priority queue
 	ranges    map[int64]*rangeItem // Map from RaftID to rangeItem (for updating priority)
+	purgatory map[int64]*Range     // Ranges which failed with a purgatoryError
+
+	processed, failed, pending, inPurgatory, dropped int64 // Accessed atomically
+
+	// metricPrefix is set while gauges are registered.
+	metricPrefix string
 }
 
 // newBaseQueue returns a new instance of baseQueue with the
-// specified shouldQ function to determine which ranges to queue
-// and maxSize to limit the growth of the queue. Note that
-// maxSize doesn't prevent new ranges from being added, it just
-// limits the total size. Higher priority ranges can still be
-// added; their addition simply removes the lowest priority range.
-func newBaseQueue(shouldQ shouldQueueFn, maxSize int) *baseQueue {
+// specified shouldQ function to determine which ranges to queue,
+// process function to process queued ranges and maxSize to limit
+// the growth of the queue. Note that maxSize doesn't prevent new
+// ranges from being added, it just limits the total size. Higher
+// priority ranges can still be added; their addition simply removes
+// the lowest priority range.
+func newBaseQueue(name string, shouldQ shouldQueueFn, process processQueueFn, maxSize int) *baseQueue {
 	return &baseQueue{
-		shouldQ: shouldQ,
-		maxSize: maxSize,
-		ranges:  map[int64]*rangeItem{},
+		name:      name,
+		shouldQ:   shouldQ,
+		process:   process,
+		maxSize:   maxSize,
+		signal:    make(chan struct{}, 1),
+		ranges:    map[int64]*rangeItem{},
+		purgatory: map[int64]*Range{},
+	}
+}
+
+// start registers gauges for the queue's stats and launches a
+// goroutine which processes queued ranges one at a time until closer
+// is closed. Every purgatoryInterval, the goroutine moves the ranges
+// in purgatory back into the queue.
+func (bq *baseQueue) start(storeID int32, closer chan struct{}) {
+	bq.registerGauges(storeID)
+	go func() {
+		ticker := time.NewTicker(purgatoryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bq.signal:
+				for bq.processOne() {
+					select {
+					case <-closer:
+						return
+					default:
+					}
+				}
+			case <-ticker.C:
+				bq.processPurgatory()
+			case <-closer:
+				return
+			}
+		}
+	}()
+}
+
+// queueGauges lists the names under which a queue's stats are
+// registered, along with the counter each one reports.
+var queueGauges = []struct {
+	name  string
+	value func(queueStats) int64
+}{
+	{"processed", func(s queueStats) int64 { return s.Processed }},
+	{"failed", func(s queueStats) int64 { return s.Failed }},
+	{"pending", func(s queueStats) int64 { return s.Pending }},
+	{"purgatory", func(s queueStats) int64 { return s.Purgatory }},
+	{"dropped", func(s queueStats) int64 { return s.Dropped }},
+}
+
+// registerGauges registers a gauge for each of the queue's stats,
+// named storage.store.<storeID>.queue.<name>.<stat>.
+func (bq *baseQueue) registerGauges(storeID int32) {
+	bq.metricPrefix = fmt.Sprintf("storage.store.%d.queue.%s.", storeID, bq.name)
+	for _, g := range queueGauges {
+		value := g.value
+		metrics.Metrics.RegisterGaugeFunc(bq.metricPrefix+g.name, func() float64 {
+			return float64(value(bq.stats()))
+		})
+	}
+}
+
+// stop deregisters the gauges registered by start. The processing
+// goroutine exits when the closer passed to start is closed.
+func (bq *baseQueue) stop() {
+	if bq.metricPrefix == "" {
+		return
+	}
+	for _, g := range queueGauges {
+		metrics.Metrics.DeregisterGaugeFunc(bq.metricPrefix + g.name)
 	}
+	bq.metricPrefix = ""
 }
 
 // length returns the current size of the queue.
 func (bq *baseQueue) length() int {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
 	return bq.priorityQ.Len()
 }
 
+// stats returns a snapshot of the queue's counters.
+func (bq *baseQueue) stats() queueStats {
+	return queueStats{
+		Processed: atomic.LoadInt64(&bq.processed),
+		Failed:    atomic.LoadInt64(&bq.failed),
+		Pending:   atomic.LoadInt64(&bq.pending),
+		Purgatory: atomic.LoadInt64(&bq.inPurgatory),
+		Dropped:   atomic.LoadInt64(&bq.dropped),
+	}
+}
+
 // next dequeues and returns the highest priority range. If the queue
 // is empty, returns nil.
 func (bq *baseQueue) next() *Range {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
 	if bq.priorityQ.Len() == 0 {
 		return nil
 	}
 	item := heap.Pop(&bq.priorityQ).(*rangeItem)
 	delete(bq.ranges, item.value.Desc.RaftID)
+	bq.updateGauges()
 	return item.value
 }
 
 // maybeAdd adds the specified range if bq.shouldQ specifies it should
 // be queued. Ranges are added to the queue using the priority
 // returned by bq.shouldQ. If the queue is too full, an already-queued
-// range with the lowest priority may be dropped.
+// range with the lowest priority may be dropped. Ranges in purgatory
+// are not added; see processPurgatory. The processing goroutine is
+// woken if the queue is non-empty.
 func (bq *baseQueue) maybeAdd(rng *Range) {
 	should, priority := bq.shouldQ(rng)
+	bq.mu.Lock()
+	bq.addLocked(rng, should, priority)
+	pending := bq.priorityQ.Len() > 0
+	bq.mu.Unlock()
+	if pending {
+		select {
+		case bq.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// addLocked adds, updates or removes the range according to the
+// result of bq.shouldQ. The queue's lock must be held.
+func (bq *baseQueue) addLocked(rng *Range, should bool, priority float64) {
+	if _, ok := bq.purgatory[rng.Desc.RaftID]; ok {
+		return
+	}
 	item, ok := bq.ranges[rng.Desc.RaftID]
 	if !should {
 		if ok {
@@ -133,25 +291,102 @@ func (bq *baseQueue) maybeAdd(rng *Range) {
 
 	// If adding this range has pushed the queue past its maximum size,
 	// remove the lowest priority element.
-	if pqLen := bq.priorityQ.Len(); pqLen > bq.maxSize {
-		bq.internalRemove(pqLen - 1)
+	if bq.priorityQ.Len() > bq.maxSize {
+		bq.internalRemove(bq.lowestPriorityIndex())
+		atomic.AddInt64(&bq.dropped, 1)
 	}
+	bq.updateGauges()
 }
 
-// maybeRemove removes the specified range from the queue if enqueued.
+// maybeRemove removes the specified range from the queue or from
+// purgatory if present.
 func (bq *baseQueue) maybeRemove(rng *Range) {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
 	if item, ok := bq.ranges[rng.Desc.RaftID]; ok {
 		bq.internalRemove(item.index)
 	}
+	delete(bq.purgatory, rng.Desc.RaftID)
+	bq.updateGauges()
 }
 
-// clear removes all ranges from the queue.
+// processOne dequeues the highest priority range and processes it,
+// without holding the queue's lock. Ranges which fail with a
+// purgatoryError are placed in purgatory; other failures are logged
+// and the range is dropped until it's next added by the scanner.
+// Returns false if the queue was empty.
+func (bq *baseQueue) processOne() bool {
+	rng := bq.next()
+	if rng == nil {
+		return false
+	}
+	if err := bq.process(rng); err != nil {
+		atomic.AddInt64(&bq.failed, 1)
+		if _, ok := err.(purgatoryError); ok {
+			log.V(1).Infof("%s: range %d placed in purgatory: %s", bq.name, rng.Desc.RaftID, err)
+			bq.mu.Lock()
+			bq.purgatory[rng.Desc.RaftID] = rng
+			bq.updateGauges()
+			bq.mu.Unlock()
+		} else {
+			log.Errorf("%s: failure processing range %d: %s", bq.name, rng.Desc.RaftID, err)
+		}
+		return true
+	}
+	atomic.AddInt64(&bq.processed, 1)
+	return true
+}
+
+// processPurgatory removes all ranges from purgatory and re-adds
+// them to the queue, subject to bq.shouldQ. It's invoked periodically
+// by the processing goroutine, and may also be invoked directly when
+// the condition which caused ranges to enter purgatory may have
+// changed (e.g. a new store has been gossiped).
+func (bq *baseQueue) processPurgatory() {
+	bq.mu.Lock()
+	purgatory := bq.purgatory
+	bq.purgatory = map[int64]*Range{}
+	bq.updateGauges()
+	bq.mu.Unlock()
+	for _, rng := range purgatory {
+		bq.maybeAdd(rng)
+	}
+}
+
+// clear removes all ranges from the queue and from purgatory.
 func (bq *baseQueue) clear() {
+	bq.mu.Lock()
+	defer bq.mu.Unlock()
 	bq.ranges = map[int64]*rangeItem{}
 	bq.priorityQ = nil
+	bq.purgatory = map[int64]*Range{}
+	bq.updateGauges()
+}
+
+// lowestPriorityIndex returns the index of the lowest priority item
+// in the priority queue, which must be non-empty. The queue's lock
+// must be held. The lowest priority item is necessarily a leaf of the
+// heap, so only the second half of the slice is examined.
+func (bq *baseQueue) lowestPriorityIndex() int {
+	n := bq.priorityQ.Len()
+	lowest := n - 1
+	for i := n / 2; i < n; i++ {
+		if bq.priorityQ[i].priority < bq.priorityQ[lowest].priority {
+			lowest = i
+		}
+	}
+	return lowest
 }
 
 func (bq *baseQueue) internalRemove(index int) {
 	item := heap.Remove(&bq.priorityQ, index).(*rangeItem)
 	delete(bq.ranges, item.value.Desc.RaftID)
+	bq.updateGauges()
+}
+
+// updateGauges updates the pending and purgatory counters. The
+// queue's lock must be held.
+func (bq *baseQueue) updateGauges() {
+	atomic.StoreInt64(&bq.pending, int64(bq.priorityQ.Len()))
+	atomic.StoreInt64(&bq.inPurgatory, int64(len(bq.purgatory)))
 }
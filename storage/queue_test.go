@@ -19,9 +19,12 @@ package storage
 
 import (
 	"container/heap"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
 )
 
 // TestQueuePriorityQueue verifies priority queue implementation.
@@ -77,7 +80,7 @@ func TestBaseQueueAddUpdateAndRemove(t *testing.T) {
 	shouldQ := func(r *Range) (shouldQueue bool, priority float64) {
 		return shouldAddMap[r], priorityMap[r]
 	}
-	bq := newBaseQueue(shouldQ, 2)
+	bq := newBaseQueue("test", shouldQ, nil, 2)
 	bq.maybeAdd(r1)
 	bq.maybeAdd(r2)
 	if bq.length() != 2 {
@@ -137,3 +140,132 @@ func TestBaseQueueAddUpdateAndRemove(t *testing.T) {
 		t.Errorf("expected r1")
 	}
 }
+
+// TestBaseQueueMaxSize verifies that the lowest priority range is
+// dropped when the queue exceeds its maximum size.
+func TestBaseQueueMaxSize(t *testing.T) {
+	priorities := []float64{5, 4, 1, 3}
+	var ranges []*Range
+	priorityMap := map[*Range]float64{}
+	for i, p := range priorities {
+		r := &Range{Desc: &proto.RangeDescriptor{RaftID: int64(i + 1)}}
+		ranges = append(ranges, r)
+		priorityMap[r] = p
+	}
+	shouldQ := func(r *Range) (bool, float64) {
+		return true, priorityMap[r]
+	}
+	bq := newBaseQueue("test", shouldQ, nil, 3)
+	for _, r := range ranges {
+		bq.maybeAdd(r)
+	}
+	if bq.length() != 3 {
+		t.Fatalf("expected length 3; got %d", bq.length())
+	}
+	if stats := bq.stats(); stats.Dropped != 1 || stats.Pending != 3 {
+		t.Errorf("expected 1 dropped and 3 pending; got %+v", stats)
+	}
+	// The range with priority 1 should have been dropped.
+	for _, exp := range []*Range{ranges[0], ranges[1], ranges[3]} {
+		if r := bq.next(); r != exp {
+			t.Errorf("expected range %d; got %d", exp.Desc.RaftID, r.Desc.RaftID)
+		}
+	}
+}
+
+// testPurgatoryError is a purgatoryError for use in tests.
+type testPurgatoryError struct{}
+
+func (testPurgatoryError) Error() string         { return "test purgatory error" }
+func (testPurgatoryError) purgatoryErrorMarker() {}
+
+// TestBaseQueuePurgatory verifies that ranges which fail processing
+// with a purgatoryError are held in purgatory until
+// processPurgatory is invoked, and that processing is reflected in
+// the queue stats.
+func TestBaseQueuePurgatory(t *testing.T) {
+	r1 := &Range{Desc: &proto.RangeDescriptor{RaftID: 1}}
+	r2 := &Range{Desc: &proto.RangeDescriptor{RaftID: 2}}
+	r3 := &Range{Desc: &proto.RangeDescriptor{RaftID: 3}}
+	priorityMap := map[*Range]float64{r1: 3.0, r2: 2.0, r3: 1.0}
+	shouldQ := func(r *Range) (bool, float64) {
+		return true, priorityMap[r]
+	}
+	errMap := map[*Range]error{
+		r2: testPurgatoryError{},
+		r3: util.Errorf("test error"),
+	}
+	var processed []*Range
+	process := func(r *Range) error {
+		processed = append(processed, r)
+		return errMap[r]
+	}
+	bq := newBaseQueue("test", shouldQ, process, 10)
+	for _, r := range []*Range{r1, r2, r3} {
+		bq.maybeAdd(r)
+	}
+	for bq.processOne() {
+	}
+	if len(processed) != 3 {
+		t.Fatalf("expected 3 ranges processed; got %d", len(processed))
+	}
+	exp := queueStats{Processed: 1, Failed: 2, Pending: 0, Purgatory: 1}
+	if stats := bq.stats(); stats != exp {
+		t.Errorf("expected stats %+v; got %+v", exp, stats)
+	}
+
+	// Ranges in purgatory aren't re-added by the scanner.
+	bq.maybeAdd(r2)
+	if bq.length() != 0 {
+		t.Errorf("expected range in purgatory not to be queued; got length %d", bq.length())
+	}
+
+	// Processing purgatory re-queues the range; this time it succeeds.
+	delete(errMap, r2)
+	bq.processPurgatory()
+	if bq.length() != 1 {
+		t.Fatalf("expected length 1; got %d", bq.length())
+	}
+	if !bq.processOne() {
+		t.Fatal("expected a range to be processed")
+	}
+	exp = queueStats{Processed: 2, Failed: 2, Pending: 0, Purgatory: 0}
+	if stats := bq.stats(); stats != exp {
+		t.Errorf("expected stats %+v; got %+v", exp, stats)
+	}
+}
+
+// TestBaseQueuePurgatoryInterval verifies that a started queue
+// periodically retries the ranges in its purgatory.
+func TestBaseQueuePurgatoryInterval(t *testing.T) {
+	defer func(interval time.Duration) { purgatoryInterval = interval }(purgatoryInterval)
+	purgatoryInterval = 1 * time.Millisecond
+
+	r1 := &Range{Desc: &proto.RangeDescriptor{RaftID: 1}}
+	shouldQ := func(r *Range) (bool, float64) {
+		return true, 1.0
+	}
+	var attempts int32
+	process := func(r *Range) error {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			return testPurgatoryError{}
+		}
+		return nil
+	}
+	bq := newBaseQueue("test", shouldQ, process, 10)
+	closer := make(chan struct{})
+	defer close(closer)
+	bq.start(1, closer)
+	defer bq.stop()
+
+	bq.maybeAdd(r1)
+	if err := util.IsTrueWithin(func() bool {
+		return bq.stats().Processed == 1
+	}, 500*time.Millisecond); err != nil {
+		t.Fatalf("expected range to be retried from purgatory: %s", err)
+	}
+	exp := queueStats{Processed: 1, Failed: 1, Pending: 0, Purgatory: 0}
+	if stats := bq.stats(); stats != exp {
+		t.Errorf("expected stats %+v; got %+v", exp, stats)
+	}
+}
@@ -18,15 +18,37 @@
 package storage
 
 import (
-	"log"
+	"flag"
 	"sync"
 	"time"
 
 	"github.com/cockroachdb/cockroach/multiraft"
 	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
 	gogoproto "github.com/gogo/protobuf/proto"
 )
 
+var (
+	raftTickInterval = flag.Duration("raft_tick_interval", time.Millisecond, "specify "+
+		"--raft_tick_interval to set the duration of a raft tick, the unit in "+
+		"which election timeouts and heartbeat intervals are measured.")
+
+	raftElectionTimeoutTicks = flag.Int("raft_election_timeout_ticks", 5, "specify "+
+		"--raft_election_timeout_ticks to set the number of ticks without contact "+
+		"from the leader after which a follower calls an election. Clusters with "+
+		"high round trip latencies should raise this to avoid spurious elections.")
+
+	raftHeartbeatIntervalTicks = flag.Int("raft_heartbeat_interval_ticks", 1, "specify "+
+		"--raft_heartbeat_interval_ticks to set the number of ticks between "+
+		"heartbeats sent by a leader. Must not exceed the election timeout.")
+)
+
+// minElectionHeartbeatRatio is the smallest ratio of election timeout
+// to heartbeat interval which tolerates a lost heartbeat without
+// triggering an election. Smaller ratios are allowed but warned about.
+const minElectionHeartbeatRatio = 3
+
 type committedCommand struct {
 	cmdIDKey cmdIDKey
 	cmd      proto.InternalRaftCommand
@@ -55,16 +77,38 @@ type singleNodeRaft struct {
 	stopper  chan struct{}
 }
 
-func newSingleNodeRaft() *singleNodeRaft {
-	mr, err := multiraft.NewMultiRaft(1, &multiraft.Config{
+// newRaftConfig returns a multiraft config with the tick settings
+// specified by the --raft_* flags. It returns an error if the
+// settings are invalid and warns if the election timeout is so close
+// to the heartbeat interval that a single delayed heartbeat causes an
+// election.
+func newRaftConfig() (*multiraft.Config, error) {
+	config := &multiraft.Config{
 		Transport:              multiraft.NewLocalRPCTransport(),
 		Storage:                multiraft.NewMemoryStorage(),
-		TickInterval:           time.Millisecond,
-		ElectionTimeoutTicks:   5,
-		HeartbeatIntervalTicks: 1,
-	})
+		TickInterval:           *raftTickInterval,
+		ElectionTimeoutTicks:   *raftElectionTimeoutTicks,
+		HeartbeatIntervalTicks: *raftHeartbeatIntervalTicks,
+	}
+	if err := config.Validate(); err != nil {
+		return nil, util.Errorf("invalid raft settings: %s", err)
+	}
+	if config.ElectionTimeoutTicks < minElectionHeartbeatRatio*config.HeartbeatIntervalTicks {
+		log.Warningf("raft election timeout of %d ticks is less than %d heartbeat intervals of %d ticks; "+
+			"delayed heartbeats may cause spurious elections", config.ElectionTimeoutTicks,
+			minElectionHeartbeatRatio, config.HeartbeatIntervalTicks)
+	}
+	return config, nil
+}
+
+func newSingleNodeRaft() (*singleNodeRaft, error) {
+	config, err := newRaftConfig()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	mr, err := multiraft.NewMultiRaft(1, config)
+	if err != nil {
+		return nil, err
 	}
 	snr := &singleNodeRaft{
 		mr:       mr,
@@ -74,7 +118,7 @@ func newSingleNodeRaft() *singleNodeRaft {
 	}
 	mr.Start()
 	go snr.run()
-	return snr
+	return snr, nil
 }
 
 var _ raft = (*singleNodeRaft)(nil)
@@ -0,0 +1,53 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// TestNewRaftConfig verifies that the raft tick flags are applied and
+// that inconsistent settings are rejected.
+func TestNewRaftConfig(t *testing.T) {
+	defer func(interval time.Duration, election, heartbeat int) {
+		*raftTickInterval = interval
+		*raftElectionTimeoutTicks = election
+		*raftHeartbeatIntervalTicks = heartbeat
+	}(*raftTickInterval, *raftElectionTimeoutTicks, *raftHeartbeatIntervalTicks)
+
+	*raftTickInterval = 50 * time.Millisecond
+	*raftElectionTimeoutTicks = 20
+	*raftHeartbeatIntervalTicks = 2
+	config, err := newRaftConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TickInterval != 50*time.Millisecond || config.ElectionTimeoutTicks != 20 ||
+		config.HeartbeatIntervalTicks != 2 {
+		t.Errorf("unexpected raft config %+v", config)
+	}
+
+	*raftHeartbeatIntervalTicks = 21
+	if _, err := newRaftConfig(); err == nil {
+		t.Error("expected error with heartbeat interval exceeding election timeout")
+	}
+	*raftHeartbeatIntervalTicks = 2
+	*raftTickInterval = 0
+	if _, err := newRaftConfig(); err == nil {
+		t.Error("expected error with zero tick interval")
+	}
+}
@@ -42,6 +42,7 @@ import (
 // init pre-registers RangeDescriptor, PrefixConfigMap types and Transaction.
 func init() {
 	gob.Register(StoreDescriptor{})
+	gob.Register(StoreSummary{})
 	gob.Register(PrefixConfigMap{})
 	gob.Register(&proto.AcctConfig{})
 	gob.Register(&proto.PermConfig{})
@@ -65,6 +66,11 @@ var (
 	// continually re-gossipped. The replica which is the raft leader of
 	// the first range gossips it.
 	ttlClusterIDGossip = 30 * time.Second
+
+	// backpressureRangeSizeMultiplier is the multiple of a range's
+	// zone-configured max bytes beyond which writes to the range are
+	// rejected with a RangeTooLargeError until the range splits.
+	backpressureRangeSizeMultiplier = 2.0
 )
 
 // configDescriptor describes administrative configuration maps
@@ -90,8 +96,10 @@ var tsCacheMethods = map[string]struct{}{
 	proto.Get:                   struct{}{},
 	proto.Put:                   struct{}{},
 	proto.ConditionalPut:        struct{}{},
+	proto.CompareAndSwap:        struct{}{},
 	proto.Increment:             struct{}{},
 	proto.Scan:                  struct{}{},
+	proto.ReverseScan:           struct{}{},
 	proto.Delete:                struct{}{},
 	proto.DeleteRange:           struct{}{},
 	proto.ReapQueue:             struct{}{},
@@ -99,6 +107,22 @@ var tsCacheMethods = map[string]struct{}{
 	proto.EnqueueMessage:        struct{}{},
 	proto.InternalResolveIntent: struct{}{},
 	proto.InternalMerge:         struct{}{},
+	proto.Batch:                 struct{}{},
+}
+
+// backpressureMethods specifies the set of methods which are subject
+// to backpressure when a range has grown too large. Deletions and
+// transaction-related commands are exempt, as they either reduce the
+// size of the range or are required to make progress on commands
+// which have already been admitted.
+var backpressureMethods = map[string]struct{}{
+	proto.Put:            struct{}{},
+	proto.ConditionalPut: struct{}{},
+	proto.CompareAndSwap: struct{}{},
+	proto.Increment:      struct{}{},
+	proto.EnqueueUpdate:  struct{}{},
+	proto.EnqueueMessage: struct{}{},
+	proto.InternalMerge:  struct{}{},
 }
 
 // UsesTimestampCache returns true if the method affects or is
@@ -108,6 +132,25 @@ func UsesTimestampCache(method string) bool {
 	return ok
 }
 
+// rangeDescriptorChangedError is returned when an operation which
+// updates a range descriptor finds that the descriptor or its
+// addressing records were modified concurrently. The operation may be
+// retried against the new descriptor.
+type rangeDescriptorChangedError struct {
+	raftID int64
+	op     string
+}
+
+// Error implements the error interface.
+func (e *rangeDescriptorChangedError) Error() string {
+	return fmt.Sprintf("%s failed: descriptor of range %d changed concurrently", e.op, e.raftID)
+}
+
+// CanRetry implements the util.Retryable interface.
+func (e *rangeDescriptorChangedError) CanRetry() bool {
+	return true
+}
+
 // A pendingCmd holds method, args, reply and a done channel for a command
 // sent to Raft. Once committed to the Raft log, the command is
 // executed and the result returned via the done channel.
@@ -136,7 +179,21 @@ type RangeManager interface {
 	AddRange(rng *Range) error
 	RemoveRange(rng *Range) error
 	CreateSnapshot() (string, error)
+	GetSnapshot(snapshotID string) (engine.Engine, error)
+	ReleaseSnapshot(snapshotID string) error
 	ProposeRaftCommand(cmdIDKey, proto.InternalRaftCommand)
+	MarkReplicaCorrupt(rng *Range)
+}
+
+// qpsInterval is the minimum interval over which a range's command
+// rate is averaged when computing QPS.
+const qpsInterval = 10 * time.Second
+
+// A qpsSample records the number of commands a range had received at
+// a point in (physical) time.
+type qpsSample struct {
+	nanos int64
+	count int64
 }
 
 // A Range is a contiguous keyspace with writes managed via an
@@ -149,13 +206,24 @@ type Range struct {
 	Desc      *proto.RangeDescriptor
 	rm        RangeManager  // Makes some store methods available
 	splitting int32         // 1 if a split is underway; updated atomically
+	cmdCount  int64         // Commands received by this range; updated atomically
+	rtCount   int64         // Range tombstones held by this range; updated atomically
+	rtUnknown int32         // 1 if rtCount couldn't be read; updated atomically
 	closer    chan struct{} // Channel for closing the range
-
-	sync.RWMutex                 // Protects the following fields (and Desc)
-	cmdQ         *CommandQueue   // Enforce at most one command is running per key(s)
-	tsCache      *TimestampCache // Most recent timestamps for keys / key ranges
-	respCache    *ResponseCache  // Provides idempotence for retries
-	pendingCmds  map[cmdIDKey]*pendingCmd
+	keys      *keySampler   // Samples the keys addressed by commands
+
+	qpsMu      sync.Mutex // Protects the QPS samples below
+	qpsPrev    qpsSample  // Sample preceding qpsCurrent
+	qpsCurrent qpsSample  // Most recent sample of cmdCount
+
+	sync.RWMutex                  // Protects the following fields (and Desc)
+	cmdQ          *CommandQueue   // Enforce at most one command is running per key(s)
+	tsCache       *TimestampCache // Most recent timestamps for keys / key ranges
+	respCache     *ResponseCache  // Provides idempotence for retries
+	pendingCmds   map[cmdIDKey]*pendingCmd
+	gcProtections gcProtections                 // Read timestamps protected from GC
+	checksum      *replicaChecksum              // In-progress consistency check, if any
+	corrupt       *proto.ReplicaCorruptionError // Set once the replica's data is suspect
 }
 
 // NewRange initializes the range using the given metadata.
@@ -169,6 +237,15 @@ func NewRange(desc *proto.RangeDescriptor, rm RangeManager) *Range {
 		respCache:   NewResponseCache(desc.RaftID, rm.Engine()),
 		pendingCmds: map[cmdIDKey]*pendingCmd{},
 	}
+	r.qpsCurrent.nanos = rm.Clock().PhysicalNow()
+	r.keys = newKeySampler(r.qpsCurrent.nanos)
+	rtCount, err := engine.GetRangeStat(rm.Engine(), desc.RaftID, engine.StatRangeTombstoneCount)
+	if err != nil {
+		// Without a count, commands look for range tombstones regardless.
+		log.Warningf("range %d: unable to read range tombstone count: %s", desc.RaftID, err)
+		r.rtUnknown = 1
+	}
+	r.rtCount = rtCount
 	return r
 }
 
@@ -208,6 +285,16 @@ func (r *Range) Destroy() error {
 	if err := engine.ClearRangeStats(r.rm.Engine(), r.Desc.RaftID); err != nil {
 		return util.Errorf("unable to clear range stats for range %d: %s", r.Desc.RaftID, err)
 	}
+	start = engine.MVCCEncodeKey(engine.RangeLastVerifiedKey(r.Desc.RaftID))
+	end = engine.MVCCEncodeKey(engine.RangeLastVerifiedKey(r.Desc.RaftID).Next())
+	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
+		return util.Errorf("unable to clear last verified timestamp for range %d: %s", r.Desc.RaftID, err)
+	}
+	start = engine.MVCCEncodeKey(engine.RangeGCMetadataKey(r.Desc.RaftID))
+	end = engine.MVCCEncodeKey(engine.RangeGCMetadataKey(r.Desc.RaftID).Next())
+	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
+		return util.Errorf("unable to clear GC metadata for range %d: %s", r.Desc.RaftID, err)
+	}
 	start = engine.MVCCEncodeKey(engine.RangeDescriptorKey(r.Desc.StartKey))
 	end = engine.MVCCEncodeKey(engine.RangeDescriptorKey(r.Desc.StartKey).Next())
 	if _, err := engine.ClearRange(r.rm.Engine(), start, end); err != nil {
@@ -222,11 +309,106 @@ func (r *Range) IsFirstRange() bool {
 }
 
 // IsLeader returns true if this range replica is the raft leader.
-// TODO(spencer): this is always true for now.
+// TODO(spencer): this is always true for now. Once leadership is held
+// by lease, replicas on a node in standby mode (see
+// NodeDescriptor.Standby) must not acquire one.
 func (r *Range) IsLeader() bool {
 	return true
 }
 
+// QPS returns the average number of commands per second received by
+// this range since the older of its two most recent samples of the
+// command count. Samples are only taken by calls to QPS, at most one
+// per qpsInterval: when QPS is called at least that often, the window
+// spans between one and two multiples of qpsInterval (or the time
+// since creation for younger ranges); otherwise it stretches back to
+// the sample taken by the previous call.
+func (r *Range) QPS() float64 {
+	now := r.rm.Clock().PhysicalNow()
+	count := atomic.LoadInt64(&r.cmdCount)
+
+	r.qpsMu.Lock()
+	defer r.qpsMu.Unlock()
+	if now-r.qpsCurrent.nanos >= qpsInterval.Nanoseconds() {
+		r.qpsPrev = r.qpsCurrent
+		r.qpsCurrent = qpsSample{nanos: now, count: count}
+	}
+	base := r.qpsPrev
+	if base.nanos == 0 {
+		base = r.qpsCurrent
+	}
+	if elapsed := now - base.nanos; elapsed > 0 {
+		return float64(count-base.count) / time.Duration(elapsed).Seconds()
+	}
+	return 0
+}
+
+// HotKeys returns up to k of the keys most frequently addressed by
+// commands received by this range, estimated from a sample of recent
+// commands (see keySampler), together with their share of them. For
+// commands spanning several keys, the start key is sampled.
+func (r *Range) HotKeys(k int) []KeySample {
+	return r.keys.top(k, r.rm.Clock().PhysicalNow())
+}
+
+// IsCorrupt returns whether the replica has been marked corrupted and
+// has stopped serving.
+func (r *Range) IsCorrupt() bool {
+	return r.Corruption() != nil
+}
+
+// Corruption returns the error with which the replica was marked
+// corrupted, or nil if it wasn't.
+func (r *Range) Corruption() *proto.ReplicaCorruptionError {
+	r.RLock()
+	defer r.RUnlock()
+	return r.corrupt
+}
+
+// setCorrupt marks the replica corrupted by the supplied cause. A
+// corrupted replica rejects all commands, so that its suspect data
+// isn't served, but keeps applying Raft commands to stay in step with
+// its peers. The store is informed and stops queueing the replica.
+// Replicas can't be replaced yet, so the mark is only held in memory:
+// it's lifted by clearCorrupt or when the store restarts. Returns the
+// corruption error, which is the original one if the replica was
+// already corrupted.
+func (r *Range) setCorrupt(cause error) *proto.ReplicaCorruptionError {
+	r.Lock()
+	if r.corrupt != nil {
+		err := r.corrupt
+		r.Unlock()
+		return err
+	}
+	err := proto.NewReplicaCorruptionError(cause)
+	r.corrupt = err
+	r.Unlock()
+
+	log.Errorf("range %d: replica on store %d is corrupted and has stopped serving: %s",
+		r.Desc.RaftID, r.rm.StoreID(), cause)
+	r.rm.MarkReplicaCorrupt(r)
+	return err
+}
+
+// clearCorrupt puts a replica marked corrupted back into service and
+// returns whether it was marked.
+func (r *Range) clearCorrupt() bool {
+	r.Lock()
+	defer r.Unlock()
+	wasCorrupt := r.corrupt != nil
+	r.corrupt = nil
+	return wasCorrupt
+}
+
+// Descriptor returns a copy of the range descriptor, read under the
+// range's lock. Unlike reading Desc directly, this is safe outside of
+// command execution, where a concurrent split may modify Desc.
+func (r *Range) Descriptor() *proto.RangeDescriptor {
+	r.RLock()
+	defer r.RUnlock()
+	return gogoproto.Clone(r.Desc).(*proto.RangeDescriptor)
+}
+
 // GetReplica returns the replica for this range from the range descriptor.
 func (r *Range) GetReplica() *proto.Replica {
 	return r.Desc.FindReplica(r.rm.StoreID())
@@ -254,8 +436,20 @@ func (r *Range) ContainsKeyRange(start, end proto.Key) bool {
 // range's leadership is confirmed. The command is then dispatched
 // either along the read-only execution path or the read-write Raft
 // command queue. If wait is false, read-write commands are added to
-// Raft without waiting for their completion.
+// Raft without waiting for their completion. Commands which would
+// modify the audit log are rejected; see VerifyAuditLogWrite.
 func (r *Range) AddCmd(method string, args proto.Request, reply proto.Response, wait bool) error {
+	if err := r.Corruption(); err != nil {
+		reply.Header().SetGoError(err)
+		return err
+	}
+	if err := VerifyAuditLogWrite(method, args); err != nil {
+		reply.Header().SetGoError(err)
+		return err
+	}
+	if args.Header().ReadConsistency == proto.INCONSISTENT {
+		return r.addInconsistentReadCmd(method, args, reply)
+	}
 	if !r.IsLeader() {
 		// TODO(spencer): when we happen to know the leader, fill it in here via replica.
 		err := &proto.NotLeaderError{}
@@ -263,6 +457,9 @@ func (r *Range) AddCmd(method string, args proto.Request, reply proto.Response,
 		return err
 	}
 
+	atomic.AddInt64(&r.cmdCount, 1)
+	r.keys.record(args.Header().Key, r.rm.Clock().PhysicalNow())
+
 	// Differentiate between read-only and read-write.
 	if proto.IsAdmin(method) {
 		return r.addAdminCmd(method, args, reply)
@@ -288,6 +485,39 @@ func (r *Range) beginCmd(start, end proto.Key, readOnly bool) interface{} {
 	return cmdKey
 }
 
+// isSequenceReplay returns whether a response cached for the
+// request's command ID was for the same write. Responses to
+// transactional writes are scoped to the transaction sequence of the
+// write.
+func isSequenceReplay(header *proto.RequestHeader, cached proto.Response) bool {
+	cachedTxn := cached.Header().Txn
+	if header.Txn == nil || cachedTxn == nil {
+		return true
+	}
+	return header.Txn.Sequence == cachedTxn.Sequence
+}
+
+// addTrace records the supplied spans for stages executed on this
+// range in the reply's trace.
+func (r *Range) addTrace(reply proto.Response, spans ...proto.TraceSpan) {
+	for _, span := range spans {
+		span.RaftID = r.Desc.RaftID
+		reply.Header().Trace = append(reply.Header().Trace, span)
+	}
+}
+
+// newGarbageCollector returns a GC for the range's data which uses
+// the supplied zone GC policies and retains the versions visible at
+// the earliest read timestamp protected on this range.
+func (r *Range) newGarbageCollector(policyFn func(key proto.Key) *proto.GCPolicy) *engine.GarbageCollector {
+	now := r.rm.Clock().Now()
+	gc := engine.NewGarbageCollector(now, policyFn)
+	r.Lock()
+	gc.SetProtectedTimestamp(r.gcProtections.min(now.WallTime))
+	r.Unlock()
+	return gc
+}
+
 // addAdminCmd executes the command directly. There is no interaction
 // with the command queue or the timestamp cache, as admin commands
 // are not meant to consistently access or modify the underlying data.
@@ -307,9 +537,18 @@ func (r *Range) addAdminCmd(method string, args proto.Request, reply proto.Respo
 func (r *Range) addReadOnlyCmd(method string, args proto.Request, reply proto.Response) error {
 	header := args.Header()
 
+	// Protect the read timestamp from GC before reading, if requested.
+	if header.ProtectUntil > 0 {
+		r.Lock()
+		r.gcProtections.add(header.Timestamp, header.ProtectUntil, r.rm.Clock().PhysicalNow())
+		r.Unlock()
+	}
+
 	// Add the read to the command queue to gate subsequent
 	// overlapping, commands until this command completes.
+	queueSpan := proto.NewTraceSpan("command queue")
 	cmdKey := r.beginCmd(header.Key, header.EndKey, true)
+	queueSpan.Finish(nil)
 
 	// It's possible that arbitrary delays (e.g. major GC, VM
 	// de-prioritization, etc.) could cause the execution of this read
@@ -330,7 +569,12 @@ func (r *Range) addReadOnlyCmd(method string, args proto.Request, reply proto.Re
 		// TODO(spencer): when we happen to know the leader, fill it in here via replica.
 		return &proto.NotLeaderError{}
 	}
+	execSpan := proto.NewTraceSpan("execute " + method)
 	err := r.executeCmd(method, args, reply)
+	execSpan.Finish(err)
+	if header.Trace {
+		r.addTrace(reply, queueSpan, execSpan)
+	}
 
 	// Only update the timestamp cache if the command succeeded.
 	r.Lock()
@@ -343,6 +587,29 @@ func (r *Range) addReadOnlyCmd(method string, args proto.Request, reply proto.Re
 	return err
 }
 
+// addInconsistentReadCmd executes a read-only command on this replica,
+// whether or not it's the leader, without waiting on the command queue
+// or updating the timestamp cache. Intents are ignored rather than
+// pushed, so the read may miss writes which are still being applied
+// or whose transactions have yet to commit.
+func (r *Range) addInconsistentReadCmd(method string, args proto.Request, reply proto.Response) error {
+	header := args.Header()
+	if !proto.IsReadOnly(method) || header.Txn != nil {
+		err := util.Errorf("inconsistent reads are only permitted for non-transactional read-only commands; got %s", method)
+		reply.Header().SetGoError(err)
+		return err
+	}
+	atomic.AddInt64(&r.cmdCount, 1)
+	r.keys.record(header.Key, r.rm.Clock().PhysicalNow())
+	execSpan := proto.NewTraceSpan("execute " + method)
+	err := r.executeCmd(method, args, reply)
+	execSpan.Finish(err)
+	if header.Trace {
+		r.addTrace(reply, execSpan)
+	}
+	return err
+}
+
 // addReadWriteCmd first consults the response cache to determine whether
 // this command has already been sent to the range. If a response is
 // found, it's returned immediately and not submitted to raft. Next,
@@ -359,14 +626,25 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 	header := args.Header()
 	txnMD5 := header.Txn.MD5()
 	if ok, err := r.respCache.GetResponse(header.CmdID, reply); ok || err != nil {
-		if ok { // this is a replay! extract error for return
+		if ok && isSequenceReplay(header, reply) { // this is a replay! extract error for return
 			return reply.Header().GoError()
+		} else if ok {
+			// The cached response is for a different write of the
+			// transaction which reused the command ID.
+			reply.Reset()
+		} else {
+			// In this case there was an error reading from the response
+			// cache. Instead of failing the request just because we can't
+			// decode the reply in the response cache, we proceed as though
+			// idempotence has expired.
+			log.Errorf("unable to read result for %+v from the response cache: %s", args, err)
 		}
-		// In this case there was an error reading from the response
-		// cache. Instead of failing the request just because we can't
-		// decode the reply in the response cache, we proceed as though
-		// idempotence has expired.
-		log.Errorf("unable to read result for %+v from the response cache: %s", args, err)
+	}
+
+	// Reject the write if the range is too large and waiting on a split.
+	if err := r.checkBackpressure(method, args); err != nil {
+		reply.Header().SetGoError(err)
+		return err
 	}
 
 	// Add the write to the command queue to gate subsequent overlapping
@@ -374,7 +652,9 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 	// done before getting the max timestamp for the key(s), as
 	// timestamp cache is only updated after preceding commands have
 	// been run to successful completion.
+	queueSpan := proto.NewTraceSpan("command queue")
 	cmdKey := r.beginCmd(header.Key, header.EndKey, false)
+	queueSpan.Finish(nil)
 
 	// Two important invariants of Cockroach: 1) encountering a more
 	// recently written value means transaction restart. 2) values must
@@ -399,15 +679,12 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 		} else if !wTS.Less(header.Timestamp) || !rTS.Less(header.Timestamp) {
 			// Otherwise, make sure we advance the request's timestamp.
 			ts := wTS
-			if ts.Less(rTS) {
-				ts = rTS
-			}
+			ts.Forward(rTS)
 			if log.V(1) {
 				log.Infof("Overriding existing timestamp %s with %s", header.Timestamp, ts)
 			}
-			ts.Logical++ // increment logical component by one to differentiate.
-			// Update the request timestamp.
-			header.Timestamp = ts
+			// Update the request timestamp, incremented by one to differentiate.
+			header.Timestamp = ts.Next()
 		}
 	}
 
@@ -421,6 +698,16 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 	raftCmd := proto.InternalRaftCommand{
 		RaftID: r.Desc.RaftID,
 	}
+	// Replicas other than this one reconstruct the command from the
+	// union; see processRaftCommand.
+	if !raftCmd.Cmd.SetValue(args) {
+		err := util.Errorf("unable to add %s to raft command union", method)
+		reply.Header().SetGoError(err)
+		r.Lock()
+		r.cmdQ.Remove(cmdKey)
+		r.Unlock()
+		return err
+	}
 	var cmdID proto.ClientCmdID
 	if !args.Header().CmdID.IsEmpty() {
 		cmdID = args.Header().CmdID
@@ -437,12 +724,19 @@ func (r *Range) addReadWriteCmd(method string, args proto.Request, reply proto.R
 	// TODO(bdarnell): In certain raft failover scenarios, proposed
 	// commands may be abandoned. We need to re-propose the command
 	// if too much time passes with no response on the done channel.
+	raftSpan := proto.NewTraceSpan("raft " + method)
 	r.rm.ProposeRaftCommand(idKey, raftCmd)
 
 	// Create a completion func for mandatory cleanups which we either
 	// run synchronously if we're waiting or in a goroutine otherwise.
 	completionFunc := func() error {
 		err := <-pendingCmd.done
+		raftSpan.Finish(err)
+		// Spans are added only after the reply has been written to the
+		// response cache, so that they aren't replayed.
+		if wait && header.Trace {
+			r.addTrace(reply, queueSpan, raftSpan)
+		}
 
 		// As for reads, update timestamp cache with the timestamp
 		// of this write on success. This ensures a strictly higher
@@ -489,12 +783,14 @@ func (r *Range) processRaftCommand(idKey cmdIDKey, raftCmd proto.InternalRaftCom
 		// reply buffer and reconstruct the method name.
 		args = raftCmd.Cmd.GetValue().(proto.Request)
 		method, err = proto.MethodForRequest(args)
-		if err != nil {
-			log.Fatal(err)
+		if err == nil {
+			_, reply, err = proto.CreateArgsAndReply(method)
 		}
-		_, reply, err = proto.CreateArgsAndReply(method)
 		if err != nil {
-			log.Fatal(err)
+			// The replica can't apply a command its peers will; its
+			// data can no longer be trusted.
+			r.setCorrupt(err)
+			return
 		}
 	}
 	err = r.executeCmd(method, args, reply)
@@ -534,7 +830,7 @@ func (r *Range) maybeGossipClusterID() {
 // the start of the key space and the raft leader.
 func (r *Range) maybeGossipFirstRange() {
 	if r.rm.Gossip() != nil && r.IsFirstRange() && r.IsLeader() {
-		if err := r.rm.Gossip().AddInfo(gossip.KeyFirstRangeDescriptor, *r.Desc, 0*time.Second); err != nil {
+		if err := r.rm.Gossip().AddInfoProto(gossip.KeyFirstRangeDescriptor, r.Desc, 0*time.Second); err != nil {
 			log.Errorf("failed to gossip first range metadata: %s", err)
 		}
 	}
@@ -572,7 +868,7 @@ func (r *Range) maybeGossipConfigs(dirtyConfigs ...*configDescriptor) {
 // instantiates/returns a config map. Prefix configuration maps
 // include accounting, permissions, and zones.
 func (r *Range) loadConfigMap(keyPrefix proto.Key, configI interface{}) (PrefixConfigMap, error) {
-	kvs, err := engine.MVCCScan(r.rm.Engine(), keyPrefix, keyPrefix.PrefixEnd(), 0, proto.MaxTimestamp, nil)
+	kvs, err := engine.MVCCScan(r.rm.Engine(), keyPrefix, keyPrefix.PrefixEnd(), 0, proto.MaxTimestamp, true, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -600,31 +896,86 @@ func (r *Range) maybeUpdateGossipConfigs(key proto.Key) {
 	}
 }
 
-// ShouldSplit returns whether the current size of the range exceeds
-// the max size specified in the zone config.
-func (r *Range) ShouldSplit() bool {
-	// If not the leader or gossip is not enabled, ignore.
-	if !r.IsLeader() || r.rm.Gossip() == nil {
-		return false
+// zoneConfig returns the config of the zone containing the range's
+// start key, as gossiped.
+func (r *Range) zoneConfig() (*proto.ZoneConfig, error) {
+	if r.rm.Gossip() == nil {
+		return nil, util.Errorf("gossip is not available")
+	}
+	info, err := r.rm.Gossip().GetInfo(gossip.KeyConfigZone)
+	if err != nil || info == nil {
+		return nil, util.Errorf("unable to fetch zone config from gossip: %v", err)
+	}
+	prefixConfig := info.(PrefixConfigMap).MatchByPrefix(engine.RangeConfigKey(r.Desc.StartKey))
+	return prefixConfig.Config.(*proto.ZoneConfig), nil
+}
+
+// getSizeAndMaxBytes returns the current size of the range in total
+// bytes and the max size specified in the zone config for the zone
+// containing this range's start key. Returns false if either value
+// could not be determined.
+func (r *Range) getSizeAndMaxBytes() (int64, int64, bool) {
+	// If gossip is not enabled, ignore.
+	if r.rm.Gossip() == nil {
+		return 0, 0, false
 	}
 
 	// Fetch the zone config for the zone containing this range's start key.
 	zoneMap, err := r.rm.Gossip().GetInfo(gossip.KeyConfigZone)
 	if err != nil || zoneMap == nil {
 		log.Errorf("unable to fetch zone config from gossip: %s", err)
-		return false
+		return 0, 0, false
 	}
-	prefixConfig := zoneMap.(PrefixConfigMap).MatchByPrefix(r.Desc.StartKey)
+	prefixConfig := zoneMap.(PrefixConfigMap).MatchByPrefix(engine.RangeConfigKey(r.Desc.StartKey))
 	zone := prefixConfig.Config.(*proto.ZoneConfig)
 
 	// Fetch the current size of this range in total bytes.
 	rangeSize, err := engine.GetRangeSize(r.rm.Engine(), r.Desc.RaftID)
 	if err != nil {
 		log.Errorf("unable to compute size from stats for range %d: %s", r.Desc.RaftID, err)
+		return 0, 0, false
+	}
+
+	return rangeSize, zone.RangeMaxBytes, true
+}
+
+// ShouldSplit returns whether the current size of the range exceeds
+// the max size specified in the zone config.
+func (r *Range) ShouldSplit() bool {
+	// If not the leader, ignore.
+	if !r.IsLeader() {
 		return false
 	}
+	rangeSize, maxBytes, ok := r.getSizeAndMaxBytes()
+	return ok && rangeSize > maxBytes
+}
 
-	return rangeSize > zone.RangeMaxBytes
+// checkBackpressure returns a RangeTooLargeError if the command is a
+// write subject to backpressure and the range has grown beyond
+// backpressureRangeSizeMultiplier times the max size specified in
+// its zone config. This happens when splits can't keep up with the
+// rate of incoming writes, as with monotonically increasing keys.
+// Rejecting these writes gives the range a chance to split before
+// it grows without bound.
+func (r *Range) checkBackpressure(method string, args proto.Request) error {
+	if _, ok := backpressureMethods[method]; !ok {
+		return nil
+	}
+	// Never backpressure writes to system keys; among other things,
+	// these are used to carry out the split itself.
+	if args.Header().Key.Less(engine.KeySystemMax) {
+		return nil
+	}
+	rangeSize, maxBytes, ok := r.getSizeAndMaxBytes()
+	if !ok || maxBytes <= 0 {
+		return nil
+	}
+	if float64(rangeSize) > float64(maxBytes)*backpressureRangeSizeMultiplier {
+		// Make sure a split is underway.
+		r.maybeSplit()
+		return proto.NewRangeTooLargeError(r.Desc.RaftID, rangeSize, maxBytes)
+	}
+	return nil
 }
 
 // maybeSplit initiates an asynchronous split via AdminSplit request
@@ -647,15 +998,15 @@ func (r *Range) maybeSplit() {
 }
 
 // executeCmd switches over the method and multiplexes to execute the
-// appropriate storage API command.
+// appropriate storage API command. A failure to commit the command's
+// writes marks the replica corrupted, as its data would otherwise
+// diverge from that of replicas which applied the command.
 //
 // TODO(Spencer): Differentiate between errors caused by the normal culprits --
 // bad inputs from clients, stale information, etc. and errors which might
 // cause the range replicas to diverge -- running out of disk space, underlying
 // rocksdb corruption, etc. Do a careful code audit to make sure we identify
-// errors which should be classified as a ReplicaCorruptionError--when those
-// bubble up to the point where we've just tried to execute a Raft command, the
-// Raft replica would need to stall itself.
+// errors which should be classified as a ReplicaCorruptionError.
 func (r *Range) executeCmd(method string, args proto.Request, reply proto.Response) error {
 	// Verify key is contained within range here to catch any range split
 	// or merge activity.
@@ -667,7 +1018,7 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 	}
 
 	// Create a new batch for the command to ensure all or nothing semantics.
-	batch := r.rm.Engine().NewBatch()
+	batch := r.withRangeTombstoneBounds(r.rm.Engine().NewBatch())
 	// Create an engine.MVCCStats instance.
 	ms := &engine.MVCCStats{}
 
@@ -680,6 +1031,8 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 		r.Put(batch, ms, args.(*proto.PutRequest), reply.(*proto.PutResponse))
 	case proto.ConditionalPut:
 		r.ConditionalPut(batch, ms, args.(*proto.ConditionalPutRequest), reply.(*proto.ConditionalPutResponse))
+	case proto.CompareAndSwap:
+		r.CompareAndSwap(batch, ms, args.(*proto.CompareAndSwapRequest), reply.(*proto.CompareAndSwapResponse))
 	case proto.Increment:
 		r.Increment(batch, ms, args.(*proto.IncrementRequest), reply.(*proto.IncrementResponse))
 	case proto.Delete:
@@ -688,8 +1041,12 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 		r.DeleteRange(batch, ms, args.(*proto.DeleteRangeRequest), reply.(*proto.DeleteRangeResponse))
 	case proto.Scan:
 		r.Scan(batch, args.(*proto.ScanRequest), reply.(*proto.ScanResponse))
+	case proto.ReverseScan:
+		r.ReverseScan(batch, args.(*proto.ReverseScanRequest), reply.(*proto.ReverseScanResponse))
 	case proto.EndTransaction:
 		r.EndTransaction(batch, args.(*proto.EndTransactionRequest), reply.(*proto.EndTransactionResponse))
+	case proto.Batch:
+		r.Batch(batch, ms, args.(*proto.BatchRequest), reply.(*proto.BatchResponse))
 	case proto.ReapQueue:
 		r.ReapQueue(batch, args.(*proto.ReapQueueRequest), reply.(*proto.ReapQueueResponse))
 	case proto.EnqueueUpdate:
@@ -705,41 +1062,48 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 	case proto.InternalResolveIntent:
 		r.InternalResolveIntent(batch, ms, args.(*proto.InternalResolveIntentRequest), reply.(*proto.InternalResolveIntentResponse))
 	case proto.InternalSnapshotCopy:
-		r.InternalSnapshotCopy(r.rm.Engine(), args.(*proto.InternalSnapshotCopyRequest), reply.(*proto.InternalSnapshotCopyResponse))
+		r.InternalSnapshotCopy(args.(*proto.InternalSnapshotCopyRequest), reply.(*proto.InternalSnapshotCopyResponse))
 	case proto.InternalMerge:
 		r.InternalMerge(batch, ms, args.(*proto.InternalMergeRequest), reply.(*proto.InternalMergeResponse))
+	case proto.InternalConditionalPutInline:
+		r.InternalConditionalPutInline(batch, ms, args.(*proto.InternalConditionalPutInlineRequest), reply.(*proto.InternalConditionalPutInlineResponse))
+	case proto.InternalDeleteInline:
+		r.InternalDeleteInline(batch, ms, args.(*proto.InternalDeleteInlineRequest), reply.(*proto.InternalDeleteInlineResponse))
+	case proto.InternalComputeChecksum:
+		r.InternalComputeChecksum(args.(*proto.InternalComputeChecksumRequest), reply.(*proto.InternalComputeChecksumResponse))
+	case proto.InternalVerifyChecksum:
+		r.InternalVerifyChecksum(batch, args.(*proto.InternalVerifyChecksumRequest), reply.(*proto.InternalVerifyChecksumResponse))
+	case proto.InternalRecomputeStats:
+		r.InternalRecomputeStats(batch, ms, args.(*proto.InternalRecomputeStatsRequest), reply.(*proto.InternalRecomputeStatsResponse))
+	case proto.InternalGC:
+		r.InternalGC(batch, ms, args.(*proto.InternalGCRequest), reply.(*proto.InternalGCResponse))
 	default:
 		return util.Errorf("unrecognized command %q", method)
 	}
 
-	// On success, flush the MVCC stats to the batch and commit.
 	if err := reply.Header().GoError(); err == nil {
+		// On success, flush the MVCC stats to the batch.
 		if proto.IsReadWrite(method) {
 			ms.MergeStats(batch, r.Desc.RaftID, r.rm.StoreID())
-			if err := batch.Commit(); err != nil {
-				reply.Header().SetGoError(err)
-			} else {
-				// If the commit succeeded, potentially initiate a split of this range.
-				r.maybeSplit()
-			}
 		}
-	} else if err, ok := reply.Header().GoError().(*proto.ReadWithinUncertaintyIntervalError); ok {
-		// A ReadUncertaintyIntervalError contains the timestamp of the value
-		// that provoked the conflict. However, we forward the timestamp to the
-		// node's time here. The reason is that the caller (which is always
-		// transactional when this error occurs) in our implementation wants to
-		// use this information to extract a timestamp after which reads from
-		// the nodes are causally consistent with the transaction. This allows
-		// the node to be classified as without further uncertain reads for the
-		// remainder of the transaction.
-		// See the comment on proto.Transaction.CertainNodes.
-		err.ExistingTimestamp.Forward(r.rm.Clock().Now())
-	}
-
-	// Maybe update gossip configs on a put if there was no error.
-	if (method == proto.Put || method == proto.ConditionalPut) &&
-		header.Key.Less(engine.KeySystemMax) && reply.Header().Error == nil {
-		r.maybeUpdateGossipConfigs(args.Header().Key)
+	} else {
+		// On failure, discard the command's writes; only the response
+		// is recorded below.
+		if proto.IsReadWrite(method) {
+			batch = r.rm.Engine().NewBatch()
+		}
+		if err, ok := err.(*proto.ReadWithinUncertaintyIntervalError); ok {
+			// A ReadUncertaintyIntervalError contains the timestamp of the value
+			// that provoked the conflict. However, we forward the timestamp to the
+			// node's time here. The reason is that the caller (which is always
+			// transactional when this error occurs) in our implementation wants to
+			// use this information to extract a timestamp after which reads from
+			// the nodes are causally consistent with the transaction. This allows
+			// the node to be classified as without further uncertain reads for the
+			// remainder of the transaction.
+			// See the comment on proto.Transaction.CertainNodes.
+			err.ExistingTimestamp.Forward(r.rm.Clock().Now())
+		}
 	}
 
 	// Propagate the request timestamp (which may have changed).
@@ -750,21 +1114,59 @@ func (r *Range) executeCmd(method string, args proto.Request, reply proto.Respon
 	// Add this command's result to the response cache if this is a
 	// read/write method. This must be done as part of the execution of
 	// raft commands so that every replica maintains the same responses
-	// to continue request idempotence when leadership changes.
+	// to continue request idempotence when leadership changes. The
+	// response is written to the same batch as the command's writes,
+	// so that a crash can't leave a command applied without its
+	// response, which would let a retry apply it a second time.
 	if proto.IsReadWrite(method) {
-		if putErr := r.respCache.PutResponse(args.Header().CmdID, reply); putErr != nil {
+		// Record the transaction with the response to scope it to the
+		// sequence of the transaction's write; see isSequenceReplay.
+		if header.Txn != nil && reply.Header().Txn == nil {
+			reply.Header().Txn = gogoproto.Clone(header.Txn).(*proto.Transaction)
+		}
+		cmdID := args.Header().CmdID
+		if putErr := r.respCache.putResponse(batch, cmdID, reply); putErr != nil {
 			log.Errorf("unable to write result of %+v: %+v to the response cache: %s",
 				args, reply, putErr)
 		}
+		succeeded := reply.Header().Error == nil
+		if err := batch.Commit(); err != nil {
+			reply.Header().SetGoError(r.setCorrupt(err))
+		} else if succeeded {
+			atomic.AddInt64(&r.rtCount, ms.RangeTombstoneCount)
+			// If the commit succeeded, potentially initiate a split of this range.
+			r.maybeSplit()
+		}
+		r.respCache.RemoveInflight(cmdID)
+	}
+
+	// Maybe update gossip configs on a put if there was no error.
+	if (method == proto.Put || method == proto.ConditionalPut) &&
+		header.Key.Less(engine.KeySystemMax) && reply.Header().Error == nil {
+		r.maybeUpdateGossipConfigs(args.Header().Key)
 	}
 
 	// Return the error (if any) set in the reply.
 	return reply.Header().GoError()
 }
 
+// withRangeTombstoneBounds wraps a command's batch so that MVCC
+// operations consider only range tombstones starting within the range,
+// which are the only ones which can cover its keys, and skip looking
+// for them altogether if the range is known to hold none.
+func (r *Range) withRangeTombstoneBounds(batch engine.Engine) engine.Engine {
+	r.RLock()
+	start, end := r.Desc.StartKey, r.Desc.EndKey
+	r.RUnlock()
+	if atomic.LoadInt32(&r.rtUnknown) == 0 && atomic.LoadInt64(&r.rtCount) == 0 {
+		end = start
+	}
+	return engine.WithRangeTombstoneBounds(batch, start, end)
+}
+
 // Contains verifies the existence of a key in the key value store.
 func (r *Range) Contains(batch engine.Engine, args *proto.ContainsRequest, reply *proto.ContainsResponse) {
-	val, err := engine.MVCCGet(batch, args.Key, args.Timestamp, args.Txn)
+	val, err := engine.MVCCGet(batch, args.Key, args.Timestamp, args.ReadConsistency == proto.CONSISTENT, args.Txn)
 	if err != nil {
 		reply.SetGoError(err)
 		return
@@ -774,9 +1176,18 @@ func (r *Range) Contains(batch engine.Engine, args *proto.ContainsRequest, reply
 	}
 }
 
-// Get returns the value for a specified key.
+// Get returns the value for a specified key. If tombstones are
+// requested and the key was deleted, the reply holds the timestamp of
+// the deletion.
 func (r *Range) Get(batch engine.Engine, args *proto.GetRequest, reply *proto.GetResponse) {
-	val, err := engine.MVCCGet(batch, args.Key, args.Timestamp, args.Txn)
+	if args.IncludeTombstones {
+		val, deleted, err := engine.MVCCGetWithTombstones(batch, args.Key, args.Timestamp, args.ReadConsistency == proto.CONSISTENT, args.Txn)
+		reply.Value = val
+		reply.Deleted = deleted
+		reply.SetGoError(err)
+		return
+	}
+	val, err := engine.MVCCGet(batch, args.Key, args.Timestamp, args.ReadConsistency == proto.CONSISTENT, args.Txn)
 	reply.Value = val
 	reply.SetGoError(err)
 }
@@ -795,6 +1206,60 @@ func (r *Range) ConditionalPut(batch engine.Engine, ms *engine.MVCCStats, args *
 	reply.SetGoError(err)
 }
 
+// CompareAndSwap verifies each of the conditions in turn and, only if
+// all of them hold, applies the writes. Every key must lie within the
+// span of the request header; as the command executes in a single
+// batch on a single range, the writes are atomic without requiring
+// a transaction. If a condition fails, the reply carries a
+// ConditionFailedError and nothing is written.
+func (r *Range) CompareAndSwap(batch engine.Engine, ms *engine.MVCCStats, args *proto.CompareAndSwapRequest, reply *proto.CompareAndSwapResponse) {
+	if args.Txn != nil {
+		reply.SetGoError(util.Errorf("cannot compare-and-swap within a transaction"))
+		return
+	}
+	inSpan := func(key proto.Key) bool {
+		if len(args.EndKey) == 0 {
+			return key.Equal(args.Key)
+		}
+		return !key.Less(args.Key) && key.Less(args.EndKey)
+	}
+	for _, c := range args.Conditions {
+		if !inSpan(c.Key) {
+			reply.SetGoError(proto.NewRangeKeyMismatchError(c.Key, c.Key, r.Desc))
+			return
+		}
+	}
+	for _, w := range args.Writes {
+		if !inSpan(w.Key) {
+			reply.SetGoError(proto.NewRangeKeyMismatchError(w.Key, w.Key, r.Desc))
+			return
+		}
+		if w.Key.Less(engine.KeySystemMax) {
+			reply.SetGoError(util.Errorf("compare-and-swap may not write system key %s", w.Key))
+			return
+		}
+	}
+
+	for _, c := range args.Conditions {
+		if err := engine.MVCCVerifyValue(batch, c.Key, c.ExpValue); err != nil {
+			reply.SetGoError(err)
+			return
+		}
+	}
+	for _, w := range args.Writes {
+		var err error
+		if w.Value == nil {
+			err = engine.MVCCDelete(batch, ms, w.Key, args.Timestamp, nil)
+		} else {
+			err = engine.MVCCPut(batch, ms, w.Key, args.Timestamp, *w.Value, nil)
+		}
+		if err != nil {
+			reply.SetGoError(err)
+			return
+		}
+	}
+}
+
 // Increment increments the value (interpreted as varint64 encoded) and
 // returns the newly incremented value (encoded as varint64). If no value
 // exists for the key, zero is incremented.
@@ -810,18 +1275,52 @@ func (r *Range) Delete(batch engine.Engine, ms *engine.MVCCStats, args *proto.De
 }
 
 // DeleteRange deletes the range of key/value pairs specified by
-// start and end keys.
+// start and end keys. If args.UseRangeTombstone is set, a single
+// range tombstone is written in place of a deletion per key.
 func (r *Range) DeleteRange(batch engine.Engine, ms *engine.MVCCStats, args *proto.DeleteRangeRequest, reply *proto.DeleteRangeResponse) {
+	if args.UseRangeTombstone {
+		if args.Txn != nil {
+			reply.SetGoError(util.Errorf("range tombstones cannot be written transactionally"))
+			return
+		}
+		if args.MaxEntriesToDelete != 0 {
+			reply.SetGoError(util.Errorf("range tombstones cannot delete a maximum number of entries"))
+			return
+		}
+		reply.SetGoError(engine.MVCCDeleteRangeTombstone(batch, ms, args.Key, args.EndKey, args.Timestamp))
+		return
+	}
 	num, err := engine.MVCCDeleteRange(batch, ms, args.Key, args.EndKey, args.MaxEntriesToDelete, args.Timestamp, args.Txn)
 	reply.NumDeleted = num
 	reply.SetGoError(err)
 }
 
 // Scan scans the key range specified by start key through end key up
-// to some maximum number of results. The last key of the iteration is
-// returned with the reply.
+// to some maximum number of results. If the maximum is reached, the key
+// at which to resume the scan is returned with the reply.
 func (r *Range) Scan(batch engine.Engine, args *proto.ScanRequest, reply *proto.ScanResponse) {
-	kvs, err := engine.MVCCScan(batch, args.Key, args.EndKey, args.MaxResults, args.Timestamp, args.Txn)
+	scan := engine.MVCCScan
+	if args.IncludeTombstones {
+		scan = engine.MVCCScanWithTombstones
+	}
+	kvs, err := scan(batch, args.Key, args.EndKey, args.MaxResults, args.Timestamp,
+		args.ReadConsistency == proto.CONSISTENT, args.Txn)
+	reply.Rows = kvs
+	if err == nil && args.MaxResults > 0 && int64(len(kvs)) == args.MaxResults {
+		reply.ResumeKey = kvs[len(kvs)-1].Key.Next()
+	}
+	reply.SetGoError(err)
+}
+
+// ReverseScan scans the key range specified by start key through end
+// key in descending order, up to some maximum number of results.
+func (r *Range) ReverseScan(batch engine.Engine, args *proto.ReverseScanRequest, reply *proto.ReverseScanResponse) {
+	reverseScan := engine.MVCCReverseScan
+	if args.IncludeTombstones {
+		reverseScan = engine.MVCCReverseScanWithTombstones
+	}
+	kvs, err := reverseScan(batch, args.Key, args.EndKey, args.MaxResults, args.Timestamp,
+		args.ReadConsistency == proto.CONSISTENT, args.Txn)
 	reply.Rows = kvs
 	reply.SetGoError(err)
 }
@@ -875,6 +1374,10 @@ func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRe
 		if reply.Txn.Priority < args.Txn.Priority {
 			reply.Txn.Priority = args.Txn.Priority
 		}
+		// The requester knows which of its writes were rolled back to
+		// savepoints; intents are resolved accordingly.
+		reply.Txn.Sequence = args.Txn.Sequence
+		reply.Txn.IgnoredSequences = args.Txn.IgnoredSequences
 	} else {
 		// The transaction doesn't exist yet on disk; use the supplied version.
 		reply.Txn = gogoproto.Clone(args.Txn).(*proto.Transaction)
@@ -882,9 +1385,7 @@ func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRe
 
 	// Take max of requested timestamp and possibly "pushed" txn
 	// record timestamp as the final commit timestamp.
-	if reply.Txn.Timestamp.Less(args.Timestamp) {
-		reply.Txn.Timestamp = args.Timestamp
-	}
+	reply.Txn.Timestamp.Forward(args.Timestamp)
 
 	// Set transaction status to COMMITTED or ABORTED as per the
 	// args.Commit parameter.
@@ -916,6 +1417,136 @@ func (r *Range) EndTransaction(batch engine.Engine, args *proto.EndTransactionRe
 	}
 }
 
+// Batch executes a batch of requests addressed to this range in the
+// same engine batch, so that their writes become visible atomically.
+//
+// A non-transactional batch may contain any batchable requests, which
+// are executed in order at the batch timestamp, each seeing the writes
+// of the requests before it.
+//
+// A transactional batch is a one-phase commit: a batch of writes
+// followed by a committing EndTransaction. The writes are applied
+// non-transactionally at the batch timestamp, so no transaction record
+// or intents are written.
+//
+// In either case, any failure fails the entire batch. A one-phase
+// commit batch which isn't of that form fails with a
+// OnePhaseCommitError, on which the coordinator falls back to the
+// regular two-phase commit path.
+func (r *Range) Batch(batch engine.Engine, ms *engine.MVCCStats, args *proto.BatchRequest, reply *proto.BatchResponse) {
+	if args.Txn == nil {
+		r.executeBatch(batch, ms, args.Requests, args.Timestamp, proto.IsBatchable, reply)
+		return
+	}
+	n := len(args.Requests)
+	if n < 2 {
+		reply.SetGoError(proto.NewOnePhaseCommitError("batch requires at least one write"))
+		return
+	}
+	etArgs, ok := args.Requests[n-1].GetValue().(*proto.EndTransactionRequest)
+	if !ok || !etArgs.Commit || etArgs.SplitTrigger != nil {
+		reply.SetGoError(proto.NewOnePhaseCommitError("batch must end with a committing EndTransaction"))
+		return
+	}
+	for i := range args.Requests[:n-1] {
+		method, err := proto.MethodForRequest(args.Requests[i].GetValue().(proto.Request))
+		if err != nil {
+			reply.SetGoError(err)
+			return
+		}
+		if !proto.IsOnePhaseCommit(method) {
+			reply.SetGoError(proto.NewOnePhaseCommitError(fmt.Sprintf("%s not permitted in batch", method)))
+			return
+		}
+	}
+	// If the isolation level is SERIALIZABLE, the batch may only commit
+	// at the original transaction timestamp. See EndTransaction.
+	if args.Txn.Isolation == proto.SERIALIZABLE && !args.Timestamp.Equal(args.Txn.OrigTimestamp) {
+		txn := gogoproto.Clone(args.Txn).(*proto.Transaction)
+		txn.Timestamp.Forward(args.Timestamp)
+		reply.SetGoError(proto.NewTransactionRetryError(txn))
+		return
+	}
+	if !r.executeBatch(batch, ms, args.Requests[:n-1], args.Timestamp, proto.IsOnePhaseCommit, reply) {
+		return
+	}
+
+	// The writes succeeded; reply with the committed transaction.
+	reply.Txn = gogoproto.Clone(args.Txn).(*proto.Transaction)
+	reply.Txn.Timestamp.Forward(args.Timestamp)
+	reply.Txn.Status = proto.COMMITTED
+	etReply := &proto.EndTransactionResponse{}
+	etReply.Timestamp = args.Timestamp
+	etReply.Txn = gogoproto.Clone(reply.Txn).(*proto.Transaction)
+	reply.Add(etReply)
+}
+
+// executeBatch executes the supplied requests in order at timestamp,
+// without a transaction, adding their responses to reply. Each request
+// must address this range and its method must satisfy allowed. Returns
+// false after setting an error on reply if any request fails.
+func (r *Range) executeBatch(batch engine.Engine, ms *engine.MVCCStats, reqs []proto.RequestUnion,
+	timestamp proto.Timestamp, allowed func(method string) bool, reply *proto.BatchResponse) bool {
+	for i := range reqs {
+		// Clone the request, as it's executed without its transaction.
+		req := gogoproto.Clone(reqs[i].GetValue().(gogoproto.Message)).(proto.Request)
+		method, err := proto.MethodForRequest(req)
+		if err != nil {
+			reply.SetGoError(err)
+			return false
+		}
+		if !allowed(method) {
+			reply.SetGoError(util.Errorf("%s not permitted in batch", method))
+			return false
+		}
+		header := req.Header()
+		if !proto.IsReadOnly(method) && header.Key.Less(engine.KeySystemMax) {
+			reply.SetGoError(util.Errorf("batch may not write system key %s", header.Key))
+			return false
+		}
+		if !r.ContainsKeyRange(header.Key, header.EndKey) {
+			reply.SetGoError(proto.NewRangeKeyMismatchError(header.Key, header.EndKey, r.Desc))
+			return false
+		}
+		header.Timestamp = timestamp
+		header.Txn = nil
+
+		resp, err := proto.CreateReply(method)
+		if err != nil {
+			reply.SetGoError(err)
+			return false
+		}
+		switch method {
+		case proto.Contains:
+			r.Contains(batch, req.(*proto.ContainsRequest), resp.(*proto.ContainsResponse))
+		case proto.Get:
+			r.Get(batch, req.(*proto.GetRequest), resp.(*proto.GetResponse))
+		case proto.Put:
+			r.Put(batch, ms, req.(*proto.PutRequest), resp.(*proto.PutResponse))
+		case proto.ConditionalPut:
+			r.ConditionalPut(batch, ms, req.(*proto.ConditionalPutRequest), resp.(*proto.ConditionalPutResponse))
+		case proto.Increment:
+			r.Increment(batch, ms, req.(*proto.IncrementRequest), resp.(*proto.IncrementResponse))
+		case proto.Delete:
+			r.Delete(batch, ms, req.(*proto.DeleteRequest), resp.(*proto.DeleteResponse))
+		case proto.DeleteRange:
+			r.DeleteRange(batch, ms, req.(*proto.DeleteRangeRequest), resp.(*proto.DeleteRangeResponse))
+		case proto.Scan:
+			r.Scan(batch, req.(*proto.ScanRequest), resp.(*proto.ScanResponse))
+		default:
+			reply.SetGoError(util.Errorf("unsupported method in batch: %s", method))
+			return false
+		}
+		if err := resp.Header().GoError(); err != nil {
+			reply.SetGoError(err)
+			return false
+		}
+		resp.Header().Timestamp = timestamp
+		reply.Add(resp)
+	}
+	return true
+}
+
 // ReapQueue destructively queries messages from a delivery inbox
 // queue. This method must be called from within a transaction.
 func (r *Range) ReapQueue(batch engine.Engine, args *proto.ReapQueueRequest, reply *proto.ReapQueueResponse) {
@@ -980,7 +1611,7 @@ func (r *Range) InternalRangeLookup(batch engine.Engine, args *proto.InternalRan
 	// MaxRanges.
 	metaPrefix := proto.Key(args.Key[:len(engine.KeyMeta1Prefix)])
 	nextKey := proto.Key(args.Key).Next()
-	kvs, err := engine.MVCCScan(batch, nextKey, metaPrefix.PrefixEnd(), rangeCount, args.Timestamp, args.Txn)
+	kvs, err := engine.MVCCScan(batch, nextKey, metaPrefix.PrefixEnd(), rangeCount, args.Timestamp, args.ReadConsistency == proto.CONSISTENT, args.Txn)
 	if err != nil {
 		reply.SetGoError(err)
 		return
@@ -1098,9 +1729,7 @@ func (r *Range) InternalPushTxn(batch engine.Engine, args *proto.InternalPushTxn
 		if reply.PusheeTxn.Epoch < args.PusheeTxn.Epoch {
 			reply.PusheeTxn.Epoch = args.PusheeTxn.Epoch
 		}
-		if reply.PusheeTxn.Timestamp.Less(args.PusheeTxn.Timestamp) {
-			reply.PusheeTxn.Timestamp = args.PusheeTxn.Timestamp
-		}
+		reply.PusheeTxn.Timestamp.Forward(args.PusheeTxn.Timestamp)
 		if reply.PusheeTxn.Priority < args.PusheeTxn.Priority {
 			reply.PusheeTxn.Priority = args.PusheeTxn.Priority
 		}
@@ -1182,8 +1811,7 @@ func (r *Range) InternalPushTxn(batch engine.Engine, args *proto.InternalPushTxn
 		reply.PusheeTxn.Status = proto.ABORTED
 	} else {
 		// Otherwise, update timestamp to be one greater than the request's timestamp.
-		reply.PusheeTxn.Timestamp = args.Timestamp
-		reply.PusheeTxn.Timestamp.Logical++
+		reply.PusheeTxn.Timestamp = args.Timestamp.Next()
 	}
 
 	// Persist the pushed transaction using zero timestamp for inline value.
@@ -1202,6 +1830,14 @@ func (r *Range) InternalResolveIntent(batch engine.Engine, ms *engine.MVCCStats,
 		reply.SetGoError(util.Errorf("no transaction specified to InternalResolveIntent"))
 		return
 	}
+	if args.GCTxn {
+		reply.SetGoError(r.gcTxnRecord(batch, ms, args.Key, args.Txn))
+		return
+	}
+	if len(args.Intents) > 0 {
+		reply.SetGoError(r.resolveIntentSpans(batch, ms, args))
+		return
+	}
 	if len(args.EndKey) == 0 || bytes.Equal(args.Key, args.EndKey) {
 		reply.SetGoError(engine.MVCCResolveWriteIntent(batch, ms, args.Key, args.Txn))
 	} else {
@@ -1210,10 +1846,62 @@ func (r *Range) InternalResolveIntent(batch engine.Engine, ms *engine.MVCCStats,
 	}
 }
 
+// resolveIntentSpans resolves the intent spans listed in args. Each
+// span's addressed keys must lie within the request's key range, which
+// the range has verified it holds. A span which doesn't is an error
+// rather than being skipped: the coordinator removes the transaction
+// record once its spans are resolved, so none may go unresolved.
+func (r *Range) resolveIntentSpans(batch engine.Engine, ms *engine.MVCCStats, args *proto.InternalResolveIntentRequest) error {
+	bounds := proto.KeyRange{Start: engine.KeyAddress(args.Key), End: engine.KeyAddress(args.EndKey)}
+	for _, span := range args.Intents {
+		keys := proto.KeyRange{Start: engine.KeyAddress(span.Key), End: engine.KeyAddress(span.EndKey)}
+		if !bounds.ContainsRange(keys) {
+			return util.Errorf("intent span %q-%q lies outside of request key range %q-%q",
+				span.Key, span.EndKey, args.Key, args.EndKey)
+		}
+	}
+	for _, span := range args.Intents {
+		if len(span.EndKey) == 0 {
+			if err := engine.MVCCResolveWriteIntent(batch, ms, span.Key, args.Txn); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := engine.MVCCResolveWriteIntentRange(batch, ms, span.Key, span.EndKey, 0, args.Txn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gcTxnRecord removes the record of the supplied transaction, which
+// must be addressed by the transaction's key. Only records of
+// committed or aborted transactions may be removed; a pending record
+// is still needed by its coordinator and by concurrent pushers.
+func (r *Range) gcTxnRecord(batch engine.Engine, ms *engine.MVCCStats, key proto.Key, txn *proto.Transaction) error {
+	if !key.Equal(txn.Key) {
+		return util.Errorf("transaction record must be addressed by transaction key %q; got %q", txn.Key, key)
+	}
+	txnKey := engine.MakeKey(engine.KeyLocalTransactionPrefix, txn.Key, txn.ID)
+	existTxn := &proto.Transaction{}
+	ok, err := engine.MVCCGetProto(batch, txnKey, proto.ZeroTimestamp, nil, existTxn)
+	if err != nil || !ok {
+		return err
+	}
+	if existTxn.Status == proto.PENDING {
+		return proto.NewTransactionStatusError(existTxn, "cannot remove record of pending transaction")
+	}
+	return engine.MVCCDelete(batch, ms, txnKey, proto.ZeroTimestamp, nil)
+}
+
 // InternalSnapshotCopy scans the key range specified by start key through
 // end key up to some maximum number of results from the given snapshot_id.
-// It will create a snapshot if snapshot_id is empty.
-func (r *Range) InternalSnapshotCopy(e engine.Engine, args *proto.InternalSnapshotCopyRequest, reply *proto.InternalSnapshotCopyResponse) {
+// It will create a snapshot if snapshot_id is empty. If args.MaxBytes is
+// set, the scan also stops once the rows returned reach that size. When
+// the scan stops short of the end key, reply.ResumeKey is set to the
+// first key not returned. The rows are checksummed in reply.Checksum.
+// The snapshot is released once a scan returns no rows.
+func (r *Range) InternalSnapshotCopy(args *proto.InternalSnapshotCopyRequest, reply *proto.InternalSnapshotCopyResponse) {
 	if len(args.SnapshotID) == 0 {
 		snapshotID, err := r.rm.CreateSnapshot()
 		if err != nil {
@@ -1222,17 +1910,34 @@ func (r *Range) InternalSnapshotCopy(e engine.Engine, args *proto.InternalSnapsh
 		}
 		args.SnapshotID = snapshotID
 	}
+	snap, err := r.rm.GetSnapshot(args.SnapshotID)
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
 
-	kvs, err := engine.ScanSnapshot(e, proto.EncodedKey(args.Key), proto.EncodedKey(args.EndKey), args.MaxResults, args.SnapshotID)
+	var kvs []proto.RawKeyValue
+	var size int64
+	err = snap.Iterate(proto.EncodedKey(args.Key), proto.EncodedKey(args.EndKey), func(kv proto.RawKeyValue) (bool, error) {
+		if (args.MaxResults != 0 && int64(len(kvs)) >= args.MaxResults) ||
+			(args.MaxBytes != 0 && size >= args.MaxBytes) {
+			reply.ResumeKey = proto.Key(kv.Key)
+			return true, nil
+		}
+		kvs = append(kvs, kv)
+		size += int64(len(kv.Key) + len(kv.Value))
+		return false, nil
+	})
 	if err != nil {
 		reply.SetGoError(err)
 		return
 	}
 	if len(kvs) == 0 {
-		err = e.ReleaseSnapshot(args.SnapshotID)
+		err = r.rm.ReleaseSnapshot(args.SnapshotID)
 	}
 
 	reply.Rows = kvs
+	reply.Checksum = proto.SnapshotChecksum(kvs)
 	reply.SnapshotID = args.SnapshotID
 	reply.SetGoError(err)
 }
@@ -1247,6 +1952,162 @@ func (r *Range) InternalMerge(batch engine.Engine, ms *engine.MVCCStats, args *p
 	reply.SetGoError(err)
 }
 
+// InternalConditionalPutInline sets the inline (non-MVCC) value of a
+// key if its existing inline value matches the expected value. Inline
+// values are not versioned, so the operation can't be transactional.
+func (r *Range) InternalConditionalPutInline(batch engine.Engine, ms *engine.MVCCStats, args *proto.InternalConditionalPutInlineRequest, reply *proto.InternalConditionalPutInlineResponse) {
+	if args.Txn != nil {
+		reply.SetGoError(util.Errorf("inline put to %q cannot be transactional", args.Key))
+		return
+	}
+	err := engine.MVCCConditionalPutInline(batch, ms, args.Key, args.Value, args.ExpValue)
+	reply.SetGoError(err)
+}
+
+// InternalDeleteInline clears the inline (non-MVCC) value of a key,
+// optionally only if it matches the expected value.
+func (r *Range) InternalDeleteInline(batch engine.Engine, ms *engine.MVCCStats, args *proto.InternalDeleteInlineRequest, reply *proto.InternalDeleteInlineResponse) {
+	if args.Txn != nil {
+		reply.SetGoError(util.Errorf("inline delete of %q cannot be transactional", args.Key))
+		return
+	}
+	err := engine.MVCCDeleteInline(batch, ms, args.Key, args.ExpValue)
+	reply.SetGoError(err)
+}
+
+// InternalComputeChecksum snapshots the range's data and starts
+// computing its checksum in the background, within the byte rate
+// budget of --consistency_check_rate. The checksum is held under
+// args.ChecksumID, replacing any earlier, unverified checksum. Replicas
+// other than the proposer report their checksum as soon as it's
+// computed; the proposer reports its own from the consistency queue.
+func (r *Range) InternalComputeChecksum(args *proto.InternalComputeChecksumRequest, reply *proto.InternalComputeChecksumResponse) {
+	snap, err := r.rm.Engine().NewSnapshot()
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	c := &replicaChecksum{
+		id:      args.ChecksumID,
+		done:    make(chan struct{}),
+		report:  args.Replica.StoreID != r.rm.StoreID(),
+		reports: map[int32]uint32{},
+	}
+	r.Lock()
+	r.checksum = c
+	r.Unlock()
+	go r.computeChecksum(c, snap)
+}
+
+// InternalVerifyChecksum tallies the checksum reported by the replica
+// named in the header. The report which gives a majority of the
+// range's replicas the same checksum is recorded in the batch as the
+// range's last verified timestamp, and this replica's checksum is
+// compared with the majority's as soon as it's available. Reports
+// arriving after the majority are ignored.
+func (r *Range) InternalVerifyChecksum(batch engine.Engine, args *proto.InternalVerifyChecksumRequest, reply *proto.InternalVerifyChecksumResponse) {
+	r.Lock()
+	if r.Desc.FindReplica(args.Replica.StoreID) == nil {
+		r.Unlock()
+		reply.SetGoError(util.Errorf("store %d holds no replica of range %d", args.Replica.StoreID, r.Desc.RaftID))
+		return
+	}
+	c := r.checksum
+	if c == nil || c.id != args.ChecksumID || c.agreed != nil {
+		r.Unlock()
+		// The check may have been decided already, or the replica may have
+		// been added or restarted since the checksum was computed.
+		if log.V(1) {
+			log.Infof("range %d: ignoring report for checksum %s from store %d",
+				r.Desc.RaftID, args.ChecksumID, args.Replica.StoreID)
+		}
+		return
+	}
+	agreed := r.tallyChecksum(c, args.Replica.StoreID, args.Checksum)
+	r.Unlock()
+	if !agreed {
+		return
+	}
+	// Replicas decide agreement independently, and one which ignored the
+	// reports (see above) never does, so the timestamp is written
+	// directly to the engine as this replica's own state rather than as
+	// part of the replicated command.
+	if err := engine.MVCCPutProto(r.rm.Engine(), nil, engine.RangeLastVerifiedKey(r.Desc.RaftID),
+		proto.ZeroTimestamp, nil, &args.Timestamp); err != nil {
+		log.Warningf("range %d: unable to record last verified timestamp: %s", r.Desc.RaftID, err)
+	}
+	r.maybeVerifyChecksum(c)
+}
+
+// InternalRecomputeStats recomputes the range's MVCC stats by scanning
+// its data and compares them with the persisted stats, which are
+// maintained incrementally. Unless this is a dry run, any difference
+// is merged into the range and store stats to correct them.
+func (r *Range) InternalRecomputeStats(batch engine.Engine, ms *engine.MVCCStats, args *proto.InternalRecomputeStatsRequest, reply *proto.InternalRecomputeStatsResponse) {
+	if args.Txn != nil {
+		reply.SetGoError(util.Errorf("cannot recompute stats of range %d in a transaction", r.Desc.RaftID))
+		return
+	}
+	actual, err := engine.MVCCComputeStats(batch, r.Desc.StartKey, r.Desc.EndKey)
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	persisted, err := engine.MVCCGetRangeStats(batch, r.Desc.RaftID)
+	if err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	actual.Subtract(persisted)
+	if actual == (engine.MVCCStats{}) {
+		return
+	}
+	reply.Mismatch = true
+	log.Warningf("range %d: persisted stats differ from recomputed stats by %+v", r.Desc.RaftID, actual)
+	if !args.DryRun {
+		ms.Add(&actual)
+	}
+}
+
+// InternalGC removes the MVCC versions listed in the request, which
+// the range leader's GC queue has found to be expired, and records
+// the range's GC metadata if supplied.
+func (r *Range) InternalGC(batch engine.Engine, ms *engine.MVCCStats, args *proto.InternalGCRequest, reply *proto.InternalGCResponse) {
+	if args.Txn != nil {
+		reply.SetGoError(util.Errorf("cannot garbage collect range %d in a transaction", r.Desc.RaftID))
+		return
+	}
+	if err := engine.MVCCGarbageCollect(batch, ms, args.Keys); err != nil {
+		reply.SetGoError(err)
+		return
+	}
+	if !args.RangeTombstoneExpiration.Equal(proto.ZeroTimestamp) {
+		if _, err := engine.MVCCGarbageCollectRangeTombstones(batch, ms, r.Desc.StartKey, r.Desc.EndKey,
+			args.RangeTombstoneExpiration); err != nil {
+			reply.SetGoError(err)
+			return
+		}
+	}
+	if args.GCMeta != nil {
+		if err := engine.MVCCPutProto(batch, nil, engine.RangeGCMetadataKey(r.Desc.RaftID),
+			proto.ZeroTimestamp, nil, args.GCMeta); err != nil {
+			reply.SetGoError(err)
+		}
+	}
+}
+
+// GetGCMetadata returns the GC metadata recorded by the range's last
+// GC pass. If the range has never been garbage collected, the
+// metadata's LastGCNanos is zero.
+func (r *Range) GetGCMetadata() (*proto.GCMetadata, error) {
+	gcMeta := proto.NewGCMetadata()
+	if _, err := engine.MVCCGetProto(r.rm.Engine(), engine.RangeGCMetadataKey(r.Desc.RaftID),
+		proto.ZeroTimestamp, nil, gcMeta); err != nil {
+		return nil, err
+	}
+	return gcMeta, nil
+}
+
 // splitTrigger is called on a successful commit of an AdminSplit
 // transaction. It copies the response cache for the new range and
 // recomputes stats for both the existing, updated range and the new
@@ -1257,15 +2118,26 @@ func (r *Range) splitTrigger(batch engine.Engine, split *proto.SplitTrigger) err
 		return util.Errorf("range does not match splits: %q-%q + %q-%q != %q-%q", split.UpdatedDesc.StartKey,
 			split.UpdatedDesc.EndKey, split.NewDesc.StartKey, split.NewDesc.EndKey, r.Desc.StartKey, r.Desc.EndKey)
 	}
+	if !split.UpdatedDesc.NewerThan(r.Desc) || !split.NewDesc.NewerThan(r.Desc) {
+		return util.Errorf("split descriptors at generation %d-%d do not supersede range at generation %d",
+			split.UpdatedDesc.Generation, split.NewDesc.Generation, r.Desc.Generation)
+	}
+
+	// Split range tombstones spanning the split key so each range
+	// addresses the tombstones covering its keys. This precedes the
+	// stats computation below, which counts the split tombstones.
+	if err := engine.MVCCSplitRangeTombstones(batch, split.NewDesc.StartKey); err != nil {
+		return util.Errorf("unable to split range tombstones: %s", err)
+	}
 
 	// Compute stats for new range.
-	ms, err := engine.MVCCComputeStats(r.rm.Engine(), split.NewDesc.StartKey, split.NewDesc.EndKey)
+	newMS, err := engine.MVCCComputeStats(batch, split.NewDesc.StartKey, split.NewDesc.EndKey)
 	if err != nil {
 		return util.Errorf("unable to compute stats for new range after split: %s", err)
 	}
-	ms.SetStats(batch, split.NewDesc.RaftID, 0)
+	newMS.SetStats(batch, split.NewDesc.RaftID, 0)
 	// Compute stats for updated range.
-	ms, err = engine.MVCCComputeStats(r.rm.Engine(), split.UpdatedDesc.StartKey, split.UpdatedDesc.EndKey)
+	ms, err := engine.MVCCComputeStats(batch, split.UpdatedDesc.StartKey, split.UpdatedDesc.EndKey)
 	if err != nil {
 		return util.Errorf("unable to compute stats for updated range after split: %s", err)
 	}
@@ -1280,10 +2152,18 @@ func (r *Range) splitTrigger(batch engine.Engine, split *proto.SplitTrigger) err
 	// updates the EndKey of the updated range and also adds the
 	// new range to the store's range map.
 	newRng := NewRange(&split.NewDesc, r.rm)
+	atomic.StoreInt64(&newRng.rtCount, newMS.RangeTombstoneCount)
+	atomic.StoreInt64(&r.rtCount, ms.RangeTombstoneCount)
+	// Both counts were just computed from the data.
+	atomic.StoreInt32(&newRng.rtUnknown, 0)
+	atomic.StoreInt32(&r.rtUnknown, 0)
 	// Write-lock the mutex to protect Desc, as SplitRange will modify
 	// Desc.EndKey.
 	r.Lock()
 	defer r.Unlock()
+	// Scans protecting their read timestamp may continue into the new
+	// range, so it inherits the protections.
+	newRng.gcProtections = append(gcProtections(nil), r.gcProtections...)
 	return r.rm.SplitRange(r, newRng)
 }
 
@@ -1307,15 +2187,13 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 	// other commands.
 	splitKey := proto.Key(args.SplitKey)
 	if len(splitKey) == 0 {
-		snapshotID, err := r.rm.CreateSnapshot()
+		snap, err := r.rm.Engine().NewSnapshot()
 		if err != nil {
 			reply.SetGoError(util.Errorf("unable to create snapshot: %s", err))
 			return
 		}
-		splitKey, err = engine.MVCCFindSplitKey(r.rm.Engine(), r.Desc.RaftID, r.Desc.StartKey, r.Desc.EndKey, snapshotID)
-		if releaseErr := r.rm.Engine().ReleaseSnapshot(snapshotID); releaseErr != nil {
-			log.Errorf("unable to release snapshot: %s", releaseErr)
-		}
+		splitKey, err = engine.MVCCFindSplitKey(snap, r.Desc.RaftID, r.Desc.StartKey, r.Desc.EndKey)
+		snap.Stop()
 		if err != nil {
 			reply.SetGoError(util.Errorf("unable to determine split key: %s", err))
 			return
@@ -1331,8 +2209,22 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 		reply.SetGoError(util.Errorf("cannot split range at key %q", splitKey))
 		return
 	}
-	if splitKey.Equal(r.Desc.StartKey) || splitKey.Equal(r.Desc.EndKey) {
-		reply.SetGoError(util.Errorf("range has already been split by key %q", splitKey))
+	if splitKey.Equal(r.Desc.StartKey) {
+		// The range has already been split at splitKey, likely by an
+		// earlier attempt at the same split. Report the existing split
+		// so that retries are idempotent. The descriptor of the range
+		// to the left is read from its addressing record, as that
+		// range may not be on this store.
+		leftDesc := proto.RangeDescriptor{}
+		if ok, _, err := r.rm.DB().GetProto(engine.RangeMetaKey(splitKey), &leftDesc); err != nil {
+			reply.SetGoError(util.Errorf("unable to look up range ending at split key %q: %s", splitKey, err))
+			return
+		} else if !ok {
+			reply.SetGoError(util.Errorf("no range ends at split key %q", splitKey))
+			return
+		}
+		reply.LeftDesc = leftDesc
+		reply.RightDesc = *r.Desc
 		return
 	}
 
@@ -1343,9 +2235,13 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 		return
 	}
 
-	// Init updated version of existing range descriptor.
+	// Init updated version of existing range descriptor. Both halves of
+	// the split supersede the original descriptor.
+	origDesc := *r.Desc
 	updatedDesc := *r.Desc
 	updatedDesc.EndKey = splitKey
+	updatedDesc.Generation++
+	newDesc.Generation = updatedDesc.Generation
 
 	log.Infof("initiating a split of range %d %q-%q at key %q", r.Desc.RaftID,
 		proto.Key(r.Desc.StartKey), proto.Key(r.Desc.EndKey), splitKey)
@@ -1354,18 +2250,19 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 		Name: fmt.Sprintf("split range %d at %q", r.Desc.RaftID, splitKey),
 	}
 	if err = r.rm.DB().RunTransaction(txnOpts, func(txn *client.KV) error {
-		// Create range descriptor for second half of split.
-		// Note that this put must go first in order to locate the
-		// transaction record on the correct range.
-		if err := txn.PreparePutProto(engine.RangeDescriptorKey(newDesc.StartKey), newDesc); err != nil {
+		// Create range descriptor for second half of split, which must
+		// not already exist. Note that this put must go first in order
+		// to locate the transaction record on the correct range.
+		if err := txn.PrepareConditionalPutProto(engine.RangeDescriptorKey(newDesc.StartKey), newDesc, nil); err != nil {
 			return err
 		}
-		// Update existing range descriptor for first half of split.
-		if err := txn.PreparePutProto(engine.RangeDescriptorKey(updatedDesc.StartKey), &updatedDesc); err != nil {
+		// Update existing range descriptor for first half of split,
+		// provided it hasn't changed since the split began.
+		if err := condPutProto(txn, engine.RangeDescriptorKey(updatedDesc.StartKey), &updatedDesc, &origDesc); err != nil {
 			return err
 		}
 		// Update range descriptor addressing record(s).
-		if err := SplitRangeAddressing(txn, newDesc, &updatedDesc); err != nil {
+		if err := SplitRangeAddressing(txn, &origDesc, newDesc, &updatedDesc); err != nil {
 			return err
 		}
 		// End the transaction manually, instead of letting RunTransaction
@@ -1379,6 +2276,13 @@ func (r *Range) AdminSplit(args *proto.AdminSplitRequest, reply *proto.AdminSpli
 			},
 		}, &proto.EndTransactionResponse{})
 	}); err != nil {
+		if _, ok := err.(*proto.ConditionFailedError); ok {
+			reply.SetGoError(&rangeDescriptorChangedError{raftID: origDesc.RaftID, op: fmt.Sprintf("split at key %q", splitKey)})
+			return
+		}
 		reply.SetGoError(util.Errorf("split at key %q failed: %s", splitKey, err))
+		return
 	}
+	reply.LeftDesc = updatedDesc
+	reply.RightDesc = *newDesc
 }
@@ -0,0 +1,112 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/proto"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// RangeEventType identifies the change to a store's ranges described
+// by a RangeEvent.
+type RangeEventType int
+
+const (
+	// RangeEventAdd indicates that a replica of a range was added to
+	// the store.
+	RangeEventAdd RangeEventType = iota
+	// RangeEventRemove indicates that a replica of a range was removed
+	// from the store.
+	RangeEventRemove
+	// RangeEventSplit indicates that a range on the store was split in
+	// two.
+	RangeEventSplit
+	// RangeEventCorrupt indicates that a replica of a range on the
+	// store was found to be corrupted and has stopped serving.
+	RangeEventCorrupt
+)
+
+// String implements the fmt.Stringer interface.
+func (t RangeEventType) String() string {
+	switch t {
+	case RangeEventAdd:
+		return "add"
+	case RangeEventRemove:
+		return "remove"
+	case RangeEventSplit:
+		return "split"
+	case RangeEventCorrupt:
+		return "corrupt"
+	}
+	return fmt.Sprintf("RangeEventType(%d)", int(t))
+}
+
+// A RangeEvent describes a change to the set of ranges on a store.
+// Descriptors are copies and may be retained by the recipient.
+type RangeEvent struct {
+	Type    RangeEventType
+	StoreID int32
+	// Desc is the descriptor of the added or removed range or, for a
+	// split, of the original range after it was shortened.
+	Desc proto.RangeDescriptor
+	// NewDesc is the descriptor of the range created by a split. It's
+	// nil for other events.
+	NewDesc *proto.RangeDescriptor
+}
+
+// A RangeEventCallback is invoked with each change to a store's
+// ranges. Callbacks run synchronously on the goroutine making the
+// change, which for splits is the one applying the split to the
+// range, so they must not block and must not call back into the
+// store or the range. Callbacks which need to do more, e.g. to
+// invalidate a cache, should hand the event off to a goroutine.
+type RangeEventCallback func(event *RangeEvent)
+
+// RegisterRangeEventCallback registers a callback to be invoked
+// whenever ranges are added to, removed from or split on the store, or
+// a replica on the store is found to be corrupted.
+// Ranges loaded when the store starts are not reported.
+func (s *Store) RegisterRangeEventCallback(cb RangeEventCallback) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rangeEventCallbacks = append(s.rangeEventCallbacks, cb)
+}
+
+// notifyRangeEvent invokes the registered range event callbacks with
+// deep copies of the supplied descriptors, so that callbacks may keep
+// the event without sharing the replica or key slices of a live range
+// descriptor. The store's lock must not be held.
+func (s *Store) notifyRangeEvent(typ RangeEventType, desc, newDesc *proto.RangeDescriptor) {
+	s.mu.RLock()
+	callbacks := s.rangeEventCallbacks
+	s.mu.RUnlock()
+	if len(callbacks) == 0 {
+		return
+	}
+	event := &RangeEvent{
+		Type:    typ,
+		StoreID: s.StoreID(),
+		Desc:    *gogoproto.Clone(desc).(*proto.RangeDescriptor),
+	}
+	if newDesc != nil {
+		event.NewDesc = gogoproto.Clone(newDesc).(*proto.RangeDescriptor)
+	}
+	for _, cb := range callbacks {
+		cb(event)
+	}
+}
@@ -121,7 +121,7 @@ func TestRangeContains(t *testing.T) {
 	}
 
 	clock := hlc.NewClock(hlc.UnixNano)
-	r := NewRange(desc, NewStore(clock, nil, nil, nil))
+	r := NewRange(desc, NewStore(clock, engine.NewInMem(proto.Attributes{}, 1<<20), nil, nil))
 	if !r.ContainsKey(proto.Key("aa")) {
 		t.Errorf("expected range to contain key \"aa\"")
 	}
@@ -137,6 +137,28 @@ func TestRangeContains(t *testing.T) {
 	}
 }
 
+// TestRangeDescriptor verifies that Descriptor returns a copy of the
+// range descriptor which can be modified without affecting the range.
+func TestRangeDescriptor(t *testing.T) {
+	desc := &proto.RangeDescriptor{
+		RaftID:   1,
+		StartKey: proto.Key("a"),
+		EndKey:   proto.Key("b"),
+		Replicas: []proto.Replica{{NodeID: 1, StoreID: 1}},
+	}
+	clock := hlc.NewClock(hlc.UnixNano)
+	r := NewRange(desc, NewStore(clock, engine.NewInMem(proto.Attributes{}, 1<<20), nil, nil))
+	descCopy := r.Descriptor()
+	if !reflect.DeepEqual(descCopy, desc) {
+		t.Fatalf("expected descriptor %+v; got %+v", desc, descCopy)
+	}
+	descCopy.EndKey[0] = 'z'
+	descCopy.Replicas[0].StoreID = 2
+	if !desc.EndKey.Equal(proto.Key("b")) || desc.Replicas[0].StoreID != 1 {
+		t.Errorf("modifying the copy changed the range descriptor: %+v", desc)
+	}
+}
+
 // TestRangeGossipFirstRange verifies that the first range gossips its
 // location and the cluster ID.
 func TestRangeGossipFirstRange(t *testing.T) {
@@ -149,9 +171,15 @@ func TestRangeGossipFirstRange(t *testing.T) {
 				log.Warningf("still waiting for first range gossip of key %s...", key)
 				return false
 			}
-			if key == gossip.KeyFirstRangeDescriptor &&
-				!reflect.DeepEqual(info.(proto.RangeDescriptor), testRangeDescriptor) {
-				t.Errorf("expected gossipped range locations to be equal: %+v vs %+v", info.(proto.RangeDescriptor), testRangeDescriptor)
+			if key == gossip.KeyFirstRangeDescriptor {
+				desc := proto.RangeDescriptor{}
+				if err := g.GetInfoProto(key, &desc); err != nil {
+					t.Fatal(err)
+				}
+				if desc.RaftID != testRangeDescriptor.RaftID || !desc.StartKey.Equal(testRangeDescriptor.StartKey) ||
+					!desc.EndKey.Equal(testRangeDescriptor.EndKey) || !reflect.DeepEqual(desc.Replicas, testRangeDescriptor.Replicas) {
+					t.Errorf("expected gossipped range locations to be equal: %+v vs %+v", desc, testRangeDescriptor)
+				}
 			}
 			if key == gossip.KeyClusterID && info.(string) != s.Ident.ClusterID {
 				t.Errorf("expected gossipped cluster ID %s; got %s", s.Ident.ClusterID, info.(string))
@@ -206,7 +234,7 @@ func TestRangeGossipConfigWithMultipleKeyPrefixes(t *testing.T) {
 	}
 	req := &proto.PutRequest{
 		RequestHeader: proto.RequestHeader{Key: key, Timestamp: proto.MinTimestamp},
-		Value:         proto.Value{Bytes: data},
+		Value:         proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}},
 	}
 	reply := &proto.PutResponse{}
 
@@ -246,7 +274,7 @@ func TestRangeGossipConfigUpdates(t *testing.T) {
 	}
 	req := &proto.PutRequest{
 		RequestHeader: proto.RequestHeader{Key: key, Timestamp: proto.MinTimestamp},
-		Value:         proto.Value{Bytes: data},
+		Value:         proto.Value{ValuePayload: proto.ValuePayload{Bytes: data}},
 	}
 	reply := &proto.PutResponse{}
 
@@ -374,7 +402,7 @@ func putArgs(key, value []byte, raftID int64, storeID int32) (*proto.PutRequest,
 			Replica:   proto.Replica{StoreID: storeID},
 		},
 		Value: proto.Value{
-			Bytes: value,
+			ValuePayload: proto.ValuePayload{Bytes: value},
 		},
 	}
 	reply := &proto.PutResponse{}
@@ -590,6 +618,111 @@ func TestRangeUpdateTSCache(t *testing.T) {
 	}
 }
 
+// TestRangeInconsistentRead verifies that inconsistent reads return
+// data without updating the timestamp cache, and that they're refused
+// for writes and transactions.
+func TestRangeInconsistentRead(t *testing.T) {
+	s, rng, mc, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+	key := []byte("a")
+	pArgs, pReply := putArgs(key, []byte("value"), 1, s.StoreID())
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	mc.Set((1 * time.Second).Nanoseconds())
+	gArgs, gReply := getArgs(key, 1, s.StoreID())
+	gArgs.Timestamp = clock.Now()
+	gArgs.ReadConsistency = proto.INCONSISTENT
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if gReply.Value == nil || !bytes.Equal(gReply.Value.Bytes, []byte("value")) {
+		t.Errorf("expected value %q; got %+v", "value", gReply.Value)
+	}
+	if rTS, _ := rng.tsCache.GetMax(proto.Key(key), nil, proto.NoTxnMD5); !rTS.Less(gArgs.Timestamp) {
+		t.Errorf("expected inconsistent read to leave the timestamp cache untouched; got rTS=%s", rTS)
+	}
+
+	// An intent is skipped in favor of the value beneath it.
+	pArgs, pReply = putArgs(key, []byte("intent"), 1, s.StoreID())
+	pArgs.Txn = newTransaction("test", key, 1, proto.SERIALIZABLE, clock)
+	pArgs.Timestamp = pArgs.Txn.Timestamp
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	gArgs, gReply = getArgs(key, 1, s.StoreID())
+	gArgs.Timestamp = clock.Now()
+	gArgs.ReadConsistency = proto.INCONSISTENT
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if gReply.Value == nil || !bytes.Equal(gReply.Value.Bytes, []byte("value")) {
+		t.Errorf("expected value %q beneath intent; got %+v", "value", gReply.Value)
+	}
+
+	pArgs, pReply = putArgs(key, []byte("value2"), 1, s.StoreID())
+	pArgs.Timestamp = clock.Now()
+	pArgs.ReadConsistency = proto.INCONSISTENT
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err == nil {
+		t.Error("expected error on inconsistent write")
+	}
+
+	gArgs, gReply = getArgs(key, 1, s.StoreID())
+	gArgs.Txn = newTransaction("test", key, 1, proto.SERIALIZABLE, clock)
+	gArgs.Timestamp = gArgs.Txn.Timestamp
+	gArgs.ReadConsistency = proto.INCONSISTENT
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err == nil {
+		t.Error("expected error on transactional inconsistent read")
+	}
+}
+
+// TestRangeScanResumeKey verifies that a scan which stops at its
+// maximum number of results returns the key at which to resume, and
+// that a scan reaching its end key does not.
+func TestRangeScanResumeKey(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+	for _, key := range []string{"a", "b", "c"} {
+		pArgs, pReply := putArgs([]byte(key), []byte("value"), 1, s.StoreID())
+		pArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		start      string
+		maxResults int64
+		expKeys    []string
+		expResume  proto.Key
+	}{
+		{"a", 2, []string{"a", "b"}, proto.Key("b").Next()},
+		{"b", 2, []string{"b", "c"}, proto.Key("c").Next()},
+		{"c", 2, []string{"c"}, nil},
+		{"a", 0, []string{"a", "b", "c"}, nil},
+	}
+	for i, test := range testCases {
+		sArgs, sReply := scanArgs([]byte(test.start), []byte("d"), 1, s.StoreID())
+		sArgs.MaxResults = test.maxResults
+		sArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Scan, sArgs, sReply, true); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		var keys []string
+		for _, kv := range sReply.Rows {
+			keys = append(keys, string(kv.Key))
+		}
+		if !reflect.DeepEqual(keys, test.expKeys) {
+			t.Errorf("%d: expected keys %q; got %q", i, test.expKeys, keys)
+		}
+		if !sReply.ResumeKey.Equal(test.expResume) {
+			t.Errorf("%d: expected resume key %q; got %q", i, test.expResume, sReply.ResumeKey)
+		}
+	}
+}
+
 // TestRangeCommandQueue verifies that reads/writes must wait for
 // pending commands to complete through Raft before being executed on
 // range.
@@ -835,6 +968,234 @@ func TestRangeIdempotence(t *testing.T) {
 	}
 }
 
+// TestRangeDeleteRangeTombstone verifies that DeleteRange writes a
+// range tombstone when requested and rejects transactional use.
+func TestRangeDeleteRangeTombstone(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	for _, key := range []string{"a", "b", "c"} {
+		pArgs, pReply := putArgs([]byte(key), []byte("value"), 1, s.StoreID())
+		pArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	drArgs := proto.DeleteRangeArgs(proto.Key("a"), proto.Key("c"))
+	drArgs.RaftID = 1
+	drArgs.Replica = proto.Replica{StoreID: s.StoreID()}
+	drArgs.UseRangeTombstone = true
+	drArgs.Timestamp = clock.Now()
+	drArgs.Txn = &proto.Transaction{ID: []byte("txn")}
+	if err := rng.AddCmd(proto.DeleteRange, drArgs, &proto.DeleteRangeResponse{}, true); err == nil {
+		t.Fatal("expected error writing range tombstone in a txn")
+	}
+	drArgs.Txn = nil
+	drArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.DeleteRange, drArgs, &proto.DeleteRangeResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	sArgs, sReply := scanArgs([]byte("a"), []byte("d"), 1, s.StoreID())
+	sArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Scan, sArgs, sReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(sReply.Rows) != 1 || !bytes.Equal(sReply.Rows[0].Key, []byte("c")) {
+		t.Errorf("expected only key \"c\" to remain; got %+v", sReply.Rows)
+	}
+}
+
+// TestRangeBatch verifies that a non-transactional batch executes
+// its requests in order, each seeing the writes of the requests
+// before it, and that a failing request fails the entire batch.
+func TestRangeBatch(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	newBatch := func(reqs ...proto.Request) *proto.BatchRequest {
+		bArgs := &proto.BatchRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       proto.Key("a"),
+				EndKey:    proto.Key("d"),
+				Timestamp: clock.Now(),
+				RaftID:    1,
+				Replica:   proto.Replica{StoreID: s.StoreID()},
+			},
+		}
+		for _, req := range reqs {
+			bArgs.Add(req)
+		}
+		return bArgs
+	}
+
+	pArgs, _ := putArgs([]byte("a"), []byte("value"), 1, s.StoreID())
+	gArgs, _ := getArgs([]byte("a"), 1, s.StoreID())
+	iArgs, _ := incrementArgs([]byte("c"), 5, 1, s.StoreID())
+	sArgs, _ := scanArgs([]byte("a"), []byte("d"), 1, s.StoreID())
+	bReply := &proto.BatchResponse{}
+	if err := rng.AddCmd(proto.Batch, newBatch(pArgs, gArgs, iArgs, sArgs), bReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(bReply.Responses) != 4 {
+		t.Fatalf("expected 4 responses; got %d", len(bReply.Responses))
+	}
+	if gReply := bReply.Responses[1].GetGet(); gReply.Value == nil || !bytes.Equal(gReply.Value.Bytes, []byte("value")) {
+		t.Errorf("expected get to see the batch's put; got %+v", gReply.Value)
+	}
+	if iReply := bReply.Responses[2].GetIncrement(); iReply.NewValue != 5 {
+		t.Errorf("expected incremented value 5; got %d", iReply.NewValue)
+	}
+	if sReply := bReply.Responses[3].GetScan(); len(sReply.Rows) != 2 {
+		t.Errorf("expected scan to see both writes; got %+v", sReply.Rows)
+	}
+
+	// A failed conditional put fails the batch, so the preceding put
+	// isn't applied.
+	pArgs, _ = putArgs([]byte("b"), []byte("value"), 1, s.StoreID())
+	cpArgs := &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{Key: proto.Key("a")},
+		Value:         proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("new")}},
+		ExpValue:      &proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("wrong")}},
+	}
+	if err := rng.AddCmd(proto.Batch, newBatch(pArgs, cpArgs), &proto.BatchResponse{}, true); err == nil {
+		t.Fatal("expected batch with failing conditional put to fail")
+	}
+	gArgs, gReply := getArgs([]byte("b"), 1, s.StoreID())
+	gArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if gReply.Value != nil {
+		t.Errorf("expected put in failed batch not to be applied; got %+v", gReply.Value)
+	}
+
+	// Only batchable methods are permitted.
+	hArgs, _ := heartbeatArgs(&proto.Transaction{ID: []byte("txn")}, 1, s.StoreID())
+	if err := rng.AddCmd(proto.Batch, newBatch(hArgs), &proto.BatchResponse{}, true); err == nil {
+		t.Error("expected error executing heartbeat in batch")
+	}
+}
+
+// TestRangeGCProtection verifies that a read with ProtectUntil set
+// keeps the versions visible at its timestamp from being garbage
+// collected until the protection expires.
+func TestRangeGCProtection(t *testing.T) {
+	s, rng, manual, _, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	readTS := proto.Timestamp{WallTime: 2 * time.Second.Nanoseconds()}
+	gArgs, gReply := getArgs([]byte("a"), 1, s.StoreID())
+	gArgs.Timestamp = readTS
+	gArgs.ProtectUntil = 10 * time.Second.Nanoseconds()
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	key := proto.Key("a")
+	keys := []proto.EncodedKey{
+		engine.MVCCEncodeKey(key),
+		engine.MVCCEncodeVersionKey(key, proto.Timestamp{WallTime: 3 * time.Second.Nanoseconds()}),
+		engine.MVCCEncodeVersionKey(key, proto.Timestamp{WallTime: 2 * time.Second.Nanoseconds()}),
+		engine.MVCCEncodeVersionKey(key, proto.Timestamp{WallTime: 1 * time.Second.Nanoseconds()}),
+	}
+	value, err := gogoproto.Marshal(&proto.MVCCValue{Value: &proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("value")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]byte{nil, value, value, value}
+	policyFn := func(key proto.Key) *proto.GCPolicy {
+		return &proto.GCPolicy{TTLSeconds: 1}
+	}
+
+	// All but the newest version are older than the TTL, but the
+	// version at the protected timestamp must survive.
+	manual.Set(5 * time.Second.Nanoseconds())
+	toDelete := rng.newGarbageCollector(policyFn).Filter(keys, values)
+	if expDelete := []bool{false, false, false, true}; !reflect.DeepEqual(toDelete, expDelete) {
+		t.Errorf("expected deletions %v; got %v", expDelete, toDelete)
+	}
+
+	// Once the protection expires, it no longer applies.
+	manual.Set(10 * time.Second.Nanoseconds())
+	toDelete = rng.newGarbageCollector(policyFn).Filter(keys, values)
+	if expDelete := []bool{false, false, true, true}; !reflect.DeepEqual(toDelete, expDelete) {
+		t.Errorf("expected deletions %v; got %v", expDelete, toDelete)
+	}
+}
+
+// TestRangeSnapshotChunks verifies that snapshot copies may be bounded
+// by size, resumed from the returned resume key and verified via
+// their checksums.
+func TestRangeSnapshotChunks(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	for i := 0; i < 10; i++ {
+		pArgs, pReply := putArgs([]byte(fmt.Sprintf("key%02d", i)), bytes.Repeat([]byte("v"), 100), 1, s.StoreID())
+		pArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Copy the whole span in one chunk for comparison.
+	start := engine.MVCCEncodeKey(engine.KeyLocalPrefix.PrefixEnd())
+	iscArgs, iscReply := internalSnapshotCopyArgs(start, engine.KeyMax, 1000, "", 1, s.StoreID())
+	iscArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.InternalSnapshotCopy, iscArgs, iscReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := iscReply.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if len(iscReply.ResumeKey) != 0 {
+		t.Fatalf("expected no resume key; got %q", iscReply.ResumeKey)
+	}
+	expRows := iscReply.Rows
+	snapshotID := iscReply.SnapshotID
+
+	// Copy again in chunks of at most ~250 bytes, resuming each time.
+	var rows []proto.RawKeyValue
+	key := proto.Key(start)
+	for chunks := 0; ; chunks++ {
+		if chunks > len(expRows) {
+			t.Fatalf("snapshot copy failed to make progress after %d chunks", chunks)
+		}
+		iscArgs, iscReply = internalSnapshotCopyArgs(key, engine.KeyMax, 1000, snapshotID, 1, s.StoreID())
+		iscArgs.MaxBytes = 250
+		iscArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.InternalSnapshotCopy, iscArgs, iscReply, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := iscReply.Verify(); err != nil {
+			t.Fatal(err)
+		}
+		var size int
+		for _, kv := range iscReply.Rows[:len(iscReply.Rows)-1] {
+			size += len(kv.Key) + len(kv.Value)
+		}
+		if size >= 250 {
+			t.Errorf("chunk %d exceeds max bytes: %d", chunks, size)
+		}
+		rows = append(rows, iscReply.Rows...)
+		if len(iscReply.ResumeKey) == 0 {
+			break
+		}
+		key = iscReply.ResumeKey
+	}
+	if !reflect.DeepEqual(rows, expRows) {
+		t.Errorf("chunked snapshot copy doesn't match full copy:\n%+v\n%+v", rows, expRows)
+	}
+
+	// A corrupted chunk fails verification.
+	iscReply.Rows[0].Value[0]++
+	if err := iscReply.Verify(); err == nil {
+		t.Error("expected checksum mismatch for corrupted chunk")
+	}
+}
+
 // TestRangeSnapshot.
 func TestRangeSnapshot(t *testing.T) {
 	s, rng, _, clock, _ := createTestRangeWithClock(t)
@@ -874,7 +1235,7 @@ func TestRangeSnapshot(t *testing.T) {
 	}
 	snapshotID := iscReply.SnapshotID
 	expectedKey := engine.MVCCEncodeKey(key1)
-	expectedVal := getSerializedMVCCValue(&proto.Value{Bytes: val1})
+	expectedVal := getSerializedMVCCValue(&proto.Value{ValuePayload: proto.ValuePayload{Bytes: val1}})
 	if len(iscReply.Rows) != 4 ||
 		!bytes.Equal(iscReply.Rows[0].Key, expectedKey) ||
 		!bytes.Equal(iscReply.Rows[1].Value, expectedVal) {
@@ -894,7 +1255,7 @@ func TestRangeSnapshot(t *testing.T) {
 		t.Fatalf("error : %s", err)
 	}
 	expectedKey = engine.MVCCEncodeKey(key2)
-	expectedVal = getSerializedMVCCValue(&proto.Value{Bytes: val2})
+	expectedVal = getSerializedMVCCValue(&proto.Value{ValuePayload: proto.ValuePayload{Bytes: val2}})
 	if len(iscReply.Rows) != 4 ||
 		!bytes.Equal(iscReply.Rows[2].Key, expectedKey) ||
 		!bytes.Equal(iscReply.Rows[3].Value, expectedVal) {
@@ -912,7 +1273,7 @@ func TestRangeSnapshot(t *testing.T) {
 	}
 	snapshotID2 := iscReply.SnapshotID
 	expectedKey = engine.MVCCEncodeKey(key2)
-	expectedVal = getSerializedMVCCValue(&proto.Value{Bytes: val3})
+	expectedVal = getSerializedMVCCValue(&proto.Value{ValuePayload: proto.ValuePayload{Bytes: val3}})
 	// Expect one more mvcc version.
 	if len(iscReply.Rows) != 5 ||
 		!bytes.Equal(iscReply.Rows[2].Key, expectedKey) ||
@@ -940,6 +1301,13 @@ func TestRangeSnapshot(t *testing.T) {
 	if len(iscReply.Rows) != 0 {
 		t.Fatalf("error : %d", len(iscReply.Rows))
 	}
+
+	// Both snapshots were released by their empty final scans.
+	for _, id := range []string{snapshotID, snapshotID2} {
+		if _, err := s.GetSnapshot(id); err == nil {
+			t.Errorf("expected snapshot %s to be released", id)
+		}
+	}
 }
 
 // TestEndTransactionBeforeHeartbeat verifies that a transaction
@@ -1539,6 +1907,68 @@ func TestRangeStats(t *testing.T) {
 	verifyRangeStats(eng, rng.Desc.RaftID, expMS, t)
 }
 
+// TestRangeRecomputeStats verifies that InternalRecomputeStats detects
+// persisted stats which have drifted from the range's data, and
+// corrects them unless asked for a dry run.
+func TestRangeRecomputeStats(t *testing.T) {
+	s, rng, mc, clock, eng := createTestRangeWithClock(t)
+	defer s.Stop()
+	for i, key := range []string{"a", "a", "b"} {
+		mc.Set(int64(i+1) * 1E9)
+		pArgs, pReply := putArgs([]byte(key), []byte("value"), 1, s.StoreID())
+		pArgs.Timestamp = clock.Now()
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expMS, err := engine.MVCCComputeStats(eng, rng.Desc.StartKey, rng.Desc.EndKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expMS.GCBytesAge == 0 {
+		t.Fatal("expected overwritten value to contribute to GC bytes age")
+	}
+	verifyRangeStats(eng, rng.Desc.RaftID, expMS, t)
+
+	recompute := func(dryRun bool) bool {
+		args := &proto.InternalRecomputeStatsRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:       rng.Desc.StartKey,
+				Timestamp: clock.Now(),
+				RaftID:    rng.Desc.RaftID,
+				Replica:   proto.Replica{StoreID: s.StoreID()},
+			},
+			DryRun: dryRun,
+		}
+		reply := &proto.InternalRecomputeStatsResponse{}
+		if err := rng.AddCmd(proto.InternalRecomputeStats, args, reply, true); err != nil {
+			t.Fatal(err)
+		}
+		return reply.Mismatch
+	}
+	if recompute(false) {
+		t.Error("expected no mismatch for incrementally maintained stats")
+	}
+
+	// Skew the persisted stats.
+	if err := engine.MergeStat(eng, rng.Desc.RaftID, 0, engine.StatLiveBytes, 10); err != nil {
+		t.Fatal(err)
+	}
+	skewedMS := expMS
+	skewedMS.LiveBytes += 10
+	if !recompute(true) {
+		t.Error("expected mismatch for skewed stats")
+	}
+	verifyRangeStats(eng, rng.Desc.RaftID, skewedMS, t)
+	if !recompute(false) {
+		t.Error("expected mismatch for skewed stats")
+	}
+	verifyRangeStats(eng, rng.Desc.RaftID, expMS, t)
+	if recompute(false) {
+		t.Error("expected no mismatch for corrected stats")
+	}
+}
+
 // TestRemoteRaftCommand ensures that commands entering the raft
 // subsystem from other nodes are applied correctly.
 func TestRemoteRaftCommand(t *testing.T) {
@@ -1583,7 +2013,7 @@ func TestInternalMerge(t *testing.T) {
 	stringExpected := "abcd"
 
 	for _, str := range stringArgs {
-		mergeArgs, resp := internalMergeArgs(key, proto.Value{Bytes: []byte(str)}, 1, s.StoreID())
+		mergeArgs, resp := internalMergeArgs(key, proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte(str)}}, 1, s.StoreID())
 		if err := r.AddCmd(proto.InternalMerge, mergeArgs, resp, true); err != nil {
 			t.Fatalf("unexpected error from InternalMerge: %s", err.Error())
 		}
@@ -1601,6 +2031,167 @@ func TestInternalMerge(t *testing.T) {
 	}
 }
 
+// TestInternalConditionalPutInline verifies that inline conditional
+// puts and deletes are applied through raft, refuse to run within a
+// transaction and never write versioned values.
+func TestInternalConditionalPutInline(t *testing.T) {
+	s, r, _, _ := createTestRange(t)
+	defer s.Stop()
+
+	key := proto.Key("inlinekey")
+	header := proto.RequestHeader{
+		Key:     key,
+		RaftID:  1,
+		Replica: proto.Replica{StoreID: s.StoreID()},
+	}
+	value1, value2 := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("v1")}}, proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("v2")}}
+	cput := func(value proto.Value, expValue *proto.Value) error {
+		args := &proto.InternalConditionalPutInlineRequest{RequestHeader: header, Value: value, ExpValue: expValue}
+		return r.AddCmd(proto.InternalConditionalPutInline, args, &proto.InternalConditionalPutInlineResponse{}, true)
+	}
+
+	if err := cput(value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cput(value2, nil).(*proto.ConditionFailedError); !ok {
+		t.Error("expected condition failed error putting over an existing value")
+	}
+	if err := cput(value2, &value1); err != nil {
+		t.Fatal(err)
+	}
+	gArgs, gReply := getArgs(key, 1, s.StoreID())
+	if err := r.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if gReply.Value == nil || !bytes.Equal(gReply.Value.Bytes, value2.Bytes) {
+		t.Errorf("expected value %q; got %+v", value2.Bytes, gReply.Value)
+	}
+
+	// A transactional inline put is rejected.
+	txnArgs := &proto.InternalConditionalPutInlineRequest{RequestHeader: header, Value: value1, ExpValue: &value2}
+	txnArgs.Txn = newTransaction("test", key, 1, proto.SERIALIZABLE, s.Clock())
+	txnArgs.Timestamp = txnArgs.Txn.Timestamp
+	if err := r.AddCmd(proto.InternalConditionalPutInline, txnArgs, &proto.InternalConditionalPutInlineResponse{}, true); err == nil {
+		t.Error("expected error on transactional inline put")
+	}
+
+	// A versioned put to the inline key fails.
+	pArgs, pReply := putArgs(key, []byte("versioned"), 1, s.StoreID())
+	pArgs.Timestamp = s.Clock().Now()
+	if err := r.AddCmd(proto.Put, pArgs, pReply, true); err == nil {
+		t.Error("expected error on versioned put to inline key")
+	}
+
+	dArgs := &proto.InternalDeleteInlineRequest{RequestHeader: header, ExpValue: &value2}
+	if err := r.AddCmd(proto.InternalDeleteInline, dArgs, &proto.InternalDeleteInlineResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+	gArgs, gReply = getArgs(key, 1, s.StoreID())
+	if err := r.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if gReply.Value != nil {
+		t.Errorf("expected inline value to be deleted; got %+v", gReply.Value)
+	}
+}
+
+// TestRangeCompareAndSwap verifies that a compare-and-swap applies
+// its writes only if all of its conditions hold, and that it rejects
+// transactions and keys outside of its header span.
+func TestRangeCompareAndSwap(t *testing.T) {
+	s, r, _, _ := createTestRange(t)
+	defer s.Stop()
+
+	keyA, keyB, keyC := proto.Key("a"), proto.Key("b"), proto.Key("c")
+	valA, valB := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("va")}}, proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("vb")}}
+	for _, kv := range []proto.KeyValue{{Key: keyA, Value: valA}, {Key: keyB, Value: valB}} {
+		pArgs, pReply := putArgs(kv.Key, kv.Value.Bytes, 1, s.StoreID())
+		pArgs.Timestamp = s.Clock().Now()
+		if err := r.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	header := proto.RequestHeader{
+		Key:     keyA,
+		EndKey:  keyC.Next(),
+		RaftID:  1,
+		Replica: proto.Replica{StoreID: s.StoreID()},
+	}
+	get := func(key proto.Key) *proto.Value {
+		gArgs, gReply := getArgs(key, 1, s.StoreID())
+		if err := r.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+			t.Fatal(err)
+		}
+		return gReply.Value
+	}
+
+	// Swap a and b, and create c, expecting c not to exist.
+	args := &proto.CompareAndSwapRequest{
+		RequestHeader: header,
+		Conditions: []proto.CompareAndSwapRequest_Condition{
+			{Key: keyA, ExpValue: &valA},
+			{Key: keyB, ExpValue: &valB},
+			{Key: keyC},
+		},
+		Writes: []proto.CompareAndSwapRequest_Write{
+			{Key: keyA, Value: &valB},
+			{Key: keyB, Value: &valA},
+			{Key: keyC, Value: &valA},
+		},
+	}
+	if err := r.AddCmd(proto.CompareAndSwap, args, &proto.CompareAndSwapResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+	for key, exp := range map[string]proto.Value{"a": valB, "b": valA, "c": valA} {
+		if v := get(proto.Key(key)); v == nil || !bytes.Equal(v.Bytes, exp.Bytes) {
+			t.Errorf("expected %q at key %q; got %+v", exp.Bytes, key, v)
+		}
+	}
+
+	// Retrying the same swap fails on the first condition and writes nothing.
+	args.Writes = []proto.CompareAndSwapRequest_Write{{Key: keyC}}
+	err := r.AddCmd(proto.CompareAndSwap, args, &proto.CompareAndSwapResponse{}, true)
+	if cErr, ok := err.(*proto.ConditionFailedError); !ok {
+		t.Fatalf("expected ConditionFailedError; got %v", err)
+	} else if v := cErr.ActualValue; v == nil || !bytes.Equal(v.Bytes, valB.Bytes) {
+		t.Errorf("expected actual value %q; got %+v", valB.Bytes, v)
+	}
+	if v := get(keyC); v == nil {
+		t.Error("expected key c to remain after failed compare-and-swap")
+	}
+
+	// A transactional compare-and-swap is rejected.
+	txnArgs := &proto.CompareAndSwapRequest{RequestHeader: header, Writes: args.Writes}
+	txnArgs.Txn = newTransaction("test", keyA, 1, proto.SERIALIZABLE, s.Clock())
+	txnArgs.Timestamp = txnArgs.Txn.Timestamp
+	if err := r.AddCmd(proto.CompareAndSwap, txnArgs, &proto.CompareAndSwapResponse{}, true); err == nil {
+		t.Error("expected error on transactional compare-and-swap")
+	}
+
+	// Keys outside of the header span are rejected.
+	spanArgs := &proto.CompareAndSwapRequest{
+		RequestHeader: header,
+		Writes:        []proto.CompareAndSwapRequest_Write{{Key: proto.Key("d"), Value: &valA}},
+	}
+	err = r.AddCmd(proto.CompareAndSwap, spanArgs, &proto.CompareAndSwapResponse{}, true)
+	if _, ok := err.(*proto.RangeKeyMismatchError); !ok {
+		t.Errorf("expected RangeKeyMismatchError; got %v", err)
+	}
+
+	// A delete (nil value) removes the key.
+	args = &proto.CompareAndSwapRequest{
+		RequestHeader: header,
+		Conditions:    []proto.CompareAndSwapRequest_Condition{{Key: keyC, ExpValue: &valA}},
+		Writes:        []proto.CompareAndSwapRequest_Write{{Key: keyC}},
+	}
+	if err := r.AddCmd(proto.CompareAndSwap, args, &proto.CompareAndSwapResponse{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if v := get(keyC); v != nil {
+		t.Errorf("expected key c to be deleted; got %+v", v)
+	}
+}
+
 // TestConditionFailedError tests that a ConditionFailedError correctly
 // bubbles up from MVCC to Range.
 func TestConditionFailedError(t *testing.T) {
@@ -1619,10 +2210,10 @@ func TestConditionFailedError(t *testing.T) {
 			Replica:   proto.Replica{StoreID: s.StoreID()},
 		},
 		Value: proto.Value{
-			Bytes: value,
+			ValuePayload: proto.ValuePayload{Bytes: value},
 		},
 		ExpValue: &proto.Value{
-			Bytes: []byte("moo"),
+			ValuePayload: proto.ValuePayload{Bytes: []byte("moo")},
 		},
 	}
 	reply := &proto.ConditionalPutResponse{}
@@ -1635,3 +2226,303 @@ func TestConditionFailedError(t *testing.T) {
 			value, v)
 	}
 }
+
+// TestRangeBackpressure verifies that writes to a range which has
+// grown beyond the backpressure limit are rejected with a
+// RangeTooLargeError, while deletions and writes to system keys are
+// still admitted.
+func TestRangeBackpressure(t *testing.T) {
+	s, r, _, _ := createTestRange(t)
+	defer s.Stop()
+
+	// Write a value to give the range a non-zero size.
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1, s.StoreID())
+	if err := r.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lower the multiplier so that any non-empty range is too large.
+	defer func(m float64) { backpressureRangeSizeMultiplier = m }(backpressureRangeSizeMultiplier)
+	backpressureRangeSizeMultiplier = 0
+
+	pArgs, pReply = putArgs([]byte("b"), []byte("value"), 1, s.StoreID())
+	err := r.AddCmd(proto.Put, pArgs, pReply, true)
+	if _, ok := err.(*proto.RangeTooLargeError); !ok {
+		t.Fatalf("expected RangeTooLargeError; got %v", err)
+	}
+	if _, ok := pReply.GoError().(*proto.RangeTooLargeError); !ok {
+		t.Errorf("expected RangeTooLargeError in reply; got %v", pReply.GoError())
+	}
+
+	// Deletions are not subject to backpressure.
+	dArgs, dReply := deleteArgs(proto.Key("a"), 1, s.StoreID())
+	dArgs.Timestamp = proto.MinTimestamp
+	if err := r.AddCmd(proto.Delete, dArgs, dReply, true); err != nil {
+		t.Errorf("unexpected error on delete: %s", err)
+	}
+
+	// Nor are writes to system keys.
+	pArgs, pReply = putArgs(engine.MakeKey(engine.KeySystemPrefix, proto.Key("a")), []byte("value"), 1, s.StoreID())
+	if err := r.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Errorf("unexpected error on system key put: %s", err)
+	}
+}
+
+// TestRangeCorruption verifies that a range marked corrupted rejects
+// all commands, including inconsistent reads, that the corruption is
+// reported to range event callbacks exactly once, that it isn't
+// persisted and that the store can put the range back into service.
+func TestRangeCorruption(t *testing.T) {
+	s, r, _, _ := createTestRange(t)
+	defer s.Stop()
+
+	var events []*RangeEvent
+	s.RegisterRangeEventCallback(func(event *RangeEvent) {
+		events = append(events, event)
+	})
+
+	if r.IsCorrupt() {
+		t.Fatal("expected new range not to be corrupt")
+	}
+	cErr := r.setCorrupt(util.Errorf("boom"))
+	if !r.IsCorrupt() {
+		t.Fatal("expected range to be corrupt")
+	}
+	if err := r.setCorrupt(util.Errorf("bang")); err != cErr {
+		t.Errorf("expected original corruption error %v; got %v", cErr, err)
+	}
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1, s.StoreID())
+	if err := r.AddCmd(proto.Put, pArgs, pReply, true); err != cErr {
+		t.Errorf("expected corruption error on put; got %v", err)
+	}
+	if _, ok := pReply.GoError().(*proto.ReplicaCorruptionError); !ok {
+		t.Errorf("expected ReplicaCorruptionError in reply; got %v", pReply.GoError())
+	}
+	gArgs, gReply := getArgs([]byte("a"), 1, s.StoreID())
+	gArgs.ReadConsistency = proto.INCONSISTENT
+	if err := r.AddCmd(proto.Get, gArgs, gReply, true); err != cErr {
+		t.Errorf("expected corruption error on inconsistent get; got %v", err)
+	}
+
+	if len(events) != 1 || events[0].Type != RangeEventCorrupt || events[0].Desc.RaftID != r.Desc.RaftID {
+		t.Errorf("expected a single corrupt event for range %d; got %+v", r.Desc.RaftID, events)
+	}
+
+	if reloaded := NewRange(r.Desc, s); reloaded.IsCorrupt() {
+		t.Error("expected reloaded range not to be corrupt")
+	}
+
+	if err := s.ClearReplicaCorruption(r.Desc.RaftID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ClearReplicaCorruption(r.Desc.RaftID); err == nil {
+		t.Error("expected error clearing range which isn't corrupt")
+	}
+	gArgs, gReply = getArgs([]byte("a"), 1, s.StoreID())
+	if err := r.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Errorf("expected cleared range to serve; got %s", err)
+	}
+}
+
+// TestInternalResolveIntentSpans verifies that intent spans supplied
+// to InternalResolveIntent are resolved by their addressed keys and
+// that a span outside of the request's key range fails the request.
+func TestInternalResolveIntentSpans(t *testing.T) {
+	s, rng, _, clock, eng := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	localKey := engine.RangeDescriptorKey(proto.Key("c"))
+	keys := []proto.Key{proto.Key("a"), proto.Key("b"), proto.Key("c"), localKey, proto.Key("d")}
+	txn := newTransaction("test", proto.Key("a"), 1, proto.SERIALIZABLE, clock)
+	for _, key := range keys {
+		pArgs, pReply := putArgs(key, []byte("value"), 1, s.StoreID())
+		pArgs.Timestamp = txn.Timestamp
+		pArgs.Txn = txn
+		if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolveArgs := func(intents ...proto.IntentSpan) (*proto.InternalResolveIntentRequest, *proto.InternalResolveIntentResponse) {
+		args := &proto.InternalResolveIntentRequest{
+			RequestHeader: proto.RequestHeader{
+				Timestamp: txn.Timestamp,
+				Key:       proto.Key("b"),
+				EndKey:    proto.Key("d"),
+				RaftID:    rng.Desc.RaftID,
+				Replica:   proto.Replica{StoreID: s.StoreID()},
+				Txn:       gogoproto.Clone(txn).(*proto.Transaction),
+			},
+			Intents: intents,
+		}
+		args.Txn.Status = proto.COMMITTED
+		return args, &proto.InternalResolveIntentResponse{}
+	}
+	verifyIntents := func(expIntents ...bool) {
+		for i, key := range keys {
+			meta := &proto.MVCCMetadata{}
+			if _, _, _, err := engine.GetProto(eng, engine.MVCCEncodeKey(key), meta); err != nil {
+				t.Fatal(err)
+			}
+			if hasIntent := meta.Txn != nil; hasIntent != expIntents[i] {
+				t.Errorf("%q: expected intent %t; got %t", key, expIntents[i], hasIntent)
+			}
+		}
+	}
+
+	// A span outside of [b, d) fails the request and nothing is resolved.
+	rArgs, rReply := resolveArgs(proto.IntentSpan{Key: proto.Key("b")}, proto.IntentSpan{Key: proto.Key("c"), EndKey: proto.Key("e")})
+	if err := rng.AddCmd(proto.InternalResolveIntent, rArgs, rReply, true); err == nil {
+		t.Fatal("expected error resolving span outside of the request's key range")
+	}
+	verifyIntents(true, true, true, true, true)
+
+	// The local key addresses "c" and so lies within [b, d).
+	rArgs, rReply = resolveArgs(proto.IntentSpan{Key: proto.Key("b")}, proto.IntentSpan{Key: proto.Key("c")}, proto.IntentSpan{Key: localKey})
+	if err := rng.AddCmd(proto.InternalResolveIntent, rArgs, rReply, true); err != nil {
+		t.Fatal(err)
+	}
+	verifyIntents(true, false, false, false, true)
+}
+
+// TestInternalResolveIntentGCTxn verifies that a transaction record
+// may be removed via InternalResolveIntent only once the transaction
+// has been committed or aborted.
+func TestInternalResolveIntentGCTxn(t *testing.T) {
+	s, rng, _, clock, eng := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	key := proto.Key("a")
+	txn := newTransaction("test", key, 1, proto.SERIALIZABLE, clock)
+	txnKey := engine.MakeKey(engine.KeyLocalTransactionPrefix, txn.Key, txn.ID)
+	gcArgs := func() (*proto.InternalResolveIntentRequest, *proto.InternalResolveIntentResponse) {
+		return &proto.InternalResolveIntentRequest{
+			RequestHeader: proto.RequestHeader{
+				Timestamp: clock.Now(),
+				Key:       txn.Key,
+				RaftID:    rng.Desc.RaftID,
+				Replica:   proto.Replica{StoreID: s.StoreID()},
+				Txn:       txn,
+			},
+			GCTxn: true,
+		}, &proto.InternalResolveIntentResponse{}
+	}
+
+	// A heartbeat writes a pending record, which must not be removed.
+	hbArgs, hbReply := heartbeatArgs(txn, 1, s.StoreID())
+	hbArgs.Timestamp = txn.Timestamp
+	if err := rng.AddCmd(proto.InternalHeartbeatTxn, hbArgs, hbReply, true); err != nil {
+		t.Fatal(err)
+	}
+	args, reply := gcArgs()
+	if err := rng.AddCmd(proto.InternalResolveIntent, args, reply, true); err == nil {
+		t.Fatal("expected error removing pending transaction record")
+	}
+
+	// Once committed, the record is removed.
+	etArgs, etReply := endTxnArgs(txn, true, 1, s.StoreID())
+	etArgs.Timestamp = txn.Timestamp
+	if err := rng.AddCmd(proto.EndTransaction, etArgs, etReply, true); err != nil {
+		t.Fatal(err)
+	}
+	args, reply = gcArgs()
+	if err := rng.AddCmd(proto.InternalResolveIntent, args, reply, true); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := engine.MVCCGetProto(eng, txnKey, proto.ZeroTimestamp, nil, &proto.Transaction{}); err != nil || ok {
+		t.Errorf("expected transaction record to be removed; got %t, %v", ok, err)
+	}
+}
+
+// TestRangeTombstoneCountUnknown verifies that a range whose range
+// tombstone count couldn't be read still honors range tombstones,
+// even though its in-memory count reads as zero.
+func TestRangeTombstoneCountUnknown(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	pArgs, pReply := putArgs([]byte("a"), []byte("value"), 1, s.StoreID())
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Fatal(err)
+	}
+	// Write the tombstone beneath the range so it isn't counted.
+	if err := engine.MVCCDeleteRangeTombstone(s.Engine(), nil, proto.Key("a"), proto.Key("b"), clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&rng.rtUnknown, 1)
+	if count := atomic.LoadInt64(&rng.rtCount); count != 0 {
+		t.Fatalf("expected range tombstone count 0; got %d", count)
+	}
+
+	gArgs, gReply := getArgs([]byte("a"), 1, s.StoreID())
+	gArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if gReply.Value != nil {
+		t.Errorf("expected key beneath range tombstone to be deleted; got %+v", gReply.Value)
+	}
+}
+
+// TestRangeAuditLogAppendOnly verifies that the range permits only
+// conditional puts of new events to the audit log, including within
+// batches, and leaves other keys unaffected.
+func TestRangeAuditLogAppendOnly(t *testing.T) {
+	s, rng, _, clock, _ := createTestRangeWithClock(t)
+	defer s.Stop()
+
+	auditKey := engine.AuditLogKey(1, nil)
+	cpArgs := &proto.ConditionalPutRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       auditKey,
+			Timestamp: clock.Now(),
+			RaftID:    1,
+			Replica:   proto.Replica{StoreID: s.StoreID()},
+		},
+		Value: proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("event")}},
+	}
+	if err := rng.AddCmd(proto.ConditionalPut, cpArgs, &proto.ConditionalPutResponse{}, true); err != nil {
+		t.Fatalf("expected append to the audit log to succeed: %s", err)
+	}
+
+	pArgs, pReply := putArgs(auditKey, []byte("overwrite"), 1, s.StoreID())
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err == nil {
+		t.Error("expected put to the audit log to fail")
+	}
+	dArgs, dReply := deleteArgs(auditKey, 1, s.StoreID())
+	dArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Delete, dArgs, dReply, true); err == nil {
+		t.Error("expected delete from the audit log to fail")
+	}
+	bArgs := &proto.BatchRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:       proto.Key("a"),
+			Timestamp: clock.Now(),
+			RaftID:    1,
+			Replica:   proto.Replica{StoreID: s.StoreID()},
+		},
+	}
+	pArgs, _ = putArgs(auditKey, []byte("overwrite"), 1, s.StoreID())
+	bArgs.Add(pArgs)
+	if err := rng.AddCmd(proto.Batch, bArgs, &proto.BatchResponse{}, true); err == nil {
+		t.Error("expected batched put to the audit log to fail")
+	}
+
+	gArgs, gReply := getArgs(auditKey, 1, s.StoreID())
+	gArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Get, gArgs, gReply, true); err != nil {
+		t.Fatal(err)
+	}
+	if gReply.Value == nil || string(gReply.Value.Bytes) != "event" {
+		t.Errorf("expected audit event to be unmodified; got %+v", gReply.Value)
+	}
+
+	pArgs, pReply = putArgs([]byte("a"), []byte("value"), 1, s.StoreID())
+	pArgs.Timestamp = clock.Now()
+	if err := rng.AddCmd(proto.Put, pArgs, pReply, true); err != nil {
+		t.Errorf("expected put outside the audit log to succeed: %s", err)
+	}
+}
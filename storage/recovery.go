@@ -0,0 +1,152 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// UnsafeRecoverRange rewrites every copy of the descriptor for the
+// range with the given Raft ID found in the engine so that its replica
+// set contains only the replicas on the surviving stores. Both the
+// range-local descriptor and any meta1/meta2 addressing records held by
+// the engine are rewritten, and their generation is incremented. The
+// store must not be running.
+//
+// This is a last resort for bringing back a range which has lost a
+// majority of its replicas. Any writes committed by the lost majority
+// but not yet applied on the survivors are lost, and the survivors may
+// disagree about the range's contents. It must be run against the
+// engines of every surviving replica and of the stores holding the
+// range's addressing records, with the same survivors each time.
+//
+// Raft state is held in memory and discarded on restart, so there is
+// no persisted raft state to clear. Range stats are not adjusted for
+// the change in descriptor size.
+//
+// Returns the rewritten descriptor and the number of records updated,
+// or a nil descriptor if the engine holds no copy of it.
+func UnsafeRecoverRange(eng engine.Engine, raftID int64, survivors []int32, now proto.Timestamp) (*proto.RangeDescriptor, int, error) {
+	if len(survivors) == 0 {
+		return nil, 0, util.Errorf("no surviving stores specified")
+	}
+	ident := proto.StoreIdent{}
+	ok, err := engine.MVCCGetProto(eng, engine.KeyLocalIdent, proto.ZeroTimestamp, nil, &ident)
+	if err != nil {
+		return nil, 0, err
+	} else if !ok {
+		return nil, 0, &NotBootstrappedError{}
+	}
+
+	var newDesc *proto.RangeDescriptor
+	var count int
+	rewrite := func(start, end proto.Key, local bool) error {
+		var keys []proto.Key
+		var descs []*proto.RangeDescriptor
+		if err := engine.MVCCIterateCommitted(eng, start, end, func(kv proto.KeyValue) (bool, error) {
+			desc := &proto.RangeDescriptor{}
+			if err := gogoproto.Unmarshal(kv.Value.Bytes, desc); err != nil {
+				return false, util.Errorf("unable to unmarshal range descriptor at %q: %s", kv.Key, err)
+			}
+			if desc.RaftID == raftID {
+				keys = append(keys, kv.Key)
+				descs = append(descs, desc)
+			}
+			return false, nil
+		}); err != nil {
+			return err
+		}
+		for i, desc := range descs {
+			if local && !containsStore(survivors, ident.StoreID) {
+				return util.Errorf("store %d holds a replica of range %d but is not among the survivors %v",
+					ident.StoreID, raftID, survivors)
+			}
+			replicas, err := survivingReplicas(desc, survivors)
+			if err != nil {
+				return err
+			}
+			desc.Replicas = replicas
+			desc.Generation++
+			if err := unsafePutDescriptor(eng, keys[i], desc, now); err != nil {
+				return err
+			}
+			newDesc = desc
+			count++
+		}
+		return nil
+	}
+
+	if err := rewrite(engine.KeyLocalRangeDescriptorPrefix, engine.KeyLocalRangeDescriptorPrefix.PrefixEnd(), true); err != nil {
+		return nil, 0, err
+	}
+	if err := rewrite(engine.KeyMetaPrefix, engine.KeyMetaMax, false); err != nil {
+		return nil, 0, err
+	}
+	return newDesc, count, nil
+}
+
+// containsStore returns whether storeID is in storeIDs.
+func containsStore(storeIDs []int32, storeID int32) bool {
+	for _, id := range storeIDs {
+		if id == storeID {
+			return true
+		}
+	}
+	return false
+}
+
+// survivingReplicas returns the replicas of desc located on the
+// survivors. Every survivor must hold a replica, and at least one
+// replica must have been lost.
+func survivingReplicas(desc *proto.RangeDescriptor, survivors []int32) ([]proto.Replica, error) {
+	var replicas []proto.Replica
+	for _, storeID := range survivors {
+		found := false
+		for _, replica := range desc.Replicas {
+			if replica.StoreID == storeID {
+				replicas = append(replicas, replica)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, util.Errorf("store %d holds no replica of range %d: %+v", storeID, desc.RaftID, desc.Replicas)
+		}
+	}
+	if len(replicas) == len(desc.Replicas) {
+		return nil, util.Errorf("range %d has lost no replicas: %+v", desc.RaftID, desc.Replicas)
+	}
+	return replicas, nil
+}
+
+// unsafePutDescriptor writes desc at key using a timestamp after both
+// now and the existing version. An intent on the key, left by an
+// unfinished split or replica change, is an error; the transaction
+// which wrote it must be resolved first.
+func unsafePutDescriptor(eng engine.Engine, key proto.Key, desc *proto.RangeDescriptor, now proto.Timestamp) error {
+	existing, err := engine.MVCCGet(eng, key, proto.MaxTimestamp, true, nil)
+	if err != nil {
+		return util.Errorf("unable to read range descriptor at %q: %s", key, err)
+	}
+	ts := now
+	if existing != nil && existing.Timestamp != nil && !existing.Timestamp.Less(ts) {
+		ts = existing.Timestamp.Next()
+	}
+	return engine.MVCCPutProto(eng, nil, key, ts, nil, desc)
+}
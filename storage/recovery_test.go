@@ -0,0 +1,97 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestUnsafeRecoverRange verifies that the local descriptor and the
+// addressing records for a range are rewritten to the survivors.
+func TestUnsafeRecoverRange(t *testing.T) {
+	store, _ := createTestStore(t)
+	store.Stop()
+	eng := store.Engine()
+
+	// Add two replicas on other stores to every copy of the descriptor.
+	now := proto.Timestamp{WallTime: 10}
+	lost := []proto.Replica{{NodeID: 2, StoreID: 2}, {NodeID: 3, StoreID: 3}}
+	keys := []proto.Key{
+		engine.RangeDescriptorKey(engine.KeyMin),
+		engine.MakeKey(engine.KeyMeta1Prefix, engine.KeyMax),
+		engine.MakeKey(engine.KeyMeta2Prefix, engine.KeyMax),
+	}
+	var expReplicas []proto.Replica
+	for _, key := range keys {
+		desc := &proto.RangeDescriptor{}
+		if ok, err := engine.MVCCGetProto(eng, key, proto.MaxTimestamp, nil, desc); err != nil || !ok {
+			t.Fatalf("unable to read descriptor at %q: %v", key, err)
+		}
+		expReplicas = desc.Replicas
+		desc.Replicas = append(desc.Replicas, lost...)
+		if err := engine.MVCCPutProto(eng, nil, key, now, nil, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Survivors must hold replicas, and at least one must be lost.
+	if _, _, err := UnsafeRecoverRange(eng, 1, []int32{1, 4}, now); err == nil {
+		t.Error("expected error recovering to a store without a replica")
+	}
+	if _, _, err := UnsafeRecoverRange(eng, 1, []int32{1, 2, 3}, now); err == nil {
+		t.Error("expected error recovering with no lost replicas")
+	}
+	if _, _, err := UnsafeRecoverRange(eng, 1, []int32{2}, now); err == nil {
+		t.Error("expected error recovering on a store which isn't a survivor")
+	}
+
+	desc, count, err := UnsafeRecoverRange(eng, 1, []int32{1}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(keys) {
+		t.Errorf("expected %d descriptors rewritten; got %d", len(keys), count)
+	}
+	if !reflect.DeepEqual(desc.Replicas, expReplicas) {
+		t.Errorf("expected replicas %+v; got %+v", expReplicas, desc.Replicas)
+	}
+	for _, key := range keys {
+		desc := &proto.RangeDescriptor{}
+		if ok, err := engine.MVCCGetProto(eng, key, proto.MaxTimestamp, nil, desc); err != nil || !ok {
+			t.Fatalf("unable to read descriptor at %q: %v", key, err)
+		}
+		if !reflect.DeepEqual(desc.Replicas, expReplicas) {
+			t.Errorf("%q: expected replicas %+v; got %+v", key, expReplicas, desc.Replicas)
+		}
+	}
+
+	// The store restarts with the recovered descriptor.
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Stop()
+	rng, err := store.GetRange(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rng.Desc.Replicas, expReplicas) {
+		t.Errorf("expected restarted range replicas %+v; got %+v", expReplicas, rng.Desc.Replicas)
+	}
+}
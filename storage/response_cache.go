@@ -186,26 +186,36 @@ func (rc *ResponseCache) CopyFrom(e engine.Engine, originRaftID int64) error {
 // command will be signaled to wakeup and read the command response
 // from the cache.
 func (rc *ResponseCache) PutResponse(cmdID proto.ClientCmdID, reply proto.Response) error {
+	err := rc.putResponse(rc.engine, cmdID, reply)
+	rc.RemoveInflight(cmdID)
+	return err
+}
+
+// putResponse writes a response for the specified cmdID to e, which
+// is usually the batch holding the writes of the command itself, so
+// that the command and its response are committed atomically. The
+// inflight entry is left in place; the caller must invoke
+// RemoveInflight once the batch has been committed.
+func (rc *ResponseCache) putResponse(e engine.Engine, cmdID proto.ClientCmdID, reply proto.Response) error {
 	// Do nothing if command ID is empty.
-	if cmdID.IsEmpty() {
+	if cmdID.IsEmpty() || !rc.shouldCacheResponse(reply) {
 		return nil
 	}
-	// Write the response value to the engine.
-	var err error
-	if rc.shouldCacheResponse(reply) {
-		key := responseCacheKey(rc.raftID, cmdID)
-		rwResp := &proto.ReadWriteCmdResponse{}
-		rwResp.SetValue(reply)
-		err = engine.MVCCPutProto(rc.engine, nil, key, proto.ZeroTimestamp, nil, rwResp)
-	}
+	key := responseCacheKey(rc.raftID, cmdID)
+	rwResp := &proto.ReadWriteCmdResponse{}
+	rwResp.SetValue(reply)
+	return engine.MVCCPutProto(e, nil, key, proto.ZeroTimestamp, nil, rwResp)
+}
 
-	// Take lock after writing response to cache!
+// RemoveInflight removes the entry matching cmdID from the inflight
+// map, waking any requests waiting on the command's outcome.
+func (rc *ResponseCache) RemoveInflight(cmdID proto.ClientCmdID) {
+	if cmdID.IsEmpty() {
+		return
+	}
 	rc.Lock()
 	defer rc.Unlock()
-	// Even on error, we remove the entry from the inflight map.
 	rc.removeInflightLocked(cmdID)
-
-	return err
 }
 
 // shouldCacheResponse returns whether the response should be cached.
@@ -224,7 +234,7 @@ func (rc *ResponseCache) shouldCacheResponse(reply proto.Response) bool {
 // map. Any subsequent invocations of GetResponse for the same client
 // command will block on the inflight cond var until either the
 // response cache is cleared or this command is removed via
-// PutResponse().
+// PutResponse() or RemoveInflight().
 func (rc *ResponseCache) addInflightLocked(cmdID proto.ClientCmdID) {
 	if _, ok := rc.inflight[makeCmdIDKey(cmdID)]; ok {
 		panic(fmt.Sprintf("command %+v is already inflight; GetResponse() should have been "+
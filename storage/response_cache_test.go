@@ -70,6 +70,50 @@ func TestResponseCachePutGetClearData(t *testing.T) {
 	}
 }
 
+// TestResponseCachePutResponseBatch verifies that a response written
+// to a batch isn't visible until the batch is committed, and that
+// waiters remain blocked until the inflight entry is removed.
+func TestResponseCachePutResponseBatch(t *testing.T) {
+	rc := createTestResponseCache(t, 1)
+	cmdID := makeCmdID(1, 1)
+	val := proto.IncrementResponse{}
+	// Add inflight for cmdID.
+	if ok, err := rc.GetResponse(cmdID, &val); ok || err != nil {
+		t.Fatalf("unexpected response: %t, %v", ok, err)
+	}
+	batch := rc.engine.NewBatch()
+	if err := rc.putResponse(batch, cmdID, &incR); err != nil {
+		t.Fatal(err)
+	}
+	key := responseCacheKey(rc.raftID, cmdID)
+	if ok, err := engine.MVCCGetProto(rc.engine, key, proto.ZeroTimestamp, nil, &proto.ReadWriteCmdResponse{}); ok || err != nil {
+		t.Fatalf("expected response not to be visible before commit: %t, %v", ok, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		val2 := proto.IncrementResponse{}
+		if ok, err := rc.GetResponse(cmdID, &val2); !ok || err != nil || val2.NewValue != 1 {
+			t.Errorf("unexpected response: %t, %v, %+v", ok, err, val2)
+		}
+		close(done)
+	}()
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+		t.Fatal("get should block until the inflight entry is removed")
+	case <-time.After(2 * time.Millisecond):
+		rc.RemoveInflight(cmdID)
+	}
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("get response failed to complete in 500ms")
+	}
+}
+
 // TestResponseCacheEmptyCmdID tests operation with empty client
 // command id. All calls should be noops.
 func TestResponseCacheEmptyCmdID(t *testing.T) {
@@ -258,7 +302,7 @@ func TestResponseCacheShouldCache(t *testing.T) {
 // garbage collected periodically.
 func TestResponseCacheGC(t *testing.T) {
 	loc := util.CreateTempDirectory()
-	rocksdb := engine.NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc)
+	rocksdb := engine.NewRocksDB(proto.Attributes{Attrs: []string{"ssd"}}, loc, engine.DefaultRocksDBOptions())
 	if err := rocksdb.Start(); err != nil {
 		t.Fatalf("could not create new rocksdb db instance at %s: %v", loc, err)
 	}
@@ -105,7 +105,10 @@ func (rs *rangeScanner) loopCount() int64 {
 // have placed it in. This method should be called by the Store
 // when a range is removed (e.g. rebalanced or merged).
 func (rs *rangeScanner) removeRange(rng *Range) {
-	rs.removed <- rng
+	select {
+	case rs.removed <- rng:
+	case <-rs.stopper.ShouldStop():
+	}
 }
 
 // scanLoop loops endlessly, scanning through ranges available via
@@ -130,9 +133,12 @@ func (rs *rangeScanner) scanLoop() {
 		case <-time.After(nextIteration):
 			rng := rs.iter.next()
 			if rng != nil {
-				// Try adding range to all queues.
-				for _, q := range rs.queues {
-					q.maybeAdd(rng)
+				// Try adding range to all queues. Corrupted replicas
+				// are left alone until they're put back into service.
+				if !rng.IsCorrupt() {
+					for _, q := range rs.queues {
+						q.maybeAdd(rng)
+					}
 				}
 			} else {
 				// Otherwise, reset iteration and start time.
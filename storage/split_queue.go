@@ -0,0 +1,86 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/proto"
+)
+
+// splitQueueMaxSize is the max size of the split queue.
+const splitQueueMaxSize = 100
+
+// splitQueue splits ranges which have grown larger than the
+// RangeMaxBytes of their zone. Ranges are prioritized by their size
+// as a multiple of the zone's maximum, as tracked by their MVCC stats.
+// The split key is left for AdminSplit to choose: it picks the key
+// nearest the range's size midpoint which doesn't divide colocated
+// keys (e.g. the columns of a table row), and updates the range
+// metadata index in the same transaction as the range descriptors.
+//
+// Ranges are also split eagerly as writes push them over the limit
+// (see Range.maybeSplit); the queue catches ranges which are never
+// written again, such as those left oversized by a lowered zone
+// maximum or by a failed split.
+type splitQueue struct {
+	*baseQueue
+	sizeFn func(*Range) (int64, int64, bool) // Returns range size and zone max
+}
+
+// newSplitQueue returns a new instance of splitQueue.
+func newSplitQueue() *splitQueue {
+	sq := &splitQueue{
+		sizeFn: (*Range).getSizeAndMaxBytes,
+	}
+	sq.baseQueue = newBaseQueue("split", sq.shouldQueue, sq.process, splitQueueMaxSize)
+	return sq
+}
+
+// shouldQueue determines whether the range has outgrown its zone's
+// maximum size. Only the leader splits ranges. Priority is the size
+// of the range as a multiple of the maximum.
+func (sq *splitQueue) shouldQueue(rng *Range) (bool, float64) {
+	if !rng.IsLeader() {
+		return false, 0
+	}
+	size, maxBytes, ok := sq.sizeFn(rng)
+	if !ok || maxBytes <= 0 {
+		return false, 0
+	}
+	priority := float64(size) / float64(maxBytes)
+	return priority > 1, priority
+}
+
+// process splits the range via an AdminSplit command, provided it is
+// still oversized and isn't already being split.
+func (sq *splitQueue) process(rng *Range) error {
+	if atomic.LoadInt32(&rng.splitting) == int32(1) {
+		return nil
+	}
+	if should, _ := sq.shouldQueue(rng); !should {
+		return nil
+	}
+	args := &proto.AdminSplitRequest{
+		RequestHeader: proto.RequestHeader{
+			Key:     rng.Desc.StartKey,
+			User:    UserRoot,
+			RaftID:  rng.Desc.RaftID,
+			Replica: *rng.GetReplica(),
+		},
+	}
+	return rng.AddCmd(proto.AdminSplit, args, &proto.AdminSplitResponse{}, true)
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestSplitQueueShouldQueue verifies that ranges are queued only once
+// they exceed their zone's maximum size, in order of size.
+func TestSplitQueueShouldQueue(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	rng := store.LookupRange(engine.KeyMin, nil)
+
+	testCases := []struct {
+		size, maxBytes int64
+		ok             bool
+		expShould      bool
+		expPriority    float64
+	}{
+		{100, 1000, true, false, 0.1},
+		{1000, 1000, true, false, 1},
+		{2000, 1000, true, true, 2},
+		{2000, 0, true, false, 0},
+		{2000, 1000, false, false, 0},
+	}
+	sq := newSplitQueue()
+	for i, test := range testCases {
+		sq.sizeFn = func(*Range) (int64, int64, bool) {
+			return test.size, test.maxBytes, test.ok
+		}
+		should, priority := sq.shouldQueue(rng)
+		if should != test.expShould || priority != test.expPriority {
+			t.Errorf("%d: expected %t, %f; got %t, %f", i, test.expShould, test.expPriority, should, priority)
+		}
+	}
+}
+
+// TestSplitQueueProcess verifies that processing an oversized range
+// splits it in two.
+func TestSplitQueueProcess(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	rng := store.LookupRange(engine.KeyMin, nil)
+
+	value := bytes.Repeat([]byte("v"), 100)
+	for c := 'a'; c <= 'z'; c++ {
+		pArgs, pReply := putArgs(proto.Key(string(c)), value, rng.Desc.RaftID, store.StoreID())
+		pArgs.Timestamp = store.Clock().Now()
+		if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sq := newSplitQueue()
+	sq.sizeFn = func(r *Range) (int64, int64, bool) {
+		size, err := engine.GetRangeSize(store.Engine(), r.Desc.RaftID)
+		return size, 1 << 10, err == nil
+	}
+	if should, _ := sq.shouldQueue(rng); !should {
+		t.Fatal("expected oversized range to be queued")
+	}
+	if err := sq.process(rng); err != nil {
+		t.Fatal(err)
+	}
+	newRng := store.LookupRange(proto.Key("z"), nil)
+	if newRng == nil || newRng.Desc.RaftID == rng.Desc.RaftID {
+		t.Fatalf("expected range to be split")
+	}
+	if !rng.Desc.EndKey.Equal(newRng.Desc.StartKey) || !rng.Desc.ContainsKey(engine.KeyMin) {
+		t.Errorf("unexpected descriptors after split: %+v, %+v", rng.Desc, newRng.Desc)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package sstable
+
+import (
+	"encoding/binary"
+
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// A blockWriter builds a block from entries added in increasing key
+// order. Each key is stored as the length of the prefix it shares with
+// the previous key and its remaining suffix, except at restart points,
+// every restartInterval entries, where the key is stored in full so
+// that readers can binary search the restart points.
+type blockWriter struct {
+	restartInterval int
+	buf             []byte
+	restarts        []uint32
+	counter         int
+	lastKey         []byte
+}
+
+// newBlockWriter returns a blockWriter with the specified restart
+// interval.
+func newBlockWriter(restartInterval int) *blockWriter {
+	bw := &blockWriter{restartInterval: restartInterval}
+	bw.reset()
+	return bw
+}
+
+// reset clears the block for reuse.
+func (bw *blockWriter) reset() {
+	bw.buf = bw.buf[:0]
+	bw.restarts = append(bw.restarts[:0], 0)
+	bw.counter = 0
+	bw.lastKey = bw.lastKey[:0]
+}
+
+// empty returns true if no entries have been added.
+func (bw *blockWriter) empty() bool {
+	return len(bw.buf) == 0
+}
+
+// estimatedSize returns the size of the block if finished now.
+func (bw *blockWriter) estimatedSize() int {
+	return len(bw.buf) + 4*len(bw.restarts) + 4
+}
+
+// add appends an entry to the block. Keys must be added in increasing
+// order.
+func (bw *blockWriter) add(key, value []byte) {
+	shared := 0
+	if bw.counter < bw.restartInterval {
+		for shared < len(key) && shared < len(bw.lastKey) && key[shared] == bw.lastKey[shared] {
+			shared++
+		}
+	} else {
+		bw.restarts = append(bw.restarts, uint32(len(bw.buf)))
+		bw.counter = 0
+	}
+	var lens [3 * binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(lens[:], uint64(shared))
+	n += binary.PutUvarint(lens[n:], uint64(len(key)-shared))
+	n += binary.PutUvarint(lens[n:], uint64(len(value)))
+	bw.buf = append(bw.buf, lens[:n]...)
+	bw.buf = append(bw.buf, key[shared:]...)
+	bw.buf = append(bw.buf, value...)
+	bw.lastKey = append(bw.lastKey[:0], key...)
+	bw.counter++
+}
+
+// finish appends the restart points and returns the block contents,
+// which remain valid until the next call to reset.
+func (bw *blockWriter) finish() []byte {
+	var b [4]byte
+	for _, r := range bw.restarts {
+		binary.LittleEndian.PutUint32(b[:], r)
+		bw.buf = append(bw.buf, b[:]...)
+	}
+	binary.LittleEndian.PutUint32(b[:], uint32(len(bw.restarts)))
+	return append(bw.buf, b[:]...)
+}
+
+// A blockIter iterates over the entries of a block.
+type blockIter struct {
+	cmp         func(a, b []byte) int
+	data        []byte // Entries, excluding the restart points
+	restarts    []byte // Restart point offsets
+	numRestarts int
+	nextOffset  int
+	key, value  []byte
+	valid       bool
+	err         error
+}
+
+// newBlockIter returns an iterator over block, whose keys are ordered
+// by cmp.
+func newBlockIter(block []byte, cmp func(a, b []byte) int) (*blockIter, error) {
+	if len(block) < 4 {
+		return nil, util.Errorf("corrupt block: too short")
+	}
+	numRestarts := int(binary.LittleEndian.Uint32(block[len(block)-4:]))
+	restartOffset := len(block) - 4 - 4*numRestarts
+	if numRestarts == 0 || restartOffset < 0 {
+		return nil, util.Errorf("corrupt block: invalid restart count %d", numRestarts)
+	}
+	return &blockIter{
+		cmp:         cmp,
+		data:        block[:restartOffset],
+		restarts:    block[restartOffset : len(block)-4],
+		numRestarts: numRestarts,
+	}, nil
+}
+
+// restartPoint returns the offset of the i'th restart point.
+func (bi *blockIter) restartPoint(i int) int {
+	return int(binary.LittleEndian.Uint32(bi.restarts[4*i:]))
+}
+
+// decodeAt decodes the entry at offset, which shares a prefix with
+// the current key.
+func (bi *blockIter) decodeAt(offset int) {
+	bi.valid = false
+	if offset >= len(bi.data) {
+		return
+	}
+	p := bi.data[offset:]
+	var lens [3]uint64
+	n := 0
+	for i := range lens {
+		v, m := binary.Uvarint(p[n:])
+		if m <= 0 {
+			bi.err = util.Errorf("corrupt block entry at offset %d", offset)
+			return
+		}
+		lens[i] = v
+		n += m
+	}
+	shared, nonShared, valueLen := lens[0], lens[1], lens[2]
+	p = p[n:]
+	if shared > uint64(len(bi.key)) || nonShared+valueLen > uint64(len(p)) {
+		bi.err = util.Errorf("corrupt block entry at offset %d", offset)
+		return
+	}
+	bi.key = append(bi.key[:shared], p[:nonShared]...)
+	bi.value = p[nonShared : nonShared+valueLen]
+	bi.nextOffset = offset + n + int(nonShared+valueLen)
+	bi.valid = true
+}
+
+// seekToFirst positions the iterator at the first entry.
+func (bi *blockIter) seekToFirst() {
+	bi.key = bi.key[:0]
+	bi.decodeAt(0)
+}
+
+// next advances the iterator to the next entry.
+func (bi *blockIter) next() {
+	bi.decodeAt(bi.nextOffset)
+}
+
+// seek positions the iterator at the first entry with a key >= target.
+func (bi *blockIter) seek(target []byte) {
+	// Binary search for the last restart point with a key < target.
+	lo, hi := 0, bi.numRestarts-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		bi.key = bi.key[:0]
+		bi.decodeAt(bi.restartPoint(mid))
+		if !bi.valid {
+			return
+		}
+		if bi.cmp(bi.key, target) < 0 {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	bi.key = bi.key[:0]
+	for bi.decodeAt(bi.restartPoint(lo)); bi.valid && bi.cmp(bi.key, target) < 0; bi.next() {
+	}
+}
+
+// seekLT positions the iterator at the last entry with a key < target,
+// or at the last entry in the block if target is nil. The iterator is
+// invalid if no such entry exists.
+func (bi *blockIter) seekLT(target []byte) {
+	// Binary search for the last restart point with a key < target.
+	lo, hi := 0, bi.numRestarts-1
+	for target != nil && lo < hi {
+		mid := (lo + hi + 1) / 2
+		bi.key = bi.key[:0]
+		bi.decodeAt(bi.restartPoint(mid))
+		if !bi.valid {
+			return
+		}
+		if bi.cmp(bi.key, target) < 0 {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if target == nil {
+		lo = bi.numRestarts - 1
+	}
+	// Scan forward from the restart point, remembering the last entry
+	// which precedes target.
+	var key, value []byte
+	var nextOffset int
+	found := false
+	bi.key = bi.key[:0]
+	for bi.decodeAt(bi.restartPoint(lo)); bi.valid && (target == nil || bi.cmp(bi.key, target) < 0); bi.next() {
+		key = append(key[:0], bi.key...)
+		value, nextOffset, found = bi.value, bi.nextOffset, true
+	}
+	if bi.err != nil {
+		return
+	}
+	bi.valid = found
+	if found {
+		bi.key = append(bi.key[:0], key...)
+		bi.value, bi.nextOffset = value, nextOffset
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+/*
+Package sstable reads and writes SSTables in RocksDB's block-based
+table format from Go, for use by export, bulk ingestion and debug
+tooling which would otherwise round-trip through cgo for every key.
+
+A table consists of a sequence of data blocks, followed by a
+properties block, a metaindex block, an index block and a fixed size
+footer. Each block is a sequence of prefix-compressed entries with
+periodic restart points, followed by a trailer of a compression type
+byte and a masked CRC-32C checksum. The index block maps the last key
+of each data block to the block's location; the metaindex block maps
+"rocksdb.properties" to the properties block.
+
+Keys are stored as RocksDB internal keys: the user key followed by an
+8-byte trailer holding a sequence number and entry kind. The Writer
+assigns sequence number zero to every entry, as RocksDB's own table
+writer does for files intended for ingestion. Tables are written with
+the LevelDB-compatible footer, which RocksDB reads as a legacy
+block-based table. The Reader also accepts tables written by RocksDB
+with the newer footer, uncompressed or compressed with Snappy; this is
+tested against tables flushed by the storage engine. The engine has no
+way to ingest external tables yet, so tables produced by the Writer
+are only known to be read back by the Reader.
+*/
+package sstable
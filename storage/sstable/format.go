@@ -0,0 +1,157 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package sstable
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// Compression is the compression applied to a block.
+type Compression uint8
+
+const (
+	// NoCompression stores blocks uncompressed.
+	NoCompression Compression = 0
+	// SnappyCompression compresses blocks with Snappy.
+	SnappyCompression Compression = 1
+)
+
+// Kind is the kind of a table entry.
+type Kind uint8
+
+const (
+	// KindDelete is a deletion tombstone.
+	KindDelete Kind = 0
+	// KindValue is a value.
+	KindValue Kind = 1
+	// KindMerge is a merge operand.
+	KindMerge Kind = 2
+)
+
+const (
+	// blockTrailerLen is the length of the compression type byte and
+	// checksum following each block.
+	blockTrailerLen = 5
+	// blockHandleMaxLen is the maximum length of an encoded block
+	// handle: two varint64s.
+	blockHandleMaxLen = 20
+	// legacyFooterLen is the length of the LevelDB-compatible footer:
+	// metaindex and index handles padded to 40 bytes, then the magic
+	// number.
+	legacyFooterLen = 2*blockHandleMaxLen + 8
+	// footerLen is the length of the footer written by RocksDB: a
+	// checksum type byte, the padded handles, a format version and the
+	// magic number.
+	footerLen = 1 + 2*blockHandleMaxLen + 4 + 8
+
+	legacyMagic     uint64 = 0xdb4775248b80fb57
+	blockBasedMagic uint64 = 0x88e241b785f4cff7
+
+	checksumNone   = 0
+	checksumCRC32c = 1
+
+	// internalKeyTrailerLen is the length of the sequence number and
+	// kind appended to user keys.
+	internalKeyTrailerLen = 8
+	// maxSequence is the largest sequence number. Seeking to a user key
+	// with maxSequence positions before all entries for that key.
+	maxSequence = 1<<56 - 1
+
+	propertiesBlockName = "rocksdb.properties"
+	crcMaskDelta        = 0xa282ead8
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// blockChecksum returns the checksum stored in the trailer of a block:
+// the masked CRC-32C of its contents followed by its compression type.
+// Checksums are masked as CRCs of data containing embedded CRCs are
+// otherwise prone to collisions.
+func blockChecksum(contents []byte, compression byte) uint32 {
+	c := crc32.Update(0, crcTable, contents)
+	c = crc32.Update(c, crcTable, []byte{compression})
+	return (c>>15 | c<<17) + crcMaskDelta
+}
+
+// A blockHandle is the location of a block within a table.
+type blockHandle struct {
+	offset, size uint64
+}
+
+// encode appends the encoded block handle to b.
+func (h blockHandle) encode(b []byte) []byte {
+	var buf [blockHandleMaxLen]byte
+	n := binary.PutUvarint(buf[:], h.offset)
+	n += binary.PutUvarint(buf[n:], h.size)
+	return append(b, buf[:n]...)
+}
+
+// decodeBlockHandle decodes a block handle from the start of b,
+// returning it and the number of bytes consumed.
+func decodeBlockHandle(b []byte) (blockHandle, int, error) {
+	offset, n := binary.Uvarint(b)
+	if n <= 0 {
+		return blockHandle{}, 0, util.Errorf("corrupt block handle")
+	}
+	size, m := binary.Uvarint(b[n:])
+	if m <= 0 {
+		return blockHandle{}, 0, util.Errorf("corrupt block handle")
+	}
+	return blockHandle{offset, size}, n + m, nil
+}
+
+// makeInternalKey appends the trailer for seq and kind to a copy of
+// userKey.
+func makeInternalKey(userKey []byte, seq uint64, kind Kind) []byte {
+	ikey := make([]byte, len(userKey)+internalKeyTrailerLen)
+	copy(ikey, userKey)
+	binary.LittleEndian.PutUint64(ikey[len(userKey):], seq<<8|uint64(kind))
+	return ikey
+}
+
+// splitInternalKey returns the user key and trailer of an internal
+// key. Keys too short to hold a trailer are returned as user keys
+// with a zero trailer.
+func splitInternalKey(ikey []byte) ([]byte, uint64) {
+	n := len(ikey) - internalKeyTrailerLen
+	if n < 0 {
+		return ikey, 0
+	}
+	return ikey[:n], binary.LittleEndian.Uint64(ikey[n:])
+}
+
+// internalCompare returns a function ordering internal keys by user
+// key according to cmp, then by decreasing trailer, so that newer
+// entries for a user key sort first.
+func internalCompare(cmp func(a, b []byte) int) func(a, b []byte) int {
+	return func(a, b []byte) int {
+		aKey, aTrailer := splitInternalKey(a)
+		bKey, bTrailer := splitInternalKey(b)
+		if c := cmp(aKey, bKey); c != 0 {
+			return c
+		}
+		switch {
+		case aTrailer > bTrailer:
+			return -1
+		case aTrailer < bTrailer:
+			return 1
+		}
+		return 0
+	}
+}
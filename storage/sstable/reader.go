@@ -0,0 +1,314 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"code.google.com/p/snappy-go/snappy"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// A Reader reads a table. Readers are safe for concurrent use.
+type Reader struct {
+	r            io.ReaderAt
+	cmp          func(a, b []byte) int // Compares internal keys
+	userCmp      func(a, b []byte) int
+	checksumType byte
+	index        []byte
+	props        Properties
+}
+
+// NewReader returns a Reader for the table of size bytes read from r.
+// opts may be nil to use the defaults; only Options.Compare is used.
+func NewReader(r io.ReaderAt, size int64, opts *Options) (*Reader, error) {
+	o := opts.withDefaults()
+	rd := &Reader{
+		r:       r,
+		cmp:     internalCompare(o.Compare),
+		userCmp: o.Compare,
+	}
+	if size < legacyFooterLen {
+		return nil, util.Errorf("invalid table: size %d is less than the footer", size)
+	}
+	footer := make([]byte, footerLen)
+	if size < footerLen {
+		footer = footer[:size]
+	}
+	if _, err := r.ReadAt(footer, size-int64(len(footer))); err != nil {
+		return nil, util.Errorf("unable to read table footer: %s", err)
+	}
+	var handles []byte
+	switch magic := binary.LittleEndian.Uint64(footer[len(footer)-8:]); magic {
+	case legacyMagic:
+		rd.checksumType = checksumCRC32c
+		handles = footer[len(footer)-legacyFooterLen:]
+	case blockBasedMagic:
+		if len(footer) < footerLen {
+			return nil, util.Errorf("invalid table: size %d is less than the footer", size)
+		}
+		rd.checksumType = footer[0]
+		if rd.checksumType != checksumNone && rd.checksumType != checksumCRC32c {
+			return nil, util.Errorf("unsupported table checksum type %d", rd.checksumType)
+		}
+		handles = footer[1:]
+	default:
+		return nil, util.Errorf("invalid table: bad magic number %x", magic)
+	}
+	metaindexHandle, n, err := decodeBlockHandle(handles)
+	if err != nil {
+		return nil, err
+	}
+	indexHandle, _, err := decodeBlockHandle(handles[n:])
+	if err != nil {
+		return nil, err
+	}
+	if rd.index, err = rd.readBlock(indexHandle); err != nil {
+		return nil, err
+	}
+	if err := rd.readProperties(metaindexHandle); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// readBlock reads the block at handle, verifying its checksum and
+// decompressing it if necessary.
+func (r *Reader) readBlock(handle blockHandle) ([]byte, error) {
+	b := make([]byte, handle.size+blockTrailerLen)
+	if _, err := r.r.ReadAt(b, int64(handle.offset)); err != nil {
+		return nil, util.Errorf("unable to read block at offset %d: %s", handle.offset, err)
+	}
+	contents, compression := b[:handle.size], b[handle.size]
+	if r.checksumType == checksumCRC32c {
+		stored := binary.LittleEndian.Uint32(b[handle.size+1:])
+		if actual := blockChecksum(contents, compression); actual != stored {
+			return nil, util.Errorf("block at offset %d has checksum %x; expected %x", handle.offset, actual, stored)
+		}
+	}
+	switch Compression(compression) {
+	case NoCompression:
+		return contents, nil
+	case SnappyCompression:
+		decoded, err := snappy.Decode(nil, contents)
+		if err != nil {
+			return nil, util.Errorf("unable to decompress block at offset %d: %s", handle.offset, err)
+		}
+		return decoded, nil
+	}
+	return nil, util.Errorf("block at offset %d has unsupported compression type %d", handle.offset, compression)
+}
+
+// readProperties reads the properties block listed in the metaindex
+// block at handle, if any.
+func (r *Reader) readProperties(metaindexHandle blockHandle) error {
+	b, err := r.readBlock(metaindexHandle)
+	if err != nil {
+		return err
+	}
+	metaindex, err := newBlockIter(b, bytes.Compare)
+	if err != nil {
+		return err
+	}
+	r.props.UserProperties = map[string]string{}
+	metaindex.seek([]byte(propertiesBlockName))
+	if metaindex.err != nil {
+		return metaindex.err
+	}
+	if !metaindex.valid || string(metaindex.key) != propertiesBlockName {
+		return nil
+	}
+	handle, _, err := decodeBlockHandle(metaindex.value)
+	if err != nil {
+		return err
+	}
+	if b, err = r.readBlock(handle); err != nil {
+		return err
+	}
+	props, err := newBlockIter(b, bytes.Compare)
+	if err != nil {
+		return err
+	}
+	return r.props.decode(props)
+}
+
+// Properties returns the table's properties.
+func (r *Reader) Properties() Properties {
+	return r.props
+}
+
+// Get returns the value of the newest entry for key, or nil if the
+// table has no entries for the key or its newest entry isn't a value.
+func (r *Reader) Get(key []byte) ([]byte, error) {
+	iter := r.NewIterator()
+	defer iter.Close()
+	iter.Seek(key)
+	if !iter.Valid() || r.userCmp(iter.Key(), key) != 0 || iter.Kind() != KindValue {
+		return nil, iter.Error()
+	}
+	return iter.Value(), nil
+}
+
+// NewIterator returns an iterator over the table's entries. Iterators
+// are not safe for concurrent use.
+func (r *Reader) NewIterator() *Iterator {
+	index, err := newBlockIter(r.index, r.cmp)
+	return &Iterator{r: r, index: index, err: err}
+}
+
+// An Iterator iterates over the entries of a table in key order. For
+// each user key, entries are visited newest first. It implements the
+// engine.Iterator interface.
+type Iterator struct {
+	r     *Reader
+	index *blockIter
+	data  *blockIter
+	err   error
+}
+
+// Close is a noop for table iterators.
+func (i *Iterator) Close() {
+}
+
+// Seek positions the iterator at the first entry with a key >= the
+// provided key.
+func (i *Iterator) Seek(key []byte) {
+	if i.err != nil {
+		return
+	}
+	i.data = nil
+	target := makeInternalKey(key, maxSequence, KindValue)
+	i.index.seek(target)
+	if !i.loadBlock() {
+		return
+	}
+	i.data.seek(target)
+	i.skipEmptyBlocks()
+}
+
+// SeekReverse positions the iterator at the last entry with a user
+// key < the provided key. If key is empty, the iterator is positioned
+// at the last entry in the table.
+func (i *Iterator) SeekReverse(key []byte) {
+	if i.err != nil {
+		return
+	}
+	i.data = nil
+	var target []byte
+	if len(key) > 0 {
+		target = makeInternalKey(key, maxSequence, KindValue)
+		i.index.seek(target)
+	}
+	if target == nil || (!i.index.valid && i.index.err == nil) {
+		i.index.seekLT(nil)
+	}
+	if !i.loadBlock() {
+		return
+	}
+	i.data.seekLT(target)
+	if i.data.valid || i.data.err != nil {
+		i.err = i.data.err
+		return
+	}
+	// Every entry in this block is >= target; the entry we want is the
+	// last one of the preceding block, if any.
+	i.index.seekLT(append([]byte(nil), i.index.key...))
+	if !i.loadBlock() {
+		return
+	}
+	i.data.seekLT(nil)
+	i.err = i.data.err
+}
+
+// Valid returns true if the iterator is positioned at an entry.
+func (i *Iterator) Valid() bool {
+	return i.err == nil && i.data != nil && i.data.valid
+}
+
+// Next advances the iterator to the next entry.
+func (i *Iterator) Next() {
+	if !i.Valid() {
+		i.err = util.Errorf("next called with invalid iterator")
+		return
+	}
+	i.data.next()
+	i.skipEmptyBlocks()
+}
+
+// Key returns the user key of the current entry.
+func (i *Iterator) Key() []byte {
+	key, _ := splitInternalKey(i.data.key)
+	return key
+}
+
+// Value returns the value of the current entry.
+func (i *Iterator) Value() []byte {
+	return i.data.value
+}
+
+// Kind returns the kind of the current entry.
+func (i *Iterator) Kind() Kind {
+	_, trailer := splitInternalKey(i.data.key)
+	return Kind(trailer & 0xff)
+}
+
+// Error returns the error, if any, which the iterator encountered.
+func (i *Iterator) Error() error {
+	return i.err
+}
+
+// loadBlock reads the data block at the index's current position.
+// Returns false if the index is exhausted or an error occurred.
+func (i *Iterator) loadBlock() bool {
+	i.data = nil
+	if !i.index.valid {
+		i.err = i.index.err
+		return false
+	}
+	handle, _, err := decodeBlockHandle(i.index.value)
+	if err != nil {
+		i.err = err
+		return false
+	}
+	b, err := i.r.readBlock(handle)
+	if err != nil {
+		i.err = err
+		return false
+	}
+	if i.data, err = newBlockIter(b, i.r.cmp); err != nil {
+		i.err = err
+		return false
+	}
+	return true
+}
+
+// skipEmptyBlocks advances to the first entry of the following data
+// blocks while the current block is exhausted.
+func (i *Iterator) skipEmptyBlocks() {
+	for i.data != nil && !i.data.valid {
+		if i.data.err != nil {
+			i.err = i.data.err
+			return
+		}
+		i.index.next()
+		if !i.loadBlock() {
+			return
+		}
+		i.data.seekToFirst()
+	}
+}
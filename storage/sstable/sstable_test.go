@@ -0,0 +1,190 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// buildTable writes a table with n entries, mapping "key-%05d" to
+// "value-%05d" for even numbers below 2n.
+func buildTable(t *testing.T, n int, opts *Options) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, opts)
+	for i := 0; i < n; i++ {
+		if err := w.Add([]byte(fmt.Sprintf("key-%05d", 2*i)), []byte(fmt.Sprintf("value-%05d", 2*i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.SetProperty("test.prop", "test-value")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestTableRoundTrip verifies that tables of various sizes and
+// options can be read back by iteration, seeks and gets.
+func TestTableRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 5000} {
+		for _, opts := range []*Options{
+			nil,
+			{BlockSize: 256, BlockRestartInterval: 4},
+			{BlockSize: 256, Compression: SnappyCompression},
+		} {
+			table := buildTable(t, n, opts)
+			r, err := NewReader(bytes.NewReader(table), int64(len(table)), opts)
+			if err != nil {
+				t.Fatalf("n=%d %+v: %s", n, opts, err)
+			}
+
+			// Scan all entries.
+			iter := r.NewIterator()
+			count := 0
+			for iter.Seek(nil); iter.Valid(); iter.Next() {
+				if expKey := fmt.Sprintf("key-%05d", 2*count); string(iter.Key()) != expKey {
+					t.Fatalf("n=%d %+v: expected key %q; got %q", n, opts, expKey, iter.Key())
+				}
+				if expValue := fmt.Sprintf("value-%05d", 2*count); string(iter.Value()) != expValue {
+					t.Fatalf("n=%d %+v: expected value %q; got %q", n, opts, expValue, iter.Value())
+				}
+				if iter.Kind() != KindValue {
+					t.Fatalf("n=%d %+v: expected value kind; got %d", n, opts, iter.Kind())
+				}
+				count++
+			}
+			if err := iter.Error(); err != nil {
+				t.Fatal(err)
+			}
+			if count != n {
+				t.Errorf("n=%d %+v: expected %d entries; got %d", n, opts, n, count)
+			}
+
+			// Seek to present and absent keys.
+			for i := 0; i < 2*n; i += 7 {
+				iter.Seek([]byte(fmt.Sprintf("key-%05d", i)))
+				expIdx := i + i%2
+				if expIdx >= 2*n {
+					if iter.Valid() {
+						t.Errorf("n=%d %+v: expected seek to %d to exhaust iterator; got %q", n, opts, i, iter.Key())
+					}
+					continue
+				}
+				if !iter.Valid() || string(iter.Key()) != fmt.Sprintf("key-%05d", expIdx) {
+					t.Errorf("n=%d %+v: seek to %d: expected key %d; got valid=%t", n, opts, i, expIdx, iter.Valid())
+				}
+				value, err := r.Get([]byte(fmt.Sprintf("key-%05d", i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (value != nil) != (i%2 == 0) {
+					t.Errorf("n=%d %+v: get of %d: unexpected value %q", n, opts, i, value)
+				}
+			}
+
+			// Reverse seeks land on the preceding key and iterate forward
+			// from there.
+			for i := 0; i <= 2*n; i += 7 {
+				iter.SeekReverse([]byte(fmt.Sprintf("key-%05d", i)))
+				expIdx := i - 2 + i%2
+				if expIdx < 0 {
+					if iter.Valid() {
+						t.Errorf("n=%d %+v: expected reverse seek to %d to exhaust iterator; got %q", n, opts, i, iter.Key())
+					}
+					continue
+				}
+				if !iter.Valid() || string(iter.Key()) != fmt.Sprintf("key-%05d", expIdx) {
+					t.Fatalf("n=%d %+v: reverse seek to %d: expected key %d; got valid=%t", n, opts, i, expIdx, iter.Valid())
+				}
+				if iter.Next(); expIdx+2 < 2*n && (!iter.Valid() || string(iter.Key()) != fmt.Sprintf("key-%05d", expIdx+2)) {
+					t.Errorf("n=%d %+v: expected key %d after reverse seek to %d", n, opts, expIdx+2, i)
+				}
+			}
+			iter.SeekReverse(nil)
+			if n == 0 && iter.Valid() {
+				t.Errorf("n=%d %+v: expected reverse seek to end of empty table to be invalid", n, opts)
+			} else if n > 0 && (!iter.Valid() || string(iter.Key()) != fmt.Sprintf("key-%05d", 2*n-2)) {
+				t.Errorf("n=%d %+v: expected reverse seek to end to find last key", n, opts)
+			}
+			if err := iter.Error(); err != nil {
+				t.Fatal(err)
+			}
+
+			props := r.Properties()
+			if props.NumEntries != uint64(n) {
+				t.Errorf("n=%d %+v: expected %d entries in properties; got %d", n, opts, n, props.NumEntries)
+			}
+			if n > 0 && props.NumDataBlocks == 0 {
+				t.Errorf("n=%d %+v: expected data blocks in properties", n, opts)
+			}
+			if !reflect.DeepEqual(props.UserProperties, map[string]string{"test.prop": "test-value"}) {
+				t.Errorf("n=%d %+v: unexpected user properties %v", n, opts, props.UserProperties)
+			}
+		}
+	}
+}
+
+// TestTableCompression verifies that Snappy compression shrinks
+// compressible tables.
+func TestTableCompression(t *testing.T) {
+	uncompressed := buildTable(t, 1000, nil)
+	compressed := buildTable(t, 1000, &Options{Compression: SnappyCompression})
+	if len(compressed) >= len(uncompressed) {
+		t.Errorf("expected compressed table to be smaller: %d >= %d", len(compressed), len(uncompressed))
+	}
+}
+
+// TestTableOutOfOrder verifies that keys must be added in strictly
+// increasing order.
+func TestTableOutOfOrder(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{}, nil)
+	if err := w.Add([]byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := w.Add([]byte(key), nil); err == nil {
+			t.Errorf("expected error adding %q after \"b\"", key)
+		}
+	}
+}
+
+// TestTableCorruption verifies that corrupted blocks and footers are
+// detected.
+func TestTableCorruption(t *testing.T) {
+	table := buildTable(t, 100, nil)
+
+	corrupt := append([]byte(nil), table...)
+	corrupt[10]++
+	r, err := NewReader(bytes.NewReader(corrupt), int64(len(corrupt)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter := r.NewIterator()
+	for iter.Seek(nil); iter.Valid(); iter.Next() {
+	}
+	if iter.Error() == nil {
+		t.Error("expected checksum error reading corrupted data block")
+	}
+
+	corrupt = append([]byte(nil), table...)
+	corrupt[len(corrupt)-1]++
+	if _, err := NewReader(bytes.NewReader(corrupt), int64(len(corrupt)), nil); err == nil {
+		t.Error("expected error reading table with corrupted magic number")
+	}
+}
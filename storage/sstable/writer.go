@@ -0,0 +1,272 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"code.google.com/p/snappy-go/snappy"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+const (
+	defaultBlockSize            = 4 << 10
+	defaultBlockRestartInterval = 16
+)
+
+// Options configure the writing and reading of tables.
+type Options struct {
+	// BlockSize is the target uncompressed size of data blocks.
+	// Defaults to 4KB.
+	BlockSize int
+	// BlockRestartInterval is the number of keys between restart points
+	// in data blocks. Defaults to 16.
+	BlockRestartInterval int
+	// Compression is applied to data blocks which it shrinks by at
+	// least 12.5%. Defaults to NoCompression.
+	Compression Compression
+	// Compare orders user keys and must match the comparator of any
+	// RocksDB instance the table is ingested into; for tables of MVCC
+	// keys, wrap engine.MVCCComparator, whose arguments are
+	// proto.EncodedKeys. Defaults to bytes.Compare.
+	Compare func(a, b []byte) int
+}
+
+// withDefaults returns a copy of opts with unset fields defaulted.
+func (opts *Options) withDefaults() Options {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.BlockSize <= 0 {
+		o.BlockSize = defaultBlockSize
+	}
+	if o.BlockRestartInterval <= 0 {
+		o.BlockRestartInterval = defaultBlockRestartInterval
+	}
+	if o.Compare == nil {
+		o.Compare = bytes.Compare
+	}
+	return o
+}
+
+// A Writer writes a table. Entries must be added in strictly
+// increasing key order, after which Close finishes the table. Writer
+// doesn't close the underlying io.Writer.
+type Writer struct {
+	w          io.Writer
+	opts       Options
+	offset     uint64
+	err        error
+	closed     bool
+	block      *blockWriter
+	index      *blockWriter
+	lastKey    []byte // Internal key of the last entry
+	hasLastKey bool
+	props      Properties
+	scratch    []byte
+}
+
+// NewWriter returns a Writer which writes a table to w. opts may be
+// nil to use the defaults.
+func NewWriter(w io.Writer, opts *Options) *Writer {
+	o := opts.withDefaults()
+	return &Writer{
+		w:     w,
+		opts:  o,
+		block: newBlockWriter(o.BlockRestartInterval),
+		index: newBlockWriter(1),
+		props: Properties{UserProperties: map[string]string{}},
+	}
+}
+
+// Add adds the value for key to the table. Keys must be added in
+// strictly increasing order.
+func (w *Writer) Add(key, value []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return util.Errorf("table writer is closed")
+	}
+	if w.hasLastKey {
+		if lastUserKey, _ := splitInternalKey(w.lastKey); w.opts.Compare(key, lastUserKey) <= 0 {
+			return util.Errorf("keys must be added in strictly increasing order: %q after %q", key, lastUserKey)
+		}
+	}
+	ikey := makeInternalKey(key, 0, KindValue)
+	w.block.add(ikey, value)
+	w.lastKey, w.hasLastKey = ikey, true
+	w.props.NumEntries++
+	w.props.RawKeySize += uint64(len(ikey))
+	w.props.RawValueSize += uint64(len(value))
+	if w.block.estimatedSize() >= w.opts.BlockSize {
+		w.flushBlock()
+	}
+	return w.err
+}
+
+// SetProperty sets a user property, which is stored in the table's
+// properties block.
+func (w *Writer) SetProperty(name, value string) {
+	w.props.UserProperties[name] = value
+}
+
+// flushBlock writes the pending data block, if any, and adds its last
+// key and location to the index.
+func (w *Writer) flushBlock() {
+	if w.err != nil || w.block.empty() {
+		return
+	}
+	handle := w.writeBlock(w.block.finish(), w.opts.Compression)
+	w.index.add(w.lastKey, handle.encode(nil))
+	w.block.reset()
+	w.props.NumDataBlocks++
+}
+
+// writeBlock writes a block with its trailer and returns its location.
+// The block is compressed if doing so saves at least 12.5%.
+func (w *Writer) writeBlock(contents []byte, compression Compression) blockHandle {
+	if w.err != nil {
+		return blockHandle{}
+	}
+	if compression == SnappyCompression {
+		compressed, err := snappy.Encode(w.scratch[:cap(w.scratch)], contents)
+		if err == nil && len(compressed) < len(contents)-len(contents)/8 {
+			w.scratch, contents = compressed, compressed
+		} else {
+			compression = NoCompression
+		}
+	}
+	var trailer [blockTrailerLen]byte
+	trailer[0] = byte(compression)
+	binary.LittleEndian.PutUint32(trailer[1:], blockChecksum(contents, trailer[0]))
+	handle := blockHandle{offset: w.offset, size: uint64(len(contents))}
+	w.write(contents)
+	w.write(trailer[:])
+	return handle
+}
+
+// write writes b to the underlying writer, recording the first error.
+func (w *Writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(b)
+	w.offset += uint64(n)
+}
+
+// Close writes any pending data block, followed by the properties,
+// metaindex and index blocks and the footer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	w.flushBlock()
+	w.props.DataSize = w.offset
+	indexContents := w.index.finish()
+	w.props.IndexSize = uint64(len(indexContents) + blockTrailerLen)
+
+	props := newBlockWriter(1)
+	w.props.encode(props)
+	propsHandle := w.writeBlock(props.finish(), NoCompression)
+
+	metaindex := newBlockWriter(1)
+	metaindex.add([]byte(propertiesBlockName), propsHandle.encode(nil))
+	metaindexHandle := w.writeBlock(metaindex.finish(), NoCompression)
+
+	indexHandle := w.writeBlock(indexContents, NoCompression)
+
+	var footer [legacyFooterLen]byte
+	copy(footer[:], indexHandle.encode(metaindexHandle.encode(nil)))
+	binary.LittleEndian.PutUint64(footer[legacyFooterLen-8:], legacyMagic)
+	w.write(footer[:])
+	return w.err
+}
+
+// Properties are the properties of a table.
+type Properties struct {
+	DataSize      uint64 // Total size of data blocks, including trailers
+	IndexSize     uint64 // Size of the index block, including its trailer
+	FilterSize    uint64 // Size of the filter block; always zero when written by Writer
+	NumDataBlocks uint64
+	NumEntries    uint64
+	RawKeySize    uint64 // Total size of keys, including internal key trailers
+	RawValueSize  uint64
+	FormatVersion uint64
+	FixedKeyLen   uint64
+	// UserProperties are the properties set with Writer.SetProperty, as
+	// well as any non-numeric properties written by RocksDB.
+	UserProperties map[string]string
+}
+
+// numericProperties maps the names of numeric properties, which are
+// stored as varints, to their fields.
+func (p *Properties) numericProperties() map[string]*uint64 {
+	return map[string]*uint64{
+		"rocksdb.data.size":        &p.DataSize,
+		"rocksdb.index.size":       &p.IndexSize,
+		"rocksdb.filter.size":      &p.FilterSize,
+		"rocksdb.num.data.blocks":  &p.NumDataBlocks,
+		"rocksdb.num.entries":      &p.NumEntries,
+		"rocksdb.raw.key.size":     &p.RawKeySize,
+		"rocksdb.raw.value.size":   &p.RawValueSize,
+		"rocksdb.format.version":   &p.FormatVersion,
+		"rocksdb.fixed.key.length": &p.FixedKeyLen,
+	}
+}
+
+// encode adds the properties to a block in sorted order.
+func (p *Properties) encode(bw *blockWriter) {
+	values := map[string][]byte{}
+	for name, v := range p.numericProperties() {
+		var buf [binary.MaxVarintLen64]byte
+		values[name] = buf[:binary.PutUvarint(buf[:], *v)]
+	}
+	for name, v := range p.UserProperties {
+		values[name] = []byte(v)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bw.add([]byte(name), values[name])
+	}
+}
+
+// decode sets the properties from the entries of a properties block.
+func (p *Properties) decode(bi *blockIter) error {
+	p.UserProperties = map[string]string{}
+	numeric := p.numericProperties()
+	for bi.seekToFirst(); bi.valid; bi.next() {
+		if v, ok := numeric[string(bi.key)]; ok {
+			var n int
+			if *v, n = binary.Uvarint(bi.value); n <= 0 {
+				return util.Errorf("corrupt table property %q", bi.key)
+			}
+			continue
+		}
+		p.UserProperties[string(bi.key)] = string(bi.value)
+	}
+	return bi.err
+}
@@ -22,9 +22,11 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"runtime"
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/cockroach/client"
@@ -34,6 +36,7 @@ import (
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metrics"
 	gogoproto "github.com/gogo/protobuf/proto"
 )
 
@@ -52,6 +55,12 @@ const (
 	// defaultScanInterval is the default value for the scan interval
 	// command line flag.
 	defaultScanInterval = 10 * time.Minute
+	// startupLogInterval is the interval at which progress is logged
+	// while loading ranges on store startup.
+	startupLogInterval = 5 * time.Second
+	// defaultGCTTL is the default value for the default GC TTL command
+	// line flag.
+	defaultGCTTL = 24 * time.Hour
 )
 
 var (
@@ -67,6 +76,16 @@ var (
 		"--scan_interval to adjust the target for the duration of a single scan "+
 		"through a store's ranges. The scan is slowed as necessary to approximately"+
 		"achieve this duration.")
+
+	startupConcurrency = flag.Int("store_startup_concurrency", runtime.NumCPU(), "specify "+
+		"--store_startup_concurrency to adjust the number of goroutines used to "+
+		"scan range descriptors and instantiate ranges when a store starts.")
+
+	defaultGCTTLFlag = flag.Duration("default_gc_ttl", defaultGCTTL, "specify "+
+		"--default_gc_ttl to set the GC TTL of the default zone config written "+
+		"when a cluster is bootstrapped. Values older than the TTL are garbage "+
+		"collected unless a read has protected them. The cluster-wide setting "+
+		"may later be changed via the default zone config.")
 )
 
 // verifyKeyLength verifies key length. Extra key length is allowed for
@@ -150,6 +169,9 @@ type NodeDescriptor struct {
 	NodeID  int32
 	Address net.Addr
 	Attrs   proto.Attributes // node specific attributes (e.g. datacenter, machine info)
+	// Standby is true if the node is in read-only standby mode: it keeps
+	// serving its existing replicas but isn't allocated new ones.
+	Standby bool
 }
 
 // StoreDescriptor holds store information including store attributes,
@@ -161,6 +183,18 @@ type StoreDescriptor struct {
 	Capacity engine.StoreCapacity
 }
 
+// StoreSummary is a compact summary of a store's ranges, their total
+// size and request rate, which nodes periodically gossip so that
+// cluster-wide totals are available without querying every node.
+type StoreSummary struct {
+	NodeID     int32
+	StoreID    int32
+	RangeCount int
+	Bytes      int64   // Total size of the store's ranges
+	QPS        float64 // Total request rate of the store's ranges
+	Timestamp  int64   // Wall time in nanoseconds at which computed
+}
+
 // CombinedAttrs returns the full list of attributes for the store,
 // including both the node and store attributes.
 func (s *StoreDescriptor) CombinedAttrs() *proto.Attributes {
@@ -218,20 +252,33 @@ func (si *storeRangeIterator) reset() {
 type Store struct {
 	*StoreFinder
 
-	Ident       proto.StoreIdent
-	clock       *hlc.Clock
-	engine      engine.Engine  // The underlying key-value store
-	db          *client.KV     // Cockroach KV DB
-	allocator   *allocator     // Makes allocation decisions
-	gossip      *gossip.Gossip // Configs and store capacities
-	raftIDAlloc *IDAllocator   // Raft ID allocator
-	configMu    sync.Mutex     // Limit config update processing
-	raft        raft
-	closer      chan struct{}
-
-	mu          sync.RWMutex     // Protects variables below...
-	ranges      map[int64]*Range // Map of ranges by Raft ID
-	rangesByKey RangeSlice       // Sorted slice of ranges by StartKey
+	Ident        proto.StoreIdent
+	clock        *hlc.Clock
+	engine       engine.Engine  // The underlying key-value store
+	db           *client.KV     // Cockroach KV DB
+	allocator    *allocator     // Makes allocation decisions
+	gossip       *gossip.Gossip // Configs and store capacities
+	raftIDAlloc  *IDAllocator   // Raft ID allocator
+	configMu     sync.Mutex     // Limit config update processing
+	raft         raft
+	scanner      *rangeScanner     // Paces iteration of ranges through queues
+	throttle     *writeThrottle    // Delays writes when compactions fall behind
+	consistencyQ *consistencyQueue // Compares replica checksums
+	gcQ          *gcQueue          // Removes expired MVCC versions
+	splitQ       *splitQueue       // Splits oversized ranges
+	closer       chan struct{}
+
+	// Progress of loading ranges on startup, exported as gauges while
+	// the store is started. Accessed atomically.
+	rangesLoaded int64
+	loadNanos    int64 // Duration of the completed load
+	metricPrefix string
+
+	mu                  sync.RWMutex             // Protects variables below...
+	ranges              map[int64]*Range         // Map of ranges by Raft ID
+	rangesByKey         RangeSlice               // Sorted slice of ranges by StartKey
+	rangeEventCallbacks []RangeEventCallback     // Notified of range changes
+	snapshots           map[string]engine.Engine // Engine snapshots by ID
 }
 
 // NewStore returns a new instance of a store.
@@ -246,13 +293,29 @@ func NewStore(clock *hlc.Clock, eng engine.Engine, db *client.KV, gossip *gossip
 		gossip:    gossip,
 		closer:    make(chan struct{}),
 		ranges:    map[int64]*Range{},
+		snapshots: map[string]engine.Engine{},
 	}
+	s.throttle = newWriteThrottle(eng)
+	s.consistencyQ = newConsistencyQueue(clock)
+	s.gcQ = newGCQueue(clock)
+	s.splitQ = newSplitQueue()
 	s.allocator.storeFinder = s.findStores
 	return s
 }
 
-// Stop calls Range.Stop() on all active ranges.
+// Stop calls Range.Stop() on all active ranges and releases any
+// outstanding snapshots.
 func (s *Store) Stop() {
+	// Stop the scanner outside of the lock, as its iterator acquires
+	// the store lock.
+	s.mu.Lock()
+	scanner := s.scanner
+	s.scanner = nil
+	s.mu.Unlock()
+	if scanner != nil {
+		scanner.stop()
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.raft != nil {
@@ -262,6 +325,20 @@ func (s *Store) Stop() {
 	for _, rng := range s.ranges {
 		rng.stop()
 	}
+	s.throttle.stop()
+	if s.metricPrefix != "" {
+		for _, name := range []string{"startup.ranges_loaded", "startup.duration_ms"} {
+			metrics.Metrics.DeregisterGaugeFunc(s.metricPrefix + name)
+		}
+		s.metricPrefix = ""
+	}
+	s.consistencyQ.stop()
+	s.gcQ.stop()
+	s.splitQ.stop()
+	for _, snap := range s.snapshots {
+		snap.Stop()
+	}
+	s.snapshots = map[string]engine.Engine{}
 	s.ranges = map[int64]*Range{}
 	s.rangesByKey = nil
 	close(s.closer)
@@ -274,9 +351,17 @@ func (s *Store) String() string {
 }
 
 // Start the engine, set the GC and read the StoreIdent.
-func (s *Store) Start() error {
+func (s *Store) Start() (err error) {
 	// Stop store for idempotency.
 	s.Stop()
+	// Stop anything started here, such as loaded ranges, if a later
+	// step fails. The engine is left running for the caller, which
+	// owns it and may still bootstrap the store.
+	defer func() {
+		if err != nil {
+			s.Stop()
+		}
+	}()
 
 	// Start engine (i.e. open and initialize RocksDB database).
 	if err := s.engine.Start(); err != nil {
@@ -302,36 +387,45 @@ func (s *Store) Start() error {
 		return &NotBootstrappedError{}
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	start := engine.KeyLocalRangeDescriptorPrefix
-	end := start.PrefixEnd()
-
-	// Iterate over all range descriptors, using just committed
-	// versions. Uncommitted intents which have been abandoned due to a
-	// split crashing halfway will simply be resolved on the next split
-	// attempt. They can otherwise be ignored.
-	if err := engine.MVCCIterateCommitted(s.engine, start, end, func(kv proto.KeyValue) (bool, error) {
-		var desc proto.RangeDescriptor
-		if err := gogoproto.Unmarshal(kv.Value.Bytes, &desc); err != nil {
-			return false, err
-		}
-		rng := NewRange(&desc, s)
-		if err := s.addRangeInternal(rng, false /* don't sort on each addition */); err != nil {
-			return false, err
-		}
-		return false, nil
-	}); err != nil {
+	s.metricPrefix = fmt.Sprintf("storage.store.%d.", s.Ident.StoreID)
+	metrics.Metrics.RegisterGaugeFunc(s.metricPrefix+"startup.ranges_loaded", func() float64 {
+		return float64(atomic.LoadInt64(&s.rangesLoaded))
+	})
+	metrics.Metrics.RegisterGaugeFunc(s.metricPrefix+"startup.duration_ms", func() float64 {
+		return float64(atomic.LoadInt64(&s.loadNanos)) / float64(time.Millisecond)
+	})
+	if err := s.loadRanges(); err != nil {
 		return err
 	}
-	// Sort the rangesByKey slice after they've all been added.
-	sort.Sort(s.rangesByKey)
 
-	s.raft = newSingleNodeRaft()
+	snr, err := newSingleNodeRaft()
+	if err != nil {
+		return err
+	}
+	s.raft = snr
 
 	// Start Raft processing goroutine.
 	go s.processRaft(s.raft, s.closer)
 
+	// Start sampling compaction stats to throttle writes before the
+	// engine falls far enough behind to stall them.
+	s.throttle.start(s.Ident.StoreID, s.closer)
+
+	// Start processing the ranges offered to each queue by the range
+	// scanner.
+	s.consistencyQ.start(s.Ident.StoreID, s.closer)
+	s.gcQ.start(s.Ident.StoreID, s.closer)
+	s.splitQ.start(s.Ident.StoreID, s.closer)
+
+	// Start the range scanner, which paces iteration over the store's
+	// ranges to complete approximately one pass per --scan_interval,
+	// offering each range to the store's range queues.
+	scanner := newRangeScanner(*scanInterval, newStoreRangeIterator(s), s.queues())
+	s.mu.Lock()
+	s.scanner = scanner
+	s.mu.Unlock()
+	scanner.start()
+
 	// Register callbacks for any changes to accounting and zone
 	// configurations; we split ranges along prefix boundaries.
 	// Gossip is only ever nil for unittests.
@@ -346,6 +440,103 @@ func (s *Store) Start() error {
 	return nil
 }
 
+// loadRanges reads all range descriptors from the engine and adds a
+// range for each to the store. The descriptors are partitioned by the
+// first byte of their range's start key, and the partitions are
+// scanned in parallel by up to --store_startup_concurrency goroutines,
+// each of which decodes descriptors and instantiates ranges as it
+// reads them. Progress is logged periodically and exported through the
+// store's startup.ranges_loaded and startup.duration_ms gauges.
+func (s *Store) loadRanges() error {
+	startTime := time.Now()
+	atomic.StoreInt64(&s.rangesLoaded, 0)
+	atomic.StoreInt64(&s.loadNanos, 0)
+
+	const partitions = 256
+	ranges := make([][]*Range, partitions)
+	errs := make([]error, partitions)
+	indexes := make(chan int, partitions)
+	for i := 0; i < partitions; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	workers := *startupConcurrency
+	if workers > partitions {
+		workers = partitions
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				// Partition 0 begins with the descriptor of the first
+				// range, whose start key is empty.
+				start := engine.RangeDescriptorKey(proto.Key{byte(i)})
+				if i == 0 {
+					start = engine.KeyLocalRangeDescriptorPrefix
+				}
+				end := engine.KeyLocalRangeDescriptorPrefix.PrefixEnd()
+				if i < partitions-1 {
+					end = engine.RangeDescriptorKey(proto.Key{byte(i + 1)})
+				}
+				// Iterate over the range descriptors, using just
+				// committed versions. Uncommitted intents which have been
+				// abandoned due to a split crashing halfway will simply be
+				// resolved on the next split attempt. They can otherwise
+				// be ignored.
+				errs[i] = engine.MVCCIterateCommitted(s.engine, start, end, func(kv proto.KeyValue) (bool, error) {
+					var desc proto.RangeDescriptor
+					if err := gogoproto.Unmarshal(kv.Value.Bytes, &desc); err != nil {
+						return false, err
+					}
+					ranges[i] = append(ranges[i], NewRange(&desc, s))
+					atomic.AddInt64(&s.rangesLoaded, 1)
+					return false, nil
+				})
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(startupLogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Infof("store %d: loaded %d ranges", s.Ident.StoreID, atomic.LoadInt64(&s.rangesLoaded))
+			case <-done:
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range ranges {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		for _, rng := range ranges[i] {
+			if err := s.addRangeInternal(rng, false /* don't sort on each addition */); err != nil {
+				return err
+			}
+		}
+	}
+	// Sort the rangesByKey slice after they've all been added.
+	sort.Sort(s.rangesByKey)
+	elapsed := time.Since(startTime)
+	atomic.StoreInt64(&s.loadNanos, elapsed.Nanoseconds())
+	log.Infof("store %d: loaded %d ranges in %s", s.Ident.StoreID, len(s.rangesByKey), elapsed)
+	return nil
+}
+
 // configGossipUpdate is a callback for gossip updates to
 // configuration maps which affect range split boundaries.
 func (s *Store) configGossipUpdate(key string, contentsChanged bool) {
@@ -400,10 +591,12 @@ func (s *Store) maybeSplitRangesByConfigs(configMap PrefixConfigMap) {
 			log.Errorf("unable to split range %q-%q by prefix map %s", desc.StartKey, desc.EndKey, configMap)
 			continue
 		}
-		// Gather new splits.
+		// Gather new splits. Prefixes within keyspaces which can't be
+		// split (e.g. the zone of the range addressing records) are
+		// honored without splitting.
 		var splitKeys []proto.Key
 		for _, split := range splits {
-			if split.end.Less(desc.EndKey) {
+			if split.end.Less(desc.EndKey) && engine.IsValidSplitKey(split.end) {
 				splitKeys = append(splitKeys, split.end)
 			}
 		}
@@ -444,6 +637,18 @@ func (s *Store) Bootstrap(ident proto.StoreIdent) error {
 	return err
 }
 
+// ReadBootstrapInfo reads the gossip bootstrap info persisted to the
+// store. Returns false if none has been written.
+func (s *Store) ReadBootstrapInfo(info *proto.GossipBootstrapInfo) (bool, error) {
+	return engine.MVCCGetProto(s.engine, engine.KeyLocalGossipBootstrap, proto.ZeroTimestamp, nil, info)
+}
+
+// WriteBootstrapInfo persists the gossip bootstrap info to the store,
+// replacing any previously written info.
+func (s *Store) WriteBootstrapInfo(info *proto.GossipBootstrapInfo) error {
+	return engine.MVCCPutProto(s.engine, nil, engine.KeyLocalGossipBootstrap, proto.ZeroTimestamp, nil, info)
+}
+
 // GetRange fetches a range by Raft ID. Returns an error if no range is found.
 func (s *Store) GetRange(raftID int64) (*Range, error) {
 	s.mu.RLock()
@@ -454,6 +659,22 @@ func (s *Store) GetRange(raftID int64) (*Range, error) {
 	return nil, proto.NewRangeNotFoundError(raftID)
 }
 
+// VisitRanges implements a visitor pattern over the store's ranges,
+// which are visited in key order. The visitor is invoked with a
+// snapshot of the range list, so it may safely call back into the
+// store. Iteration stops at the first error, which is returned.
+func (s *Store) VisitRanges(visitor func(rng *Range) error) error {
+	s.mu.RLock()
+	ranges := append(RangeSlice(nil), s.rangesByKey...)
+	s.mu.RUnlock()
+	for _, rng := range ranges {
+		if err := visitor(rng); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // LookupRange looks up a range via binary search over the sorted
 // "rangesByKey" RangeSlice. Returns nil if no range is found for
 // specified key range. Note that the specified keys are transformed
@@ -530,6 +751,9 @@ func (s *Store) BootstrapRange() error {
 		},
 		RangeMinBytes: 1048576,
 		RangeMaxBytes: 67108864,
+		GC: &proto.GCPolicy{
+			TTLSeconds: int32(*defaultGCTTLFlag / time.Second),
+		},
 	}
 	key = engine.MakeKey(engine.KeyConfigZonePrefix, engine.KeyMin)
 	if err := engine.MVCCPutProto(batch, ms, key, now, nil, zoneConfig); err != nil {
@@ -580,7 +804,7 @@ func (s *Store) NewRangeDescriptor(start, end proto.Key, replicas []proto.Replic
 
 // SplitRange shortens the original range to accommodate the new
 // range. The new range is added to the ranges map and the rangesByKey
-// sorted slice.
+// sorted slice, and range event callbacks are notified of the split.
 func (s *Store) SplitRange(origRng, newRng *Range) error {
 	if !bytes.Equal(origRng.Desc.EndKey, newRng.Desc.EndKey) ||
 		bytes.Compare(origRng.Desc.StartKey, newRng.Desc.StartKey) >= 0 {
@@ -593,32 +817,80 @@ func (s *Store) SplitRange(origRng, newRng *Range) error {
 	// within a command execution, Desc.EndKey is protected from other
 	// concurrent range accesses.
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	origRng.Desc.EndKey = append([]byte(nil), newRng.Desc.StartKey...)
-	return s.addRangeInternal(newRng, true)
+	origRng.Desc.Generation = newRng.Desc.Generation
+	err := s.addRangeInternal(newRng, true)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.notifyRangeEvent(RangeEventSplit, origRng.Desc, newRng.Desc)
+	return nil
 }
 
 // AddRange adds the range to the store's range map and to the sorted
-// rangesByKey slice.
+// rangesByKey slice, and notifies range event callbacks.
 func (s *Store) AddRange(rng *Range) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.addRangeInternal(rng, true)
+	err := s.addRangeInternal(rng, true)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.notifyRangeEvent(RangeEventAdd, rng.Desc, nil)
+	return nil
+}
+
+// MarkReplicaCorrupt takes note of a replica which has stopped serving
+// because its data is suspect. The corruption is counted and reported
+// to range event callbacks, and the range is dropped from the store's
+// queues. The replica isn't replaced; it stays out of service until
+// ClearReplicaCorruption is called or the store restarts.
+func (s *Store) MarkReplicaCorrupt(rng *Range) {
+	metrics.Metrics.Counter(fmt.Sprintf("storage.store.%d.corrupt_replicas", s.StoreID()), 1)
+	s.mu.RLock()
+	scanner := s.scanner
+	s.mu.RUnlock()
+	if scanner != nil {
+		scanner.removeRange(rng)
+	}
+	s.notifyRangeEvent(RangeEventCorrupt, rng.Descriptor(), nil)
+}
+
+// ClearReplicaCorruption puts the store's replica of the range with
+// the specified Raft ID back into service after it was marked
+// corrupted, e.g. once an operator has established that a consistency
+// check's verdict was wrong. The range scanner queues the replica
+// again on its next pass.
+func (s *Store) ClearReplicaCorruption(raftID int64) error {
+	rng, err := s.GetRange(raftID)
+	if err != nil {
+		return err
+	}
+	if !rng.clearCorrupt() {
+		return util.Errorf("replica of range %d on store %d isn't marked corrupted", raftID, s.StoreID())
+	}
+	log.Infof("range %d: replica on store %d was put back into service", raftID, s.StoreID())
+	return nil
 }
 
 // addRangeInternal starts the range and adds it to the ranges map and
 // the rangesByKey slice. If resort is true, the rangesByKey slice is
 // sorted; this is optional to allow many ranges to be added and the
 // sort only invoked once. This method presupposes the store's lock
-// is held. Returns a rangeAlreadyExists error if a range with the
-// same Raft ID has already been added to this store.
+// is held. If a range with the same Raft ID has already been added
+// to this store, it is replaced if its descriptor is older than the
+// new range's; otherwise a rangeAlreadyExists error is returned.
 func (s *Store) addRangeInternal(rng *Range, resort bool) error {
-	rng.start()
-	// TODO(spencer); will need to determine which range is
-	// newer, and keep that one.
 	if exRng, ok := s.ranges[rng.Desc.RaftID]; ok {
-		return &rangeAlreadyExists{exRng}
+		if !rng.Desc.NewerThan(exRng.Desc) {
+			return &rangeAlreadyExists{exRng}
+		}
+		if err := s.removeRangeLocked(exRng); err != nil {
+			return err
+		}
 	}
+	rng.start()
 	s.ranges[rng.Desc.RaftID] = rng
 	s.rangesByKey = append(s.rangesByKey, rng)
 	if resort {
@@ -628,10 +900,27 @@ func (s *Store) addRangeInternal(rng *Range, resort bool) error {
 }
 
 // RemoveRange removes the range from the store's range map and from
-// the sorted rangesByKey slice.
+// the sorted rangesByKey slice, and notifies range event callbacks.
 func (s *Store) RemoveRange(rng *Range) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	scanner := s.scanner
+	err := s.removeRangeLocked(rng)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Remove the range from any queues outside of the lock, as the
+	// scanner acquires the store lock when iterating.
+	if scanner != nil {
+		scanner.removeRange(rng)
+	}
+	s.notifyRangeEvent(RangeEventRemove, rng.Desc, nil)
+	return nil
+}
+
+// removeRangeLocked removes the range from the range map and the
+// rangesByKey slice. The store lock must be held.
+func (s *Store) removeRangeLocked(rng *Range) error {
 	rng.stop()
 	delete(s.ranges, rng.Desc.RaftID)
 	// Find the range in rangesByKey slice and swap it to end of slice
@@ -646,7 +935,16 @@ func (s *Store) RemoveRange(rng *Range) error {
 	return nil
 }
 
-// CreateSnapshot creates a new snapshot, named using an internal counter.
+// queues returns the range queues fed by the store's range scanner.
+// Maintenance queues (e.g. GC, split and replication) embed baseQueue
+// and are added here.
+func (s *Store) queues() []rangeQueue {
+	return []rangeQueue{s.consistencyQ, s.gcQ, s.splitQ}
+}
+
+// CreateSnapshot creates a new snapshot of the store's engine, named
+// using an internal counter. The snapshot is held until released via
+// ReleaseSnapshot or until the store is stopped.
 func (s *Store) CreateSnapshot() (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -655,8 +953,38 @@ func (s *Store) CreateSnapshot() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	snap, err := s.engine.NewSnapshot()
+	if err != nil {
+		return "", err
+	}
 	snapshotID := strconv.FormatInt(candidateID, 10)
-	return snapshotID, s.engine.CreateSnapshot(snapshotID)
+	s.snapshots[snapshotID] = snap
+	return snapshotID, nil
+}
+
+// GetSnapshot returns the snapshot created by CreateSnapshot under
+// the given ID.
+func (s *Store) GetSnapshot(snapshotID string) (engine.Engine, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap, ok := s.snapshots[snapshotID]
+	if !ok {
+		return nil, util.Errorf("snapshotID %s does not exist", snapshotID)
+	}
+	return snap, nil
+}
+
+// ReleaseSnapshot stops and forgets the snapshot with the given ID.
+func (s *Store) ReleaseSnapshot(snapshotID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.snapshots[snapshotID]
+	if !ok {
+		return util.Errorf("snapshotID %s does not exist", snapshotID)
+	}
+	snap.Stop()
+	delete(s.snapshots, snapshotID)
+	return nil
 }
 
 // Attrs returns the attributes of the underlying store.
@@ -685,6 +1013,29 @@ func (s *Store) Descriptor(nodeDesc *NodeDescriptor) (*StoreDescriptor, error) {
 	}, nil
 }
 
+// Summary returns a StoreSummary of the store's ranges.
+func (s *Store) Summary() (*StoreSummary, error) {
+	summary := &StoreSummary{
+		NodeID:    s.Ident.NodeID,
+		StoreID:   s.Ident.StoreID,
+		Timestamp: s.clock.PhysicalNow(),
+	}
+	err := s.VisitRanges(func(rng *Range) error {
+		size, err := engine.GetRangeSize(s.engine, rng.Desc.RaftID)
+		if err != nil {
+			return err
+		}
+		summary.RangeCount++
+		summary.Bytes += size
+		summary.QPS += rng.QPS()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
 // ExecuteCmd fetches a range based on the header's replica, assembles
 // method, args & reply into a Raft Cmd struct and executes the
 // command using the fetched range.
@@ -695,7 +1046,13 @@ func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Respon
 		return err
 	}
 	if header.Timestamp.Equal(proto.ZeroTimestamp) {
-		// Update the incoming timestamp if unset.
+		// Update the incoming timestamp if unset, after any causality
+		// token the request carries.
+		if !header.MinTimestamp.Equal(proto.ZeroTimestamp) {
+			if _, err := s.clock.Update(header.MinTimestamp); err != nil {
+				return err
+			}
+		}
 		header.Timestamp = s.clock.Now()
 	} else {
 		// Otherwise, update our clock with the incoming request. This
@@ -714,16 +1071,41 @@ func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Respon
 		return err
 	}
 
+	// Delay writes while the engine is behind on compactions.
+	if isThrottled(method) {
+		if delay := s.throttle.writeDelay(); delay > 0 {
+			time.Sleep(delay)
+		}
+	}
+
+	// Spans are accumulated across attempts, as each attempt resets
+	// the reply.
+	var trace []proto.TraceSpan
+	var attempt int32
+
 	// Backoff and retry loop for handling errors.
 	retryOpts := RangeRetryOptions
 	retryOpts.Tag = method
 	err = util.RetryWithBackoff(retryOpts, func() (util.RetryStatus, error) {
 		// Add the command to the range for execution; exit retry loop on success.
 		reply.Reset()
+		attempt++
+		span := proto.NewTraceSpan("store " + method)
 		err := rng.AddCmd(method, args, reply, true)
+		if header.Trace {
+			span.RaftID, span.StoreID, span.Attempt = rng.Desc.RaftID, s.StoreID(), attempt
+			span.Finish(err)
+			trace = append(append(trace, reply.Header().Trace...), span)
+		}
 		if err == nil {
 			return util.RetryBreak, nil
 		}
+		// Inconsistent reads report conflicting intents rather than
+		// pushing their transactions, so that they don't interfere with
+		// the traffic they observe.
+		if header.ReadConsistency == proto.INCONSISTENT {
+			return util.RetryBreak, nil
+		}
 
 		// Maybe resolve a potential write intent error. We do this here
 		// because this is the code path with the requesting client
@@ -734,8 +1116,7 @@ func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Respon
 		switch t := err.(type) {
 		case *proto.WriteTooOldError:
 			// Update request timestamp and retry immediately.
-			header.Timestamp = t.ExistingTimestamp
-			header.Timestamp.Logical++
+			header.Timestamp = t.ExistingTimestamp.Next()
 			return util.RetryReset, nil
 		case *proto.WriteIntentError:
 			// If write intent error is resolved, exit retry/backoff loop to
@@ -745,10 +1126,12 @@ func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Respon
 			}
 			// Otherwise, update timestamp on read/write and backoff / retry.
 			if proto.IsReadWrite(method) && header.Timestamp.Less(t.Txn.Timestamp) {
-				header.Timestamp = t.Txn.Timestamp
-				header.Timestamp.Logical++
+				header.Timestamp = t.Txn.Timestamp.Next()
 			}
 			return util.RetryContinue, nil
+		case *proto.RangeTooLargeError:
+			// The range is waiting on a split; backoff and retry.
+			return util.RetryContinue, nil
 		}
 		return util.RetryBreak, nil
 	})
@@ -759,6 +1142,9 @@ func (s *Store) ExecuteCmd(method string, args proto.Request, reply proto.Respon
 	if _, ok := err.(*util.RetryMaxAttemptsError); ok && header.Txn != nil {
 		reply.Header().SetGoError(proto.NewTransactionRetryError(header.Txn))
 	}
+	if header.Trace {
+		reply.Header().Trace = trace
+	}
 	return reply.Header().GoError()
 }
 
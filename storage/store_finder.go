@@ -52,8 +52,8 @@ func (sf *StoreFinder) capacityGossipUpdate(key string, contentsChanged bool) {
 }
 
 // findStores is the Store's implementation of a StoreFinder. It returns a list
-// of stores with attributes that are a superset of the required attributes. It
-// never returns an error.
+// of stores with combined node and store attributes that are a superset of the
+// required attributes. It never returns an error.
 //
 // If it cannot retrieve a StoreDescriptor from the Store's gossip, it garbage
 // collects the failed key.
@@ -71,7 +71,7 @@ func (sf *StoreFinder) findStores(required proto.Attributes) ([]*StoreDescriptor
 			// We can no longer retrieve this key from the gossip store,
 			// perhaps it expired.
 			delete(sf.capacityKeys, key)
-		} else if required.IsSubset(storeDesc.Attrs) {
+		} else if required.IsSubset(*storeDesc.CombinedAttrs()) {
 			stores = append(stores, storeDesc)
 		}
 	}
@@ -81,10 +81,11 @@ func TestStoreRangeSplitAtIllegalKeys(t *testing.T) {
 }
 
 // TestStoreRangeSplitAtRangeBounds verifies a range cannot be split
-// at its start or end keys (would create zero-length range!). This
-// sort of thing might happen in the wild if two split requests
-// arrived for same key. The first one succeeds and second would try
-// to split at the start of the newly split range.
+// at its end key, and that splitting a range at its start key
+// reports the existing split instead of creating a zero-length
+// range. The latter happens in the wild if two split requests arrive
+// for the same key. The first one succeeds and the second tries to
+// split at the start of the newly split range.
 func TestStoreRangeSplitAtRangeBounds(t *testing.T) {
 	store := createTestStore(t)
 	defer store.Stop()
@@ -93,14 +94,24 @@ func TestStoreRangeSplitAtRangeBounds(t *testing.T) {
 	if err := store.ExecuteCmd(proto.AdminSplit, args, reply); err != nil {
 		t.Fatal(err)
 	}
+	left, right := reply.LeftDesc, reply.RightDesc
 	// This second split will try to split at end of first split range.
-	if err := store.ExecuteCmd(proto.AdminSplit, args, reply); err == nil {
+	if err := store.ExecuteCmd(proto.AdminSplit, args, &proto.AdminSplitResponse{}); err == nil {
 		t.Fatalf("split succeeded unexpectedly")
 	}
 	// Now try to split at start of new range.
 	args, reply = adminSplitArgs(engine.KeyMin, []byte("a"), 2, store.StoreID())
-	if err := store.ExecuteCmd(proto.AdminSplit, args, reply); err == nil {
-		t.Fatalf("split succeeded unexpectedly")
+	if err := store.ExecuteCmd(proto.AdminSplit, args, reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reply.LeftDesc, left) {
+		t.Errorf("expected left descriptor %+v; got %+v", left, reply.LeftDesc)
+	}
+	if !reflect.DeepEqual(reply.RightDesc, right) {
+		t.Errorf("expected right descriptor %+v; got %+v", right, reply.RightDesc)
+	}
+	if rng := store.LookupRange(proto.Key("a"), nil); rng.Desc.RaftID != 2 {
+		t.Errorf("expected key \"a\" to remain in range 2; got range %d", rng.Desc.RaftID)
 	}
 }
 
@@ -134,6 +145,96 @@ func TestStoreRangeSplitConcurrent(t *testing.T) {
 	}
 }
 
+// TestStoreRangeSplitDescriptorChanged verifies that a split fails
+// with a retryable error if the range descriptor stored for the range
+// doesn't match the descriptor being split.
+func TestStoreRangeSplitDescriptorChanged(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+
+	rng := store.LookupRange(engine.KeyMin, nil)
+	desc := *rng.Desc
+	desc.Replicas = append(append([]proto.Replica(nil), desc.Replicas...), proto.Replica{NodeID: 2, StoreID: 2})
+	if err := engine.MVCCPutProto(store.Engine(), nil, engine.RangeDescriptorKey(desc.StartKey),
+		store.Clock().Now(), nil, &desc); err != nil {
+		t.Fatal(err)
+	}
+
+	args, reply := adminSplitArgs(engine.KeyMin, []byte("a"), 1, store.StoreID())
+	err := store.ExecuteCmd(proto.AdminSplit, args, reply)
+	if err == nil {
+		t.Fatal("split succeeded unexpectedly")
+	}
+	if retryErr, ok := err.(util.Retryable); !ok || !retryErr.CanRetry() {
+		t.Errorf("expected retryable error; got %s", err)
+	}
+	if rng := store.LookupRange(proto.Key("a"), nil); rng.Desc.RaftID != 1 {
+		t.Errorf("expected key \"a\" to remain in range 1; got range %d", rng.Desc.RaftID)
+	}
+}
+
+// TestStoreRangeSplitReply verifies that the reply to a split holds
+// the descriptors of the ranges on either side of the split key.
+func TestStoreRangeSplitReply(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+
+	splitKey := proto.Key("m")
+	args, reply := adminSplitArgs(engine.KeyMin, splitKey, 1, store.StoreID())
+	if err := store.ExecuteCmd(proto.AdminSplit, args, reply); err != nil {
+		t.Fatal(err)
+	}
+	left, right := store.LookupRange(engine.KeyMin, nil), store.LookupRange(splitKey, nil)
+	if !reflect.DeepEqual(reply.LeftDesc, *left.Desc) {
+		t.Errorf("expected left descriptor %+v; got %+v", *left.Desc, reply.LeftDesc)
+	}
+	if !reflect.DeepEqual(reply.RightDesc, *right.Desc) {
+		t.Errorf("expected right descriptor %+v; got %+v", *right.Desc, reply.RightDesc)
+	}
+	if !reply.LeftDesc.EndKey.Equal(splitKey) || !reply.RightDesc.StartKey.Equal(splitKey) {
+		t.Errorf("expected descriptors to meet at %q; got %+v, %+v", splitKey, reply.LeftDesc, reply.RightDesc)
+	}
+}
+
+// TestStoreRangeSplitGeneration verifies that each split increments
+// the generation of both resulting range descriptors.
+func TestStoreRangeSplitGeneration(t *testing.T) {
+	store := createTestStore(t)
+	defer store.Stop()
+
+	for i, split := range []struct {
+		key    proto.Key
+		raftID int64
+	}{
+		{proto.Key("b"), 1},
+		{proto.Key("c"), 2},
+	} {
+		args, reply := adminSplitArgs(split.key, split.key, split.raftID, store.StoreID())
+		if err := store.ExecuteCmd(proto.AdminSplit, args, reply); err != nil {
+			t.Fatal(err)
+		}
+		expGen := int64(i + 1)
+		for _, key := range []proto.Key{split.key.Prev(), split.key} {
+			rng := store.LookupRange(key, nil)
+			if rng.Desc.Generation != expGen {
+				t.Errorf("%d: expected range containing %q at generation %d; got %d", i, key, expGen, rng.Desc.Generation)
+			}
+			desc := &proto.RangeDescriptor{}
+			if ok, err := engine.MVCCGetProto(store.Engine(), engine.RangeDescriptorKey(rng.Desc.StartKey),
+				store.Clock().Now(), nil, desc); err != nil || !ok {
+				t.Fatalf("%d: unable to read descriptor of range containing %q: %v", i, key, err)
+			}
+			if desc.Generation != expGen {
+				t.Errorf("%d: expected stored descriptor for %q at generation %d; got %d", i, key, expGen, desc.Generation)
+			}
+		}
+	}
+	// The first range was not involved in the second split.
+	if rng := store.LookupRange(engine.KeyMin, nil); rng.Desc.Generation != 1 {
+		t.Errorf("expected first range at generation 1; got %d", rng.Desc.Generation)
+	}
+}
+
 // TestStoreRangeSplit executes a split of a range and verifies that the
 // resulting ranges respond to the right key ranges and that their stats
 // and response caches have been properly accounted for.
@@ -23,7 +23,9 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"reflect"
 	"sort"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -203,6 +205,27 @@ func createRange(s *Store, raftID int64, start, end proto.Key) *Range {
 	return NewRange(desc, s)
 }
 
+// TestStoreStartFailure verifies that a store which fails to start
+// stops the ranges it had already loaded.
+func TestStoreStartFailure(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+
+	// Write a corrupt descriptor, which is loaded after range 1's.
+	value := proto.Value{ValuePayload: proto.ValuePayload{Bytes: []byte("garbage")}}
+	key := engine.RangeDescriptorKey(proto.Key("\xffcorrupt"))
+	if err := engine.MVCCPut(store.Engine(), nil, key, proto.ZeroTimestamp, value, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Start(); err == nil {
+		t.Fatal("expected failure starting store with corrupt range descriptor")
+	}
+	if len(store.ranges) != 0 || store.metricPrefix != "" {
+		t.Errorf("expected store to be stopped; got %d ranges, metric prefix %q",
+			len(store.ranges), store.metricPrefix)
+	}
+}
+
 func TestStoreAddRemoveRanges(t *testing.T) {
 	store, _ := createTestStore(t)
 	defer store.Stop()
@@ -257,6 +280,111 @@ func TestStoreAddRemoveRanges(t *testing.T) {
 	}
 }
 
+// TestStoreRangeEvents verifies that registered callbacks are
+// notified of ranges being added, split and removed, and that events
+// don't share state with the ranges' descriptors.
+func TestStoreRangeEvents(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	// Remove range 1 so that the ranges added below don't overlap it.
+	rng1, err := store.GetRange(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveRange(rng1); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []*RangeEvent
+	store.RegisterRangeEventCallback(func(event *RangeEvent) {
+		events = append(events, event)
+	})
+
+	rng2 := createRange(store, 2, proto.Key("a"), proto.Key("c"))
+	rng2.Desc.Replicas = []proto.Replica{{NodeID: 1, StoreID: store.StoreID()}}
+	if err := store.AddRange(rng2); err != nil {
+		t.Fatal(err)
+	}
+	rng3 := splitTestRange(store, proto.Key("a"), proto.Key("b"), t)
+	if err := store.RemoveRange(rng3); err != nil {
+		t.Fatal(err)
+	}
+
+	expEvents := []struct {
+		typ        RangeEventType
+		raftID     int64
+		start, end proto.Key
+		newRaftID  int64
+	}{
+		{RangeEventAdd, 2, proto.Key("a"), proto.Key("c"), 0},
+		{RangeEventSplit, 2, proto.Key("a"), proto.Key("b"), rng3.Desc.RaftID},
+		{RangeEventRemove, rng3.Desc.RaftID, proto.Key("b"), proto.Key("c"), 0},
+	}
+	if len(events) != len(expEvents) {
+		t.Fatalf("expected %d events; got %d: %+v", len(expEvents), len(events), events)
+	}
+	for i, exp := range expEvents {
+		e := events[i]
+		if e.Type != exp.typ || e.StoreID != store.StoreID() || e.Desc.RaftID != exp.raftID ||
+			!e.Desc.StartKey.Equal(exp.start) || !e.Desc.EndKey.Equal(exp.end) {
+			t.Errorf("%d: expected %s of range %d [%q, %q); got %+v", i, exp.typ, exp.raftID, exp.start, exp.end, e)
+		}
+		if exp.newRaftID == 0 {
+			if e.NewDesc != nil {
+				t.Errorf("%d: expected no new descriptor; got %+v", i, e.NewDesc)
+			}
+		} else if e.NewDesc == nil || e.NewDesc.RaftID != exp.newRaftID {
+			t.Errorf("%d: expected new range %d; got %+v", i, exp.newRaftID, e.NewDesc)
+		}
+	}
+
+	rng2.Desc.Replicas[0].StoreID++
+	if storeID := events[0].Desc.Replicas[0].StoreID; storeID != store.StoreID() {
+		t.Errorf("expected event's replicas to be unaffected by the range's; got store %d", storeID)
+	}
+}
+
+// TestStoreAddRangeGeneration verifies that adding a range which
+// already exists replaces it only if the new descriptor is newer.
+func TestStoreAddRangeGeneration(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+	rng1, err := store.GetRange(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveRange(rng1); err != nil {
+		t.Fatal(err)
+	}
+	rng2 := createRange(store, 2, proto.Key("a"), proto.Key("b"))
+	if err := store.AddRange(rng2); err != nil {
+		t.Fatal(err)
+	}
+	// A descriptor of the same generation doesn't replace the range.
+	rng2Dup := createRange(store, 2, proto.Key("a"), proto.Key("b"))
+	if err := store.AddRange(rng2Dup); err == nil {
+		t.Fatal("expected error adding range with same generation")
+	} else if _, ok := err.(*rangeAlreadyExists); !ok {
+		t.Fatalf("expected rangeAlreadyExists error; got %s", err)
+	}
+	// A newer descriptor replaces the range.
+	rng2New := createRange(store, 2, proto.Key("a"), proto.Key("c"))
+	rng2New.Desc.Generation = 1
+	if err := store.AddRange(rng2New); err != nil {
+		t.Fatal(err)
+	}
+	if rng, err := store.GetRange(2); err != nil || rng != rng2New {
+		t.Errorf("expected range 2 to be replaced by newer range; got %s, %v", rng, err)
+	}
+	if rng := store.LookupRange(proto.Key("b"), nil); rng != rng2New {
+		t.Errorf("expected lookup of \"b\" to return newer range; got %s", rng)
+	}
+	// An older descriptor doesn't replace the newer range.
+	if err := store.AddRange(rng2); err == nil {
+		t.Error("expected error adding older range")
+	}
+}
+
 func TestStoreRangeIterator(t *testing.T) {
 	store, _ := createTestStore(t)
 	defer store.Stop()
@@ -331,6 +459,41 @@ func TestStoreRangeIterator(t *testing.T) {
 	}
 }
 
+// TestStoreScanner verifies that a started store paces a range
+// scanner through its ranges and that the scanner is stopped along
+// with the store.
+func TestStoreScanner(t *testing.T) {
+	defer func(d time.Duration) { *scanInterval = d }(*scanInterval)
+	*scanInterval = time.Millisecond
+	store, _ := createTestStore(t)
+	defer store.Stop()
+
+	store.mu.RLock()
+	scanner := store.scanner
+	store.mu.RUnlock()
+	if scanner == nil {
+		t.Fatal("expected store to have started a range scanner")
+	}
+	if err := util.IsTrueWithin(func() bool {
+		return scanner.loopCount() > 0
+	}, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing a range should not block on the scanner.
+	rng := splitTestRange(store, engine.KeyMin, proto.Key("a"), t)
+	if err := store.RemoveRange(rng); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Stop()
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.scanner != nil {
+		t.Error("expected range scanner to be cleared on stop")
+	}
+}
+
 func TestStoreIteratorWithAddAndRemoval(t *testing.T) {
 }
 
@@ -351,6 +514,29 @@ func TestStoreExecuteCmd(t *testing.T) {
 	}
 }
 
+// TestStoreSummary verifies that the store summary counts the store's
+// ranges and their size.
+func TestStoreSummary(t *testing.T) {
+	store, manual := createTestStore(t)
+	defer store.Stop()
+	pArgs, pReply := putArgs([]byte("a"), []byte("aaa"), 1, store.StoreID())
+	if err := store.ExecuteCmd(proto.Put, pArgs, pReply); err != nil {
+		t.Fatal(err)
+	}
+
+	manual.Set(10)
+	summary, err := store.Summary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.StoreID != store.StoreID() || summary.RangeCount != 1 || summary.Timestamp != 10 {
+		t.Errorf("unexpected store summary: %+v", summary)
+	}
+	if summary.Bytes == 0 {
+		t.Errorf("expected non-zero bytes in store summary: %+v", summary)
+	}
+}
+
 // TestStoreVerifyKeys checks that key length is enforced and
 // that end keys must sort >= start.
 func TestStoreVerifyKeys(t *testing.T) {
@@ -431,6 +617,26 @@ func TestStoreExecuteCmdWithZeroTime(t *testing.T) {
 	}
 }
 
+// TestStoreExecuteCmdWithMinTimestamp verifies that a request with no
+// timestamp executes after the causality token it carries.
+func TestStoreExecuteCmdWithMinTimestamp(t *testing.T) {
+	store, mc := createTestStore(t)
+	defer store.Stop()
+	args, reply := getArgs([]byte("a"), 1, store.StoreID())
+
+	// Set clock to time 1 and the token 100ms ahead of it.
+	mc.Set(1)
+	args.MinTimestamp = store.clock.Now()
+	args.MinTimestamp.WallTime += (100 * time.Millisecond).Nanoseconds()
+	err := store.ExecuteCmd(proto.Get, args, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !args.MinTimestamp.Less(reply.Timestamp) {
+		t.Errorf("expected reply timestamp after %s; got %s", args.MinTimestamp, reply.Timestamp)
+	}
+}
+
 // TestStoreExecuteCmdWithClockOffset verifies that if the request
 // specifies a timestamp further into the future than the node's
 // maximum allowed clock offset, the cmd fails with an error.
@@ -453,6 +659,52 @@ func TestStoreExecuteCmdWithClockOffset(t *testing.T) {
 	}
 }
 
+// TestStoreExecuteCmdTrace verifies that a request asking for a trace
+// receives spans for each stage of its execution on the store.
+func TestStoreExecuteCmdTrace(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+
+	testCases := []struct {
+		method string
+		args   proto.Request
+		reply  proto.Response
+		stages []string
+	}{
+		{proto.Put, proto.PutArgs([]byte("a"), []byte("value")), &proto.PutResponse{},
+			[]string{"command queue", "raft Put", "store Put"}},
+		{proto.Get, proto.GetArgs([]byte("a")), &proto.GetResponse{},
+			[]string{"command queue", "execute Get", "store Get"}},
+	}
+	for i, test := range testCases {
+		test.args.Header().RaftID = 1
+		test.args.Header().Replica = proto.Replica{StoreID: store.StoreID()}
+		test.args.Header().Trace = true
+		if err := store.ExecuteCmd(test.method, test.args, test.reply); err != nil {
+			t.Fatal(err)
+		}
+		var stages []string
+		for _, span := range test.reply.Header().Trace {
+			stages = append(stages, span.Name)
+			if span.RaftID != 1 || span.Duration < 0 || span.Error != "" {
+				t.Errorf("%d: unexpected span %+v", i, span)
+			}
+		}
+		if !reflect.DeepEqual(stages, test.stages) {
+			t.Errorf("%d: expected stages %v; got %v", i, test.stages, stages)
+		}
+	}
+
+	// Without the flag, no trace is returned.
+	args, reply := getArgs([]byte("a"), 1, store.StoreID())
+	if err := store.ExecuteCmd(proto.Get, args, reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Trace) != 0 {
+		t.Errorf("expected no trace; got %+v", reply.Trace)
+	}
+}
+
 // TestStoreExecuteCmdBadRange passes a bad range.
 func TestStoreExecuteCmdBadRange(t *testing.T) {
 	store, _ := createTestStore(t)
@@ -519,6 +771,60 @@ func TestStoreRaftIDAllocation(t *testing.T) {
 	}
 }
 
+// TestStoreLoadRanges verifies that ranges are reloaded from their
+// descriptors when a store is restarted, regardless of the number of
+// goroutines used to scan them, and that the loaded ranges are
+// counted.
+func TestStoreLoadRanges(t *testing.T) {
+	defer func(c int) { *startupConcurrency = c }(*startupConcurrency)
+	store, _ := createTestStore(t)
+	defer store.Stop()
+
+	// The keys fall into several partitions of the descriptor scan,
+	// including the first and last.
+	splitKeys := []proto.Key{proto.Key("\x00a"), proto.Key("A"), proto.Key("a"), proto.Key("b"),
+		proto.Key("c"), proto.Key("\xff")}
+	// Write the descriptors of the ranges between the split keys, which
+	// the store reads back when it's restarted.
+	replicas := store.LookupRange(engine.KeyMin, nil).Desc.Replicas
+	startKey := engine.KeyMin
+	for i, endKey := range append(splitKeys, engine.KeyMax) {
+		desc := &proto.RangeDescriptor{
+			RaftID:   int64(i + 1),
+			StartKey: startKey,
+			EndKey:   endKey,
+			Replicas: replicas,
+		}
+		if err := engine.MVCCPutProto(store.Engine(), nil, engine.RangeDescriptorKey(startKey),
+			store.Clock().Now(), nil, desc); err != nil {
+			t.Fatal(err)
+		}
+		startKey = endKey
+	}
+
+	for _, concurrency := range []int{0, 1, 3, 100} {
+		*startupConcurrency = concurrency
+		if err := store.Start(); err != nil {
+			t.Fatal(err)
+		}
+		store.mu.RLock()
+		count := len(store.rangesByKey)
+		store.mu.RUnlock()
+		if count != len(splitKeys)+1 {
+			t.Fatalf("concurrency %d: expected %d ranges; got %d", concurrency, len(splitKeys)+1, count)
+		}
+		if loaded := atomic.LoadInt64(&store.rangesLoaded); loaded != int64(count) {
+			t.Errorf("concurrency %d: expected %d ranges counted as loaded; got %d", concurrency, count, loaded)
+		}
+		for i, key := range splitKeys {
+			rng := store.LookupRange(key, key)
+			if rng == nil || !bytes.Equal(rng.Desc.StartKey, key) {
+				t.Fatalf("concurrency %d: %d: expected range starting at %q; got %+v", concurrency, i, key, rng)
+			}
+		}
+	}
+}
+
 // TestStoreRangesByKey verifies we can lookup ranges by key using
 // the sorted rangesByKey slice.
 func TestStoreRangesByKey(t *testing.T) {
@@ -0,0 +1,163 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"flag"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/metrics"
+)
+
+const (
+	// compactionStatsInterval is the interval at which the store
+	// refreshes the engine's compaction stats and recomputes the write
+	// delay.
+	compactionStatsInterval = 1 * time.Second
+)
+
+var (
+	l0SlowdownFiles = flag.Int64("l0_slowdown_files", 12, "specify "+
+		"--l0_slowdown_files to set the number of level 0 files at which the "+
+		"store begins delaying writes to let compactions catch up.")
+
+	l0StopFiles = flag.Int64("l0_stop_files", 20, "specify "+
+		"--l0_stop_files to set the number of level 0 files at which writes "+
+		"are delayed by --max_write_delay. This should be below the level 0 "+
+		"stop trigger of the underlying engine so that the store throttles "+
+		"writes before the engine stalls them.")
+
+	maxWriteDelay = flag.Duration("max_write_delay", 100*time.Millisecond, "specify "+
+		"--max_write_delay to set the longest delay applied to each write "+
+		"while the engine is behind on compactions.")
+)
+
+// computeWriteDelay returns the delay to apply to each write given the
+// current number of level 0 files. No delay is applied below slowdown
+// files; from there the delay grows linearly, reaching maxDelay at stop
+// files.
+func computeWriteDelay(l0Files, slowdown, stop int64, maxDelay time.Duration) time.Duration {
+	if l0Files < slowdown {
+		return 0
+	}
+	if l0Files >= stop || stop <= slowdown {
+		return maxDelay
+	}
+	return time.Duration(int64(maxDelay) * (l0Files - slowdown + 1) / (stop - slowdown + 1))
+}
+
+// A writeThrottle periodically samples an engine's compaction stats and
+// delays writes as read amplification grows. Spreading a small delay
+// over many writes smooths latency, where letting the engine hit its
+// own limits stalls all writes at once until compactions catch up.
+type writeThrottle struct {
+	engine engine.Engine
+	// Accessed atomically.
+	l0Files           int64
+	pendingCompaction int64
+	delay             int64 // nanoseconds
+	// metricPrefix is set while gauges are registered.
+	metricPrefix string
+}
+
+// newWriteThrottle returns a writeThrottle for the specified engine.
+func newWriteThrottle(eng engine.Engine) *writeThrottle {
+	return &writeThrottle{engine: eng}
+}
+
+// start registers gauges for the store's compaction stats and launches
+// a goroutine to refresh them until closer is closed.
+func (wt *writeThrottle) start(storeID int32, closer chan struct{}) {
+	wt.refresh()
+	wt.metricPrefix = fmt.Sprintf("storage.store.%d.", storeID)
+	metrics.Metrics.RegisterGaugeFunc(wt.metricPrefix+"l0_files", func() float64 {
+		return float64(atomic.LoadInt64(&wt.l0Files))
+	})
+	metrics.Metrics.RegisterGaugeFunc(wt.metricPrefix+"pending_compaction", func() float64 {
+		return float64(atomic.LoadInt64(&wt.pendingCompaction))
+	})
+	metrics.Metrics.RegisterGaugeFunc(wt.metricPrefix+"write_delay_ms", func() float64 {
+		return float64(wt.writeDelay()) / float64(time.Millisecond)
+	})
+	go func() {
+		ticker := time.NewTicker(compactionStatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				wt.refresh()
+			case <-closer:
+				return
+			}
+		}
+	}()
+}
+
+// stop deregisters the gauges registered by start.
+func (wt *writeThrottle) stop() {
+	if wt.metricPrefix == "" {
+		return
+	}
+	for _, name := range []string{"l0_files", "pending_compaction", "write_delay_ms"} {
+		metrics.Metrics.DeregisterGaugeFunc(wt.metricPrefix + name)
+	}
+	wt.metricPrefix = ""
+}
+
+// refresh reads the engine's compaction stats and recomputes the
+// write delay.
+func (wt *writeThrottle) refresh() {
+	stats, err := wt.engine.CompactionStats()
+	if err != nil {
+		log.Warningf("unable to read compaction stats: %s", err)
+		return
+	}
+	wt.update(stats)
+}
+
+// update records stats and recomputes the write delay from them.
+func (wt *writeThrottle) update(stats engine.CompactionStats) {
+	var pending int64
+	if stats.PendingCompaction {
+		pending = 1
+	}
+	atomic.StoreInt64(&wt.l0Files, stats.L0FileCount)
+	atomic.StoreInt64(&wt.pendingCompaction, pending)
+	delay := computeWriteDelay(stats.L0FileCount, *l0SlowdownFiles, *l0StopFiles, *maxWriteDelay)
+	if old := time.Duration(atomic.SwapInt64(&wt.delay, int64(delay))); old == 0 && delay > 0 {
+		log.Warningf("%d level 0 files; delaying writes by %s", stats.L0FileCount, delay)
+	} else if old > 0 && delay == 0 {
+		log.Infof("%d level 0 files; no longer delaying writes", stats.L0FileCount)
+	}
+}
+
+// isThrottled returns whether commands of the specified method are
+// delayed while the engine is behind on compactions. Reads add no
+// compaction debt, and admin commands such as splits are exempt as
+// they relieve the load rather than add to it.
+func isThrottled(method string) bool {
+	return !proto.IsReadOnly(method) && !proto.IsAdmin(method)
+}
+
+// writeDelay returns the delay currently applied to each write.
+func (wt *writeThrottle) writeDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&wt.delay))
+}
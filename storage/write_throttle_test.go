@@ -0,0 +1,86 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+)
+
+// TestComputeWriteDelay verifies the write delay is zero below the
+// slowdown threshold, grows linearly and is capped at the stop threshold.
+func TestComputeWriteDelay(t *testing.T) {
+	testCases := []struct {
+		l0Files, slowdown, stop int64
+		expDelay                time.Duration
+	}{
+		{0, 10, 19, 0},
+		{9, 10, 19, 0},
+		{10, 10, 19, 10 * time.Millisecond},
+		{14, 10, 19, 50 * time.Millisecond},
+		{18, 10, 19, 90 * time.Millisecond},
+		{19, 10, 19, 100 * time.Millisecond},
+		{50, 10, 19, 100 * time.Millisecond},
+		// Misconfigured thresholds throttle fully past slowdown.
+		{10, 10, 5, 100 * time.Millisecond},
+	}
+	for i, test := range testCases {
+		if delay := computeWriteDelay(test.l0Files, test.slowdown, test.stop, 100*time.Millisecond); delay != test.expDelay {
+			t.Errorf("%d: expected delay %s; got %s", i, test.expDelay, delay)
+		}
+	}
+}
+
+// TestWriteThrottleUpdate verifies the store's throttle recomputes the
+// write delay as compaction stats change.
+func TestWriteThrottleUpdate(t *testing.T) {
+	store, _ := createTestStore(t)
+	defer store.Stop()
+
+	wt := store.throttle
+	wt.update(engine.CompactionStats{L0FileCount: *l0StopFiles, PendingCompaction: true})
+	if delay := wt.writeDelay(); delay != *maxWriteDelay {
+		t.Errorf("expected delay %s; got %s", *maxWriteDelay, delay)
+	}
+	wt.update(engine.CompactionStats{})
+	if delay := wt.writeDelay(); delay != 0 {
+		t.Errorf("expected no delay; got %s", delay)
+	}
+}
+
+// TestIsThrottled verifies that only writes are throttled and that
+// admin commands are exempt.
+func TestIsThrottled(t *testing.T) {
+	testCases := []struct {
+		method    string
+		throttled bool
+	}{
+		{proto.Put, true},
+		{proto.DeleteRange, true},
+		{proto.EndTransaction, true},
+		{proto.Get, false},
+		{proto.Scan, false},
+		{proto.AdminSplit, false},
+	}
+	for i, test := range testCases {
+		if throttled := isThrottled(test.method); throttled != test.throttled {
+			t.Errorf("%d: expected %s throttled %t; got %t", i, test.method, test.throttled, throttled)
+		}
+	}
+}
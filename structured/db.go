@@ -19,11 +19,18 @@
 package structured
 
 import (
+	"encoding/json"
+
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
 )
 
+// schemaScanBatchSize is the number of schemas read at a time by
+// RegisterSchemas.
+const schemaScanBatchSize = 100
+
 // A DB interface provides methods to access a datastore
 // using a structured data API.
 type DB interface {
@@ -46,13 +53,39 @@ func NewDB(kvDB *client.KV) DB {
 }
 
 // PutSchema inserts s into the kv store for subsequent
-// usage by clients.
+// usage by clients. The schema is registered with this node at once,
+// so that ranges holding the schema's data are split only between
+// rows; other nodes register it the next time they call
+// RegisterSchemas.
 func (db *structuredDB) PutSchema(s *Schema) error {
 	if err := s.Validate(); err != nil {
 		return err
 	}
 	k := engine.MakeKey(engine.KeySchemaPrefix, proto.Key(s.Key))
-	return db.kvDB.PutI(k, s)
+	if err := db.kvDB.PutI(k, s); err != nil {
+		return err
+	}
+	s.registerColocation()
+	return nil
+}
+
+// RegisterSchemas registers every schema in the kv store with the
+// storage engine, so that ranges holding their data are split only
+// between rows. Nodes call it at startup and periodically thereafter
+// to pick up schemas put through other nodes. Deleted schemas remain
+// registered until the node restarts.
+func RegisterSchemas(kvDB *client.KV) error {
+	return kvDB.ScanPrefix(engine.KeySchemaPrefix, schemaScanBatchSize, func(kv proto.KeyValue) error {
+		s := &Schema{}
+		if err := json.Unmarshal(kv.Value.Bytes, s); err != nil {
+			return util.Errorf("unable to decode schema at key %q: %s", kv.Key, err)
+		}
+		if err := s.Validate(); err != nil {
+			return util.Errorf("invalid schema at key %q: %s", kv.Key, err)
+		}
+		s.registerColocation()
+		return nil
+	})
 }
 
 // DeleteSchema removes s from the kv store.
@@ -18,12 +18,15 @@
 package structured_test
 
 import (
+	"bytes"
+	"encoding/gob"
 	"testing"
 
 	"github.com/cockroachdb/cockroach/proto"
 	"github.com/cockroachdb/cockroach/server"
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/structured"
+	"github.com/cockroachdb/cockroach/util/encoding"
 )
 
 func TestPutGetDeleteSchema(t *testing.T) {
@@ -58,6 +61,76 @@ func TestPutGetDeleteSchema(t *testing.T) {
 	}
 }
 
+// TestRegisterSchemas verifies that a stored schema which wasn't put
+// through this node is registered by RegisterSchemas, so that its
+// rows aren't split.
+func TestRegisterSchemas(t *testing.T) {
+	s, err := createTestSchema()
+	if err != nil {
+		t.Fatalf("could not create test schema: %v", err)
+	}
+	// Use a key of its own, as registrations are global.
+	s.Key = "rdb"
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	localDB, err := server.BootstrapCluster("test-cluster", e)
+	if err != nil {
+		t.Fatalf("unable to boostrap cluster: %v", err)
+	}
+	if err := localDB.PutI(engine.MakeKey(engine.KeySchemaPrefix, proto.Key(s.Key)), s); err != nil {
+		t.Fatal(err)
+	}
+	rowKey := append(proto.Key("rdb/us/\xf2\xae"), encoding.EncodeInt(nil, 531)...)
+	subKey := append(append(proto.Key(nil), rowKey...), "/ll"...)
+	if !engine.IsValidSplitKey(subKey) {
+		t.Fatalf("expected %q to be a valid split key before registration", subKey)
+	}
+	if err := structured.RegisterSchemas(localDB); err != nil {
+		t.Fatal(err)
+	}
+	if engine.IsValidSplitKey(subKey) {
+		t.Errorf("expected key %q within a row to be an invalid split key", subKey)
+	}
+	if !engine.IsValidSplitKey(rowKey) {
+		t.Errorf("expected row key %q to be a valid split key", rowKey)
+	}
+}
+
+// TestGetGobSchema verifies that a schema stored gob-encoded, as
+// PutSchema wrote schemas before they were stored as JSON, can still
+// be read.
+func TestGetGobSchema(t *testing.T) {
+	s, err := createTestSchema()
+	if err != nil {
+		t.Fatalf("could not create test schema: %v", err)
+	}
+	e := engine.NewInMem(proto.Attributes{}, 1<<20)
+	localDB, err := server.BootstrapCluster("test-cluster", e)
+	if err != nil {
+		t.Fatalf("unable to boostrap cluster: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		t.Fatal(err)
+	}
+	k := engine.MakeKey(engine.KeySchemaPrefix, proto.Key(s.Key))
+	if err := localDB.Call(proto.Put, proto.PutArgs(k, buf.Bytes()), &proto.PutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	db := structured.NewDB(localDB)
+	readS, err := db.GetSchema(s.Key)
+	if err != nil {
+		t.Fatalf("could not get gob-encoded schema: %v", err)
+	}
+	if readS == nil || readS.Name != s.Name || readS.Key != s.Key || len(readS.Tables) != len(s.Tables) {
+		t.Fatalf("expected schema %+v; got %+v", s, readS)
+	}
+	for i, table := range readS.Tables {
+		if table.Name != s.Tables[i].Name || table.Key != s.Tables[i].Key || len(table.Columns) != len(s.Tables[i].Columns) {
+			t.Errorf("%d: expected table %+v; got %+v", i, s.Tables[i], table)
+		}
+	}
+}
+
 // User is a top-level table. User IDs are scattered, meaning a two
 // byte hash of the ID from the UserID sequence is prepended to yield
 // a randomly distributed keyspace.
@@ -311,6 +311,11 @@ primary keys) as an entry in the map, indexed by column key.
   pdb/us/<E(530)>: <data for user 530>
   pdb/us/<E(531)>: <data for user 531>
 
+Ranges are never split between a tuple's key and the keys stored
+beneath it. The tuples of interleaved tables, described below, are
+tuples in their own right, so a range may be split between a tuple and
+the tuples interleaved with it. See Schema.RowPrefix.
+
 If a primary key column has the "scatter" option specified, the
 encoded value for the key is additionally prefixed with the first two
 bytes of a hash of the entire primary key value. For example, If the
@@ -0,0 +1,123 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package structured
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// scatterPrefixLength is the length of the hash prepended to the
+// primary key of tables whose first primary key column specifies
+// "scatter".
+const scatterPrefixLength = 2
+
+// keyPrefix returns the prefix of all keys storing the schema's data.
+func (s *Schema) keyPrefix() proto.Key {
+	return proto.Key(s.Key + "/")
+}
+
+// RowPrefix returns the key of the row to which key belongs: the
+// schema and table keys followed by the row's encoded primary key.
+// The keys stored beneath the row begin with this prefix. The rows of
+// interleaved tables are stored beneath the row they reference, and
+// are rows in their own right: their prefix is that of the parent row
+// followed by their own table key and encoded primary key. Returns
+// nil if key isn't the key of a row of one of the schema's tables, or
+// of data stored with one. The schema must have been validated.
+//
+// RowPrefix is registered as the schema's engine.ColocationFunc, so
+// that ranges are split only between rows.
+func (s *Schema) RowPrefix(key proto.Key) proto.Key {
+	prefix := s.keyPrefix()
+	if !bytes.HasPrefix(key, prefix) {
+		return nil
+	}
+	t, n := s.tableAt(key, len(prefix))
+	if t == nil {
+		return nil
+	}
+	n, ok := t.primaryKeyEnd(key, n)
+	if !ok {
+		return nil
+	}
+	for n < len(key) && key[n] == '/' {
+		child, m := s.tableAt(key, n+1)
+		if child == nil || !child.interleavedIn(t) {
+			break
+		}
+		end, ok := child.primaryKeyEnd(key, m)
+		if !ok {
+			break
+		}
+		t, n = child, end
+	}
+	return key[:n]
+}
+
+// tableAt returns the table whose key, followed by a slash, begins
+// key[n:], and the offset of the key following the slash. Returns nil
+// if there is no such table.
+func (s *Schema) tableAt(key proto.Key, n int) (*Table, int) {
+	slash := bytes.IndexByte(key[n:], '/')
+	if slash < 0 {
+		return nil, 0
+	}
+	t, ok := s.byKey[string(key[n:n+slash])]
+	if !ok {
+		return nil, 0
+	}
+	return t, n + slash + 1
+}
+
+// primaryKeyEnd returns the offset of the end of the table's encoded
+// primary key, which begins at key[n:]. Returns false if key holds an
+// incomplete primary key.
+func (t *Table) primaryKeyEnd(key proto.Key, n int) (int, bool) {
+	for i, c := range t.primaryKey {
+		if i == 0 && c.Scatter {
+			n += scatterPrefixLength
+		}
+		if n >= len(key) {
+			return 0, false
+		}
+		l, err := encoding.PeekLength(key[n:])
+		if err != nil {
+			return 0, false
+		}
+		n += l
+	}
+	return n, true
+}
+
+// interleavedIn returns whether the table's rows are stored beneath
+// the rows of parent which they reference.
+func (t *Table) interleavedIn(parent *Table) bool {
+	for _, c := range t.foreignKeys[parent.Name] {
+		// Validation ensures that all columns of a foreign key agree.
+		return c.Interleave
+	}
+	return false
+}
+
+// registerColocation registers the schema's RowPrefix with the
+// storage engine so that ranges aren't split within rows.
+func (s *Schema) registerColocation() {
+	engine.RegisterColocation(s.keyPrefix(), s.RowPrefix)
+}
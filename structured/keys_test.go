@@ -0,0 +1,91 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package structured
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util/encoding"
+)
+
+// TestRowPrefix verifies that the row prefix is found for the keys of
+// rows, keys stored beneath them and interleaved rows, and that ranges
+// may only be split between rows, including interleaved rows, once
+// the schema is registered.
+func TestRowPrefix(t *testing.T) {
+	s, err := createTestSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeKey := func(parts ...[]byte) proto.Key {
+		var key []byte
+		for _, p := range parts {
+			key = append(key, p...)
+		}
+		return proto.Key(key)
+	}
+	hash := []byte("\xf2\xae")
+	userRow := makeKey([]byte("pdb/us/"), hash, encoding.EncodeInt(nil, 531))
+	streamRow := makeKey([]byte("pdb/ps/"), hash, encoding.EncodeInt(nil, 7))
+	postRow := makeKey([]byte("pdb/sp/"), encoding.EncodeInt(nil, 7), encoding.EncodeInt(nil, 9))
+	childRow := makeKey(streamRow, []byte("/sp/"), encoding.EncodeInt(nil, 7), encoding.EncodeInt(nil, 9))
+
+	testCases := []struct {
+		key, expPrefix proto.Key
+	}{
+		{userRow, userRow},
+		{makeKey(userRow, []byte("/ll")), userRow},
+		{streamRow, streamRow},
+		// Interleaved rows.
+		{childRow, childRow},
+		{makeKey(childRow, []byte("/ll")), childRow},
+		{makeKey(streamRow, []byte("/sp/"), encoding.EncodeInt(nil, 7)), streamRow},
+		// Users aren't interleaved with photo streams.
+		{makeKey(streamRow, []byte("/us/"), hash, encoding.EncodeInt(nil, 531)), streamRow},
+		{postRow, postRow},
+		{makeKey(postRow, []byte("/c")), postRow},
+		// Incomplete primary keys.
+		{makeKey([]byte("pdb/sp/"), encoding.EncodeInt(nil, 7)), nil},
+		{makeKey([]byte("pdb/us/"), hash), nil},
+		{proto.Key("pdb/us/\xf2\xae\x18\x02"), nil},
+		// Keys outside the schema's tables.
+		{proto.Key("pdb/us"), nil},
+		{proto.Key("pdb/xx/\x15"), nil},
+		{proto.Key("pdbx/us/\x15"), nil},
+		{proto.Key("other"), nil},
+	}
+	for i, test := range testCases {
+		if prefix := s.RowPrefix(test.key); !prefix.Equal(test.expPrefix) {
+			t.Errorf("%d: expected row prefix %q for %q; got %q", i, test.expPrefix, test.key, prefix)
+		}
+	}
+
+	s.registerColocation()
+	if !engine.IsValidSplitKey(userRow) {
+		t.Errorf("expected row key %q to be a valid split key", userRow)
+	}
+	if subKey := makeKey(userRow, []byte("/ll")); engine.IsValidSplitKey(subKey) {
+		t.Errorf("expected key %q within a row to be an invalid split key", subKey)
+	}
+	if !engine.IsValidSplitKey(childRow) {
+		t.Errorf("expected interleaved row key %q to be a valid split key", childRow)
+	}
+	if subKey := makeKey(childRow, []byte("/ll")); engine.IsValidSplitKey(subKey) {
+		t.Errorf("expected key %q within an interleaved row to be an invalid split key", subKey)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+// Package build reports information about the binary, such as the
+// source revision and Go version it was built with.
+package build
+
+import (
+	"runtime"
+	"sort"
+)
+
+// These variables are set at link time via -ldflags "-X ..."; see
+// the Makefile. They are empty for binaries built with "go build".
+var (
+	tag     string // Output of "git describe"
+	sha     string // Source revision
+	utcTime string // Time of the build
+)
+
+// features lists optional capabilities compiled into the binary,
+// registered from files guarded by build tags.
+var features []string
+
+// Info describes the build of a binary.
+type Info struct {
+	GoVersion string   `json:"go_version"`
+	Tag       string   `json:"tag"`
+	SHA       string   `json:"sha"`
+	Time      string   `json:"time"`
+	Features  []string `json:"features"`
+}
+
+// GetInfo returns the build information for this binary.
+func GetInfo() Info {
+	f := append([]string(nil), features...)
+	sort.Strings(f)
+	return Info{
+		GoVersion: runtime.Version(),
+		Tag:       tag,
+		SHA:       sha,
+		Time:      utcTime,
+		Features:  f,
+	}
+}
+
+// Short returns a concise description of the build, suitable for
+// comparing the builds of different nodes. If the binary wasn't
+// built with a tag, the source revision is used instead.
+func (i Info) Short() string {
+	v := i.Tag
+	if v == "" {
+		v = i.SHA
+	}
+	if v == "" {
+		v = "unknown"
+	}
+	return v + " (" + i.GoVersion + ")"
+}
@@ -0,0 +1,41 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package build
+
+import (
+	"runtime"
+	"testing"
+)
+
+// TestGetInfo verifies build info defaults for binaries built without
+// link-time variables.
+func TestGetInfo(t *testing.T) {
+	info := GetInfo()
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("expected Go version %q; got %q", runtime.Version(), info.GoVersion)
+	}
+	if s := info.Short(); s != "unknown ("+runtime.Version()+")" {
+		t.Errorf("unexpected short description %q", s)
+	}
+	info.SHA = "abc"
+	if s := info.Short(); s != "abc ("+runtime.Version()+")" {
+		t.Errorf("unexpected short description %q", s)
+	}
+	info.Tag = "v0.1"
+	if s := info.Short(); s != "v0.1 ("+runtime.Version()+")" {
+		t.Errorf("unexpected short description %q", s)
+	}
+}
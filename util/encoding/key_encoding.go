@@ -25,7 +25,11 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 	"unicode/utf8"
+
+	"github.com/cockroachdb/cockroach/util"
 )
 
 // Maximum sizes for allocating buffers to hold encoded values.
@@ -45,6 +49,9 @@ const (
 	orderedEncodingBinary              = 0x25
 	orderedEncodingBinaryNoTermination = 0x26
 	orderedEncodingTerminator          = 0x00
+	// orderedEncodingEscaped00 follows a 0x00 byte within an encoded
+	// string to distinguish it from the terminator.
+	orderedEncodingEscaped00 = 0xff
 )
 
 // EncodeNil returns a byte slice containing a nil-encoded value.
@@ -52,39 +59,78 @@ func EncodeNil() []byte {
 	return []byte{orderedEncodingNil}
 }
 
+// PeekLength returns the length in bytes of the key-encoded value at
+// the start of b, without decoding it. Nil, zero, NaN and infinite
+// values are a single byte; BINARY values encoded as the last value of
+// a key extend to the end of b; all other values end with a 0x00
+// terminator byte which isn't followed by an escape byte (see
+// EncodeString). This allows the values of a composite key to be
+// delimited without knowing their types.
+func PeekLength(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, util.Errorf("empty slice")
+	}
+	switch b[0] {
+	case orderedEncodingNil, orderedEncodingNaN, orderedEncodingNegativeInfinity,
+		orderedEncodingZero, orderedEncodingInfinity:
+		return 1, nil
+	case orderedEncodingBinaryNoTermination:
+		return len(b), nil
+	}
+	for i := 1; i < len(b); i++ {
+		if b[i] != orderedEncodingTerminator {
+			continue
+		}
+		if i+1 < len(b) && b[i+1] == orderedEncodingEscaped00 {
+			i++
+			continue
+		}
+		return i + 1, nil
+	}
+	return 0, util.Errorf("encoded value %q has no terminator", b)
+}
+
 // EncodeString returns the resulting byte slice with s encoded
 // and appended to b. If b is nil, it is treated as an empty
-// byte slice. If s is not a valid utf8-encoded string or
-// contains an intervening 0x00 byte, EncodeString will panic.
+// byte slice. If s is not a valid utf8-encoded string,
+// EncodeString will panic.
 //
 // Each value that is TEXT begins with a single byte of 0x24
 // and ends with a single byte of 0x00. There are zero or more
 // intervening bytes that encode the text value. The intervening
 // bytes are chosen so that the encoding will sort in the desired
-// collating order. The default sequence of bytes is simply UTF8.
-// The intervening bytes may not contain a 0x00 character; the only
-// 0x00 byte allowed in a text encoding is the final byte.
+// collating order. The default sequence of bytes is simply UTF8,
+// except that each 0x00 byte of the text is escaped as the two
+// bytes 0x00 0xff. No encoded value begins with 0xff, so an escaped
+// 0x00 can't be mistaken for the terminator of the text followed by
+// another value.
 //
 // Note that all key-encoded text with the BINARY collating sequence
 // is simply UTF8 text. UTF8 not UTF16. Strings must be converted to
 // UTF8 so that equivalent strings in different encodings compare the
-// same and so that the strings contain no embedded 0x00 bytes. In other
-// words, strcmp() should be sufficient for comparing two text keys.
+// same. In other words, strcmp() should be sufficient for comparing
+// two text keys.
 //
 // The text encoding ends in 0x00 in order to ensure that when there
 // are two strings where one is a prefix of the other that the shorter
-// string will sort first.
+// string will sort first. The escape byte sorts after the terminator
+// and after the first byte of any value, so this holds for text
+// containing 0x00 bytes as well.
 func EncodeString(b []byte, s string) []byte {
 	if !utf8.ValidString(s) {
 		panic("invalid utf8 string passed")
 	}
 	b = append(b, orderedEncodingText)
-	for _, v := range []byte(s) {
-		if v == 0x00 {
-			panic("string contains intervening 0x00 byte")
+	for {
+		i := strings.IndexByte(s, orderedEncodingTerminator)
+		if i == -1 {
+			break
 		}
-		b = append(b, v)
+		b = append(b, s[:i]...)
+		b = append(b, orderedEncodingTerminator, orderedEncodingEscaped00)
+		s = s[i+1:]
 	}
+	b = append(b, s...)
 	return append(b, orderedEncodingTerminator)
 }
 
@@ -94,12 +140,20 @@ func DecodeString(b []byte) ([]byte, string) {
 	if b[0] != orderedEncodingText {
 		panic("first byte of encoded string must be 0x24")
 	}
-	for i, v := range b[1:] {
-		if v == orderedEncodingTerminator {
-			return b[1+i:], string(b[1 : 1+i])
+	b = b[1:]
+	var s []byte
+	for {
+		i := bytes.IndexByte(b, orderedEncodingTerminator)
+		if i == -1 {
+			panic("encoded string must have terminator byte")
+		}
+		if i+1 < len(b) && b[i+1] == orderedEncodingEscaped00 {
+			s = append(s, b[:i+1]...)
+			b = b[i+2:]
+			continue
 		}
+		return b[i+1:], string(append(s, b[:i]...))
 	}
-	panic("encoded string must have terminator byte")
 }
 
 // EncodeBinary returns the resulting byte slice with i encoded
@@ -337,15 +391,6 @@ func makeIntFromMandE(negative bool, e int, m []byte) int64 {
 	return i
 }
 
-func removeTrailingZeros(m []byte) []byte {
-	for i := len(m); i > 0; i-- {
-		if m[i-1] != 0 {
-			return m[:i]
-		}
-	}
-	return []byte{}
-}
-
 // EncodeFloat returns the resulting byte slice with the encoded
 // float64 and appended to b.
 //
@@ -391,6 +436,76 @@ func EncodeFloat(b []byte, f float64) []byte {
 	return nil
 }
 
+// DecodeFloat returns the remaining byte slice after decoding and the
+// decoded float64 from buf. See EncodeFloat for the encoding.
+func DecodeFloat(buf []byte) ([]byte, float64) {
+	switch buf[0] {
+	case orderedEncodingNaN:
+		return buf[1:], math.NaN()
+	case orderedEncodingInfinity:
+		return buf[1:], math.Inf(1)
+	case orderedEncodingNegativeInfinity:
+		return buf[1:], math.Inf(-1)
+	case orderedEncodingZero:
+		return buf[1:], 0
+	}
+	idx := bytes.IndexByte(buf, orderedEncodingTerminator)
+	if idx == -1 {
+		panic(fmt.Sprintf("encoded float %q has no terminator", buf))
+	}
+	var e int
+	var m []byte
+	negative := buf[0] < orderedEncodingZero
+	switch {
+	case buf[0] == 0x08:
+		// Negative large.
+		e, m = decodeLargeNumber(true, buf[:idx+1])
+	case buf[0] > 0x08 && buf[0] <= 0x13:
+		// Negative medium.
+		e, m = decodeMediumNumber(true, buf[:idx+1])
+	case buf[0] == 0x14:
+		// Negative small.
+		e, m = decodeSmallNumber(true, buf[:idx+1])
+	case buf[0] == 0x16:
+		// Positive small.
+		e, m = decodeSmallNumber(false, buf[:idx+1])
+	case buf[0] >= 0x17 && buf[0] < 0x22:
+		// Positive medium.
+		e, m = decodeMediumNumber(false, buf[:idx+1])
+	case buf[0] == 0x22:
+		// Positive large.
+		e, m = decodeLargeNumber(false, buf[:idx+1])
+	default:
+		panic(fmt.Sprintf("unknown prefix of the encoded byte slice: %q", buf))
+	}
+	return buf[idx+1:], makeFloatFromMandE(negative, e, m)
+}
+
+// makeFloatFromMandE reconstructs the float from the mantissa M and
+// exponent E. The centimal digits of M are written out in decimal
+// and parsed, so that the result is the float nearest to the value
+// they represent.
+func makeFloatFromMandE(negative bool, e int, m []byte) float64 {
+	// The value is 0.{digits of M} * 100^E.
+	b := make([]byte, 0, 2*len(m)+8)
+	if negative {
+		b = append(b, '-')
+	}
+	b = append(b, '0', '.')
+	for _, v := range m {
+		// Each byte is 2X+1, except for the last which is 2X+0.
+		d := v / 2
+		b = append(b, '0'+d/10, '0'+d%10)
+	}
+	b = append(b, 'e')
+	b = strconv.AppendInt(b, int64(2*e), 10)
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		panic(fmt.Sprintf("unable to parse decoded float %q: %s", b, err))
+	}
+	return f
+}
+
 // floatMandE computes and returns the mantissa M and exponent E for f.
 //
 // The mantissa is a base-100 representation of the value. The exponent
@@ -406,48 +521,47 @@ func EncodeFloat(b []byte, f float64) []byte {
 // If we assume all digits of the mantissa occur to the right of the decimal
 // point, then the exponent E is the power of one hundred by which one must
 // multiply the mantissa to recover the original value.
+//
+// The digits are those of the shortest decimal representation of f
+// which parses back to f, so decoding the mantissa recovers f exactly.
 func floatMandE(f float64) (int, []byte) {
 	if f < 0 {
 		f = -f
 	}
-	i := 0
-	if f >= 1 {
-		for t := f; t >= 1; t /= 100 {
-			i++
-		}
-	} else {
-		for t := f; t < 0.01; t *= 100 {
-			i--
-		}
+	// Format f as d.ddde±xx and collect its decimal digits. The value is
+	// then 0.dddd * 10^(xx+1).
+	b := strconv.AppendFloat(nil, f, 'e', -1, 64)
+	i := bytes.IndexByte(b, 'e')
+	exp10, err := strconv.Atoi(string(b[i+1:]))
+	if err != nil {
+		panic(fmt.Sprintf("unable to parse exponent of %q: %s", b, err))
+	}
+	e10 := exp10 + 1
+	digits := []byte{b[0]}
+	if i > 1 {
+		digits = append(digits, b[2:i]...) // skip the decimal point
+	}
+	// Align the digits to centimal digits: the decimal exponent must be
+	// even and the number of digits a multiple of two.
+	if e10%2 != 0 {
+		digits = append([]byte{'0'}, digits...)
+		e10++
+	}
+	if len(digits)%2 != 0 {
+		digits = append(digits, '0')
+	}
+	m := make([]byte, len(digits)/2)
+	for j := range m {
+		x := (digits[2*j]-'0')*10 + digits[2*j+1] - '0'
+		m[j] = 2*x + 1
 	}
-	// Iterate through the centimal digits of the
-	// mantissa and add them to the buffer.
-	// For a number like 9999.00001, start with
-	// 99.9900001, write 99 to the buffer, then
-	// multiply by 100 until there is no fractional
-	// portion left.
-	d := f * math.Pow(100, float64(-i+1))
-	var buf bytes.Buffer
-	n, frac := math.Modf(d)
-	buf.WriteByte(byte(2*n + 1))
-	for frac != 0 {
-		// Remove the integral portion and shift to the left.
-		// So given the above example:
-		// 99.9900001 -> [-99] -> 00.9900001 -> [*100] -> 99.00001
-		// 99.00001 -> [-99] -> 00.00001 -> [*100] -> 00.001
-		// 00.001 -> [-00] -> 00.001 -> [*100] -> 00.1
-		// 00.1 -> [-00] -> 00.1 -> [*100] -> 10
-		// Done as frac == 0
-		d = (d - n) * 100
-		n, frac = math.Modf(d)
-		buf.WriteByte(byte(2*n + 1)) // Write the integral to the buf.
-	}
-	b := buf.Bytes()
-	// The last byte is encoded as 2n+0.
-	b[len(b)-1]--
-
 	// Trailing X==0 digits are omitted.
-	return i, removeTrailingZeros(b)
+	for len(m) > 1 && m[len(m)-1] == 1 {
+		m = m[:len(m)-1]
+	}
+	// The last byte is encoded as 2n+0.
+	m[len(m)-1]--
+	return e10 / 2, m
 }
 
 // onesComplement inverts each byte in buf from index start to end.
@@ -463,10 +577,10 @@ func encodeSmallNumber(negative bool, e int, m []byte, buf []byte) []byte {
 	l := 1 + n + len(m)
 	if negative {
 		buf[0] = 0x14
-		onesComplement(buf, n, l) // ones complement of mantissa
+		onesComplement(buf, n+1, l) // ones complement of mantissa
 	} else {
 		buf[0] = 0x16
-		onesComplement(buf, 1, n) // ones complement of exponent
+		onesComplement(buf, 1, n+1) // ones complement of exponent
 	}
 	buf[l] = orderedEncodingTerminator
 	return buf[:l+1]
@@ -514,6 +628,27 @@ func decodeMediumNumber(negative bool, buf []byte) (int, []byte) {
 	return e, m
 }
 
+func decodeSmallNumber(negative bool, buf []byte) (int, []byte) {
+	// We don't need the prefix and last terminator.
+	m := make([]byte, len(buf)-2)
+	copy(m, buf[1:len(buf)-1])
+
+	var e uint64
+	var n int
+	if negative {
+		e, n = GetUVarint(m)
+		onesComplement(m, n, len(m))
+	} else {
+		// The length of the exponent is only known once it has been
+		// complemented back.
+		exp := make([]byte, len(m))
+		copy(exp, m)
+		onesComplement(exp, 0, len(exp))
+		e, n = GetUVarint(exp)
+	}
+	return -int(e), m[n:]
+}
+
 func decodeLargeNumber(negative bool, buf []byte) (int, []byte) {
 	m := make([]byte, len(buf))
 	copy(m, buf)
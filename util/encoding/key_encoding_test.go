@@ -20,6 +20,7 @@ package encoding
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"sort"
 	"testing"
 )
@@ -91,13 +92,30 @@ func TestInvalidUTF8String(t *testing.T) {
 	EncodeString(nil, "\x80\x80\x80\x80")
 }
 
-func TestStringNullBytePanic(t *testing.T) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("expected panic due to intervening 0x00 byte in string")
+// TestStringNullByteEscaping verifies that strings containing 0x00
+// bytes are escaped, decode to the original strings and sort in the
+// order of the strings, also when followed by another value.
+func TestStringNullByteEscaping(t *testing.T) {
+	strs := []string{"", "\x00", "\x00\x00", "\x00\x01", "a", "a\x00", "a\x00b", "a\x01", "ab"}
+	var last []byte
+	for i, s := range strs {
+		enc := EncodeString(nil, s)
+		b := EncodeInt(append([]byte(nil), enc...), 1)
+		if l, err := PeekLength(b); err != nil || l != len(enc) {
+			t.Errorf("%d: expected length %d for %v; got %d, %v", i, len(enc), prettyBytes(b), l, err)
 		}
-	}()
-	EncodeString(nil, string([]byte{0x00, 0x01, 0x02}))
+		rem, decoded := DecodeString(b)
+		if decoded != s {
+			t.Errorf("%d: expected %q; got %q", i, s, decoded)
+		}
+		if _, v := DecodeInt(rem); v != 1 {
+			t.Errorf("%d: expected remainder to hold 1; got %d", i, v)
+		}
+		if i > 0 && bytes.Compare(last, b) >= 0 {
+			t.Errorf("%d: expected %v to be less than %v", i, prettyBytes(last), prettyBytes(b))
+		}
+		last = b
+	}
 }
 
 func TestStringNoTerminatorPanic(t *testing.T) {
@@ -259,7 +277,7 @@ func TestEncodeInt(t *testing.T) {
 	}
 }
 
-func disabledTestFloatMandE(t *testing.T) {
+func TestFloatMandE(t *testing.T) {
 	testCases := []struct {
 		Value float64
 		E     int
@@ -293,7 +311,9 @@ func disabledTestFloatMandE(t *testing.T) {
 		{0.123, 0, []byte{0x19, 0x3c}},
 		{0.0123, 0, []byte{0x03, 0x2e}},
 		{0.00123, -1, []byte{0x19, 0x3c}},
-		{9223372036854775807, 10, []byte{0x13, 0x2d, 0x43, 0x91, 0x07, 0x89, 0x6d, 0x9b, 0x75, 0x0e}},
+		// The nearest float64 is 9223372036854775808, whose shortest
+		// representation is 9.223372036854776e+18.
+		{9223372036854775807, 10, []byte{0x13, 0x2d, 0x43, 0x91, 0x07, 0x89, 0x6d, 0x9b, 0x78}},
 	}
 	for _, c := range testCases {
 		if e, m := floatMandE(c.Value); e != c.E || !bytes.Equal(m, c.M) {
@@ -301,3 +321,65 @@ func disabledTestFloatMandE(t *testing.T) {
 		}
 	}
 }
+
+// TestEncodeDecodeFloat verifies that floats decode to their original
+// values and that their encodings sort in increasing order.
+func TestEncodeDecodeFloat(t *testing.T) {
+	values := []float64{
+		math.Inf(-1), -1e300, -1234.5, -99.01, -1, -0.123, -0.00123, -1e-30,
+		0, 1e-30, 0.00123, 0.0123, 0.123, 1, 10, 12.345, 99.01, 100.1,
+		1234.5, 9999.1, 123450, 9223372036854775807, 1e30, 1e300, math.Inf(1),
+	}
+	var last []byte
+	for i, v := range values {
+		enc := EncodeFloat(nil, v)
+		if i > 0 && bytes.Compare(last, enc) >= 0 {
+			t.Errorf("expected %v to be less than %v", prettyBytes(last), prettyBytes(enc))
+		}
+		last = enc
+		rem, dec := DecodeFloat(append(enc, orderedEncodingNil))
+		if dec != v {
+			t.Errorf("unexpected mismatch for %v. got %v", v, dec)
+		}
+		if !bytes.Equal(rem, []byte{orderedEncodingNil}) {
+			t.Errorf("unexpected remainder for %v: %v", v, prettyBytes(rem))
+		}
+	}
+	if _, dec := DecodeFloat(EncodeFloat(nil, math.NaN())); !math.IsNaN(dec) {
+		t.Errorf("expected NaN; got %v", dec)
+	}
+}
+
+func TestPeekLength(t *testing.T) {
+	encodings := [][]byte{
+		EncodeNil(),
+		EncodeString(nil, ""),
+		EncodeString(nil, "foo"),
+		EncodeString(nil, "f\x00o"),
+		EncodeBinary(nil, []byte{0, 1, 2}),
+		EncodeInt(nil, 0),
+		EncodeInt(nil, -10000),
+		EncodeInt(nil, 9223372036854775807),
+		EncodeFloat(nil, math.NaN()),
+		EncodeFloat(nil, math.Inf(-1)),
+		EncodeFloat(nil, 0.00123),
+		EncodeFloat(nil, -1234.5),
+	}
+	for _, enc := range encodings {
+		// Append a second value to verify only the first is measured.
+		b := EncodeString(append([]byte(nil), enc...), "bar")
+		if l, err := PeekLength(b); err != nil || l != len(enc) {
+			t.Errorf("expected length %d for %v; got %d, %v", len(enc), prettyBytes(b), l, err)
+		}
+	}
+	// A final binary value extends to the end of the slice.
+	final := EncodeBinaryFinal([]byte("foo"))
+	if l, err := PeekLength(final); err != nil || l != len(final) {
+		t.Errorf("expected length %d for %v; got %d, %v", len(final), prettyBytes(final), l, err)
+	}
+	for _, b := range [][]byte{nil, {orderedEncodingText, 'a'}} {
+		if _, err := PeekLength(b); err == nil {
+			t.Errorf("expected error for %v", prettyBytes(b))
+		}
+	}
+}
@@ -86,6 +86,32 @@ func (m *ManualClock) Set(nanos int64) {
 	atomic.StoreInt64(&m.nanos, nanos)
 }
 
+// SkewedClock is a convenience type to facilitate creating a hybrid
+// logical clock whose physical clock deviates from an underlying
+// physical clock by a configurable skew. Tests use it to simulate
+// nodes whose clocks disagree. SkewedClock is thread safe.
+type SkewedClock struct {
+	physicalClock func() int64
+	skew          int64
+}
+
+// NewSkewedClock returns a new instance which offsets readings of the
+// supplied physical clock by skew.
+func NewSkewedClock(physicalClock func() int64, skew time.Duration) *SkewedClock {
+	return &SkewedClock{physicalClock: physicalClock, skew: int64(skew)}
+}
+
+// UnixNano returns the underlying physical clock's timestamp offset
+// by the skew.
+func (s *SkewedClock) UnixNano() int64 {
+	return s.physicalClock() + atomic.LoadInt64(&s.skew)
+}
+
+// SetSkew atomically sets the skew.
+func (s *SkewedClock) SetSkew(skew time.Duration) {
+	atomic.StoreInt64(&s.skew, int64(skew))
+}
+
 // UnixNano returns the local machine's physical nanosecond
 // unix epoch timestamp as a convenience to create a HLC via
 // c := hlc.NewClock(hlc.UnixNano).
@@ -202,3 +202,19 @@ func ExampleManualClock() {
 		log.Fatalf("manual clock error")
 	}
 }
+
+// TestSkewedClock verifies that a SkewedClock offsets readings of its
+// underlying physical clock and that the skew may be changed.
+func TestSkewedClock(t *testing.T) {
+	m := NewManualClock(100)
+	s := NewSkewedClock(m.UnixNano, 10)
+	c := NewClock(s.UnixNano)
+	if now := c.PhysicalNow(); now != 110 {
+		t.Errorf("expected skewed physical time 110; got %d", now)
+	}
+	s.SetSkew(-10)
+	m.Increment(5)
+	if now := c.PhysicalNow(); now != 95 {
+		t.Errorf("expected skewed physical time 95; got %d", now)
+	}
+}
@@ -71,6 +71,17 @@ func isAllowed(encType EncodingType, allowed []EncodingType) bool {
 	return false
 }
 
+// withoutEncoding returns the encodings in allowed other than encType.
+func withoutEncoding(allowed []EncodingType, encType EncodingType) []EncodingType {
+	var result []EncodingType
+	for _, et := range allowed {
+		if et != encType {
+			result = append(result, et)
+		}
+	}
+	return result
+}
+
 var yamlXXXUnrecognizedRE = regexp.MustCompile(` *xxx_unrecognized: \[\]\n?`)
 
 // sanitizeYAML filters lines in the input which match xxx_unrecognized, a
@@ -131,17 +142,12 @@ func UnmarshalRequest(r *http.Request, body []byte, value interface{}, allowed [
 	return Errorf("unsupported content type: %q", contentType)
 }
 
-// MarshalResponse examines the request Accept header to determine the
-// client's preferred response encoding. Supported content types
-// include JSON, protobuf, and YAML. If the Accept header is not
-// available, the Content-Type header specifying the request encoding
-// is used. The value parameter is marshalled using the response
-// encoding and the resulting body and content type are returned. If
-// the encoding could not be determined by either header, the response
-// is marshalled using JSON. An error is retruned on marshalling
-// failure.
-func MarshalResponse(r *http.Request, value interface{}, allowed []EncodingType) (
-	body []byte, contentType string, err error) {
+// ResponseEncoding examines the request Accept header to determine
+// the client's preferred response encoding among those allowed. If
+// the Accept header names none of them, the Content-Type header
+// specifying the request encoding is used. If the encoding could not
+// be determined by either header, JSON is returned.
+func ResponseEncoding(r *http.Request, allowed []EncodingType) EncodingType {
 	// TODO(spencer): until there's a nice (free) way to parse the
 	//   Accept header and properly use the request's preference for a
 	//   content type, we simply find out which of "json", "protobuf" or
@@ -180,12 +186,32 @@ func MarshalResponse(r *http.Request, value interface{}, allowed []EncodingType)
 	}
 
 	if protoIdx < jsonIdx && protoIdx < yamlIdx {
+		return ProtoEncoding
+	} else if yamlIdx < jsonIdx && yamlIdx < protoIdx {
+		return YAMLEncoding
+	}
+	return JSONEncoding
+}
+
+// MarshalResponse marshals the value parameter using the response
+// encoding chosen by ResponseEncoding. Supported content types include
+// JSON, protobuf, and YAML. The resulting body and content type are
+// returned. Protobuf encoding is only used for values which are
+// protocol buffer messages; clients requesting protobuf for other
+// values receive JSON. An error is retruned on marshalling failure.
+func MarshalResponse(r *http.Request, value interface{}, allowed []EncodingType) (
+	body []byte, contentType string, err error) {
+	if _, ok := value.(gogoproto.Message); !ok {
+		allowed = withoutEncoding(allowed, ProtoEncoding)
+	}
+	switch ResponseEncoding(r, allowed) {
+	case ProtoEncoding:
 		// Protobuf-encode the config.
 		contentType = ProtoContentType
 		if body, err = gogoproto.Marshal(value.(gogoproto.Message)); err != nil {
 			err = Errorf("unable to marshal %+v to protobuf: %s", value, err)
 		}
-	} else if yamlIdx < jsonIdx && yamlIdx < protoIdx {
+	case YAMLEncoding:
 		// YAML-encode the config.
 		contentType = YAMLContentType
 		if body, err = yaml.Marshal(value); err != nil {
@@ -193,7 +219,7 @@ func MarshalResponse(r *http.Request, value interface{}, allowed []EncodingType)
 		} else {
 			body = sanitizeYAML(body)
 		}
-	} else {
+	default:
 		// Always fall back to JSON-encode the config.
 		contentType = JSONContentType
 		if body, err = json.MarshalIndent(value, "", "  "); err != nil {
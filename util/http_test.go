@@ -193,3 +193,29 @@ func TestMarshalResponse(t *testing.T) {
 		}
 	}
 }
+
+// TestMarshalResponseNonProto verifies that values which aren't
+// protocol buffers are marshalled using JSON when protobuf is
+// requested, and using YAML when YAML is requested.
+func TestMarshalResponseNonProto(t *testing.T) {
+	value := map[string]int{"a": 1}
+	testCases := []struct {
+		accept, expCType string
+	}{
+		{util.ProtoContentType, util.JSONContentType},
+		{util.ProtoContentType + ", " + util.YAMLContentType, util.YAMLContentType},
+		{util.YAMLContentType, util.YAMLContentType},
+	}
+	for i, test := range testCases {
+		req, err := http.NewRequest("GET", "http://foo.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add(util.AcceptHeader, test.accept)
+		if _, cType, err := util.MarshalResponse(req, value, util.AllEncodings); err != nil {
+			t.Errorf("%d: %s", i, err)
+		} else if cType != test.expCType {
+			t.Errorf("%d: expected %s content type; got %s", i, test.expCType, cType)
+		}
+	}
+}
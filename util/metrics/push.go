@@ -0,0 +1,180 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// Protocols supported by a Pusher.
+const (
+	// Graphite is the Graphite plaintext protocol, sent over TCP.
+	Graphite = "graphite"
+	// StatsD is the StatsD protocol, sent over UDP. Processed metrics
+	// are already aggregated over an interval, so they're sent as gauges.
+	StatsD = "statsd"
+)
+
+// pushDialTimeout bounds the attempt to connect to the push endpoint.
+const pushDialTimeout = 10 * time.Second
+
+// A Pusher sends the processed metrics of a MetricSystem to a Graphite
+// or StatsD endpoint at the end of each of its intervals, for
+// monitoring systems which can't pull metrics from nodes directly.
+type Pusher struct {
+	protocol string
+	addr     string
+	prefix   string
+	ms       *MetricSystem
+	stream   chan *ProcessedMetricSet
+	done     chan struct{}
+}
+
+// NewPusher returns a Pusher which sends metrics to addr using the
+// given protocol, either Graphite or StatsD. Metric names are
+// prefixed with prefix and a dot, unless prefix is empty.
+func NewPusher(protocol, addr, prefix string) (*Pusher, error) {
+	if protocol != Graphite && protocol != StatsD {
+		return nil, util.Errorf("unsupported metrics push protocol %q; must be %q or %q",
+			protocol, Graphite, StatsD)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, util.Errorf("invalid metrics push address %q: %s", addr, err)
+	}
+	return &Pusher{
+		protocol: protocol,
+		addr:     addr,
+		prefix:   strings.TrimSuffix(prefix, "."),
+		stream:   make(chan *ProcessedMetricSet, 16),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start subscribes to the processed metrics of ms and pushes each set
+// received until Stop is called. Failed pushes are logged and the
+// metrics of their interval dropped.
+func (p *Pusher) Start(ms *MetricSystem) {
+	p.ms = ms
+	ms.SubscribeToProcessedMetrics(p.stream)
+	go func() {
+		for {
+			select {
+			case set, ok := <-p.stream:
+				if !ok {
+					log.Errorf("metrics push to %s fell behind and was unsubscribed", p.addr)
+					return
+				}
+				if err := p.push(set); err != nil {
+					log.Warningf("failed to push metrics to %s: %s", p.addr, err)
+				}
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop unsubscribes from the metric system and stops pushing.
+func (p *Pusher) Stop() {
+	if p.ms != nil {
+		p.ms.UnsubscribeFromProcessedMetrics(p.stream)
+	}
+	close(p.done)
+}
+
+// push connects to the endpoint and sends the metric set.
+func (p *Pusher) push(set *ProcessedMetricSet) error {
+	network := "tcp"
+	if p.protocol == StatsD {
+		network = "udp"
+	}
+	conn, err := net.DialTimeout(network, p.addr, pushDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if p.protocol == StatsD {
+		// Each metric is sent in its own datagram, which keeps packets
+		// well below the MTU.
+		return writeStatsD(conn, p.prefix, set)
+	}
+	w := bufio.NewWriter(conn)
+	if err := writeGraphite(w, p.prefix, set); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeGraphite writes the metric set in the Graphite plaintext
+// format: one "<name> <value> <unix-time>" line per metric.
+func writeGraphite(w io.Writer, prefix string, set *ProcessedMetricSet) error {
+	ts := set.Time.Unix()
+	for _, name := range sortedNames(set) {
+		if _, err := fmt.Fprintf(w, "%s %s %d\n", pushName(prefix, name),
+			strconv.FormatFloat(set.Metrics[name], 'f', -1, 64), ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStatsD writes the metric set as StatsD gauges, one
+// "<name>:<value>|g" line per write.
+func writeStatsD(w io.Writer, prefix string, set *ProcessedMetricSet) error {
+	for _, name := range sortedNames(set) {
+		if _, err := fmt.Fprintf(w, "%s:%s|g\n", pushName(prefix, name),
+			strconv.FormatFloat(set.Metrics[name], 'f', -1, 64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedNames returns the names of the metrics in the set, sorted.
+func sortedNames(set *ProcessedMetricSet) []string {
+	names := make([]string, 0, len(set.Metrics))
+	for name := range set.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pushName returns the name under which a metric is pushed: the
+// prefix and name joined by a dot, with characters which delimit
+// fields in the Graphite and StatsD formats replaced by underscores.
+func pushName(prefix, name string) string {
+	if prefix != "" {
+		name = prefix + "." + name
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', ':', '|', '@':
+			return '_'
+		}
+		return r
+	}, name)
+}
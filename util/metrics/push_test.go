@@ -0,0 +1,146 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func testMetricSet() *ProcessedMetricSet {
+	return &ProcessedMetricSet{
+		Time: time.Unix(1425600000, 0),
+		Metrics: map[string]float64{
+			"kv.dist_sender.rpcs": 42,
+			"sys.Alloc":           1.5,
+			"odd name:with|seps":  -1,
+		},
+	}
+}
+
+// TestPushFormats verifies the Graphite and StatsD output formats,
+// including prefixing and sanitizing of metric names.
+func TestPushFormats(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeGraphite(&buf, "cockroach.node1", testMetricSet()); err != nil {
+		t.Fatal(err)
+	}
+	expected := "cockroach.node1.kv.dist_sender.rpcs 42 1425600000\n" +
+		"cockroach.node1.odd_name_with_seps -1 1425600000\n" +
+		"cockroach.node1.sys.Alloc 1.5 1425600000\n"
+	if buf.String() != expected {
+		t.Errorf("expected graphite output:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := writeStatsD(&buf, "", testMetricSet()); err != nil {
+		t.Fatal(err)
+	}
+	expected = "kv.dist_sender.rpcs:42|g\n" +
+		"odd_name_with_seps:-1|g\n" +
+		"sys.Alloc:1.5|g\n"
+	if buf.String() != expected {
+		t.Errorf("expected statsd output:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+// TestNewPusherValidation verifies that unsupported protocols and
+// malformed addresses are rejected.
+func TestNewPusherValidation(t *testing.T) {
+	if _, err := NewPusher("collectd", "localhost:2003", ""); err == nil {
+		t.Error("expected error for unsupported protocol")
+	}
+	if _, err := NewPusher(Graphite, "localhost", ""); err == nil {
+		t.Error("expected error for address without port")
+	}
+	if _, err := NewPusher(StatsD, "localhost:8125", "cockroach."); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestPusherGraphite verifies that metrics pushed by a started Pusher
+// arrive at a Graphite endpoint over TCP.
+func TestPusherGraphite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	ms := NewMetricSystem(time.Millisecond, false)
+	p, err := NewPusher(Graphite, ln.Addr().String(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start(ms)
+	defer p.Stop()
+	ms.RegisterGaugeFunc("gauge", func() float64 { return 7 })
+	ms.Start()
+	defer ms.Stop()
+
+	select {
+	case line := <-lines:
+		if !bytes.HasPrefix([]byte(line), []byte("test.gauge 7 ")) {
+			t.Errorf("expected pushed gauge; got %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no metrics pushed within 5s")
+	}
+}
+
+// TestPusherStatsD verifies that a metric set pushed to a StatsD
+// endpoint arrives as gauges, one per datagram.
+func TestPusherStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	p, err := NewPusher(StatsD, conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.push(testMetricSet()); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := "kv.dist_sender.rpcs:42|g\n"; string(buf[:n]) != e {
+		t.Errorf("expected datagram %q; got %q", e, buf[:n])
+	}
+}
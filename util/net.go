@@ -0,0 +1,90 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package util
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// UnixAddrPrefix prefixes addresses which specify a unix domain
+// socket, e.g. "unix:/var/run/cockroach.sock".
+const UnixAddrPrefix = "unix:"
+
+// ParseAddr parses an address specified on the command line. Addresses
+// beginning with UnixAddrPrefix designate a unix domain socket at the
+// path which follows. All others are "host:port" TCP addresses; IPv6
+// literals must be enclosed in brackets, as in "[::1]:8080". If the
+// host is omitted (e.g. ":8080"), defaultHost is used in its place.
+func ParseAddr(addr, defaultHost string) (net.Addr, error) {
+	if strings.HasPrefix(addr, UnixAddrPrefix) {
+		path := strings.TrimPrefix(addr, UnixAddrPrefix)
+		if path == "" {
+			return nil, Errorf("unix address %q is missing a socket path", addr)
+		}
+		return MakeRawAddr("unix", path), nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, Errorf("unable to parse address %q: %v", addr, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, Errorf("invalid port in address %q", addr)
+	}
+	if host == "" {
+		host = defaultHost
+	}
+	return MakeRawAddr("tcp", net.JoinHostPort(host, port)), nil
+}
+
+// Listen announces on the supplied address. For a unix domain socket,
+// a socket file left behind by a process which exited without closing
+// its listener, e.g. after a crash, is removed first, as it would
+// otherwise prevent listening. A socket on which another process is
+// still listening is left in place.
+func Listen(addr net.Addr) (net.Listener, error) {
+	if addr.Network() == "unix" {
+		if err := removeStaleUnixSocket(addr.String()); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen(addr.Network(), addr.String())
+}
+
+// removeStaleUnixSocket removes the unix domain socket at path if no
+// process accepts connections on it. Files which aren't sockets are
+// left for net.Listen to report.
+func removeStaleUnixSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		return Errorf("unable to remove stale unix socket %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package util
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestParseAddr verifies parsing of TCP, IPv6 and unix addresses.
+func TestParseAddr(t *testing.T) {
+	testCases := []struct {
+		addr, expNetwork, expAddr string
+		expErr                    bool
+	}{
+		{"localhost:8080", "tcp", "localhost:8080", false},
+		{":8080", "tcp", "host:8080", false},
+		{"127.0.0.1:0", "tcp", "127.0.0.1:0", false},
+		{"[::1]:8080", "tcp", "[::1]:8080", false},
+		{"[fe80::1%eth0]:26257", "tcp", "[fe80::1%eth0]:26257", false},
+		{"unix:/tmp/cockroach.sock", "unix", "/tmp/cockroach.sock", false},
+		{"unix:", "", "", true},
+		{"::1:8080", "", "", true},
+		{"localhost", "", "", true},
+		{"localhost:http", "", "", true},
+		{"localhost:70000", "", "", true},
+	}
+	for i, test := range testCases {
+		addr, err := ParseAddr(test.addr, "host")
+		if test.expErr {
+			if err == nil {
+				t.Errorf("%d: expected error parsing %q; got %s", i, test.addr, addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error parsing %q: %v", i, test.addr, err)
+			continue
+		}
+		if addr.Network() != test.expNetwork || addr.String() != test.expAddr {
+			t.Errorf("%d: expected %s %s; got %s %s", i, test.expNetwork, test.expAddr, addr.Network(), addr)
+		}
+	}
+}
+
+// TestListenStaleUnixSocket verifies that listening on a unix domain
+// socket removes a socket file left behind by a previous listener,
+// but not one which is still in use.
+func TestListenStaleUnixSocket(t *testing.T) {
+	addr := CreateTestAddr("unix")
+	path := addr.String()
+	defer os.Remove(path)
+
+	// Bind a socket and close it without unlinking it, as a process
+	// which crashed would.
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrUnix{Name: path}); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(fd)
+
+	ln, err := Listen(addr)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced: %s", err)
+	}
+	defer ln.Close()
+	if _, err := Listen(addr); err == nil {
+		t.Error("expected listening on a socket in use to fail")
+	}
+}